@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountingLedgers holds the ledger (account) names used in exported journals
+type AccountingLedgers struct {
+	Clearing     string // Amounts collected by Cashfree but not yet settled
+	Sales        string
+	SalesReturns string
+	Bank         string
+	OutputCGST   string
+	OutputSGST   string
+	OutputIGST   string
+
+	// Platform fees kept from vendor splits, and what is owed to the vendors
+	CommissionIncome string
+	VendorPayables   string
+
+	// Gateway fees and the tax on them, deducted from settlements
+	GatewayCharges string
+}
+
+// DefaultAccountingLedgers returns the default ledger names
+func DefaultAccountingLedgers() AccountingLedgers {
+	return AccountingLedgers{
+		Clearing:     "Cashfree Clearing",
+		Sales:        "Sales",
+		SalesReturns: "Sales Returns",
+		Bank:         "Bank",
+		OutputCGST:   "Output CGST",
+		OutputSGST:   "Output SGST",
+		OutputIGST:   "Output IGST",
+
+		CommissionIncome: "Commission Income",
+		VendorPayables:   "Vendor Payables",
+
+		GatewayCharges: "Payment Gateway Charges",
+	}
+}
+
+// JournalLine is a single debit or credit in a journal entry
+type JournalLine struct {
+	Ledger string
+	Debit  float64
+	Credit float64
+}
+
+// JournalEntry is a balanced accounting journal entry
+type JournalEntry struct {
+	Date      time.Time
+	Kind      string // PAYMENT, REFUND, SETTLEMENT or FEE
+	Reference string
+	Narration string
+	Currency  string
+	Lines     []JournalLine
+}
+
+// BuildJournal converts payments, refunds, settlements and platform fees into journal entries
+// ordered by date. Payments with a tax rate have their GST credited to the output tax ledgers.
+func BuildJournal(payments []Payment, refunds []Refund, settlements []Settlement, fees []SplitFee, ledgers AccountingLedgers, merchantStateCode string) []JournalEntry {
+	var entries []JournalEntry
+
+	for i := range payments {
+		p := &payments[i]
+		date := p.CreatedAt
+		if p.PaymentTime != nil {
+			date = *p.PaymentTime
+		}
+
+		reference := p.OrderID
+		narration := fmt.Sprintf("Payment received for order %s from %s", p.OrderID, p.CustomerName)
+		if p.InvoiceNumber != nil {
+			reference = *p.InvoiceNumber
+			narration = fmt.Sprintf("Invoice %s: payment received for order %s from %s", *p.InvoiceNumber, p.OrderID, p.CustomerName)
+		}
+
+		lines := []JournalLine{{Ledger: ledgers.Clearing, Debit: p.Amount}}
+		if tax := paymentTax(p, merchantStateCode); tax != nil {
+			lines = append(lines, JournalLine{Ledger: ledgers.Sales, Credit: tax.TaxableValue})
+			for _, component := range []JournalLine{
+				{Ledger: ledgers.OutputCGST, Credit: tax.CGST},
+				{Ledger: ledgers.OutputSGST, Credit: tax.SGST},
+				{Ledger: ledgers.OutputIGST, Credit: tax.IGST},
+			} {
+				if component.Credit > 0 {
+					lines = append(lines, component)
+				}
+			}
+		} else {
+			lines = append(lines, JournalLine{Ledger: ledgers.Sales, Credit: p.Amount})
+		}
+
+		entries = append(entries, JournalEntry{
+			Date:      date,
+			Kind:      "PAYMENT",
+			Reference: reference,
+			Narration: narration,
+			Currency:  p.Currency,
+			Lines:     lines,
+		})
+	}
+
+	for _, r := range refunds {
+		date := r.CreatedAt
+		if r.ProcessedAt != nil {
+			date = *r.ProcessedAt
+		}
+		entries = append(entries, JournalEntry{
+			Date:      date,
+			Kind:      "REFUND",
+			Reference: r.RefundID,
+			Narration: fmt.Sprintf("Refund %s for order %s", r.RefundID, r.OrderID),
+			Currency:  "INR",
+			Lines: []JournalLine{
+				{Ledger: ledgers.SalesReturns, Debit: r.Amount},
+				{Ledger: ledgers.Clearing, Credit: r.Amount},
+			},
+		})
+	}
+
+	for _, s := range settlements {
+		narration := fmt.Sprintf("Settlement %s for order %s", s.SettlementID, s.OrderID)
+		if s.UTR != nil {
+			narration += " (UTR " + *s.UTR + ")"
+		}
+		lines := []JournalLine{{Ledger: ledgers.Bank, Debit: s.Amount}}
+		cleared := s.Amount
+		// The gateway's charges on the order were collected into clearing but kept back
+		// from the settlement
+		if b := s.Breakdown; b != nil && b.GatewayFee != nil {
+			if charges := *b.GatewayFee + *b.GatewayTax; charges > 0 {
+				lines = append(lines, JournalLine{Ledger: ledgers.GatewayCharges, Debit: charges})
+				cleared += charges
+			}
+		}
+		lines = append(lines, JournalLine{Ledger: ledgers.Clearing, Credit: cleared})
+
+		entries = append(entries, JournalEntry{
+			Date:      *s.SettledAt,
+			Kind:      "SETTLEMENT",
+			Reference: s.SettlementID,
+			Narration: narration,
+			Currency:  "INR",
+			Lines:     lines,
+		})
+	}
+
+	for _, f := range fees {
+		entries = append(entries, JournalEntry{
+			Date:      f.CreatedAt,
+			Kind:      "FEE",
+			Reference: f.OrderID,
+			Narration: fmt.Sprintf("%s fee on the split of order %s for vendor %s", feeTypeName(f.FeeType), f.OrderID, f.VendorID),
+			Currency:  f.Currency,
+			Lines: []JournalLine{
+				{Ledger: ledgers.VendorPayables, Debit: f.Amount},
+				{Ledger: ledgers.CommissionIncome, Credit: f.Amount},
+			},
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Date.Before(entries[j].Date)
+	})
+
+	return entries
+}
+
+// tallyEnvelope is the Tally XML import envelope
+type tallyEnvelope struct {
+	XMLName xml.Name `xml:"ENVELOPE"`
+	Header  struct {
+		TallyRequest string `xml:"TALLYREQUEST"`
+	} `xml:"HEADER"`
+	Body struct {
+		ImportData struct {
+			RequestDesc struct {
+				ReportName string `xml:"REPORTNAME"`
+			} `xml:"REQUESTDESC"`
+			RequestData struct {
+				Messages []tallyMessage `xml:"TALLYMESSAGE"`
+			} `xml:"REQUESTDATA"`
+		} `xml:"IMPORTDATA"`
+	} `xml:"BODY"`
+}
+
+type tallyMessage struct {
+	Voucher tallyVoucher `xml:"VOUCHER"`
+}
+
+type tallyVoucher struct {
+	VchType         string             `xml:"VCHTYPE,attr"`
+	Action          string             `xml:"ACTION,attr"`
+	Date            string             `xml:"DATE"`
+	VoucherTypeName string             `xml:"VOUCHERTYPENAME"`
+	VoucherNumber   string             `xml:"VOUCHERNUMBER"`
+	Reference       string             `xml:"REFERENCE"`
+	Narration       string             `xml:"NARRATION"`
+	LedgerEntries   []tallyLedgerEntry `xml:"ALLLEDGERENTRIES.LIST"`
+}
+
+type tallyLedgerEntry struct {
+	LedgerName       string `xml:"LEDGERNAME"`
+	IsDeemedPositive string `xml:"ISDEEMEDPOSITIVE"`
+	Amount           string `xml:"AMOUNT"`
+}
+
+// FormatTallyXML renders journal entries as Tally journal vouchers.
+// Tally represents debits as negative amounts with ISDEEMEDPOSITIVE set to Yes.
+func FormatTallyXML(entries []JournalEntry) ([]byte, error) {
+	var envelope tallyEnvelope
+	envelope.Header.TallyRequest = "Import Data"
+	envelope.Body.ImportData.RequestDesc.ReportName = "Vouchers"
+
+	for _, entry := range entries {
+		voucher := tallyVoucher{
+			VchType:         "Journal",
+			Action:          "Create",
+			Date:            entry.Date.Format("20060102"),
+			VoucherTypeName: "Journal",
+			VoucherNumber:   entry.Reference,
+			Reference:       entry.Kind,
+			Narration:       entry.Narration,
+		}
+
+		for _, line := range entry.Lines {
+			ledgerEntry := tallyLedgerEntry{LedgerName: line.Ledger}
+			if line.Debit > 0 {
+				ledgerEntry.IsDeemedPositive = "Yes"
+				ledgerEntry.Amount = formatAmount(-line.Debit)
+			} else {
+				ledgerEntry.IsDeemedPositive = "No"
+				ledgerEntry.Amount = formatAmount(line.Credit)
+			}
+			voucher.LedgerEntries = append(voucher.LedgerEntries, ledgerEntry)
+		}
+
+		envelope.Body.ImportData.RequestData.Messages = append(envelope.Body.ImportData.RequestData.Messages, tallyMessage{Voucher: voucher})
+	}
+
+	out, err := xml.MarshalIndent(envelope, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
+
+// FormatZohoBooksCSV renders journal entries in the Zoho Books manual journal import format
+func FormatZohoBooksCSV(entries []JournalEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{"Journal Date", "Reference Number", "Journal Type", "Notes", "Currency", "Account", "Debit", "Credit"}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		for _, line := range entry.Lines {
+			debit, credit := "", ""
+			if line.Debit > 0 {
+				debit = formatAmount(line.Debit)
+			} else {
+				credit = formatAmount(line.Credit)
+			}
+
+			record := []string{
+				entry.Date.Format("2006-01-02"),
+				entry.Reference,
+				entry.Kind,
+				entry.Narration,
+				entry.Currency,
+				line.Ledger,
+				debit,
+				credit,
+			}
+			if err := w.Write(record); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// parseDateRange parses the from/to query parameters (YYYY-MM-DD, to inclusive)
+// into a half-open [from, to) interval of days starting at midnight in loc
+func parseDateRange(c *gin.Context, loc *time.Location) (time.Time, time.Time, error) {
+	from, err := time.ParseInLocation("2006-01-02", c.Query("from"), loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must be a date in YYYY-MM-DD format")
+	}
+
+	to, err := time.ParseInLocation("2006-01-02", c.Query("to"), loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must be a date in YYYY-MM-DD format")
+	}
+
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to must not be before from")
+	}
+
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// ExportHandler serves accounting exports
+type ExportHandler struct {
+	repo      *PaymentRepository
+	merchants *MerchantRepository
+	ledgers   AccountingLedgers
+	invoices  InvoiceConfig
+
+	// location is the deployment's business day time zone
+	location *time.Location
+
+	// clock decides which day the report jobs upload
+	clock Clock
+
+	// verifyLatency is the payment handler's verify latency histogram the SLA stats
+	// report; nil reports no verify calls
+	verifyLatency *VerifyLatency
+}
+
+// now returns the handler's current time
+func (h *ExportHandler) now() time.Time {
+	return clockOrSystem(h.clock).Now()
+}
+
+// locationFor returns the time zone of the business day exports follow for the
+// context's merchant
+func (h *ExportHandler) locationFor(ctx context.Context) *time.Location {
+	return reportLocation(ctx, h.location)
+}
+
+// Exports an accounting journal for a date range
+func (h *ExportHandler) ExportAccounting(c *gin.Context) {
+	loc := h.locationFor(requestContext(c))
+	from, to, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.DefaultQuery("format", "tally")
+	if format != "tally" && format != "zoho" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be tally or zoho"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	entries, err := h.buildJournal(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to build accounting journal: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build accounting export"})
+		return
+	}
+
+	// Date vouchers by the merchant's business day
+	for i := range entries {
+		entries[i].Date = entries[i].Date.In(loc)
+	}
+
+	var body []byte
+	var contentType, extension string
+	if format == "tally" {
+		body, err = FormatTallyXML(entries)
+		contentType, extension = "application/xml", "xml"
+	} else {
+		body, err = FormatZohoBooksCSV(entries)
+		contentType, extension = "text/csv", "csv"
+	}
+
+	if err != nil {
+		log.Printf("Failed to format accounting export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build accounting export"})
+		return
+	}
+
+	filename := fmt.Sprintf("journal_%s_%s_%s.%s", format, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), extension)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, contentType, body)
+}
+
+func (h *ExportHandler) buildJournal(ctx context.Context, from, to time.Time) ([]JournalEntry, error) {
+	payments, err := h.repo.ListPaidPaymentsBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	refunds, err := h.repo.ListProcessedRefundsBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	settlements, err := h.repo.ListSettledSettlementsBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	fees, err := h.repo.ListSplitFeesBetween(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return BuildJournal(payments, refunds, settlements, fees, h.ledgers, h.invoices.MerchantStateCode), nil
+}
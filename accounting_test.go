@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleJournal() []JournalEntry {
+	paidAt := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	refundedAt := time.Date(2024, 4, 3, 10, 0, 0, 0, time.UTC)
+	settledAt := time.Date(2024, 4, 4, 10, 0, 0, 0, time.UTC)
+	utr := "UTR123"
+
+	payments := []Payment{{OrderID: "order_1", Amount: 1000, Currency: "INR", CustomerName: "John Doe", PaymentTime: &paidAt}}
+	refunds := []Refund{{RefundID: "refund_1", OrderID: "order_1", Amount: 200, ProcessedAt: &refundedAt}}
+	settlements := []Settlement{{SettlementID: "settle_1", OrderID: "order_1", Amount: 800, UTR: &utr, SettledAt: &settledAt}}
+
+	return BuildJournal(payments, refunds, settlements, nil, DefaultAccountingLedgers(), "29")
+}
+
+func TestBuildJournalIsBalanced(t *testing.T) {
+	entries := sampleJournal()
+
+	assert.Len(t, entries, 3)
+	assert.Equal(t, []string{"PAYMENT", "REFUND", "SETTLEMENT"}, []string{entries[0].Kind, entries[1].Kind, entries[2].Kind})
+
+	for _, entry := range entries {
+		var debits, credits float64
+		for _, line := range entry.Lines {
+			debits += line.Debit
+			credits += line.Credit
+		}
+		assert.Equal(t, debits, credits, entry.Reference)
+	}
+
+	assert.Contains(t, entries[2].Narration, "UTR123")
+}
+
+func TestBuildJournalPlatformFees(t *testing.T) {
+	rate := 5.0
+	fees := []SplitFee{{
+		OrderID: "order_1", VendorID: "vendor_a", FeeType: FeeTypeCommission, Rate: &rate,
+		Amount: 40, Currency: "INR", CreatedAt: time.Date(2024, 4, 2, 11, 0, 0, 0, time.UTC),
+	}}
+
+	entries := BuildJournal(nil, nil, nil, fees, DefaultAccountingLedgers(), "29")
+	require.Len(t, entries, 1)
+	assert.Equal(t, "FEE", entries[0].Kind)
+	assert.Equal(t, "Commission fee on the split of order order_1 for vendor vendor_a", entries[0].Narration)
+	assert.Equal(t, []JournalLine{
+		{Ledger: "Vendor Payables", Debit: 40},
+		{Ledger: "Commission Income", Credit: 40},
+	}, entries[0].Lines)
+}
+
+func TestFormatTallyXML(t *testing.T) {
+	out, err := FormatTallyXML(sampleJournal())
+	assert.NoError(t, err)
+
+	var envelope tallyEnvelope
+	assert.NoError(t, xml.Unmarshal(out, &envelope))
+	assert.Equal(t, "Import Data", envelope.Header.TallyRequest)
+
+	messages := envelope.Body.ImportData.RequestData.Messages
+	assert.Len(t, messages, 3)
+
+	payment := messages[0].Voucher
+	assert.Equal(t, "20240402", payment.Date)
+	assert.Equal(t, "order_1", payment.VoucherNumber)
+	assert.Equal(t, "Cashfree Clearing", payment.LedgerEntries[0].LedgerName)
+	assert.Equal(t, "Yes", payment.LedgerEntries[0].IsDeemedPositive)
+	assert.Equal(t, "-1000.00", payment.LedgerEntries[0].Amount)
+	assert.Equal(t, "1000.00", payment.LedgerEntries[1].Amount)
+}
+
+func TestFormatZohoBooksCSV(t *testing.T) {
+	out, err := FormatZohoBooksCSV(sampleJournal())
+	assert.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	assert.Len(t, lines, 7)
+	assert.Equal(t, "Journal Date,Reference Number,Journal Type,Notes,Currency,Account,Debit,Credit", lines[0])
+	assert.Equal(t, "2024-04-03,refund_1,REFUND,Refund refund_1 for order order_1,INR,Sales Returns,200.00,", lines[3])
+}
+
+func TestBuildJournalSplitsGST(t *testing.T) {
+	paidAt := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	rate := 18.0
+	placeOfSupply := "27"
+	invoice := "INV/2024-25/000001"
+
+	payments := []Payment{{
+		OrderID: "order_1", Amount: 1180, Currency: "INR", PaymentTime: &paidAt,
+		TaxRate: &rate, PlaceOfSupply: &placeOfSupply, InvoiceNumber: &invoice,
+	}}
+
+	entries := BuildJournal(payments, nil, nil, nil, DefaultAccountingLedgers(), "29")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, invoice, entries[0].Reference)
+	assert.Equal(t, []JournalLine{
+		{Ledger: "Cashfree Clearing", Debit: 1180},
+		{Ledger: "Sales", Credit: 1000},
+		{Ledger: "Output IGST", Credit: 180},
+	}, entries[0].Lines)
+}
+
+func TestBuildJournalSettlementGatewayCharges(t *testing.T) {
+	settledAt := time.Date(2024, 4, 4, 10, 0, 0, 0, time.UTC)
+	fee, tax := 2.0, 0.36
+	settlements := []Settlement{{
+		SettlementID: "settle_1", OrderID: "order_1", Amount: 97.64, SettledAt: &settledAt,
+		Breakdown: &SettlementBreakdown{Currency: "INR", GrossAmount: 100, GatewayFee: &fee, GatewayTax: &tax},
+	}}
+
+	entries := BuildJournal(nil, nil, settlements, nil, DefaultAccountingLedgers(), "29")
+	require.Len(t, entries, 1)
+	assert.Equal(t, []JournalLine{
+		{Ledger: "Bank", Debit: 97.64},
+		{Ledger: "Payment Gateway Charges", Debit: 2.36},
+		{Ledger: "Cashfree Clearing", Credit: 100},
+	}, entries[0].Lines)
+}
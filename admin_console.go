@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/domain"
+)
+
+//go:embed adminui
+var adminUIFiles embed.FS
+
+var errWebhookNotFound = errors.New("webhook not found")
+
+// TimelineEvent is one step in the history of an order
+type TimelineEvent struct {
+	At        time.Time  `json:"at"`
+	Type      string     `json:"type"` // e.g. order.created, webhook, refund.created
+	Summary   string     `json:"summary"`
+	WebhookID *uuid.UUID `json:"webhook_id,omitempty"` // for webhook events, which can be replayed
+}
+
+// SearchPayments finds payments across every merchant, newest first. query matches an
+// order ID prefix or exactly a Cashfree payment ID, customer ID, email or phone; status,
+// when set, must match too.
+func (r *PaymentRepository) SearchPayments(ctx context.Context, query, status string, limit, offset int) ([]Payment, error) {
+	sql := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE ($1 = '' OR starts_with(order_id, $1) OR cf_payment_id = $1 OR customer_id = $1
+		       OR LOWER(customer_email) = LOWER($1) OR customer_phone = $1)
+		  AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := r.db.Query(ctx, sql, query, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// GetOrderTimeline returns what happened to an order of the merchant tenantID, or of no
+// merchant for nil, oldest first: its creation, status changes and payment, the webhooks
+// received for it, and its refunds and their approvals
+func (r *PaymentRepository) GetOrderTimeline(ctx context.Context, orderID string, tenantID *uuid.UUID) ([]TimelineEvent, error) {
+	query := `
+		SELECT created_at, 'order.created', amount || ' ' || currency || ' ' || status, NULL::uuid
+		FROM payments WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		UNION ALL
+		SELECT payment_time, 'order.paid', COALESCE(payment_method, ''), NULL
+		FROM payments WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2 AND payment_time IS NOT NULL
+		UNION ALL
+		SELECT occurred_at, 'order.status_changed', (data->>'from') || ' -> ' || (data->>'to'), NULL
+		FROM events WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2 AND type = 'order.status_changed'
+		UNION ALL
+		SELECT created_at, 'webhook', event_type || ' (' || status || ')', id
+		FROM webhooks WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		UNION ALL
+		SELECT created_at, 'refund.created', refund_id || ' ' || amount || ' ' || status, NULL
+		FROM refunds WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		UNION ALL
+		SELECT processed_at, 'refund.processed', refund_id, NULL
+		FROM refunds WHERE order_id = $1 AND tenant_id IS NOT DISTINCT FROM $2 AND processed_at IS NOT NULL
+		UNION ALL
+		SELECT a.created_at, 'refund.' || LOWER(a.action), a.refund_id || ' by ' || a.actor, NULL
+		FROM refund_audit_log a JOIN refunds f ON f.refund_id = a.refund_id
+		WHERE f.order_id = $1 AND f.tenant_id IS NOT DISTINCT FROM $2
+		ORDER BY 1
+	`
+
+	rows, err := r.db.Query(ctx, query, orderID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TimelineEvent{}
+	for rows.Next() {
+		var event TimelineEvent
+		if err := rows.Scan(&event.At, &event.Type, &event.Summary, &event.WebhookID); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// GetWebhookLog retrieves a webhook log entry of any merchant
+func (r *PaymentRepository) GetWebhookLog(ctx context.Context, id uuid.UUID) (*Webhook, error) {
+	query := `
+		SELECT id, event_type, order_id, payload, status, created_at, tenant_id
+		FROM webhooks
+		WHERE id = $1
+	`
+
+	var webhook Webhook
+	err := r.db.QueryRow(ctx, query, id).Scan(
+		&webhook.ID, &webhook.EventType, &webhook.OrderID, &webhook.Payload,
+		&webhook.Status, &webhook.CreatedAt, &webhook.TenantID,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// AdminConsoleHandler serves the embedded admin UI and the cross-merchant operations
+// behind it
+type AdminConsoleHandler struct {
+	repo      *PaymentRepository
+	payments  *PaymentHandler
+	merchants *MerchantRepository // nil in single-merchant mode
+}
+
+// ServeUI serves the admin UI's embedded files
+func (h *AdminConsoleHandler) ServeUI(c *gin.Context) {
+	files, _ := fs.Sub(adminUIFiles, "adminui")
+	path := strings.TrimPrefix(c.Param("filepath"), "/")
+	if path == "" {
+		path = "index.html"
+	}
+	data, err := fs.ReadFile(files, path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.Data(http.StatusOK, mime.TypeByExtension(filepath.Ext(path)), data)
+}
+
+// SearchPayments finds payments of every merchant by order ID, Cashfree payment ID or
+// customer
+func (h *AdminConsoleHandler) SearchPayments(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 25
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	query := strings.TrimSpace(c.Query("q"))
+	status := strings.ToUpper(c.Query("status"))
+	payments, err := h.repo.SearchPayments(ctx, query, status, limit, offset)
+	if err != nil {
+		log.Printf("Failed to search payments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payments": payments,
+		"limit":    limit,
+		"offset":   offset,
+		"count":    len(payments),
+	})
+}
+
+// GetOrderTimeline returns an order with the history of its webhooks and refunds
+func (h *AdminConsoleHandler) GetOrderTimeline(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	payment, ok := h.orderPayment(ctx, c)
+	if !ok {
+		return
+	}
+
+	events, err := h.repo.GetOrderTimeline(ctx, orderID, payment.TenantID)
+	if err != nil {
+		log.Printf("Failed to get timeline of order %s: %v", orderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment": payment, "timeline": events})
+}
+
+// ReplayWebhook applies a logged webhook again, within the scope of the merchant it was
+// received for
+func (h *AdminConsoleHandler) ReplayWebhook(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	webhook, err := h.repo.GetWebhookLog(ctx, id)
+	if errors.Is(err, errWebhookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get webhook %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve webhook"})
+		return
+	}
+
+	var webhookData WebhookData
+	if err := json.Unmarshal([]byte(webhook.Payload), &webhookData); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Webhook payload cannot be parsed"})
+		return
+	}
+
+	ctx, err = h.merchantContext(ctx, webhook.TenantID)
+	if err != nil {
+		log.Printf("Failed to load merchant of webhook %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load merchant"})
+		return
+	}
+
+	status := domain.WebhookReplayed
+	replayErr := h.payments.dispatchWebhook(ctx, webhookData)
+	if replayErr != nil {
+		log.Printf("Replaying webhook %s failed: %v", id, replayErr)
+		status = domain.WebhookFailed
+	}
+	if err := h.repo.UpdateWebhookStatus(ctx, id, status); err != nil {
+		log.Printf("Failed to mark webhook %s %s: %v", id, status, err)
+	}
+
+	if replayErr != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Webhook replay failed: " + replayErr.Error(), "webhook_id": id, "status": status})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhook_id": id, "status": status})
+}
+
+// ScopeToOrderMerchant runs the rest of the chain as the merchant owning the order in
+// the :order_id path parameter, so merchant-scoped payment handlers can serve admins
+func (h *AdminConsoleHandler) ScopeToOrderMerchant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.merchants == nil {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		payment, ok := h.orderPayment(ctx, c)
+		if !ok {
+			c.Abort()
+			return
+		}
+		if payment.TenantID != nil {
+			merchant, err := h.merchants.GetMerchantByID(ctx, *payment.TenantID)
+			if err != nil {
+				log.Printf("Failed to load merchant %s: %v", *payment.TenantID, err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load merchant"})
+				return
+			}
+			c.Set(merchantGinKey, merchant)
+		}
+		c.Next()
+	}
+}
+
+// orderPayment returns the payment of the :order_id path parameter, writing the error
+// response on failure. Order IDs are unique per merchant, so an order ID more than one
+// merchant used needs the merchant_id query parameter.
+func (h *AdminConsoleHandler) orderPayment(ctx context.Context, c *gin.Context) (*Payment, bool) {
+	if merchantID := c.Query("merchant_id"); merchantID != "" {
+		tenantID, err := uuid.Parse(merchantID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant_id"})
+			return nil, false
+		}
+		ctx = WithTenantID(ctx, &tenantID)
+	}
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, c.Param("order_id"))
+	if errors.Is(err, ErrAmbiguousOrder) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order ID is used by more than one merchant; pass merchant_id"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return nil, false
+	}
+	return payment, true
+}
+
+// merchantContext scopes ctx to a merchant, or leaves it unscoped for nil
+func (h *AdminConsoleHandler) merchantContext(ctx context.Context, tenantID *uuid.UUID) (context.Context, error) {
+	if tenantID == nil || h.merchants == nil {
+		return ctx, nil
+	}
+	merchant, err := h.merchants.GetMerchantByID(ctx, *tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return WithMerchant(ctx, merchant), nil
+}
+
+// registerAdminConsoleRoutes registers the admin UI and its API on an admin-authenticated
+// group
+func registerAdminConsoleRoutes(group *gin.RouterGroup, console *AdminConsoleHandler) {
+	group.GET("/ui/*filepath", console.ServeUI)
+	group.GET("/payments", console.SearchPayments)
+	group.GET("/payments/:order_id/timeline", console.GetOrderTimeline)
+	group.POST("/payments/:order_id/refund", console.ScopeToOrderMerchant(), console.payments.RefundPayment)
+	group.POST("/webhooks/:id/replay", console.ReplayWebhook)
+}
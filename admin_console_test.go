@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestAdminConsoleServesUI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAdminConsoleRoutes(r.Group("/admin"), &AdminConsoleHandler{payments: &PaymentHandler{}})
+
+	for path, contentType := range map[string]string{
+		"/admin/ui/":       "text/html",
+		"/admin/ui/app.js": "javascript",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Contains(t, w.Header().Get("Content-Type"), contentType, path)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/ui/missing.js", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminConsole(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerAdminConsoleRoutes(r.Group("/admin"), &AdminConsoleHandler{repo: handler.repo, payments: handler})
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	ctx := context.Background()
+	orderID := fmt.Sprintf("order_console_%d", time.Now().UnixNano())
+	payment := testPayment(orderID)
+	payment.Amount = 499.5
+	require.NoError(t, handler.repo.CreatePayment(ctx, payment))
+	_, err := client.CreateOrder(testOrderRequest(orderID))
+	require.NoError(t, err)
+
+	w := serve(http.MethodGet, "/admin/payments?q="+orderID, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"count":1`)
+	w = serve(http.MethodGet, "/admin/payments?q="+orderID+"&status=paid", "")
+	assert.Contains(t, w.Body.String(), `"count":0`)
+
+	// The success webhook failed when it arrived and is replayed
+	delivered, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	webhook := &Webhook{EventType: "PAYMENT_SUCCESS_WEBHOOK", OrderID: &orderID, Payload: string(delivered.Body), Status: domain.WebhookFailed}
+	require.NoError(t, handler.repo.CreateWebhookLog(ctx, webhook))
+
+	w = serve(http.MethodPost, "/admin/webhooks/"+webhook.ID.String()+"/replay", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	replayed, err := handler.repo.GetWebhookLog(ctx, webhook.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.WebhookReplayed, replayed.Status)
+
+	w = serve(http.MethodPost, "/admin/payments/"+orderID+"/refund", `{"amount": 100, "refund_reference": "console-1"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(http.MethodGet, "/admin/payments/"+orderID+"/timeline", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Timeline []TimelineEvent `json:"timeline"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	var types []string
+	for _, event := range body.Timeline {
+		types = append(types, event.Type)
+	}
+	assert.Contains(t, types, "order.created")
+	assert.Contains(t, types, "order.status_changed")
+	assert.Contains(t, types, "webhook")
+	assert.Contains(t, types, "refund.created")
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/admin/payments/order_missing/timeline", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/webhooks/not-a-uuid/replay", "").Code)
+}
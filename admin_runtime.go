@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RuntimeStats describes the running process, for diagnosing a production instance
+type RuntimeStats struct {
+	StartedAt     time.Time          `json:"started_at"`
+	UptimeSeconds float64            `json:"uptime_seconds"`
+	GoVersion     string             `json:"go_version"`
+	GOMAXPROCS    int                `json:"gomaxprocs"`
+	Goroutines    int                `json:"goroutines"`
+	Memory        MemoryStats        `json:"memory"`
+	GC            GCStats            `json:"gc"`
+	DBPool        *DBPoolStats       `json:"db_pool,omitempty"`
+	Jobs          map[string]*JobRun `json:"jobs"`       // latest run by job name, null until a job has run
+	JobLeader     bool               `json:"job_leader"` // whether this instance runs the jobs
+	GeneratedAt   time.Time          `json:"generated_at"`
+}
+
+// MemoryStats describes the process's heap
+type MemoryStats struct {
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	SysBytes       uint64 `json:"sys_bytes"` // obtained from the OS
+}
+
+// GCStats describes garbage collection since the process started
+type GCStats struct {
+	NumGC         int64      `json:"num_gc"`
+	PauseTotalMS  float64    `json:"pause_total_ms"`
+	LastPauseMS   float64    `json:"last_pause_ms"`
+	LastGC        *time.Time `json:"last_gc"`
+	GCCPUFraction float64    `json:"gc_cpu_fraction"`
+}
+
+// runtimeStats reads the Go runtime's goroutine, memory and GC statistics
+func runtimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	stats := RuntimeStats{
+		GoVersion:  runtime.Version(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: MemoryStats{
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			HeapObjects:    mem.HeapObjects,
+			SysBytes:       mem.Sys,
+		},
+		GC: GCStats{
+			NumGC:         gc.NumGC,
+			PauseTotalMS:  float64(gc.PauseTotal) / float64(time.Millisecond),
+			GCCPUFraction: mem.GCCPUFraction,
+		},
+	}
+	if gc.NumGC > 0 {
+		stats.GC.LastGC = &gc.LastGC
+		stats.GC.LastPauseMS = float64(gc.Pause[0]) / float64(time.Millisecond)
+	}
+	return stats
+}
+
+// GetRuntime returns the process's runtime, connection pool and background job stats
+func (h *AdminStatsHandler) GetRuntime(c *gin.Context) {
+	now := clockOrSystem(h.clock).Now().UTC()
+
+	stats := runtimeStats()
+	stats.StartedAt = h.startedAt.UTC()
+	stats.UptimeSeconds = now.Sub(h.startedAt).Seconds()
+	stats.DBPool = dbPoolStats(h.pool)
+	stats.Jobs = make(map[string]*JobRun)
+	if h.scheduler != nil {
+		stats.JobLeader = h.scheduler.Leading()
+		runs := h.scheduler.LastRuns()
+		for _, name := range h.scheduler.Jobs() {
+			if run, ok := runs[name]; ok {
+				stats.Jobs[name] = &run
+			} else {
+				stats.Jobs[name] = nil
+			}
+		}
+	}
+	stats.GeneratedAt = now
+
+	c.JSON(http.StatusOK, stats)
+}
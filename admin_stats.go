@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/domain"
+	"payment-getway/queue"
+)
+
+// OperationalStats are live counters across every merchant, for dashboards and
+// smoke checks after a deploy
+type OperationalStats struct {
+	OrdersByStatus         map[string]int     `json:"orders_by_status"`
+	PendingReconciliations int                `json:"pending_reconciliations"` // recon runs still running
+	FailedWebhooks         int                `json:"failed_webhooks"`
+	CircuitBreakers        map[string]string  `json:"circuit_breakers"` // state by Cashfree environment
+	OutboxBacklog          *int               `json:"outbox_backlog"`   // tasks awaiting a worker, when the queue can tell
+	DBPool                 *DBPoolStats       `json:"db_pool,omitempty"`
+	StatusPoller           *StatusPollerStats `json:"status_poller,omitempty"` // when STATUS_POLLER_ENABLED
+	GeneratedAt            time.Time          `json:"generated_at"`
+}
+
+// DBPoolStats describes database connection pool utilization
+type DBPoolStats struct {
+	AcquiredConns int32   `json:"acquired_conns"`
+	IdleConns     int32   `json:"idle_conns"`
+	TotalConns    int32   `json:"total_conns"`
+	MaxConns      int32   `json:"max_conns"`
+	Utilization   float64 `json:"utilization"` // acquired connections as a fraction of the maximum
+
+	AcquireCount         int64   `json:"acquire_count"`
+	EmptyAcquireCount    int64   `json:"empty_acquire_count"` // acquires that waited for a connection
+	CanceledAcquireCount int64   `json:"canceled_acquire_count"`
+	AcquireWaitMS        float64 `json:"acquire_wait_ms"` // total time spent acquiring connections
+}
+
+// backlogReporter is a task queue that can count the tasks waiting for a worker
+type backlogReporter interface {
+	Backlog() int
+}
+
+// dbPoolStats summarizes a connection pool, or returns nil without one
+func dbPoolStats(pool *pgxpool.Pool) *DBPoolStats {
+	if pool == nil {
+		return nil
+	}
+
+	stat := pool.Stat()
+	stats := &DBPoolStats{
+		AcquiredConns: stat.AcquiredConns(),
+		IdleConns:     stat.IdleConns(),
+		TotalConns:    stat.TotalConns(),
+		MaxConns:      stat.MaxConns(),
+
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireWaitMS:        float64(stat.AcquireDuration()) / float64(time.Millisecond),
+	}
+	if stats.MaxConns > 0 {
+		stats.Utilization = float64(stats.AcquiredConns) / float64(stats.MaxConns)
+	}
+	return stats
+}
+
+// GetOperationalStats counts orders by status, running reconciliations and failed
+// webhooks across every merchant
+func (r *PaymentRepository) GetOperationalStats(ctx context.Context) (*OperationalStats, error) {
+	stats := &OperationalStats{OrdersByStatus: make(map[string]int)}
+
+	rows, err := r.db.Query(ctx, `SELECT status, COUNT(*) FROM payments GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats.OrdersByStatus[status] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT (SELECT COUNT(*) FROM recon_runs WHERE status = $1),
+		       (SELECT COUNT(*) FROM webhooks WHERE status = $2)
+	`
+	err = r.db.QueryRow(ctx, query, ReconRunning, domain.WebhookFailed).Scan(&stats.PendingReconciliations, &stats.FailedWebhooks)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// UpdateWebhookStatus sets the status of a webhook log entry
+func (r *PaymentRepository) UpdateWebhookStatus(ctx context.Context, id uuid.UUID, status domain.WebhookStatus) error {
+	_, err := r.db.Exec(ctx, `UPDATE webhooks SET status = $2 WHERE id = $1`, id, status)
+	return err
+}
+
+// AdminStatsHandler serves operational counters to administrators
+type AdminStatsHandler struct {
+	repo      *PaymentRepository
+	pool      *pgxpool.Pool
+	clients   map[string]*CashfreeClient // by environment
+	tasks     queue.Queue
+	scheduler *Scheduler
+	poller    *StatusPoller // nil when the status poller is disabled
+	startedAt time.Time
+	clock     Clock
+}
+
+// GetStats returns live operational counters
+func (h *AdminStatsHandler) GetStats(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	stats, err := h.repo.GetOperationalStats(ctx)
+	if err != nil {
+		log.Printf("Failed to get operational stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve stats"})
+		return
+	}
+
+	stats.CircuitBreakers = make(map[string]string, len(h.clients))
+	for env, client := range h.clients {
+		if client.Breaker != nil {
+			stats.CircuitBreakers[env] = client.Breaker.State()
+		}
+	}
+	if reporter, ok := h.tasks.(backlogReporter); ok {
+		backlog := reporter.Backlog()
+		stats.OutboxBacklog = &backlog
+	}
+	stats.DBPool = dbPoolStats(h.pool)
+	if h.poller != nil {
+		stats.StatusPoller, err = h.poller.Stats(ctx)
+		if err != nil {
+			log.Printf("Failed to get status poller stats: %v", err)
+		}
+	}
+	stats.GeneratedAt = clockOrSystem(h.clock).Now().UTC()
+
+	c.JSON(http.StatusOK, stats)
+}
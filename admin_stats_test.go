@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+	"payment-getway/queue"
+)
+
+func TestAdminStats(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("order_stats_%d", time.Now().UnixNano())
+	for i, status := range []domain.PaymentStatus{domain.PaymentActive, domain.PaymentActive, domain.PaymentPaid} {
+		payment := testPayment(fmt.Sprintf("%s_%d", prefix, i))
+		payment.Status = status
+		require.NoError(t, repo.CreatePayment(ctx, payment))
+	}
+	orderID := prefix + "_0"
+	webhook := &Webhook{EventType: "PAYMENT_SUCCESS_WEBHOOK", OrderID: &orderID, Payload: `{}`, Status: domain.WebhookReceived}
+	require.NoError(t, repo.CreateWebhookLog(ctx, webhook))
+	require.NoError(t, repo.UpdateWebhookStatus(ctx, webhook.ID, domain.WebhookFailed))
+
+	client := NewCashfreeClient("id", "secret", EnvironmentTest)
+	for i := 0; i < 5; i++ {
+		client.Breaker.RecordFailure()
+	}
+	tasks := queue.NewWorkerPool(1, 10)
+	task, _ := queue.NewTask(queue.TaskVerifyPayment, "order_1")
+	require.NoError(t, tasks.Enqueue(ctx, task))
+
+	handler := &AdminStatsHandler{
+		repo:    repo,
+		pool:    db,
+		clients: map[string]*CashfreeClient{EnvironmentTest: client},
+		tasks:   tasks,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/admin/stats", handler.GetStats)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/stats", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var stats OperationalStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, map[string]int{"ACTIVE": 2, "PAID": 1}, stats.OrdersByStatus)
+	assert.Equal(t, 1, stats.FailedWebhooks)
+	assert.Equal(t, 0, stats.PendingReconciliations)
+	assert.Equal(t, map[string]string{EnvironmentTest: CircuitOpen}, stats.CircuitBreakers)
+	require.NotNil(t, stats.OutboxBacklog)
+	assert.Equal(t, 1, *stats.OutboxBacklog)
+	require.NotNil(t, stats.DBPool)
+	assert.Positive(t, stats.DBPool.MaxConns)
+}
+
+func TestAdminRuntime(t *testing.T) {
+	started := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	clock := newTestClock(started.Add(time.Hour))
+
+	scheduler := NewScheduler(clock)
+	for _, name := range []string{"ran", "pending"} {
+		scheduler.Register(Job{Name: name, Schedule: Every(time.Hour), Run: func(ctx context.Context) error { return nil }})
+	}
+	scheduler.run(context.Background(), scheduler.jobs[0])
+
+	handler := &AdminStatsHandler{scheduler: scheduler, startedAt: started, clock: clock}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/v1/admin/runtime", handler.GetRuntime)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/admin/runtime", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var stats RuntimeStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 3600.0, stats.UptimeSeconds)
+	assert.Positive(t, stats.Goroutines)
+	assert.Positive(t, stats.Memory.HeapAllocBytes)
+	assert.Nil(t, stats.DBPool)
+	assert.True(t, stats.JobLeader)
+	require.Contains(t, stats.Jobs, "ran")
+	require.NotNil(t, stats.Jobs["ran"])
+	assert.Equal(t, started.Add(time.Hour), stats.Jobs["ran"].StartedAt)
+	require.Contains(t, stats.Jobs, "pending")
+	assert.Nil(t, stats.Jobs["pending"])
+}
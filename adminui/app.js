@@ -0,0 +1,161 @@
+// Admin UI for the payment gateway. It is served under /admin/ui/ and calls the admin
+// API relative to that path; the browser sends the admin credentials it was asked for.
+(function () {
+  'use strict';
+
+  var api = '../';
+  var currentOrder = null;
+  var currentMerchant = null; // merchants may use the same order IDs
+
+  var operator = document.getElementById('operator');
+  operator.value = localStorage.getItem('operator') || '';
+  operator.addEventListener('change', function () {
+    localStorage.setItem('operator', operator.value.trim());
+  });
+
+  function request(method, path, body) {
+    var headers = { 'Accept': 'application/json' };
+    if (operator.value.trim()) {
+      headers['X-Actor'] = operator.value.trim();
+      headers['X-Admin-Actor'] = operator.value.trim();
+    }
+    if (body) {
+      headers['Content-Type'] = 'application/json';
+    }
+    return fetch(api + path, {
+      method: method,
+      headers: headers,
+      credentials: 'same-origin',
+      body: body ? JSON.stringify(body) : undefined
+    }).then(function (res) {
+      return res.json().catch(function () { return {}; }).then(function (data) {
+        if (!res.ok) {
+          throw new Error(data.error || res.status + ' ' + res.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function show(id, text, isError) {
+    var el = document.getElementById(id);
+    el.textContent = text || '';
+    el.className = 'message' + (isError ? ' error' : '');
+  }
+
+  function cell(row, text) {
+    var td = document.createElement('td');
+    td.textContent = text == null ? '' : text;
+    row.appendChild(td);
+  }
+
+  function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : '';
+  }
+
+  function search(event) {
+    if (event) {
+      event.preventDefault();
+    }
+    var form = document.getElementById('search');
+    var params = new URLSearchParams({ q: form.q.value.trim(), status: form.status.value });
+    show('search-message', 'Searching…');
+    request('GET', 'payments?' + params).then(function (data) {
+      var tbody = document.getElementById('payments');
+      tbody.textContent = '';
+      data.payments.forEach(function (payment) {
+        var row = document.createElement('tr');
+        cell(row, payment.order_id);
+        cell(row, payment.amount + ' ' + payment.currency);
+        cell(row, payment.status);
+        cell(row, payment.customer_email || payment.customer_id);
+        cell(row, formatTime(payment.created_at));
+        row.addEventListener('click', function () { openOrder(payment.order_id, payment.tenant_id); });
+        tbody.appendChild(row);
+      });
+      show('search-message', data.count ? '' : 'No payments found');
+    }).catch(function (err) {
+      show('search-message', err.message, true);
+    });
+  }
+
+  // orderPath returns the API path of the open order's action
+  function orderPath(action) {
+    var path = 'payments/' + encodeURIComponent(currentOrder) + '/' + action;
+    return currentMerchant ? path + '?merchant_id=' + encodeURIComponent(currentMerchant) : path;
+  }
+
+  function openOrder(orderID, merchantID) {
+    currentOrder = orderID;
+    currentMerchant = merchantID || null;
+    show('order-message', '');
+    request('GET', orderPath('timeline')).then(function (data) {
+      var payment = data.payment;
+      document.getElementById('order').className = '';
+      document.getElementById('order-title').textContent =
+        payment.order_id + ' · ' + payment.amount + ' ' + payment.currency + ' · ' + payment.status;
+
+      var list = document.getElementById('timeline');
+      list.textContent = '';
+      data.timeline.forEach(function (event) {
+        var item = document.createElement('li');
+        var time = document.createElement('time');
+        time.textContent = formatTime(event.at);
+        var type = document.createElement('span');
+        type.className = 'type';
+        type.textContent = event.type;
+        var summary = document.createElement('span');
+        summary.textContent = event.summary;
+        item.append(time, type, summary);
+        if (event.webhook_id) {
+          var replay = document.createElement('button');
+          replay.textContent = 'Replay';
+          replay.addEventListener('click', function () { replayWebhook(event.webhook_id); });
+          item.appendChild(replay);
+        }
+        list.appendChild(item);
+      });
+    }).catch(function (err) {
+      show('order-message', err.message, true);
+    });
+  }
+
+  function replayWebhook(id) {
+    if (!confirm('Apply this webhook again?')) {
+      return;
+    }
+    request('POST', 'webhooks/' + id + '/replay').then(function (data) {
+      openOrder(currentOrder, currentMerchant);
+      show('order-message', 'Webhook ' + data.status.toLowerCase());
+    }).catch(function (err) {
+      openOrder(currentOrder, currentMerchant);
+      show('order-message', err.message, true);
+    });
+  }
+
+  function refund(event) {
+    event.preventDefault();
+    var form = document.getElementById('refund');
+    var body = { amount: parseFloat(form.amount.value) };
+    if (form.refund_reference.value.trim()) {
+      body.refund_reference = form.refund_reference.value.trim();
+    }
+    if (form.reason.value.trim()) {
+      body.reason = form.reason.value.trim();
+    }
+    if (!confirm('Refund ' + body.amount + ' on ' + currentOrder + '?')) {
+      return;
+    }
+    request('POST', orderPath('refund'), body).then(function (data) {
+      form.reset();
+      openOrder(currentOrder, currentMerchant);
+      show('order-message', 'Refund ' + (data.refund_id || '') + ' ' + (data.status || 'requested'));
+    }).catch(function (err) {
+      show('order-message', err.message, true);
+    });
+  }
+
+  document.getElementById('search').addEventListener('submit', search);
+  document.getElementById('refund').addEventListener('submit', refund);
+  search();
+})();
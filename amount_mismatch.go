@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// paidStatus returns the status to store for an order Cashfree reports paid with the
+// paid amount: status itself, or PaymentAmountMismatch when the amount differs from
+// the one quoted at session creation, kept as the payment's amount. The payment is then
+// held back from fulfillment; a new mismatch raises an alert.
+//
+// A paid amount of 0 means the gateway did not report it, and is not checked.
+func (h *PaymentHandler) paidStatus(ctx context.Context, orderID, cfPaymentID string, paid float64, status domain.PaymentStatus) domain.PaymentStatus {
+	if paid <= 0 {
+		return status
+	}
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to load payment %s to check its paid amount: %v", orderID, err)
+		return status
+	}
+	if toMinorUnits(paid, payment.Currency) == toMinorUnits(payment.Amount, payment.Currency) {
+		return status
+	}
+
+	if payment.Status != domain.PaymentAmountMismatch {
+		log.Printf("Order %s paid %s %.2f, quoted %.2f; holding it as %s", orderID, payment.Currency, paid, payment.Amount, domain.PaymentAmountMismatch)
+		h.publishEvent(ctx, events.AmountMismatch, orderID, events.AmountMismatchPayload{
+			OrderID:      orderID,
+			CFPaymentID:  cfPaymentID,
+			Currency:     payment.Currency,
+			QuotedAmount: payment.Amount,
+			PaidAmount:   paid,
+		})
+	}
+	return domain.PaymentAmountMismatch
+}
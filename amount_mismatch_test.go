@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+func TestPaymentAmountMismatch(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	bus := events.NewMemoryBus(nil)
+	var mu sync.Mutex
+	published := map[string][]events.Event{}
+	bus.Subscribe(events.All, func(ctx context.Context, event events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published[event.Type] = append(published[event.Type], event)
+		return nil
+	})
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		publisher:    bus,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	// The order at Cashfree was tampered with after we quoted 100 for it
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	webhook, err := gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	payment, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentAmountMismatch, payment.Status)
+	assert.Nil(t, payment.InvoiceNumber)
+
+	// Verifying the order keeps it held, without alerting again
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/verify", bytes.NewBufferString(`{"order_id": "order_1"}`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"order_status":"AMOUNT_MISMATCH"`)
+
+	bus.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Empty(t, published[events.PaymentSucceeded])
+	require.Len(t, published[events.AmountMismatch], 1)
+	var mismatch events.AmountMismatchPayload
+	require.NoError(t, published[events.AmountMismatch][0].Decode(&mismatch))
+	assert.Equal(t, events.AmountMismatchPayload{
+		OrderID: "order_1", CFPaymentID: mismatch.CFPaymentID, Currency: "INR", QuotedAmount: 100, PaidAmount: 499.5,
+	}, mismatch)
+	assert.NotEmpty(t, mismatch.CFPaymentID)
+}
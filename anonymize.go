@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Kinds of personal data, each replaced with fakes of the same shape
+const (
+	piiName       = "name"
+	piiEmail      = "email"
+	piiPhone      = "phone"
+	piiCustomerID = "customer_id"
+	piiToken      = "token" // device IDs, card fingerprints, UPI IDs
+	piiIP         = "ip"
+	piiRedacted   = "redacted" // free text, cleared
+)
+
+// anonymizeBatch is the number of JSON documents rewritten per update
+const anonymizeBatch = 500
+
+var (
+	fakeFirstNames = []string{"Aarav", "Priya", "Rohan", "Ananya", "Vikram", "Neha", "Arjun", "Kavya", "Ishaan", "Meera", "Kabir", "Diya", "Aditya", "Saanvi", "Reyansh", "Tara"}
+	fakeLastNames  = []string{"Sharma", "Iyer", "Mehta", "Reddy", "Singh", "Gupta", "Nair", "Joshi", "Patel", "Rao", "Das", "Kapoor", "Menon", "Bose", "Verma", "Pillai"}
+)
+
+// anonymizedColumns are the columns holding personal data. Values are replaced through
+// the same mapping in every table, so customers still match across payments, reminders,
+// risk assessments and the blocklist.
+var anonymizedColumns = []struct {
+	Table, Column, Kind, Where string
+}{
+	{"payments", "customer_id", piiCustomerID, ""},
+	{"payments", "customer_name", piiName, ""},
+	{"payments", "customer_email", piiEmail, ""},
+	{"payments", "customer_phone", piiPhone, ""},
+	{"checkout_reminders", "customer_id", piiCustomerID, ""},
+	{"customer_reminder_preferences", "customer_id", piiCustomerID, ""},
+	{"customer_preferences", "customer_id", piiCustomerID, ""},
+	{"customer_consent_audit", "customer_id", piiCustomerID, ""},
+	{"customer_consent_audit", "remote_ip", piiIP, ""},
+	{"risk_assessments", "customer_id", piiCustomerID, ""},
+	{"risk_assessments", "device_id", piiToken, ""},
+	{"risk_assessments", "remote_ip", piiIP, ""},
+	{"blocklist", "value", piiEmail, "type = 'EMAIL'"},
+	{"blocklist", "value", piiPhone, "type = 'PHONE'"},
+	{"blocklist", "value", piiCustomerID, "type = 'CUSTOMER_ID'"},
+	{"blocklist", "value", piiToken, "type = 'CARD_FINGERPRINT'"},
+	{"blocklist_matches", "value", piiEmail, "type = 'EMAIL'"},
+	{"blocklist_matches", "value", piiPhone, "type = 'PHONE'"},
+	{"blocklist_matches", "value", piiCustomerID, "type = 'CUSTOMER_ID'"},
+	{"blocklist_matches", "value", piiToken, "type = 'CARD_FINGERPRINT'"},
+	{"blocklist_matches", "remote_ip", piiIP, ""},
+	{"merchant_audit_log", "remote_ip", piiIP, ""},
+	{"refund_audit_log", "remote_ip", piiIP, ""},
+	{"bank_statement_entries", "description", piiRedacted, ""},
+}
+
+// anonymizedJSONColumns are the JSON columns whose webhookPIIKeys are anonymized, by
+// table and column
+var anonymizedJSONColumns = [][2]string{
+	{"webhooks", "payload"},
+	{"payments", "payment_method_details"},
+}
+
+// webhookPIIKeys are the keys holding personal data, at any depth, in webhook payloads
+// and the other JSON columns of anonymizedJSONColumns
+var webhookPIIKeys = map[string]string{
+	"customer_id":      piiCustomerID,
+	"customer_name":    piiName,
+	"customer_email":   piiEmail,
+	"customer_phone":   piiPhone,
+	"card_fingerprint": piiToken,
+	"card_number":      piiToken,
+	"upi_id":           piiToken,
+}
+
+// anonymizer maps personal data to deterministic fakes: the same value and secret
+// always give the same fake, and without the secret fakes cannot be traced back
+type anonymizer struct {
+	secret []byte
+}
+
+// digest keys the value's HMAC with its kind, so an email and a customer ID with the
+// same text get unrelated fakes
+func (a *anonymizer) digest(kind, value string) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(kind))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// fake returns the fake of a value of a kind. Emails and phones are normalized as the
+// blocklist stores them first, so differently written forms of one contact get the
+// same fake. Empty values stay empty.
+func (a *anonymizer) fake(kind, value string) string {
+	if strings.TrimSpace(value) == "" {
+		return value
+	}
+
+	switch kind {
+	case piiName:
+		sum := a.digest(kind, strings.ToLower(strings.TrimSpace(value)))
+		return fakeFirstNames[int(sum[0])%len(fakeFirstNames)] + " " + fakeLastNames[int(sum[1])%len(fakeLastNames)]
+	case piiEmail:
+		sum := a.digest(kind, strings.ToLower(strings.TrimSpace(value)))
+		return "customer." + hex.EncodeToString(sum[:6]) + "@example.com"
+	case piiPhone:
+		normalized, err := normalizeBlockValue(BlockPhone, value)
+		if err != nil {
+			normalized = value
+		}
+		sum := a.digest(kind, normalized)
+		// A 10 digit mobile number starting with 9, as Cashfree validates phones
+		return fmt.Sprintf("9%09d", binary.BigEndian.Uint64(sum[:8])%1000000000)
+	case piiCustomerID:
+		return "cust_" + hex.EncodeToString(a.digest(kind, strings.TrimSpace(value))[:8])
+	case piiIP:
+		sum := a.digest(kind, strings.TrimSpace(value))
+		return fmt.Sprintf("10.%d.%d.%d", sum[0], sum[1], sum[2])
+	case piiRedacted:
+		return ""
+	default:
+		return hex.EncodeToString(a.digest(kind, value)[:16])
+	}
+}
+
+// anonymizeJSON replaces the values of webhookPIIKeys anywhere in a JSON document,
+// keeping numbers as they were written
+func (a *anonymizer) anonymizeJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(a.anonymizeValue(doc))
+}
+
+func (a *anonymizer) anonymizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if kind, ok := webhookPIIKeys[key]; ok {
+				if s, ok := field.(string); ok {
+					v[key] = a.fake(kind, s)
+					continue
+				}
+			}
+			v[key] = a.anonymizeValue(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = a.anonymizeValue(item)
+		}
+	}
+	return value
+}
+
+// anonymizeDatabase replaces the personal data of every table in one transaction,
+// leaving IDs, amounts and statuses untouched. It returns the rows updated by column.
+func anonymizeDatabase(ctx context.Context, db *pgxpool.Pool, a *anonymizer) (map[string]int64, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	updated := make(map[string]int64)
+	for _, col := range anonymizedColumns {
+		count, err := anonymizeColumn(ctx, tx, a, col.Table, col.Column, col.Kind, col.Where)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", col.Table, col.Column, err)
+		}
+		updated[col.Table+"."+col.Column] += count
+	}
+
+	for _, col := range anonymizedJSONColumns {
+		count, err := anonymizeJSONColumn(ctx, tx, a, col[0], col[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", col[0], col[1], err)
+		}
+		updated[col[0]+"."+col[1]] = count
+	}
+
+	return updated, tx.Commit(ctx)
+}
+
+// anonymizeColumn maps each distinct value of a column to its fake in one update
+func anonymizeColumn(ctx context.Context, tx pgx.Tx, a *anonymizer, table, column, kind, where string) (int64, error) {
+	condition := column + " IS NOT NULL AND " + column + " <> ''"
+	if where != "" {
+		condition += " AND " + where
+	}
+
+	rows, err := tx.Query(ctx, `SELECT DISTINCT `+column+` FROM `+table+` WHERE `+condition)
+	if err != nil {
+		return 0, err
+	}
+	originals, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, err
+	}
+	if len(originals) == 0 {
+		return 0, nil
+	}
+
+	fakes := make([]string, len(originals))
+	for i, original := range originals {
+		fakes[i] = a.fake(kind, original)
+	}
+
+	query := `
+		UPDATE ` + table + ` SET ` + column + ` = m.fake
+		FROM unnest($1::text[], $2::text[]) AS m(original, fake)
+		WHERE ` + table + `.` + column + ` = m.original AND ` + condition
+	tag, err := tx.Exec(ctx, query, originals, fakes)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// anonymizeJSONColumn rewrites the JSON documents of a column in batches, by the UUID
+// id of their rows
+func anonymizeJSONColumn(ctx context.Context, tx pgx.Tx, a *anonymizer, table, column string) (int64, error) {
+	var total int64
+	after := uuid.Nil
+	for {
+		rows, err := tx.Query(ctx, `SELECT id, `+column+`::text FROM `+table+` WHERE id > $1 AND `+column+` IS NOT NULL ORDER BY id LIMIT $2`, after, anonymizeBatch)
+		if err != nil {
+			return total, err
+		}
+
+		var ids []uuid.UUID
+		var documents []string
+		for rows.Next() {
+			var id uuid.UUID
+			var document string
+			if err := rows.Scan(&id, &document); err != nil {
+				rows.Close()
+				return total, err
+			}
+			rewritten, err := a.anonymizeJSON([]byte(document))
+			if err != nil {
+				rows.Close()
+				return total, fmt.Errorf("row %s: %w", id, err)
+			}
+			ids = append(ids, id)
+			documents = append(documents, string(rewritten))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+
+		tag, err := tx.Exec(ctx, `
+			UPDATE `+table+` SET `+column+` = m.document::jsonb
+			FROM unnest($1::uuid[], $2::text[]) AS m(id, document)
+			WHERE `+table+`.id = m.id
+		`, ids, documents)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+		after = ids[len(ids)-1]
+	}
+}
+
+// runAnonymize implements the anonymize command, which replaces the personal data in a
+// copy of the database, such as a production snapshot restored for staging
+func runAnonymize(args []string, db *pgxpool.Pool) error {
+	flags := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	confirm := flags.String("confirm", "", "name of the database to anonymize, as a safeguard against running on the wrong one")
+	secret := flags.String("secret", os.Getenv("ANONYMIZE_SECRET"), "secret keying the fakes (default $ANONYMIZE_SECRET)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *secret == "" {
+		return errors.New("-secret or ANONYMIZE_SECRET is required")
+	}
+
+	ctx := context.Background()
+	var database string
+	if err := db.QueryRow(ctx, `SELECT current_database()`).Scan(&database); err != nil {
+		return err
+	}
+	if *confirm != database {
+		return fmt.Errorf("this rewrites the personal data in database %q; pass -confirm %s to proceed", database, database)
+	}
+
+	updated, err := anonymizeDatabase(ctx, db, &anonymizer{secret: []byte(*secret)})
+	if err != nil {
+		return err
+	}
+	printed := make(map[string]bool)
+	for _, col := range anonymizedColumns {
+		name := col.Table + "." + col.Column
+		if !printed[name] {
+			fmt.Printf("%s: %d rows\n", name, updated[name])
+			printed[name] = true
+		}
+	}
+	for _, col := range anonymizedJSONColumns {
+		name := col[0] + "." + col[1]
+		fmt.Printf("%s: %d rows\n", name, updated[name])
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestAnonymizerFake(t *testing.T) {
+	a := &anonymizer{secret: []byte("staging")}
+
+	email := a.fake(piiEmail, "John.Doe@Example.com")
+	assert.Equal(t, email, a.fake(piiEmail, " john.doe@example.com"))
+	assert.NotEqual(t, email, a.fake(piiEmail, "jane@example.com"))
+	assert.Regexp(t, `^customer\.[0-9a-f]{12}@example\.com$`, email)
+
+	phone := a.fake(piiPhone, "+91 98765 43210")
+	assert.Equal(t, phone, a.fake(piiPhone, "9876543210"))
+	assert.Regexp(t, `^9[0-9]{9}$`, phone)
+	normalized, err := normalizeBlockValue(BlockPhone, phone)
+	require.NoError(t, err)
+	assert.Equal(t, phone, normalized)
+
+	assert.Regexp(t, `^[A-Z][a-z]+ [A-Z][a-z]+$`, a.fake(piiName, "John Doe"))
+	assert.Regexp(t, `^cust_[0-9a-f]{16}$`, a.fake(piiCustomerID, "customer_001"))
+	assert.Regexp(t, `^10\.\d+\.\d+\.\d+$`, a.fake(piiIP, "203.0.113.7"))
+	assert.Empty(t, a.fake(piiRedacted, "NEFT from JOHN DOE"))
+	assert.Empty(t, a.fake(piiEmail, ""))
+
+	// Another secret gives other fakes
+	assert.NotEqual(t, email, (&anonymizer{secret: []byte("other")}).fake(piiEmail, "john.doe@example.com"))
+}
+
+func TestAnonymizeJSON(t *testing.T) {
+	a := &anonymizer{secret: []byte("staging")}
+	payload, err := os.ReadFile("testdata/webhooks/payment_success_2023-08-01.json")
+	require.NoError(t, err)
+
+	anonymized, err := a.anonymizeJSON(payload)
+	require.NoError(t, err)
+	assert.NotContains(t, string(anonymized), "john.doe@example.com")
+	assert.NotContains(t, string(anonymized), "9876543210")
+	assert.NotContains(t, string(anonymized), "customer@okicici")
+	assert.Contains(t, string(anonymized), a.fake(piiEmail, "john.doe@example.com"))
+	// Numbers and IDs are kept as written
+	assert.Contains(t, string(anonymized), `"cf_payment_id":1453002795`)
+	assert.Contains(t, string(anonymized), `"order_id":"order_OFR_2"`)
+}
+
+func TestAnonymizeDatabase(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	payment := testPayment("order_anonymize_1")
+	payment.CustomerEmail = "John.Doe@Example.com"
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+	require.NoError(t, repo.CreateBlocklistEntry(ctx, &BlocklistEntry{Type: BlockEmail, Value: "john.doe@example.com", CreatedBy: "ops"}))
+	payload, err := os.ReadFile("testdata/webhooks/payment_success_2023-08-01.json")
+	require.NoError(t, err)
+	orderID := payment.OrderID
+	require.NoError(t, repo.CreateWebhookLog(ctx, &Webhook{EventType: "PAYMENT_SUCCESS_WEBHOOK", OrderID: &orderID, Payload: string(payload), Status: domain.WebhookReceived}))
+
+	a := &anonymizer{secret: []byte("staging")}
+	updated, err := anonymizeDatabase(ctx, db, a)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), updated["payments.customer_email"])
+	assert.Equal(t, int64(1), updated["blocklist.value"])
+	assert.Equal(t, int64(1), updated["webhooks.payload"])
+
+	anonymized, err := repo.GetPaymentByOrderID(ctx, payment.OrderID)
+	require.NoError(t, err)
+	assert.Equal(t, a.fake(piiEmail, "john.doe@example.com"), anonymized.CustomerEmail)
+	assert.Equal(t, a.fake(piiCustomerID, payment.CustomerID), anonymized.CustomerID)
+	assert.Equal(t, payment.Amount, anonymized.Amount)
+
+	// The anonymized customer still matches the blocklist
+	entry, err := repo.FindBlocklistEntry(ctx, []blockCandidate{{Type: BlockEmail, Value: anonymized.CustomerEmail}})
+	require.NoError(t, err)
+	assert.NotNil(t, entry)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+)
+
+// Refund types, as Cashfree names them. Merchant-initiated refunds are asked for through
+// the service or the Cashfree dashboard; Cashfree makes auto-refunds on its own, such as
+// when a customer was debited after dropping out of checkout.
+const (
+	RefundTypeMerchant = "MERCHANT_INITIATED"
+	RefundTypeAuto     = "PAYMENT_AUTO_REFUND"
+)
+
+// gatewayRefundReason is the reason recorded for a refund made at the gateway without a
+// note
+const gatewayRefundReason = "Refunded by Cashfree"
+
+// RecordGatewayRefund saves a refund made at the gateway rather than through the service,
+// unless a refund with its ID is already stored. It reports whether the refund was
+// saved, which it is not when its order is unknown either.
+func (r *PaymentRepository) RecordGatewayRefund(ctx context.Context, refund *Refund) (bool, error) {
+	now := r.now()
+	refund.ID = uuid.New()
+	refund.CreatedAt = now
+	refund.UpdatedAt = now
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 13)
+	query := `
+		INSERT INTO refunds (
+			id, refund_id, cf_refund_id, order_id, cf_order_id, amount, status, reason,
+			refund_mode, refund_arn, refund_type, processed_at, created_at, updated_at, tenant_id
+		)
+		SELECT $1, $2, NULLIF($3, ''), order_id, cf_order_id, $5, $6, $7, $8, $9, $10, $11,
+			$12, $12, tenant_id
+		FROM payments
+		WHERE order_id = $4` + tenant + `
+		ON CONFLICT DO NOTHING
+		RETURNING cf_order_id
+	`
+
+	args := append([]interface{}{
+		refund.ID, refund.RefundID, refund.CFRefundID, refund.OrderID, refund.Amount,
+		refund.Status, refund.Reason, refund.Mode, refund.ARN, refund.Type,
+		refund.ProcessedAt, now,
+	}, tenantArgs...)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	saved := false
+	for rows.Next() {
+		if err := rows.Scan(&refund.CFOrderID); err != nil {
+			return false, err
+		}
+		saved = true
+	}
+	return saved, rows.Err()
+}
+
+// recordGatewayRefund saves the refund a webhook reports when it was not made through
+// the service, so the local refunds match Cashfree's. It reports whether the refund was
+// new.
+func (h *PaymentHandler) recordGatewayRefund(ctx context.Context, webhook RefundWebhook) (bool, error) {
+	if webhook.OrderID == "" || webhook.RefundAmount <= 0 {
+		return false, nil
+	}
+
+	refund := &Refund{
+		RefundID:    webhook.RefundID,
+		CFRefundID:  webhook.CFRefundID,
+		OrderID:     webhook.OrderID,
+		Amount:      webhook.RefundAmount,
+		Status:      webhook.RefundStatus,
+		Type:        webhook.RefundType,
+		ProcessedAt: webhook.ProcessedAt,
+	}
+	if refund.Type == "" {
+		refund.Type = RefundTypeMerchant
+	}
+	reason := webhook.RefundNote
+	if reason == "" {
+		reason = gatewayRefundReason
+	}
+	refund.Reason = &reason
+	if webhook.RefundMode != "" {
+		refund.Mode = &webhook.RefundMode
+	}
+	if webhook.RefundARN != "" {
+		refund.ARN = &webhook.RefundARN
+	}
+
+	recorded, err := h.repo.RecordGatewayRefund(ctx, refund)
+	if err != nil {
+		return false, err
+	}
+	if recorded {
+		log.Printf("Recorded %s refund %s of order %s made at the gateway", refund.Type, refund.RefundID, refund.OrderID)
+	}
+	return recorded, nil
+}
+
+// handleAutoRefundStatusWebhook applies an AUTO_REFUND_STATUS_WEBHOOK, recording the
+// refund Cashfree made the first time it is reported
+func (h *PaymentHandler) handleAutoRefundStatusWebhook(ctx context.Context, data map[string]interface{}) error {
+	refund, err := parseRefundWebhook(data)
+	if err != nil {
+		log.Printf("Invalid auto-refund status webhook: %v", err)
+		return nil
+	}
+	if refund.RefundType == "" {
+		refund.RefundType = RefundTypeAuto
+	}
+	return h.applyRefundWebhook(ctx, refund)
+}
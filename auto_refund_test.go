@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/domain"
+)
+
+func TestAutoRefundWebhookRecordsRefund(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	deliver := func(webhook *cashfreetest.Webhook) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	upstream, err := client.CreateOrder(testOrderRequest("order_dropped"))
+	require.NoError(t, err)
+	payment := testPayment("order_dropped")
+	payment.CFOrderID = upstream.CFOrderID
+	require.NoError(t, handler.repo.CreatePayment(ctx, payment))
+
+	webhook, err := gateway.AutoRefund("order_dropped")
+	require.NoError(t, err)
+	deliver(webhook)
+	// Cashfree retrying the webhook does not record the refund twice
+	deliver(webhook)
+
+	var count int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM refunds WHERE order_id = 'order_dropped'`).Scan(&count))
+	assert.Equal(t, 1, count)
+
+	order, ok := gateway.Order("order_dropped")
+	require.True(t, ok)
+	require.Len(t, order.Refunds, 1)
+	refund, err := handler.repo.GetRefundByID(ctx, order.Refunds[0].RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, RefundTypeAuto, refund.Type)
+	assert.Equal(t, domain.RefundSuccess, refund.Status)
+	assert.Equal(t, upstream.CFOrderID, refund.CFOrderID)
+	assert.Equal(t, order.Amount, refund.Amount)
+	assert.NotNil(t, refund.ARN)
+	assert.NotNil(t, refund.ProcessedAt)
+	assert.Equal(t, "Payment debited after the customer dropped out", stringValue(refund.Reason))
+
+	// The payment itself is left alone
+	stored, err := handler.repo.GetPaymentByOrderID(ctx, "order_dropped")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentActive, stored.Status)
+}
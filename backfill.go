@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/domain"
+)
+
+// maxBackfillDays bounds the range of a backfill requested over the API, which runs
+// within the request. The backfill command has no limit.
+const maxBackfillDays = 31
+
+// BackfillResult summarizes an import of Cashfree history
+type BackfillResult struct {
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Orders   int               `json:"orders"`           // orders inserted or updated
+	Payments int               `json:"payments"`         // successful payments among them
+	Refunds  int               `json:"refunds"`          // refunds inserted or updated
+	Failed   map[string]string `json:"failed,omitempty"` // error by order ID
+}
+
+// backfillPayment builds the local record of a Cashfree order from the order, its
+// payment attempts and the Cashfree environment it was made in
+func backfillPayment(order *CashfreeOrderStatusResponse, attempts []CashfreePaymentResponse, environment string) *Payment {
+	payment := &Payment{
+		OrderID:          order.OrderID,
+		CFOrderID:        order.CFOrderID,
+		Amount:           order.OrderAmount,
+		Currency:         order.OrderCurrency,
+		Status:           order.OrderStatus,
+		Gateway:          GatewayCashfree,
+		CurrencyExponent: currencyExponent(order.OrderCurrency),
+		CreatedAt:        order.CreatedAt,
+	}
+	if environment != "" {
+		env := strings.ToUpper(environment)
+		payment.Environment = &env
+	}
+	if customer := order.CustomerDetails; customer != nil {
+		payment.CustomerID = customer.CustomerID
+		payment.CustomerName = customer.CustomerName
+		payment.CustomerEmail = customer.CustomerEmail
+		payment.CustomerPhone = customer.CustomerPhone
+	}
+	if order.PaymentLink != "" {
+		payment.PaymentURL = &order.PaymentLink
+	}
+	if order.OrderNote != "" {
+		payment.Description = &order.OrderNote
+	}
+	if len(order.OrderTags) > 0 {
+		payment.Tags = order.OrderTags
+	}
+
+	// Attempts are listed newest first; the order is paid by its successful one
+	for _, attempt := range attempts {
+		if attempt.PaymentStatus != "SUCCESS" {
+			continue
+		}
+		payment.Status = domain.PaymentSuccess
+		payment.CFPaymentID = &attempt.CFPaymentID
+		payment.PaymentMethod = &attempt.PaymentMethod
+		paymentTime := attempt.PaymentTime
+		payment.PaymentTime = &paymentTime
+		if charges := attempt.PaymentCharges; charges != nil {
+			fee, tax, net := charges.ServiceCharge, charges.ServiceTax, charges.net(attempt.PaymentAmount)
+			payment.GatewayFee, payment.GatewayTax, payment.NetAmount = &fee, &tax, &net
+		}
+		break
+	}
+	if payment.CreatedAt.IsZero() && payment.PaymentTime != nil {
+		payment.CreatedAt = *payment.PaymentTime
+	}
+	return payment
+}
+
+// backfillRefund builds the local record of a Cashfree refund
+func backfillRefund(payment *Payment, remote CashfreeRefundResponse) Refund {
+	refund := Refund{
+		RefundID:    remote.RefundID,
+		CFRefundID:  remote.CFRefundID,
+		OrderID:     payment.OrderID,
+		CFOrderID:   payment.CFOrderID,
+		Amount:      remote.RefundAmount,
+		Status:      remote.RefundStatus,
+		Speed:       RefundSpeedStandard,
+		ProcessedAt: remote.ProcessedAt,
+		CreatedAt:   payment.CreatedAt,
+	}
+	if remote.RefundNote != "" {
+		refund.Reason = &remote.RefundNote
+	}
+	if remote.RefundSpeed != nil && remote.RefundSpeed.Requested != "" {
+		refund.Speed = remote.RefundSpeed.Requested
+	}
+	if remote.RefundMode != "" {
+		refund.Mode = &remote.RefundMode
+	}
+	if remote.RefundARN != "" {
+		refund.ARN = &remote.RefundARN
+	}
+	if remote.RefundType != "" {
+		refund.Type = remote.RefundType
+	}
+	if remote.CreatedAt != nil {
+		refund.CreatedAt = *remote.CreatedAt
+	}
+	return refund
+}
+
+// UpsertBackfilledOrder records a payment imported from Cashfree and its refunds,
+// updating what Cashfree may since have changed on orders already recorded. Local
+// details Cashfree does not know, such as GST and splits, are kept.
+func (r *PaymentRepository) UpsertBackfilledOrder(ctx context.Context, payment *Payment, refunds []Refund) error {
+	now := r.now()
+	payment.ID = uuid.New()
+	payment.UpdatedAt = now
+	if payment.CreatedAt.IsZero() {
+		payment.CreatedAt = now
+	}
+	if tenantID := TenantIDFromContext(ctx); tenantID != nil {
+		payment.TenantID = tenantID
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Orders we cancelled keep their status, as in keepsLocalStatus
+	query := `
+		INSERT INTO payments (
+			id, order_id, cf_order_id, amount, currency, status,
+			customer_id, customer_name, customer_email, customer_phone,
+			description, payment_url, cf_payment_id, payment_method, payment_time,
+			gateway, environment, tenant_id, currency_exponent, tags,
+			gateway_fee, gateway_tax, net_amount, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+		ON CONFLICT (tenant_key, order_id) DO UPDATE SET
+			status = CASE WHEN payments.status = $26 AND EXCLUDED.status IN ($27, $28)
+			              THEN payments.status ELSE EXCLUDED.status END,
+			cf_payment_id = COALESCE(EXCLUDED.cf_payment_id, payments.cf_payment_id),
+			payment_method = COALESCE(EXCLUDED.payment_method, payments.payment_method),
+			payment_time = COALESCE(EXCLUDED.payment_time, payments.payment_time),
+			gateway_fee = COALESCE(EXCLUDED.gateway_fee, payments.gateway_fee),
+			gateway_tax = COALESCE(EXCLUDED.gateway_tax, payments.gateway_tax),
+			net_amount = COALESCE(EXCLUDED.net_amount, payments.net_amount),
+			updated_at = EXCLUDED.updated_at
+	`
+	_, err = tx.Exec(ctx, query,
+		payment.ID, payment.OrderID, payment.CFOrderID, payment.Amount,
+		payment.Currency, payment.Status, payment.CustomerID, payment.CustomerName,
+		payment.CustomerEmail, payment.CustomerPhone, payment.Description,
+		payment.PaymentURL, payment.CFPaymentID, payment.PaymentMethod, payment.PaymentTime,
+		payment.Gateway, payment.Environment, payment.TenantID, payment.CurrencyExponent,
+		payment.Tags, payment.GatewayFee, payment.GatewayTax, payment.NetAmount,
+		payment.CreatedAt, payment.UpdatedAt,
+		domain.PaymentCancelled, domain.PaymentTerminated, domain.PaymentTerminationRequested,
+	)
+	if err != nil {
+		return err
+	}
+
+	refundQuery := `
+		INSERT INTO refunds (
+			id, refund_id, cf_refund_id, order_id, cf_order_id, amount, status, reason,
+			refund_speed, refund_mode, refund_arn, processed_at, created_at, updated_at, tenant_id,
+			refund_type
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+			COALESCE(NULLIF($16, ''), 'MERCHANT_INITIATED'))
+		ON CONFLICT (refund_id) DO UPDATE SET
+			cf_refund_id = COALESCE(EXCLUDED.cf_refund_id, refunds.cf_refund_id),
+			status = EXCLUDED.status,
+			refund_mode = COALESCE(EXCLUDED.refund_mode, refunds.refund_mode),
+			refund_arn = COALESCE(EXCLUDED.refund_arn, refunds.refund_arn),
+			processed_at = COALESCE(EXCLUDED.processed_at, refunds.processed_at),
+			updated_at = EXCLUDED.updated_at
+		WHERE refunds.tenant_id IS NOT DISTINCT FROM EXCLUDED.tenant_id AND refunds.order_id = EXCLUDED.order_id
+	`
+	for i := range refunds {
+		refund := &refunds[i]
+		refund.ID = uuid.New()
+		refund.UpdatedAt = now
+		_, err := tx.Exec(ctx, refundQuery,
+			refund.ID, refund.RefundID, refund.CFRefundID, refund.OrderID, refund.CFOrderID,
+			refund.Amount, refund.Status, refund.Reason, refund.Speed, refund.Mode, refund.ARN,
+			refund.ProcessedAt, refund.CreatedAt, refund.UpdatedAt, payment.TenantID, refund.Type,
+		)
+		if err != nil {
+			return fmt.Errorf("refund %s: %w", refund.RefundID, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// backfillOrderIDs returns the orders Cashfree reports payment or refund events for in
+// [from, to), in the order of their first event. Cashfree only reports events once they
+// are reconciled, so orders that were never paid are not found.
+func backfillOrderIDs(ctx context.Context, client *CashfreeClient, from, to time.Time) ([]string, error) {
+	var orderIDs []string
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return orderIDs, err
+		}
+		page, err := client.GetReconEvents(from, to, cursor)
+		if err != nil {
+			return orderIDs, err
+		}
+		for _, event := range page.Data {
+			if (event.EventType != "PAYMENT" && event.EventType != "REFUND") || seen[event.OrderID] {
+				continue
+			}
+			seen[event.OrderID] = true
+			orderIDs = append(orderIDs, event.OrderID)
+		}
+		if page.Cursor == "" || len(page.Data) == 0 {
+			return orderIDs, nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// backfill imports the orders, payments and refunds Cashfree has for [from, to) into
+// repo, within ctx's merchant. An order that cannot be imported is recorded in the
+// result and the rest are still imported.
+func backfill(ctx context.Context, repo *PaymentRepository, client *CashfreeClient, from, to time.Time) (*BackfillResult, error) {
+	result := &BackfillResult{From: from, To: to}
+	client = client.ForBatch()
+
+	orderIDs, err := backfillOrderIDs(ctx, client, from, to)
+	if err != nil {
+		return result, fmt.Errorf("failed to list Cashfree events: %w", err)
+	}
+
+	for _, orderID := range orderIDs {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		refunds, err := backfillOrder(ctx, repo, client, orderID)
+		if err != nil {
+			if result.Failed == nil {
+				result.Failed = make(map[string]string)
+			}
+			result.Failed[orderID] = err.Error()
+			continue
+		}
+		result.Orders++
+		result.Refunds += refunds
+	}
+
+	paid, err := repo.countPaidOrders(ctx, orderIDs)
+	if err != nil {
+		return result, err
+	}
+	result.Payments = paid
+	return result, nil
+}
+
+// backfillOrder imports one order with its payments and refunds, returning how many
+// refunds it has
+func backfillOrder(ctx context.Context, repo *PaymentRepository, client *CashfreeClient, orderID string) (int, error) {
+	order, err := client.GetOrderStatus(orderID)
+	if err != nil {
+		return 0, err
+	}
+	attempts, err := client.ListPayments(orderID)
+	if err != nil {
+		return 0, err
+	}
+	remoteRefunds, err := client.ListRefunds(orderID)
+	if err != nil {
+		return 0, err
+	}
+
+	payment := backfillPayment(order, attempts, client.Environment)
+	refunds := make([]Refund, len(remoteRefunds))
+	for i, remote := range remoteRefunds {
+		refunds[i] = backfillRefund(payment, remote)
+	}
+	if err := repo.UpsertBackfilledOrder(ctx, payment, refunds); err != nil {
+		return 0, err
+	}
+	return len(refunds), nil
+}
+
+// countPaidOrders counts the successful payments among orderIDs
+func (r *PaymentRepository) countPaidOrders(ctx context.Context, orderIDs []string) (int, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `SELECT COUNT(*) FROM payments WHERE order_id = ANY($1) AND status = 'SUCCESS'` + tenant
+
+	var count int
+	err := r.db.QueryRow(ctx, query, append([]interface{}{orderIDs}, tenantArgs...)...).Scan(&count)
+	return count, err
+}
+
+// Backfill imports a date range of Cashfree history for the request's merchant
+func (h *ReconHandler) Backfill(c *gin.Context) {
+	ctx := requestContext(c)
+	from, to, err := parseDateRange(c, h.locationFor(ctx))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if to.Sub(from) > maxBackfillDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("a backfill may cover at most %d days; use the backfill command for longer ranges", maxBackfillDays)})
+		return
+	}
+
+	client, err := h.cashfree(ctx)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	result, err := backfill(ctx, h.repo, client, from, to)
+	if err != nil {
+		log.Printf("Backfill failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Backfill failed: " + err.Error(), "result": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// runBackfill implements the backfill command, which imports a date range of Cashfree
+// history into the database
+func runBackfill(args []string, db *pgxpool.Pool, cfg *Config, merchants *MerchantRepository) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fromDate := flags.String("from", "", "first day to import as YYYY-MM-DD, in the report time zone")
+	toDate := flags.String("to", "", "last day to import as YYYY-MM-DD, in the report time zone")
+	environment := flags.String("environment", cfg.CashfreeEnvironment, "Cashfree environment to import from")
+	merchantID := flags.String("merchant-id", "", "merchant to import for in multi-merchant mode")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := time.ParseInLocation("2006-01-02", *fromDate, cfg.ReportLocation)
+	if err != nil {
+		return errors.New("-from must be a date in YYYY-MM-DD format")
+	}
+	to, err := time.ParseInLocation("2006-01-02", *toDate, cfg.ReportLocation)
+	if err != nil {
+		return errors.New("-to must be a date in YYYY-MM-DD format")
+	}
+	if to.Before(from) {
+		return errors.New("-to must not be before -from")
+	}
+	to = to.AddDate(0, 0, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	var client *CashfreeClient
+	if *merchantID != "" {
+		if merchants == nil {
+			return errors.New("MERCHANT_ENCRYPTION_KEY must be set to backfill a merchant's history")
+		}
+		id, err := uuid.Parse(*merchantID)
+		if err != nil {
+			return fmt.Errorf("invalid merchant id: %v", err)
+		}
+		merchant, err := merchants.GetMerchantByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		ctx = WithMerchant(ctx, merchant)
+		client = NewMerchantClientPool(merchants).Get(merchant)
+	} else {
+		env := strings.ToUpper(*environment)
+		creds, ok := cfg.Cashfree[env]
+		if !ok {
+			return fmt.Errorf("no Cashfree credentials are configured for %s", env)
+		}
+		client = NewCashfreeClient(creds.ClientID, creds.ClientSecret, env, WithAPI(cfg.CashfreeAPI))
+	}
+
+	result, err := backfill(ctx, NewPaymentRepository(db), client, from, to)
+	if result != nil {
+		fmt.Printf("Imported %d orders (%d paid) and %d refunds\n", result.Orders, result.Payments, result.Refunds)
+		for orderID, reason := range result.Failed {
+			fmt.Printf("Failed to import %s: %s\n", orderID, reason)
+		}
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestBackfillPayment(t *testing.T) {
+	created := time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC)
+	paid := created.Add(10 * time.Minute)
+	order := &CashfreeOrderStatusResponse{
+		CFOrderID: "cf_order_1", OrderID: "order_1", OrderStatus: "PAID",
+		OrderAmount: 499.5, OrderCurrency: "INR", OrderNote: "Annual plan",
+		CustomerDetails: &CustomerDetails{CustomerID: "cust_1", CustomerEmail: "john@example.com", CustomerPhone: "9999999999"},
+		CreatedAt:       created,
+	}
+	attempts := []CashfreePaymentResponse{
+		{CFPaymentID: "cf_payment_2", PaymentStatus: "SUCCESS", PaymentAmount: 499.5, PaymentMethod: "upi", PaymentTime: paid,
+			PaymentCharges: &CashfreePaymentCharges{ServiceCharge: 9.99, ServiceTax: 1.8}},
+		{CFPaymentID: "cf_payment_1", PaymentStatus: "FAILED", PaymentMethod: "card"},
+	}
+
+	payment := backfillPayment(order, attempts, "test")
+	assert.Equal(t, domain.PaymentSuccess, payment.Status)
+	assert.Equal(t, "cf_payment_2", *payment.CFPaymentID)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+	assert.Equal(t, paid, *payment.PaymentTime)
+	assert.Equal(t, 487.71, *payment.NetAmount)
+	assert.Equal(t, "TEST", *payment.Environment)
+	assert.Equal(t, "Annual plan", *payment.Description)
+	assert.Equal(t, created, payment.CreatedAt)
+
+	// Orders never paid keep Cashfree's order status
+	order.OrderStatus = "EXPIRED"
+	payment = backfillPayment(order, attempts[1:], "TEST")
+	assert.Equal(t, domain.PaymentExpired, payment.Status)
+	assert.Nil(t, payment.CFPaymentID)
+
+	refund := backfillRefund(payment, CashfreeRefundResponse{
+		CFRefundID: "cf_refund_1", RefundID: "refund_1", RefundAmount: 100, RefundStatus: "SUCCESS",
+		RefundSpeed: &CashfreeRefundSpeed{Requested: "INSTANT"}, RefundMode: "INSTANT",
+	})
+	assert.Equal(t, "INSTANT", refund.Speed)
+	assert.Equal(t, "cf_order_1", refund.CFOrderID)
+	assert.Equal(t, created, refund.CreatedAt)
+}
+
+func TestBackfill(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("order_backfill_%d", time.Now().UnixNano())
+	for i := 0; i < 3; i++ {
+		orderID := fmt.Sprintf("%s_%d", prefix, i)
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+		if i < 2 {
+			_, err = server.CompletePayment(orderID, "upi")
+			require.NoError(t, err)
+		}
+	}
+	_, err := client.RefundPayment(CashfreeRefundRequest{OrderID: prefix + "_0", RefundID: "refund_" + prefix, RefundAmount: 100})
+	require.NoError(t, err)
+
+	// An order already recorded locally is updated rather than duplicated
+	existing := testPayment(prefix + "_1")
+	existing.CFOrderID = "cf_" + existing.OrderID
+	require.NoError(t, repo.CreatePayment(ctx, existing))
+
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	result, err := backfill(ctx, repo, client, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Orders)
+	assert.Equal(t, 2, result.Payments)
+	assert.Equal(t, 1, result.Refunds)
+	assert.Empty(t, result.Failed)
+
+	imported, err := repo.GetPaymentByOrderID(ctx, prefix+"_0")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentSuccess, imported.Status)
+	assert.Equal(t, "john@example.com", imported.CustomerEmail)
+	assert.Equal(t, "upi", *imported.PaymentMethod)
+	refund, err := repo.GetRefundByID(ctx, "refund_"+prefix)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, refund.Amount)
+
+	updated, err := repo.GetPaymentByOrderID(ctx, prefix+"_1")
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, updated.ID)
+	assert.Equal(t, domain.PaymentSuccess, updated.Status)
+
+	// The unpaid order has no recon events, so it is not imported
+	_, err = repo.GetPaymentByOrderID(ctx, prefix+"_2")
+	assert.Error(t, err)
+
+	// Running it again changes nothing
+	result, err = backfill(ctx, repo, client, from, to)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Orders)
+	assert.Equal(t, 1, result.Refunds)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bank statement formats
+const (
+	StatementFormatCSV   = "CSV"
+	StatementFormatMT940 = "MT940"
+)
+
+// maxStatementSize is the largest bank statement that can be uploaded
+const maxStatementSize = 10 << 20
+
+// parseBankStatement returns the credits in a bank statement. An empty format is
+// detected from the content.
+func parseBankStatement(data []byte, format string) ([]BankStatementEntry, error) {
+	if format == "" {
+		format = detectStatementFormat(data)
+	}
+	switch strings.ToUpper(format) {
+	case StatementFormatCSV:
+		return parseStatementCSV(data)
+	case StatementFormatMT940:
+		return parseStatementMT940(data)
+	default:
+		return nil, fmt.Errorf("format must be csv or mt940")
+	}
+}
+
+// detectStatementFormat tells MT940 statements, which start with a SWIFT header block or
+// a :20: transaction reference, from CSV
+func detectStatementFormat(data []byte) string {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{1:")) || bytes.HasPrefix(data, []byte(":20:")) {
+		return StatementFormatMT940
+	}
+	return StatementFormatCSV
+}
+
+// parseStatementCSV reads a CSV statement with a header row naming the utr, amount and
+// value_date columns, and optionally description. Rows with an amount that is not
+// positive are debits and are skipped.
+func parseStatementCSV(data []byte) ([]BankStatementEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("statement is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{"utr", "amount", "value_date"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("statement has no %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []BankStatementEntry
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+
+		// Indian statements group digits as 1,00,000.00
+		amount, err := strconv.ParseFloat(strings.ReplaceAll(field(record, "amount"), ",", ""), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, field(record, "amount"))
+		}
+		if amount <= 0 {
+			continue
+		}
+		utr := field(record, "utr")
+		if utr == "" {
+			return nil, fmt.Errorf("line %d: utr is empty", line)
+		}
+		valueDate, err := parseStatementDate(field(record, "value_date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		entries = append(entries, BankStatementEntry{
+			UTR:         utr,
+			Amount:      amount,
+			ValueDate:   valueDate,
+			Description: field(record, "description"),
+			Source:      StatementFormatCSV,
+		})
+	}
+	return entries, nil
+}
+
+// parseStatementDate parses a value date as YYYY-MM-DD or DD/MM/YYYY
+func parseStatementDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "02/01/2006"} {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("value_date %q must be YYYY-MM-DD or DD/MM/YYYY", value)
+}
+
+// mt940StatementLine matches the :61: field: value date, optional entry date, debit or
+// credit mark, optional funds code, amount, transaction type, the account owner's
+// reference, the bank's reference and supplementary details
+var mt940StatementLine = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)[A-Z]?(\d+,\d*)[A-Z][A-Z0-9]{3}([^/\n]*)(?://([^\n]*))?(?:\n(?s:(.*)))?$`)
+
+// parseStatementMT940 reads the credits of an MT940 statement. The UTR is the account
+// owner's reference of the :61: line, or the bank's reference when the owner's is
+// NONREF, and the :86: line that follows becomes the description.
+func parseStatementMT940(data []byte) ([]BankStatementEntry, error) {
+	type field struct {
+		tag, value string
+		line       int
+	}
+	var fields []field
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " ")
+		switch {
+		case line == "" || line == "-" || line == "-}" || strings.HasPrefix(line, "{"):
+		case strings.HasPrefix(line, ":") && strings.Index(line[1:], ":") > 0:
+			end := strings.Index(line[1:], ":") + 1
+			fields = append(fields, field{tag: line[1:end], value: line[end+1:], line: i + 1})
+		case len(fields) > 0:
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+
+	var entries []BankStatementEntry
+	credit := false
+	for _, f := range fields {
+		switch f.tag {
+		case "61":
+			m := mt940StatementLine.FindStringSubmatch(f.value)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: invalid :61: statement line", f.line)
+			}
+			credit = m[3] == "C"
+			if !credit {
+				continue
+			}
+
+			valueDate, err := time.Parse("060102", m[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid value date %q", f.line, m[1])
+			}
+			amount, err := strconv.ParseFloat(strings.Replace(m[4], ",", ".", 1), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid amount %q", f.line, m[4])
+			}
+			utr := strings.TrimSpace(m[5])
+			if utr == "" || utr == "NONREF" {
+				utr = strings.TrimSpace(m[6])
+			}
+			if utr == "" {
+				return nil, fmt.Errorf("line %d: credit has no reference", f.line)
+			}
+
+			entries = append(entries, BankStatementEntry{
+				UTR:         utr,
+				Amount:      amount,
+				ValueDate:   valueDate,
+				Description: strings.TrimSpace(m[7]),
+				Source:      StatementFormatMT940,
+			})
+		case "86":
+			if credit && len(entries) > 0 {
+				entries[len(entries)-1].Description = strings.ReplaceAll(strings.TrimSpace(f.value), "\n", " ")
+			}
+			credit = false
+		}
+	}
+	return entries, nil
+}
+
+// Imports the credits of an uploaded bank statement for settlement reconciliation
+func (h *ReconHandler) ImportBankStatement(c *gin.Context) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if header.Size > maxStatementSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Statement is too large"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+
+	entries, err := parseBankStatement(data, c.PostForm("format"))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	imported, err := h.repo.ImportBankStatementEntries(ctx, entries)
+	if err != nil {
+		log.Printf("Failed to import bank statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import bank statement"})
+		return
+	}
+
+	log.Printf("Imported %d of %d bank statement credits from %s", imported, len(entries), header.Filename)
+	c.JSON(http.StatusOK, gin.H{
+		"credits":  len(entries),
+		"imported": imported,
+		"skipped":  len(entries) - imported,
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatementCSV(t *testing.T) {
+	statement := "\ufeffValue_Date,UTR,Description,Amount\n" +
+		"2024-04-02,UTR0001,CASHFREE PAYMENTS,\"1,00,000.50\"\n" +
+		"02/04/2024,UTR0002,CASHFREE PAYMENTS,98\n" +
+		"2024-04-02,,ATM WITHDRAWAL,-500\n"
+
+	entries, err := parseBankStatement([]byte(statement), "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "UTR0001", entries[0].UTR)
+	assert.Equal(t, 100000.5, entries[0].Amount)
+	assert.Equal(t, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), entries[0].ValueDate)
+	assert.Equal(t, "CASHFREE PAYMENTS", entries[0].Description)
+	assert.Equal(t, StatementFormatCSV, entries[0].Source)
+	assert.Equal(t, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), entries[1].ValueDate)
+
+	_, err = parseBankStatement([]byte("utr,amount\nUTR0001,98\n"), "csv")
+	assert.EqualError(t, err, "statement has no value_date column")
+	_, err = parseBankStatement([]byte("utr,amount,value_date\nUTR0001,ninety,2024-04-02\n"), "csv")
+	assert.EqualError(t, err, `line 2: invalid amount "ninety"`)
+	_, err = parseBankStatement([]byte("utr,amount,value_date\nUTR0001,98,April 2\n"), "csv")
+	assert.ErrorContains(t, err, "line 2: value_date")
+	_, err = parseBankStatement([]byte("utr,amount,value_date\n"), "xlsx")
+	assert.EqualError(t, err, "format must be csv or mt940")
+}
+
+func TestParseStatementMT940(t *testing.T) {
+	statement := "{1:F01BANKINBBAXXX0000000000}{2:O9400000000000BANKINBBAXXX00000000000000000000N}{4:\r\n" +
+		":20:STMT240402\r\n" +
+		":25:50100012345678\r\n" +
+		":28C:1/1\r\n" +
+		":60F:C240401INR10000,00\r\n" +
+		":61:2404020402CR98,NTRFUTR0001//CF123\r\n" +
+		":86:NEFT CR-CASHFREE PAYMENTS\r\n" +
+		"INDIA PVT LTD\r\n" +
+		":61:240402D500,00NCHKNONREF//CHQ0001\r\n" +
+		":86:CHEQUE 000123\r\n" +
+		":61:240402C1250,5NTRFNONREF//UTR0002\r\n" +
+		"SETTLEMENT 2024-04-01\r\n" +
+		":62F:C240402INR10848,50\r\n" +
+		"-}\r\n"
+
+	entries, err := parseBankStatement([]byte(statement), "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "UTR0001", entries[0].UTR)
+	assert.Equal(t, 98.0, entries[0].Amount)
+	assert.Equal(t, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), entries[0].ValueDate)
+	assert.Equal(t, "NEFT CR-CASHFREE PAYMENTS INDIA PVT LTD", entries[0].Description)
+	assert.Equal(t, StatementFormatMT940, entries[0].Source)
+
+	// A NONREF credit is known by the bank's reference
+	assert.Equal(t, "UTR0002", entries[1].UTR)
+	assert.Equal(t, 1250.5, entries[1].Amount)
+	assert.Equal(t, "SETTLEMENT 2024-04-01", entries[1].Description)
+
+	_, err = parseBankStatement([]byte(":20:STMT\n:61:APRIL2C98,00NTRFUTR0001\n"), "")
+	assert.EqualError(t, err, "line 2: invalid :61: statement line")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+)
+
+// benchmarkWebhook creates a paid order on a fake Cashfree server and returns its
+// PAYMENT_SUCCESS_WEBHOOK along with a handler that verifies it
+func benchmarkWebhook(b *testing.B, repo *PaymentRepository) (*PaymentHandler, *cashfreetest.Webhook) {
+	gateway, client := newFakeCashfree(b)
+	orderID := fmt.Sprintf("bench_%d", time.Now().UnixNano())
+	_, err := client.CreateOrder(testOrderRequest(orderID))
+	require.NoError(b, err)
+	if repo != nil {
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		require.NoError(b, repo.CreatePayment(context.Background(), payment))
+	}
+	webhook, err := gateway.CompletePayment(orderID, "upi")
+	require.NoError(b, err)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+	return handler, webhook
+}
+
+// discardLogs silences the handlers' per-request logging for the rest of the benchmark
+func discardLogs(b *testing.B) {
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func BenchmarkWebhookVerifyAndParse(b *testing.B) {
+	handler, webhook := benchmarkWebhook(b, nil)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, ok := handler.verifyWebhook(ctx, nil, webhook.Signature, webhook.Timestamp, string(webhook.Body)); !ok {
+			b.Fatal("webhook signature rejected")
+		}
+		var webhookData WebhookData
+		if err := json.Unmarshal(webhook.Body, &webhookData); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := parsePaymentWebhook(webhookData.Data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkHandleWebhook delivers the same success webhook repeatedly, as Cashfree's
+// retries would, through verification, the webhook log and dispatch
+func BenchmarkHandleWebhook(b *testing.B) {
+	repo := NewPaymentRepository(testDB(b))
+	handler, webhook := benchmarkWebhook(b, repo)
+	discardLogs(b)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+		if w.Code != http.StatusOK {
+			b.Fatalf("webhook returned %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+// benchmarkPayments returns n payments with every optional field set, as a page of
+// paid orders would be
+func benchmarkPayments(n int) []Payment {
+	paidAt := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	payments := make([]Payment, n)
+	for i := range payments {
+		payment := testPayment(fmt.Sprintf("order_%d", i))
+		cfPaymentID := fmt.Sprintf("cf_payment_%d", i)
+		method := "upi"
+		payment.Status = "SUCCESS"
+		payment.CFPaymentID = &cfPaymentID
+		payment.PaymentMethod = &method
+		payment.PaymentTime = &paidAt
+		payment.CreatedAt = paidAt
+		payment.UpdatedAt = paidAt
+		payments[i] = *payment
+	}
+	return payments
+}
+
+func BenchmarkPaymentsListSerialization(b *testing.B) {
+	for _, n := range []int{10, 100} {
+		payments := benchmarkPayments(n)
+		b.Run(fmt.Sprintf("payments=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				data, err := json.Marshal(gin.H{"payments": payments, "limit": n, "offset": 0, "count": n})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(data)))
+			}
+		})
+	}
+}
+
+// BenchmarkGetAllPayments serves a full page of payments from the database
+func BenchmarkGetAllPayments(b *testing.B) {
+	repo := NewPaymentRepository(testDB(b))
+	ctx := context.Background()
+	for i := range 100 {
+		require.NoError(b, repo.CreatePayment(ctx, testPayment(fmt.Sprintf("order_list_%d", i))))
+	}
+	handler := &PaymentHandler{repo: repo}
+	discardLogs(b)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/payments", handler.GetAllPayments)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments?limit=100", nil))
+		if w.Code != http.StatusOK {
+			b.Fatalf("listing returned %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkCreateSplitSettlement(b *testing.B) {
+	repo := NewPaymentRepository(testDB(b))
+	ctx := context.Background()
+	payment := testPayment("order_split_bench")
+	require.NoError(b, repo.CreatePayment(ctx, payment))
+
+	for _, n := range []int{2, 10, 50} {
+		b.Run(fmt.Sprintf("splits=%d", n), func(b *testing.B) {
+			splits := make([]SplitSettlement, n)
+			b.ReportAllocs()
+			for b.Loop() {
+				for i := range splits {
+					splits[i] = SplitSettlement{
+						OrderID:   payment.OrderID,
+						CFOrderID: payment.CFOrderID,
+						VendorID:  fmt.Sprintf("vendor_%d", i),
+						Amount:    payment.Amount / float64(n),
+						SplitType: "AMOUNT",
+						Status:    "PENDING",
+					}
+				}
+				if err := repo.CreateSplitSettlement(ctx, splits); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Blocklist entry types
+const (
+	BlockEmail           = "EMAIL"
+	BlockPhone           = "PHONE"
+	BlockCustomerID      = "CUSTOMER_ID"
+	BlockCardFingerprint = "CARD_FINGERPRINT"
+)
+
+// Stages at which a blocklist entry can match
+const (
+	BlockStageCreateSession = "create_session"
+	BlockStagePayment       = "payment" // card fingerprints, known once the customer has paid
+)
+
+var errBlocklistEntryExists = errors.New("blocklist entry already exists")
+
+// BlocklistEntry blocks a customer or payment instrument. Entries without a merchant
+// apply to every merchant.
+type BlocklistEntry struct {
+	ID         uuid.UUID  `json:"id"`
+	MerchantID *uuid.UUID `json:"merchant_id,omitempty"`
+	Type       string     `json:"type"`
+	Value      string     `json:"value"` // normalized, see normalizeBlockValue
+	Reason     *string    `json:"reason,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// BlocklistMatch records a blocklist entry matching an order, for the audit trail
+type BlocklistMatch struct {
+	ID         uuid.UUID  `json:"id"`
+	EntryID    *uuid.UUID `json:"entry_id,omitempty"` // nil once the entry is deleted
+	MerchantID *uuid.UUID `json:"merchant_id,omitempty"`
+	Type       string     `json:"type"`
+	Value      string     `json:"value"`
+	OrderID    string     `json:"order_id"`
+	Stage      string     `json:"stage"`
+	RemoteIP   *string    `json:"remote_ip,omitempty"` // of the create-session request
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// blockCandidate is a value looked up in the blocklist
+type blockCandidate struct {
+	Type  string
+	Value string
+}
+
+// normalizeBlockValue puts a value in the form entries are stored and matched in:
+// emails lower-cased, phones as their digits without a 0 or 91 prefix, and other
+// values trimmed. It returns an error for an unknown type or an empty value.
+func normalizeBlockValue(blockType, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch blockType {
+	case BlockEmail:
+		value = strings.ToLower(value)
+	case BlockPhone:
+		var digits strings.Builder
+		for _, r := range value {
+			if r >= '0' && r <= '9' {
+				digits.WriteRune(r)
+			}
+		}
+		value = digits.String()
+		if len(value) == 11 && strings.HasPrefix(value, "0") {
+			value = value[1:]
+		} else if len(value) == 12 && strings.HasPrefix(value, "91") {
+			value = value[2:]
+		}
+	case BlockCustomerID, BlockCardFingerprint:
+	default:
+		return "", fmt.Errorf("type must be one of %s, %s, %s or %s", BlockEmail, BlockPhone, BlockCustomerID, BlockCardFingerprint)
+	}
+	if value == "" {
+		return "", errors.New("value is required")
+	}
+	return value, nil
+}
+
+const blocklistColumns = `id, tenant_id, type, value, reason, created_by, created_at, updated_at`
+
+func scanBlocklistEntry(row pgx.Row) (*BlocklistEntry, error) {
+	var e BlocklistEntry
+	err := row.Scan(&e.ID, &e.MerchantID, &e.Type, &e.Value, &e.Reason, &e.CreatedBy, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// CreateBlocklistEntry adds an entry, returning errBlocklistEntryExists when the same
+// value is already blocked for the merchant
+func (r *PaymentRepository) CreateBlocklistEntry(ctx context.Context, entry *BlocklistEntry) error {
+	query := `
+		INSERT INTO blocklist (id, tenant_id, type, value, reason, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		ON CONFLICT DO NOTHING
+		RETURNING created_at
+	`
+
+	entry.ID = uuid.New()
+	entry.CreatedAt = r.now()
+	entry.UpdatedAt = entry.CreatedAt
+
+	err := r.db.QueryRow(ctx, query,
+		entry.ID, entry.MerchantID, entry.Type, entry.Value, entry.Reason, entry.CreatedBy, entry.CreatedAt,
+	).Scan(&entry.CreatedAt)
+	if err == pgx.ErrNoRows {
+		return errBlocklistEntryExists
+	}
+	return err
+}
+
+// ListBlocklistEntries returns entries, newest first, optionally of one type
+func (r *PaymentRepository) ListBlocklistEntries(ctx context.Context, blockType string, limit, offset int) ([]BlocklistEntry, error) {
+	query := `
+		SELECT ` + blocklistColumns + `
+		FROM blocklist
+		WHERE $1 = '' OR type = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, blockType, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []BlocklistEntry{}
+	for rows.Next() {
+		entry, err := scanBlocklistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// GetBlocklistEntry returns an entry by ID
+func (r *PaymentRepository) GetBlocklistEntry(ctx context.Context, id uuid.UUID) (*BlocklistEntry, error) {
+	query := `SELECT ` + blocklistColumns + ` FROM blocklist WHERE id = $1`
+	return scanBlocklistEntry(r.db.QueryRow(ctx, query, id))
+}
+
+// UpdateBlocklistReason replaces an entry's reason; an empty reason removes it
+func (r *PaymentRepository) UpdateBlocklistReason(ctx context.Context, id uuid.UUID, reason string) (*BlocklistEntry, error) {
+	query := `
+		UPDATE blocklist
+		SET reason = NULLIF($2, ''), updated_at = $3
+		WHERE id = $1
+		RETURNING ` + blocklistColumns
+
+	return scanBlocklistEntry(r.db.QueryRow(ctx, query, id, reason, r.now()))
+}
+
+// DeleteBlocklistEntry removes an entry. Its matches are kept.
+func (r *PaymentRepository) DeleteBlocklistEntry(ctx context.Context, id uuid.UUID) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM blocklist WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// FindBlocklistEntry returns the oldest entry blocking any of the candidates for the
+// context's merchant, or nil when none does
+func (r *PaymentRepository) FindBlocklistEntry(ctx context.Context, candidates []blockCandidate) (*BlocklistEntry, error) {
+	var conditions []string
+	var args []interface{}
+	for _, candidate := range candidates {
+		if candidate.Value == "" {
+			continue
+		}
+		args = append(args, candidate.Type, candidate.Value)
+		conditions = append(conditions, fmt.Sprintf("(type = $%d AND value = $%d)", len(args)-1, len(args)))
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	tenant := "tenant_id IS NULL"
+	if tenantID := TenantIDFromContext(ctx); tenantID != nil {
+		args = append(args, *tenantID)
+		tenant = fmt.Sprintf("(tenant_id IS NULL OR tenant_id = $%d)", len(args))
+	}
+	query := `
+		SELECT ` + blocklistColumns + `
+		FROM blocklist
+		WHERE (` + strings.Join(conditions, " OR ") + `) AND ` + tenant + `
+		ORDER BY created_at
+		LIMIT 1
+	`
+
+	entry, err := scanBlocklistEntry(r.db.QueryRow(ctx, query, args...))
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return entry, err
+}
+
+// RecordBlocklistMatch appends a match to the audit trail
+func (r *PaymentRepository) RecordBlocklistMatch(ctx context.Context, match *BlocklistMatch) error {
+	query := `
+		INSERT INTO blocklist_matches (id, entry_id, tenant_id, type, value, order_id, stage, remote_ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	match.ID = uuid.New()
+	match.MerchantID = TenantIDFromContext(ctx)
+	match.CreatedAt = r.now()
+
+	_, err := r.db.Exec(ctx, query,
+		match.ID, match.EntryID, match.MerchantID, match.Type, match.Value,
+		match.OrderID, match.Stage, match.RemoteIP, match.CreatedAt,
+	)
+	return err
+}
+
+// ListBlocklistMatches returns the match audit trail, newest first
+func (r *PaymentRepository) ListBlocklistMatches(ctx context.Context, limit, offset int) ([]BlocklistMatch, error) {
+	query := `
+		SELECT id, entry_id, tenant_id, type, value, order_id, stage, remote_ip, created_at
+		FROM blocklist_matches
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []BlocklistMatch{}
+	for rows.Next() {
+		var m BlocklistMatch
+		err := rows.Scan(&m.ID, &m.EntryID, &m.MerchantID, &m.Type, &m.Value, &m.OrderID, &m.Stage, &m.RemoteIP, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+
+	return matches, rows.Err()
+}
+
+// Blocklist refuses blocked customers and audit-logs the orders entries match
+type Blocklist struct {
+	repo *PaymentRepository
+}
+
+// NewBlocklist returns a blocklist backed by repo
+func NewBlocklist(repo *PaymentRepository) *Blocklist {
+	return &Blocklist{repo: repo}
+}
+
+// recordMatch audit-logs a match, logging rather than failing when the entry cannot be
+// written
+func (b *Blocklist) recordMatch(ctx context.Context, entry *BlocklistEntry, orderID, stage string, remoteIP *string) {
+	match := &BlocklistMatch{
+		EntryID:  &entry.ID,
+		Type:     entry.Type,
+		Value:    entry.Value,
+		OrderID:  orderID,
+		Stage:    stage,
+		RemoteIP: remoteIP,
+	}
+	if err := b.repo.RecordBlocklistMatch(ctx, match); err != nil {
+		log.Printf("Failed to record blocklist match for order %s: %v", orderID, err)
+	}
+}
+
+// checkBlocklist refuses a payment session whose customer is blocked. It returns false
+// after writing the response when the session cannot go ahead.
+func (h *PaymentHandler) checkBlocklist(ctx context.Context, c *gin.Context, req *CreatePaymentSessionRequest) bool {
+	if h.blocklist == nil {
+		return true
+	}
+
+	var candidates []blockCandidate
+	for blockType, value := range map[string]string{
+		BlockEmail:      req.CustomerEmail,
+		BlockPhone:      req.CustomerPhone,
+		BlockCustomerID: req.CustomerID,
+	} {
+		if normalized, err := normalizeBlockValue(blockType, value); err == nil {
+			candidates = append(candidates, blockCandidate{Type: blockType, Value: normalized})
+		}
+	}
+
+	entry, err := h.blocklist.repo.FindBlocklistEntry(ctx, candidates)
+	if err != nil {
+		log.Printf("Failed to check blocklist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check blocklist"})
+		return false
+	}
+	if entry == nil {
+		return true
+	}
+
+	remoteIP := c.ClientIP()
+	h.blocklist.recordMatch(ctx, entry, req.OrderID, BlockStageCreateSession, &remoteIP)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":        "Customer is blocked",
+		"order_id":     req.OrderID,
+		"blocked_type": entry.Type,
+	})
+	return false
+}
+
+// checkCardBlocklist audit-logs a paid order whose card is blocked. The hosted checkout
+// takes the card, so the payment cannot be refused; the match is for follow-up.
+func (h *PaymentHandler) checkCardBlocklist(ctx context.Context, orderID, fingerprint string) {
+	if h.blocklist == nil || fingerprint == "" {
+		return
+	}
+	entry, err := h.blocklist.repo.FindBlocklistEntry(ctx, []blockCandidate{{Type: BlockCardFingerprint, Value: fingerprint}})
+	if err != nil {
+		log.Printf("Failed to check card blocklist for order %s: %v", orderID, err)
+		return
+	}
+	if entry == nil {
+		return
+	}
+	log.Printf("Order %s was paid with a blocked card", orderID)
+	h.blocklist.recordMatch(ctx, entry, orderID, BlockStagePayment, nil)
+}
+
+// BlocklistHandler serves the blocklist administration API
+type BlocklistHandler struct {
+	repo *PaymentRepository
+}
+
+// BlocklistEntryRequest adds a blocklist entry through the admin API
+type BlocklistEntryRequest struct {
+	Type       string     `json:"type" binding:"required"`
+	Value      string     `json:"value" binding:"required"`
+	MerchantID *uuid.UUID `json:"merchant_id,omitempty"` // nil blocks for every merchant
+	Reason     *string    `json:"reason,omitempty" binding:"omitempty,max=500"`
+}
+
+// paginationParams reads limit (default 10, at most 100) and offset query parameters
+func paginationParams(c *gin.Context) (int, int) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// entryID parses the id parameter, writing the error response when it is invalid
+func (h *BlocklistHandler) entryID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid blocklist entry ID"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// CreateEntry blocks a customer or card
+func (h *BlocklistHandler) CreateEntry(c *gin.Context) {
+	var req BlocklistEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	blockType := strings.ToUpper(req.Type)
+	value, err := normalizeBlockValue(blockType, req.Value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := c.GetHeader("X-Admin-Actor")
+	if actor == "" {
+		actor = "admin"
+	}
+	entry := &BlocklistEntry{
+		MerchantID: req.MerchantID,
+		Type:       blockType,
+		Value:      value,
+		Reason:     req.Reason,
+		CreatedBy:  actor,
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.repo.CreateBlocklistEntry(ctx, entry); err != nil {
+		if err == errBlocklistEntryExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Value is already blocked"})
+			return
+		}
+		log.Printf("Failed to create blocklist entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create blocklist entry"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// ListEntries lists blocklist entries, newest first, optionally filtered by type
+func (h *BlocklistHandler) ListEntries(c *gin.Context) {
+	limit, offset := paginationParams(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	entries, err := h.repo.ListBlocklistEntries(ctx, strings.ToUpper(c.Query("type")), limit, offset)
+	if err != nil {
+		log.Printf("Failed to list blocklist entries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blocklist"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   len(entries),
+	})
+}
+
+// GetEntry returns a blocklist entry
+func (h *BlocklistHandler) GetEntry(c *gin.Context) {
+	id, ok := h.entryID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	entry, err := h.repo.GetBlocklistEntry(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist entry not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// UpdateEntry changes the reason of a blocklist entry. The blocked value cannot change;
+// delete the entry and add another instead.
+func (h *BlocklistHandler) UpdateEntry(c *gin.Context) {
+	id, ok := h.entryID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	entry, err := h.repo.UpdateBlocklistReason(ctx, id, req.Reason)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist entry not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update blocklist entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update blocklist entry"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// DeleteEntry unblocks a value
+func (h *BlocklistHandler) DeleteEntry(c *gin.Context) {
+	id, ok := h.entryID(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	deleted, err := h.repo.DeleteBlocklistEntry(ctx, id)
+	if err != nil {
+		log.Printf("Failed to delete blocklist entry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete blocklist entry"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Blocklist entry not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListMatches lists the orders blocklist entries matched, newest first
+func (h *BlocklistHandler) ListMatches(c *gin.Context) {
+	limit, offset := paginationParams(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	matches, err := h.repo.ListBlocklistMatches(ctx, limit, offset)
+	if err != nil {
+		log.Printf("Failed to list blocklist matches: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve blocklist matches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"matches": matches,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   len(matches),
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeBlockValue(t *testing.T) {
+	tests := []struct {
+		blockType, value, want string
+	}{
+		{BlockEmail, " John@Example.COM ", "john@example.com"},
+		{BlockPhone, "+91 98765-43210", "9876543210"},
+		{BlockPhone, "09876543210", "9876543210"},
+		{BlockPhone, "9876543210", "9876543210"},
+		{BlockCustomerID, " cust_1 ", "cust_1"},
+		{BlockCardFingerprint, "a1b2c3", "a1b2c3"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeBlockValue(tt.blockType, tt.value)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := normalizeBlockValue("IP", "10.0.0.1")
+	assert.Error(t, err)
+	_, err = normalizeBlockValue(BlockPhone, "n/a")
+	assert.EqualError(t, err, "value is required")
+}
+
+func TestWebhookCardFingerprint(t *testing.T) {
+	assert.Equal(t, "fp_1", webhookCardFingerprint(map[string]interface{}{
+		"payment_method": map[string]interface{}{"card": map[string]interface{}{"card_fingerprint": "fp_1"}},
+	}))
+	assert.Empty(t, webhookCardFingerprint(map[string]interface{}{"payment_method": "card"}))
+}
+
+func TestBlocklist(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		blocklist:    NewBlocklist(repo),
+	}
+	admin := &BlocklistHandler{repo: repo}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	r.POST("/admin/blocklist", admin.CreateEntry)
+	r.GET("/admin/blocklist", admin.ListEntries)
+	r.GET("/admin/blocklist/matches", admin.ListMatches)
+	r.GET("/admin/blocklist/:id", admin.GetEntry)
+	r.PATCH("/admin/blocklist/:id", admin.UpdateEntry)
+	r.DELETE("/admin/blocklist/:id", admin.DeleteEntry)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Admin-Actor", "ops@example.com")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(orderID, email string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/payments/create-session", `{
+			"order_id": "`+orderID+`", "amount": 250, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "`+email+`",
+			"customer_phone": "9999999999"
+		}`)
+	}
+
+	w := serve(http.MethodPost, "/admin/blocklist", `{"type": "email", "value": "Fraud@Example.com", "reason": "chargebacks"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var entry BlocklistEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entry))
+	assert.Equal(t, BlockEmail, entry.Type)
+	assert.Equal(t, "fraud@example.com", entry.Value)
+	assert.Equal(t, "ops@example.com", entry.CreatedBy)
+
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/admin/blocklist", `{"type": "EMAIL", "value": "fraud@example.com"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/admin/blocklist", `{"type": "IP", "value": "10.0.0.1"}`).Code)
+	require.Equal(t, http.StatusCreated, serve(http.MethodPost, "/admin/blocklist", `{"type": "CARD_FINGERPRINT", "value": "fp_stolen"}`).Code)
+
+	w = create("order_1", "fraud@EXAMPLE.com")
+	require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"error": "Customer is blocked", "order_id": "order_1", "blocked_type": "EMAIL"}`, w.Body.String())
+	_, ok := gateway.Order("order_1")
+	assert.False(t, ok)
+
+	require.Equal(t, http.StatusOK, create("order_2", "john@example.com").Code)
+
+	// A blocked card is only known once the customer has paid
+	webhook, err := gateway.SignWebhook("PAYMENT_SUCCESS_WEBHOOK", map[string]interface{}{
+		"order": map[string]interface{}{"order_id": "order_2"},
+		"payment": map[string]interface{}{
+			"cf_payment_id":  "cf_payment_1",
+			"payment_status": "SUCCESS",
+			"payment_amount": 250,
+			"payment_method": map[string]interface{}{"card": map[string]interface{}{"card_fingerprint": "fp_stolen"}},
+		},
+	})
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(http.MethodGet, "/admin/blocklist/matches", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var matches struct {
+		Matches []BlocklistMatch `json:"matches"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &matches))
+	require.Len(t, matches.Matches, 2)
+	assert.Equal(t, "order_2", matches.Matches[0].OrderID)
+	assert.Equal(t, BlockStagePayment, matches.Matches[0].Stage)
+	assert.Equal(t, "order_1", matches.Matches[1].OrderID)
+	assert.Equal(t, BlockStageCreateSession, matches.Matches[1].Stage)
+	assert.Equal(t, &entry.ID, matches.Matches[1].EntryID)
+
+	w = serve(http.MethodPatch, "/admin/blocklist/"+entry.ID.String(), `{"reason": "confirmed fraud"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"reason":"confirmed fraud"`)
+
+	w = serve(http.MethodGet, "/admin/blocklist?type=email", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	// Unblocking lets the customer pay again and keeps the audit trail
+	require.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/admin/blocklist/"+entry.ID.String(), "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/admin/blocklist/"+entry.ID.String(), "").Code)
+	require.Equal(t, http.StatusOK, create("order_1", "fraud@example.com").Code)
+
+	stored, err := repo.ListBlocklistMatches(ctx, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Nil(t, stored[1].EntryID)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/domain"
+)
+
+// cassetteClient returns a Cashfree client that replays testdata/cassettes/<name>.yaml.
+// With CASHFREE_RECORD=1 and the sandbox credentials of the contract tests, it calls the
+// sandbox instead and rewrites the cassette when the test passes.
+func cassetteClient(t *testing.T, name string) (*CashfreeClient, *cashfreetest.Cassette) {
+	path := filepath.Join("testdata", "cassettes", name+".yaml")
+
+	if os.Getenv("CASHFREE_RECORD") == "1" {
+		client := sandboxClient(t)
+		cassette := cashfreetest.RecordCassette(path, nil)
+		client.Client.SetTransport(cassette)
+		t.Cleanup(func() {
+			if !t.Failed() {
+				require.NoError(t, cassette.Save())
+			}
+		})
+		return client, cassette
+	}
+
+	cassette, err := cashfreetest.LoadCassette(path)
+	require.NoError(t, err, "record the cassette with CASHFREE_RECORD=1")
+	client := NewCashfreeClient("cassette_client", "cassette_secret", EnvironmentTest)
+	client.Client.SetRetryCount(0)
+	client.Client.SetTransport(cassette)
+	t.Cleanup(func() {
+		assert.Empty(t, cassette.Unused(), "recorded calls the test no longer makes")
+	})
+	return client, cassette
+}
+
+func TestCashfreeClientCassetteOrders(t *testing.T) {
+	client, cassette := cassetteClient(t, "orders")
+	run, err := cassette.Var("run", func() string { return strconv.FormatInt(time.Now().UnixNano(), 10) })
+	require.NoError(t, err)
+	orderID := "cassette_" + run
+
+	order, err := client.CreateOrder(testOrderRequest(orderID))
+	require.NoError(t, err)
+	assert.Equal(t, orderID, order.OrderID)
+	assert.NotEmpty(t, order.CFOrderID)
+	assert.Equal(t, domain.PaymentActive, order.OrderStatus)
+
+	_, err = client.CreateOrder(testOrderRequest(orderID))
+	assert.ErrorContains(t, err, "status 409")
+	assert.ErrorContains(t, err, "order_already_exists")
+	assert.ErrorIs(t, err, ErrDuplicateRequest)
+
+	status, err := client.GetOrderStatus(orderID)
+	require.NoError(t, err)
+	assert.Equal(t, order.CFOrderID, status.CFOrderID)
+	assert.Equal(t, domain.PaymentActive, status.OrderStatus)
+	assert.Equal(t, 499.5, status.OrderAmount)
+	assert.Equal(t, "INR", status.OrderCurrency)
+	assert.False(t, status.OrderExpiryTime.IsZero())
+
+	_, err = client.GetPayments(orderID)
+	assert.EqualError(t, err, "no payments found for order "+orderID)
+
+	_, err = client.RefundPayment(CashfreeRefundRequest{OrderID: orderID, RefundID: "refund_" + orderID, RefundAmount: 100})
+	assert.ErrorContains(t, err, "status 400")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	_, err = client.GetOrderStatus("missing_" + orderID)
+	assert.ErrorContains(t, err, "status 404")
+	assert.ErrorContains(t, err, "order_not_found")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestCashfreeClientCassetteCredentials(t *testing.T) {
+	client, cassette := cassetteClient(t, "credentials")
+	assert.NoError(t, client.ValidateCredentials())
+
+	wrong := NewCashfreeClient(client.ClientID, "wrong_secret", EnvironmentTest)
+	wrong.Client.SetRetryCount(0)
+	wrong.Client.SetTransport(cassette)
+	assert.ErrorIs(t, wrong.ValidateCredentials(), ErrInvalidCredentials)
+}
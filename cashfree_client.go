@@ -4,11 +4,14 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+
+	"payment-getway/domain"
 )
 
 const (
@@ -23,123 +26,224 @@ type CashfreeClient struct {
 	Environment  string
 	BaseURL      string
 	Client       *resty.Client
+	Breaker      *CircuitBreaker
+	API          CashfreeAPI
+	Timeouts     CashfreeTimeouts
+
+	timeout       time.Duration // of each attempt at a call, when not Timeouts.Default
+	requestHooks  []CashfreeRequestHook
+	responseHooks []CashfreeResponseHook
 }
 
 // NewCashfreeClient creates a new Cashfree client
-func NewCashfreeClient(clientID, clientSecret, environment string) *CashfreeClient {
+func NewCashfreeClient(clientID, clientSecret, environment string, opts ...CashfreeOption) *CashfreeClient {
 	baseURL := CashfreeTestURL
 	if strings.ToUpper(environment) == "PROD" {
 		baseURL = CashfreeProdURL
 	}
 
+	// Attempts are timed per call, by installTimeouts, rather than by the HTTP client
 	client := resty.New()
-	client.SetTimeout(30 * time.Second)
 	client.SetRetryCount(3)
 	client.SetRetryWaitTime(5 * time.Second)
 
-	return &CashfreeClient{
+	// Stop calling Cashfree after repeated transport failures or 5xx responses
+	breaker := NewCircuitBreaker(5, 30*time.Second)
+	client.OnBeforeRequest(func(_ *resty.Client, _ *resty.Request) error {
+		if !breaker.Allow() {
+			return ErrCircuitOpen
+		}
+		return nil
+	})
+	client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		if resp.StatusCode() >= 500 {
+			breaker.RecordFailure()
+		} else {
+			breaker.RecordSuccess()
+		}
+		return nil
+	})
+	client.OnError(func(_ *resty.Request, err error) {
+		var respErr *resty.ResponseError
+		if !errors.Is(err, ErrCircuitOpen) && !errors.As(err, &respErr) {
+			breaker.RecordFailure()
+		}
+	})
+
+	c := &CashfreeClient{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		Environment:  environment,
 		BaseURL:      baseURL,
 		Client:       client,
+		Breaker:      breaker,
+		API:          CashfreeAPI{Version: DefaultCashfreeAPIVersion},
+		Timeouts:     DefaultCashfreeTimeouts,
 	}
+	c.installTimeouts()
+	c.installHooks()
+	c.OnBeforeRequest(IdempotencyKeyHook)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Name returns the gateway name
+func (c *CashfreeClient) Name() string {
+	return GatewayCashfree
 }
 
 // CreateOrder creates a new order in Cashfree
 func (c *CashfreeClient) CreateOrder(req CreateOrderRequest) (*CashfreeOrderResponse, error) {
-	url := fmt.Sprintf("%s/orders", c.BaseURL)
+	if err := validateCreateOrder(req); err != nil {
+		return nil, err
+	}
 
-	// Prepare headers
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders", c.BaseURL)
 
 	var response CashfreeOrderResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	resp, err := c.request(CashfreeOpCreateOrder).
 		SetBody(req).
 		SetResult(&response).
 		Post(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create order: %v", err)
+		return nil, fmt.Errorf("failed to create order: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
+	c.adaptOrder(&response)
 	return &response, nil
 }
 
 // GetOrderStatus gets the status of an order
 func (c *CashfreeClient) GetOrderStatus(orderID string) (*CashfreeOrderStatusResponse, error) {
-	url := fmt.Sprintf("%s/orders/%s", c.BaseURL, orderID)
+	if err := validateOrderID(CashfreeOpGetOrder, orderID); err != nil {
+		return nil, err
+	}
 
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders/%s", c.BaseURL, orderID)
 
 	var response CashfreeOrderStatusResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	resp, err := c.request(CashfreeOpGetOrder).
 		SetResult(&response).
 		Get(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get order status: %v", err)
+		return nil, fmt.Errorf("failed to get order status: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
+	c.adaptOrderStatus(&response)
 	return &response, nil
 }
 
-// GetPayments gets payment details for an order
+// GetPayments gets payment details for an order: its successful attempt, or the newest
+// when none succeeded
 func (c *CashfreeClient) GetPayments(orderID string) (*CashfreePaymentResponse, error) {
-	url := fmt.Sprintf("%s/orders/%s/payments", c.BaseURL, orderID)
+	payments, err := c.ListPayments(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(payments) == 0 {
+		return nil, fmt.Errorf("no payments found for order %s", orderID)
+	}
+
+	if paid := successfulAttempt(payments); paid != nil {
+		return paid, nil
+	}
+	return &payments[0], nil
+}
+
+// ListPayments gets every payment attempt on an order, newest first. Orders with many
+// attempts are listed a page at a time, each page giving the cursor of the next in its
+// x-next-cursor header.
+func (c *CashfreeClient) ListPayments(orderID string) ([]CashfreePaymentResponse, error) {
+	if err := validateOrderID(CashfreeOpGetPayments, orderID); err != nil {
+		return nil, err
+	}
 
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders/%s/payments", c.BaseURL, orderID)
 
 	var payments []CashfreePaymentResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
-		SetResult(&payments).
+	cursor := ""
+	for {
+		var page []CashfreePaymentResponse
+		req := c.request(CashfreeOpGetPayments).
+			SetResult(&page)
+		if cursor != "" {
+			req.SetQueryParam("cursor", cursor)
+		}
+		resp, err := req.Get(url)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get payments: %w", err)
+		}
+
+		if resp.StatusCode() != 200 {
+			return nil, newCashfreeError(resp)
+		}
+
+		payments = append(payments, page...)
+		cursor = resp.Header().Get("x-next-cursor")
+		if cursor == "" || len(page) == 0 {
+			return payments, nil
+		}
+	}
+}
+
+// GetPayment gets a payment by Cashfree's payment ID
+func (c *CashfreeClient) GetPayment(cfPaymentID string) (*CashfreePaymentResponse, error) {
+	if err := validatePaymentID(CashfreeOpGetPayment, cfPaymentID); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/payments/%s", c.BaseURL, cfPaymentID)
+
+	var payment CashfreePaymentResponse
+	resp, err := c.request(CashfreeOpGetPayment).
+		SetResult(&payment).
 		Get(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get payments: %v", err)
+		return nil, fmt.Errorf("failed to get payment: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
-	if len(payments) == 0 {
-		return nil, fmt.Errorf("no payments found for order %s", orderID)
-	}
-
-	return &payments[0], nil
+	return &payment, nil
 }
 
 // RefundPayment creates a refund for a payment
 func (c *CashfreeClient) RefundPayment(req CashfreeRefundRequest) (*CashfreeRefundResponse, error) {
-	url := fmt.Sprintf("%s/orders/%s/refunds", c.BaseURL, req.OrderID)
+	if err := validateRefund(req); err != nil {
+		return nil, err
+	}
 
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders/%s/refunds", c.BaseURL, req.OrderID)
 
 	var response CashfreeRefundResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	resp, err := c.request(CashfreeOpCreateRefund).
 		SetBody(req).
 		SetResult(&response).
 		Post(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create refund: %v", err)
+		return nil, fmt.Errorf("failed to create refund: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
 	return &response, nil
@@ -147,67 +251,198 @@ func (c *CashfreeClient) RefundPayment(req CashfreeRefundRequest) (*CashfreeRefu
 
 // GetRefundStatus gets the status of a refund
 func (c *CashfreeClient) GetRefundStatus(orderID, refundID string) (*CashfreeRefundResponse, error) {
-	url := fmt.Sprintf("%s/orders/%s/refunds/%s", c.BaseURL, orderID, refundID)
+	if err := validateOrderID(CashfreeOpGetRefund, orderID); err != nil {
+		return nil, err
+	}
 
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders/%s/refunds/%s", c.BaseURL, orderID, refundID)
 
 	var response CashfreeRefundResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	resp, err := c.request(CashfreeOpGetRefund).
 		SetResult(&response).
 		Get(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to get refund status: %v", err)
+		return nil, fmt.Errorf("failed to get refund status: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
 	return &response, nil
 }
 
-// CancelOrder cancels an order
+// ListRefunds gets every refund of an order
+func (c *CashfreeClient) ListRefunds(orderID string) ([]CashfreeRefundResponse, error) {
+	if err := validateOrderID(CashfreeOpListRefunds, orderID); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/orders/%s/refunds", c.BaseURL, orderID)
+
+	var refunds []CashfreeRefundResponse
+	resp, err := c.request(CashfreeOpListRefunds).
+		SetResult(&refunds).
+		Get(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refunds: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, newCashfreeError(resp)
+	}
+
+	return refunds, nil
+}
+
+// CancelOrder closes an unpaid order so it can no longer be paid. Cashfree records it as
+// terminated; see TerminateOrder.
 func (c *CashfreeClient) CancelOrder(orderID string) error {
-	url := fmt.Sprintf("%s/orders/%s/cancel", c.BaseURL, orderID)
+	_, err := c.terminateOrder(CashfreeOpCancelOrder, "cancel", orderID)
+	return err
+}
 
-	headers := c.getAuthHeaders()
+// TerminateOrder asks Cashfree to terminate an unpaid order. The order is TERMINATED, or
+// TERMINATION_REQUESTED while Cashfree checks that no payment is in flight.
+func (c *CashfreeClient) TerminateOrder(orderID string) (*CashfreeOrderStatusResponse, error) {
+	return c.terminateOrder(CashfreeOpTerminateOrder, "terminate", orderID)
+}
+
+// terminateOrder patches the order's status to TERMINATED
+func (c *CashfreeClient) terminateOrder(operation, action, orderID string) (*CashfreeOrderStatusResponse, error) {
+	if err := validateOrderID(operation, orderID); err != nil {
+		return nil, err
+	}
 
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	url := fmt.Sprintf("%s/orders/%s", c.BaseURL, orderID)
+
+	var response CashfreeOrderStatusResponse
+	resp, err := c.request(operation).
+		SetBody(map[string]domain.PaymentStatus{"order_status": domain.PaymentTerminated}).
+		SetResult(&response).
 		Patch(url)
 
 	if err != nil {
-		return fmt.Errorf("failed to cancel order: %v", err)
+		return nil, fmt.Errorf("failed to %s order: %w", action, err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
 	}
 
-	return nil
+	c.adaptOrderStatus(&response)
+	return &response, nil
 }
 
 // CreateSettlement creates split settlement
 func (c *CashfreeClient) CreateSettlement(req CashfreeSettlementRequest) (*CashfreeSettlementResponse, error) {
-	url := fmt.Sprintf("%s/orders/%s/settlements", c.BaseURL, req.OrderID)
+	if err := validateOrderID(CashfreeOpCreateSettlement, req.OrderID); err != nil {
+		return nil, err
+	}
 
-	headers := c.getAuthHeaders()
+	url := fmt.Sprintf("%s/orders/%s/settlements", c.BaseURL, req.OrderID)
 
 	var response CashfreeSettlementResponse
-	resp, err := c.Client.R().
-		SetHeaders(headers).
+	resp, err := c.request(CashfreeOpCreateSettlement).
+		SetBody(req).
+		SetResult(&response).
+		Post(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create settlement: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, newCashfreeError(resp)
+	}
+
+	return &response, nil
+}
+
+// GetReconEvents gets a page of the payment, refund and settlement events Cashfree
+// reconciled in [from, to). Pass the cursor of the previous page, or "" for the first.
+func (c *CashfreeClient) GetReconEvents(from, to time.Time, cursor string) (*CashfreeReconResponse, error) {
+	url := fmt.Sprintf("%s/recon", c.BaseURL)
+
+	req := CashfreeReconRequest{}
+	req.Pagination.Limit = 100
+	if cursor != "" {
+		req.Pagination.Cursor = &cursor
+	}
+	req.Filters.StartDate = from.UTC().Format(time.RFC3339)
+	req.Filters.EndDate = to.UTC().Format(time.RFC3339)
+
+	var response CashfreeReconResponse
+	resp, err := c.request(CashfreeOpGetReconEvents).
 		SetBody(req).
 		SetResult(&response).
 		Post(url)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create settlement: %v", err)
+		return nil, fmt.Errorf("failed to get recon events: %w", err)
 	}
 
 	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("cashfree API returned status %d: %s", resp.StatusCode(), resp.String())
+		return nil, newCashfreeError(resp)
+	}
+
+	return &response, nil
+}
+
+// ErrInvalidCredentials is returned when Cashfree rejects a client ID and secret
+var ErrInvalidCredentials = errors.New("cashfree rejected the client credentials")
+
+// ValidateCredentials checks the client ID and secret with a live Cashfree call. The
+// lookup of an order that does not exist succeeds with 404 for valid credentials.
+func (c *CashfreeClient) ValidateCredentials() error {
+	url := fmt.Sprintf("%s/orders/%s", c.BaseURL, "credential_check")
+
+	resp, err := c.request(CashfreeOpValidateCredentials).
+		Get(url)
+
+	if err != nil {
+		return fmt.Errorf("failed to validate credentials: %w", err)
+	}
+
+	switch resp.StatusCode() {
+	case 200, 404:
+		return nil
+	case 401, 403:
+		return ErrInvalidCredentials
+	default:
+		return newCashfreeError(resp)
+	}
+}
+
+// CreateUPIIntent starts a UPI payment of an order in its payment session through
+// Cashfree's intent flow, returning the upi:// URI and app-specific links that launch
+// a UPI app with the payment filled in
+func (c *CashfreeClient) CreateUPIIntent(paymentSessionID string) (*CashfreeUPIIntentResponse, error) {
+	if err := validatePaymentSession(CashfreeOpPayOrder, paymentSessionID); err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/orders/sessions", c.BaseURL)
+
+	var response CashfreeUPIIntentResponse
+	resp, err := c.request(CashfreeOpPayOrder).
+		SetBody(map[string]interface{}{
+			"payment_session_id": paymentSessionID,
+			"payment_method": map[string]interface{}{
+				"upi": map[string]string{"channel": "link"},
+			},
+		}).
+		SetResult(&response).
+		Post(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create UPI intent: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, newCashfreeError(resp)
 	}
 
 	return &response, nil
@@ -215,15 +450,29 @@ func (c *CashfreeClient) CreateSettlement(req CashfreeSettlementRequest) (*Cashf
 
 // VerifyWebhookSignature verifies the webhook signature
 func (c *CashfreeClient) VerifyWebhookSignature(signature, timestamp, payload string) bool {
+	return verifyWebhookSignature(c.ClientSecret, signature, timestamp, payload)
+}
+
+// verifyWebhookSignature verifies a webhook signature against a secret. An empty
+// secret never verifies.
+func verifyWebhookSignature(secret, signature, timestamp, payload string) bool {
+	if secret == "" {
+		return false
+	}
+
+	hash := webhookSignature(secret, timestamp, payload)
+	return hmac.Equal([]byte(hash), []byte(signature))
+}
+
+// webhookSignature returns the signature Cashfree sends for a webhook signed with secret
+func webhookSignature(secret, timestamp, payload string) string {
 	// Create the string to sign
 	stringToSign := timestamp + payload
 
 	// Create HMAC SHA256 hash
-	h := hmac.New(sha256.New, []byte(c.ClientSecret))
+	h := hmac.New(sha256.New, []byte(secret))
 	h.Write([]byte(stringToSign))
-	hash := base64.StdEncoding.EncodeToString(h.Sum(nil))
-
-	return hash == signature
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
 // getAuthHeaders returns the authentication headers for Cashfree API
@@ -233,7 +482,7 @@ func (c *CashfreeClient) getAuthHeaders() map[string]string {
 		"X-Client-Secret": c.ClientSecret,
 		"Content-Type":    "application/json",
 		"Accept":          "application/json",
-		"x-api-version":   "2023-08-01",
+		"x-api-version":   c.API.Version,
 	}
 }
 
@@ -246,6 +495,9 @@ type CreateOrderRequest struct {
 	OrderMeta   *OrderMeta              `json:"order_meta,omitempty"`
 	OrderNote   string                  `json:"order_note,omitempty"`
 	OrderExpiryTime string              `json:"order_expiry_time,omitempty"`
+	OrderTags   map[string]string       `json:"order_tags,omitempty"`
+	CartDetails *CartDetails            `json:"cart_details,omitempty"`
+	OrderSplits []CashfreeSettlementSplit `json:"order_splits,omitempty"` // vendor splits settled once the order is paid
 }
 
 type CustomerDetails struct {
@@ -265,11 +517,29 @@ type OrderMeta struct {
 type CashfreeOrderStatusResponse struct {
 	CFOrderID       string    `json:"cf_order_id"`
 	OrderID         string    `json:"order_id"`
-	OrderStatus     string    `json:"order_status"`
+	OrderStatus     domain.PaymentStatus `json:"order_status"`
 	OrderAmount     float64   `json:"order_amount"`
 	OrderCurrency   string    `json:"order_currency"`
 	OrderExpiryTime time.Time `json:"order_expiry_time"`
 	PaymentLink     string    `json:"payment_link"`
+	PaymentSessionID string   `json:"payment_session_id,omitempty"`
+	CustomerDetails *CustomerDetails `json:"customer_details,omitempty"`
+	OrderNote       string    `json:"order_note,omitempty"`
+	OrderTags       map[string]string `json:"order_tags,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CashfreeUPIIntentResponse represents a UPI intent payment started by Cashfree
+type CashfreeUPIIntentResponse struct {
+	CFPaymentID   string `json:"cf_payment_id"`
+	PaymentMethod string `json:"payment_method"`
+	Channel       string `json:"channel"`
+	Action        string `json:"action"`
+	Data          struct {
+		// Payload maps "default" to the generic upi:// URI and app names such as gpay,
+		// phonepe, paytm and bhim to the app's own deep link
+		Payload map[string]string `json:"payload"`
+	} `json:"data"`
 }
 
 // CashfreeRefundRequest represents refund request
@@ -278,6 +548,8 @@ type CashfreeRefundRequest struct {
 	RefundAmount float64 `json:"refund_amount"`
 	RefundID     string  `json:"refund_id"`
 	RefundNote   string  `json:"refund_note,omitempty"`
+	RefundSpeed  string  `json:"refund_speed,omitempty"` // STANDARD or INSTANT
+	RefundSplits []CashfreeRefundSplit `json:"refund_splits,omitempty"`
 }
 
 // CashfreeRefundResponse represents refund response
@@ -286,10 +558,24 @@ type CashfreeRefundResponse struct {
 	RefundID      string  `json:"refund_id"`
 	OrderID       string  `json:"order_id"`
 	RefundAmount  float64 `json:"refund_amount"`
-	RefundStatus  string  `json:"refund_status"`
+	RefundStatus  domain.RefundStatus `json:"refund_status"`
 	RefundMode    string  `json:"refund_mode"`
+	RefundARN     string  `json:"refund_arn,omitempty"`
+	RefundSpeed   *CashfreeRefundSpeed `json:"refund_speed,omitempty"`
 	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
 	RefundNote    string  `json:"refund_note,omitempty"`
+	RefundType    string  `json:"refund_type,omitempty"` // MERCHANT_INITIATED or PAYMENT_AUTO_REFUND
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+}
+
+// CashfreeRefundSpeed reports the speed a refund was requested at and the speed
+// Cashfree accepted and processed it at. An instant refund Cashfree cannot make is
+// accepted as STANDARD, with the reason in Message.
+type CashfreeRefundSpeed struct {
+	Requested string `json:"requested,omitempty"`
+	Accepted  string `json:"accepted,omitempty"`
+	Processed string `json:"processed,omitempty"`
+	Message   string `json:"message,omitempty"`
 }
 
 // CashfreeSettlementRequest represents settlement request
@@ -309,10 +595,40 @@ type CashfreeSettlementResponse struct {
 	CFSettlementID string    `json:"cf_settlement_id"`
 	SettlementID   string    `json:"settlement_id"`
 	OrderID        string    `json:"order_id"`
-	SettlementStatus string  `json:"settlement_status"`
+	SettlementStatus domain.SettlementStatus `json:"settlement_status"`
 	Splits         []CashfreeSettlementSplit `json:"splits"`
 }
 
+// CashfreeReconRequest represents a reconciliation events request
+type CashfreeReconRequest struct {
+	Pagination struct {
+		Limit  int     `json:"limit"`
+		Cursor *string `json:"cursor"`
+	} `json:"pagination"`
+	Filters struct {
+		StartDate string `json:"start_date"`
+		EndDate   string `json:"end_date"`
+	} `json:"filters"`
+}
+
+// CashfreeReconResponse represents a page of reconciliation events. Cursor is empty on
+// the last page.
+type CashfreeReconResponse struct {
+	Cursor string               `json:"cursor"`
+	Limit  int                  `json:"limit"`
+	Data   []CashfreeReconEvent `json:"data"`
+}
+
+// CashfreeReconEvent represents a reconciled payment, refund or settlement event
+type CashfreeReconEvent struct {
+	EventType     string  `json:"event_type"` // PAYMENT, REFUND, ...
+	EventStatus   string  `json:"event_status"`
+	EventAmount   float64 `json:"event_amount"`
+	EventCurrency string  `json:"event_currency"`
+	OrderID       string  `json:"order_id"`
+	OrderAmount   float64 `json:"order_amount"`
+}
+
 // WebhookData represents webhook payload
 type WebhookData struct {
 	Type      string                 `json:"type"`
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/domain"
+)
+
+// newFakeCashfree starts a fake Cashfree API and a client pointed at it
+func newFakeCashfree(t testing.TB) (*cashfreetest.Server, *CashfreeClient) {
+	server := cashfreetest.NewServer("test_client", "test_secret")
+	t.Cleanup(server.Close)
+
+	client := NewCashfreeClient(server.ClientID, server.ClientSecret, "TEST")
+	client.BaseURL = server.URL
+	client.Client.SetRetryCount(0)
+	return server, client
+}
+
+func testOrderRequest(orderID string) CreateOrderRequest {
+	return CreateOrderRequest{
+		OrderID:       orderID,
+		OrderAmount:   499.5,
+		OrderCurrency: "INR",
+		CustomerDetails: CustomerDetails{
+			CustomerID:    "cust_1",
+			CustomerName:  "John Doe",
+			CustomerEmail: "john@example.com",
+			CustomerPhone: "9999999999",
+		},
+		OrderMeta:       &OrderMeta{ReturnURL: "https://shop.example.com/return"},
+		OrderExpiryTime: time.Now().Add(time.Hour).Format(time.RFC3339),
+		OrderTags:       map[string]string{"theme_color": "#1A73E8"},
+	}
+}
+
+func TestCashfreeClientOrderLifecycle(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	order, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	assert.Equal(t, "order_1", order.OrderID)
+	assert.Equal(t, domain.PaymentActive, order.OrderStatus)
+	assert.NotEmpty(t, order.CFOrderID)
+	assert.NotEmpty(t, order.PaymentLink)
+
+	stored, found := server.Order("order_1")
+	require.True(t, found)
+	assert.Equal(t, "#1A73E8", stored.Tags["theme_color"])
+
+	_, err = client.CreateOrder(testOrderRequest("order_1"))
+	assert.ErrorContains(t, err, "status 409")
+
+	_, err = client.GetPayments("order_1")
+	assert.ErrorContains(t, err, "no payments found")
+
+	_, err = server.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+
+	status, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, status.OrderStatus)
+	assert.Equal(t, 499.5, status.OrderAmount)
+	require.NotNil(t, status.CustomerDetails)
+	assert.Equal(t, "john@example.com", status.CustomerDetails.CustomerEmail)
+	assert.False(t, status.CreatedAt.IsZero())
+
+	payment, err := client.GetPayments("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", payment.PaymentStatus)
+	assert.Equal(t, "upi", payment.PaymentMethod)
+	assert.Equal(t, 499.5, payment.PaymentAmount)
+
+	byID, err := client.GetPayment(payment.CFPaymentID)
+	require.NoError(t, err)
+	assert.Equal(t, "order_1", byID.OrderID)
+	assert.Equal(t, "SUCCESS", byID.PaymentStatus)
+
+	refund, err := client.RefundPayment(CashfreeRefundRequest{OrderID: "order_1", RefundID: "refund_1", RefundAmount: 100, RefundSpeed: RefundSpeedInstant})
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundPending, refund.RefundStatus)
+	assert.Equal(t, RefundSpeedInstant, refund.RefundSpeed.Requested)
+	assert.Equal(t, RefundSpeedInstant, refundMode(refund))
+
+	_, err = client.RefundPayment(CashfreeRefundRequest{OrderID: "order_1", RefundID: "refund_2", RefundAmount: 400})
+	assert.ErrorContains(t, err, "status 400")
+	_, err = client.RefundPayment(CashfreeRefundRequest{
+		OrderID: "order_1", RefundID: "refund_3", RefundAmount: 10,
+		RefundSplits: []CashfreeRefundSplit{{VendorID: "vendor_1", Amount: 11}},
+	})
+	assert.ErrorContains(t, err, "status 400")
+	_, err = client.RefundPayment(CashfreeRefundRequest{
+		OrderID: "order_1", RefundID: "refund_4", RefundAmount: 10,
+		RefundSplits: []CashfreeRefundSplit{{VendorID: "vendor_1", Amount: 10}},
+	})
+	require.NoError(t, err)
+	stored, found = server.Order("order_1")
+	require.True(t, found)
+	require.Len(t, stored.Refunds[1].Splits, 1)
+	assert.Equal(t, 10.0, *stored.Refunds[1].Splits[0].Amount)
+
+	_, err = server.ProcessRefund("order_1", "refund_1", "SUCCESS")
+	require.NoError(t, err)
+	refund, err = client.GetRefundStatus("order_1", "refund_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundSuccess, refund.RefundStatus)
+	assert.NotNil(t, refund.ProcessedAt)
+	assert.NotEmpty(t, refund.RefundARN)
+
+	refunds, err := client.ListRefunds("order_1")
+	require.NoError(t, err)
+	require.Len(t, refunds, 2)
+	assert.Equal(t, "refund_1", refunds[0].RefundID)
+	assert.NotNil(t, refunds[0].CreatedAt)
+
+	amount := 300.0
+	settlement, err := client.CreateSettlement(CashfreeSettlementRequest{
+		OrderID: "order_1",
+		Splits:  []CashfreeSettlementSplit{{VendorID: "vendor_1", Amount: &amount}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, domain.SettlementPending, settlement.SettlementStatus)
+	assert.Len(t, settlement.Splits, 1)
+
+	// Paid orders can no longer be cancelled
+	assert.Error(t, client.CancelOrder("order_1"))
+}
+
+func TestCashfreeClientCancelOrder(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	require.NoError(t, client.CancelOrder("order_1"))
+
+	status, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentTerminated, status.OrderStatus)
+
+	_, err = server.CompletePayment("order_1", "upi")
+	assert.Error(t, err)
+
+	_, err = client.GetOrderStatus("order_missing")
+	assert.ErrorContains(t, err, "status 404")
+}
+
+func TestCashfreeClientErrors(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	require.NoError(t, client.ValidateCredentials())
+
+	wrong := NewCashfreeClient(server.ClientID, "wrong_secret", "TEST")
+	wrong.BaseURL = server.URL
+	assert.ErrorIs(t, wrong.ValidateCredentials(), ErrInvalidCredentials)
+
+	server.FailNext(http.StatusBadGateway)
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	assert.ErrorContains(t, err, "status 502")
+
+	// 5xx responses count towards opening the circuit breaker
+	server.FailNext(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+	for i := 0; i < 4; i++ {
+		client.GetOrderStatus("order_1")
+	}
+	_, err = client.GetOrderStatus("order_1")
+	assert.ErrorContains(t, err, ErrCircuitOpen.Error())
+}
+
+func TestCashfreeClientTypedErrors(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	_, err = client.CreateOrder(testOrderRequest("order_1"))
+	assert.ErrorIs(t, err, ErrDuplicateRequest)
+
+	_, err = client.GetOrderStatus("order_missing")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+	var cfErr *CashfreeError
+	require.ErrorAs(t, err, &cfErr)
+	assert.Equal(t, http.StatusNotFound, cfErr.StatusCode)
+	assert.Equal(t, "invalid_request_error", cfErr.Type)
+	assert.Equal(t, "order not found", cfErr.Message)
+
+	_, err = client.RefundPayment(CashfreeRefundRequest{OrderID: "order_1", RefundID: "refund_1", RefundAmount: 100})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = client.GetRefundStatus("order_1", "refund_missing")
+	assert.ErrorIs(t, err, ErrRefundNotFound)
+	_, err = client.GetPayment("cf_payment_missing")
+	assert.ErrorIs(t, err, ErrPaymentNotFound)
+
+	server.FailNext(http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	_, err = client.GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, ErrRateLimited)
+	_, err = client.GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, ErrCashfreeUnavailable)
+}
+
+func TestCashfreeErrorClassification(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		kind   error
+		want   string
+	}{
+		{http.StatusBadRequest, `{"message":"insufficient balance to process refund","code":"insufficient_balance","type":"invalid_request_error"}`, ErrInsufficientBalance, "cashfree API returned status 400: insufficient_balance: insufficient balance to process refund"},
+		{http.StatusNotFound, `{"message":"order not found for the given order id","code":"order_not_found","type":"invalid_request_error"}`, ErrOrderNotFound, "cashfree API returned status 404: order_not_found: order not found for the given order id"},
+		{http.StatusNotFound, `{"message":"payment not found","code":"payment_not_found","type":"invalid_request_error"}`, ErrPaymentNotFound, "cashfree API returned status 404: payment_not_found: payment not found"},
+		{http.StatusBadRequest, `{"message":"too many requests","code":"request_failed","type":"rate_limit_error"}`, ErrRateLimited, "cashfree API returned status 400: request_failed: too many requests"},
+		{http.StatusConflict, `{"message":"refund with same id is already present","code":"refund_already_exists","type":"invalid_request_error"}`, ErrDuplicateRequest, "cashfree API returned status 409: refund_already_exists: refund with same id is already present"},
+		{http.StatusForbidden, `{"message":"authentication Failed","code":"request_failed","type":"authentication_error"}`, ErrInvalidCredentials, "cashfree API returned status 403: request_failed: authentication Failed"},
+		{http.StatusBadGateway, "<html>Bad Gateway</html>", ErrCashfreeUnavailable, "cashfree API returned status 502: <html>Bad Gateway</html>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewCashfreeClient("client_id", "client_secret", "TEST")
+			client.BaseURL = server.URL
+			client.Client.SetRetryCount(0)
+
+			_, err := client.GetOrderStatus("order_1")
+			assert.ErrorIs(t, err, tt.kind)
+			assert.EqualError(t, err, tt.want)
+		})
+	}
+}
+
+func TestRespondGatewayError(t *testing.T) {
+	cashfreeErr := func(status int, code, message string) error {
+		err := &CashfreeError{StatusCode: status, Code: code, Message: message}
+		err.kind = err.classify()
+		return fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{cashfreeErr(404, "order_not_found", "order not found"), http.StatusNotFound, `{"error":"Order not found","reason":"order not found","upstream_code":"order_not_found"}`},
+		{cashfreeErr(400, "insufficient_balance", "insufficient balance"), http.StatusUnprocessableEntity, `{"error":"Insufficient balance","reason":"insufficient balance","upstream_code":"insufficient_balance"}`},
+		{cashfreeErr(429, "", "too many requests"), http.StatusTooManyRequests, `{"error":"Payment gateway rate limit exceeded","reason":"too many requests"}`},
+		{cashfreeErr(409, "order_already_exists", "order with same id is already present"), http.StatusConflict, `{"error":"Failed to create refund","reason":"order with same id is already present","upstream_code":"order_already_exists"}`},
+		{cashfreeErr(400, "refund_request_invalid", "order is not paid"), http.StatusUnprocessableEntity, `{"error":"Failed to create refund","reason":"order is not paid","upstream_code":"refund_request_invalid"}`},
+		{cashfreeErr(500, "", "internal error"), http.StatusBadGateway, `{"error":"Failed to create refund"}`},
+		{cashfreeErr(401, "", "authentication Failed"), http.StatusInternalServerError, `{"error":"Failed to create refund"}`},
+		{fmt.Errorf("failed to create refund: %w", ErrCircuitOpen), http.StatusServiceUnavailable, `{"error":"Failed to create refund"}`},
+		{fmt.Errorf("no payments found for order order_1"), http.StatusInternalServerError, `{"error":"Failed to create refund"}`},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		respondGatewayError(c, tt.err, "Failed to create refund")
+		assert.Equal(t, tt.status, w.Code, tt.err.Error())
+		assert.JSONEq(t, tt.body, w.Body.String(), tt.err.Error())
+	}
+}
+
+func TestCreatePaymentSessionReportsDuplicateOrder(t *testing.T) {
+	_, client := newFakeCashfree(t)
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+
+	handler := &PaymentHandler{
+		cashfree:          client,
+		repo:              NewPaymentRepository(testDB(t)),
+		gateways:          NewGatewayRouter(client, nil, false),
+		environments:      map[string]*CashfreeClient{EnvironmentTest: client},
+		orderURLs:         OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+		duplicateSessions: DuplicateSessionsReject,
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(`{
+		"order_id": "order_1", "amount": 250, "currency": "INR", "customer_id": "cust_1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "9999999999", "notify_url": "https://shop.example.com/notify"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"Failed to create payment session","reason":"order with same id is already present","upstream_code":"request_failed"}`, w.Body.String())
+}
+
+func TestCashfreeWebhookSignatures(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	webhook, err := server.CompletePayment("order_1", "card")
+	require.NoError(t, err)
+
+	assert.True(t, client.VerifyWebhookSignature(webhook.Signature, webhook.Timestamp, string(webhook.Body)))
+	assert.False(t, client.VerifyWebhookSignature(webhook.Signature, webhook.Timestamp, string(webhook.Body)+" "))
+
+	var data WebhookData
+	require.NoError(t, json.Unmarshal(webhook.Body, &data))
+	assert.Equal(t, "PAYMENT_SUCCESS_WEBHOOK", data.Type)
+	assert.Equal(t, "order_1", data.Data["order_id"])
+}
+
+func TestPaymentFlowAgainstFakeCashfree(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		orderURLs:    OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(req)
+	}
+
+	orderID := fmt.Sprintf("order_fake_%d", time.Now().UnixNano())
+	w := post("/payments/create-session", fmt.Sprintf(`{
+		"order_id": %q, "amount": 250, "currency": "INR", "customer_id": "cust_1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "9999999999", "notify_url": "https://shop.example.com/notify"
+	}`, orderID))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	order, ok := server.Order(orderID)
+	require.True(t, ok)
+	assert.Equal(t, "https://shop.example.com/return?order_id="+orderID, order.ReturnURL)
+
+	webhook, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	w = serve(webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	payment, err := handler.repo.GetPaymentByOrderID(context.Background(), orderID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentSuccess, payment.Status)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+
+	w = post("/payments/"+orderID+"/refund", `{"amount": 50, "reason": "damaged"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	order, _ = server.Order(orderID)
+	require.Len(t, order.Refunds, 1)
+	assert.Equal(t, 50.0, order.Refunds[0].Amount)
+
+	// Tampered webhooks are rejected
+	webhook.Signature = cashfreetest.Sign("other_secret", webhook.Timestamp, webhook.Body)
+	assert.Equal(t, http.StatusUnauthorized, serve(webhook.Request("/webhook/cashfree")).Code)
+}
+
+func TestGetPaymentByCFPaymentID(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	get := func(cfPaymentID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments/by-cf-payment/"+cfPaymentID, nil))
+		return w
+	}
+
+	orderID := fmt.Sprintf("order_cf_lookup_%d", time.Now().UnixNano())
+	payment := testPayment(orderID)
+	payment.Amount = 499.5
+	require.NoError(t, handler.repo.CreatePayment(context.Background(), payment))
+	_, err := client.CreateOrder(testOrderRequest(orderID))
+	require.NoError(t, err)
+	webhook, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	order, _ := server.Order(orderID)
+	cfPaymentID := order.Payments[0].CFPaymentID
+
+	// Before the webhook records the ID, Cashfree says which order it paid
+	w := get(cfPaymentID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"order_id":"`+orderID+`"`)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = get(cfPaymentID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"cf_payment_id":"`+cfPaymentID+`"`)
+
+	w = get("cf_payment_missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Payment not found")
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+// The contract tests call the real Cashfree sandbox to catch changes in the API that the
+// fake in cashfreetest would hide, such as fields the client decodes being renamed or
+// changing type. They run only when CASHFREE_SANDBOX_CLIENT_ID and
+// CASHFREE_SANDBOX_CLIENT_SECRET are set.
+//
+// Webhooks are checked too when CASHFREE_CONTRACT_NOTIFY_URL is a public URL (a tunnel,
+// for example) forwarding to CASHFREE_CONTRACT_WEBHOOK_ADDR, default 127.0.0.1:8099. Set
+// CASHFREE_CONTRACT_CAPTURE_DIR to testdata/webhooks to save the payloads received as
+// golden test inputs.
+
+// sandboxPaymentTimeout bounds how long a simulated payment may take to complete
+const sandboxPaymentTimeout = 2 * time.Minute
+
+// sandboxClient returns a client for the Cashfree sandbox, skipping the test without
+// sandbox credentials
+func sandboxClient(t *testing.T) *CashfreeClient {
+	clientID := os.Getenv("CASHFREE_SANDBOX_CLIENT_ID")
+	clientSecret := os.Getenv("CASHFREE_SANDBOX_CLIENT_SECRET")
+	if clientID == "" || clientSecret == "" {
+		t.Skip("CASHFREE_SANDBOX_CLIENT_ID and CASHFREE_SANDBOX_CLIENT_SECRET are not set")
+	}
+
+	client := NewCashfreeClient(clientID, clientSecret, "TEST")
+	client.Client.SetRetryCount(0)
+	return client
+}
+
+// sandboxCall makes a raw API call and returns the decoded response, with numbers kept
+// as json.Number, so the test sees exactly what Cashfree sent
+func sandboxCall(t *testing.T, client *CashfreeClient, method, path string, body interface{}) interface{} {
+	t.Helper()
+
+	req := client.Client.R().SetHeaders(client.getAuthHeaders())
+	if body != nil {
+		req.SetBody(body)
+	}
+	resp, err := req.Execute(method, client.BaseURL+path)
+	require.NoError(t, err, "%s %s", method, path)
+	require.True(t, resp.IsSuccess(), "%s %s returned status %d: %s", method, path, resp.StatusCode(), resp.String())
+
+	decoder := json.NewDecoder(bytes.NewReader(resp.Body()))
+	decoder.UseNumber()
+	var decoded interface{}
+	require.NoError(t, decoder.Decode(&decoded), "%s %s", method, path)
+	return decoded
+}
+
+// assertContract checks that a response object has every field v's type decodes, with a
+// compatible JSON type. Fields tagged omitempty may be absent.
+func assertContract(t *testing.T, name string, response interface{}, v interface{}) {
+	t.Helper()
+
+	object, ok := response.(map[string]interface{})
+	if !assert.True(t, ok, "%s: response is %T, want an object", name, response) {
+		return
+	}
+
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		key, options, _ := strings.Cut(tag, ",")
+		if key == "-" || key == "" {
+			continue
+		}
+
+		value, present := object[key]
+		if !present {
+			assert.True(t, options == "omitempty", "%s: field %s is missing", name, key)
+			continue
+		}
+		if problem := contractMismatch(field.Type, value); problem != "" {
+			t.Errorf("%s: field %s %s", name, key, problem)
+		}
+	}
+}
+
+// contractMismatch describes how a JSON value cannot be decoded into typ, or returns ""
+func contractMismatch(typ reflect.Type, value interface{}) string {
+	if value == nil {
+		if typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Map || typ.Kind() == reflect.Slice {
+			return ""
+		}
+		return fmt.Sprintf("is null, want %s", typ)
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ == reflect.TypeOf(time.Time{}) {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("is %T, want an RFC 3339 time", value)
+		}
+		if _, err := time.Parse(time.RFC3339, s); err != nil {
+			return fmt.Sprintf("is %q, want an RFC 3339 time", s)
+		}
+		return ""
+	}
+
+	switch typ.Kind() {
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return fmt.Sprintf("is %T %v, want a string", value, value)
+		}
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int32, reflect.Int64:
+		if _, ok := value.(json.Number); !ok {
+			return fmt.Sprintf("is %T %v, want a number", value, value)
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Sprintf("is %T %v, want a boolean", value, value)
+		}
+	case reflect.Slice:
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Sprintf("is %T, want an array", value)
+		}
+	case reflect.Map, reflect.Struct:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Sprintf("is %T, want an object", value)
+		}
+	}
+	return ""
+}
+
+// contractString returns a string or number field of a response object as a string
+func contractString(response interface{}, key string) string {
+	object, _ := response.(map[string]interface{})
+	switch value := object[key].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	}
+	return ""
+}
+
+// sandboxWebhooks receives the sandbox's webhook deliveries, verified with the client's
+// secret, or returns a nil channel when CASHFREE_CONTRACT_NOTIFY_URL is not set
+func sandboxWebhooks(t *testing.T, client *CashfreeClient) (notifyURL string, webhooks <-chan WebhookData) {
+	notifyURL = os.Getenv("CASHFREE_CONTRACT_NOTIFY_URL")
+	if notifyURL == "" {
+		return "", nil
+	}
+	addr := os.Getenv("CASHFREE_CONTRACT_WEBHOOK_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8099"
+	}
+
+	received := make(chan WebhookData, 16)
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			signature := r.Header.Get("x-webhook-signature")
+			timestamp := r.Header.Get("x-webhook-timestamp")
+			if !client.VerifyWebhookSignature(signature, timestamp, string(body)) {
+				t.Errorf("webhook signature did not verify: %s", body)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			var webhook WebhookData
+			if err := json.Unmarshal(body, &webhook); err != nil {
+				t.Errorf("webhook is not a WebhookData: %v: %s", err, body)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if dir := os.Getenv("CASHFREE_CONTRACT_CAPTURE_DIR"); dir != "" {
+				captureWebhook(t, dir, webhook.Type, r.Header.Get("x-webhook-version"), body)
+			}
+			received <- webhook
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	go server.ListenAndServe()
+	t.Cleanup(func() { server.Close() })
+
+	return notifyURL, received
+}
+
+// captureWebhook saves a webhook payload as <type>_<version>.json, indented like the
+// other golden test inputs
+func captureWebhook(t *testing.T, dir, webhookType, version string, body []byte) {
+	if version == "" {
+		version = "unversioned"
+	}
+	name := strings.ToLower(strings.TrimSuffix(webhookType, "_WEBHOOK")) + "_" + version + ".json"
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		t.Errorf("capturing %s: %v", name, err)
+		return
+	}
+	indented.WriteByte('\n')
+	if err := os.WriteFile(filepath.Join(dir, name), indented.Bytes(), 0o644); err != nil {
+		t.Errorf("capturing %s: %v", name, err)
+	}
+}
+
+func TestCashfreeSandboxContract(t *testing.T) {
+	client := sandboxClient(t)
+	notifyURL, webhooks := sandboxWebhooks(t, client)
+
+	orderID := "contract_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	request := testOrderRequest(orderID)
+	request.OrderAmount = 10
+	request.OrderTags = nil
+	if notifyURL != "" {
+		request.OrderMeta.NotifyURL = notifyURL
+	}
+
+	// Create the order
+	created := sandboxCall(t, client, http.MethodPost, "/orders", request)
+	assertContract(t, "create order", created, CashfreeOrderResponse{})
+	assert.Equal(t, orderID, contractString(created, "order_id"))
+	assert.Equal(t, "ACTIVE", contractString(created, "order_status"))
+
+	order := sandboxCall(t, client, http.MethodGet, "/orders/"+orderID, nil)
+	assertContract(t, "get order", order, CashfreeOrderStatusResponse{})
+
+	status, err := client.GetOrderStatus(orderID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentActive, status.OrderStatus)
+	assert.Equal(t, 10.0, status.OrderAmount)
+
+	// Pay it by UPI collect and have the sandbox succeed the payment
+	sessionID := contractString(created, "payment_session_id")
+	require.NotEmpty(t, sessionID, "create order returned no payment_session_id")
+	paid := sandboxCall(t, client, http.MethodPost, "/orders/sessions", map[string]interface{}{
+		"payment_session_id": sessionID,
+		"payment_method": map[string]interface{}{
+			"upi": map[string]interface{}{"channel": "collect", "upi_id": "testsuccess@gocash"},
+		},
+	})
+	cfPaymentID := contractString(paid, "cf_payment_id")
+	require.NotEmpty(t, cfPaymentID, "order pay returned no cf_payment_id")
+
+	sandboxCall(t, client, http.MethodPost, "/simulate", map[string]interface{}{
+		"entity":            "PAYMENTS",
+		"entity_id":         cfPaymentID,
+		"entity_simulation": map[string]interface{}{"payment_status": "SUCCESS"},
+	})
+
+	deadline := time.Now().Add(sandboxPaymentTimeout)
+	for {
+		status, err = client.GetOrderStatus(orderID)
+		require.NoError(t, err)
+		if status.OrderStatus == "PAID" || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Second)
+	}
+	require.Equal(t, domain.PaymentPaid, status.OrderStatus)
+
+	// Payments
+	payments, ok := sandboxCall(t, client, http.MethodGet, "/orders/"+orderID+"/payments", nil).([]interface{})
+	require.True(t, ok, "get payments did not return an array")
+	require.NotEmpty(t, payments)
+	assertContract(t, "get payments", payments[0], CashfreePaymentResponse{})
+
+	payment, err := client.GetPayments(orderID)
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", payment.PaymentStatus)
+	assert.Equal(t, cfPaymentID, payment.CFPaymentID)
+
+	// Refund part of it
+	refundID := "refund_" + orderID
+	refund, err := client.RefundPayment(CashfreeRefundRequest{OrderID: orderID, RefundAmount: 4, RefundID: refundID, RefundNote: "contract test"})
+	require.NoError(t, err)
+	assert.Equal(t, refundID, refund.RefundID)
+	assert.Equal(t, 4.0, refund.RefundAmount)
+
+	fetched := sandboxCall(t, client, http.MethodGet, "/orders/"+orderID+"/refunds/"+refundID, nil)
+	assertContract(t, "get refund", fetched, CashfreeRefundResponse{})
+
+	_, err = client.GetRefundStatus(orderID, refundID)
+	require.NoError(t, err)
+
+	// The payment webhook carries what handlePaymentSuccessWebhook reads
+	if webhooks == nil {
+		t.Log("CASHFREE_CONTRACT_NOTIFY_URL is not set; skipping webhook checks")
+		return
+	}
+	timeout := time.After(sandboxPaymentTimeout)
+	for {
+		select {
+		case webhook := <-webhooks:
+			if webhook.Type != "PAYMENT_SUCCESS_WEBHOOK" {
+				continue
+			}
+			parsed, err := parsePaymentWebhook(webhook.Data)
+			require.NoError(t, err, "webhook data: %v", webhook.Data)
+			assert.Equal(t, orderID, parsed.OrderID)
+			assert.Equal(t, cfPaymentID, parsed.CFPaymentID)
+			assert.Equal(t, "upi", parsed.PaymentMethod)
+			assert.NotNil(t, parsed.PaymentTime, "webhook payment_time")
+			return
+		case <-timeout:
+			t.Fatal("no PAYMENT_SUCCESS_WEBHOOK received")
+		}
+	}
+}
+
+func TestContractMismatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		typ   interface{}
+		value interface{}
+		ok    bool
+	}{
+		{"string", "", "x", true},
+		{"object for string", "", map[string]interface{}{"upi": map[string]interface{}{}}, false},
+		{"number", 0.0, json.Number("10.5"), true},
+		{"string for number", 0.0, "10.5", false},
+		{"time", time.Time{}, "2024-05-01T10:30:00+05:30", true},
+		{"bad time", time.Time{}, "01/05/2024", false},
+		{"null pointer", (*time.Time)(nil), nil, true},
+		{"null string", "", nil, false},
+		{"array", []CashfreeSettlementSplit{}, []interface{}{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := contractMismatch(reflect.TypeOf(tt.typ), tt.value)
+			assert.Equal(t, tt.ok, problem == "", problem)
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-resty/resty/v2"
+)
+
+// Kinds of Cashfree API error. A *CashfreeError wraps one of these, so callers can
+// test for them with errors.Is.
+var (
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrRefundNotFound      = errors.New("refund not found")
+	ErrPaymentNotFound     = errors.New("payment not found")
+	ErrDuplicateRequest    = errors.New("duplicate request")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrRateLimited         = errors.New("rate limited by cashfree")
+	ErrInvalidRequest      = errors.New("invalid request")
+	ErrCashfreeUnavailable = errors.New("cashfree is unavailable")
+)
+
+// CashfreeError is an error response from the Cashfree API
+type CashfreeError struct {
+	StatusCode int
+	Type       string // such as "invalid_request_error" or "rate_limit_error"
+	Code       string // such as "order_not_found"
+	Message    string
+	kind       error
+}
+
+// newCashfreeError parses the error body of resp. A body that is not Cashfree's error
+// JSON is kept as the message.
+func newCashfreeError(resp *resty.Response) *CashfreeError {
+	var body struct {
+		Message string `json:"message"`
+		Code    string `json:"code"`
+		Type    string `json:"type"`
+	}
+	if err := json.Unmarshal(resp.Body(), &body); err != nil || body.Message == "" && body.Code == "" {
+		body.Message = strings.TrimSpace(resp.String())
+	}
+
+	e := &CashfreeError{
+		StatusCode: resp.StatusCode(),
+		Type:       body.Type,
+		Code:       body.Code,
+		Message:    body.Message,
+	}
+	e.kind = e.classify()
+	return e
+}
+
+// classify picks the kind of error from the status, type, code and message
+func (e *CashfreeError) classify() error {
+	message := strings.ToLower(e.Message)
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests || e.Type == "rate_limit_error":
+		return ErrRateLimited
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrInvalidCredentials
+	case e.StatusCode >= 500:
+		return ErrCashfreeUnavailable
+	case strings.Contains(e.Code, "insufficient_balance") || strings.Contains(message, "insufficient balance"):
+		return ErrInsufficientBalance
+	case e.Code == "refund_not_found" || e.StatusCode == http.StatusNotFound && strings.Contains(message, "refund"):
+		return ErrRefundNotFound
+	case e.Code == "payment_not_found" || e.StatusCode == http.StatusNotFound && strings.Contains(message, "payment"):
+		return ErrPaymentNotFound
+	case e.Code == "order_not_found" || e.StatusCode == http.StatusNotFound:
+		return ErrOrderNotFound
+	case e.StatusCode == http.StatusConflict || strings.HasSuffix(e.Code, "_already_exists"):
+		return ErrDuplicateRequest
+	default:
+		return ErrInvalidRequest
+	}
+}
+
+func (e *CashfreeError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("cashfree API returned status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("cashfree API returned status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap returns the kind of error, such as ErrOrderNotFound
+func (e *CashfreeError) Unwrap() error {
+	return e.kind
+}
+
+// respondGatewayError writes the response to a failed payment gateway call: Cashfree's
+// client errors keep their meaning, with its message as the reason and its error code
+// as upstream_code, a request the client would not send is a 422 listing its fields,
+// an unavailable gateway is 502 or 503, and anything else is a 500 with message.
+func respondGatewayError(c *gin.Context, err error, message string) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		status, message = http.StatusNotFound, "Order not found"
+	case errors.Is(err, ErrRefundNotFound):
+		status, message = http.StatusNotFound, "Refund not found"
+	case errors.Is(err, ErrPaymentNotFound):
+		status, message = http.StatusNotFound, "Payment not found"
+	case errors.Is(err, ErrInsufficientBalance):
+		status, message = http.StatusUnprocessableEntity, "Insufficient balance"
+	case errors.Is(err, ErrRateLimited):
+		status, message = http.StatusTooManyRequests, "Payment gateway rate limit exceeded"
+	case errors.Is(err, ErrDuplicateRequest):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidRequest):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, ErrCircuitOpen):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrCashfreeUnavailable):
+		status = http.StatusBadGateway
+	}
+
+	response := gin.H{"error": message}
+	var cfErr *CashfreeError
+	if errors.As(err, &cfErr) && status < http.StatusInternalServerError {
+		response["reason"] = cfErr.Message
+		if cfErr.Code != "" {
+			response["upstream_code"] = cfErr.Code
+		}
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		response["fields"] = validationErr.Fields
+	}
+	c.JSON(status, response)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/google/uuid"
+)
+
+// Operations of the Cashfree client, as interceptors see them
+const (
+	CashfreeOpCreateOrder         = "create_order"
+	CashfreeOpGetOrder            = "get_order"
+	CashfreeOpGetPayments         = "get_payments"
+	CashfreeOpGetPayment          = "get_payment"
+	CashfreeOpCreateRefund        = "create_refund"
+	CashfreeOpGetRefund           = "get_refund"
+	CashfreeOpListRefunds         = "list_refunds"
+	CashfreeOpCancelOrder         = "cancel_order"
+	CashfreeOpTerminateOrder      = "terminate_order"
+	CashfreeOpCreateSettlement    = "create_settlement"
+	CashfreeOpGetReconEvents      = "get_recon_events"
+	CashfreeOpValidateCredentials = "validate_credentials"
+	CashfreeOpPayOrder            = "pay_order"
+)
+
+// CashfreeRequest is an attempt at a Cashfree API call, as interceptors see it
+type CashfreeRequest struct {
+	Operation string // such as CashfreeOpCreateOrder
+	Method    string
+	URL       string
+	Header    http.Header // sent with the request; request hooks may change it
+	Attempt   int         // 1 for the first attempt, higher for resty's retries
+}
+
+// CashfreeResponse is the outcome of a Cashfree API call attempt
+type CashfreeResponse struct {
+	StatusCode int // 0 when the call failed without a response
+	Duration   time.Duration
+	Err        error // why the call failed without a response, such as ErrCircuitOpen
+}
+
+// CashfreeRequestHook runs before each attempt at a call. An error stops the call and is
+// returned by the client method.
+type CashfreeRequestHook func(ctx context.Context, req *CashfreeRequest) error
+
+// CashfreeResponseHook runs after each attempt that got a response, and once for a call
+// that failed without one
+type CashfreeResponseHook func(ctx context.Context, req *CashfreeRequest, resp *CashfreeResponse)
+
+// cashfreeOperationKey carries a call's operation in its request context
+type cashfreeOperationKey struct{}
+
+// OnBeforeRequest adds a hook run before every call, after the hooks added before it.
+// Hooks are added while setting the client up, not while it is in use.
+func (c *CashfreeClient) OnBeforeRequest(hook CashfreeRequestHook) {
+	c.requestHooks = append(c.requestHooks, hook)
+}
+
+// OnAfterResponse adds a hook run after every call, after the hooks added before it.
+// Hooks are added while setting the client up, not while it is in use.
+func (c *CashfreeClient) OnAfterResponse(hook CashfreeResponseHook) {
+	c.responseHooks = append(c.responseHooks, hook)
+}
+
+// request starts a call of an operation, with the authentication headers and each
+// attempt limited to the client's timeout
+func (c *CashfreeClient) request(operation string) *resty.Request {
+	ctx := context.WithValue(context.Background(), cashfreeOperationKey{}, operation)
+	ctx = context.WithValue(ctx, cashfreeAttemptKey{}, &attemptTimer{timeout: c.callTimeout()})
+	return c.Client.R().
+		SetContext(ctx).
+		SetHeaders(c.getAuthHeaders())
+}
+
+// installHooks runs the client's hooks from resty's middleware, after the circuit
+// breaker's
+func (c *CashfreeClient) installHooks() {
+	c.Client.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		req := cashfreeRequest(r)
+		for _, hook := range c.requestHooks {
+			if err := hook(r.Context(), req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	c.Client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		c.runResponseHooks(resp.Request, &CashfreeResponse{
+			StatusCode: resp.StatusCode(),
+			Duration:   resp.Time(),
+		})
+		return nil
+	})
+	c.Client.OnError(func(r *resty.Request, err error) {
+		// Calls that got a response have been seen by the response hooks already
+		var respErr *resty.ResponseError
+		if errors.As(err, &respErr) {
+			return
+		}
+		resp := &CashfreeResponse{Err: err}
+		if !r.Time.IsZero() {
+			resp.Duration = time.Since(r.Time)
+		}
+		c.runResponseHooks(r, resp)
+	})
+}
+
+func (c *CashfreeClient) runResponseHooks(r *resty.Request, resp *CashfreeResponse) {
+	if len(c.responseHooks) == 0 {
+		return
+	}
+	req := cashfreeRequest(r)
+	for _, hook := range c.responseHooks {
+		hook(r.Context(), req, resp)
+	}
+}
+
+func cashfreeRequest(r *resty.Request) *CashfreeRequest {
+	operation, _ := r.Context().Value(cashfreeOperationKey{}).(string)
+	return &CashfreeRequest{
+		Operation: operation,
+		Method:    r.Method,
+		URL:       r.URL,
+		Header:    r.Header,
+		Attempt:   r.Attempt,
+	}
+}
+
+// idempotentOperations are the calls that create something at Cashfree
+var idempotentOperations = map[string]bool{
+	CashfreeOpCreateOrder:      true,
+	CashfreeOpCreateRefund:     true,
+	CashfreeOpCreateSettlement: true,
+}
+
+// IdempotencyKeyHook gives each call that creates something an x-idempotency-key, kept
+// across resty's retries so Cashfree acts on a retried call only once
+func IdempotencyKeyHook(ctx context.Context, req *CashfreeRequest) error {
+	if idempotentOperations[req.Operation] && req.Header.Get("x-idempotency-key") == "" {
+		req.Header.Set("x-idempotency-key", uuid.NewString())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCashfreeClientHooks(t *testing.T) {
+	var requestIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get("X-Request-Id"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/orders/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "order not found", "code": "order_not_found"}`))
+			return
+		}
+		w.Write([]byte(`{"order_id": "order_1", "order_status": "ACTIVE"}`))
+	}))
+	defer server.Close()
+
+	client := NewCashfreeClient("id", "secret", "TEST")
+	client.BaseURL = server.URL
+	client.Client.SetRetryCount(0)
+
+	var seen []string
+	client.OnBeforeRequest(func(ctx context.Context, req *CashfreeRequest) error {
+		req.Header.Set("X-Request-Id", "req_"+req.Operation)
+		return nil
+	})
+	client.OnBeforeRequest(func(ctx context.Context, req *CashfreeRequest) error {
+		if req.Operation == CashfreeOpCancelOrder {
+			return errors.New("cancellations are paused")
+		}
+		return nil
+	})
+	var responses []CashfreeResponse
+	client.OnAfterResponse(func(ctx context.Context, req *CashfreeRequest, resp *CashfreeResponse) {
+		seen = append(seen, req.Method+" "+req.Operation)
+		responses = append(responses, *resp)
+	})
+
+	_, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	_, err = client.GetOrderStatus("missing")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+	assert.Equal(t, []string{"req_get_order", "req_get_order"}, requestIDs)
+
+	// A request hook can stop a call before it is sent
+	err = client.CancelOrder("order_1")
+	assert.EqualError(t, err, "failed to cancel order: cancellations are paused")
+	assert.Len(t, requestIDs, 2)
+
+	assert.Equal(t, []string{"GET get_order", "GET get_order", "PATCH cancel_order"}, seen)
+	require.Len(t, responses, 3)
+	assert.Equal(t, http.StatusOK, responses[0].StatusCode)
+	assert.Positive(t, responses[0].Duration)
+	assert.Equal(t, http.StatusNotFound, responses[1].StatusCode)
+	assert.Zero(t, responses[2].StatusCode)
+	assert.EqualError(t, responses[2].Err, "cancellations are paused")
+
+	// Calls the circuit breaker stops are reported too
+	for i := 0; i < 5; i++ {
+		client.Breaker.RecordFailure()
+	}
+	_, err = client.GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	require.Len(t, responses, 4)
+	assert.ErrorIs(t, responses[3].Err, ErrCircuitOpen)
+}
+
+func TestIdempotencyKeyHook(t *testing.T) {
+	ctx := context.Background()
+
+	create := &CashfreeRequest{Operation: CashfreeOpCreateRefund, Method: http.MethodPost, Header: http.Header{}}
+	require.NoError(t, IdempotencyKeyHook(ctx, create))
+	key := create.Header.Get("x-idempotency-key")
+	assert.NotEmpty(t, key)
+
+	// A retry keeps the key of the first attempt
+	create.Attempt = 2
+	require.NoError(t, IdempotencyKeyHook(ctx, create))
+	assert.Equal(t, key, create.Header.Get("x-idempotency-key"))
+
+	lookup := &CashfreeRequest{Operation: CashfreeOpGetReconEvents, Method: http.MethodPost, Header: http.Header{}}
+	require.NoError(t, IdempotencyKeyHook(ctx, lookup))
+	assert.Empty(t, lookup.Header.Get("x-idempotency-key"))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// CashfreeTimeouts limit each attempt at a Cashfree call, by how long its caller can
+// wait. Retries of an attempt that timed out get the same time again.
+type CashfreeTimeouts struct {
+	Default  time.Duration // calls that say nothing else
+	Checkout time.Duration // calls a customer waits on at checkout, such as verifying a payment
+	Batch    time.Duration // background jobs such as reconciliation, backfills and resyncs
+}
+
+// DefaultCashfreeTimeouts are the timeouts of a client made without WithTimeouts
+var DefaultCashfreeTimeouts = CashfreeTimeouts{
+	Default:  30 * time.Second,
+	Checkout: 10 * time.Second,
+	Batch:    2 * time.Minute,
+}
+
+// WithTimeouts sets the timeouts of the client's calls
+func WithTimeouts(timeouts CashfreeTimeouts) CashfreeOption {
+	return func(c *CashfreeClient) {
+		c.Timeouts = timeouts
+	}
+}
+
+// WithTimeout returns a client making the same calls as c, through the same connections,
+// circuit breaker and hooks, with each attempt limited to timeout
+func (c *CashfreeClient) WithTimeout(timeout time.Duration) *CashfreeClient {
+	limited := *c
+	limited.timeout = timeout
+	return &limited
+}
+
+// ForCheckout returns the client with the checkout timeout, for calls a customer waits on
+func (c *CashfreeClient) ForCheckout() *CashfreeClient {
+	return c.WithTimeout(c.Timeouts.Checkout)
+}
+
+// ForBatch returns the client with the batch timeout, for background jobs that can wait
+func (c *CashfreeClient) ForBatch() *CashfreeClient {
+	return c.WithTimeout(c.Timeouts.Batch)
+}
+
+// callTimeout returns the limit of each attempt at the client's calls
+func (c *CashfreeClient) callTimeout() time.Duration {
+	if c.timeout > 0 {
+		return c.timeout
+	}
+	return c.Timeouts.Default
+}
+
+// forCheckout gives a Cashfree gateway's calls the checkout timeout. Other gateways keep
+// their own.
+func forCheckout(gateway PaymentGateway) PaymentGateway {
+	if client, ok := gateway.(*CashfreeClient); ok {
+		return client.ForCheckout()
+	}
+	return gateway
+}
+
+// forBatch gives a Cashfree gateway's calls the batch timeout. Other gateways keep their
+// own.
+func forBatch(gateway PaymentGateway) PaymentGateway {
+	if client, ok := gateway.(*CashfreeClient); ok {
+		return client.ForBatch()
+	}
+	return gateway
+}
+
+// cashfreeAttemptKey carries a call's attemptTimer in its request context
+type cashfreeAttemptKey struct{}
+
+// attemptTimer limits each attempt at one call. The deadline is set on the HTTP request
+// only, not on resty's request context, so resty still retries an attempt that timed out.
+type attemptTimer struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+// start limits the attempt sending raw, ending the previous attempt's timer
+func (t *attemptTimer) start(raw *http.Request) {
+	t.stop()
+	ctx, cancel := context.WithTimeout(raw.Context(), t.timeout)
+	t.cancel = cancel
+	*raw = *raw.WithContext(ctx)
+}
+
+// stop releases the timer once the attempt's response has been read
+func (t *attemptTimer) stop() {
+	if t.cancel != nil {
+		t.cancel()
+		t.cancel = nil
+	}
+}
+
+// attemptTimerOf returns the timer of the call r belongs to, or nil
+func attemptTimerOf(r *resty.Request) *attemptTimer {
+	timer, _ := r.Context().Value(cashfreeAttemptKey{}).(*attemptTimer)
+	return timer
+}
+
+// installTimeouts times each attempt at a call. resty reads the response body before
+// its response middleware runs, so the timer is stopped there, or once the call fails.
+func (c *CashfreeClient) installTimeouts() {
+	c.Client.SetPreRequestHook(func(_ *resty.Client, raw *http.Request) error {
+		if timer, ok := raw.Context().Value(cashfreeAttemptKey{}).(*attemptTimer); ok {
+			timer.start(raw)
+		}
+		return nil
+	})
+	c.Client.OnAfterResponse(func(_ *resty.Client, resp *resty.Response) error {
+		if timer := attemptTimerOf(resp.Request); timer != nil {
+			timer.stop()
+		}
+		return nil
+	})
+	c.Client.OnError(func(r *resty.Request, _ error) {
+		if timer := attemptTimerOf(r); timer != nil {
+			timer.stop()
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCashfreeClientTimeouts(t *testing.T) {
+	var calls atomic.Int32
+	var slow atomic.Bool // makes the next attempt slow
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if slow.CompareAndSwap(true, false) {
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order_id": "order_1", "order_status": "ACTIVE"}`))
+	}))
+	defer server.Close()
+
+	client := NewCashfreeClient("id", "secret", "TEST", WithTimeouts(CashfreeTimeouts{
+		Default: time.Second, Checkout: 50 * time.Millisecond, Batch: time.Second,
+	}))
+	client.BaseURL = server.URL
+	client.Client.SetRetryCount(1).SetRetryWaitTime(time.Millisecond)
+
+	// Slow attempts are waited for by default
+	slow.Store(true)
+	order, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "order_1", order.OrderID)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// At checkout they time out, and are retried with the same timeout
+	slow.Store(true)
+	order, err = client.ForCheckout().GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "order_1", order.OrderID)
+	assert.Equal(t, int32(3), calls.Load())
+
+	client.Client.SetRetryCount(0)
+	slow.Store(true)
+	_, err = client.WithTimeout(50 * time.Millisecond).GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// Overrides leave the client itself alone
+	assert.Equal(t, time.Second, client.callTimeout())
+	assert.Equal(t, 50*time.Millisecond, client.ForCheckout().callTimeout())
+	assert.Same(t, client.Breaker, client.ForBatch().Breaker)
+}
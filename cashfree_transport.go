@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// CashfreeOption configures a client made by NewCashfreeClient
+type CashfreeOption func(*CashfreeClient)
+
+// WithAPI sets the API version the client speaks
+func WithAPI(api CashfreeAPI) CashfreeOption {
+	return func(c *CashfreeClient) {
+		c.API = api
+	}
+}
+
+// WithHTTPTransport sends the client's requests through transport, for a proxy, extra
+// trusted CAs, a client certificate or tuned connection pooling
+func WithHTTPTransport(transport *http.Transport) CashfreeOption {
+	return func(c *CashfreeClient) {
+		c.Client.SetTransport(transport)
+	}
+}
+
+// CashfreeTransport configures the HTTP connections to Cashfree. The zero value uses Go's
+// default transport, which honours HTTPS_PROXY.
+type CashfreeTransport struct {
+	// ProxyURL is an http(s) proxy for Cashfree calls only, with the credentials of an
+	// authenticated proxy as its user info
+	ProxyURL string
+
+	// CABundle is a PEM file of CAs to trust besides the system's, such as the CA of a
+	// TLS-inspecting proxy
+	CABundle string
+
+	// ClientCert and ClientKey are PEM files of a client certificate for mutual TLS
+	ClientCert string
+	ClientKey  string
+
+	// MaxIdleConnsPerHost bounds the idle connections kept to Cashfree; 0 keeps Go's
+	// default
+	MaxIdleConnsPerHost int
+}
+
+// IsZero reports whether the default transport is enough
+func (t CashfreeTransport) IsZero() bool {
+	return t == CashfreeTransport{}
+}
+
+// Build makes the transport, reading the certificate files
+func (t CashfreeTransport) Build() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if t.ProxyURL != "" {
+		proxy, err := url.Parse(t.ProxyURL)
+		if err != nil || proxy.Host == "" || (proxy.Scheme != "http" && proxy.Scheme != "https") {
+			return nil, fmt.Errorf("proxy URL must be an absolute http(s) URL")
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if t.CABundle != "" || t.ClientCert != "" || t.ClientKey != "" {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if t.CABundle != "" {
+			pem, err := os.ReadFile(t.CABundle)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("CA bundle %s has no PEM certificates", t.CABundle)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if t.ClientCert != "" || t.ClientKey != "" {
+			if t.ClientCert == "" || t.ClientKey == "" {
+				return nil, errors.New("client certificate and key must be set together")
+			}
+			cert, err := tls.LoadX509KeyPair(t.ClientCert, t.ClientKey)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load client certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if t.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	return transport, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+// writePEM writes a PEM block to a file in the test's temporary directory
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestCashfreeTransportProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.Header.Get("Proxy-Authorization")+" "+r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order_id": "order_1", "order_status": "ACTIVE"}`))
+	}))
+	defer proxy.Close()
+
+	transport, err := CashfreeTransport{ProxyURL: "http://egress:s3cret@" + proxy.Listener.Addr().String(), MaxIdleConnsPerHost: 50}.Build()
+	require.NoError(t, err)
+	assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+
+	client := NewCashfreeClient("id", "secret", "TEST", WithHTTPTransport(transport))
+	client.BaseURL = "http://cashfree.internal/pg"
+	client.Client.SetRetryCount(0)
+
+	status, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentActive, status.OrderStatus)
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("egress:s3cret"))
+	assert.Equal(t, []string{auth + " http://cashfree.internal/pg/orders/order_1"}, proxied)
+}
+
+func TestCashfreeTransportTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order_id": "order_1", "order_status": "PAID"}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// The test server's certificate doubles as the CA and the client certificate
+	cert := server.TLS.Certificates[0]
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	certFile := writePEM(t, "cert.pem", "CERTIFICATE", cert.Certificate[0])
+	keyFile := writePEM(t, "key.pem", "PRIVATE KEY", key)
+
+	newClient := func(config CashfreeTransport) *CashfreeClient {
+		transport, err := config.Build()
+		require.NoError(t, err)
+		client := NewCashfreeClient("id", "secret", "TEST", WithHTTPTransport(transport))
+		client.BaseURL = server.URL
+		client.Client.SetRetryCount(0)
+		return client
+	}
+
+	_, err = newClient(CashfreeTransport{}).GetOrderStatus("order_1")
+	assert.ErrorContains(t, err, "certificate")
+
+	_, err = newClient(CashfreeTransport{CABundle: certFile}).GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	status, err := newClient(CashfreeTransport{CABundle: certFile, ClientCert: certFile, ClientKey: keyFile}).GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, status.OrderStatus)
+}
+
+func TestCashfreeTransportBuildErrors(t *testing.T) {
+	notPEM := filepath.Join(t.TempDir(), "not.pem")
+	require.NoError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o600))
+
+	tests := []struct {
+		config CashfreeTransport
+		err    string
+	}{
+		{CashfreeTransport{ProxyURL: "socks5://proxy:1080"}, "proxy URL must be an absolute http(s) URL"},
+		{CashfreeTransport{CABundle: filepath.Join(t.TempDir(), "missing.pem")}, "failed to read CA bundle"},
+		{CashfreeTransport{CABundle: notPEM}, "has no PEM certificates"},
+		{CashfreeTransport{ClientCert: notPEM}, "client certificate and key must be set together"},
+		{CashfreeTransport{ClientCert: notPEM, ClientKey: notPEM}, "failed to load client certificate"},
+	}
+	for _, tt := range tests {
+		_, err := tt.config.Build()
+		assert.ErrorContains(t, err, tt.err)
+	}
+	assert.True(t, CashfreeTransport{}.IsZero())
+}
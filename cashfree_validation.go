@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// FieldError is a request field Cashfree would reject
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports the fields of a request that Cashfree would reject, found
+// before the request is sent. It wraps ErrInvalidRequest.
+type ValidationError struct {
+	Operation string
+	Fields    []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Field + " " + field.Message
+	}
+	return fmt.Sprintf("invalid %s request: %s", e.Operation, strings.Join(problems, "; "))
+}
+
+// Unwrap returns ErrInvalidRequest, the kind of error Cashfree would have returned
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// requestValidator collects the field errors of one request
+type requestValidator struct {
+	operation string
+	fields    []FieldError
+}
+
+func (v *requestValidator) fail(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *requestValidator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Operation: v.operation, Fields: v.fields}
+}
+
+// maxRefundIDLength is the longest refund_id Cashfree accepts
+const maxRefundIDLength = 40
+
+var (
+	// cashfreeOrderID is the order_id Cashfree accepts: letters, digits, "_" and "-"
+	cashfreeOrderID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,45}$`)
+	// cashfreeReferenceID is the format of customer, refund and payment IDs
+	cashfreeReferenceID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// cashfreePhone is a phone number of 10 to 15 digits, optionally with a leading "+"
+	cashfreePhone = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+)
+
+func (v *requestValidator) orderID(orderID string) {
+	if !cashfreeOrderID.MatchString(orderID) {
+		v.fail("order_id", "must be 1 to 45 letters, digits, underscores or hyphens")
+	}
+}
+
+// validateCreateOrder checks an order before it is sent to Cashfree
+func validateCreateOrder(req CreateOrderRequest) error {
+	v := &requestValidator{operation: CashfreeOpCreateOrder}
+	v.orderID(req.OrderID)
+	if req.OrderAmount <= 0 {
+		v.fail("order_amount", "must be positive")
+	}
+	if _, _, err := validateCurrency(req.OrderCurrency, req.OrderAmount); err != nil {
+		if _, ok := currencyExponents[strings.ToUpper(req.OrderCurrency)]; ok {
+			v.fail("order_amount", "%v", err)
+		} else {
+			v.fail("order_currency", "%v", err)
+		}
+	}
+
+	customer := req.CustomerDetails
+	if customer.CustomerID == "" || len(customer.CustomerID) > 50 || !cashfreeReferenceID.MatchString(customer.CustomerID) {
+		v.fail("customer_details.customer_id", "must be 1 to 50 letters, digits, underscores or hyphens")
+	}
+	if !cashfreePhone.MatchString(customer.CustomerPhone) {
+		v.fail("customer_details.customer_phone", "must be 10 to 15 digits, optionally starting with +")
+	}
+	return v.err()
+}
+
+// validateRefund checks a refund before it is sent to Cashfree
+func validateRefund(req CashfreeRefundRequest) error {
+	v := &requestValidator{operation: CashfreeOpCreateRefund}
+	v.orderID(req.OrderID)
+	if len(req.RefundID) > maxRefundIDLength || !cashfreeReferenceID.MatchString(req.RefundID) {
+		v.fail("refund_id", "must be 1 to %d letters, digits, underscores or hyphens", maxRefundIDLength)
+	}
+	if req.RefundAmount <= 0 {
+		v.fail("refund_amount", "must be positive")
+	}
+	return v.err()
+}
+
+// validateOrderID checks the order ID of a call about an existing order, which Cashfree
+// takes in the URL
+func validateOrderID(operation, orderID string) error {
+	v := &requestValidator{operation: operation}
+	v.orderID(orderID)
+	return v.err()
+}
+
+// validatePaymentSession checks the payment session of an order being paid
+func validatePaymentSession(operation, sessionID string) error {
+	v := &requestValidator{operation: operation}
+	if !cashfreeReferenceID.MatchString(sessionID) {
+		v.fail("payment_session_id", "must be letters, digits, underscores or hyphens")
+	}
+	return v.err()
+}
+
+// validatePaymentID checks Cashfree's ID of a payment, which it takes in the URL
+func validatePaymentID(operation, cfPaymentID string) error {
+	v := &requestValidator{operation: operation}
+	if !cashfreeReferenceID.MatchString(cfPaymentID) {
+		v.fail("cf_payment_id", "must be letters, digits, underscores or hyphens")
+	}
+	return v.err()
+}
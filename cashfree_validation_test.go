@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCreateOrder(t *testing.T) {
+	require.NoError(t, validateCreateOrder(testOrderRequest("order_1")))
+
+	tests := []struct {
+		change func(*CreateOrderRequest)
+		fields []FieldError
+	}{
+		{func(r *CreateOrderRequest) { r.OrderID = "order 1" }, []FieldError{{"order_id", "must be 1 to 45 letters, digits, underscores or hyphens"}}},
+		{func(r *CreateOrderRequest) { r.OrderID = strings.Repeat("a", 46) }, []FieldError{{"order_id", "must be 1 to 45 letters, digits, underscores or hyphens"}}},
+		{func(r *CreateOrderRequest) { r.OrderAmount = 10.005 }, []FieldError{{"order_amount", "INR amounts must have at most 2 decimal places"}}},
+		{func(r *CreateOrderRequest) { r.OrderAmount, r.OrderCurrency = 1.5, "JPY" }, []FieldError{{"order_amount", "JPY amounts must have at most 0 decimal places"}}},
+		{func(r *CreateOrderRequest) { r.OrderAmount = 0 }, []FieldError{{"order_amount", "must be positive"}}},
+		{func(r *CreateOrderRequest) { r.OrderCurrency = "XYZ" }, []FieldError{{"order_currency", `unsupported currency "XYZ"`}}},
+		{func(r *CreateOrderRequest) { r.CustomerDetails.CustomerID = "john@example.com" }, []FieldError{{"customer_details.customer_id", "must be 1 to 50 letters, digits, underscores or hyphens"}}},
+		{func(r *CreateOrderRequest) { r.CustomerDetails.CustomerPhone = "98765 43210" }, []FieldError{{"customer_details.customer_phone", "must be 10 to 15 digits, optionally starting with +"}}},
+		{func(r *CreateOrderRequest) { r.CustomerDetails.CustomerPhone = "12345" }, []FieldError{{"customer_details.customer_phone", "must be 10 to 15 digits, optionally starting with +"}}},
+	}
+	for _, tt := range tests {
+		req := testOrderRequest("order_1")
+		tt.change(&req)
+		err := validateCreateOrder(req)
+		var validationErr *ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, tt.fields, validationErr.Fields)
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	}
+
+	// Every problem is reported at once
+	req := testOrderRequest("order/1")
+	req.CustomerDetails.CustomerPhone = ""
+	assert.EqualError(t, validateCreateOrder(req), "invalid create_order request: order_id must be 1 to 45 letters, digits, underscores or hyphens; customer_details.customer_phone must be 10 to 15 digits, optionally starting with +")
+}
+
+func TestCashfreeClientValidatesBeforeSending(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := NewCashfreeClient("id", "secret", "TEST")
+	client.BaseURL = server.URL
+	client.Client.SetRetryCount(0)
+
+	req := testOrderRequest("order_1")
+	req.OrderAmount = 99.999
+	_, err := client.CreateOrder(req)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = client.GetOrderStatus("../refunds")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = client.RefundPayment(CashfreeRefundRequest{OrderID: "order_1", RefundID: "refund 1", RefundAmount: 10})
+	assert.EqualError(t, err, "invalid create_refund request: refund_id must be 1 to 40 letters, digits, underscores or hyphens")
+	_, err = client.RefundPayment(CashfreeRefundRequest{OrderID: "order_1", RefundID: "refund_" + strings.Repeat("a", 34), RefundAmount: 10})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.ErrorIs(t, client.CancelOrder(""), ErrInvalidRequest)
+	assert.Zero(t, calls)
+}
+
+func TestCreatePaymentSessionReportsInvalidFields(t *testing.T) {
+	_, client := newFakeCashfree(t)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(testDB(t)),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		orderURLs:    OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(`{
+		"order_id": "order_1", "amount": 250, "currency": "INR", "customer_id": "cust 1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "98765-43210", "notify_url": "https://shop.example.com/notify"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{
+		"error": "Failed to create payment session",
+		"fields": [{"field": "customer_details.customer_id", "message": "must be 1 to 50 letters, digits, underscores or hyphens"}]
+	}`, w.Body.String())
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+)
+
+func TestCashfreeAPIPaymentLink(t *testing.T) {
+	current := CashfreeAPI{Version: CashfreeAPIVersion20230801, CheckoutURLTemplate: "https://shop.example.com/pay/{order_id}?session={payment_session_id}"}
+	assert.Equal(t, "https://payments.cashfree.com/link", current.paymentLink("order_1", "https://payments.cashfree.com/link", "session_1"))
+	assert.Empty(t, current.paymentLink("order_1", "", "session_1"))
+
+	sessionOnly := current
+	sessionOnly.Version = CashfreeAPIVersion20250101
+	assert.Equal(t, "https://shop.example.com/pay/order_1?session=session_1", sessionOnly.paymentLink("order_1", "", "session_1"))
+	assert.Empty(t, sessionOnly.paymentLink("order_1", "", ""))
+	assert.Empty(t, CashfreeAPI{Version: CashfreeAPIVersion20250101}.paymentLink("order_1", "", "session_1"))
+}
+
+func TestCashfreeClientSessionOnlyAPIVersion(t *testing.T) {
+	_, client := newFakeCashfree(t)
+	client.API = CashfreeAPI{Version: cashfreetest.SessionOnlyAPIVersion}
+
+	order, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	assert.Empty(t, order.PaymentLink)
+	assert.Equal(t, "session_"+order.CFOrderID, order.PaymentSessionID)
+
+	// The checkout URL template stands in for the payment link
+	client.API.CheckoutURLTemplate = "https://shop.example.com/pay?session={payment_session_id}"
+	status, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.example.com/pay?session=session_"+order.CFOrderID, status.PaymentLink)
+
+	client.API.Version = "2022-09-01"
+	_, err = client.GetOrderStatus("order_1")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestCreatePaymentSessionWithSessionOnlyAPIVersion(t *testing.T) {
+	db := testDB(t)
+	_, client := newFakeCashfree(t)
+	client.API = CashfreeAPI{Version: CashfreeAPIVersion20250101}
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(`{
+		"order_id": "order_1", "amount": 250, "currency": "INR", "customer_id": "cust_1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "9999999999"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		CFOrderID        string `json:"cf_order_id"`
+		PaymentLink      string `json:"payment_link"`
+		PaymentSessionID string `json:"payment_session_id"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Empty(t, resp.PaymentLink)
+	assert.Equal(t, "session_"+resp.CFOrderID, resp.PaymentSessionID)
+
+	payment, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Nil(t, payment.PaymentURL)
+}
@@ -0,0 +1,207 @@
+package cashfreetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cassette is an http.RoundTripper that records the requests a client makes and the
+// responses it gets, or replays responses recorded earlier, so client tests can run
+// offline against the Cashfree sandbox's real answers. Request headers are not
+// recorded, so credentials never reach the cassette file.
+type Cassette struct {
+	// Vars holds values a test must reuse when the cassette is replayed, such as the
+	// unique order IDs of the recording run
+	Vars         map[string]string `yaml:"vars,omitempty"`
+	Interactions []Interaction     `yaml:"interactions"`
+
+	path      string
+	transport http.RoundTripper // nil when replaying
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `yaml:"request"`
+	Response RecordedResponse `yaml:"response"`
+}
+
+// RecordedRequest identifies a request by method and URL path and query; the host is
+// left out so a cassette replays against any base URL
+type RecordedRequest struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body,omitempty"`
+}
+
+// RecordedResponse is the response to a recorded request
+type RecordedResponse struct {
+	Status      int    `yaml:"status"`
+	ContentType string `yaml:"content_type,omitempty"`
+	Body        string `yaml:"body,omitempty"`
+}
+
+// LoadCassette opens the cassette at path for replay
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cassette{path: path}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing cassette %s: %w", path, err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+// RecordCassette starts a cassette that sends requests through transport and records
+// them. Save writes it to path.
+func RecordCassette(path string, transport http.RoundTripper) *Cassette {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Cassette{path: path, transport: transport, Vars: make(map[string]string)}
+}
+
+// Recording reports whether the cassette is recording rather than replaying
+func (c *Cassette) Recording() bool {
+	return c.transport != nil
+}
+
+// Var returns the value recorded for name. While recording, the first call for a name
+// records the value generate returns.
+func (c *Cassette) Var(name string, generate func() string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if value, ok := c.Vars[name]; ok {
+		return value, nil
+	}
+	if !c.Recording() {
+		return "", fmt.Errorf("cassette %s has no var %q", c.path, name)
+	}
+	c.Vars[name] = generate()
+	return c.Vars[name], nil
+}
+
+// RoundTrip records or replays a request
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded := RecordedRequest{Method: req.Method, URL: req.URL.RequestURI()}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		recorded.Body = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if c.Recording() {
+		return c.record(req, recorded)
+	}
+	return c.replay(req, recorded)
+}
+
+func (c *Cassette) record(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	c.mu.Lock()
+	c.Interactions = append(c.Interactions, Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        string(body),
+		},
+	})
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// replay answers with the first unused interaction with the request's method and URL
+func (c *Cassette) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, interaction := range c.Interactions {
+		if c.used[i] || interaction.Request.Method != recorded.Method || interaction.Request.URL != recorded.URL {
+			continue
+		}
+		c.used[i] = true
+
+		header := make(http.Header)
+		if interaction.Response.ContentType != "" {
+			header.Set("Content-Type", interaction.Response.ContentType)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.Status, http.StatusText(interaction.Response.Status)),
+			StatusCode:    interaction.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no unused response for %s %s", c.path, recorded.Method, recorded.URL)
+}
+
+// Unused returns the recorded requests that were not replayed, so a test can check it
+// made every call it was recorded making
+func (c *Cassette) Unused() []RecordedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var unused []RecordedRequest
+	for i, interaction := range c.Interactions {
+		if !c.Recording() && !c.used[i] {
+			unused = append(unused, interaction.Request)
+		}
+	}
+	return unused
+}
+
+// Save writes a recorded cassette to its path
+func (c *Cassette) Save() error {
+	if !c.Recording() {
+		return errors.New("only a recording cassette can be saved")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,82 @@
+package cashfreetest
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCassetteRecordAndReplay(t *testing.T) {
+	server := NewServer("test_client", "test_secret")
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "orders.yaml")
+
+	call := func(client *http.Client, method, url, body string) (int, string, error) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("x-client-id", server.ClientID)
+		req.Header.Set("x-client-secret", server.ClientSecret)
+		req.Header.Set("x-api-version", APIVersion)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data), nil
+	}
+	order := `{"order_id": "order_1", "order_amount": 10, "order_currency": "INR", "customer_details": {"customer_id": "cust_1", "customer_phone": "9999999999"}}`
+
+	recorder := RecordCassette(path, nil)
+	run, err := recorder.Var("run", func() string { return "42" })
+	require.NoError(t, err)
+	assert.Equal(t, "42", run)
+
+	client := &http.Client{Transport: recorder}
+	createdStatus, created, err := call(client, http.MethodPost, server.URL+"/orders", order)
+	require.NoError(t, err)
+	duplicateStatus, duplicate, err := call(client, http.MethodPost, server.URL+"/orders", order)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, createdStatus)
+	require.Equal(t, http.StatusConflict, duplicateStatus)
+	require.NoError(t, recorder.Save())
+
+	// Credentials are sent as headers, which are not recorded
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), server.ClientSecret)
+
+	player, err := LoadCassette(path)
+	require.NoError(t, err)
+	run, err = player.Var("run", func() string { return "other" })
+	require.NoError(t, err)
+	assert.Equal(t, "42", run)
+	_, err = player.Var("missing", func() string { return "other" })
+	assert.Error(t, err)
+
+	// Responses come back in recorded order, from any host
+	client = &http.Client{Transport: player}
+	status, body, err := call(client, http.MethodPost, "https://sandbox.example.com/orders", order)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, created, body)
+	assert.Len(t, player.Unused(), 1)
+
+	status, body, err = call(client, http.MethodPost, "https://sandbox.example.com/orders", order)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, duplicate, body)
+	assert.Empty(t, player.Unused())
+
+	_, _, err = call(client, http.MethodPost, "https://sandbox.example.com/orders", order)
+	assert.ErrorContains(t, err, "no unused response for POST /orders")
+	assert.Error(t, player.Save())
+}
@@ -0,0 +1,1071 @@
+// Package cashfreetest provides an in-memory fake of the Cashfree Payment Gateway API
+// for tests. It serves the order, payment, refund and settlement endpoints the service
+// calls, lets a test play the customer's part (paying or failing an order) and the
+// gateway's (processing refunds and settlements), and signs the webhooks Cashfree would
+// send for each of those. Cassette records a client's calls to the real sandbox and
+// replays them offline.
+package cashfreetest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIVersion is the x-api-version the fake implements by default
+const APIVersion = "2023-08-01"
+
+// SessionOnlyAPIVersion is a later x-api-version the fake also implements, whose order
+// responses carry a payment_session_id but no payment_link
+const SessionOnlyAPIVersion = "2025-01-01"
+
+// Order statuses
+const (
+	OrderActive     = "ACTIVE"
+	OrderPaid       = "PAID"
+	OrderExpired    = "EXPIRED"
+	OrderTerminated = "TERMINATED"
+
+	// OrderTerminationRequested is a termination Cashfree has yet to confirm
+	OrderTerminationRequested = "TERMINATION_REQUESTED"
+)
+
+// Order is an order as the fake stores it
+type Order struct {
+	CFOrderID      string
+	OrderID        string
+	Status         string
+	Amount         float64
+	Currency       string
+	CustomerID     string
+	CustomerName   string
+	CustomerEmail  string
+	CustomerPhone  string
+	ReturnURL      string
+	NotifyURL      string
+	PaymentMethods string // order_meta.payment_methods
+	Note           string
+	Tags           map[string]string
+	CartItems      []CartItem
+	Splits         []Split // order_splits
+	ExpiryTime     time.Time
+	PaymentLink    string
+	CreatedAt      time.Time
+
+	Payments    []Payment
+	Refunds     []Refund
+	Settlements []Settlement
+}
+
+// CartItem is a line item of an order's cart
+type CartItem struct {
+	ItemID    string  `json:"item_id"`
+	Name      string  `json:"item_name"`
+	ImageURL  string  `json:"item_image_url"`
+	UnitPrice float64 `json:"item_discounted_unit_price"`
+	Quantity  int     `json:"item_quantity"`
+}
+
+// Payment is an attempt to pay an order
+type Payment struct {
+	CFPaymentID string    `json:"cf_payment_id"`
+	OrderID     string    `json:"order_id"`
+	Status      string    `json:"payment_status"` // SUCCESS or FAILED
+	Amount      float64   `json:"payment_amount"`
+	Method      string    `json:"payment_method"`
+	Time        time.Time `json:"payment_time"`
+	Charges     *Charges  `json:"payment_charges,omitempty"` // set on successful payments when the server charges
+}
+
+// Charges are what Cashfree deducts from a payment before settling it
+type Charges struct {
+	ServiceCharge    float64 `json:"service_charge"`
+	ServiceTax       float64 `json:"service_tax"`
+	SettlementAmount float64 `json:"settlement_amount"`
+}
+
+// Refund is a refund of an order's payment
+type Refund struct {
+	CFRefundID  string      `json:"cf_refund_id"`
+	RefundID    string      `json:"refund_id"`
+	OrderID     string      `json:"order_id"`
+	Amount      float64     `json:"refund_amount"`
+	Status      string      `json:"refund_status"` // PENDING, SUCCESS or CANCELLED
+	Mode        string      `json:"refund_mode"`   // STANDARD or INSTANT
+	Speed       RefundSpeed `json:"refund_speed"`
+	ARN         string      `json:"refund_arn,omitempty"` // set once the refund is processed
+	Splits      []Split     `json:"refund_splits,omitempty"`
+	Note        string      `json:"refund_note,omitempty"`
+	Type        string      `json:"refund_type,omitempty"` // PAYMENT_AUTO_REFUND for auto-refunds
+	ProcessedAt *time.Time  `json:"processed_at,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// RefundSpeed is the speed a refund was requested and accepted at. Only UPI and card
+// payments are refunded instantly; other instant refunds are accepted as STANDARD.
+type RefundSpeed struct {
+	Requested string `json:"requested"`
+	Accepted  string `json:"accepted"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Settlement is a split settlement of an order
+type Settlement struct {
+	CFSettlementID string  `json:"cf_settlement_id"`
+	SettlementID   string  `json:"settlement_id"`
+	OrderID        string  `json:"order_id"`
+	Status         string  `json:"settlement_status"` // PENDING or SUCCESS
+	Splits         []Split `json:"splits"`
+	UTR            string  `json:"utr,omitempty"`
+}
+
+// Split is a vendor's share of a settlement
+type Split struct {
+	VendorID   string   `json:"vendor_id"`
+	Amount     *float64 `json:"amount,omitempty"`
+	Percentage *float64 `json:"percentage,omitempty"`
+}
+
+// Webhook is a signed webhook ready to deliver
+type Webhook struct {
+	Type      string
+	Body      []byte
+	Signature string
+	Timestamp string
+}
+
+// Request builds the webhook delivery to target, for passing to a handler's ServeHTTP
+func (w *Webhook) Request(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(w.Body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-webhook-signature", w.Signature)
+	req.Header.Set("x-webhook-timestamp", w.Timestamp)
+	return req
+}
+
+// Server is a fake Cashfree API. Requests must carry the server's client ID and secret
+// and an x-api-version it implements.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	// Now is the gateway's clock
+	Now func() time.Time
+
+	// ChargePercent is the service charge on successful payments, as a percentage of
+	// the amount. GST of 18% is charged on it. Payments carry no charges when it is 0.
+	ChargePercent float64
+
+	// DeferTermination leaves terminated orders TERMINATION_REQUESTED, as Cashfree does
+	// while it checks that no payment is in flight
+	DeferTermination bool
+
+	// PaymentsPageSize lists an order's payments this many at a time, with the cursor of
+	// the next page in the x-next-cursor header. All are listed at once when it is 0.
+	PaymentsPageSize int
+
+	mu       sync.Mutex
+	orders   map[string]*Order
+	sequence int
+	failures []int
+}
+
+// NewServer starts a fake Cashfree API accepting the given credentials. Close it when
+// the test is done.
+func NewServer(clientID, clientSecret string) *Server {
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Now:          time.Now,
+		orders:       make(map[string]*Order),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /orders", s.createOrder)
+	mux.HandleFunc("GET /orders/{order_id}", s.getOrder)
+	mux.HandleFunc("GET /orders/{order_id}/payments", s.getPayments)
+	mux.HandleFunc("GET /payments/{cf_payment_id}", s.getPayment)
+	mux.HandleFunc("PATCH /orders/{order_id}", s.terminateOrder)
+	mux.HandleFunc("POST /orders/sessions", s.payOrder)
+	mux.HandleFunc("POST /orders/{order_id}/refunds", s.createRefund)
+	mux.HandleFunc("GET /orders/{order_id}/refunds", s.listRefunds)
+	mux.HandleFunc("GET /orders/{order_id}/refunds/{refund_id}", s.getRefund)
+	mux.HandleFunc("POST /orders/{order_id}/settlements", s.createSettlement)
+	mux.HandleFunc("POST /recon", s.recon)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// FailNext makes the next API calls fail with the given statuses, in order
+func (s *Server) FailNext(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statuses...)
+}
+
+// Order returns a copy of an order
+func (s *Server) Order(orderID string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderID]
+	if !ok {
+		return Order{}, false
+	}
+	copied := *order
+	copied.CartItems = append([]CartItem(nil), order.CartItems...)
+	copied.Payments = append([]Payment(nil), order.Payments...)
+	copied.Refunds = append([]Refund(nil), order.Refunds...)
+	copied.Settlements = append([]Settlement(nil), order.Settlements...)
+	return copied, true
+}
+
+// CompletePayment pays an active order in full with method, as the customer would on
+// the hosted checkout, and returns the PAYMENT_SUCCESS_WEBHOOK Cashfree would send
+func (s *Server) CompletePayment(orderID, method string) (*Webhook, error) {
+	s.mu.Lock()
+	order, err := s.activeOrder(orderID)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	payment := Payment{
+		CFPaymentID: s.nextID("cf_payment"),
+		OrderID:     orderID,
+		Status:      "SUCCESS",
+		Amount:      order.Amount,
+		Method:      method,
+		Time:        s.Now().UTC().Truncate(time.Second),
+		Charges:     s.charges(order.Amount),
+	}
+	order.Payments = append(order.Payments, payment)
+	order.Status = OrderPaid
+	s.mu.Unlock()
+
+	data := map[string]interface{}{
+		"order_id":       orderID,
+		"cf_payment_id":  payment.CFPaymentID,
+		"payment_status": payment.Status,
+		"payment_amount": payment.Amount,
+		"payment_method": payment.Method,
+		"payment_time":   payment.Time.Format(time.RFC3339),
+	}
+	if payment.Charges != nil {
+		data["payment_charges"] = payment.Charges
+	}
+	return s.SignWebhook("PAYMENT_SUCCESS_WEBHOOK", data)
+}
+
+// charges returns the charges on a successful payment of amount, or nil when the
+// server charges nothing
+func (s *Server) charges(amount float64) *Charges {
+	if s.ChargePercent <= 0 {
+		return nil
+	}
+	fee := math.Round(amount*s.ChargePercent) / 100
+	tax := math.Round(fee*18) / 100
+	return &Charges{
+		ServiceCharge:    fee,
+		ServiceTax:       tax,
+		SettlementAmount: math.Round((amount-fee-tax)*100) / 100,
+	}
+}
+
+// FailPayment records a failed payment attempt on an active order, which stays
+// payable, and returns the PAYMENT_FAILED_WEBHOOK Cashfree would send
+func (s *Server) FailPayment(orderID, method string) (*Webhook, error) {
+	s.mu.Lock()
+	order, err := s.activeOrder(orderID)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	payment := Payment{
+		CFPaymentID: s.nextID("cf_payment"),
+		OrderID:     orderID,
+		Status:      "FAILED",
+		Amount:      order.Amount,
+		Method:      method,
+		Time:        s.Now().UTC().Truncate(time.Second),
+	}
+	order.Payments = append(order.Payments, payment)
+	s.mu.Unlock()
+
+	return s.SignWebhook("PAYMENT_FAILED_WEBHOOK", map[string]interface{}{
+		"order_id":       orderID,
+		"cf_payment_id":  payment.CFPaymentID,
+		"payment_status": payment.Status,
+		"payment_amount": payment.Amount,
+		"payment_method": payment.Method,
+		"payment_time":   payment.Time.Format(time.RFC3339),
+	})
+}
+
+// ProcessRefund completes a pending refund with status SUCCESS or CANCELLED and returns
+// the REFUND_STATUS_WEBHOOK Cashfree would send
+func (s *Server) ProcessRefund(orderID, refundID, status string) (*Webhook, error) {
+	s.mu.Lock()
+	refund, err := s.pendingRefund(orderID, refundID)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	processedAt := s.Now().UTC().Truncate(time.Second)
+	refund.Status = status
+	refund.ProcessedAt = &processedAt
+	if status == "SUCCESS" {
+		refund.ARN = s.nextID("arn")
+	}
+	data := map[string]interface{}{
+		"order_id":      orderID,
+		"refund_id":     refundID,
+		"cf_refund_id":  refund.CFRefundID,
+		"refund_amount": refund.Amount,
+		"refund_status": status,
+		"refund_mode":   refund.Mode,
+		"refund_arn":    refund.ARN,
+		"processed_at":  processedAt.Format(time.RFC3339),
+	}
+	s.mu.Unlock()
+
+	return s.SignWebhook("REFUND_STATUS_WEBHOOK", data)
+}
+
+// AutoRefund refunds a payment the customer was debited for after dropping out of
+// checkout, as Cashfree does on its own, and returns the AUTO_REFUND_STATUS_WEBHOOK
+// Cashfree would send. The order is left as it was.
+func (s *Server) AutoRefund(orderID string) (*Webhook, error) {
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+
+	processedAt := s.Now().UTC().Truncate(time.Second)
+	refund := Refund{
+		CFRefundID:  s.nextID("cf_refund"),
+		OrderID:     orderID,
+		Amount:      order.Amount,
+		Status:      "SUCCESS",
+		Mode:        "STANDARD",
+		ARN:         s.nextID("arn"),
+		Note:        "Payment debited after the customer dropped out",
+		Type:        "PAYMENT_AUTO_REFUND",
+		ProcessedAt: &processedAt,
+		CreatedAt:   processedAt,
+	}
+	refund.RefundID = "auto_" + refund.CFRefundID
+	order.Refunds = append(order.Refunds, refund)
+	data := map[string]interface{}{
+		"auto_refund": map[string]interface{}{
+			"order_id":      orderID,
+			"refund_id":     refund.RefundID,
+			"cf_refund_id":  refund.CFRefundID,
+			"refund_amount": refund.Amount,
+			"refund_status": refund.Status,
+			"refund_mode":   refund.Mode,
+			"refund_arn":    refund.ARN,
+			"refund_type":   refund.Type,
+			"refund_note":   refund.Note,
+			"processed_at":  processedAt.Format(time.RFC3339),
+		},
+	}
+	s.mu.Unlock()
+
+	return s.SignWebhook("AUTO_REFUND_STATUS_WEBHOOK", data)
+}
+
+// Settle pays out an order's settlement with a bank reference and returns the
+// SETTLEMENT_STATUS_WEBHOOK Cashfree would send
+func (s *Server) Settle(orderID, settlementID, utr string) (*Webhook, error) {
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+
+	var settlement *Settlement
+	for i := range order.Settlements {
+		if order.Settlements[i].SettlementID == settlementID || order.Settlements[i].CFSettlementID == settlementID {
+			settlement = &order.Settlements[i]
+		}
+	}
+	if settlement == nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("settlement %s not found for order %s", settlementID, orderID)
+	}
+
+	settlement.Status = "SUCCESS"
+	settlement.UTR = utr
+	data := map[string]interface{}{
+		"order_id":          orderID,
+		"settlement_id":     settlement.SettlementID,
+		"settlement_status": settlement.Status,
+		"settlement_amount": order.Amount,
+		"utr":               utr,
+	}
+	s.mu.Unlock()
+
+	return s.SignWebhook("SETTLEMENT_STATUS_WEBHOOK", data)
+}
+
+// SignWebhook builds a webhook of the given type signed with the client secret
+func (s *Server) SignWebhook(eventType string, data map[string]interface{}) (*Webhook, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":       eventType,
+		"event_time": s.Now().UTC().Format(time.RFC3339),
+		"data":       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(s.Now().UnixMilli(), 10)
+	return &Webhook{
+		Type:      eventType,
+		Body:      body,
+		Signature: Sign(s.ClientSecret, timestamp, body),
+		Timestamp: timestamp,
+	}, nil
+}
+
+// Sign computes the x-webhook-signature Cashfree sends: the base64 HMAC-SHA256 of the
+// timestamp followed by the body, keyed with the client secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// nextID returns a new gateway ID with the given prefix. s.mu must be held.
+func (s *Server) nextID(prefix string) string {
+	s.sequence++
+	return fmt.Sprintf("%s_%d", prefix, s.sequence)
+}
+
+// activeOrder returns an order that can still be paid. s.mu must be held.
+func (s *Server) activeOrder(orderID string) (*Order, error) {
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if order.Status == OrderActive && !order.ExpiryTime.IsZero() && s.Now().After(order.ExpiryTime) {
+		order.Status = OrderExpired
+	}
+	if order.Status != OrderActive {
+		return nil, fmt.Errorf("order %s is %s", orderID, order.Status)
+	}
+	return order, nil
+}
+
+// paidMethod returns the method of the payment that paid an order
+func paidMethod(order *Order) string {
+	for _, payment := range order.Payments {
+		if payment.Status == "SUCCESS" {
+			return payment.Method
+		}
+	}
+	return ""
+}
+
+// pendingRefund returns a refund that has not been processed. s.mu must be held.
+func (s *Server) pendingRefund(orderID, refundID string) (*Refund, error) {
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	for i := range order.Refunds {
+		if order.Refunds[i].RefundID == refundID {
+			if order.Refunds[i].Status != "PENDING" {
+				return nil, fmt.Errorf("refund %s is %s", refundID, order.Refunds[i].Status)
+			}
+			return &order.Refunds[i], nil
+		}
+	}
+	return nil, fmt.Errorf("refund %s not found for order %s", refundID, orderID)
+}
+
+// authenticate checks the credentials and API version, and injects queued failures
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		var failure int
+		if len(s.failures) > 0 {
+			failure, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		switch {
+		case failure != 0:
+			writeError(w, failure, "api_error", "injected failure")
+		case r.Header.Get("X-Client-Id") != s.ClientID || r.Header.Get("X-Client-Secret") != s.ClientSecret:
+			writeError(w, http.StatusUnauthorized, "authentication_error", "authentication Failed")
+		case r.Header.Get("x-api-version") != APIVersion && r.Header.Get("x-api-version") != SessionOnlyAPIVersion:
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "x-api-version must be "+APIVersion+" or "+SessionOnlyAPIVersion)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+type createOrderRequest struct {
+	OrderID         string  `json:"order_id"`
+	OrderAmount     float64 `json:"order_amount"`
+	OrderCurrency   string  `json:"order_currency"`
+	CustomerDetails struct {
+		CustomerID    string `json:"customer_id"`
+		CustomerName  string `json:"customer_name"`
+		CustomerEmail string `json:"customer_email"`
+		CustomerPhone string `json:"customer_phone"`
+	} `json:"customer_details"`
+	OrderMeta struct {
+		ReturnURL      string `json:"return_url"`
+		NotifyURL      string `json:"notify_url"`
+		PaymentMethods string `json:"payment_methods"`
+	} `json:"order_meta"`
+	OrderNote       string            `json:"order_note"`
+	OrderExpiryTime string            `json:"order_expiry_time"`
+	OrderTags       map[string]string `json:"order_tags"`
+	CartDetails     struct {
+		CartItems []CartItem `json:"cart_items"`
+	} `json:"cart_details"`
+	OrderSplits []Split `json:"order_splits"`
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request) {
+	var req createOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+
+	switch {
+	case req.OrderID == "":
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order_id is missing in the request")
+		return
+	case req.OrderAmount < 1:
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order_amount : must be at least 1")
+		return
+	case req.OrderCurrency == "":
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order_currency is missing in the request")
+		return
+	case req.CustomerDetails.CustomerID == "" || req.CustomerDetails.CustomerPhone == "":
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "customer_details.customer_id and customer_phone are required")
+		return
+	}
+
+	splitTotal := 0.0
+	for _, split := range req.OrderSplits {
+		switch {
+		case split.VendorID == "" || (split.Amount == nil) == (split.Percentage == nil):
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "order_splits need a vendor_id and either an amount or a percentage")
+			return
+		case split.Amount != nil:
+			splitTotal += *split.Amount
+		default:
+			splitTotal += req.OrderAmount * *split.Percentage / 100
+		}
+	}
+	if splitTotal > req.OrderAmount+1e-9 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order_splits exceed the order amount")
+		return
+	}
+
+	var expiry time.Time
+	if req.OrderExpiryTime != "" {
+		var err error
+		if expiry, err = time.Parse(time.RFC3339, req.OrderExpiryTime); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "order_expiry_time must be in RFC 3339 format")
+			return
+		}
+	} else {
+		expiry = s.Now().Add(30 * 24 * time.Hour)
+	}
+
+	s.mu.Lock()
+	if _, exists := s.orders[req.OrderID]; exists {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "invalid_request_error", "order with same id is already present")
+		return
+	}
+
+	cfOrderID := s.nextID("cf_order")
+	order := &Order{
+		CFOrderID:      cfOrderID,
+		OrderID:        req.OrderID,
+		Status:         OrderActive,
+		Amount:         req.OrderAmount,
+		Currency:       req.OrderCurrency,
+		CustomerID:     req.CustomerDetails.CustomerID,
+		CustomerName:   req.CustomerDetails.CustomerName,
+		CustomerEmail:  req.CustomerDetails.CustomerEmail,
+		CustomerPhone:  req.CustomerDetails.CustomerPhone,
+		ReturnURL:      req.OrderMeta.ReturnURL,
+		NotifyURL:      req.OrderMeta.NotifyURL,
+		PaymentMethods: req.OrderMeta.PaymentMethods,
+		Note:           req.OrderNote,
+		Tags:           req.OrderTags,
+		CartItems:      req.CartDetails.CartItems,
+		Splits:         req.OrderSplits,
+		ExpiryTime:     expiry,
+		PaymentLink:    s.URL + "/checkout/" + cfOrderID,
+		CreatedAt:      s.Now().UTC().Truncate(time.Second),
+	}
+	s.orders[req.OrderID] = order
+	response := orderResponse(order, r.Header.Get("x-api-version"))
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	if order.Status == OrderActive && s.Now().After(order.ExpiryTime) {
+		order.Status = OrderExpired
+	}
+	writeJSON(w, http.StatusOK, orderResponse(order, r.Header.Get("x-api-version")))
+}
+
+func (s *Server) getPayments(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+
+	offset := 0
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid cursor")
+			return
+		}
+	}
+
+	// Newest attempt first, as Cashfree lists them
+	payments := make([]Payment, 0, len(order.Payments))
+	for i := len(order.Payments) - 1 - offset; i >= 0; i-- {
+		if s.PaymentsPageSize > 0 && len(payments) == s.PaymentsPageSize {
+			w.Header().Set("x-next-cursor", strconv.Itoa(offset+len(payments)))
+			break
+		}
+		payments = append(payments, order.Payments[i])
+	}
+	writeJSON(w, http.StatusOK, payments)
+}
+
+func (s *Server) getPayment(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfPaymentID := r.PathValue("cf_payment_id")
+	for _, order := range s.orders {
+		for _, payment := range order.Payments {
+			if payment.CFPaymentID == cfPaymentID {
+				writeJSON(w, http.StatusOK, payment)
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "invalid_request_error", "payment not found")
+}
+
+func (s *Server) terminateOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OrderStatus string `json:"order_status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderStatus != OrderTerminated {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order_status must be TERMINATED")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	if order.Status != OrderActive {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "order is "+order.Status+" and cannot be terminated")
+		return
+	}
+
+	order.Status = OrderTerminated
+	if s.DeferTermination {
+		order.Status = OrderTerminationRequested
+	}
+	writeJSON(w, http.StatusOK, orderResponse(order, r.Header.Get("x-api-version")))
+}
+
+type payOrderRequest struct {
+	PaymentSessionID string `json:"payment_session_id"`
+	PaymentMethod    struct {
+		UPI *struct {
+			Channel string `json:"channel"`
+		} `json:"upi"`
+	} `json:"payment_method"`
+}
+
+// payOrder starts a payment in an order's payment session. Only UPI intent payments
+// (channel "link") are supported; the customer completes them with CompletePayment.
+func (s *Server) payOrder(w http.ResponseWriter, r *http.Request) {
+	var req payOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+	if req.PaymentMethod.UPI == nil || req.PaymentMethod.UPI.Channel != "link" {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "only payment_method.upi with channel link is supported")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var order *Order
+	for _, candidate := range s.orders {
+		if "session_"+candidate.CFOrderID == req.PaymentSessionID {
+			order = candidate
+		}
+	}
+	if order == nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "payment_session_id is not valid")
+		return
+	}
+	if _, err := s.activeOrder(order.OrderID); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if order.PaymentMethods != "" && !strings.Contains(","+order.PaymentMethods+",", ",upi,") {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "upi is not enabled for this order")
+		return
+	}
+
+	cfPaymentID := s.nextID("cf_payment")
+	query := fmt.Sprintf("pa=cashfree@fake&pn=Merchant&tr=%s&am=%.2f&cu=%s", cfPaymentID, order.Amount, order.Currency)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cf_payment_id":  cfPaymentID,
+		"payment_method": "upi",
+		"channel":        "link",
+		"action":         "link",
+		"data": map[string]interface{}{
+			"payload": map[string]string{
+				"default": "upi://pay?" + query,
+				"gpay":    "tez://upi/pay?" + query,
+				"phonepe": "phonepe://pay?" + query,
+				"paytm":   "paytmmp://pay?" + query,
+				"bhim":    "upi://pay?" + query,
+			},
+		},
+	})
+}
+
+type createRefundRequest struct {
+	RefundAmount float64 `json:"refund_amount"`
+	RefundID     string  `json:"refund_id"`
+	RefundNote   string  `json:"refund_note"`
+	RefundSpeed  string  `json:"refund_speed"`
+	RefundSplits []Split `json:"refund_splits"`
+}
+
+func (s *Server) createRefund(w http.ResponseWriter, r *http.Request) {
+	var req createRefundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	if order.Status != OrderPaid {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "refunds can only be created for paid orders")
+		return
+	}
+	if req.RefundID == "" || req.RefundAmount <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "refund_id and a positive refund_amount are required")
+		return
+	}
+
+	refunded := 0.0
+	for _, refund := range order.Refunds {
+		if refund.RefundID == req.RefundID {
+			writeError(w, http.StatusConflict, "invalid_request_error", "refund with same id is already present")
+			return
+		}
+		if refund.Status != "CANCELLED" {
+			refunded += refund.Amount
+		}
+	}
+	if refunded+req.RefundAmount > order.Amount+1e-9 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "refund amount exceeds the refundable amount")
+		return
+	}
+
+	splitTotal := 0.0
+	for _, split := range req.RefundSplits {
+		if split.VendorID == "" || split.Amount == nil || *split.Amount <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "refund_splits need a vendor_id and a positive amount")
+			return
+		}
+		splitTotal += *split.Amount
+	}
+	if splitTotal > req.RefundAmount+1e-9 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "refund_splits exceed the refund amount")
+		return
+	}
+
+	speed := RefundSpeed{Requested: "STANDARD", Accepted: "STANDARD"}
+	switch req.RefundSpeed {
+	case "", "STANDARD":
+	case "INSTANT":
+		speed.Requested = "INSTANT"
+		if method := paidMethod(order); method == "upi" || method == "card" {
+			speed.Accepted = "INSTANT"
+		} else {
+			speed.Message = "instant refund is not available for this payment method"
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "refund_speed must be STANDARD or INSTANT")
+		return
+	}
+
+	refund := Refund{
+		CFRefundID: s.nextID("cf_refund"),
+		RefundID:   req.RefundID,
+		OrderID:    order.OrderID,
+		Amount:     req.RefundAmount,
+		Status:     "PENDING",
+		Mode:       speed.Accepted,
+		Speed:      speed,
+		Note:       req.RefundNote,
+		Splits:     req.RefundSplits,
+		CreatedAt:  s.Now().UTC().Truncate(time.Second),
+	}
+	order.Refunds = append(order.Refunds, refund)
+	writeJSON(w, http.StatusOK, refund)
+}
+
+func (s *Server) listRefunds(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, append([]Refund{}, order.Refunds...))
+}
+
+func (s *Server) getRefund(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	for _, refund := range order.Refunds {
+		if refund.RefundID == r.PathValue("refund_id") {
+			writeJSON(w, http.StatusOK, refund)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "invalid_request_error", "refund not found")
+}
+
+func (s *Server) createSettlement(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Splits []Split `json:"splits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Splits) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "splits are required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[r.PathValue("order_id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "invalid_request_error", "order not found")
+		return
+	}
+	if order.Status != OrderPaid {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "settlements can only be created for paid orders")
+		return
+	}
+
+	settlement := Settlement{
+		CFSettlementID: s.nextID("cf_settlement"),
+		OrderID:        order.OrderID,
+		Status:         "PENDING",
+		Splits:         req.Splits,
+	}
+	settlement.SettlementID = settlement.CFSettlementID
+	order.Settlements = append(order.Settlements, settlement)
+	writeJSON(w, http.StatusOK, settlement)
+}
+
+// recon lists the successful payments made in the requested range as PAYMENT events.
+// Cashfree only lists events once they are settled; the fake does not wait.
+func (s *Server) recon(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pagination struct {
+			Limit  int     `json:"limit"`
+			Cursor *string `json:"cursor"`
+		} `json:"pagination"`
+		Filters struct {
+			StartDate time.Time `json:"start_date"`
+			EndDate   time.Time `json:"end_date"`
+		} `json:"filters"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body")
+		return
+	}
+	if req.Pagination.Limit < 1 || req.Pagination.Limit > 1000 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "pagination.limit must be between 1 and 1000")
+		return
+	}
+	offset := 0
+	if req.Pagination.Cursor != nil {
+		var err error
+		if offset, err = strconv.Atoi(*req.Pagination.Cursor); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid cursor")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	type event struct {
+		payment Payment
+		order   *Order
+	}
+	var events []event
+	for _, order := range s.orders {
+		for _, payment := range order.Payments {
+			if payment.Status == "SUCCESS" && !payment.Time.Before(req.Filters.StartDate) && payment.Time.Before(req.Filters.EndDate) {
+				events = append(events, event{payment: payment, order: order})
+			}
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].payment.Time.Equal(events[j].payment.Time) {
+			return events[i].payment.Time.Before(events[j].payment.Time)
+		}
+		return events[i].payment.CFPaymentID < events[j].payment.CFPaymentID
+	})
+
+	data := []map[string]interface{}{}
+	for i := offset; i < len(events) && len(data) < req.Pagination.Limit; i++ {
+		e := events[i]
+		data = append(data, map[string]interface{}{
+			"event_id":       e.payment.CFPaymentID,
+			"event_type":     "PAYMENT",
+			"event_status":   e.payment.Status,
+			"event_amount":   e.payment.Amount,
+			"event_time":     e.payment.Time.Format(time.RFC3339),
+			"event_currency": e.order.Currency,
+			"order_id":       e.order.OrderID,
+			"order_amount":   e.order.Amount,
+		})
+	}
+	s.mu.Unlock()
+
+	var cursor interface{}
+	if next := offset + len(data); next < len(events) {
+		cursor = strconv.Itoa(next)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"cursor": cursor,
+		"limit":  req.Pagination.Limit,
+		"data":   data,
+	})
+}
+
+// orderResponse renders an order as the orders API of an x-api-version does
+func orderResponse(order *Order, version string) map[string]interface{} {
+	response := map[string]interface{}{
+		"cf_order_id":       order.CFOrderID,
+		"order_id":          order.OrderID,
+		"order_status":      order.Status,
+		"order_amount":      order.Amount,
+		"order_currency":    order.Currency,
+		"order_expiry_time": order.ExpiryTime.Format(time.RFC3339),
+		"order_note":        order.Note,
+		"order_tags":        order.Tags,
+		"created_at":        order.CreatedAt.Format(time.RFC3339),
+		"customer_details": map[string]string{
+			"customer_id":    order.CustomerID,
+			"customer_name":  order.CustomerName,
+			"customer_email": order.CustomerEmail,
+			"customer_phone": order.CustomerPhone,
+		},
+		"payment_link":       order.PaymentLink,
+		"payment_session_id": "session_" + order.CFOrderID,
+		"order_meta": map[string]string{
+			"return_url": order.ReturnURL,
+			"notify_url": order.NotifyURL,
+		},
+	}
+	if len(order.Splits) > 0 {
+		response["order_splits"] = order.Splits
+	}
+	if version == SessionOnlyAPIVersion {
+		delete(response, "payment_link")
+	}
+	return response
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes an error in Cashfree's error format
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	writeJSON(w, status, map[string]string{
+		"message": message,
+		"code":    "request_failed",
+		"type":    errorType,
+	})
+}
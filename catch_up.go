@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+)
+
+// Defaults of the catch-up command
+const (
+	defaultCatchUpWindow   = 2 * time.Hour
+	defaultCatchUpLookback = 24 * time.Hour
+)
+
+// RefundChange is a refund brought up to date with the gateway
+type RefundChange struct {
+	RefundID string              `json:"refund_id"`
+	OrderID  string              `json:"order_id"`
+	From     domain.RefundStatus `json:"from,omitempty"` // empty for refunds that were not recorded
+	To       domain.RefundStatus `json:"to"`
+}
+
+// CatchUpResult summarizes a catch-up of the state changes missed in a window
+type CatchUpResult struct {
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Checked  int               `json:"checked"`           // orders looked up at the gateway
+	Payments []ResyncChange    `json:"payments"`          // orders whose status changed
+	Refunds  []RefundChange    `json:"refunds"`           // refunds created or updated
+	Unknown  []string          `json:"unknown,omitempty"` // orders the gateway reported that are not recorded
+	Failed   map[string]string `json:"failed,omitempty"`  // error by order ID
+}
+
+// ListOpenPaymentsCreatedBetween returns the orders created at the gateway in
+// [from, to) that are still waiting for a payment or a termination, oldest first
+func (r *PaymentRepository) ListOpenPaymentsCreatedBetween(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE status IN ('ACTIVE', 'TERMINATION_REQUESTED') AND cf_order_id IS NOT NULL
+		  AND COALESCE(activate_at, created_at) >= $1 AND COALESCE(activate_at, created_at) < $2` + tenant + `
+		ORDER BY COALESCE(activate_at, created_at)
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// catchUp applies the state changes the gateway made in [from, to) that no webhook
+// delivered, such as while the service was down. It checks the orders client reports
+// payment or refund events for in the window, and the local orders created since
+// openSince that are still open, since the gateway only reports events once they are
+// reconciled. Each order's status is re-fetched and its refunds compared, and every
+// change is applied as its webhook would have been, events included. It stops early,
+// with the result so far, when ctx is done.
+func (h *PaymentHandler) catchUp(ctx context.Context, client *CashfreeClient, from, to, openSince time.Time, ratePerSecond float64) (*CatchUpResult, error) {
+	result := &CatchUpResult{From: from, To: to, Payments: []ResyncChange{}, Refunds: []RefundChange{}}
+	fail := func(orderID string, err error) {
+		if result.Failed == nil {
+			result.Failed = make(map[string]string)
+		}
+		result.Failed[orderID] = err.Error()
+	}
+
+	orderIDs, err := backfillOrderIDs(ctx, client.ForBatch(), from, to)
+	if err != nil {
+		return result, fmt.Errorf("failed to list Cashfree events: %w", err)
+	}
+	open, err := h.repo.ListOpenPaymentsCreatedBetween(ctx, openSince, to)
+	if err != nil {
+		return result, err
+	}
+	seen := make(map[string]bool)
+	for _, orderID := range orderIDs {
+		seen[orderID] = true
+	}
+	for _, payment := range open {
+		if !seen[payment.OrderID] {
+			seen[payment.OrderID] = true
+			orderIDs = append(orderIDs, payment.OrderID)
+		}
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / ratePerSecond))
+	defer ticker.Stop()
+
+	for _, orderID := range orderIDs {
+		payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+		if err != nil {
+			result.Unknown = append(result.Unknown, orderID)
+			continue
+		}
+
+		if result.Checked > 0 {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		result.Checked++
+
+		status, err := h.resyncPayment(ctx, payment)
+		if err != nil {
+			fail(orderID, err)
+			continue
+		}
+		if status != payment.Status {
+			result.Payments = append(result.Payments, ResyncChange{OrderID: orderID, From: payment.Status, To: status})
+		}
+
+		if payment.Gateway != "" && payment.Gateway != GatewayCashfree {
+			continue
+		}
+		changes, err := h.catchUpRefunds(ctx, payment)
+		result.Refunds = append(result.Refunds, changes...)
+		if err != nil {
+			fail(orderID, err)
+		}
+	}
+
+	return result, nil
+}
+
+// catchUpRefunds applies the refunds of an order whose status at the gateway differs
+// from the recorded one, recording those made outside the service
+func (h *PaymentHandler) catchUpRefunds(ctx context.Context, payment *Payment) ([]RefundChange, error) {
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := client.ForBatch().ListRefunds(payment.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []RefundChange
+	for _, refund := range remote {
+		change := RefundChange{RefundID: refund.RefundID, OrderID: payment.OrderID, To: refund.RefundStatus}
+		if local, err := h.repo.GetRefundByID(ctx, refund.RefundID); err == nil {
+			if local.Status == refund.RefundStatus {
+				continue
+			}
+			change.From = local.Status
+		}
+
+		err := h.applyRefundWebhook(ctx, RefundWebhook{
+			RefundID:     refund.RefundID,
+			CFRefundID:   refund.CFRefundID,
+			OrderID:      payment.OrderID,
+			RefundStatus: refund.RefundStatus,
+			RefundAmount: refund.RefundAmount,
+			RefundMode:   refund.RefundMode,
+			RefundARN:    refund.RefundARN,
+			RefundType:   refund.RefundType,
+			RefundNote:   refund.RefundNote,
+			ProcessedAt:  refund.ProcessedAt,
+		})
+		if err != nil {
+			return changes, fmt.Errorf("refund %s: %v", refund.RefundID, err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// runCatchUp implements the catch-up command, which applies the state changes Cashfree
+// made in a recent window that no webhook delivered
+func runCatchUp(args []string, handler *PaymentHandler, cfg *Config) error {
+	flags := flag.NewFlagSet("catch-up", flag.ContinueOnError)
+	since := flags.Duration("since", defaultCatchUpWindow, "length of the window ending now, when -from is not given")
+	fromTime := flags.String("from", "", "start of the window in RFC 3339, such as when the outage began")
+	toTime := flags.String("to", "", "end of the window in RFC 3339 (default now)")
+	lookback := flags.Duration("open-lookback", defaultCatchUpLookback, "also check local orders still open that were created this long before the window")
+	rate := flags.Float64("rate", defaultResyncRate, "orders checked per second")
+	environment := flags.String("environment", cfg.CashfreeEnvironment, "Cashfree environment to list events from")
+	merchantID := flags.String("merchant-id", "", "merchant to catch up in multi-merchant mode")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	to := handler.now()
+	if *toTime != "" {
+		parsed, err := time.Parse(time.RFC3339, *toTime)
+		if err != nil {
+			return errors.New("-to must be a time in RFC 3339 format")
+		}
+		to = parsed
+	}
+	from := to.Add(-*since)
+	if *fromTime != "" {
+		parsed, err := time.Parse(time.RFC3339, *fromTime)
+		if err != nil {
+			return errors.New("-from must be a time in RFC 3339 format")
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		return errors.New("the window must end after it starts")
+	}
+	if *rate <= 0 || *rate > maxResyncRate {
+		return fmt.Errorf("-rate must be between 0 and %g", maxResyncRate)
+	}
+	if *lookback < 0 {
+		return errors.New("-open-lookback must not be negative")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	if *merchantID != "" {
+		if handler.merchants == nil {
+			return errors.New("MERCHANT_ENCRYPTION_KEY must be set to catch up a merchant")
+		}
+		id, err := uuid.Parse(*merchantID)
+		if err != nil {
+			return fmt.Errorf("invalid merchant id: %v", err)
+		}
+		merchant, err := handler.merchants.GetMerchantByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		ctx = WithMerchant(ctx, merchant)
+	} else {
+		ctx = WithCashfreeEnvironment(ctx, strings.ToUpper(*environment))
+	}
+	client, err := handler.cashfreeFor(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := handler.catchUp(ctx, client, from, to, from.Add(-*lookback), *rate)
+	if result != nil {
+		fmt.Printf("Caught up %s to %s: checked %d orders, updated %d payments and %d refunds\n",
+			from.Format(time.RFC3339), to.Format(time.RFC3339), result.Checked, len(result.Payments), len(result.Refunds))
+		for _, change := range result.Payments {
+			fmt.Printf("%s: %s -> %s\n", change.OrderID, change.From, change.To)
+		}
+		for _, change := range result.Refunds {
+			from := string(change.From)
+			if from == "" {
+				from = "(not recorded)"
+			}
+			fmt.Printf("%s refund %s: %s -> %s\n", change.OrderID, change.RefundID, from, change.To)
+		}
+		for _, orderID := range result.Unknown {
+			fmt.Printf("%s is not recorded; import it with the backfill command\n", orderID)
+		}
+		for orderID, reason := range result.Failed {
+			fmt.Printf("Failed to catch up %s: %s\n", orderID, reason)
+		}
+	}
+	return err
+}
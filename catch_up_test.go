@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+func TestCatchUp(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	bus := events.NewMemoryBus(nil)
+	var mu sync.Mutex
+	published := map[string][]string{}
+	bus.Subscribe(events.All, func(ctx context.Context, event events.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		published[event.Type] = append(published[event.Type], event.OrderID)
+		return nil
+	})
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		publisher:    bus,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	prefix := fmt.Sprintf("order_catchup_%d", time.Now().UnixNano())
+	paid, refunded, open, remoteOnly := prefix+"_paid", prefix+"_refunded", prefix+"_open", prefix+"_remote"
+	for _, orderID := range []string{paid, refunded, open, remoteOnly} {
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+		if orderID == remoteOnly {
+			continue
+		}
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		require.NoError(t, handler.repo.CreatePayment(ctx, payment))
+	}
+
+	// While the service was down, orders were paid and one was auto-refunded, and none
+	// of the webhooks arrived
+	for _, orderID := range []string{paid, refunded, remoteOnly} {
+		_, err := server.CompletePayment(orderID, "upi")
+		require.NoError(t, err)
+	}
+	_, err := server.AutoRefund(refunded)
+	require.NoError(t, err)
+
+	now := time.Now()
+	result, err := handler.catchUp(ctx, client, now.Add(-time.Hour), now.Add(time.Hour), now.Add(-25*time.Hour), maxResyncRate)
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Checked)
+	assert.ElementsMatch(t, []ResyncChange{
+		{OrderID: paid, From: domain.PaymentActive, To: domain.PaymentPaid},
+		{OrderID: refunded, From: domain.PaymentActive, To: domain.PaymentPaid},
+	}, result.Payments)
+	require.Len(t, result.Refunds, 1)
+	assert.Equal(t, refunded, result.Refunds[0].OrderID)
+	assert.Empty(t, result.Refunds[0].From)
+	assert.Equal(t, domain.RefundStatus("SUCCESS"), result.Refunds[0].To)
+	assert.Equal(t, []string{remoteOnly}, result.Unknown)
+	assert.Empty(t, result.Failed)
+
+	refund, err := handler.repo.GetRefundByID(ctx, result.Refunds[0].RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, "PAYMENT_AUTO_REFUND", refund.Type)
+
+	bus.Wait()
+	mu.Lock()
+	assert.ElementsMatch(t, []string{paid, refunded}, published[events.PaymentSucceeded])
+	assert.Equal(t, []string{refunded}, published[events.RefundCreated])
+	mu.Unlock()
+
+	// Catching up again finds nothing left to apply
+	result, err = handler.catchUp(ctx, client, now.Add(-time.Hour), now.Add(time.Hour), now.Add(-25*time.Hour), maxResyncRate)
+	require.NoError(t, err)
+	assert.Empty(t, result.Payments)
+	assert.Empty(t, result.Refunds)
+}
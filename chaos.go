@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Fault injection targets
+const (
+	FaultTargetCashfree = "cashfree"
+	FaultTargetDatabase = "database"
+)
+
+// ErrFaultInjected is the cause of database queries failed by the fault injector
+var ErrFaultInjected = errors.New("fault injected")
+
+// FaultConfig describes the faults injected into one target. Rates are probabilities
+// between 0 and 1, applied to each call independently.
+type FaultConfig struct {
+	LatencyMS   int64   `json:"latency_ms"`
+	ErrorRate   float64 `json:"error_rate"`
+	PartialRate float64 `json:"partial_rate,omitempty"` // Cashfree only: truncate response bodies
+}
+
+func (f FaultConfig) latency() time.Duration {
+	return time.Duration(f.LatencyMS) * time.Millisecond
+}
+
+// Validate checks the rates and latency of a fault configuration for target
+func (f FaultConfig) Validate(target string) error {
+	switch target {
+	case FaultTargetCashfree, FaultTargetDatabase:
+	default:
+		return fmt.Errorf("unknown fault target %q, expected %s or %s", target, FaultTargetCashfree, FaultTargetDatabase)
+	}
+	if f.LatencyMS < 0 || f.LatencyMS > 60000 {
+		return fmt.Errorf("latency_ms must be between 0 and 60000, got %d", f.LatencyMS)
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1, got %v", f.ErrorRate)
+	}
+	if f.PartialRate < 0 || f.PartialRate > 1 {
+		return fmt.Errorf("partial_rate must be between 0 and 1, got %v", f.PartialRate)
+	}
+	if f.PartialRate > 0 && target != FaultTargetCashfree {
+		return fmt.Errorf("partial_rate only applies to %s", FaultTargetCashfree)
+	}
+	return nil
+}
+
+// FaultInjector adds latency, errors and truncated responses to Cashfree calls and
+// database queries, so circuit breakers and retries can be exercised in development.
+// It injects nothing until a target is configured.
+type FaultInjector struct {
+	mu     sync.RWMutex
+	faults map[string]FaultConfig
+	roll   func() float64
+}
+
+// NewFaultInjector creates a fault injector with no faults configured
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		faults: make(map[string]FaultConfig),
+		roll:   rand.Float64,
+	}
+}
+
+// Set replaces the faults injected into target
+func (f *FaultInjector) Set(target string, cfg FaultConfig) error {
+	if err := cfg.Validate(target); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[target] = cfg
+	return nil
+}
+
+// Clear stops injecting faults into every target
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = make(map[string]FaultConfig)
+}
+
+// Faults returns the faults configured for each target
+func (f *FaultInjector) Faults() map[string]FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	faults := make(map[string]FaultConfig, len(f.faults))
+	for target, cfg := range f.faults {
+		faults[target] = cfg
+	}
+	return faults
+}
+
+func (f *FaultInjector) get(target string) FaultConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.faults[target]
+}
+
+// hit reports whether a fault with the given rate happens on this call
+func (f *FaultInjector) hit(rate float64) bool {
+	return rate > 0 && f.roll() < rate
+}
+
+// delay waits for the configured latency or until ctx is done
+func delay(ctx context.Context, latency time.Duration) error {
+	if latency <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WrapClient routes a Cashfree client's requests through the injector. A nil injector
+// leaves the client unchanged.
+func (f *FaultInjector) WrapClient(client *CashfreeClient) {
+	if f == nil {
+		return
+	}
+	client.Client.SetTransport(f.Transport(client.Client.GetClient().Transport))
+}
+
+// Transport returns an http.RoundTripper that injects the Cashfree faults in front of next
+func (f *FaultInjector) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{faults: f, next: next}
+}
+
+type faultTransport struct {
+	faults *FaultInjector
+	next   http.RoundTripper
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := t.faults.get(FaultTargetCashfree)
+	if err := delay(req.Context(), cfg.latency()); err != nil {
+		return nil, err
+	}
+
+	if t.faults.hit(cfg.ErrorRate) {
+		log.Printf("Fault injected: 503 for %s %s", req.Method, req.URL.Path)
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		body := `{"message":"fault injected","code":"fault_injected","type":"api_error"}`
+		return &http.Response{
+			Status:        "503 Service Unavailable",
+			StatusCode:    http.StatusServiceUnavailable,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": []string{"application/json"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || !t.faults.hit(cfg.PartialRate) {
+		return resp, err
+	}
+
+	// Cut the body in half, as if the connection dropped mid-response
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Fault injected: truncated response for %s %s", req.Method, req.URL.Path)
+	body = body[:len(body)/2]
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// Tracer returns a pgx query tracer that injects the database faults. A failed query
+// runs with a cancelled context, so pgx rejects it before it reaches the server.
+func (f *FaultInjector) Tracer() pgx.QueryTracer {
+	return &faultTracer{faults: f}
+}
+
+type faultTracer struct {
+	faults *FaultInjector
+}
+
+func (t *faultTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	cfg := t.faults.get(FaultTargetDatabase)
+	if err := delay(ctx, cfg.latency()); err != nil {
+		return ctx
+	}
+	if t.faults.hit(cfg.ErrorRate) {
+		log.Printf("Fault injected: failing query %.60q", data.SQL)
+		ctx, cancel := context.WithCancelCause(ctx)
+		cancel(ErrFaultInjected)
+		return ctx
+	}
+	return ctx
+}
+
+func (t *faultTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+// FaultsHandler returns the faults configured for each target
+func (f *FaultInjector) FaultsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"faults": f.Faults()})
+}
+
+// SetFaultHandler replaces the faults injected into the target in the URL
+func (f *FaultInjector) SetFaultHandler(c *gin.Context) {
+	var cfg FaultConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	target := c.Param("target")
+	if err := f.Set(target, cfg); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("Fault injection for %s set to %+v", target, cfg)
+	c.JSON(http.StatusOK, gin.H{"faults": f.Faults()})
+}
+
+// ClearFaultsHandler stops injecting faults
+func (f *FaultInjector) ClearFaultsHandler(c *gin.Context) {
+	f.Clear()
+	log.Println("Fault injection cleared")
+	c.JSON(http.StatusOK, gin.H{"faults": f.Faults()})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestFaultConfigValidate(t *testing.T) {
+	assert.NoError(t, FaultConfig{LatencyMS: 200, ErrorRate: 0.5, PartialRate: 0.1}.Validate(FaultTargetCashfree))
+	assert.NoError(t, FaultConfig{LatencyMS: 200, ErrorRate: 1}.Validate(FaultTargetDatabase))
+
+	assert.ErrorContains(t, FaultConfig{}.Validate("queue"), `unknown fault target "queue"`)
+	assert.ErrorContains(t, FaultConfig{LatencyMS: -1}.Validate(FaultTargetCashfree), "latency_ms")
+	assert.ErrorContains(t, FaultConfig{ErrorRate: 1.5}.Validate(FaultTargetCashfree), "error_rate")
+	assert.ErrorContains(t, FaultConfig{PartialRate: 0.5}.Validate(FaultTargetDatabase), "partial_rate only applies to cashfree")
+}
+
+func TestFaultInjectorTripsCashfreeCircuitBreaker(t *testing.T) {
+	_, client := newFakeCashfree(t)
+	faults := NewFaultInjector()
+	faults.WrapClient(client)
+
+	// Nothing is injected until a target is configured
+	_, err := client.CreateOrder(testOrderRequest("order_chaos_1"))
+	require.NoError(t, err)
+
+	require.NoError(t, faults.Set(FaultTargetCashfree, FaultConfig{ErrorRate: 1}))
+	for i := 0; i < 5; i++ {
+		_, err := client.GetOrderStatus("order_chaos_1")
+		assert.ErrorContains(t, err, "status 503")
+	}
+	assert.Equal(t, CircuitOpen, client.Breaker.State())
+
+	faults.Clear()
+	_, err = client.GetOrderStatus("order_chaos_1")
+	assert.ErrorContains(t, err, ErrCircuitOpen.Error())
+}
+
+func TestFaultInjectorTruncatesCashfreeResponses(t *testing.T) {
+	_, client := newFakeCashfree(t)
+	faults := NewFaultInjector()
+	faults.WrapClient(client)
+
+	require.NoError(t, faults.Set(FaultTargetCashfree, FaultConfig{PartialRate: 1}))
+	_, err := client.CreateOrder(testOrderRequest("order_chaos_2"))
+	assert.Error(t, err)
+
+	// The order was created; only the response was cut short
+	faults.Clear()
+	status, err := client.GetOrderStatus("order_chaos_2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentActive, status.OrderStatus)
+}
+
+func TestFaultInjectorLatencyStopsWithRequestContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+
+	faults := NewFaultInjector()
+	require.NoError(t, faults.Set(FaultTargetCashfree, FaultConfig{LatencyMS: 10000}))
+	client := &http.Client{Transport: faults.Transport(nil)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = client.Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestFaultInjectorFailsDatabaseQueries(t *testing.T) {
+	faults := NewFaultInjector()
+	tracer := faults.Tracer()
+	data := pgx.TraceQueryStartData{SQL: "SELECT 1"}
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, data)
+	assert.NoError(t, ctx.Err())
+
+	require.NoError(t, faults.Set(FaultTargetDatabase, FaultConfig{ErrorRate: 1}))
+	ctx = tracer.TraceQueryStart(context.Background(), nil, data)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.ErrorIs(t, context.Cause(ctx), ErrFaultInjected)
+}
+
+func TestFaultAdminHandlers(t *testing.T) {
+	faults := NewFaultInjector()
+	r := gin.New()
+	r.GET("/admin/faults", faults.FaultsHandler)
+	r.PUT("/admin/faults/:target", faults.SetFaultHandler)
+	r.DELETE("/admin/faults", faults.ClearFaultsHandler)
+
+	do := func(method, path, body string) (int, map[string]FaultConfig) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp struct {
+			Faults map[string]FaultConfig `json:"faults"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Faults
+	}
+
+	code, got := do(http.MethodPut, "/admin/faults/cashfree", `{"latency_ms": 250, "error_rate": 0.2}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, FaultConfig{LatencyMS: 250, ErrorRate: 0.2}, got[FaultTargetCashfree])
+
+	code, _ = do(http.MethodPut, "/admin/faults/database", `{"error_rate": 2}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	code, _ = do(http.MethodPut, "/admin/faults/database", `not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, got = do(http.MethodGet, "/admin/faults", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, got, 1)
+
+	code, got = do(http.MethodDelete, "/admin/faults", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, got)
+	assert.Empty(t, faults.Faults())
+}
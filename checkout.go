@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+)
+
+// CheckoutTheme customizes the hosted checkout a customer pays on
+type CheckoutTheme struct {
+	DisplayName string `json:"display_name,omitempty"` // merchant name shown to the customer
+	LogoURL     string `json:"logo_url,omitempty"`
+	ThemeColor  string `json:"theme_color,omitempty"` // "#RRGGBB"
+}
+
+// themeColorPattern matches a #RRGGBB color
+var themeColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// Validate checks the theme's logo URL and color
+func (t CheckoutTheme) Validate() error {
+	if len(t.DisplayName) > 64 {
+		return errors.New("checkout display_name must be at most 64 characters")
+	}
+	if t.LogoURL != "" {
+		parsed, err := url.Parse(t.LogoURL)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return errors.New("checkout logo_url must be an absolute https URL")
+		}
+	}
+	if t.ThemeColor != "" && !themeColorPattern.MatchString(t.ThemeColor) {
+		return errors.New("checkout theme_color must be a #RRGGBB color")
+	}
+	return nil
+}
+
+// IsZero reports whether no customization is set
+func (t CheckoutTheme) IsZero() bool {
+	return t == CheckoutTheme{}
+}
+
+// orderTags returns the theme as gateway order tags, so it is visible on the order in
+// the gateway dashboard and can be applied by the checkout page
+func (t CheckoutTheme) orderTags() map[string]string {
+	if t.IsZero() {
+		return nil
+	}
+
+	tags := make(map[string]string)
+	if t.DisplayName != "" {
+		tags["merchant_display_name"] = t.DisplayName
+	}
+	if t.LogoURL != "" {
+		tags["merchant_logo_url"] = t.LogoURL
+	}
+	if t.ThemeColor != "" {
+		tags["theme_color"] = t.ThemeColor
+	}
+	return tags
+}
+
+// checkoutThemeFromTags reads back a theme stored in an order's tags by orderTags
+func checkoutThemeFromTags(tags map[string]string) CheckoutTheme {
+	return CheckoutTheme{
+		DisplayName: tags["merchant_display_name"],
+		LogoURL:     tags["merchant_logo_url"],
+		ThemeColor:  tags["theme_color"],
+	}
+}
+
+// checkoutThemeFor returns the checkout theme for a new order: the merchant's settings,
+// falling back field by field to the deployment's
+func (h *PaymentHandler) checkoutThemeFor(ctx context.Context) CheckoutTheme {
+	theme := h.checkout
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		if merchant.Checkout.DisplayName != "" {
+			theme.DisplayName = merchant.Checkout.DisplayName
+		}
+		if merchant.Checkout.LogoURL != "" {
+			theme.LogoURL = merchant.Checkout.LogoURL
+		}
+		if merchant.Checkout.ThemeColor != "" {
+			theme.ThemeColor = merchant.Checkout.ThemeColor
+		}
+	}
+	return theme
+}
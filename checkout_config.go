@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// CheckoutConfig is everything the Cashfree JS and mobile SDKs need to open the
+// drop-in checkout for an order
+type CheckoutConfig struct {
+	OrderID          string        `json:"order_id"`
+	PaymentSessionID string        `json:"payment_session_id"`
+	Environment      string        `json:"environment"` // TEST or PROD
+	Mode             string        `json:"mode"`        // the SDK's mode: sandbox or production
+	Amount           float64       `json:"amount"`
+	Currency         string        `json:"currency"`
+	Theme            CheckoutTheme `json:"theme"`
+	PaymentMethods   []string      `json:"payment_methods"` // methods the customer may pay with
+	ExpiresAt        *time.Time    `json:"expires_at,omitempty"`
+}
+
+// checkoutMode returns the Cashfree SDK mode of an environment
+func checkoutMode(environment string) string {
+	if strings.ToUpper(environment) == "PROD" {
+		return "production"
+	}
+	return "sandbox"
+}
+
+// GetCheckoutConfig returns the payment session, environment, theme and allowed payment
+// methods of an order in one call, for bootstrapping the Cashfree SDK's drop-in
+// checkout. Orders that can no longer be paid get 409.
+func (h *PaymentHandler) GetCheckoutConfig(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	switch {
+	case payment.Gateway != "" && payment.Gateway != GatewayCashfree:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Drop-in checkout is only available for Cashfree orders"})
+		return
+	case payment.Status == domain.PaymentScheduled || payment.Status == domain.PaymentActivating || payment.Status == domain.PaymentPendingReview:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has not been created at the gateway yet"})
+		return
+	case payment.Status == domain.PaymentCancelled:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is CANCELLED and can no longer be paid"})
+		return
+	}
+
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get checkout config"})
+		return
+	}
+
+	order, err := client.GetOrderStatus(orderID)
+	if err != nil {
+		log.Printf("Failed to get order %s: %v", orderID, err)
+		respondGatewayError(c, err, "Failed to get checkout config")
+		return
+	}
+	if order.OrderStatus != domain.PaymentActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is " + string(order.OrderStatus) + " and can no longer be paid"})
+		return
+	}
+	if !order.OrderExpiryTime.IsZero() && !clockOrSystem(h.clock).Now().Before(order.OrderExpiryTime) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has expired and can no longer be paid"})
+		return
+	}
+	if order.PaymentSessionID == "" {
+		log.Printf("Cashfree returned no payment session for order %s", orderID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to get checkout config"})
+		return
+	}
+
+	// The theme the order was created with, falling back to the current settings
+	theme := checkoutThemeFromTags(order.OrderTags)
+	if theme.IsZero() {
+		theme = h.checkoutThemeFor(ctx)
+	}
+
+	methods := CashfreePaymentMethods()
+	if payment.PaymentMethods != nil {
+		methods = strings.Split(*payment.PaymentMethods, ",")
+	}
+
+	config := CheckoutConfig{
+		OrderID:          orderID,
+		PaymentSessionID: order.PaymentSessionID,
+		Environment:      strings.ToUpper(client.Environment),
+		Mode:             checkoutMode(client.Environment),
+		Amount:           payment.Amount,
+		Currency:         payment.Currency,
+		Theme:            theme,
+		PaymentMethods:   methods,
+	}
+	if !order.OrderExpiryTime.IsZero() {
+		config.ExpiresAt = &order.OrderExpiryTime
+	}
+
+	c.JSON(http.StatusOK, config)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckoutMode(t *testing.T) {
+	assert.Equal(t, "sandbox", checkoutMode("TEST"))
+	assert.Equal(t, "production", checkoutMode("prod"))
+}
+
+func TestGetCheckoutConfig(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		checkout:     CheckoutTheme{DisplayName: "Marketplace"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	serve := func(orderID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/checkout/"+orderID+"/config", nil))
+		return w
+	}
+	createOrder := func(orderID, methods string) {
+		payment := testPayment(orderID)
+		req := testOrderRequest(orderID)
+		if methods != "" {
+			payment.PaymentMethods = &methods
+			req.OrderMeta.PaymentMethods = methods
+		}
+		require.NoError(t, handler.repo.CreatePayment(context.Background(), payment))
+		_, err := client.CreateOrder(req)
+		require.NoError(t, err)
+	}
+
+	orderID := fmt.Sprintf("order_checkout_%d", time.Now().UnixNano())
+	createOrder(orderID, "upi,cc")
+	w := serve(orderID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var config CheckoutConfig
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	assert.Equal(t, orderID, config.OrderID)
+	assert.NotEmpty(t, config.PaymentSessionID)
+	assert.Equal(t, "TEST", config.Environment)
+	assert.Equal(t, "sandbox", config.Mode)
+	assert.Equal(t, []string{"upi", "cc"}, config.PaymentMethods)
+	// The order's tags carry the theme it was created with
+	assert.Equal(t, "#1A73E8", config.Theme.ThemeColor)
+	assert.NotNil(t, config.ExpiresAt)
+
+	// Unrestricted orders may be paid with any method
+	open := orderID + "_open"
+	createOrder(open, "")
+	w = serve(open)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &config))
+	assert.Equal(t, CashfreePaymentMethods(), config.PaymentMethods)
+
+	// Paid orders are no longer payable
+	_, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, serve(orderID).Code)
+
+	assert.Equal(t, http.StatusNotFound, serve("order_missing").Code)
+}
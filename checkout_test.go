@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckoutThemeValidate(t *testing.T) {
+	assert.NoError(t, CheckoutTheme{}.Validate())
+	assert.NoError(t, CheckoutTheme{DisplayName: "Acme", LogoURL: "https://cdn.acme.in/logo.png", ThemeColor: "#1A2b3C"}.Validate())
+	assert.Error(t, CheckoutTheme{LogoURL: "http://cdn.acme.in/logo.png"}.Validate())
+	assert.Error(t, CheckoutTheme{ThemeColor: "blue"}.Validate())
+	assert.Error(t, CheckoutTheme{ThemeColor: "#12345"}.Validate())
+}
+
+func TestCheckoutThemeFor(t *testing.T) {
+	handler := &PaymentHandler{checkout: CheckoutTheme{DisplayName: "Marketplace", ThemeColor: "#000000"}}
+
+	theme := handler.checkoutThemeFor(context.Background())
+	assert.Equal(t, map[string]string{"merchant_display_name": "Marketplace", "theme_color": "#000000"}, theme.orderTags())
+
+	// A merchant's settings override the deployment's field by field
+	merchant := &Merchant{Checkout: CheckoutTheme{DisplayName: "Acme", LogoURL: "https://cdn.acme.in/logo.png"}}
+	theme = handler.checkoutThemeFor(WithMerchant(context.Background(), merchant))
+	assert.Equal(t, CheckoutTheme{DisplayName: "Acme", LogoURL: "https://cdn.acme.in/logo.png", ThemeColor: "#000000"}, theme)
+
+	assert.Nil(t, CheckoutTheme{}.orderTags())
+}
+
+func TestCheckoutThemeFromTags(t *testing.T) {
+	theme := CheckoutTheme{DisplayName: "Acme", LogoURL: "https://cdn.acme.in/logo.png", ThemeColor: "#1A73E8"}
+	assert.Equal(t, theme, checkoutThemeFromTags(theme.orderTags()))
+	assert.True(t, checkoutThemeFromTags(map[string]string{"channel": "app"}).IsZero())
+}
@@ -0,0 +1,27 @@
+package main
+
+import "time"
+
+// Clock tells the current time. Handlers, repositories and scheduled jobs read the time
+// from a Clock rather than calling time.Now, so tests can fix it and step it forward.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockOrSystem returns c, or the wall clock when c is nil, so a zero-value handler or
+// repository tells the real time
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
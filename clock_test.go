@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// testClock is a Clock that only moves when the test advances it
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock(now time.Time) *testClock {
+	return &testClock{now: now}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestClockOrSystem(t *testing.T) {
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, clock, clockOrSystem(clock))
+	assert.Equal(t, SystemClock, clockOrSystem(nil))
+	assert.WithinDuration(t, time.Now(), (&PaymentHandler{}).now(), time.Second)
+}
+
+func TestStatusTokenExpiresOnHandlerClock(t *testing.T) {
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	handler := &PaymentHandler{
+		statusTokens: NewStatusTokenIssuer("secret", 15*time.Minute),
+		clock:        clock,
+	}
+	token, _ := handler.statusTokens.Issue("order_123", nil, clock.Now())
+
+	verify := func() (string, bool, int) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/status?token="+token, nil)
+		_, orderID, ok := handler.orderFromStatusToken(c)
+		return orderID, ok, w.Code
+	}
+
+	clock.Advance(14 * time.Minute)
+	orderID, ok, _ := verify()
+	assert.True(t, ok)
+	assert.Equal(t, "order_123", orderID)
+
+	clock.Advance(time.Minute)
+	_, ok, code := verify()
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
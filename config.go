@@ -0,0 +1,630 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/events"
+	"payment-getway/notify"
+	"payment-getway/queue"
+	"payment-getway/storage"
+)
+
+// Config is the service configuration, loaded from environment variables at startup
+type Config struct {
+	Port        string
+	DatabaseURL string
+
+	// GRPCPort serves the event stream, alongside the admin API
+	GRPCPort string
+
+	// On SIGTERM the instance reports unready for ShutdownDrainDelay, so load balancers
+	// stop routing to it, then waits up to ShutdownTimeout for requests in flight
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+
+	// Cashfree credentials by environment ("TEST" or "PROD"). CASHFREE_CLIENT_ID and
+	// CASHFREE_CLIENT_SECRET belong to CashfreeEnvironment, the default for requests;
+	// CASHFREE_TEST_* and CASHFREE_PROD_* configure each environment explicitly.
+	Cashfree            map[string]CashfreeCredentials
+	CashfreeEnvironment string
+
+	// CashfreeStartupCheck is what happens when Cashfree rejects the credentials at
+	// startup: StartupCheckFail, StartupCheckWarn or StartupCheckOff
+	CashfreeStartupCheck string
+
+	// CashfreeAPI is the x-api-version every Cashfree client sends, so the service can
+	// move to a newer version with one setting
+	CashfreeAPI CashfreeAPI
+
+	// CashfreeTransport routes Cashfree calls through a proxy, trusts extra CAs or
+	// presents a client certificate
+	CashfreeTransport CashfreeTransport
+
+	// CashfreeTimeouts limit each attempt at a Cashfree call, shorter for calls a
+	// customer waits on at checkout and longer for background jobs
+	CashfreeTimeouts CashfreeTimeouts
+
+	// Razorpay fallback gateway, disabled when RazorpayKeyID is empty
+	RazorpayKeyID     string
+	RazorpayKeySecret string
+
+	MultiMerchant         bool
+	MerchantEncryptionKey string // empty in single-merchant deployments
+
+	// Versioned keys for rotating MerchantEncryptionKey, as "<key id>:<key>", and the
+	// ID of the one new values are encrypted with; empty keeps MerchantEncryptionKey
+	MerchantEncryptionKeys  []string
+	MerchantEncryptionKeyID string
+
+	// Return and notify URLs of new orders in single-merchant mode, and the default for
+	// merchants without their own
+	OrderURLs OrderURLs
+
+	// Hosted checkout theme, the default for merchants without their own
+	Checkout CheckoutTheme
+
+	// PhoneRegion is the region of customer phones given without a country code
+	PhoneRegion string
+
+	// AllowedPaymentMethods are the Cashfree payment methods new orders may be
+	// restricted to; empty allows all of them
+	AllowedPaymentMethods []string
+
+	// DuplicateSessions is how create-session answers an order that already has a
+	// session: "reuse" returns its ACTIVE, unexpired gateway order, "reject" answers 409
+	DuplicateSessions string
+
+	// RefundApprovalThreshold is the INR amount above which a refund waits for a second
+	// user's approval before it is sent to Cashfree; 0 disables approval
+	RefundApprovalThreshold float64
+
+	// SplitRecoveryEvents publishes a vendor.recovery_due event for each vendor share
+	// reversed when a split order is fully refunded
+	SplitRecoveryEvents bool
+
+	// AdminAPIKey enables the /admin routes; empty disables them
+	AdminAPIKey string
+
+	// ChaosMode enables fault injection into Cashfree calls and database queries,
+	// controlled through /admin/faults. Development only.
+	ChaosMode bool
+
+	StatusTokenSecret string // empty to generate a per-process secret
+	StatusTokenTTL    time.Duration
+
+	EventBus string // "memory" or "nats"
+	NATS     events.NATSConfig
+
+	// EventRetrySchedule is the seconds before each retry of an event delivery to a
+	// merchant endpoint created without a schedule of its own
+	EventRetrySchedule []int
+
+	QueueBackend  string // "memory", "postgres" or "rabbitmq"
+	QueueWorkers  int
+	RabbitMQ      queue.RabbitMQConfig
+	PostgresQueue queue.PostgresConfig
+
+	EmailProvider string // "smtp", "ses", or empty to disable email
+	SMTP          notify.SMTPConfig
+	SESRegion     string
+	Email         notify.EmailConfig
+
+	// NotificationConsentRequired withholds payment links and reminders from customers
+	// who never stated their consent to the channel
+	NotificationConsentRequired bool
+
+	// Slack alerts, disabled when Slack.WebhookURL is empty
+	Slack notify.SlackConfig
+
+	Invoices InvoiceConfig
+	Ledgers  AccountingLedgers
+
+	// Platform fees deducted from vendor splits
+	Fees FeeModel
+
+	ReportStorage      string // "s3", "gcs", or empty to disable report delivery
+	Reports            storage.Config
+	ReportScheduleHour int // hour of day in ReportLocation
+	GCSHMACAccessKey   string
+	GCSHMACSecret      string
+
+	ReconEnabled      bool
+	ReconScheduleHour int // hour of day in ReportLocation
+
+	// Reminders of orders left unpaid
+	Reminders ReminderConfig
+
+	// Polling the gateway for orders whose webhook has not arrived
+	StatusPoller StatusPollerConfig
+
+	// Serving order statuses from the database while refreshing them in the background
+	StatusCache StatusCacheConfig
+
+	// PaymentAttemptCacheSize is how many orders' payment attempts are kept in memory
+	PaymentAttemptCacheSize int
+
+	// VerifyMinInterval is how often verifying one order may ask the gateway; 0 asks it
+	// on every verification
+	VerifyMinInterval time.Duration
+
+	// Risk screening of new payment sessions
+	Risk RiskConfig
+
+	// ReportLocation is the time zone of the business day that reports, exports and
+	// quotas follow, unless a merchant sets its own
+	ReportLocation *time.Location
+
+	Runtime RuntimeConfig
+}
+
+// CashfreeCredentials are the API keys of one Cashfree environment
+type CashfreeCredentials struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// ConfigError reports every missing or invalid environment variable
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// envReader reads environment variables, collecting problems instead of stopping at the first
+type envReader struct {
+	problems []string
+}
+
+func (r *envReader) problem(format string, args ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *envReader) str(key string) string {
+	return strings.TrimSpace(os.Getenv(key))
+}
+
+func (r *envReader) required(key string) string {
+	value := r.str(key)
+	if value == "" {
+		r.problem("%s is required", key)
+	}
+	return value
+}
+
+func (r *envReader) boolean(key string) bool {
+	value := r.str(key)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		r.problem("%s must be true or false, got %q", key, value)
+	}
+	return b
+}
+
+// integer reads an integer within [min, max], returning def when unset
+func (r *envReader) integer(key string, def, min, max int) int {
+	value := r.str(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		r.problem("%s must be an integer between %d and %d, got %q", key, min, max, value)
+		return def
+	}
+	return n
+}
+
+func (r *envReader) float(key string) float64 {
+	value := r.str(key)
+	if value == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		r.problem("%s must be a non-negative number, got %q", key, value)
+		return 0
+	}
+	return f
+}
+
+// oneOf reads a case-insensitive enum, returning it lower-cased or def when unset
+func (r *envReader) oneOf(key, def string, allowed ...string) string {
+	value := strings.ToLower(r.str(key))
+	if value == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	r.problem("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value)
+	return def
+}
+
+// url reads an absolute URL with one of the given schemes
+func (r *envReader) url(key string, schemes ...string) string {
+	value := r.str(key)
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		r.problem("%s must be an absolute URL, got %q", key, value)
+		return value
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return value
+		}
+	}
+	r.problem("%s must use scheme %s, got %q", key, strings.Join(schemes, " or "), u.Scheme)
+	return value
+}
+
+func (r *envReader) list(key string) []string {
+	value := r.str(key)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// LoadConfig reads and validates the configuration, reporting every problem at once
+func LoadConfig() (*Config, error) {
+	r := &envReader{}
+	cfg := &Config{}
+
+	cfg.Port = strconv.Itoa(r.integer("PORT", 8080, 1, 65535))
+	cfg.GRPCPort = strconv.Itoa(r.integer("GRPC_PORT", 9090, 1, 65535))
+	if cfg.GRPCPort == cfg.Port {
+		r.problem("GRPC_PORT must differ from PORT")
+	}
+	cfg.ShutdownDrainDelay = time.Duration(r.integer("SHUTDOWN_DRAIN_SECONDS", 5, 0, 300)) * time.Second
+	cfg.ShutdownTimeout = time.Duration(r.integer("SHUTDOWN_TIMEOUT_SECONDS", 30, 1, 600)) * time.Second
+
+	cfg.DatabaseURL = r.required("DATABASE_URL")
+	if cfg.DatabaseURL != "" {
+		if _, err := pgxpool.ParseConfig(cfg.DatabaseURL); err != nil {
+			r.problem("DATABASE_URL is not a valid PostgreSQL connection string: %v", err)
+		}
+	}
+
+	// Merchant accounts bring their own Cashfree credentials
+	cfg.MultiMerchant = r.boolean("MULTI_MERCHANT")
+	cfg.MerchantEncryptionKey = r.str("MERCHANT_ENCRYPTION_KEY")
+	cfg.MerchantEncryptionKeys = r.list("MERCHANT_ENCRYPTION_KEYS")
+	cfg.MerchantEncryptionKeyID = r.str("MERCHANT_ENCRYPTION_KEY_ID")
+	if cfg.MerchantEncryptionKey != "" {
+		if _, err := newSecretBox(cfg); err != nil {
+			r.problem("%v", err)
+		}
+	} else if cfg.MultiMerchant {
+		r.problem("MERCHANT_ENCRYPTION_KEY is required when MULTI_MERCHANT is enabled")
+	} else if len(cfg.MerchantEncryptionKeys) > 0 || cfg.MerchantEncryptionKeyID != "" {
+		r.problem("MERCHANT_ENCRYPTION_KEYS and MERCHANT_ENCRYPTION_KEY_ID need MERCHANT_ENCRYPTION_KEY")
+	}
+
+	cfg.CashfreeEnvironment = strings.ToUpper(r.oneOf("CASHFREE_ENVIRONMENT", "test", "test", "prod"))
+	cfg.Cashfree = make(map[string]CashfreeCredentials)
+	if id, secret := r.str("CASHFREE_CLIENT_ID"), r.str("CASHFREE_CLIENT_SECRET"); id != "" || secret != "" {
+		cfg.Cashfree[cfg.CashfreeEnvironment] = CashfreeCredentials{
+			ClientID:     r.required("CASHFREE_CLIENT_ID"),
+			ClientSecret: r.required("CASHFREE_CLIENT_SECRET"),
+		}
+	}
+	for _, env := range []string{"TEST", "PROD"} {
+		idKey, secretKey := "CASHFREE_"+env+"_CLIENT_ID", "CASHFREE_"+env+"_CLIENT_SECRET"
+		if r.str(idKey) != "" || r.str(secretKey) != "" {
+			cfg.Cashfree[env] = CashfreeCredentials{
+				ClientID:     r.required(idKey),
+				ClientSecret: r.required(secretKey),
+			}
+		}
+	}
+	if _, ok := cfg.Cashfree[cfg.CashfreeEnvironment]; !ok && !cfg.MultiMerchant {
+		r.required("CASHFREE_CLIENT_ID")
+		r.required("CASHFREE_CLIENT_SECRET")
+	}
+	cfg.CashfreeStartupCheck = r.oneOf("CASHFREE_STARTUP_CHECK", StartupCheckFail, StartupCheckFail, StartupCheckWarn, StartupCheckOff)
+	cfg.CashfreeAPI = CashfreeAPI{
+		Version:             r.oneOf("CASHFREE_API_VERSION", DefaultCashfreeAPIVersion, CashfreeAPIVersions()...),
+		CheckoutURLTemplate: r.str("CASHFREE_CHECKOUT_URL"),
+	}
+	if cfg.CashfreeAPI.CheckoutURLTemplate != "" {
+		if err := validateTemplate("CASHFREE_CHECKOUT_URL", cfg.CashfreeAPI.CheckoutURLTemplate, checkoutURLVariables); err != nil {
+			r.problem("%v", err)
+		}
+	}
+	problems := len(r.problems)
+	cfg.CashfreeTransport = CashfreeTransport{
+		ProxyURL:            r.url("CASHFREE_PROXY_URL", "http", "https"),
+		CABundle:            r.str("CASHFREE_CA_BUNDLE"),
+		ClientCert:          r.str("CASHFREE_CLIENT_CERT"),
+		ClientKey:           r.str("CASHFREE_CLIENT_KEY"),
+		MaxIdleConnsPerHost: r.integer("CASHFREE_MAX_IDLE_CONNS_PER_HOST", 0, 0, 1000),
+	}
+	if (cfg.CashfreeTransport.ClientCert == "") != (cfg.CashfreeTransport.ClientKey == "") {
+		r.problem("CASHFREE_CLIENT_CERT and CASHFREE_CLIENT_KEY must be set together")
+	}
+	// The certificate files are only read once the settings themselves are valid
+	if len(r.problems) == problems {
+		if _, err := cfg.CashfreeTransport.Build(); err != nil {
+			r.problem("Cashfree transport: %v", err)
+		}
+	}
+
+	cfg.CashfreeTimeouts = CashfreeTimeouts{
+		Default:  time.Duration(r.integer("CASHFREE_TIMEOUT_SECONDS", 30, 1, 300)) * time.Second,
+		Checkout: time.Duration(r.integer("CASHFREE_CHECKOUT_TIMEOUT_SECONDS", 10, 1, 300)) * time.Second,
+		Batch:    time.Duration(r.integer("CASHFREE_BATCH_TIMEOUT_SECONDS", 120, 1, 1800)) * time.Second,
+	}
+
+	cfg.RazorpayKeyID = r.str("RAZORPAY_KEY_ID")
+	if cfg.RazorpayKeyID != "" {
+		cfg.RazorpayKeySecret = r.required("RAZORPAY_KEY_SECRET")
+	}
+	cfg.Runtime = loadRuntimeConfig(r)
+	if cfg.Runtime.GatewayAutoFailover && cfg.RazorpayKeyID == "" {
+		r.problem("GATEWAY_AUTO_FAILOVER requires RAZORPAY_KEY_ID")
+	}
+
+	cfg.OrderURLs = OrderURLs{
+		ReturnURLTemplate: r.str("RETURN_URL_TEMPLATE"),
+		NotifyURLTemplate: r.str("NOTIFY_URL_TEMPLATE"),
+	}
+	for key, template := range map[string]string{"RETURN_URL_TEMPLATE": cfg.OrderURLs.ReturnURLTemplate, "NOTIFY_URL_TEMPLATE": cfg.OrderURLs.NotifyURLTemplate} {
+		if template == "" {
+			continue
+		}
+		if err := validateURLTemplate(key, template); err != nil {
+			r.problem("%v", err)
+		}
+	}
+	for _, host := range r.list("ALLOWED_URL_HOSTS") {
+		host = strings.TrimSpace(host)
+		if !validAllowedHost(host) {
+			r.problem("ALLOWED_URL_HOSTS entries must be hosts such as shop.example.com or *.example.com, got %q", host)
+			continue
+		}
+		cfg.OrderURLs.AllowedHosts = append(cfg.OrderURLs.AllowedHosts, host)
+	}
+
+	cfg.Checkout = CheckoutTheme{
+		DisplayName: r.str("CHECKOUT_DISPLAY_NAME"),
+		LogoURL:     r.str("CHECKOUT_LOGO_URL"),
+		ThemeColor:  r.str("CHECKOUT_THEME_COLOR"),
+	}
+	if err := cfg.Checkout.Validate(); err != nil {
+		r.problem("%v", err)
+	}
+	cfg.PhoneRegion = strings.ToUpper(r.str("PHONE_DEFAULT_REGION"))
+	if cfg.PhoneRegion == "" {
+		cfg.PhoneRegion = DefaultPhoneRegion
+	} else if _, ok := phoneRegions[cfg.PhoneRegion]; !ok {
+		r.problem("PHONE_DEFAULT_REGION must be one of %s, got %q", strings.Join(PhoneRegions(), ", "), cfg.PhoneRegion)
+	}
+	if methods := r.str("ALLOWED_PAYMENT_METHODS"); methods != "" {
+		allowed, err := parsePaymentMethods(methods, nil)
+		if err != nil {
+			r.problem("ALLOWED_PAYMENT_METHODS: %v", err)
+		}
+		cfg.AllowedPaymentMethods = allowed
+	}
+	cfg.DuplicateSessions = r.oneOf("DUPLICATE_SESSIONS", DuplicateSessionsReuse, DuplicateSessionsReuse, DuplicateSessionsReject)
+	cfg.RefundApprovalThreshold = r.float("REFUND_APPROVAL_THRESHOLD")
+	cfg.SplitRecoveryEvents = r.boolean("SPLIT_RECOVERY_EVENTS")
+
+	cfg.AdminAPIKey = r.str("ADMIN_API_KEY")
+	if cfg.AdminAPIKey != "" && len(cfg.AdminAPIKey) < 32 {
+		r.problem("ADMIN_API_KEY must be at least 32 characters")
+	}
+
+	cfg.ChaosMode = r.boolean("CHAOS_MODE")
+	if cfg.ChaosMode {
+		if cfg.AdminAPIKey == "" {
+			r.problem("CHAOS_MODE requires ADMIN_API_KEY")
+		}
+		if _, ok := cfg.Cashfree[EnvironmentProd]; ok || cfg.CashfreeEnvironment == EnvironmentProd {
+			r.problem("CHAOS_MODE is for development and cannot be used with PROD Cashfree credentials")
+		}
+	}
+
+	cfg.StatusTokenSecret = r.str("STATUS_TOKEN_SECRET")
+	cfg.StatusTokenTTL = time.Duration(r.integer("STATUS_TOKEN_TTL_MINUTES", 15, 1, 24*60)) * time.Minute
+
+	cfg.EventBus = r.oneOf("EVENT_BUS", "memory", "memory", "nats")
+	cfg.NATS = events.NATSConfig{
+		URL:           r.url("NATS_URL", "nats", "tls"),
+		Stream:        r.str("NATS_STREAM"),
+		SubjectPrefix: r.str("NATS_SUBJECT_PREFIX"),
+		MaxRetries:    r.integer("NATS_MAX_RETRIES", 0, 0, 100),
+	}
+	schedule, err := parseRetrySchedule(r.list("EVENT_RETRY_SCHEDULE"))
+	if err != nil {
+		r.problem("EVENT_RETRY_SCHEDULE %v", err)
+	}
+	cfg.EventRetrySchedule = schedule
+
+	cfg.QueueBackend = r.oneOf("QUEUE_BACKEND", "memory", "memory", "postgres", "rabbitmq")
+	cfg.QueueWorkers = r.integer("QUEUE_WORKERS", 0, 0, 1000)
+	cfg.PostgresQueue = queue.PostgresConfig{
+		Workers:           cfg.QueueWorkers,
+		MaxAttempts:       r.integer("QUEUE_MAX_ATTEMPTS", queue.DefaultMaxAttempts, 1, 100),
+		VisibilityTimeout: time.Duration(r.integer("QUEUE_VISIBILITY_TIMEOUT_SECONDS", 60, 5, 60*60)) * time.Second,
+		PollInterval:      time.Duration(r.integer("QUEUE_POLL_INTERVAL_SECONDS", 1, 1, 60)) * time.Second,
+	}
+	cfg.RabbitMQ = queue.RabbitMQConfig{
+		Exchange: r.str("RABBITMQ_EXCHANGE"),
+		Queue:    r.str("RABBITMQ_QUEUE"),
+		Prefetch: r.integer("RABBITMQ_PREFETCH", 0, 0, 65535),
+		Workers:  cfg.QueueWorkers,
+	}
+	if cfg.QueueBackend == "rabbitmq" {
+		r.required("RABBITMQ_URL")
+	}
+	cfg.RabbitMQ.URL = r.url("RABBITMQ_URL", "amqp", "amqps")
+
+	cfg.EmailProvider = r.oneOf("EMAIL_PROVIDER", "", "smtp", "ses")
+	cfg.SMTP = notify.SMTPConfig{
+		Host:     r.str("SMTP_HOST"),
+		Port:     r.str("SMTP_PORT"),
+		Username: r.str("SMTP_USERNAME"),
+		Password: r.str("SMTP_PASSWORD"),
+		From:     r.str("EMAIL_FROM"),
+	}
+	cfg.SESRegion = r.str("SES_REGION")
+	cfg.Email = notify.EmailConfig{
+		MerchantEmail: r.str("MERCHANT_EMAIL"),
+		Enabled:       r.list("EMAIL_EVENTS"),
+		TemplateDir:   r.str("EMAIL_TEMPLATE_DIR"),
+	}
+	cfg.NotificationConsentRequired = r.boolean("NOTIFICATION_CONSENT_REQUIRED")
+	switch cfg.EmailProvider {
+	case "smtp":
+		r.required("SMTP_HOST")
+		r.required("EMAIL_FROM")
+	case "ses":
+		r.required("SES_REGION")
+		r.required("EMAIL_FROM")
+	}
+	if cfg.SMTP.Port != "" {
+		r.integer("SMTP_PORT", 0, 1, 65535)
+	}
+
+	cfg.Slack = notify.SlackConfig{
+		WebhookURL:      r.url("SLACK_WEBHOOK_URL", "https"),
+		RefundThreshold: r.float("SLACK_REFUND_THRESHOLD"),
+		Enabled:         r.list("SLACK_EVENTS"),
+	}
+
+	cfg.Invoices = InvoiceConfig{
+		Prefix:            r.str("INVOICE_PREFIX"),
+		MerchantGSTIN:     r.str("MERCHANT_GSTIN"),
+		MerchantStateCode: r.str("MERCHANT_STATE_CODE"),
+	}
+	if gstin := cfg.Invoices.MerchantGSTIN; gstin != "" && len(gstin) != 15 {
+		r.problem("MERCHANT_GSTIN must be 15 characters, got %q", gstin)
+	}
+	if code := cfg.Invoices.MerchantStateCode; code != "" {
+		if _, err := strconv.Atoi(code); err != nil || len(code) != 2 {
+			r.problem("MERCHANT_STATE_CODE must be a two digit GST state code, got %q", code)
+		}
+	}
+
+	cfg.Ledgers = DefaultAccountingLedgers()
+	if name := r.str("ACCOUNTING_CLEARING_LEDGER"); name != "" {
+		cfg.Ledgers.Clearing = name
+	}
+	if name := r.str("ACCOUNTING_SALES_LEDGER"); name != "" {
+		cfg.Ledgers.Sales = name
+	}
+	if name := r.str("ACCOUNTING_SALES_RETURNS_LEDGER"); name != "" {
+		cfg.Ledgers.SalesReturns = name
+	}
+	if name := r.str("ACCOUNTING_BANK_LEDGER"); name != "" {
+		cfg.Ledgers.Bank = name
+	}
+	if name := r.str("ACCOUNTING_COMMISSION_LEDGER"); name != "" {
+		cfg.Ledgers.CommissionIncome = name
+	}
+	if name := r.str("ACCOUNTING_VENDOR_PAYABLES_LEDGER"); name != "" {
+		cfg.Ledgers.VendorPayables = name
+	}
+	if name := r.str("ACCOUNTING_GATEWAY_CHARGES_LEDGER"); name != "" {
+		cfg.Ledgers.GatewayCharges = name
+	}
+
+	cfg.Fees.CommissionPercent = r.float("PLATFORM_COMMISSION_PERCENT")
+	if cfg.Fees.CommissionPercent >= 100 {
+		r.problem("PLATFORM_COMMISSION_PERCENT must be below 100, got %v", cfg.Fees.CommissionPercent)
+	}
+	cfg.Fees.FixedFee = r.float("PLATFORM_FIXED_FEE")
+	slabs, err := parseFeeSlabs(r.list("PLATFORM_FEE_SLABS"))
+	if err != nil {
+		r.problem("PLATFORM_FEE_SLABS %v", err)
+	}
+	cfg.Fees.Slabs = slabs
+
+	cfg.ReportStorage = r.oneOf("REPORT_STORAGE", "", "s3", "gcs")
+	cfg.Reports = storage.Config{
+		Bucket:   r.str("REPORT_BUCKET"),
+		Prefix:   r.str("REPORT_PREFIX"),
+		Region:   r.str("REPORT_REGION"),
+		Endpoint: r.url("REPORT_ENDPOINT", "http", "https"),
+		KMSKeyID: r.str("REPORT_KMS_KEY_ID"),
+	}
+	switch sse := r.str("REPORT_SSE"); sse {
+	case "", "AES256", "aws:kms":
+		cfg.Reports.SSE = sse
+	default:
+		r.problem("REPORT_SSE must be AES256 or aws:kms, got %q", sse)
+	}
+	cfg.ReportScheduleHour = r.integer("REPORT_SCHEDULE_HOUR", 1, 0, 23)
+	cfg.ReportLocation = istLocation
+	if name := r.str("REPORT_TIMEZONE"); name != "" {
+		loc, err := loadTimezone(name)
+		if err != nil {
+			r.problem("REPORT_TIMEZONE: %v", err)
+		} else {
+			cfg.ReportLocation = loc
+		}
+	}
+	cfg.ReconEnabled = r.boolean("RECON_ENABLED")
+	cfg.ReconScheduleHour = r.integer("RECON_SCHEDULE_HOUR", 2, 0, 23)
+	cfg.Reminders = ReminderConfig{
+		Enabled:            r.boolean("REMINDERS_ENABLED"),
+		Delay:              time.Duration(r.integer("REMINDER_DELAY_MINUTES", 30, 5, 12*60)) * time.Minute,
+		MaxPerOrder:        r.integer("REMINDER_MAX_PER_ORDER", 2, 1, 10),
+		CustomerDailyLimit: r.integer("REMINDER_CUSTOMER_DAILY_LIMIT", 3, 1, 50),
+	}
+	cfg.StatusPoller = StatusPollerConfig{
+		Enabled:       r.boolean("STATUS_POLLER_ENABLED"),
+		After:         time.Duration(r.integer("STATUS_POLL_AFTER_SECONDS", 120, 10, 24*60*60)) * time.Second,
+		Interval:      time.Duration(r.integer("STATUS_POLL_INTERVAL_SECONDS", 300, 30, 24*60*60)) * time.Second,
+		RatePerSecond: float64(r.integer("STATUS_POLL_RPS", 5, 1, 100)),
+		BatchSize:     r.integer("STATUS_POLL_BATCH", 500, 1, 10000),
+	}
+	cfg.StatusCache = StatusCacheConfig{
+		TTL:          time.Duration(r.integer("STATUS_CACHE_TTL_SECONDS", 0, 0, 60*60)) * time.Second,
+		MaxRefreshes: r.integer("STATUS_CACHE_MAX_REFRESHES", 16, 1, 1000),
+	}
+	cfg.PaymentAttemptCacheSize = r.integer("PAYMENT_ATTEMPT_CACHE_SIZE", 10000, 1, 1000000)
+	cfg.VerifyMinInterval = time.Duration(r.integer("VERIFY_MIN_INTERVAL_SECONDS", 3, 0, 60)) * time.Second
+	cfg.Risk = RiskConfig{
+		VelocityWindow:         time.Duration(r.integer("RISK_VELOCITY_WINDOW_MINUTES", 60, 1, 24*60)) * time.Minute,
+		MaxSessionsPerCustomer: r.integer("RISK_MAX_SESSIONS_PER_CUSTOMER", 0, 0, 1000),
+		MaxSessionsPerDevice:   r.integer("RISK_MAX_SESSIONS_PER_DEVICE", 0, 0, 1000),
+		ReviewAmount:           r.float("RISK_REVIEW_AMOUNT"),
+		BlockAmount:            r.float("RISK_BLOCK_AMOUNT"),
+	}
+	if cfg.Risk.ReviewAmount > 0 && cfg.Risk.BlockAmount > 0 && cfg.Risk.BlockAmount <= cfg.Risk.ReviewAmount {
+		r.problem("RISK_BLOCK_AMOUNT must be above RISK_REVIEW_AMOUNT")
+	}
+	cfg.GCSHMACAccessKey = r.str("GCS_HMAC_ACCESS_KEY")
+	cfg.GCSHMACSecret = r.str("GCS_HMAC_SECRET")
+	if cfg.ReportStorage != "" {
+		r.required("REPORT_BUCKET")
+	}
+	if cfg.ReportStorage == "gcs" {
+		r.required("GCS_HMAC_ACCESS_KEY")
+		r.required("GCS_HMAC_SECRET")
+	}
+
+	if len(r.problems) > 0 {
+		return nil, &ConfigError{Problems: r.problems}
+	}
+	return cfg, nil
+}
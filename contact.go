@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultPhoneRegion is the region of phone numbers given without a country code
+const DefaultPhoneRegion = "IN"
+
+// phoneRegion is the numbering plan of a region, after libphonenumber's metadata:
+// national numbers are what remains once the country calling code and trunk prefix
+// are removed
+type phoneRegion struct {
+	callingCode string
+	trunkPrefix string         // dialled before national numbers within the region
+	national    *regexp.Regexp // valid national numbers
+}
+
+// phoneRegions are the regions whose numbers are validated against their numbering
+// plan. Indian numbers must be mobiles, which Cashfree needs for OTPs and UPI.
+var phoneRegions = map[string]phoneRegion{
+	"IN": {callingCode: "91", trunkPrefix: "0", national: regexp.MustCompile(`^[6-9]\d{9}$`)},
+	"US": {callingCode: "1", trunkPrefix: "1", national: regexp.MustCompile(`^[2-9]\d{2}[2-9]\d{6}$`)},
+	"GB": {callingCode: "44", trunkPrefix: "0", national: regexp.MustCompile(`^(7\d{9}|[1-3]\d{8,9})$`)},
+	"AE": {callingCode: "971", trunkPrefix: "0", national: regexp.MustCompile(`^(5\d{8}|[2-9]\d{7})$`)},
+	"SG": {callingCode: "65", national: regexp.MustCompile(`^[689]\d{7}$`)},
+	"AU": {callingCode: "61", trunkPrefix: "0", national: regexp.MustCompile(`^[2-478]\d{8}$`)},
+	"NP": {callingCode: "977", trunkPrefix: "0", national: regexp.MustCompile(`^(9[678]\d{8}|[1-8]\d{6,7})$`)},
+	"BD": {callingCode: "880", trunkPrefix: "0", national: regexp.MustCompile(`^1[3-9]\d{8}$`)},
+	"LK": {callingCode: "94", trunkPrefix: "0", national: regexp.MustCompile(`^[1-9]\d{8}$`)},
+	"MY": {callingCode: "60", trunkPrefix: "0", national: regexp.MustCompile(`^(1\d{8,9}|[3-9]\d{7,8})$`)},
+}
+
+// PhoneRegions returns the regions phone numbers are validated for, sorted
+func PhoneRegions() []string {
+	regions := make([]string, 0, len(phoneRegions))
+	for region := range phoneRegions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// phoneRegionForCallingCode returns the region of the longest known calling code
+// prefixing digits, and the rest of the digits
+func phoneRegionForCallingCode(digits string) (phoneRegion, string, bool) {
+	for length := 3; length >= 1; length-- {
+		if len(digits) <= length {
+			continue
+		}
+		for _, region := range phoneRegions {
+			if region.callingCode == digits[:length] {
+				return region, digits[length:], true
+			}
+		}
+	}
+	return phoneRegion{}, "", false
+}
+
+// phoneFormatting is what may separate a phone number's digits
+const phoneFormatting = " -.()/"
+
+// normalizePhone validates a phone number and returns it in E.164 format, such as
+// +919876543210. Numbers without a country code (a leading + or 00) are read in
+// defaultRegion. Numbers in a region of phoneRegions must fit its numbering plan;
+// numbers elsewhere must be 8 to 15 digits long, as E.164 allows.
+func normalizePhone(value, defaultRegion string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("phone number is required")
+	}
+
+	var digits strings.Builder
+	international := false
+	for i, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case strings.ContainsRune(phoneFormatting, r):
+		default:
+			return "", fmt.Errorf("phone number %q may only contain digits, spaces, dashes and a leading +", value)
+		}
+	}
+	number := digits.String()
+	if !international && strings.HasPrefix(number, "00") {
+		international, number = true, number[2:]
+	}
+
+	if international {
+		region, national, ok := phoneRegionForCallingCode(number)
+		if !ok {
+			if len(number) < 8 || len(number) > 15 {
+				return "", fmt.Errorf("phone number %q must have 8 to 15 digits", value)
+			}
+			return "+" + number, nil
+		}
+		// "+44 (0)20 ..." carries the trunk prefix by habit
+		if !region.national.MatchString(national) && region.trunkPrefix != "" {
+			national = strings.TrimPrefix(national, region.trunkPrefix)
+		}
+		if !region.national.MatchString(national) {
+			return "", fmt.Errorf("phone number %q is not a valid +%s number", value, region.callingCode)
+		}
+		return "+" + region.callingCode + national, nil
+	}
+
+	if defaultRegion == "" {
+		defaultRegion = DefaultPhoneRegion
+	}
+	region, ok := phoneRegions[defaultRegion]
+	if !ok {
+		return "", fmt.Errorf("phone region %s is not supported", defaultRegion)
+	}
+	for _, national := range []string{
+		number,
+		strings.TrimPrefix(number, region.trunkPrefix),
+		strings.TrimPrefix(number, region.callingCode), // the country code without its +
+	} {
+		if region.national.MatchString(national) {
+			return "+" + region.callingCode + national, nil
+		}
+	}
+	return "", fmt.Errorf("phone number %q is not a valid %s number; include the country code for other countries", value, defaultRegion)
+}
+
+// emailDomainLabel is one dot-separated label of an email domain
+var emailDomainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeEmail validates a bare email address and returns it in canonical form,
+// trimmed and lower-cased. Names and angle brackets ("Jo <jo@example.com>") are
+// rejected, as are domains that are not fully qualified.
+func normalizeEmail(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("email is required")
+	}
+	invalid := fmt.Errorf("email %q is not a valid address", value)
+
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Name != "" || strings.ContainsAny(value, "<>") {
+		return "", invalid
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	local := strings.ToLower(addr.Address[:at])
+	domain := strings.ToLower(addr.Address[at+1:])
+	if len(local) > 64 || len(domain) > 253 || strings.HasPrefix(local, "\"") {
+		return "", invalid
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", invalid
+	}
+	for _, label := range labels {
+		if !emailDomainLabel.MatchString(label) {
+			return "", invalid
+		}
+	}
+	if tld := labels[len(labels)-1]; strings.Trim(tld, "0123456789") == "" {
+		return "", invalid
+	}
+
+	return local + "@" + domain, nil
+}
+
+// normalizeCustomerContact validates the customer's phone and email of a new payment
+// session and rewrites them in canonical form, before anything is stored or sent to
+// the gateway
+func (h *PaymentHandler) normalizeCustomerContact(req *CreatePaymentSessionRequest) error {
+	phone, err := normalizePhone(req.CustomerPhone, h.phoneRegion)
+	if err != nil {
+		return fmt.Errorf("customer_phone: %w", err)
+	}
+	email, err := normalizeEmail(req.CustomerEmail)
+	if err != nil {
+		return fmt.Errorf("customer_email: %w", err)
+	}
+	req.CustomerPhone, req.CustomerEmail = phone, email
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	valid := map[string]string{
+		"9876543210":         "+919876543210",
+		"98765 43210":        "+919876543210",
+		"098765-43210":       "+919876543210",
+		"919876543210":       "+919876543210",
+		"+91 98765 43210":    "+919876543210",
+		"0091 9876543210":    "+919876543210",
+		"+44 (0)7911 123456": "+447911123456",
+		"+1 (415) 555-2671":  "+14155552671",
+		"+65 9123 4567":      "+6591234567",
+		"+86 138 0013 8000":  "+8613800138000", // outside phoneRegions, checked for length only
+	}
+	for input, want := range valid {
+		got, err := normalizePhone(input, "")
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, want, got, input)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"12345",
+		"5876543210",      // Indian numbers must be mobiles
+		"98765432101",     // too long
+		"+91 12345 67890", // not an Indian mobile
+		"9876543210 ext 5",
+		"+1234",
+		"98+76543210",
+	} {
+		_, err := normalizePhone(input, "")
+		assert.Error(t, err, input)
+	}
+
+	got, err := normalizePhone("07911 123456", "GB")
+	require.NoError(t, err)
+	assert.Equal(t, "+447911123456", got)
+	_, err = normalizePhone("9876543210", "XX")
+	assert.Error(t, err)
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	valid := map[string]string{
+		"john@example.com":          "john@example.com",
+		"  John.Doe@Example.COM ":   "john.doe@example.com",
+		"jo+orders@mail.example.in": "jo+orders@mail.example.in",
+	}
+	for input, want := range valid {
+		got, err := normalizeEmail(input)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, want, got, input)
+		}
+	}
+
+	for _, input := range []string{
+		"",
+		"john",
+		"john@localhost",
+		"John <john@example.com>",
+		"john@exa_mple.com",
+		"john@-example.com",
+		"john@example.123",
+		"john@@example.com",
+	} {
+		_, err := normalizeEmail(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestNormalizeCustomerContact(t *testing.T) {
+	handler := &PaymentHandler{}
+	req := &CreatePaymentSessionRequest{CustomerPhone: "98765 43210", CustomerEmail: "Jo@Example.com"}
+	require.NoError(t, handler.normalizeCustomerContact(req))
+	assert.Equal(t, "+919876543210", req.CustomerPhone)
+	assert.Equal(t, "jo@example.com", req.CustomerEmail)
+
+	req.CustomerPhone = "12345"
+	assert.ErrorContains(t, handler.normalizeCustomerContact(req), "customer_phone")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateCredentialsRequest carries Cashfree credentials to check without saving them
+type ValidateCredentialsRequest struct {
+	ClientID     string `json:"client_id" binding:"required"`
+	ClientSecret string `json:"client_secret" binding:"required"`
+	Environment  string `json:"environment"` // TEST (default) or PROD
+}
+
+// CredentialsHandler checks Cashfree credentials before a rotation activates them
+type CredentialsHandler struct {
+	// validate checks Cashfree credentials with a live call
+	validate func(clientID, clientSecret, environment string) error
+}
+
+// ValidateCredentials makes an authenticated Cashfree call with the given credentials
+// and reports whether Cashfree accepts them. Nothing is stored, and the secret is never
+// logged.
+func (h *CredentialsHandler) ValidateCredentials(c *gin.Context) {
+	var req ValidateCredentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	environment, err := normalizeEnvironment(req.Environment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := c.GetHeader("X-Admin-Actor")
+	if actor == "" {
+		actor = "admin"
+	}
+
+	err = h.validate(req.ClientID, req.ClientSecret, environment)
+	switch {
+	case err == nil:
+		log.Printf("%s validated Cashfree %s credentials for client %s", actor, environment, req.ClientID)
+		c.JSON(http.StatusOK, gin.H{"valid": true, "environment": environment})
+	case errors.Is(err, ErrInvalidCredentials):
+		log.Printf("%s tried Cashfree %s credentials for client %s, which Cashfree rejected", actor, environment, req.ClientID)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"valid": false, "environment": environment, "error": "Cashfree rejected the credentials"})
+	default:
+		log.Printf("Failed to validate Cashfree credentials: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to validate credentials with Cashfree"})
+	}
+}
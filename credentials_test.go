@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	server, _ := newFakeCashfree(t)
+	handler := &CredentialsHandler{validate: func(clientID, clientSecret, environment string) error {
+		client := NewCashfreeClient(clientID, clientSecret, environment)
+		client.BaseURL = server.URL
+		client.Client.SetRetryCount(0)
+		return client.ValidateCredentials()
+	}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/api/v1/admin/credentials/validate", handler.ValidateCredentials)
+	validate := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/credentials/validate", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := validate(`{"client_id":"test_client","client_secret":"test_secret","environment":"prod"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"valid":true,"environment":"PROD"}`, w.Body.String())
+
+	w = validate(`{"client_id":"test_client","client_secret":"rotated_wrong"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"valid":false`)
+	assert.NotContains(t, w.Body.String(), "rotated_wrong")
+
+	w = validate(`{"client_id":"test_client"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = validate(`{"client_id":"test_client","client_secret":"test_secret","environment":"staging"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	handler.validate = func(string, string, string) error { return errors.New("connection refused") }
+	w = validate(`{"client_id":"test_client","client_secret":"test_secret"}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// currencyExponents lists the currencies orders can be created in, by ISO 4217 code,
+// with the number of digits after the decimal point in each. These are the currencies
+// Cashfree accepts for international payments, plus INR.
+var currencyExponents = map[string]int{
+	"INR": 2,
+	"AED": 2,
+	"AUD": 2,
+	"BDT": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"JPY": 0,
+	"KES": 2,
+	"KRW": 0,
+	"KWD": 3,
+	"LKR": 2,
+	"MYR": 2,
+	"NOK": 2,
+	"NPR": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PHP": 2,
+	"QAR": 2,
+	"SAR": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"THB": 2,
+	"USD": 2,
+	"ZAR": 2,
+}
+
+// currencyExponent returns the number of decimal digits in a currency's amounts,
+// assuming 2 for currencies not in currencyExponents
+func currencyExponent(currency string) int {
+	if exponent, ok := currencyExponents[strings.ToUpper(currency)]; ok {
+		return exponent
+	}
+	return 2
+}
+
+// validateCurrency checks that orders can be created in a currency and that the amount
+// has no more decimal digits than the currency allows. It returns the upper-cased
+// currency code and its exponent.
+func validateCurrency(currency string, amount float64) (string, int, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	exponent, ok := currencyExponents[code]
+	if !ok {
+		return "", 0, fmt.Errorf("unsupported currency %q", currency)
+	}
+
+	minor := amount * math.Pow10(exponent)
+	if math.Abs(minor-math.Round(minor)) > 1e-6 {
+		return "", 0, fmt.Errorf("%s amounts must have at most %d decimal places", code, exponent)
+	}
+	return code, exponent, nil
+}
+
+// toMinorUnits converts an amount to the currency's smallest unit, such as paise
+func toMinorUnits(amount float64, currency string) int64 {
+	return int64(math.Round(amount * math.Pow10(currencyExponent(currency))))
+}
+
+// fromMinorUnits converts an amount in the currency's smallest unit back to a decimal
+func fromMinorUnits(amount int64, currency string) float64 {
+	return float64(amount) / math.Pow10(currencyExponent(currency))
+}
+
+// formatCurrencyAmount formats an amount with the currency's number of decimal digits
+func formatCurrencyAmount(amount float64, currency string) string {
+	return strconv.FormatFloat(amount, 'f', currencyExponent(currency), 64)
+}
+
+// FXRates holds reference exchange rates to INR. The rate in effect when an order is
+// created is stored with it so that reports can show foreign currency orders in INR;
+// the rates are for reporting only and never change what the customer is charged.
+type FXRates struct {
+	mu    sync.RWMutex
+	rates map[string]float64
+}
+
+func NewFXRates(rates map[string]float64) *FXRates {
+	f := &FXRates{}
+	f.Set(rates)
+	return f
+}
+
+// Set replaces the reference rates
+func (f *FXRates) Set(rates map[string]float64) {
+	copied := make(map[string]float64, len(rates))
+	for currency, rate := range rates {
+		copied[strings.ToUpper(currency)] = rate
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rates = copied
+}
+
+// RateToINR returns the number of rupees in one unit of currency, or false when no
+// reference rate is configured for it
+func (f *FXRates) RateToINR(currency string) (float64, bool) {
+	currency = strings.ToUpper(currency)
+	if currency == "INR" {
+		return 1, true
+	}
+	if f == nil {
+		return 0, false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	rate, ok := f.rates[currency]
+	return rate, ok
+}
+
+// AmountINR returns the payment amount in INR at the reference rate recorded when the
+// order was created, or false when no rate was recorded
+func (p *Payment) AmountINR() (float64, bool) {
+	if strings.EqualFold(p.Currency, "INR") {
+		return p.Amount, true
+	}
+	if p.FXRateINR == nil {
+		return 0, false
+	}
+	return math.Round(p.Amount**p.FXRateINR*100) / 100, true
+}
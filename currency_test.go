@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateCurrency(t *testing.T) {
+	code, exponent, err := validateCurrency("usd", 10.5)
+	require.NoError(t, err)
+	assert.Equal(t, "USD", code)
+	assert.Equal(t, 2, exponent)
+
+	code, exponent, err = validateCurrency("JPY", 1500)
+	require.NoError(t, err)
+	assert.Equal(t, "JPY", code)
+	assert.Equal(t, 0, exponent)
+
+	_, _, err = validateCurrency("KWD", 1.125)
+	assert.NoError(t, err)
+
+	_, _, err = validateCurrency("JPY", 1500.5)
+	assert.EqualError(t, err, "JPY amounts must have at most 0 decimal places")
+
+	_, _, err = validateCurrency("INR", 10.005)
+	assert.Error(t, err)
+
+	_, _, err = validateCurrency("XYZ", 10)
+	assert.EqualError(t, err, `unsupported currency "XYZ"`)
+}
+
+func TestMinorUnits(t *testing.T) {
+	assert.Equal(t, int64(10050), toMinorUnits(100.5, "INR"))
+	assert.Equal(t, int64(1500), toMinorUnits(1500, "JPY"))
+	assert.Equal(t, int64(1125), toMinorUnits(1.125, "KWD"))
+	assert.Equal(t, 100.5, fromMinorUnits(10050, "INR"))
+	assert.Equal(t, 1.125, fromMinorUnits(1125, "kwd"))
+	assert.Equal(t, "1.125", formatCurrencyAmount(1.125, "KWD"))
+	assert.Equal(t, "1500", formatCurrencyAmount(1500, "JPY"))
+}
+
+func TestFXRates(t *testing.T) {
+	rates := NewFXRates(map[string]float64{"usd": 83.25})
+
+	rate, ok := rates.RateToINR("USD")
+	assert.True(t, ok)
+	assert.Equal(t, 83.25, rate)
+
+	rate, ok = rates.RateToINR("INR")
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, rate)
+
+	_, ok = rates.RateToINR("EUR")
+	assert.False(t, ok)
+
+	rates.Set(map[string]float64{"EUR": 90})
+	_, ok = rates.RateToINR("USD")
+	assert.False(t, ok)
+}
+
+func TestPaymentAmountINR(t *testing.T) {
+	rate := 83.25
+	amount, ok := (&Payment{Amount: 10, Currency: "USD", FXRateINR: &rate}).AmountINR()
+	assert.True(t, ok)
+	assert.Equal(t, 832.5, amount)
+
+	amount, ok = (&Payment{Amount: 500, Currency: "INR"}).AmountINR()
+	assert.True(t, ok)
+	assert.Equal(t, 500.0, amount)
+
+	_, ok = (&Payment{Amount: 10, Currency: "USD"}).AmountINR()
+	assert.False(t, ok)
+}
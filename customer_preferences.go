@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/notify"
+)
+
+// CustomerPreferences records which channels a customer agreed to be sent payment links
+// and reminders on. A nil channel was never stated, and is taken as consent unless
+// NOTIFICATION_CONSENT_REQUIRED is set.
+type CustomerPreferences struct {
+	CustomerID string     `json:"customer_id"`
+	Email      *bool      `json:"email"`
+	SMS        *bool      `json:"sms"`
+	WhatsApp   *bool      `json:"whatsapp"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// channels returns the customer's consent of each channel by notify channel name
+func (p *CustomerPreferences) channels() map[string]**bool {
+	return map[string]**bool{
+		notify.ChannelEmail:    &p.Email,
+		notify.ChannelSMS:      &p.SMS,
+		notify.ChannelWhatsApp: &p.WhatsApp,
+	}
+}
+
+// ConsentChange records a customer's consent of a channel changing
+type ConsentChange struct {
+	ID         uuid.UUID `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	Channel    string    `json:"channel"`
+	OptedIn    bool      `json:"opted_in"`
+	Previous   *bool     `json:"previous"`
+	Actor      string    `json:"actor"`
+	RemoteIP   string    `json:"remote_ip"`
+	ChangedAt  time.Time `json:"changed_at"`
+}
+
+// GetCustomerPreferences returns a customer's notification preferences, with no
+// channel stated for customers who never set them
+func (r *PaymentRepository) GetCustomerPreferences(ctx context.Context, customerID string) (*CustomerPreferences, error) {
+	query := `
+		SELECT customer_id, email_opt_in, sms_opt_in, whatsapp_opt_in, updated_at
+		FROM customer_preferences
+		WHERE customer_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+	`
+
+	prefs := &CustomerPreferences{}
+	err := r.db.QueryRow(ctx, query, customerID, TenantIDFromContext(ctx)).Scan(
+		&prefs.CustomerID, &prefs.Email, &prefs.SMS, &prefs.WhatsApp, &prefs.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return &CustomerPreferences{CustomerID: customerID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// UpdateCustomerPreferences sets the channels stated in update, leaving the others as
+// they are, and records each consent that changed in the consent audit as done by
+// actor from remoteIP
+func (r *PaymentRepository) UpdateCustomerPreferences(ctx context.Context, customerID string, update CustomerPreferences, actor, remoteIP string) (*CustomerPreferences, error) {
+	tenantID := TenantIDFromContext(ctx)
+	now := r.now()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the customer's preferences, creating them on their first update
+	_, err = tx.Exec(ctx, `
+		INSERT INTO customer_preferences (tenant_id, customer_id, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'), customer_id) DO NOTHING
+	`, tenantID, customerID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs := &CustomerPreferences{}
+	err = tx.QueryRow(ctx, `
+		SELECT customer_id, email_opt_in, sms_opt_in, whatsapp_opt_in, updated_at
+		FROM customer_preferences
+		WHERE customer_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		FOR UPDATE
+	`, customerID, tenantID).Scan(&prefs.CustomerID, &prefs.Email, &prefs.SMS, &prefs.WhatsApp, &prefs.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	current, updates := prefs.channels(), update.channels()
+	for _, channel := range notify.Channels {
+		optedIn, previous := *updates[channel], *current[channel]
+		if optedIn == nil || (previous != nil && *previous == *optedIn) {
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO customer_consent_audit (id, tenant_id, customer_id, channel, opted_in, previous, actor, remote_ip, changed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, uuid.New(), tenantID, customerID, channel, *optedIn, previous, actor, remoteIP, now)
+		if err != nil {
+			return nil, err
+		}
+		*current[channel] = optedIn
+	}
+
+	prefs.UpdatedAt = &now
+	_, err = tx.Exec(ctx, `
+		UPDATE customer_preferences
+		SET email_opt_in = $3, sms_opt_in = $4, whatsapp_opt_in = $5, updated_at = $6
+		WHERE customer_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+	`, customerID, tenantID, prefs.Email, prefs.SMS, prefs.WhatsApp, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return prefs, tx.Commit(ctx)
+}
+
+// ListConsentChanges returns the changes of a customer's consents, oldest first
+func (r *PaymentRepository) ListConsentChanges(ctx context.Context, customerID string) ([]ConsentChange, error) {
+	query := `
+		SELECT id, customer_id, channel, opted_in, previous, actor, remote_ip, changed_at
+		FROM customer_consent_audit
+		WHERE customer_id = $1 AND tenant_id IS NOT DISTINCT FROM $2
+		ORDER BY changed_at, channel
+	`
+
+	rows, err := r.db.Query(ctx, query, customerID, TenantIDFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []ConsentChange{}
+	for rows.Next() {
+		var change ConsentChange
+		err := rows.Scan(
+			&change.ID, &change.CustomerID, &change.Channel, &change.OptedIn,
+			&change.Previous, &change.Actor, &change.RemoteIP, &change.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
+
+// GetOrderConsent returns the consent of the customer of an order to a channel, or nil
+// when the customer never stated it
+func (r *PaymentRepository) GetOrderConsent(ctx context.Context, orderID, channel string) (*bool, error) {
+	column := map[string]string{
+		notify.ChannelEmail:    "email_opt_in",
+		notify.ChannelSMS:      "sms_opt_in",
+		notify.ChannelWhatsApp: "whatsapp_opt_in",
+	}[channel]
+	if column == "" {
+		return nil, nil
+	}
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT pref.` + column + `
+		FROM (SELECT customer_id, tenant_id FROM payments WHERE order_id = $1` + tenant + `) p
+		JOIN customer_preferences pref
+		  ON pref.customer_id = p.customer_id AND pref.tenant_id IS NOT DISTINCT FROM p.tenant_id
+	`
+
+	var consent *bool
+	err := r.db.QueryRow(ctx, query, append([]interface{}{orderID}, tenantArgs...)...).Scan(&consent)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	return consent, err
+}
+
+// ConsentStore answers the notifiers from the customers' stored preferences
+type ConsentStore struct {
+	repo *PaymentRepository
+	// required withholds payment links and reminders from customers who never
+	// stated their consent to the channel
+	required bool
+}
+
+// Allowed reports whether the customer of an order may be notified on channel
+func (s *ConsentStore) Allowed(ctx context.Context, orderID, channel string) (bool, error) {
+	consent, err := s.repo.GetOrderConsent(ctx, orderID, channel)
+	if err != nil {
+		return false, err
+	}
+	if consent == nil {
+		return !s.required, nil
+	}
+	return *consent, nil
+}
+
+// PreferenceHandler serves customers' notification preferences
+type PreferenceHandler struct {
+	repo *PaymentRepository
+}
+
+// Gets a customer's notification preferences
+func (h *PreferenceHandler) GetPreferences(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	prefs, err := h.repo.GetCustomerPreferences(ctx, c.Param("customer_id"))
+	if err != nil {
+		log.Printf("Failed to get customer preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve customer preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// Opts a customer in to or out of the channels in the body, recording who changed them
+// from the X-Actor header
+func (h *PreferenceHandler) UpdatePreferences(c *gin.Context) {
+	var req CustomerPreferences
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Email == nil && req.SMS == nil && req.WhatsApp == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one of email, sms and whatsapp is required"})
+		return
+	}
+	actor := c.GetHeader(actorHeader)
+	if actor == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Actor header is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	prefs, err := h.repo.UpdateCustomerPreferences(ctx, c.Param("customer_id"), req, actor, c.ClientIP())
+	if err != nil {
+		log.Printf("Failed to save customer preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save customer preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+// Gets the changes of a customer's consents, oldest first
+func (h *PreferenceHandler) GetConsentAudit(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	changes, err := h.repo.ListConsentChanges(ctx, c.Param("customer_id"))
+	if err != nil {
+		log.Printf("Failed to get consent audit: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve consent audit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+// registerPreferenceRoutes registers the customer preference routes on a
+// merchant-authenticated group
+func registerPreferenceRoutes(group *gin.RouterGroup, preferenceHandler *PreferenceHandler) {
+	// Get or set the channels a customer receives payment links and reminders on
+	group.GET("/customers/:customer_id/preferences", preferenceHandler.GetPreferences)
+	group.PUT("/customers/:customer_id/preferences", preferenceHandler.UpdatePreferences)
+
+	// Every change of the customer's consents
+	group.GET("/customers/:customer_id/preferences/audit", preferenceHandler.GetConsentAudit)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/notify"
+)
+
+func TestCustomerPreferences(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPreferenceRoutes(r.Group(""), &PreferenceHandler{repo: repo})
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(actorHeader, "support@merchant.example")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var prefs CustomerPreferences
+	w := serve(http.MethodGet, "/customers/customer_001/preferences", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &prefs))
+	assert.Equal(t, CustomerPreferences{CustomerID: "customer_001"}, prefs)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/customers/customer_001/preferences", `{}`).Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodPut, "/customers/customer_001/preferences", `{"email": true, "sms": false}`).Code)
+	// Unchanged consents are not audited again
+	w = serve(http.MethodPut, "/customers/customer_001/preferences", `{"email": false, "sms": false}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &prefs))
+	require.NotNil(t, prefs.Email)
+	assert.False(t, *prefs.Email)
+	assert.Nil(t, prefs.WhatsApp)
+
+	w = serve(http.MethodGet, "/customers/customer_001/preferences/audit", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var audit struct {
+		Changes []ConsentChange `json:"changes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &audit))
+	require.Len(t, audit.Changes, 3)
+	last := audit.Changes[2]
+	assert.Equal(t, notify.ChannelEmail, last.Channel)
+	assert.False(t, last.OptedIn)
+	require.NotNil(t, last.Previous)
+	assert.True(t, *last.Previous)
+	assert.Equal(t, "support@merchant.example", last.Actor)
+
+	// The notifiers read the consent of an order's customer
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	store := &ConsentStore{repo: repo}
+	allowed, err := store.Allowed(ctx, "order_1", notify.ChannelEmail)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, err = store.Allowed(ctx, "order_1", notify.ChannelWhatsApp)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	store.required = true
+	allowed, err = store.Allowed(ctx, "order_1", notify.ChannelWhatsApp)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -12,13 +11,9 @@ import (
 
 var dbPool *pgxpool.Pool
 
-// connectDB establishes a connection pool to PostgreSQL database
-func connectDB() {
-	url := os.Getenv("DATABASE_URL")
-	if url == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
-	}
-
+// connectDB establishes a connection pool to PostgreSQL database. Queries go through
+// faults when it is not nil.
+func connectDB(url string, faults *FaultInjector) {
 	config, err := pgxpool.ParseConfig(url)
 	if err != nil {
 		log.Fatalf("Failed to parse database URL: %v", err)
@@ -29,6 +24,9 @@ func connectDB() {
 	config.MinConns = 5
 	config.MaxConnLifetime = time.Hour
 	config.MaxConnIdleTime = time.Minute * 30
+	if faults != nil {
+		config.ConnConfig.Tracer = faults.Tracer()
+	}
 
 	dbPool, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a database check constraint limiting a column to one of values
+type Constraint struct {
+	Table  string
+	Column string
+	Values []string
+}
+
+// Name returns the constraint's name, following Postgres' naming of column checks
+func (c Constraint) Name() string {
+	return c.Table + "_" + c.Column + "_check"
+}
+
+// SQL returns the statements replacing the constraint. It is added NOT VALID, so rows
+// written before it existed are not checked; validate it once they are cleaned up.
+func (c Constraint) SQL() string {
+	quoted := make([]string, len(c.Values))
+	for i, value := range c.Values {
+		quoted[i] = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;\nALTER TABLE %s ADD CONSTRAINT %s CHECK (%s IN (%s)) NOT VALID;\n",
+		c.Table, c.Name(), c.Table, c.Name(), c.Column, strings.Join(quoted, ", "))
+}
+
+// Constraints returns the check constraints of the status columns
+func Constraints() []Constraint {
+	return []Constraint{
+		{Table: "payments", Column: "status", Values: strs(PaymentStatuses())},
+		{Table: "refunds", Column: "status", Values: strs(RefundStatuses())},
+		{Table: "settlements", Column: "status", Values: strs(SettlementStatuses())},
+		{Table: "split_settlements", Column: "status", Values: strs(SettlementStatuses())},
+		{Table: "webhooks", Column: "status", Values: strs(WebhookStatuses())},
+		{Table: "event_deliveries", Column: "status", Values: strs(DeliveryStatuses())},
+	}
+}
+
+// ConstraintsSQL returns the migration creating every check constraint of Constraints
+func ConstraintsSQL() string {
+	var b strings.Builder
+	b.WriteString("-- Status check constraints, generated by `payment-getway constraints` from the domain package\n")
+	for _, c := range Constraints() {
+		b.WriteString(c.SQL())
+	}
+	return b.String()
+}
+
+func strs[T ~string](values []T) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = string(v)
+	}
+	return out
+}
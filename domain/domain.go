@@ -0,0 +1,191 @@
+// Package domain defines the statuses and webhook types stored by the payment service.
+// Handlers, the repository and the database's check constraints all take their values
+// from here, so a status cannot be written that the rest of the service does not know.
+package domain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// PaymentStatus is the status of a payment. Besides our own statuses it takes the
+// statuses Cashfree reports for orders and payments.
+type PaymentStatus string
+
+const (
+	PaymentCreated              PaymentStatus = "CREATED"
+	PaymentActive               PaymentStatus = "ACTIVE"
+	PaymentPaid                 PaymentStatus = "PAID"    // the order, as Cashfree reports it
+	PaymentSuccess              PaymentStatus = "SUCCESS" // the payment, as its webhook reports it
+	PaymentFailed               PaymentStatus = "FAILED"
+	PaymentExpired              PaymentStatus = "EXPIRED"
+	PaymentCancelled            PaymentStatus = "CANCELLED"             // cancelled through our cancel endpoint before it was paid
+	PaymentTerminated           PaymentStatus = "TERMINATED"            // terminated by the merchant at Cashfree
+	PaymentTerminationRequested PaymentStatus = "TERMINATION_REQUESTED" // until Cashfree confirms no payment is in flight
+	PaymentScheduled            PaymentStatus = "SCHEDULED"             // gateway order not created until activate_at
+	PaymentActivating           PaymentStatus = "ACTIVATING"            // claimed by an instance of the activation job
+	PaymentPendingReview        PaymentStatus = "PENDING_REVIEW"        // held for manual risk review
+	PaymentAmountMismatch       PaymentStatus = "AMOUNT_MISMATCH"       // paid, but not the amount quoted at session creation
+)
+
+// PaymentStatuses returns every payment status
+func PaymentStatuses() []PaymentStatus {
+	return []PaymentStatus{
+		PaymentCreated, PaymentActive, PaymentPaid, PaymentSuccess, PaymentFailed,
+		PaymentExpired, PaymentCancelled, PaymentTerminated, PaymentTerminationRequested,
+		PaymentScheduled, PaymentActivating, PaymentPendingReview, PaymentAmountMismatch,
+	}
+}
+
+// Valid reports whether s is a known payment status
+func (s PaymentStatus) Valid() bool { return slices.Contains(PaymentStatuses(), s) }
+
+// Paid reports whether s is the status of a paid order or payment
+func (s PaymentStatus) Paid() bool { return s == PaymentPaid || s == PaymentSuccess }
+
+// ParsePaymentStatus returns the payment status named by value, in any case
+func ParsePaymentStatus(value string) (PaymentStatus, error) {
+	return parse("payment status", value, PaymentStatuses())
+}
+
+// RefundStatus is the status of a refund: Cashfree's, or one of the approval flow's
+// before the refund is sent to Cashfree
+type RefundStatus string
+
+const (
+	RefundPending         RefundStatus = "PENDING"
+	RefundSuccess         RefundStatus = "SUCCESS"
+	RefundCancelled       RefundStatus = "CANCELLED"
+	RefundFailed          RefundStatus = "FAILED"
+	RefundOnHold          RefundStatus = "ONHOLD"
+	RefundPendingApproval RefundStatus = "PENDING_APPROVAL"
+	RefundApproved        RefundStatus = "APPROVED" // approved and being sent to Cashfree
+	RefundRejected        RefundStatus = "REJECTED"
+)
+
+// RefundStatuses returns every refund status
+func RefundStatuses() []RefundStatus {
+	return []RefundStatus{
+		RefundPending, RefundSuccess, RefundCancelled, RefundFailed, RefundOnHold,
+		RefundPendingApproval, RefundApproved, RefundRejected,
+	}
+}
+
+// Valid reports whether s is a known refund status
+func (s RefundStatus) Valid() bool { return slices.Contains(RefundStatuses(), s) }
+
+// ParseRefundStatus returns the refund status named by value, in any case
+func ParseRefundStatus(value string) (RefundStatus, error) {
+	return parse("refund status", value, RefundStatuses())
+}
+
+// SettlementStatus is the status of a settlement or of a vendor's split of one
+type SettlementStatus string
+
+const (
+	SettlementPending SettlementStatus = "PENDING"
+	SettlementSuccess SettlementStatus = "SUCCESS"
+	SettlementFailed  SettlementStatus = "FAILED"
+)
+
+// SettlementStatuses returns every settlement status
+func SettlementStatuses() []SettlementStatus {
+	return []SettlementStatus{SettlementPending, SettlementSuccess, SettlementFailed}
+}
+
+// Valid reports whether s is a known settlement status
+func (s SettlementStatus) Valid() bool { return slices.Contains(SettlementStatuses(), s) }
+
+// ParseSettlementStatus returns the settlement status named by value, in any case
+func ParseSettlementStatus(value string) (SettlementStatus, error) {
+	return parse("settlement status", value, SettlementStatuses())
+}
+
+// WebhookType is the type of a Cashfree webhook
+type WebhookType string
+
+const (
+	WebhookPaymentSuccess   WebhookType = "PAYMENT_SUCCESS_WEBHOOK"
+	WebhookPaymentFailed    WebhookType = "PAYMENT_FAILED_WEBHOOK"
+	WebhookPaymentCharges   WebhookType = "PAYMENT_CHARGES_WEBHOOK"
+	WebhookRefundStatus     WebhookType = "REFUND_STATUS_WEBHOOK"
+	WebhookAutoRefundStatus WebhookType = "AUTO_REFUND_STATUS_WEBHOOK" // a refund Cashfree made on its own
+	WebhookSettlementStatus WebhookType = "SETTLEMENT_STATUS_WEBHOOK"
+	WebhookDisputeCreated   WebhookType = "DISPUTE_CREATED"
+)
+
+// WebhookTypes returns every webhook type the service applies. Webhooks of other types
+// are still logged, so the webhook log has no check constraint on its type.
+func WebhookTypes() []WebhookType {
+	return []WebhookType{
+		WebhookPaymentSuccess, WebhookPaymentFailed, WebhookPaymentCharges,
+		WebhookRefundStatus, WebhookAutoRefundStatus, WebhookSettlementStatus, WebhookDisputeCreated,
+	}
+}
+
+// Valid reports whether t is a webhook type the service applies
+func (t WebhookType) Valid() bool { return slices.Contains(WebhookTypes(), t) }
+
+// ParseWebhookType returns the webhook type named by value, in any case
+func ParseWebhookType(value string) (WebhookType, error) {
+	return parse("webhook type", value, WebhookTypes())
+}
+
+// WebhookStatus is the status of a webhook log entry
+type WebhookStatus string
+
+const (
+	WebhookReceived WebhookStatus = "RECEIVED"
+	WebhookFailed   WebhookStatus = "FAILED"   // applying the webhook failed
+	WebhookReplayed WebhookStatus = "REPLAYED" // applied again by an administrator
+)
+
+// WebhookStatuses returns every webhook log status
+func WebhookStatuses() []WebhookStatus {
+	return []WebhookStatus{WebhookReceived, WebhookFailed, WebhookReplayed}
+}
+
+// Valid reports whether s is a known webhook log status
+func (s WebhookStatus) Valid() bool { return slices.Contains(WebhookStatuses(), s) }
+
+// ParseWebhookStatus returns the webhook log status named by value, in any case
+func ParseWebhookStatus(value string) (WebhookStatus, error) {
+	return parse("webhook status", value, WebhookStatuses())
+}
+
+// DeliveryStatus is the status of an event's delivery to a merchant's endpoint
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "PENDING" // awaiting its first attempt or a retry
+	DeliveryDelivered DeliveryStatus = "DELIVERED"
+	DeliveryFailed    DeliveryStatus = "FAILED" // every attempt of the endpoint's retry schedule failed
+)
+
+// DeliveryStatuses returns every delivery status
+func DeliveryStatuses() []DeliveryStatus {
+	return []DeliveryStatus{DeliveryPending, DeliveryDelivered, DeliveryFailed}
+}
+
+// Valid reports whether s is a known delivery status
+func (s DeliveryStatus) Valid() bool { return slices.Contains(DeliveryStatuses(), s) }
+
+// ParseDeliveryStatus returns the delivery status named by value, in any case
+func ParseDeliveryStatus(value string) (DeliveryStatus, error) {
+	return parse("delivery status", value, DeliveryStatuses())
+}
+
+// parse returns the value of values equal to value, ignoring case and surrounding space
+func parse[T ~string](kind, value string, values []T) (T, error) {
+	v := T(strings.ToUpper(strings.TrimSpace(value)))
+	if !slices.Contains(values, v) {
+		return "", fmt.Errorf("unknown %s %q: must be one of %s", kind, value, join(values))
+	}
+	return v, nil
+}
+
+// join lists values separated by commas
+func join[T ~string](values []T) string {
+	return strings.Join(strs(values), ", ")
+}
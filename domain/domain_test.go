@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatuses(t *testing.T) {
+	status, err := ParsePaymentStatus(" paid ")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentPaid, status)
+	assert.True(t, status.Paid())
+	assert.False(t, PaymentActive.Paid())
+
+	_, err = ParsePaymentStatus("PROCESSED")
+	assert.ErrorContains(t, err, "unknown payment status")
+
+	refund, err := ParseRefundStatus("pending_approval")
+	require.NoError(t, err)
+	assert.Equal(t, RefundPendingApproval, refund)
+
+	_, err = ParseSettlementStatus("")
+	assert.Error(t, err)
+
+	webhookType, err := ParseWebhookType("REFUND_STATUS_WEBHOOK")
+	require.NoError(t, err)
+	assert.Equal(t, WebhookRefundStatus, webhookType)
+	assert.False(t, WebhookType("SUBSCRIPTION_WEBHOOK").Valid())
+
+	assert.True(t, WebhookReplayed.Valid())
+	assert.False(t, WebhookStatus("PROCESSED").Valid())
+}
+
+func TestConstraints(t *testing.T) {
+	for _, c := range Constraints() {
+		assert.NotEmpty(t, c.Values, c.Name())
+		seen := make(map[string]bool)
+		for _, value := range c.Values {
+			assert.False(t, seen[value], "%s lists %s twice", c.Name(), value)
+			seen[value] = true
+		}
+	}
+
+	c := Constraint{Table: "payments", Column: "status", Values: []string{"PAID", "IT'S"}}
+	assert.Equal(t, "payments_status_check", c.Name())
+	assert.Equal(t, "ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;\n"+
+		"ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (status IN ('PAID', 'IT''S')) NOT VALID;\n", c.SQL())
+
+	sql := ConstraintsSQL()
+	assert.True(t, strings.HasPrefix(sql, "-- "))
+	assert.Contains(t, sql, "webhooks_status_check CHECK (status IN ('RECEIVED', 'FAILED', 'REPLAYED'))")
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Types of the domain events in the events table. Triggers record all but
+// DomainWebhookApplied, which only the service knows of.
+const (
+	DomainOrderCreated        = "order.created"
+	DomainOrderStatusChanged  = "order.status_changed"
+	DomainRefundRequested     = "refund.requested"
+	DomainRefundStatusChanged = "refund.status_changed" // to a status before the refund is processed
+	DomainRefundProcessed     = "refund.processed"      // to SUCCESS, FAILED or CANCELLED
+	DomainWebhookReceived     = "webhook.received"
+	DomainWebhookApplied      = "webhook.applied"
+)
+
+// Bounds of a read of the event store
+const (
+	domainEventsDefaultLimit = 500
+	domainEventsMaxLimit     = 5000
+)
+
+// DomainEvent is a fact about an order recorded in the append-only event store
+type DomainEvent struct {
+	Sequence      int64           `json:"sequence"` // position among every event
+	OrderID       string          `json:"order_id"`
+	OrderSequence int             `json:"order_sequence"` // position among the order's events, from 1
+	Type          string          `json:"type"`
+	Data          json.RawMessage `json:"data"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+}
+
+// scanDomainEvents reads rows of sequence, order_id, order_sequence, type, data and
+// occurred_at
+func scanDomainEvents(rows pgx.Rows) ([]DomainEvent, error) {
+	stored := []DomainEvent{}
+	for rows.Next() {
+		var event DomainEvent
+		var data []byte
+		if err := rows.Scan(&event.Sequence, &event.OrderID, &event.OrderSequence, &event.Type, &data, &event.OccurredAt); err != nil {
+			return nil, err
+		}
+		event.Data = json.RawMessage(data)
+		stored = append(stored, event)
+	}
+	return stored, rows.Err()
+}
+
+// AppendDomainEvent records an event of an order in the event store, after the order's
+// other events
+func (r *PaymentRepository) AppendDomainEvent(ctx context.Context, orderID, eventType string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	orderTenant, tenantArgs := orderTenant(ctx, 1, 4)
+	_, err = r.db.Exec(ctx, `
+		SELECT append_event(`+orderTenant+`, $1, $2, $3)
+	`, append([]interface{}{orderID, eventType, raw}, tenantArgs...)...)
+	return err
+}
+
+// ListOrderEvents returns an order's events in the order they were recorded
+func (r *PaymentRepository) ListOrderEvents(ctx context.Context, orderID string) ([]DomainEvent, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT sequence, order_id, order_sequence, type, data, occurred_at
+		FROM events
+		WHERE order_id = $1` + tenant + `
+		ORDER BY order_sequence
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDomainEvents(rows)
+}
+
+// domainEventsLockKey is the advisory lock appends to the event store hold shared. A
+// reader takes it exclusively, so every append that drew a sequence number has ended
+// and a reader resuming after one never skips an event committed late.
+const domainEventsLockKey int64 = 0x6576656e7473746f // "eventsto"
+
+// ReadDomainEvents returns up to limit events after a sequence number, oldest first
+func (r *PaymentRepository) ReadDomainEvents(ctx context.Context, after int64, limit int) ([]DomainEvent, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, domainEventsLockKey); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT sequence, order_id, order_sequence, type, data, occurred_at
+		FROM events
+		WHERE sequence > $1
+		ORDER BY sequence
+		LIMIT $2
+	`
+
+	rows, err := tx.Query(ctx, query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	events, err := scanDomainEvents(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	return events, tx.Commit(ctx)
+}
+
+// recordWebhookApplied records that a webhook about an order was applied
+func (h *PaymentHandler) recordWebhookApplied(ctx context.Context, webhookData WebhookData) {
+	orderID := webhookOrderID(webhookData.Data)
+	if orderID == "" {
+		return
+	}
+	err := h.repo.AppendDomainEvent(ctx, orderID, DomainWebhookApplied, map[string]string{"webhook_type": webhookData.Type})
+	if err != nil {
+		log.Printf("Failed to record applying %s webhook of order %s: %v", webhookData.Type, orderID, err)
+	}
+}
+
+// Gets an order's events: its creation, status changes, refunds and webhooks
+func (h *PaymentHandler) GetOrderEvents(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.repo.GetPaymentByOrderID(ctx, orderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	events, err := h.repo.ListOrderEvents(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get events of order %s: %v", orderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events"})
+		return
+	}
+
+	validator := newListValidator()
+	for _, event := range events {
+		validator.add(event, event.OccurredAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "events": events})
+}
+
+// ReadEventStore pages through the event store of every merchant in sequence, for
+// rebuilding projections. Pass the last sequence read as ?after= to continue.
+func (h *EventStreamHandler) ReadEventStore(c *gin.Context) {
+	var after int64
+	if offset := c.Query("after"); offset != "" {
+		var err error
+		after, err = strconv.ParseInt(offset, 10, 64)
+		if err != nil || after < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after must be a non-negative sequence number"})
+			return
+		}
+	}
+	limit := domainEventsDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > domainEventsMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(domainEventsMaxLimit)})
+			return
+		}
+		limit = n
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	events, err := h.repo.ReadDomainEvents(ctx, after, limit)
+	if err != nil {
+		log.Printf("Failed to read the event store: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read events"})
+		return
+	}
+
+	next := after
+	if len(events) > 0 {
+		next = events[len(events)-1].Sequence
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "next": next, "count": len(events)})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestDomainEventStore(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_2")))
+	orderID, cfPaymentID, method := "order_1", "cf_payment_1", "upi"
+	webhook := &Webhook{EventType: domain.WebhookPaymentSuccess, OrderID: &orderID, Payload: `{}`, Status: domain.WebhookReceived}
+	require.NoError(t, repo.CreateWebhookLog(ctx, webhook))
+	paidAt := time.Now()
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_1", domain.PaymentSuccess, &cfPaymentID, &method, &paidAt))
+	// Updates that leave the status alone are not events
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_1", domain.PaymentSuccess, &cfPaymentID, &method, &paidAt))
+	require.NoError(t, repo.AppendDomainEvent(ctx, "order_1", DomainWebhookApplied, map[string]string{"webhook_type": "PAYMENT_SUCCESS_WEBHOOK"}))
+	refund := &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 40, Status: "PENDING"}
+	require.NoError(t, repo.CreateRefund(ctx, refund))
+	require.NoError(t, repo.UpdateRefundStatus(ctx, "refund_1", domain.RefundSuccess, &paidAt))
+
+	events, err := repo.ListOrderEvents(ctx, "order_1")
+	require.NoError(t, err)
+	var types []string
+	for i, event := range events {
+		types = append(types, event.Type)
+		assert.Equal(t, i+1, event.OrderSequence)
+	}
+	assert.Equal(t, []string{
+		DomainOrderCreated, DomainWebhookReceived, DomainOrderStatusChanged,
+		DomainWebhookApplied, DomainRefundRequested, DomainRefundProcessed,
+	}, types)
+
+	var change map[string]string
+	require.NoError(t, json.Unmarshal(events[2].Data, &change))
+	assert.Equal(t, map[string]string{"from": "ACTIVE", "to": "SUCCESS", "cf_payment_id": "cf_payment_1", "payment_method": "upi"}, change)
+
+	// The store is read in sequence across orders
+	all, err := repo.ReadDomainEvents(ctx, 0, 100)
+	require.NoError(t, err)
+	require.Len(t, all, 7)
+	assert.Equal(t, "order_2", all[1].OrderID)
+	for i := 1; i < len(all); i++ {
+		assert.Greater(t, all[i].Sequence, all[i-1].Sequence)
+	}
+	rest, err := repo.ReadDomainEvents(ctx, all[4].Sequence, 100)
+	require.NoError(t, err)
+	assert.Equal(t, all[5:], rest)
+
+	// Recorded events cannot be changed or removed
+	_, err = db.Exec(ctx, `UPDATE events SET type = 'order.forged' WHERE order_id = 'order_1'`)
+	assert.ErrorContains(t, err, "append-only")
+	_, err = db.Exec(ctx, `DELETE FROM events WHERE order_id = 'order_1'`)
+	assert.ErrorContains(t, err, "append-only")
+}
+
+func TestDomainEventAppendsOnlyWaitForTheSameOrder(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_2")))
+
+	tx, err := db.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback(ctx)
+	_, err = tx.Exec(ctx, `SELECT append_event(NULL, 'order_1', 'order.noted', '{}')`)
+	require.NoError(t, err)
+
+	// Another order's events are appended while order_1's transaction is open
+	short, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	require.NoError(t, repo.AppendDomainEvent(short, "order_2", "order.noted", map[string]string{}))
+
+	// order_1's next event, and readers of the whole store, wait for it to end
+	blocked, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	assert.Error(t, repo.AppendDomainEvent(blocked, "order_1", "order.noted", map[string]string{}))
+	blocked, cancel = context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	_, err = repo.ReadDomainEvents(blocked, 0, 100)
+	assert.Error(t, err)
+
+	require.NoError(t, tx.Commit(ctx))
+	all, err := repo.ReadDomainEvents(ctx, 0, 100)
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+}
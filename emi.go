@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// EMIDetails is the installment plan of a payment made on EMI, kept for support queries
+type EMIDetails struct {
+	Tenure            int     `json:"tenure"`             // number of monthly installments
+	InstallmentAmount float64 `json:"installment_amount"` // amount of each installment
+	Issuer            string  `json:"issuer"`             // bank or cardless EMI provider
+}
+
+// UnmarshalJSON reads a Cashfree payment entity. Its payment_method is an object keyed
+// by the method ({"upi": {...}}) in current API versions and a name in older ones; the
+// name is kept in PaymentMethod, the method's details in MethodDetails and an EMI plan,
+// if any, in EMI.
+func (p *CashfreePaymentResponse) UnmarshalJSON(data []byte) error {
+	type plain CashfreePaymentResponse
+	var raw struct {
+		plain
+		PaymentMethod interface{} `json:"payment_method"`
+		PaymentGroup  string      `json:"payment_group"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	payment := map[string]interface{}{"payment_method": raw.PaymentMethod, "payment_group": raw.PaymentGroup}
+	*p = CashfreePaymentResponse(raw.plain)
+	p.PaymentMethod = webhookPaymentMethod(payment)
+	p.EMI = webhookPaymentEMI(payment)
+	p.MethodDetails = webhookPaymentMethodDetails(payment)
+	return nil
+}
+
+// webhookPaymentEMI reads the installment plan from a payment's method object, under
+// "emi" for card EMI and "cardless_emi" for EMI providers, or returns nil when the
+// payment was not made on EMI
+func webhookPaymentEMI(payment map[string]interface{}) *EMIDetails {
+	method, ok := payment["payment_method"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var emi map[string]interface{}
+	var issuer string
+	if card, ok := method["emi"].(map[string]interface{}); ok {
+		emi, issuer = card, webhookString(card["emi_bank"])
+	} else if cardless, ok := method["cardless_emi"].(map[string]interface{}); ok {
+		emi, issuer = cardless, webhookString(cardless["provider"])
+	} else {
+		return nil
+	}
+
+	// The plan is in emi_details; older payloads put the tenure next to the bank
+	plan, _ := emi["emi_details"].(map[string]interface{})
+	tenure := webhookFloat(plan["emi_tenure"])
+	if tenure == 0 {
+		tenure = webhookFloat(emi["emi_tenure"])
+	}
+	return &EMIDetails{
+		Tenure:            int(tenure),
+		InstallmentAmount: webhookFloat(plan["emi_amount"]),
+		Issuer:            issuer,
+	}
+}
+
+// SetPaymentEMI records the installment plan of a payment made on EMI
+func (r *PaymentRepository) SetPaymentEMI(ctx context.Context, orderID string, emi *EMIDetails) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 4)
+	query := `
+		UPDATE payments
+		SET emi_details = $1, updated_at = $2
+		WHERE order_id = $3` + tenant
+
+	args := append([]interface{}{emi, r.now(), orderID}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// recordPaymentEMI saves the installment plan of an order's payment when it was made on
+// EMI. Failures are logged, as the payment itself has been recorded.
+func (h *PaymentHandler) recordPaymentEMI(ctx context.Context, orderID string, emi *EMIDetails) {
+	if emi == nil {
+		return
+	}
+	if err := h.repo.SetPaymentEMI(ctx, orderID, emi); err != nil {
+		log.Printf("Failed to record EMI details for %s: %v", orderID, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCashfreePaymentResponseEMI(t *testing.T) {
+	var payment CashfreePaymentResponse
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"cf_payment_id": "cf_payment_1", "order_id": "order_1", "payment_status": "SUCCESS",
+		"payment_amount": 12000, "payment_time": "2024-04-01T10:00:00+05:30",
+		"payment_group": "cardless_emi",
+		"payment_method": {"cardless_emi": {"channel": "link", "provider": "zestmoney",
+			"emi_details": {"emi_amount": 4100, "emi_tenure": 3}}}
+	}`), &payment))
+	assert.Equal(t, "order_1", payment.OrderID)
+	assert.Equal(t, "cardless_emi", payment.PaymentMethod)
+	assert.Equal(t, &EMIDetails{Tenure: 3, InstallmentAmount: 4100, Issuer: "zestmoney"}, payment.EMI)
+
+	// The tenure may only be given next to the bank
+	payment = CashfreePaymentResponse{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"payment_method": {"emi": {"emi_bank": "Kotak", "emi_tenure": 9}}
+	}`), &payment))
+	assert.Equal(t, "emi", payment.PaymentMethod)
+	assert.Equal(t, &EMIDetails{Tenure: 9, Issuer: "Kotak"}, payment.EMI)
+
+	payment = CashfreePaymentResponse{}
+	require.NoError(t, json.Unmarshal([]byte(`{"payment_method": "upi", "payment_amount": 10}`), &payment))
+	assert.Equal(t, "upi", payment.PaymentMethod)
+	assert.Nil(t, payment.EMI)
+}
+
+func TestPaymentEMIDetails(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	webhook, err := gateway.SignWebhook("PAYMENT_SUCCESS_WEBHOOK", map[string]interface{}{
+		"order": map[string]interface{}{"order_id": "order_1"},
+		"payment": map[string]interface{}{
+			"cf_payment_id":  "cf_payment_1",
+			"payment_status": "SUCCESS",
+			"payment_amount": 100,
+			"payment_time":   "2024-04-01T10:00:00+05:30",
+			"payment_method": map[string]interface{}{"emi": map[string]interface{}{
+				"emi_bank":    "HDFC Bank",
+				"emi_details": map[string]interface{}{"emi_amount": 34.5, "emi_tenure": 3},
+			}},
+		},
+	})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments/order_1", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		EMI *EMIDetails `json:"emi_details"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, &EMIDetails{Tenure: 3, InstallmentAmount: 34.5, Issuer: "HDFC Bank"}, resp.EMI)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Envelope is the v2 response body
+type Envelope struct {
+	Data  interface{}    `json:"data" yaml:"data"`
+	Error *EnvelopeError `json:"error,omitempty" yaml:"error,omitempty"`
+	Meta  *EnvelopeMeta  `json:"meta,omitempty" yaml:"meta,omitempty"`
+}
+
+// EnvelopeError describes a failed v2 request with a machine-readable code
+type EnvelopeError struct {
+	Code    string      `json:"code" yaml:"code"`
+	Message string      `json:"message" yaml:"message"`
+	Details interface{} `json:"details,omitempty" yaml:"details,omitempty"`
+}
+
+// EnvelopeMeta carries response metadata such as pagination
+type EnvelopeMeta struct {
+	Pagination *Pagination `json:"pagination,omitempty" yaml:"pagination,omitempty"`
+}
+
+// Pagination describes a page of a list response
+type Pagination struct {
+	Limit  int `json:"limit" yaml:"limit"`
+	Offset int `json:"offset" yaml:"offset"`
+	Count  int `json:"count" yaml:"count"`
+}
+
+// v2 error codes
+const (
+	ErrCodeInvalidRequest      = "invalid_request"
+	ErrCodeUnauthorized        = "unauthorized"
+	ErrCodeForbidden           = "forbidden"
+	ErrCodeNotFound            = "not_found"
+	ErrCodeNotAcceptable       = "not_acceptable"
+	ErrCodeConflict            = "conflict"
+	ErrCodeUnprocessable       = "unprocessable_entity"
+	ErrCodeRateLimited         = "rate_limited"
+	ErrCodeInternal            = "internal_error"
+	ErrCodeGatewayError        = "gateway_error"
+	ErrCodePaymentNotFound     = "payment_not_found"
+	ErrCodeRefundNotFound      = "refund_not_found"
+	ErrCodeSettlementNotFound  = "settlement_not_found"
+	ErrCodeMissingAPIKey       = "missing_api_key"
+	ErrCodeInvalidAPIKey       = "invalid_api_key"
+	ErrCodeMerchantDisabled    = "merchant_disabled"
+	ErrCodePaymentNotPaid      = "payment_not_paid"
+	ErrCodeUnsupportedExport   = "unsupported_export_format"
+	ErrCodeInvalidEnvironment  = "invalid_environment"
+	ErrCodeQuotaExceeded       = "quota_exceeded"
+	ErrCodeOrderNotFound       = "order_not_found"
+	ErrCodeInsufficientBalance = "insufficient_balance"
+	ErrCodeReconRunNotFound    = "recon_run_not_found"
+	ErrCodeRefundNotPending    = "refund_not_pending_approval"
+	ErrCodeSameApprover        = "approver_is_requester"
+	ErrCodeMissingActor        = "missing_actor"
+	ErrCodeCustomerBlocked     = "customer_blocked"
+)
+
+// errorCodesByMessage maps the error messages of the shared v1 handlers to v2 codes
+var errorCodesByMessage = map[string]string{
+	"Payment not found":                           ErrCodePaymentNotFound,
+	"Refund not found":                            ErrCodeRefundNotFound,
+	"Settlement not found":                        ErrCodeSettlementNotFound,
+	"Missing API key":                             ErrCodeMissingAPIKey,
+	"Invalid API key":                             ErrCodeInvalidAPIKey,
+	"Merchant is disabled":                        ErrCodeMerchantDisabled,
+	"Receipt is only available for paid orders":   ErrCodePaymentNotPaid,
+	"format must be tally or zoho":                ErrCodeUnsupportedExport,
+	"X-Cashfree-Environment must be TEST or PROD": ErrCodeInvalidEnvironment,
+	"Rate limit exceeded":                         ErrCodeRateLimited,
+	"Daily order quota exceeded":                  ErrCodeQuotaExceeded,
+	"Daily refund quota exceeded":                 ErrCodeQuotaExceeded,
+	"Order not found":                             ErrCodeOrderNotFound,
+	"Insufficient balance":                        ErrCodeInsufficientBalance,
+	"Payment gateway rate limit exceeded":         ErrCodeRateLimited,
+	"Recon run not found":                         ErrCodeReconRunNotFound,
+	"Refund is not pending approval":              ErrCodeRefundNotPending,
+	"Approver must not be the requester":          ErrCodeSameApprover,
+	"X-Actor header is required":                  ErrCodeMissingActor,
+	"Customer is blocked":                         ErrCodeCustomerBlocked,
+}
+
+// errorCodeForStatus is the fallback v2 code for an HTTP status
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusNotAcceptable:
+		return ErrCodeNotAcceptable
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusUnprocessableEntity:
+		return ErrCodeUnprocessable
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ErrCodeGatewayError
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// envelopeDataKey and envelopeMetaKey let a handler shape its v2 response. The v1
+// response is unaffected.
+const (
+	envelopeDataKey = "envelope_data"
+	envelopeMetaKey = "envelope_meta"
+)
+
+// setEnvelope sets the data and metadata of the v2 response for a handler whose v1 body
+// is not the resource itself, such as a list with pagination fields
+func setEnvelope(c *gin.Context, data interface{}, meta *EnvelopeMeta) {
+	c.Set(envelopeDataKey, data)
+	c.Set(envelopeMetaKey, meta)
+}
+
+// Response formats offered by v2
+const (
+	formatJSON = "application/json"
+	formatYAML = "application/yaml"
+)
+
+// negotiableFormats are the content types v2 can respond with: enveloped JSON or YAML,
+// and the CSV and XML files of the export endpoints
+var negotiableFormats = []string{formatJSON, formatYAML, "application/x-yaml", "text/csv", "application/xml"}
+
+// bufferedWriter holds back a handler's response so it can be re-rendered
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+// EnvelopeMiddleware renders the JSON responses of the handlers it wraps in the v2
+// envelope, as JSON or YAML depending on the Accept header, with error messages in the
+// language of the Accept-Language header. Other responses, such as CSV exports, are
+// passed through unchanged.
+func EnvelopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format := formatJSON
+		if accept := c.GetHeader("Accept"); accept != "" {
+			switch c.NegotiateFormat(negotiableFormats...) {
+			case "":
+				envelopeErr := &EnvelopeError{
+					Code:    ErrCodeNotAcceptable,
+					Message: "Supported response formats are application/json and application/yaml",
+				}
+				localizeError(c, envelopeErr)
+				c.AbortWithStatusJSON(http.StatusNotAcceptable, Envelope{Error: envelopeErr})
+				return
+			case formatYAML, "application/x-yaml":
+				format = formatYAML
+			}
+		}
+
+		original := c.Writer
+		buffer := &bufferedWriter{ResponseWriter: original, status: http.StatusOK}
+		c.Writer = buffer
+		c.Next()
+		c.Writer = original
+
+		contentType := original.Header().Get("Content-Type")
+		if !strings.HasPrefix(contentType, "application/json") {
+			original.WriteHeader(buffer.status)
+			original.Write(buffer.body.Bytes())
+			return
+		}
+
+		envelope := buildEnvelope(c, buffer.status, buffer.body.Bytes())
+		if envelope.Error != nil {
+			localizeError(c, envelope.Error)
+		}
+		original.Header().Del("Content-Type")
+		original.Header().Del("Content-Length")
+		if format == formatYAML {
+			c.YAML(buffer.status, envelope)
+		} else {
+			c.JSON(buffer.status, envelope)
+		}
+	}
+}
+
+// buildEnvelope wraps a handler's JSON body in the v2 envelope
+func buildEnvelope(c *gin.Context, status int, body []byte) Envelope {
+	var decoded interface{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			return Envelope{Error: &EnvelopeError{Code: ErrCodeInternal, Message: "Malformed response"}}
+		}
+	}
+
+	if status >= http.StatusBadRequest {
+		return Envelope{Error: envelopeError(status, decoded)}
+	}
+
+	envelope := Envelope{Data: decoded}
+	if data, ok := c.Get(envelopeDataKey); ok {
+		envelope.Data = data
+	}
+	if meta, ok := c.Get(envelopeMetaKey); ok {
+		envelope.Meta, _ = meta.(*EnvelopeMeta)
+	}
+	return envelope
+}
+
+// envelopeError converts a v1 error body ({"error": "..."} plus optional fields) into
+// a v2 error
+func envelopeError(status int, body interface{}) *EnvelopeError {
+	envelopeErr := &EnvelopeError{Code: errorCodeForStatus(status), Message: http.StatusText(status)}
+
+	fields, ok := body.(map[string]interface{})
+	if !ok {
+		return envelopeErr
+	}
+
+	if message, ok := fields["error"].(string); ok {
+		envelopeErr.Message = message
+		if code, ok := errorCodesByMessage[message]; ok {
+			envelopeErr.Code = code
+		}
+	}
+
+	details := make(map[string]interface{})
+	for key, value := range fields {
+		if key != "error" {
+			details[key] = value
+		}
+	}
+	if len(details) > 0 {
+		envelopeErr.Details = details
+	}
+	return envelopeErr
+}
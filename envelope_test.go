@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func newEnvelopeRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	v2 := router.Group("/api/v2", EnvelopeMiddleware(), CashfreeEnvironmentMiddleware())
+	v2.GET("/payments/:order_id", func(c *gin.Context) {
+		if c.Param("order_id") == "missing" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": c.Param("order_id")})
+	})
+	v2.GET("/payments", func(c *gin.Context) {
+		payments := []gin.H{{"order_id": "order_1"}}
+		setEnvelope(c, payments, &EnvelopeMeta{Pagination: &Pagination{Limit: 10, Offset: 0, Count: len(payments)}})
+		c.JSON(http.StatusOK, gin.H{"payments": payments, "limit": 10, "offset": 0, "count": len(payments)})
+	})
+	v2.POST("/payments/:order_id/refund", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount exceeds remaining balance", "refundable": 50.0})
+	})
+	v2.GET("/exports/payments", func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/csv", []byte("order_id\norder_1\n"))
+	})
+	return router
+}
+
+func serveEnvelope(t *testing.T, router *gin.Engine, method, path, accept string) (*httptest.ResponseRecorder, Envelope) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	router.ServeHTTP(w, req)
+
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	return w, envelope
+}
+
+func TestEnvelopeWrapsData(t *testing.T) {
+	router := newEnvelopeRouter()
+
+	w, envelope := serveEnvelope(t, router, "GET", "/api/v2/payments/order_1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, envelope.Error)
+	assert.Equal(t, map[string]interface{}{"order_id": "order_1"}, envelope.Data)
+
+	w, envelope = serveEnvelope(t, router, "GET", "/api/v2/payments", "application/json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []interface{}{map[string]interface{}{"order_id": "order_1"}}, envelope.Data)
+	require.NotNil(t, envelope.Meta)
+	assert.Equal(t, &Pagination{Limit: 10, Offset: 0, Count: 1}, envelope.Meta.Pagination)
+}
+
+func TestEnvelopeErrorCodes(t *testing.T) {
+	router := newEnvelopeRouter()
+
+	w, envelope := serveEnvelope(t, router, "GET", "/api/v2/payments/missing", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	require.NotNil(t, envelope.Error)
+	assert.Equal(t, ErrCodePaymentNotFound, envelope.Error.Code)
+	assert.Equal(t, "Payment not found", envelope.Error.Message)
+	assert.Nil(t, envelope.Data)
+
+	// Unknown messages fall back to the code for the status, extra fields become details
+	w, envelope = serveEnvelope(t, router, "POST", "/api/v2/payments/order_1/refund", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrCodeInvalidRequest, envelope.Error.Code)
+	assert.Equal(t, map[string]interface{}{"refundable": 50.0}, envelope.Error.Details)
+
+	// Errors from middleware inside the group are enveloped too
+	req, _ := http.NewRequest("GET", "/api/v2/payments/order_1", nil)
+	req.Header.Set("X-Cashfree-Environment", "staging")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &envelope))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, ErrCodeInvalidEnvironment, envelope.Error.Code)
+}
+
+func TestEnvelopeContentNegotiation(t *testing.T) {
+	router := newEnvelopeRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v2/payments/order_1", nil)
+	req.Header.Set("Accept", "application/yaml")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "yaml")
+	var envelope map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, map[string]interface{}{"order_id": "order_1"}, envelope["data"])
+
+	w, jsonEnvelope := serveEnvelope(t, router, "GET", "/api/v2/payments/order_1", "text/html")
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+	assert.Equal(t, ErrCodeNotAcceptable, jsonEnvelope.Error.Code)
+
+	// File downloads are not enveloped
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v2/exports/payments", nil)
+	req.Header.Set("Accept", "text/csv")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "order_id\norder_1\n", w.Body.String())
+}
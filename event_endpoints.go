@@ -0,0 +1,1071 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// Bounds of event delivery
+const (
+	eventDeliveryBatch   = 100
+	eventDeliveryTimeout = 10 * time.Second
+	eventRetryMax        = 7 * 24 * 60 * 60 // seconds before a single retry
+	eventRetryStepsMax   = 20
+)
+
+// defaultEventRetrySchedule is the retry schedule of endpoints created without one,
+// unless EVENT_RETRY_SCHEDULE sets another: 1m, 5m, 30m, 2h, 6h and 24h
+var defaultEventRetrySchedule = []int{60, 300, 1800, 7200, 21600, 86400}
+
+// EventEndpoint is a merchant URL our events are delivered to, such as an ERP or OMS.
+// Each endpoint signs with its own secret and retries on its own schedule.
+type EventEndpoint struct {
+	ID            uuid.UUID  `json:"id"`
+	TenantID      *uuid.UUID `json:"tenant_id,omitempty"`
+	URL           string     `json:"url"`
+	Description   *string    `json:"description,omitempty"`
+	EventTypes    []string   `json:"event_types"`      // empty for every type
+	RetrySchedule []int      `json:"retry_schedule"`   // seconds before each retry
+	Secret        string     `json:"secret,omitempty"` // only returned when created or rotated
+	Active        bool       `json:"active"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	storedSecret    string // as stored, encrypted when secretEncrypted
+	secretEncrypted bool
+}
+
+// EventDelivery is the delivery of one event to one endpoint
+type EventDelivery struct {
+	ID             uuid.UUID             `json:"id"`
+	EndpointID     uuid.UUID             `json:"endpoint_id"`
+	EventID        string                `json:"event_id"`
+	EventType      string                `json:"event_type"`
+	OrderID        *string               `json:"order_id,omitempty"`
+	Payload        json.RawMessage       `json:"payload"`
+	Status         domain.DeliveryStatus `json:"status"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int                  `json:"last_status_code,omitempty"`
+	LastError      *string               `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+
+	AttemptLog []EventDeliveryAttempt `json:"attempt_log,omitempty"`
+}
+
+// EventDeliveryAttempt records one request made to deliver an event
+type EventDeliveryAttempt struct {
+	Attempt     int       `json:"attempt"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       *string   `json:"error,omitempty"`
+	DurationMS  int       `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// dueDelivery is a pending delivery with the endpoint it goes to
+type dueDelivery struct {
+	delivery EventDelivery
+	endpoint EventEndpoint
+}
+
+const eventEndpointColumns = `id, tenant_id, url, description, event_types, retry_schedule,
+			   secret, secret_encrypted, active, created_at, updated_at`
+
+func scanEventEndpoint(row pgx.Row) (*EventEndpoint, error) {
+	var e EventEndpoint
+	err := row.Scan(
+		&e.ID, &e.TenantID, &e.URL, &e.Description, &e.EventTypes, &e.RetrySchedule,
+		&e.storedSecret, &e.secretEncrypted, &e.Active, &e.CreatedAt, &e.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+const eventDeliveryColumns = `d.id, d.endpoint_id, d.event_id::text, d.event_type, d.order_id, d.payload,
+			   d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error,
+			   d.delivered_at, d.created_at, d.updated_at`
+
+func scanEventDelivery(row pgx.Row) (*EventDelivery, error) {
+	var d EventDelivery
+	var payload []byte
+	err := row.Scan(
+		&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &d.OrderID, &payload,
+		&d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastStatusCode, &d.LastError,
+		&d.DeliveredAt, &d.CreatedAt, &d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	return &d, nil
+}
+
+// CreateEventEndpoint stores an endpoint for the context's merchant. The endpoint's
+// stored secret must already be set.
+func (r *PaymentRepository) CreateEventEndpoint(ctx context.Context, endpoint *EventEndpoint) error {
+	query := `
+		INSERT INTO event_endpoints (
+			id, tenant_id, url, description, event_types, retry_schedule,
+			secret, secret_encrypted, active, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+	`
+
+	endpoint.ID = uuid.New()
+	endpoint.TenantID = TenantIDFromContext(ctx)
+	endpoint.CreatedAt = r.now()
+	endpoint.UpdatedAt = endpoint.CreatedAt
+
+	_, err := r.db.Exec(ctx, query,
+		endpoint.ID, endpoint.TenantID, endpoint.URL, endpoint.Description, endpoint.EventTypes,
+		endpoint.RetrySchedule, endpoint.storedSecret, endpoint.secretEncrypted, endpoint.Active,
+		endpoint.CreatedAt,
+	)
+	return err
+}
+
+// ListEventEndpoints returns the context's merchant's endpoints, oldest first
+func (r *PaymentRepository) ListEventEndpoints(ctx context.Context) ([]EventEndpoint, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "WHERE", 1)
+	query := `SELECT ` + eventEndpointColumns + ` FROM event_endpoints` + tenant + ` ORDER BY created_at`
+
+	rows, err := r.db.Query(ctx, query, tenantArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []EventEndpoint{}
+	for rows.Next() {
+		endpoint, err := scanEventEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+// GetEventEndpoint returns one of the context's merchant's endpoints
+func (r *PaymentRepository) GetEventEndpoint(ctx context.Context, id uuid.UUID) (*EventEndpoint, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `SELECT ` + eventEndpointColumns + ` FROM event_endpoints WHERE id = $1` + tenant
+	return scanEventEndpoint(r.db.QueryRow(ctx, query, append([]interface{}{id}, tenantArgs...)...))
+}
+
+// UpdateEventEndpoint saves an endpoint's URL, description, event types, retry schedule,
+// active flag and stored secret
+func (r *PaymentRepository) UpdateEventEndpoint(ctx context.Context, endpoint *EventEndpoint) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 10)
+	query := `
+		UPDATE event_endpoints
+		SET url = $2, description = $3, event_types = $4, retry_schedule = $5,
+		    active = $6, secret = $7, secret_encrypted = $8, updated_at = $9
+		WHERE id = $1` + tenant
+
+	endpoint.UpdatedAt = r.now()
+	args := append([]interface{}{
+		endpoint.ID, endpoint.URL, endpoint.Description, endpoint.EventTypes, endpoint.RetrySchedule,
+		endpoint.Active, endpoint.storedSecret, endpoint.secretEncrypted, endpoint.UpdatedAt,
+	}, tenantArgs...)
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteEventEndpoint removes an endpoint with its delivery log
+func (r *PaymentRepository) DeleteEventEndpoint(ctx context.Context, id uuid.UUID) (bool, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	tag, err := r.db.Exec(ctx, `DELETE FROM event_endpoints WHERE id = $1`+tenant, append([]interface{}{id}, tenantArgs...)...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// EnqueueEventDeliveries schedules an event for immediate delivery to every active
+// endpoint of the merchant owning its order that subscribes to its type. Events without
+// a merchant, those of single-merchant deployments, go to endpoints without a merchant.
+// An event already enqueued for an endpoint is not enqueued twice.
+func (r *PaymentRepository) EnqueueEventDeliveries(ctx context.Context, event events.Event) (int, error) {
+	var tenantID *uuid.UUID
+	if event.TenantID != "" {
+		id, err := uuid.Parse(event.TenantID)
+		if err != nil {
+			return 0, fmt.Errorf("event %s has an invalid merchant ID: %w", event.ID, err)
+		}
+		tenantID = &id
+	}
+	var orderID *string
+	if event.OrderID != "" {
+		orderID = &event.OrderID
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `
+		INSERT INTO event_deliveries (
+			id, endpoint_id, event_id, event_type, order_id, payload, status,
+			attempts, next_attempt_at, created_at, updated_at
+		)
+		SELECT uuid_generate_v4(), e.id, $1, $2, $3, $4, $5, 0, $6, $6, $6
+		FROM event_endpoints e
+		WHERE e.active AND e.tenant_id IS NOT DISTINCT FROM $7
+		  AND (cardinality(e.event_types) = 0 OR $2 = ANY(e.event_types))
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	`
+
+	tag, err := r.db.Exec(ctx, query,
+		event.ID, event.Type, orderID, body, domain.DeliveryPending, r.now(), tenantID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// DueEventDeliveries returns up to limit pending deliveries due by now, with their
+// endpoints, longest waiting first
+func (r *PaymentRepository) DueEventDeliveries(ctx context.Context, now time.Time, limit int) ([]dueDelivery, error) {
+	query := `
+		SELECT ` + eventDeliveryColumns + `,
+		       e.id, e.tenant_id, e.url, e.description, e.event_types, e.retry_schedule,
+		       e.secret, e.secret_encrypted, e.active, e.created_at, e.updated_at
+		FROM event_deliveries d
+		JOIN event_endpoints e ON e.id = d.endpoint_id
+		WHERE d.status = $1 AND d.next_attempt_at <= $2 AND e.active
+		ORDER BY d.next_attempt_at
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(ctx, query, domain.DeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueDelivery
+	for rows.Next() {
+		var item dueDelivery
+		e := &item.endpoint
+		delivery, err := scanEventDelivery(trailingColumns{rows, []interface{}{
+			&e.ID, &e.TenantID, &e.URL, &e.Description, &e.EventTypes, &e.RetrySchedule,
+			&e.storedSecret, &e.secretEncrypted, &e.Active, &e.CreatedAt, &e.UpdatedAt,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		item.delivery = *delivery
+		due = append(due, item)
+	}
+
+	return due, rows.Err()
+}
+
+// RecordEventDeliveryAttempt appends an attempt to the delivery log and saves the
+// delivery's new status, attempt count and next attempt
+func (r *PaymentRepository) RecordEventDeliveryAttempt(ctx context.Context, delivery *EventDelivery, attempt EventDeliveryAttempt) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO event_delivery_attempts (id, delivery_id, attempt, status_code, error, duration_ms, attempted_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, uuid.New(), delivery.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.DurationMS, attempt.AttemptedAt)
+	if err != nil {
+		return err
+	}
+
+	delivery.UpdatedAt = r.now()
+	_, err = tx.Exec(ctx, `
+		UPDATE event_deliveries
+		SET status = $2, attempts = $3, next_attempt_at = $4, last_status_code = $5,
+		    last_error = $6, delivered_at = $7, updated_at = $8
+		WHERE id = $1
+	`, delivery.ID, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatusCode,
+		delivery.LastError, delivery.DeliveredAt, delivery.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListEventDeliveries returns an endpoint's deliveries, newest first, optionally of one
+// status
+func (r *PaymentRepository) ListEventDeliveries(ctx context.Context, endpointID uuid.UUID, status string, limit, offset int) ([]EventDelivery, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 5)
+	query := `
+		SELECT ` + eventDeliveryColumns + `
+		FROM event_deliveries d
+		JOIN event_endpoints e ON e.id = d.endpoint_id
+		WHERE d.endpoint_id = $1 AND ($2 = '' OR d.status = $2)` + tenant + `
+		ORDER BY d.created_at DESC
+		LIMIT $3 OFFSET $4
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{endpointID, status, limit, offset}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []EventDelivery{}
+	for rows.Next() {
+		delivery, err := scanEventDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetEventDelivery returns a delivery of one of the context's merchant's endpoints with
+// its attempts
+func (r *PaymentRepository) GetEventDelivery(ctx context.Context, id uuid.UUID) (*EventDelivery, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT ` + eventDeliveryColumns + `
+		FROM event_deliveries d
+		JOIN event_endpoints e ON e.id = d.endpoint_id
+		WHERE d.id = $1` + tenant
+
+	delivery, err := scanEventDelivery(r.db.QueryRow(ctx, query, append([]interface{}{id}, tenantArgs...)...))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT attempt, status_code, error, duration_ms, attempted_at
+		FROM event_delivery_attempts
+		WHERE delivery_id = $1
+		ORDER BY attempt
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	delivery.AttemptLog = []EventDeliveryAttempt{}
+	for rows.Next() {
+		var attempt EventDeliveryAttempt
+		if err := rows.Scan(&attempt.Attempt, &attempt.StatusCode, &attempt.Error, &attempt.DurationMS, &attempt.AttemptedAt); err != nil {
+			return nil, err
+		}
+		delivery.AttemptLog = append(delivery.AttemptLog, attempt)
+	}
+
+	return delivery, rows.Err()
+}
+
+// RetryEventDelivery schedules a delivery for an immediate attempt. A failed delivery
+// gets one more attempt and fails again if that attempt does.
+func (r *PaymentRepository) RetryEventDelivery(ctx context.Context, id uuid.UUID) (*EventDelivery, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 4)
+	query := `
+		UPDATE event_deliveries d
+		SET status = $2, next_attempt_at = $3, updated_at = $3
+		FROM event_endpoints e
+		WHERE e.id = d.endpoint_id AND d.id = $1` + tenant + `
+		RETURNING ` + eventDeliveryColumns
+
+	args := append([]interface{}{id, domain.DeliveryPending, r.now()}, tenantArgs...)
+	return scanEventDelivery(r.db.QueryRow(ctx, query, args...))
+}
+
+// EventEndpoints fans events out to merchant endpoints: it enqueues a delivery per
+// subscribed endpoint as events are published, delivers them from a background job, and
+// serves the endpoint API
+type EventEndpoints struct {
+	repo     *PaymentRepository
+	box      *SecretBox // encrypts secrets at rest; nil stores them as they are
+	schedule []int      // retry schedule of endpoints created without one
+	client   *http.Client
+	clock    Clock
+
+	// guard keeps endpoint URLs and deliveries off private addresses; nil allows any
+	guard *endpointGuard
+}
+
+// NewEventEndpoints creates the event fan-out. box may be nil in single-merchant
+// deployments without MERCHANT_ENCRYPTION_KEY.
+func NewEventEndpoints(repo *PaymentRepository, box *SecretBox, schedule []int) *EventEndpoints {
+	if len(schedule) == 0 {
+		schedule = defaultEventRetrySchedule
+	}
+	guard := &endpointGuard{lookup: net.DefaultResolver.LookupIPAddr}
+	return &EventEndpoints{
+		repo:     repo,
+		box:      box,
+		schedule: schedule,
+		client:   guard.client(),
+		clock:    SystemClock,
+		guard:    guard,
+	}
+}
+
+// now returns the time attempts are scheduled against
+func (d *EventEndpoints) now() time.Time {
+	return clockOrSystem(d.clock).Now()
+}
+
+// Subscribe enqueues deliveries of every event published on bus
+func (d *EventEndpoints) Subscribe(bus events.Subscriber) {
+	bus.Subscribe(events.All, func(ctx context.Context, event events.Event) error {
+		_, err := d.repo.EnqueueEventDeliveries(ctx, event)
+		return err
+	})
+}
+
+// setSecret stores secret on endpoint, encrypted when a box is configured
+func (d *EventEndpoints) setSecret(endpoint *EventEndpoint, secret string) error {
+	endpoint.Secret = secret
+	endpoint.storedSecret = secret
+	endpoint.secretEncrypted = false
+	if d.box != nil {
+		encrypted, err := d.box.Encrypt(secret)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt endpoint secret: %v", err)
+		}
+		endpoint.storedSecret = encrypted
+		endpoint.secretEncrypted = true
+	}
+	return nil
+}
+
+// secret returns an endpoint's signing secret
+func (d *EventEndpoints) secret(endpoint *EventEndpoint) (string, error) {
+	if !endpoint.secretEncrypted {
+		return endpoint.storedSecret, nil
+	}
+	if d.box == nil {
+		return "", fmt.Errorf("secret of endpoint %s is encrypted and MERCHANT_ENCRYPTION_KEY is not set", endpoint.ID)
+	}
+	return d.box.Decrypt(endpoint.storedSecret)
+}
+
+// newEndpointSecret generates a random signing secret for an endpoint
+func newEndpointSecret() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+// signEvent returns the signature of an event delivery: base64(HMAC-SHA256(timestamp +
+// body)), the scheme Cashfree signs its own webhooks with, so endpoints can verify both
+// the same way
+func signEvent(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write(body)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// nextEventAttempt returns when to retry after the attempts'th failed attempt, or nil
+// once the schedule is exhausted
+func nextEventAttempt(schedule []int, attempts int, now time.Time) *time.Time {
+	if attempts < 1 || attempts > len(schedule) {
+		return nil
+	}
+	next := now.Add(time.Duration(schedule[attempts-1]) * time.Second)
+	return &next
+}
+
+// DeliverDue attempts every due delivery once, returning how many were delivered
+func (d *EventEndpoints) DeliverDue(ctx context.Context) (int, error) {
+	due, err := d.repo.DueEventDeliveries(ctx, d.now(), eventDeliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+		if err := d.attempt(ctx, &due[i].delivery, &due[i].endpoint); err != nil {
+			return delivered, err
+		}
+		if due[i].delivery.Status == domain.DeliveryDelivered {
+			delivered++
+		}
+	}
+	return delivered, nil
+}
+
+// attempt posts a delivery to its endpoint and records the outcome. Any 2xx response
+// delivers the event; other responses and network errors are retried on the endpoint's
+// schedule, after which the delivery fails.
+func (d *EventEndpoints) attempt(ctx context.Context, delivery *EventDelivery, endpoint *EventEndpoint) error {
+	started := d.now()
+	attempt := EventDeliveryAttempt{Attempt: delivery.Attempts + 1, AttemptedAt: started}
+
+	statusCode, err := d.post(ctx, delivery, endpoint)
+	attempt.DurationMS = int(d.now().Sub(started) / time.Millisecond)
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+	if err == nil && (statusCode < 200 || statusCode > 299) {
+		err = fmt.Errorf("endpoint answered %d", statusCode)
+	}
+
+	delivery.Attempts = attempt.Attempt
+	delivery.LastStatusCode = attempt.StatusCode
+	if err != nil {
+		message := err.Error()
+		attempt.Error = &message
+		delivery.LastError = &message
+		delivery.NextAttemptAt = nextEventAttempt(endpoint.RetrySchedule, delivery.Attempts, d.now())
+		if delivery.NextAttemptAt == nil {
+			delivery.Status = domain.DeliveryFailed
+			log.Printf("Giving up delivering event %s to endpoint %s after %d attempts: %v", delivery.EventID, endpoint.ID, delivery.Attempts, err)
+		}
+	} else {
+		now := d.now()
+		delivery.Status = domain.DeliveryDelivered
+		delivery.LastError = nil
+		delivery.NextAttemptAt = nil
+		delivery.DeliveredAt = &now
+	}
+
+	return d.repo.RecordEventDeliveryAttempt(ctx, delivery, attempt)
+}
+
+// post sends a delivery's payload to its endpoint, returning the response status
+func (d *EventEndpoints) post(ctx context.Context, delivery *EventDelivery, endpoint *EventEndpoint) (int, error) {
+	secret, err := d.secret(endpoint)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, eventDeliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", delivery.EventID)
+	req.Header.Set("X-Event-Type", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signEvent(secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// EventDeliveryJob delivers due events every 15 seconds
+func (d *EventEndpoints) EventDeliveryJob() Job {
+	return Job{
+		Name:     "event_deliveries",
+		Schedule: Every(15 * time.Second),
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			delivered, err := d.DeliverDue(ctx)
+			if delivered > 0 {
+				log.Printf("Delivered %d events to merchant endpoints", delivered)
+			}
+			return err
+		},
+	}
+}
+
+// parseRetrySchedule parses a comma-separated list of seconds before each retry
+func parseRetrySchedule(entries []string) ([]int, error) {
+	var schedule []int
+	for _, entry := range entries {
+		seconds, err := strconv.Atoi(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, fmt.Errorf("entries must be seconds, got %q", entry)
+		}
+		schedule = append(schedule, seconds)
+	}
+	return schedule, validateRetrySchedule(schedule)
+}
+
+// validateRetrySchedule checks that a schedule has at most eventRetryStepsMax retries,
+// each between 1 second and a week away
+func validateRetrySchedule(schedule []int) error {
+	if len(schedule) > eventRetryStepsMax {
+		return fmt.Errorf("retry_schedule allows at most %d retries", eventRetryStepsMax)
+	}
+	for _, seconds := range schedule {
+		if seconds < 1 || seconds > eventRetryMax {
+			return fmt.Errorf("retry_schedule entries must be between 1 and %d seconds, got %d", eventRetryMax, seconds)
+		}
+	}
+	return nil
+}
+
+// validateEndpointURL checks that an endpoint URL is an absolute https URL whose host
+// guard allows
+func validateEndpointURL(ctx context.Context, raw string, guard *endpointGuard) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.Scheme != "https" {
+		return fmt.Errorf("url must be an absolute https URL")
+	}
+	return guard.checkHost(ctx, parsed.Hostname())
+}
+
+// endpointGuard keeps event endpoints from reaching the deployment's own network, such
+// as the database or a cloud metadata service. URLs must resolve to public addresses
+// only, and deliveries only dial public addresses, so a name pointed elsewhere after
+// validation is still refused.
+type endpointGuard struct {
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// checkHost resolves an endpoint host and refuses it when any of its addresses is not
+// public. A nil guard allows every host.
+func (g *endpointGuard) checkHost(ctx context.Context, host string) error {
+	if g == nil {
+		return nil
+	}
+
+	var addrs []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IP{ip}
+	} else {
+		resolved, err := g.lookup(ctx, host)
+		if err != nil || len(resolved) == 0 {
+			return fmt.Errorf("url host %s could not be resolved", host)
+		}
+		for _, addr := range resolved {
+			addrs = append(addrs, addr.IP)
+		}
+	}
+	for _, ip := range addrs {
+		if !publicAddress(ip) {
+			return fmt.Errorf("url host %s must not resolve to a loopback, link-local, private or unspecified address", host)
+		}
+	}
+	return nil
+}
+
+// client returns the HTTP client deliveries are sent with. It dials endpoints directly,
+// not through a proxy, so every address it connects to is checked, and refuses
+// redirects away from https.
+func (g *endpointGuard) client() *http.Client {
+	dialer := &net.Dialer{Timeout: eventDeliveryTimeout, Control: g.control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   eventDeliveryTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to %s is not https", req.URL.Redacted())
+			}
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// control refuses a connection about to be made to an address that is not public
+func (g *endpointGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return fmt.Errorf("refusing to deliver to %s: not a public address", address)
+	}
+	return nil
+}
+
+// publicAddress reports whether ip is neither loopback, link-local, private, multicast
+// nor unspecified
+func publicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() && !ip.IsMulticast() && !ip.IsPrivate() && !ip.IsUnspecified()
+}
+
+// EventEndpointRequest creates an event endpoint, or changes one when sent to PATCH,
+// where omitted fields are left as they are
+type EventEndpointRequest struct {
+	URL           *string   `json:"url"`
+	Description   *string   `json:"description" binding:"omitempty,max=500"`
+	EventTypes    *[]string `json:"event_types"`
+	RetrySchedule *[]int    `json:"retry_schedule"`
+	Active        *bool     `json:"active"`
+}
+
+// apply copies the request's fields onto endpoint, validating them and checking the URL
+// with guard
+func (req *EventEndpointRequest) apply(ctx context.Context, endpoint *EventEndpoint, guard *endpointGuard) error {
+	if req.URL != nil {
+		if err := validateEndpointURL(ctx, *req.URL, guard); err != nil {
+			return err
+		}
+		endpoint.URL = *req.URL
+	}
+	if req.Description != nil {
+		endpoint.Description = req.Description
+		if *req.Description == "" {
+			endpoint.Description = nil
+		}
+	}
+	if req.EventTypes != nil {
+		types := []string{}
+		for _, eventType := range *req.EventTypes {
+			eventType = strings.TrimSpace(eventType)
+			if eventType == "" || eventType == events.All {
+				return fmt.Errorf("event_types must name event types; leave it empty for every type")
+			}
+			if !slices.Contains(types, eventType) {
+				types = append(types, eventType)
+			}
+		}
+		endpoint.EventTypes = types
+	}
+	if req.RetrySchedule != nil {
+		if err := validateRetrySchedule(*req.RetrySchedule); err != nil {
+			return err
+		}
+		endpoint.RetrySchedule = append([]int{}, *req.RetrySchedule...)
+	}
+	if req.Active != nil {
+		endpoint.Active = *req.Active
+	}
+	return nil
+}
+
+// uuidParam parses a UUID path parameter, writing the error response when it is invalid
+func uuidParam(c *gin.Context, name, what string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(name))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + what + " ID"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// Creates an event endpoint. The signing secret is generated and returned only in this
+// response.
+func (d *EventEndpoints) CreateEndpoint(c *gin.Context) {
+	var req EventEndpointRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.URL == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return
+	}
+
+	endpoint := &EventEndpoint{
+		EventTypes:    []string{},
+		RetrySchedule: append([]int{}, d.schedule...),
+		Active:        true,
+	}
+	if err := req.apply(c.Request.Context(), endpoint, d.guard); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := newEndpointSecret()
+	if err == nil {
+		err = d.setSecret(endpoint, secret)
+	}
+	if err != nil {
+		log.Printf("Failed to generate endpoint secret: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event endpoint"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	if err := d.repo.CreateEventEndpoint(ctx, endpoint); err != nil {
+		log.Printf("Failed to create event endpoint: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event endpoint"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, endpoint)
+}
+
+// Lists the merchant's event endpoints
+func (d *EventEndpoints) ListEndpoints(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	endpoints, err := d.repo.ListEventEndpoints(ctx)
+	if err != nil {
+		log.Printf("Failed to list event endpoints: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event endpoints"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"endpoints": endpoints, "count": len(endpoints)})
+}
+
+// Gets an event endpoint
+func (d *EventEndpoints) GetEndpoint(c *gin.Context) {
+	id, ok := uuidParam(c, "endpoint_id", "event endpoint")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	endpoint, err := d.repo.GetEventEndpoint(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event endpoint not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// Changes an event endpoint's URL, description, event types, retry schedule or whether
+// it is active
+func (d *EventEndpoints) UpdateEndpoint(c *gin.Context) {
+	d.changeEndpoint(c, func(endpoint *EventEndpoint) (int, error) {
+		var req EventEndpointRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return http.StatusBadRequest, err
+		}
+		if err := req.apply(c.Request.Context(), endpoint, d.guard); err != nil {
+			return http.StatusBadRequest, err
+		}
+		return 0, nil
+	})
+}
+
+// Replaces an event endpoint's signing secret, returning the new one only in this
+// response. Deliveries are signed with the new secret from the next attempt.
+func (d *EventEndpoints) RotateSecret(c *gin.Context) {
+	d.changeEndpoint(c, func(endpoint *EventEndpoint) (int, error) {
+		secret, err := newEndpointSecret()
+		if err == nil {
+			err = d.setSecret(endpoint, secret)
+		}
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	})
+}
+
+// changeEndpoint loads the endpoint_id endpoint, applies change to it and saves it.
+// change returns the status to answer with when it fails.
+func (d *EventEndpoints) changeEndpoint(c *gin.Context, change func(*EventEndpoint) (int, error)) {
+	id, ok := uuidParam(c, "endpoint_id", "event endpoint")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	endpoint, err := d.repo.GetEventEndpoint(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event endpoint not found"})
+		return
+	}
+	if status, err := change(endpoint); err != nil {
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to change event endpoint %s: %v", id, err)
+			c.JSON(status, gin.H{"error": "Failed to update event endpoint"})
+			return
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := d.repo.UpdateEventEndpoint(ctx, endpoint); err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event endpoint not found"})
+			return
+		}
+		log.Printf("Failed to update event endpoint %s: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event endpoint"})
+		return
+	}
+
+	c.JSON(http.StatusOK, endpoint)
+}
+
+// Deletes an event endpoint with its delivery log
+func (d *EventEndpoints) DeleteEndpoint(c *gin.Context) {
+	id, ok := uuidParam(c, "endpoint_id", "event endpoint")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	deleted, err := d.repo.DeleteEventEndpoint(ctx, id)
+	if err != nil {
+		log.Printf("Failed to delete event endpoint: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event endpoint"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event endpoint not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Lists an event endpoint's deliveries, newest first, optionally of one ?status=
+func (d *EventEndpoints) ListDeliveries(c *gin.Context) {
+	id, ok := uuidParam(c, "endpoint_id", "event endpoint")
+	if !ok {
+		return
+	}
+	status := ""
+	if value := c.Query("status"); value != "" {
+		parsed, err := domain.ParseDeliveryStatus(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		status = string(parsed)
+	}
+	limit, offset := paginationParams(c)
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	if _, err := d.repo.GetEventEndpoint(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event endpoint not found"})
+		return
+	}
+	deliveries, err := d.repo.ListEventDeliveries(ctx, id, status, limit, offset)
+	if err != nil {
+		log.Printf("Failed to list event deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+		"limit":      limit,
+		"offset":     offset,
+		"count":      len(deliveries),
+	})
+}
+
+// Gets an event delivery with every attempt made
+func (d *EventEndpoints) GetDelivery(c *gin.Context) {
+	id, ok := uuidParam(c, "delivery_id", "event delivery")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	delivery, err := d.repo.GetEventDelivery(ctx, id)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event delivery not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get event delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event delivery"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// Schedules an event delivery for immediate redelivery, such as a failed one once the
+// endpoint is fixed
+func (d *EventEndpoints) RetryDelivery(c *gin.Context) {
+	id, ok := uuidParam(c, "delivery_id", "event delivery")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	delivery, err := d.repo.RetryEventDelivery(ctx, id)
+	if err == pgx.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event delivery not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to retry event delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry event delivery"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// registerEventEndpointRoutes registers the event endpoint routes on a
+// merchant-authenticated group
+func registerEventEndpointRoutes(group *gin.RouterGroup, endpoints *EventEndpoints) {
+	// Merchant URLs our events are delivered to
+	group.POST("/event-endpoints", endpoints.CreateEndpoint)
+	group.GET("/event-endpoints", endpoints.ListEndpoints)
+	group.GET("/event-endpoints/:endpoint_id", endpoints.GetEndpoint)
+	group.PATCH("/event-endpoints/:endpoint_id", endpoints.UpdateEndpoint)
+	group.DELETE("/event-endpoints/:endpoint_id", endpoints.DeleteEndpoint)
+	group.POST("/event-endpoints/:endpoint_id/rotate-secret", endpoints.RotateSecret)
+
+	// Delivery log
+	group.GET("/event-endpoints/:endpoint_id/deliveries", endpoints.ListDeliveries)
+	group.GET("/event-deliveries/:delivery_id", endpoints.GetDelivery)
+	group.POST("/event-deliveries/:delivery_id/retry", endpoints.RetryDelivery)
+}
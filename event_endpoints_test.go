@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+func TestSignEventVerifiesLikeCashfreeWebhooks(t *testing.T) {
+	body := []byte(`{"type":"payment.succeeded"}`)
+	signature := signEvent("whsec_test", "1712000000", body)
+	assert.True(t, verifyWebhookSignature("whsec_test", signature, "1712000000", string(body)))
+	assert.False(t, verifyWebhookSignature("whsec_other", signature, "1712000000", string(body)))
+}
+
+func TestNextEventAttempt(t *testing.T) {
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	schedule := []int{60, 300}
+
+	assert.Equal(t, now.Add(time.Minute), *nextEventAttempt(schedule, 1, now))
+	assert.Equal(t, now.Add(5*time.Minute), *nextEventAttempt(schedule, 2, now))
+	assert.Nil(t, nextEventAttempt(schedule, 3, now), "the schedule is exhausted")
+	assert.Nil(t, nextEventAttempt(nil, 1, now), "an empty schedule never retries")
+}
+
+func TestParseRetrySchedule(t *testing.T) {
+	schedule, err := parseRetrySchedule([]string{"30", " 120", "3600 "})
+	require.NoError(t, err)
+	assert.Equal(t, []int{30, 120, 3600}, schedule)
+
+	for _, entries := range [][]string{{"1m"}, {"0"}, {"700000"}} {
+		_, err := parseRetrySchedule(entries)
+		assert.Error(t, err, "%v", entries)
+	}
+}
+
+func TestEventEndpointRequestValidation(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	ctx := context.Background()
+	guard := &endpointGuard{lookup: func(_ context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "erp.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.5")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}}
+
+	endpoint := &EventEndpoint{EventTypes: []string{}}
+	types := []string{"payment.succeeded", " refund.created", "payment.succeeded"}
+	require.NoError(t, (&EventEndpointRequest{URL: str("https://erp.example.com/hooks"), EventTypes: &types}).apply(ctx, endpoint, guard))
+	assert.Equal(t, "https://erp.example.com/hooks", endpoint.URL)
+	assert.Equal(t, []string{"payment.succeeded", "refund.created"}, endpoint.EventTypes)
+
+	for _, raw := range []string{
+		"ftp://erp.example.com", "/hooks", "http://erp.example.com/hooks",
+		"https://internal.example.com", "https://missing.example.com",
+		"https://127.0.0.1/hooks", "https://[::1]/hooks", "https://10.1.2.3", "https://192.168.1.1",
+		"https://169.254.169.254/latest/meta-data", "https://0.0.0.0", "https://[fd00::1]",
+	} {
+		assert.Error(t, (&EventEndpointRequest{URL: str(raw)}).apply(ctx, endpoint, guard), raw)
+	}
+	all := []string{events.All}
+	assert.Error(t, (&EventEndpointRequest{EventTypes: &all}).apply(ctx, endpoint, guard))
+	long := []int{60, 0}
+	assert.Error(t, (&EventEndpointRequest{RetrySchedule: &long}).apply(ctx, endpoint, guard))
+}
+
+func TestEventDeliveriesOnlyDialPublicAddresses(t *testing.T) {
+	guard := &endpointGuard{}
+	for _, address := range []string{"127.0.0.1:443", "[::1]:443", "10.0.0.5:443", "169.254.169.254:80", "0.0.0.0:443"} {
+		assert.Error(t, guard.control("tcp", address, nil), address)
+	}
+	assert.NoError(t, guard.control("tcp", "93.184.216.34:443", nil))
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	client := guard.client()
+	client.Transport.(*http.Transport).TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	_, err := client.Get(server.URL)
+	assert.ErrorContains(t, err, "not a public address")
+}
+
+// endpointRecorder is a merchant endpoint answering with the queued statuses, then 200
+type endpointRecorder struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (e *endpointRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, r)
+	e.bodies = append(e.bodies, body)
+	status := http.StatusOK
+	if len(e.statuses) > 0 {
+		status, e.statuses = e.statuses[0], e.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (e *endpointRecorder) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.requests)
+}
+
+func TestEventEndpointFanOut(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	repo := NewPaymentRepository(testDB(t))
+	repo.clock = clock
+	endpoints := NewEventEndpoints(repo, nil, []int{60, 300})
+	endpoints.clock = clock
+
+	erp := &endpointRecorder{statuses: []int{http.StatusServiceUnavailable}}
+	erpServer := httptest.NewTLSServer(erp)
+	defer erpServer.Close()
+	oms := &endpointRecorder{statuses: []int{500, 500, 500}}
+	omsServer := httptest.NewTLSServer(oms)
+	defer omsServer.Close()
+	// The test servers listen on loopback, which the guard refuses
+	endpoints.guard = nil
+	endpoints.client = erpServer.Client()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerEventEndpointRoutes(r.Group(""), endpoints)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(body string) EventEndpoint {
+		w := serve(http.MethodPost, "/event-endpoints", body)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var endpoint EventEndpoint
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &endpoint))
+		require.NotEmpty(t, endpoint.Secret)
+		return endpoint
+	}
+	erpEndpoint := create(`{"url": "` + erpServer.URL + `", "description": "ERP"}`)
+	omsEndpoint := create(`{"url": "` + omsServer.URL + `", "event_types": ["payment.succeeded"], "retry_schedule": [30]}`)
+	assert.Equal(t, []int{60, 300}, erpEndpoint.RetrySchedule)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/event-endpoints", `{"url": "not a url"}`).Code)
+
+	// The secret is only returned on creation
+	w := serve(http.MethodGet, "/event-endpoints/"+erpEndpoint.ID.String(), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotContains(t, w.Body.String(), erpEndpoint.Secret)
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	succeeded, err := events.NewEvent(events.PaymentSucceeded, "order_1", events.PaymentPayload{OrderID: "order_1"})
+	require.NoError(t, err)
+	refunded, err := events.NewEvent(events.RefundCreated, "order_1", events.RefundPayload{OrderID: "order_1"})
+	require.NoError(t, err)
+
+	enqueued, err := repo.EnqueueEventDeliveries(ctx, succeeded)
+	require.NoError(t, err)
+	assert.Equal(t, 2, enqueued)
+	enqueued, err = repo.EnqueueEventDeliveries(ctx, refunded)
+	require.NoError(t, err)
+	assert.Equal(t, 1, enqueued, "the OMS only subscribes to payment.succeeded")
+	enqueued, err = repo.EnqueueEventDeliveries(ctx, succeeded)
+	require.NoError(t, err)
+	assert.Zero(t, enqueued, "an event is enqueued once per endpoint")
+
+	// The ERP fails the first event once; the OMS fails every attempt
+	delivered, err := endpoints.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 2, erp.count())
+	assert.Equal(t, 1, oms.count())
+
+	request := oms.requests[0]
+	assert.Equal(t, succeeded.ID, request.Header.Get("X-Event-Id"))
+	assert.Equal(t, events.PaymentSucceeded, request.Header.Get("X-Event-Type"))
+	assert.True(t, verifyWebhookSignature(omsEndpoint.Secret, request.Header.Get("X-Webhook-Signature"),
+		request.Header.Get("X-Webhook-Timestamp"), string(oms.bodies[0])))
+
+	// Retries follow each endpoint's schedule
+	clock.Advance(30 * time.Second)
+	delivered, err = endpoints.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, delivered)
+	assert.Equal(t, 2, oms.count())
+	clock.Advance(30 * time.Second)
+	delivered, err = endpoints.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 3, erp.count())
+
+	w = serve(http.MethodGet, "/event-endpoints/"+omsEndpoint.ID.String()+"/deliveries?status=failed", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list struct {
+		Deliveries []EventDelivery `json:"deliveries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Deliveries, 1)
+	failed := list.Deliveries[0]
+	assert.Equal(t, 2, failed.Attempts)
+	assert.Equal(t, 500, *failed.LastStatusCode)
+
+	w = serve(http.MethodGet, "/event-deliveries/"+failed.ID.String(), "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var detail EventDelivery
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &detail))
+	assert.Len(t, detail.AttemptLog, 2)
+
+	// A failed delivery gets one more attempt on request
+	w = serve(http.MethodPost, "/event-deliveries/"+failed.ID.String()+"/retry", "")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	delivered, err = endpoints.DeliverDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, delivered)
+	retried, err := repo.GetEventDelivery(ctx, failed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DeliveryFailed, retried.Status)
+	assert.Equal(t, 3, retried.Attempts)
+	assert.Len(t, retried.AttemptLog, 3)
+
+	// Inactive endpoints receive no new events
+	w = serve(http.MethodPatch, "/event-endpoints/"+erpEndpoint.ID.String(), `{"active": false}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	cancelled, err := events.NewEvent(events.PaymentCancelled, "order_1", nil)
+	require.NoError(t, err)
+	enqueued, err = repo.EnqueueEventDeliveries(ctx, cancelled)
+	require.NoError(t, err)
+	assert.Zero(t, enqueued)
+
+	assert.Equal(t, http.StatusNoContent, serve(http.MethodDelete, "/event-endpoints/"+erpEndpoint.ID.String(), "").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/event-endpoints/"+erpEndpoint.ID.String(), "").Code)
+}
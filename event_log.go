@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"payment-getway/events"
+	"payment-getway/eventspb"
+)
+
+// eventLogLockKey serializes appends to the event log, so offsets become visible in
+// order and a consumer resuming after an offset never skips an event committed late
+const eventLogLockKey int64 = 0x6576656e746c6f67 // "eventlog"
+
+// Bounds of the event stream. Idle subscriptions are pinged every eventStreamKeepalive
+// so proxies do not close them.
+const (
+	eventStreamBatch     = 100
+	eventStreamPoll      = time.Second
+	eventStreamKeepalive = 15 * time.Second
+)
+
+// StoredEvent is a published event with its offset in the event log
+type StoredEvent struct {
+	Offset int64 `json:"offset"`
+	events.Event
+}
+
+// AppendEvent records a published event at the end of the event log. An event already
+// recorded is ignored.
+func (r *PaymentRepository) AppendEvent(ctx context.Context, event events.Event) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, eventLogLockKey); err != nil {
+		return err
+	}
+
+	var orderID *string
+	if event.OrderID != "" {
+		orderID = &event.OrderID
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO event_log (event_id, type, order_id, data, occurred_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (event_id) DO NOTHING
+	`, event.ID, event.Type, orderID, []byte(event.Data), event.OccurredAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReadEvents returns up to limit events after an offset, oldest first, optionally only
+// those of the given types
+func (r *PaymentRepository) ReadEvents(ctx context.Context, after int64, types []string, limit int) ([]StoredEvent, error) {
+	query := `
+		SELECT "offset", event_id::text, type, COALESCE(order_id, ''), data, occurred_at
+		FROM event_log
+		WHERE "offset" > $1 AND (cardinality($2::text[]) = 0 OR type = ANY($2))
+		ORDER BY "offset"
+		LIMIT $3
+	`
+
+	if types == nil {
+		types = []string{}
+	}
+	rows, err := r.db.Query(ctx, query, after, types, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := []StoredEvent{}
+	for rows.Next() {
+		var event StoredEvent
+		var data []byte
+		if err := rows.Scan(&event.Offset, &event.ID, &event.Type, &event.OrderID, &data, &event.OccurredAt); err != nil {
+			return nil, err
+		}
+		event.Data = json.RawMessage(data)
+		stored = append(stored, event)
+	}
+
+	return stored, rows.Err()
+}
+
+// subscribeEventLog records every event published on bus in the event log
+func subscribeEventLog(bus events.Subscriber, repo *PaymentRepository) {
+	bus.Subscribe(events.All, func(ctx context.Context, event events.Event) error {
+		return repo.AppendEvent(ctx, event)
+	})
+}
+
+// EventStreamHandler serves the event store to internal consumers
+type EventStreamHandler struct {
+	repo *PaymentRepository
+}
+
+// EventStreamServer streams the event log to internal consumers over gRPC
+type EventStreamServer struct {
+	eventspb.UnimplementedEventStreamServer
+
+	repo *PaymentRepository
+	poll time.Duration // how often to look for new events; eventStreamPoll when zero
+}
+
+// NewEventStreamServer creates a gRPC server offering the event stream to callers that
+// send the admin key in the x-admin-key metadata
+func NewEventStreamServer(repo *PaymentRepository, adminKey string) *grpc.Server {
+	server := grpc.NewServer(
+		grpc.StreamInterceptor(adminKeyStreamInterceptor(adminKey)),
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: eventStreamKeepalive}),
+	)
+	eventspb.RegisterEventStreamServer(server, &EventStreamServer{repo: repo})
+	return server
+}
+
+// adminKeyStreamInterceptor rejects streams without the ADMIN_API_KEY in the
+// x-admin-key metadata, as AdminAuthMiddleware does for the admin API
+func adminKeyStreamInterceptor(adminKey string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		keys := md.Get("x-admin-key")
+		if len(keys) != 1 || subtle.ConstantTimeCompare([]byte(keys[0]), []byte(adminKey)) != 1 {
+			return status.Error(codes.Unauthenticated, "invalid admin key")
+		}
+		return handler(srv, stream)
+	}
+}
+
+// SubscribeEvents sends the events after the requested offset, then follows new ones as
+// they are published, until the consumer cancels. Each event carries its offset, so a
+// consumer resumes after a disconnect by subscribing after the last one it processed.
+func (s *EventStreamServer) SubscribeEvents(req *eventspb.SubscribeEventsRequest, stream grpc.ServerStreamingServer[eventspb.Event]) error {
+	if req.GetAfter() < 0 {
+		return status.Error(codes.InvalidArgument, "after must be a non-negative event offset")
+	}
+	after := req.GetAfter()
+	var types []string
+	for _, eventType := range req.GetTypes() {
+		types = append(types, strings.TrimSpace(eventType))
+	}
+
+	poll := s.poll
+	if poll == 0 {
+		poll = eventStreamPoll
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(stream.Context(), 5*time.Second)
+		batch, err := s.repo.ReadEvents(ctx, after, types, eventStreamBatch)
+		cancel()
+		if err != nil {
+			if stream.Context().Err() != nil {
+				return status.FromContextError(stream.Context().Err()).Err()
+			}
+			log.Printf("Failed to read the event log: %v", err)
+			return status.Error(codes.Unavailable, "failed to read events")
+		}
+
+		for _, event := range batch {
+			if err := stream.Send(eventMessage(event)); err != nil {
+				return err
+			}
+			after = event.Offset
+		}
+
+		// A full batch means more are waiting
+		if len(batch) == eventStreamBatch {
+			continue
+		}
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventMessage converts a stored event to its gRPC message
+func eventMessage(event StoredEvent) *eventspb.Event {
+	return &eventspb.Event{
+		Offset:     event.Offset,
+		Id:         event.ID,
+		Type:       event.Type,
+		OrderId:    event.OrderID,
+		Data:       event.Data,
+		OccurredAt: timestamppb.New(event.OccurredAt),
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"payment-getway/events"
+	"payment-getway/eventspb"
+)
+
+func TestEventLog(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	var published []events.Event
+	for _, eventType := range []string{events.PaymentCreated, events.PaymentSucceeded, events.RefundCreated} {
+		event, err := events.NewEvent(eventType, "order_1", map[string]string{"status": "ok"})
+		require.NoError(t, err)
+		require.NoError(t, repo.AppendEvent(ctx, event))
+		published = append(published, event)
+	}
+	// A redelivered event is recorded once
+	require.NoError(t, repo.AppendEvent(ctx, published[0]))
+
+	stored, err := repo.ReadEvents(ctx, 0, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	for i, event := range stored {
+		assert.Equal(t, published[i].ID, event.ID)
+		assert.Equal(t, published[i].Type, event.Type)
+		assert.JSONEq(t, `{"status":"ok"}`, string(event.Data))
+	}
+
+	after, err := repo.ReadEvents(ctx, stored[0].Offset, nil, 10)
+	require.NoError(t, err)
+	assert.Len(t, after, 2)
+
+	refunds, err := repo.ReadEvents(ctx, 0, []string{events.RefundCreated}, 10)
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, stored[2].Offset, refunds[0].Offset)
+}
+
+// dialEventStream serves the event stream over an in-memory connection and returns a
+// client for it
+func dialEventStream(t *testing.T, server *grpc.Server) eventspb.EventStreamClient {
+	listener := bufconn.Listen(1 << 20)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///event-stream",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return eventspb.NewEventStreamClient(conn)
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	first, err := events.NewEvent(events.PaymentCreated, "order_1", nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.AppendEvent(ctx, first))
+
+	server := grpc.NewServer(grpc.StreamInterceptor(adminKeyStreamInterceptor("admin-key")))
+	eventspb.RegisterEventStreamServer(server, &EventStreamServer{repo: repo, poll: 10 * time.Millisecond})
+	client := dialEventStream(t, server)
+
+	stored, err := repo.ReadEvents(ctx, 0, nil, 1)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+
+	// Resuming after the first event only delivers what was published since
+	ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(ctx, "x-admin-key", "admin-key"), 10*time.Second)
+	defer cancel()
+	stream, err := client.SubscribeEvents(ctx, &eventspb.SubscribeEventsRequest{After: stored[0].Offset})
+	require.NoError(t, err)
+
+	second, err := events.NewEvent(events.PaymentSucceeded, "order_1", map[string]string{"status": "ok"})
+	require.NoError(t, err)
+	require.NoError(t, repo.AppendEvent(context.Background(), second))
+
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Greater(t, event.Offset, stored[0].Offset)
+	assert.Equal(t, second.ID, event.Id)
+	assert.Equal(t, events.PaymentSucceeded, event.Type)
+	assert.Equal(t, "order_1", event.OrderId)
+	assert.JSONEq(t, `{"status":"ok"}`, string(event.Data))
+	assert.WithinDuration(t, second.OccurredAt, event.OccurredAt.AsTime(), time.Millisecond)
+}
+
+func TestSubscribeEventsRejectsBadRequests(t *testing.T) {
+	client := dialEventStream(t, NewEventStreamServer(nil, "admin-key"))
+
+	// Without the admin key
+	stream, err := client.SubscribeEvents(context.Background(), &eventspb.SubscribeEventsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-admin-key", "wrong-key")
+	stream, err = client.SubscribeEvents(ctx, &eventspb.SubscribeEventsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-admin-key", "admin-key")
+	stream, err = client.SubscribeEvents(ctx, &eventspb.SubscribeEventsRequest{After: -1})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published by the payment service
+const (
+	PaymentCreated    = "payment.created"
+	PaymentActivated  = "payment.activated" // a scheduled payment's order was created
+	PaymentSucceeded  = "payment.succeeded"
+	AmountMismatch    = "payment.amount_mismatch" // paid, but not the amount quoted; held back from fulfillment
+	PaymentFailed     = "payment.failed"
+	PaymentCancelled  = "payment.cancelled"
+	PaymentTerminated = "payment.terminated"
+	PaymentReminder   = "payment.reminder"
+	RefundCreated     = "refund.created"
+	RefundUpdated     = "refund.updated"
+	SettlementSplit   = "settlement.split_created"
+	SettlementUpdated = "settlement.updated"
+	DisputeOpened     = "dispute.opened"
+	ReconMismatch     = "recon.mismatch"
+	VendorRecoveryDue = "vendor.recovery_due"
+)
+
+// All subscribes a handler to every event type
+const All = "*"
+
+// Event represents a domain event emitted by the payment service
+type Event struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	OrderID    string          `json:"order_id,omitempty"`
+	TenantID   string          `json:"tenant_id,omitempty"` // the merchant owning the order, in multi-merchant deployments
+	Data       json.RawMessage `json:"data,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// NewEvent creates an event with a fresh ID and the given payload encoded as JSON
+func NewEvent(eventType, orderID string, data interface{}) (Event, error) {
+	event := Event{
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		OrderID:    orderID,
+		OccurredAt: time.Now().UTC(),
+	}
+
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return Event{}, err
+		}
+		event.Data = raw
+	}
+
+	return event, nil
+}
+
+// Decode unmarshals the event payload into v
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Handler processes an event delivered by the bus
+type Handler func(ctx context.Context, event Event) error
+
+// Publisher publishes events to the event bus
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// Subscriber registers handlers for event types
+type Subscriber interface {
+	Subscribe(eventType string, handler Handler)
+}
+
+// Bus is an event bus that components both publish to and subscribe on
+type Bus interface {
+	Publisher
+	Subscriber
+}
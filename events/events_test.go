@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	event, err := NewEvent(PaymentCreated, "order_123", map[string]interface{}{"amount": 100.5})
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, PaymentCreated, event.Type)
+	assert.Equal(t, "order_123", event.OrderID)
+	assert.False(t, event.OccurredAt.IsZero())
+
+	var data map[string]interface{}
+	assert.NoError(t, json.Unmarshal(event.Data, &data))
+	assert.Equal(t, 100.5, data["amount"])
+}
+
+func TestNATSSubjectNaming(t *testing.T) {
+	publisher := &NATSPublisher{prefix: "payments"}
+
+	assert.Equal(t, "payments.payment.succeeded", publisher.Subject(PaymentSucceeded))
+	assert.Equal(t, "payments.refund.created", publisher.Subject(RefundCreated))
+}
+
+type recordingPublisher struct {
+	published []Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event Event) error {
+	p.published = append(p.published, event)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error { return nil }
+
+func TestMemoryBusDeliversToSubscribers(t *testing.T) {
+	external := &recordingPublisher{}
+	bus := NewMemoryBus(external)
+
+	var mu sync.Mutex
+	var typed, all []string
+
+	bus.Subscribe(PaymentSucceeded, func(ctx context.Context, event Event) error {
+		var payload PaymentPayload
+		assert.NoError(t, event.Decode(&payload))
+		mu.Lock()
+		typed = append(typed, payload.OrderID)
+		mu.Unlock()
+		return nil
+	})
+	bus.Subscribe(All, func(ctx context.Context, event Event) error {
+		mu.Lock()
+		all = append(all, event.Type)
+		mu.Unlock()
+		return nil
+	})
+
+	succeeded, _ := NewEvent(PaymentSucceeded, "order_1", PaymentPayload{OrderID: "order_1"})
+	refunded, _ := NewEvent(RefundCreated, "order_1", RefundPayload{OrderID: "order_1"})
+
+	assert.NoError(t, bus.Publish(context.Background(), succeeded))
+	assert.NoError(t, bus.Publish(context.Background(), refunded))
+	bus.Wait()
+
+	assert.Equal(t, []string{"order_1"}, typed)
+	assert.ElementsMatch(t, []string{PaymentSucceeded, RefundCreated}, all)
+	assert.Len(t, external.published, 2)
+}
@@ -0,0 +1,87 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// handlerTimeout bounds how long a single subscriber may take to handle an event
+const handlerTimeout = 30 * time.Second
+
+// MemoryBus delivers events to in-process subscribers and optionally forwards
+// them to an external broker such as NATS.
+//
+// Subscribers run asynchronously so a slow notification sender never delays
+// the request that published the event.
+type MemoryBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	external Publisher
+	wg       sync.WaitGroup
+}
+
+// NewMemoryBus creates an in-memory bus. external may be nil.
+func NewMemoryBus(external Publisher) *MemoryBus {
+	return &MemoryBus{
+		handlers: make(map[string][]Handler),
+		external: external,
+	}
+}
+
+// Subscribe registers a handler for an event type, or for every event with All
+func (b *MemoryBus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish delivers the event to local subscribers and forwards it to the external broker
+func (b *MemoryBus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append([]Handler{}, b.handlers[event.Type]...)
+	handlers = append(handlers, b.handlers[All]...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		b.wg.Add(1)
+		go b.deliver(handler, event)
+	}
+
+	if b.external != nil {
+		return b.external.Publish(ctx, event)
+	}
+
+	return nil
+}
+
+// Wait blocks until all in-flight deliveries have finished
+func (b *MemoryBus) Wait() {
+	b.wg.Wait()
+}
+
+// Close waits for in-flight deliveries and closes the external broker
+func (b *MemoryBus) Close() error {
+	b.wg.Wait()
+	if b.external != nil {
+		return b.external.Close()
+	}
+	return nil
+}
+
+func (b *MemoryBus) deliver(handler Handler, event Event) {
+	defer b.wg.Done()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event handler panicked on %s (%s): %v", event.Type, event.ID, r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handlerTimeout)
+	defer cancel()
+
+	if err := handler(ctx, event); err != nil {
+		log.Printf("Event handler failed on %s (%s): %v", event.Type, event.ID, err)
+	}
+}
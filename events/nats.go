@@ -0,0 +1,116 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSConfig holds the settings for the NATS JetStream publisher
+type NATSConfig struct {
+	URL           string
+	Stream        string
+	SubjectPrefix string
+	MaxRetries    int
+}
+
+// NATSPublisher publishes events to a NATS JetStream stream.
+//
+// Every event is published to "<prefix>.<event type>" and waits for the
+// stream acknowledgement, retrying on failure. The event ID is sent as the
+// JetStream message ID so retried publishes are de-duplicated by the server,
+// giving at-least-once delivery to consumers.
+type NATSPublisher struct {
+	conn       *nats.Conn
+	js         jetstream.JetStream
+	prefix     string
+	maxRetries int
+}
+
+// NewNATSPublisher connects to NATS and makes sure the stream exists
+func NewNATSPublisher(cfg NATSConfig) (*NATSPublisher, error) {
+	if cfg.Stream == "" {
+		cfg.Stream = "PAYMENTS"
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = "payments"
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 3
+	}
+
+	conn, err := nats.Connect(cfg.URL, nats.Name("cashfree-payment-gateway"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       cfg.Stream,
+		Subjects:   []string{cfg.SubjectPrefix + ".>"},
+		Storage:    jetstream.FileStorage,
+		Duplicates: 2 * time.Minute,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create stream %s: %v", cfg.Stream, err)
+	}
+
+	return &NATSPublisher{
+		conn:       conn,
+		js:         js,
+		prefix:     cfg.SubjectPrefix,
+		maxRetries: cfg.MaxRetries,
+	}, nil
+}
+
+// Subject returns the subject an event type is published on
+func (p *NATSPublisher) Subject(eventType string) string {
+	return p.prefix + "." + strings.ToLower(eventType)
+}
+
+// Publish publishes the event and waits for the JetStream acknowledgement
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	subject := p.Subject(event.Type)
+
+	var lastErr error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+			}
+		}
+
+		_, lastErr = p.js.Publish(ctx, subject, data, jetstream.WithMsgID(event.ID))
+		if lastErr == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to publish event %s to %s: %v", event.ID, subject, lastErr)
+}
+
+// Close drains and closes the NATS connection
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
@@ -0,0 +1,92 @@
+package events
+
+import "time"
+
+// PaymentPayload is the payload of payment.* events
+type PaymentPayload struct {
+	OrderID       string     `json:"order_id"`
+	CFOrderID     string     `json:"cf_order_id"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	Status        string     `json:"status"`
+	CustomerID    string     `json:"customer_id"`
+	CustomerName  string     `json:"customer_name"`
+	CustomerEmail string     `json:"customer_email"`
+	CustomerPhone string     `json:"customer_phone"`
+	CFPaymentID   string     `json:"cf_payment_id,omitempty"`
+	PaymentMethod string     `json:"payment_method,omitempty"`
+	PaymentURL    string     `json:"payment_url,omitempty"`
+	PaymentTime   *time.Time `json:"payment_time,omitempty"`
+}
+
+// PaymentReminderPayload is the payload of payment.reminder events, published for an
+// order the customer left unpaid
+type PaymentReminderPayload struct {
+	PaymentPayload
+	Attempt int `json:"attempt"` // 1 for the first reminder of the order
+}
+
+// RefundPayload is the payload of refund.* events
+type RefundPayload struct {
+	RefundID      string     `json:"refund_id"`
+	CFRefundID    string     `json:"cf_refund_id"`
+	OrderID       string     `json:"order_id"`
+	Amount        float64    `json:"amount"`
+	Currency      string     `json:"currency"`
+	Status        string     `json:"status"`
+	Reason        string     `json:"reason,omitempty"`
+	CustomerName  string     `json:"customer_name"`
+	CustomerEmail string     `json:"customer_email"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+}
+
+// SettlementPayload is the payload of settlement.* events
+type SettlementPayload struct {
+	SettlementID   string  `json:"settlement_id"`
+	CFSettlementID string  `json:"cf_settlement_id,omitempty"`
+	OrderID        string  `json:"order_id"`
+	Amount         float64 `json:"amount"`
+	Status         string  `json:"status"`
+	UTR            string  `json:"utr,omitempty"`
+}
+
+// VendorRecoveryPayload is the payload of vendor.recovery_due events, published for
+// each vendor share reversed when a split order is fully refunded so it can be
+// recovered from the vendor's next payout
+type VendorRecoveryPayload struct {
+	VendorID string  `json:"vendor_id"`
+	OrderID  string  `json:"order_id"`
+	RefundID string  `json:"refund_id"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// DisputePayload is the payload of dispute.* events
+type DisputePayload struct {
+	DisputeID   string  `json:"dispute_id"`
+	OrderID     string  `json:"order_id"`
+	Amount      float64 `json:"amount"`
+	Status      string  `json:"status"`
+	DisputeType string  `json:"dispute_type,omitempty"`
+	ReasonCode  string  `json:"reason_code,omitempty"`
+}
+
+// AmountMismatchPayload is the payload of payment.amount_mismatch events
+type AmountMismatchPayload struct {
+	OrderID      string  `json:"order_id"`
+	CFPaymentID  string  `json:"cf_payment_id,omitempty"`
+	Currency     string  `json:"currency"`
+	QuotedAmount float64 `json:"quoted_amount"`
+	PaidAmount   float64 `json:"paid_amount"`
+}
+
+// ReconMismatchPayload is the payload of recon.mismatch events
+type ReconMismatchPayload struct {
+	RunID        string  `json:"run_id"`
+	OrderID      string  `json:"order_id"`
+	Kind         string  `json:"kind"`
+	LocalStatus  string  `json:"local_status,omitempty"`
+	RemoteStatus string  `json:"remote_status,omitempty"`
+	LocalAmount  float64 `json:"local_amount,omitempty"`
+	RemoteAmount float64 `json:"remote_amount,omitempty"`
+}
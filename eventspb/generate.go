@@ -0,0 +1,4 @@
+// Package eventspb holds the gRPC API internal consumers follow the event log with.
+package eventspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative events.proto
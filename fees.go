@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Platform fee types
+const (
+	FeeTypeCommission = "COMMISSION"
+	FeeTypeFixed      = "FIXED"
+)
+
+// FeeSlab is the commission rate on payments by a method up to an amount
+type FeeSlab struct {
+	Method  string  // lower case payment method, e.g. "upi"
+	UpTo    float64 // largest payment amount the slab covers; 0 for no limit
+	Percent float64
+}
+
+// FeeModel is the platform's cut of each vendor split. The fees are deducted from the
+// vendor's share when the split is created, so Cashfree settles the vendor its share
+// less the fees and the platform keeps them.
+type FeeModel struct {
+	CommissionPercent float64   // of each split, unless a slab for the payment's method applies
+	FixedFee          float64   // per split, in the order's currency
+	Slabs             []FeeSlab // by method, smallest UpTo first and unlimited last
+}
+
+// SplitFee is a platform fee deducted from a vendor's split of an order
+type SplitFee struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	SplitID   uuid.UUID `json:"split_id" db:"split_id"`
+	OrderID   string    `json:"order_id" db:"order_id"`
+	VendorID  string    `json:"vendor_id" db:"vendor_id"`
+	FeeType   string    `json:"fee_type" db:"fee_type"`
+	Rate      *float64  `json:"rate,omitempty" db:"rate"` // percent, for commissions
+	Amount    float64   `json:"amount" db:"amount"`
+	Currency  string    `json:"currency" db:"currency"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// SplitFeeError is returned when a vendor's share does not cover its fees
+type SplitFeeError struct {
+	VendorID string
+	Share    float64
+	Fees     float64
+}
+
+func (e *SplitFeeError) Error() string {
+	return fmt.Sprintf("split of %.2f for vendor %s does not cover its fees of %.2f", e.Share, e.VendorID, e.Fees)
+}
+
+// Enabled reports whether the model charges any fee
+func (m FeeModel) Enabled() bool {
+	return m.CommissionPercent > 0 || m.FixedFee > 0 || len(m.Slabs) > 0
+}
+
+// commissionPercent returns the commission rate on a payment: that of the first slab
+// for its method that covers its amount, or the model's commission
+func (m FeeModel) commissionPercent(payment *Payment) float64 {
+	method := ""
+	if payment.PaymentMethod != nil {
+		method = strings.ToLower(*payment.PaymentMethod)
+	}
+	for _, slab := range m.Slabs {
+		if slab.Method == method && (slab.UpTo == 0 || payment.Amount <= slab.UpTo) {
+			return slab.Percent
+		}
+	}
+	return m.CommissionPercent
+}
+
+// Fees returns the fee line items on a vendor's share of a payment, rounded to the
+// currency's smallest unit, and the share left for the vendor. It returns a
+// *SplitFeeError when the fees leave the vendor nothing.
+func (m FeeModel) Fees(payment *Payment, vendorID string, share float64) ([]SplitFee, float64, error) {
+	exponent := math.Pow10(currencyExponent(payment.Currency))
+	round := func(amount float64) float64 {
+		return math.Round(amount*exponent) / exponent
+	}
+
+	var fees []SplitFee
+	total := 0.0
+	if percent := m.commissionPercent(payment); percent > 0 {
+		rate := percent
+		amount := round(share * percent / 100)
+		fees = append(fees, SplitFee{FeeType: FeeTypeCommission, Rate: &rate, Amount: amount})
+		total += amount
+	}
+	if m.FixedFee > 0 {
+		amount := round(m.FixedFee)
+		fees = append(fees, SplitFee{FeeType: FeeTypeFixed, Amount: amount})
+		total += amount
+	}
+
+	net := round(share - total)
+	if net <= 0 {
+		return nil, 0, &SplitFeeError{VendorID: vendorID, Share: share, Fees: total}
+	}
+	for i := range fees {
+		fees[i].OrderID = payment.OrderID
+		fees[i].VendorID = vendorID
+		fees[i].Currency = payment.Currency
+	}
+	return fees, net, nil
+}
+
+// feeTypeName returns how a fee type reads in narrations
+func feeTypeName(feeType string) string {
+	if feeType == FeeTypeFixed {
+		return "Fixed"
+	}
+	return "Commission"
+}
+
+// parseFeeSlabs reads slabs written as method:up_to=percent, with * as the up_to of a
+// method's unlimited slab, e.g. "upi:2000=0.5,upi:*=1,card:*=2"
+func parseFeeSlabs(entries []string) ([]FeeSlab, error) {
+	var slabs []FeeSlab
+	for _, entry := range entries {
+		bound, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		method, upTo, ok2 := strings.Cut(bound, ":")
+		percent, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || !ok2 || strings.TrimSpace(method) == "" || err != nil || percent < 0 || percent >= 100 {
+			return nil, fmt.Errorf("entries must be method:up_to=percent, got %q", entry)
+		}
+
+		slab := FeeSlab{Method: strings.ToLower(strings.TrimSpace(method)), Percent: percent}
+		if upTo = strings.TrimSpace(upTo); upTo != "*" {
+			slab.UpTo, err = strconv.ParseFloat(upTo, 64)
+			if err != nil || slab.UpTo <= 0 {
+				return nil, fmt.Errorf("up_to must be a positive amount or *, got %q", entry)
+			}
+		}
+		slabs = append(slabs, slab)
+	}
+
+	sort.SliceStable(slabs, func(i, j int) bool {
+		if slabs[i].Method != slabs[j].Method {
+			return slabs[i].Method < slabs[j].Method
+		}
+		if slabs[i].UpTo == 0 || slabs[j].UpTo == 0 {
+			return slabs[j].UpTo == 0 && slabs[i].UpTo != 0
+		}
+		return slabs[i].UpTo < slabs[j].UpTo
+	})
+	return slabs, nil
+}
+
+// insertSplitFees records the fees of a split in tx
+func insertSplitFees(ctx context.Context, tx pgx.Tx, split *SplitSettlement) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 3, 10)
+	query := `
+		INSERT INTO split_fees (
+			id, split_id, order_id, vendor_id, fee_type, rate, amount, currency, created_at, tenant_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, ` + orderTenant + `)
+	`
+
+	for i := range split.Fees {
+		fee := &split.Fees[i]
+		fee.ID = uuid.New()
+		fee.SplitID = split.ID
+		fee.CreatedAt = split.CreatedAt
+
+		args := []interface{}{
+			fee.ID, fee.SplitID, split.OrderID, split.VendorID, fee.FeeType,
+			fee.Rate, fee.Amount, fee.Currency, fee.CreatedAt,
+		}
+		_, err := tx.Exec(ctx, query, append(args, tenantArgs...)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSplitFeesBetween returns the fees of splits created in [from, to)
+func (r *PaymentRepository) ListSplitFeesBetween(ctx context.Context, from, to time.Time) ([]SplitFee, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT id, split_id, order_id, vendor_id, fee_type, rate, amount, currency, created_at
+		FROM split_fees
+		WHERE created_at >= $1 AND created_at < $2` + tenant + `
+		ORDER BY created_at, order_id, vendor_id, fee_type
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fees []SplitFee
+	for rows.Next() {
+		var fee SplitFee
+		err := rows.Scan(
+			&fee.ID, &fee.SplitID, &fee.OrderID, &fee.VendorID, &fee.FeeType,
+			&fee.Rate, &fee.Amount, &fee.Currency, &fee.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fees = append(fees, fee)
+	}
+
+	return fees, rows.Err()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeeModelFees(t *testing.T) {
+	model := FeeModel{
+		CommissionPercent: 5,
+		FixedFee:          2,
+		Slabs: []FeeSlab{
+			{Method: "upi", UpTo: 2000, Percent: 0.5},
+			{Method: "upi", Percent: 1},
+		},
+	}
+	payment := testPayment("order_1")
+	payment.Amount = 1000
+
+	fees, net, err := model.Fees(payment, "vendor_a", 333.33)
+	require.NoError(t, err)
+	rate := 5.0
+	assert.Equal(t, []SplitFee{
+		{OrderID: "order_1", VendorID: "vendor_a", FeeType: FeeTypeCommission, Rate: &rate, Amount: 16.67, Currency: "INR"},
+		{OrderID: "order_1", VendorID: "vendor_a", FeeType: FeeTypeFixed, Amount: 2, Currency: "INR"},
+	}, fees)
+	assert.Equal(t, 314.66, net)
+
+	// Slabs for the payment's method replace the commission
+	method := "UPI"
+	payment.PaymentMethod = &method
+	fees, _, err = model.Fees(payment, "vendor_a", 200)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, *fees[0].Rate)
+	assert.Equal(t, 1.0, fees[0].Amount)
+	payment.Amount = 5000
+	fees, _, err = model.Fees(payment, "vendor_a", 200)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, fees[0].Amount)
+
+	// A share that does not cover its fees is refused
+	_, _, err = model.Fees(payment, "vendor_a", 2)
+	var feeErr *SplitFeeError
+	require.ErrorAs(t, err, &feeErr)
+	assert.Equal(t, SplitFeeError{VendorID: "vendor_a", Share: 2, Fees: 2.02}, *feeErr)
+
+	assert.False(t, FeeModel{}.Enabled())
+}
+
+func TestParseFeeSlabs(t *testing.T) {
+	slabs, err := parseFeeSlabs([]string{"upi:*=1", "upi:5000=0.75", "upi:2000=0.5"})
+	require.NoError(t, err)
+	assert.Equal(t, []FeeSlab{
+		{Method: "upi", UpTo: 2000, Percent: 0.5},
+		{Method: "upi", UpTo: 5000, Percent: 0.75},
+		{Method: "upi", Percent: 1},
+	}, slabs)
+
+	for _, entry := range []string{"upi", "upi:*", ":*=1", "upi:*=100", "upi:-5=1", "upi:*=x"} {
+		_, err := parseFeeSlabs([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestSplitSettlementFees(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		fees:         FeeModel{CommissionPercent: 10, FixedFee: 5},
+	}
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	_, err = gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+	payment := testPayment("order_1")
+	payment.Amount = 1000
+	payment.Status = "PAID"
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/order_1/split", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"splits": [{"vendor_id": "vendor_a", "amount": 5}]}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": "Split does not cover its platform fees", "vendor_id": "vendor_a", "fees": 5.5}`, w.Body.String())
+
+	w = post(`{"splits": [{"vendor_id": "vendor_a", "amount": 600}, {"vendor_id": "vendor_b", "percentage": 30}]}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Fees []SplitFee `json:"fees"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Fees, 4)
+	assert.Equal(t, 60.0, resp.Fees[0].Amount)
+	assert.Equal(t, 30.0, resp.Fees[2].Amount)
+
+	// Cashfree settles the vendors their shares less the fees
+	order, ok := gateway.Order("order_1")
+	require.True(t, ok)
+	require.Len(t, order.Settlements, 1)
+	splits := order.Settlements[0].Splits
+	assert.Equal(t, 535.0, *splits[0].Amount)
+	assert.Equal(t, 265.0, *splits[1].Amount)
+	assert.Nil(t, splits[1].Percentage)
+
+	stored, err := repo.ListSplitSettlements(ctx, "order_1")
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, 535.0, stored[0].Amount)
+
+	day := time.Now().Add(-time.Hour)
+	fees, err := repo.ListSplitFeesBetween(ctx, day, day.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, fees, 4)
+}
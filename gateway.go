@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Supported payment gateways
+const (
+	GatewayCashfree = "cashfree"
+	GatewayRazorpay = "razorpay"
+)
+
+// ErrNotSent wraps the error of a gateway call that failed before its request was
+// sent, so the gateway cannot have acted on it
+var ErrNotSent = errors.New("request was not sent to the gateway")
+
+// gatewayRejected reports whether a failed gateway call certainly left nothing done at
+// the gateway: the request was refused before it was sent, or the gateway answered
+// with a client error. A conflict is not a rejection, since the gateway already has
+// what was asked for. Timeouts, dropped connections and server errors are uncertain,
+// as the gateway may have acted before the call failed.
+func gatewayRejected(err error) bool {
+	var cfErr *CashfreeError
+	if errors.As(err, &cfErr) {
+		return cfErr.StatusCode >= http.StatusBadRequest && cfErr.StatusCode < http.StatusInternalServerError &&
+			!errors.Is(err, ErrDuplicateRequest)
+	}
+	return errors.Is(err, ErrInvalidRequest) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNotSent)
+}
+
+// PaymentGateway is the set of order operations every gateway supports.
+// Requests and responses use the Cashfree shapes, which other gateways translate to.
+type PaymentGateway interface {
+	Name() string
+	CreateOrder(req CreateOrderRequest) (*CashfreeOrderResponse, error)
+	GetOrderStatus(orderID string) (*CashfreeOrderStatusResponse, error)
+	GetPayments(orderID string) (*CashfreePaymentResponse, error)
+	RefundPayment(req CashfreeRefundRequest) (*CashfreeRefundResponse, error)
+	CancelOrder(orderID string) error
+}
+
+// OrderTagUpdater is implemented by gateways that can change an order's tags after it
+// is created. Cashfree takes order_tags only when an order is created.
+type OrderTagUpdater interface {
+	UpdateOrderTags(orderID string, tags map[string]string) error
+}
+
+// GatewayRouter picks the gateway for new and existing orders
+type GatewayRouter struct {
+	gateways     map[string]PaymentGateway
+	primary      string
+	fallback     string
+	autoFailover atomic.Bool
+	breaker      *CircuitBreaker
+}
+
+// NewGatewayRouter creates a router with Cashfree as the primary gateway. If fallback
+// is set and autoFailover is enabled, new orders go to the fallback while the Cashfree
+// circuit breaker is open.
+func NewGatewayRouter(cashfree *CashfreeClient, fallback PaymentGateway, autoFailover bool) *GatewayRouter {
+	router := &GatewayRouter{
+		gateways: map[string]PaymentGateway{GatewayCashfree: cashfree},
+		primary:  GatewayCashfree,
+		breaker:  cashfree.Breaker,
+	}
+	router.autoFailover.Store(autoFailover)
+
+	if fallback != nil {
+		router.gateways[fallback.Name()] = fallback
+		router.fallback = fallback.Name()
+	}
+
+	return router
+}
+
+// SetAutoFailover enables or disables automatic failover for new orders
+func (r *GatewayRouter) SetAutoFailover(enabled bool) {
+	r.autoFailover.Store(enabled)
+}
+
+// Get returns a gateway by name, defaulting to the primary gateway
+func (r *GatewayRouter) Get(name string) (PaymentGateway, error) {
+	if name == "" {
+		name = r.primary
+	}
+
+	gateway, ok := r.gateways[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("payment gateway %q is not configured", name)
+	}
+	return gateway, nil
+}
+
+// ForNewOrder returns the gateway for a new order, honouring an explicit request
+// and failing over from the primary gateway when its circuit breaker is open
+func (r *GatewayRouter) ForNewOrder(requested string) (PaymentGateway, error) {
+	if requested != "" {
+		return r.Get(requested)
+	}
+
+	if r.autoFailover.Load() && r.fallback != "" && r.breaker != nil && r.breaker.IsOpen() {
+		return r.gateways[r.fallback], nil
+	}
+
+	return r.gateways[r.primary], nil
+}
+
+// environmentGinKey is the gin context key the requested Cashfree environment is stored under
+const environmentGinKey = "cashfree_environment"
+
+// Cashfree environments
+const (
+	EnvironmentTest = "TEST"
+	EnvironmentProd = "PROD"
+)
+
+type environmentContextKey struct{}
+
+// WithCashfreeEnvironment returns a context carrying the Cashfree environment a request
+// is made for
+func WithCashfreeEnvironment(ctx context.Context, env string) context.Context {
+	return context.WithValue(ctx, environmentContextKey{}, env)
+}
+
+// CashfreeEnvironmentFromContext returns the requested Cashfree environment, or "" for
+// the default environment
+func CashfreeEnvironmentFromContext(ctx context.Context) string {
+	env, _ := ctx.Value(environmentContextKey{}).(string)
+	return env
+}
+
+// CashfreeEnvironmentMiddleware reads the Cashfree environment a request is made for
+// from the X-Cashfree-Environment header
+func CashfreeEnvironmentMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		env := strings.ToUpper(c.GetHeader("X-Cashfree-Environment"))
+		switch env {
+		case "":
+		case EnvironmentTest, EnvironmentProd:
+			c.Set(environmentGinKey, env)
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "X-Cashfree-Environment must be TEST or PROD"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// cashfreeFor returns the Cashfree client for the request's merchant and environment.
+// Merchant accounts are bound to the environment of their credentials.
+func (h *PaymentHandler) cashfreeFor(ctx context.Context) (*CashfreeClient, error) {
+	env := CashfreeEnvironmentFromContext(ctx)
+
+	if merchant := MerchantFromContext(ctx); merchant != nil && h.clients != nil {
+		if env != "" && env != strings.ToUpper(merchant.Environment) {
+			return nil, fmt.Errorf("merchant is configured for the %s environment", strings.ToUpper(merchant.Environment))
+		}
+		return h.clients.Get(merchant), nil
+	}
+
+	return h.cashfreeForEnvironment(env)
+}
+
+// cashfreeForEnvironment returns the deployment's Cashfree client for an environment,
+// or the default client for ""
+func (h *PaymentHandler) cashfreeForEnvironment(env string) (*CashfreeClient, error) {
+	if env == "" || env == strings.ToUpper(h.cashfree.Environment) {
+		return h.cashfree, nil
+	}
+	if client, ok := h.environments[env]; ok {
+		return client, nil
+	}
+	return nil, fmt.Errorf("Cashfree %s environment is not configured", env)
+}
+
+// gatewayForNewOrder picks the gateway for a new order. Merchant accounts always use
+// their own Cashfree credentials; the Razorpay fallback belongs to the deployment.
+func (h *PaymentHandler) gatewayForNewOrder(ctx context.Context, requested string) (PaymentGateway, error) {
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		if requested != "" && requested != GatewayCashfree {
+			return nil, fmt.Errorf("payment gateway %q is not available for merchant accounts", requested)
+		}
+		client, err := h.cashfreeFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	// The fallback gateway only serves the default environment
+	if env := CashfreeEnvironmentFromContext(ctx); env != "" && env != strings.ToUpper(h.cashfree.Environment) {
+		if requested != "" && requested != GatewayCashfree {
+			return nil, fmt.Errorf("payment gateway %q is not available in the %s environment", requested, env)
+		}
+		client, err := h.cashfreeFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+
+	if h.gateways == nil {
+		return h.cashfree, nil
+	}
+	return h.gateways.ForNewOrder(requested)
+}
+
+// gatewayFor returns the gateway a payment was created with, using the owning
+// merchant's credentials for Cashfree payments
+func (h *PaymentHandler) gatewayFor(ctx context.Context, payment *Payment) (PaymentGateway, error) {
+	if payment.Gateway == "" || payment.Gateway == GatewayCashfree {
+		return h.cashfreeForPayment(ctx, payment)
+	}
+
+	if h.gateways == nil {
+		return nil, fmt.Errorf("payment gateway %q is not configured", payment.Gateway)
+	}
+	return h.gateways.Get(payment.Gateway)
+}
+
+// cashfreeForPayment returns the Cashfree client of the merchant that owns a payment,
+// or the deployment's client for the environment the payment was created in
+func (h *PaymentHandler) cashfreeForPayment(ctx context.Context, payment *Payment) (*CashfreeClient, error) {
+	if payment.TenantID != nil && h.clients != nil {
+		return h.clients.ForTenant(ctx, *payment.TenantID)
+	}
+	if payment.Environment == nil {
+		return h.cashfree, nil
+	}
+	return h.cashfreeForEnvironment(*payment.Environment)
+}
+
+// gatewayForOrder looks up the payment and returns the gateway it was created with.
+// Orders unknown to the database are looked up with the request's Cashfree client.
+func (h *PaymentHandler) gatewayForOrder(ctx context.Context, orderID string) (PaymentGateway, error) {
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		client, err := h.cashfreeFor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	return h.gatewayFor(ctx, payment)
+}
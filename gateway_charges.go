@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CashfreePaymentCharges are the charges Cashfree deducts from a payment before
+// settling it, as reported with the payment
+type CashfreePaymentCharges struct {
+	ServiceCharge    float64 `json:"service_charge"`
+	ServiceTax       float64 `json:"service_tax"`
+	SettlementAmount float64 `json:"settlement_amount,omitempty"`
+}
+
+// net returns the amount Cashfree settles for a payment of gross: the settlement amount
+// it reported, or gross less the charges
+func (c *CashfreePaymentCharges) net(gross float64) float64 {
+	if c.SettlementAmount > 0 {
+		return c.SettlementAmount
+	}
+	return math.Round((gross-c.ServiceCharge-c.ServiceTax)*1000) / 1000
+}
+
+// GatewayCharges are the recorded gross amount and gateway charges of a payment
+type GatewayCharges struct {
+	Gross float64
+	Fee   float64
+	Tax   float64
+	Net   float64
+}
+
+// GatewayChargesSummary totals the gateway charges on the successful payments in one
+// currency by one method
+type GatewayChargesSummary struct {
+	Currency      string  `json:"currency"`
+	PaymentMethod string  `json:"payment_method"`
+	Payments      int     `json:"payments"`
+	Gross         float64 `json:"gross"`
+	GatewayFee    float64 `json:"gateway_fee"`
+	GatewayTax    float64 `json:"gateway_tax"`
+	Net           float64 `json:"net"`
+	EffectiveRate float64 `json:"effective_rate"` // fee and tax as a percentage of gross
+}
+
+// SetPaymentCharges records the gateway fee, the tax on it and the net amount of a
+// payment
+func (r *PaymentRepository) SetPaymentCharges(ctx context.Context, orderID string, fee, tax, net float64) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 6)
+	query := `
+		UPDATE payments
+		SET gateway_fee = $1, gateway_tax = $2, net_amount = $3, updated_at = $4
+		WHERE order_id = $5` + tenant
+
+	args := append([]interface{}{fee, tax, net, r.now(), orderID}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// GatewayChargesByOrder returns the recorded charges of the given orders. Orders whose
+// charges are not known are left out.
+func (r *PaymentRepository) GatewayChargesByOrder(ctx context.Context, orderIDs []string) (map[string]GatewayCharges, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT order_id, amount, gateway_fee, COALESCE(gateway_tax, 0), net_amount
+		FROM payments
+		WHERE order_id = ANY($1) AND gateway_fee IS NOT NULL AND net_amount IS NOT NULL` + tenant
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderIDs}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	charges := make(map[string]GatewayCharges)
+	for rows.Next() {
+		var orderID string
+		var c GatewayCharges
+		if err := rows.Scan(&orderID, &c.Gross, &c.Fee, &c.Tax, &c.Net); err != nil {
+			return nil, err
+		}
+		charges[orderID] = c
+	}
+
+	return charges, rows.Err()
+}
+
+// SummarizeGatewayCharges totals the charges on successful payments made in [from, to)
+// by currency and payment method. Payments whose charges are not known are left out.
+func (r *PaymentRepository) SummarizeGatewayCharges(ctx context.Context, from, to time.Time) ([]GatewayChargesSummary, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT currency, COALESCE(payment_method, ''), COUNT(*), SUM(amount),
+			   SUM(gateway_fee), SUM(COALESCE(gateway_tax, 0)), SUM(net_amount)
+		FROM payments
+		WHERE status = 'SUCCESS' AND gateway_fee IS NOT NULL AND net_amount IS NOT NULL
+		  AND payment_time >= $1 AND payment_time < $2` + tenant + `
+		GROUP BY currency, COALESCE(payment_method, '')
+		ORDER BY currency, COALESCE(payment_method, '')
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := []GatewayChargesSummary{}
+	for rows.Next() {
+		var s GatewayChargesSummary
+		err := rows.Scan(&s.Currency, &s.PaymentMethod, &s.Payments, &s.Gross, &s.GatewayFee, &s.GatewayTax, &s.Net)
+		if err != nil {
+			return nil, err
+		}
+		if s.Gross > 0 {
+			s.EffectiveRate = math.Round((s.GatewayFee+s.GatewayTax)/s.Gross*100*10000) / 10000
+		}
+		summaries = append(summaries, s)
+	}
+
+	return summaries, rows.Err()
+}
+
+// recordPaymentCharges saves the charges the gateway reported on an order's payment of
+// gross. Failures are logged, as the payment itself has been recorded.
+func (h *PaymentHandler) recordPaymentCharges(ctx context.Context, orderID string, gross float64, charges *CashfreePaymentCharges) {
+	if charges == nil {
+		return
+	}
+	err := h.repo.SetPaymentCharges(ctx, orderID, charges.ServiceCharge, charges.ServiceTax, charges.net(gross))
+	if err != nil {
+		log.Printf("Failed to record gateway charges for %s: %v", orderID, err)
+	}
+}
+
+// Gets the gateway charges on successful payments in a date range by currency and method
+func (h *ExportHandler) GetGatewayCharges(c *gin.Context) {
+	from, to, err := parseDateRange(c, h.locationFor(requestContext(c)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	summaries, err := h.repo.SummarizeGatewayCharges(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to summarize gateway charges: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get gateway charges"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "charges": summaries})
+}
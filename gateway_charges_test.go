@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCashfreePaymentChargesNet(t *testing.T) {
+	charges := &CashfreePaymentCharges{ServiceCharge: 9.5, ServiceTax: 1.71, SettlementAmount: 488.8}
+	assert.Equal(t, 488.8, charges.net(500))
+
+	// Without a settlement amount the charges come off the gross
+	charges.SettlementAmount = 0
+	assert.Equal(t, 488.79, charges.net(500))
+}
+
+func TestGetPaymentsCharges(t *testing.T) {
+	gateway, client := newFakeCashfree(t)
+	gateway.ChargePercent = 2
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	_, err = gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+
+	payment, err := client.GetPayments("order_1")
+	require.NoError(t, err)
+	require.NotNil(t, payment.PaymentCharges)
+	assert.Equal(t, 9.99, payment.PaymentCharges.ServiceCharge)
+	assert.Equal(t, 1.8, payment.PaymentCharges.ServiceTax)
+	assert.Equal(t, 487.71, payment.PaymentCharges.SettlementAmount)
+}
+
+func TestGatewayChargesAPI(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	gateway.ChargePercent = 2
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo, location: time.UTC}, nil)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	payment := testPayment("order_1")
+	payment.Amount = 499.5
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+
+	webhook, err := gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(webhook.Request("/webhook/cashfree")).Code)
+
+	stored, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.GatewayFee)
+	assert.Equal(t, 9.99, *stored.GatewayFee)
+	assert.Equal(t, 1.8, *stored.GatewayTax)
+	assert.Equal(t, 487.71, *stored.NetAmount)
+
+	day := time.Now().UTC().Format("2006-01-02")
+	w := serve(httptest.NewRequest(http.MethodGet, "/analytics/gateway-charges?from="+day+"&to="+day, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Charges []GatewayChargesSummary `json:"charges"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, []GatewayChargesSummary{{
+		Currency:      "INR",
+		PaymentMethod: "upi",
+		Payments:      1,
+		Gross:         499.5,
+		GatewayFee:    9.99,
+		GatewayTax:    1.8,
+		Net:           487.71,
+		EffectiveRate: 2.3604,
+	}}, resp.Charges)
+
+	assert.Equal(t, http.StatusBadRequest, serve(httptest.NewRequest(http.MethodGet, "/analytics/gateway-charges?from=April", nil)).Code)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, 50*time.Millisecond)
+	assert.True(t, breaker.Allow())
+
+	breaker.RecordFailure()
+	assert.Equal(t, CircuitClosed, breaker.State())
+
+	breaker.RecordFailure()
+	assert.True(t, breaker.IsOpen())
+	assert.False(t, breaker.Allow())
+
+	// After the cooldown a single trial call is let through
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	assert.False(t, breaker.Allow())
+
+	breaker.RecordFailure()
+	assert.True(t, breaker.IsOpen())
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, breaker.Allow())
+	breaker.RecordSuccess()
+	assert.Equal(t, CircuitClosed, breaker.State())
+}
+
+func TestGatewayRouterFailover(t *testing.T) {
+	cashfree := NewCashfreeClient("id", "secret", "TEST")
+	razorpay := NewRazorpayClient("key", "secret")
+	router := NewGatewayRouter(cashfree, razorpay, true)
+
+	gateway, err := router.ForNewOrder("")
+	require.NoError(t, err)
+	assert.Equal(t, GatewayCashfree, gateway.Name())
+
+	for i := 0; i < 5; i++ {
+		cashfree.Breaker.RecordFailure()
+	}
+
+	gateway, err = router.ForNewOrder("")
+	require.NoError(t, err)
+	assert.Equal(t, GatewayRazorpay, gateway.Name())
+
+	// An explicit choice is always honoured
+	gateway, err = router.ForNewOrder(GatewayCashfree)
+	require.NoError(t, err)
+	assert.Equal(t, GatewayCashfree, gateway.Name())
+
+	// Existing orders without a gateway belong to Cashfree
+	gateway, err = router.Get("")
+	require.NoError(t, err)
+	assert.Equal(t, GatewayCashfree, gateway.Name())
+}
+
+func TestGatewayRouterWithoutFallback(t *testing.T) {
+	cashfree := NewCashfreeClient("id", "secret", "TEST")
+	router := NewGatewayRouter(cashfree, nil, true)
+
+	for i := 0; i < 5; i++ {
+		cashfree.Breaker.RecordFailure()
+	}
+
+	gateway, err := router.ForNewOrder("")
+	require.NoError(t, err)
+	assert.Equal(t, GatewayCashfree, gateway.Name())
+
+	_, err = router.ForNewOrder(GatewayRazorpay)
+	assert.Error(t, err)
+}
+
+func TestGatewayRejected(t *testing.T) {
+	cashfreeErr := func(status int, code string) error {
+		err := &CashfreeError{StatusCode: status, Code: code, Message: "message"}
+		err.kind = err.classify()
+		return fmt.Errorf("failed to create refund: %w", err)
+	}
+
+	tests := []struct {
+		err      error
+		rejected bool
+	}{
+		{cashfreeErr(400, "refund_request_invalid"), true},
+		{cashfreeErr(404, "order_not_found"), true},
+		{cashfreeErr(429, ""), true},
+		{cashfreeErr(409, "refund_already_exists"), false},
+		{cashfreeErr(500, ""), false},
+		{cashfreeErr(503, ""), false},
+		{&ValidationError{Operation: CashfreeOpCreateRefund}, true},
+		{fmt.Errorf("failed to create refund: %w", ErrCircuitOpen), true},
+		{fmt.Errorf("%w: no payments found for order order_1", ErrNotSent), true},
+		{fmt.Errorf("failed to create refund: %w", context.DeadlineExceeded), false},
+		{errors.New("failed to create refund: connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.rejected, gatewayRejected(tt.err), tt.err.Error())
+	}
+}
+
+func TestRazorpayClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "key" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/payment_links":
+			var body razorpayPaymentLinkRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, int64(49999), body.Amount)
+			assert.Equal(t, "order_1", body.ReferenceID)
+
+			json.NewEncoder(w).Encode(razorpayPaymentLink{
+				ID:          "plink_1",
+				ReferenceID: body.ReferenceID,
+				Status:      "created",
+				ShortURL:    "https://rzp.io/i/abc",
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/payment_links":
+			assert.Equal(t, "order_1", r.URL.Query().Get("reference_id"))
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"payment_links": []razorpayPaymentLink{{
+					ID:          "plink_1",
+					ReferenceID: "order_1",
+					Amount:      49999,
+					Currency:    "INR",
+					Status:      "paid",
+					Payments: []razorpayPaymentLinkPayment{
+						{PaymentID: "pay_1", Amount: 49999, Status: "captured", Method: "upi"},
+					},
+				}},
+			})
+		case r.Method == http.MethodPost && r.URL.Path == "/payments/pay_1/refund":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, "optimum", body["speed"])
+			refund := razorpayRefund{ID: "rfnd_1", Amount: 10000, Status: "processed", SpeedProcessed: "instant"}
+			refund.AcquirerData.ARN = "arn_1"
+			json.NewEncoder(w).Encode(refund)
+		case r.Method == http.MethodGet && r.URL.Path == "/payments/pay_1/refunds":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"items": []razorpayRefund{
+					{ID: "rfnd_0", Amount: 5000, Receipt: "refund_0", Status: "processed"},
+					{ID: "rfnd_1", Amount: 10000, Receipt: "refund_1", Status: "pending"},
+				},
+			})
+		case r.Method == http.MethodPatch && r.URL.Path == "/payment_links/plink_1":
+			var body map[string]map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			assert.Equal(t, map[string]string{"channel": "web"}, body["notes"])
+			json.NewEncoder(w).Encode(razorpayPaymentLink{ID: "plink_1", ReferenceID: "order_1"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := NewRazorpayClient("key", "secret")
+	client.BaseURL = server.URL
+
+	order, err := client.CreateOrder(CreateOrderRequest{
+		OrderID:       "order_1",
+		OrderAmount:   499.99,
+		OrderCurrency: "INR",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "plink_1", order.CFOrderID)
+	assert.Equal(t, "https://rzp.io/i/abc", order.PaymentLink)
+	assert.Equal(t, domain.PaymentActive, order.OrderStatus)
+
+	status, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, status.OrderStatus)
+	assert.Equal(t, 499.99, status.OrderAmount)
+
+	payment, err := client.GetPayments("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "pay_1", payment.CFPaymentID)
+	assert.Equal(t, "upi", payment.PaymentMethod)
+
+	refund, err := client.RefundPayment(CashfreeRefundRequest{
+		OrderID:      "order_1",
+		RefundID:     "refund_1",
+		RefundAmount: 100,
+		RefundSpeed:  RefundSpeedInstant,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "rfnd_1", refund.CFRefundID)
+	assert.Equal(t, domain.RefundSuccess, refund.RefundStatus)
+	assert.Equal(t, RefundSpeedInstant, refund.RefundMode)
+	assert.Equal(t, "arn_1", refund.RefundARN)
+
+	require.NoError(t, client.UpdateOrderTags("order_1", map[string]string{"channel": "web"}))
+
+	// The status poller looks uncertain refunds up by the receipt they were sent with
+	handler := &PaymentHandler{gateways: NewGatewayRouter(NewCashfreeClient("id", "secret", EnvironmentTest), client, false)}
+	linked := &Payment{OrderID: "order_1", Gateway: GatewayRazorpay}
+	found, err := handler.lookUpRefund(context.Background(), linked, &Refund{OrderID: "order_1", RefundID: "refund_1"})
+	require.NoError(t, err)
+	assert.Equal(t, "rfnd_1", found.CFRefundID)
+	assert.Equal(t, "refund_1", found.RefundID)
+	assert.Equal(t, 100.0, found.RefundAmount)
+	assert.Equal(t, domain.RefundPending, found.RefundStatus)
+	_, err = handler.lookUpRefund(context.Background(), linked, &Refund{OrderID: "order_1", RefundID: "refund_2"})
+	assert.ErrorIs(t, err, ErrRefundNotFound)
+}
+
+func TestCashfreeEnvironmentRouting(t *testing.T) {
+	sandbox := NewCashfreeClient("test_id", "test_secret", EnvironmentTest)
+	production := NewCashfreeClient("prod_id", "prod_secret", EnvironmentProd)
+	handler := &PaymentHandler{
+		cashfree:     production,
+		gateways:     NewGatewayRouter(production, NewRazorpayClient("key", "secret"), false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: sandbox, EnvironmentProd: production},
+	}
+	ctx := context.Background()
+
+	gateway, err := handler.gatewayForNewOrder(ctx, "")
+	require.NoError(t, err)
+	assert.Same(t, production, gateway)
+
+	testCtx := WithCashfreeEnvironment(ctx, EnvironmentTest)
+	gateway, err = handler.gatewayForNewOrder(testCtx, "")
+	require.NoError(t, err)
+	assert.Same(t, sandbox, gateway)
+
+	// The Razorpay fallback only serves the default environment
+	_, err = handler.gatewayForNewOrder(testCtx, GatewayRazorpay)
+	assert.Error(t, err)
+
+	// Payments keep using the environment they were created in
+	env := EnvironmentTest
+	client, err := handler.cashfreeForPayment(ctx, &Payment{Environment: &env})
+	require.NoError(t, err)
+	assert.Same(t, sandbox, client)
+
+	client, err = handler.cashfreeForPayment(ctx, &Payment{})
+	require.NoError(t, err)
+	assert.Same(t, production, client)
+
+	// Merchants are bound to the environment of their credentials
+	handler.clients = NewMerchantClientPool(nil)
+	merchant := &Merchant{ID: uuid.New(), Environment: EnvironmentProd}
+	_, err = handler.cashfreeFor(WithCashfreeEnvironment(WithMerchant(ctx, merchant), EnvironmentTest))
+	assert.Error(t, err)
+}
+
+func TestCashfreeEnvironmentMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(CashfreeEnvironmentMiddleware())
+	r.GET("/env", func(c *gin.Context) {
+		c.String(http.StatusOK, CashfreeEnvironmentFromContext(requestContext(c)))
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/env", nil)
+	req.Header.Set("X-Cashfree-Environment", "test")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, EnvironmentTest, w.Body.String())
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/env", nil)
+	req.Header.Set("X-Cashfree-Environment", "staging")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -2,20 +2,205 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+	"payment-getway/queue"
 )
 
 type PaymentHandler struct {
-	cashfree *CashfreeClient
-	repo     *PaymentRepository
+	cashfree  *CashfreeClient
+	repo      *PaymentRepository
+	publisher events.Publisher
+	tasks     queue.Queue
+	invoices  InvoiceConfig
+	gateways  *GatewayRouter
+	clients   *MerchantClientPool
+	merchants *MerchantRepository
+
+	// environments holds the deployment's Cashfree clients by environment
+	environments map[string]*CashfreeClient
+
+	// orderURLs fills in and checks the return and notify URLs of new orders
+	orderURLs OrderURLs
+
+	// checkout is the deployment's hosted checkout theme
+	checkout CheckoutTheme
+
+	// paymentMethods are the payment methods new orders may be restricted to; empty
+	// allows all of Cashfree's
+	paymentMethods []string
+
+	// fxRates are the reference exchange rates recorded on new orders
+	fxRates *FXRates
+
+	// refundApprovalThreshold is the INR amount above which refunds need approval; 0
+	// sends every refund to the gateway straight away
+	refundApprovalThreshold float64
+
+	// fees are the platform's cut of vendor splits
+	fees FeeModel
+
+	// splitRecoveryEvents publishes vendor.recovery_due events for the vendor shares
+	// reversed when split orders are fully refunded
+	splitRecoveryEvents bool
+
+	// risk screens new payment sessions; nil creates them unscreened
+	risk *RiskScreen
+
+	// blocklist refuses blocked customers; nil checks none
+	blocklist *Blocklist
+
+	// phoneRegion is the region of customer phones given without a country code;
+	// DefaultPhoneRegion when empty
+	phoneRegion string
+
+	// duplicateSessions is how create-session answers orders that already have a
+	// session, DuplicateSessionsReuse or DuplicateSessionsReject; reuse when empty
+	duplicateSessions string
+
+	statusTokens *StatusTokenIssuer
+
+	// statusCache answers status reads from the database, refreshing stale statuses in
+	// the background; nil reads every status from the gateway
+	statusCache *StatusRefresher
+
+	// statusChanges wakes status streams when an order's status changes
+	statusChanges *StatusChanges
+
+	// attempts caches the payment attempts listed for orders by order status; nil lists
+	// them on every read
+	attempts *PaymentAttemptCache
+
+	// verifyThrottle limits how often verifying an order asks the gateway; nil asks it
+	// on every verification
+	verifyThrottle *VerifyThrottle
+
+	// verifyLatency counts how long verify calls take; nil counts nothing
+	verifyLatency *VerifyLatency
+
+	// clock dates order expiry, refund IDs, status tokens and invoices
+	clock Clock
+}
+
+// now returns the handler's current time
+func (h *PaymentHandler) now() time.Time {
+	return clockOrSystem(h.clock).Now()
+}
+
+// publishEvent publishes a domain event, logging failures instead of failing the request
+func (h *PaymentHandler) publishEvent(ctx context.Context, eventType, orderID string, data interface{}) {
+	if h.publisher == nil {
+		return
+	}
+
+	event, err := newTenantEvent(ctx, eventType, orderID, data)
+	if err != nil {
+		log.Printf("Failed to build %s event: %v", eventType, err)
+		return
+	}
+
+	if err := h.publisher.Publish(ctx, event); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// newTenantEvent creates an event of the context's merchant, whose order ID is only
+// unique among that merchant's orders
+func newTenantEvent(ctx context.Context, eventType, orderID string, data interface{}) (events.Event, error) {
+	event, err := events.NewEvent(eventType, orderID, data)
+	if tenantID := TenantIDFromContext(ctx); err == nil && tenantID != nil {
+		event.TenantID = tenantID.String()
+	}
+	return event, err
+}
+
+// publishPaymentEvent publishes a payment event built from the stored payment
+func (h *PaymentHandler) publishPaymentEvent(ctx context.Context, eventType, orderID string) {
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to load payment for %s event: %v", eventType, err)
+		return
+	}
+
+	h.publishEvent(ctx, eventType, orderID, paymentPayload(payment))
+}
+
+// publishRefundEvent publishes a refund event built from the stored refund
+func (h *PaymentHandler) publishRefundEvent(ctx context.Context, eventType, refundID string) {
+	refund, err := h.repo.GetRefundByID(ctx, refundID)
+	if err != nil {
+		log.Printf("Failed to load refund for %s event: %v", eventType, err)
+		return
+	}
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, refund.OrderID)
+	if err != nil {
+		log.Printf("Failed to load payment for %s event: %v", eventType, err)
+		return
+	}
+
+	h.publishEvent(ctx, eventType, refund.OrderID, refundPayload(refund, payment))
+}
+
+// paymentPayload converts a payment into its event payload
+func paymentPayload(p *Payment) events.PaymentPayload {
+	payload := events.PaymentPayload{
+		OrderID:       p.OrderID,
+		CFOrderID:     p.CFOrderID,
+		Amount:        p.Amount,
+		Currency:      p.Currency,
+		Status:        string(p.Status),
+		CustomerID:    p.CustomerID,
+		CustomerName:  p.CustomerName,
+		CustomerEmail: p.CustomerEmail,
+		CustomerPhone: p.CustomerPhone,
+		PaymentTime:   p.PaymentTime,
+	}
+	if p.CFPaymentID != nil {
+		payload.CFPaymentID = *p.CFPaymentID
+	}
+	if p.PaymentMethod != nil {
+		payload.PaymentMethod = *p.PaymentMethod
+	}
+	if p.PaymentURL != nil {
+		payload.PaymentURL = *p.PaymentURL
+	}
+	return payload
+}
+
+// refundPayload converts a refund and its payment into the refund event payload
+func refundPayload(r *Refund, p *Payment) events.RefundPayload {
+	payload := events.RefundPayload{
+		RefundID:      r.RefundID,
+		CFRefundID:    r.CFRefundID,
+		OrderID:       r.OrderID,
+		Amount:        r.Amount,
+		Currency:      p.Currency,
+		Status:        string(r.Status),
+		CustomerName:  p.CustomerName,
+		CustomerEmail: p.CustomerEmail,
+		ProcessedAt:   r.ProcessedAt,
+	}
+	if r.Reason != nil {
+		payload.Reason = *r.Reason
+	}
+	return payload
 }
 
 // Creates a payment session
@@ -26,6 +211,112 @@ func (h *PaymentHandler) CreatePaymentSession(c *gin.Context) {
 		return
 	}
 
+	// Cashfree rejects malformed phones only once the order is created, so normalize
+	// them before anything is stored
+	if err := h.normalizeCustomerContact(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	currency, exponent, err := validateCurrency(req.Currency, req.Amount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Currency = currency
+
+	if err := validateOrderTags(req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateOrderItems(req.Items, req.Currency, req.Amount); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Order splits are a Cashfree feature, so split orders never fail over to another
+	// gateway. The splits are built now so that one that does not cover its platform
+	// fees is refused before anything is created.
+	var orderSplits []CashfreeSettlementSplit
+	if len(req.Splits) > 0 {
+		if req.Gateway != "" && req.Gateway != GatewayCashfree {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "order_splits are only supported by Cashfree"})
+			return
+		}
+		if err := validateOrderSplits(req.Splits, req.Amount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Gateway = GatewayCashfree
+
+		orderSplits, _, err = h.buildSplits(&Payment{OrderID: req.OrderID, Amount: req.Amount, Currency: req.Currency}, req.Splits)
+		var feeErr *SplitFeeError
+		if errors.As(err, &feeErr) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":     "Split does not cover its platform fees",
+				"vendor_id": feeErr.VendorID,
+				"fees":      feeErr.Fees,
+			})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to build the splits of %s: %v", req.OrderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
+			return
+		}
+	}
+
+	if req.PaymentMethods != "" {
+		methods, err := parsePaymentMethods(req.PaymentMethods, h.paymentMethods)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.PaymentMethods = strings.Join(methods, ",")
+	}
+
+	returnURL, notifyURL, err := h.resolveOrderURLs(requestContext(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ActivateAt != nil {
+		if err := validateActivateAt(*req.ActivateAt, h.now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Order IDs are unique per merchant, so an order already stored is answered before
+	// anything is created with the gateway
+	if h.answerExistingOrder(requestContext(c), c, &req) {
+		return
+	}
+
+	// Refuse blocked customers, then screen the session, before anything is created
+	// with the gateway
+	if !h.checkBlocklist(requestContext(c), c, &req) {
+		return
+	}
+	if h.risk != nil {
+		assessment, ok := h.screenPayment(requestContext(c), c, &req)
+		if !ok {
+			return
+		}
+		if assessment.Decision == RiskReview {
+			h.holdPaymentForReview(c, &req, exponent, returnURL, notifyURL)
+			return
+		}
+	}
+
+	// Scheduled orders are created with the gateway by ScheduledOrdersJob
+	if req.ActivateAt != nil {
+		h.schedulePaymentSession(c, &req, exponent, returnURL, notifyURL)
+		return
+	}
+
 	// Create order in Cashfree
 	cashfreeReq := CreateOrderRequest{
 		OrderID:       req.OrderID,
@@ -38,21 +329,48 @@ func (h *PaymentHandler) CreatePaymentSession(c *gin.Context) {
 			CustomerPhone: req.CustomerPhone,
 		},
 		OrderMeta: &OrderMeta{
-			ReturnURL: req.ReturnURL,
-			NotifyURL: req.NotifyURL,
+			ReturnURL:      returnURL,
+			NotifyURL:      notifyURL,
+			PaymentMethods: req.PaymentMethods,
 		},
-		OrderExpiryTime: time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+		OrderExpiryTime: h.now().Add(orderExpiry).Format(time.RFC3339),
+		CartDetails:     cartDetails(req.Items, req.Currency),
+		OrderSplits:     orderSplits,
 	}
 
+	// Brand the hosted checkout for the merchant
+	theme := h.checkoutThemeFor(requestContext(c))
+	cashfreeReq.OrderTags = gatewayOrderTags(req.Tags, theme.orderTags())
+
 	// Handle optional description
 	if req.Description != nil {
 		cashfreeReq.OrderNote = *req.Description
 	}
 
-	cashfreeResp, err := h.cashfree.CreateOrder(cashfreeReq)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	gateway, err := h.gatewayForNewOrder(ctx, req.Gateway)
 	if err != nil {
-		log.Printf("Failed to create Cashfree order: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cashfreeResp, err := gateway.CreateOrder(cashfreeReq)
+	reused := false
+	if errors.Is(err, ErrDuplicateRequest) {
+		// A repeated request gets the order's existing session. An order created
+		// upstream before but never stored is stored now.
+		if h.answerDuplicateSession(ctx, c, &req) {
+			return
+		}
+		if existing, ok := h.adoptExistingOrder(gateway, &req); ok {
+			cashfreeResp, err, reused = existing, nil, true
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to create %s order: %v", gateway.Name(), err)
+		respondGatewayError(c, err, "Failed to create payment session")
 		return
 	}
 
@@ -62,32 +380,72 @@ func (h *PaymentHandler) CreatePaymentSession(c *gin.Context) {
 		CFOrderID:     cashfreeResp.CFOrderID,
 		Amount:        req.Amount,
 		Currency:      req.Currency,
-		Status:        "CREATED",
+		Status:        domain.PaymentCreated,
+		Gateway:       gateway.Name(),
 		CustomerID:    req.CustomerID,
 		CustomerName:  req.CustomerName,
 		CustomerEmail: req.CustomerEmail,
 		CustomerPhone: req.CustomerPhone,
 		Description:   req.Description,
-		PaymentURL:    &cashfreeResp.PaymentLink,
+		GSTIN:         req.GSTIN,
+		PlaceOfSupply: req.PlaceOfSupply,
+		TaxRate:       req.TaxRate,
+		HSNCode:       req.HSNCode,
+		Tags:          req.Tags,
+		Notes:         req.Notes,
+		Items:         req.Items,
+	}
+	if req.PaymentMethods != "" {
+		payment.PaymentMethods = &req.PaymentMethods
+	}
+	if cashfreeResp.PaymentLink != "" {
+		payment.PaymentURL = &cashfreeResp.PaymentLink
+	}
+	if client, ok := gateway.(*CashfreeClient); ok {
+		env := strings.ToUpper(client.Environment)
+		payment.Environment = &env
+	}
+	payment.CurrencyExponent = exponent
+	if rate, ok := h.fxRates.RateToINR(req.Currency); ok {
+		payment.FXRateINR = &rate
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
 	if err := h.repo.CreatePayment(ctx, payment); err != nil {
 		log.Printf("Failed to save payment to database: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payment"})
+		respondPaymentSaveError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	splits := h.recordOrderSplits(ctx, payment, req.Splits)
+
+	h.publishEvent(ctx, events.PaymentCreated, payment.OrderID, paymentPayload(payment))
+
+	response := gin.H{
 		"order_id":     cashfreeResp.OrderID,
 		"cf_order_id":  cashfreeResp.CFOrderID,
 		"payment_link": cashfreeResp.PaymentLink,
 		"order_status": cashfreeResp.OrderStatus,
 		"amount":       req.Amount,
 		"currency":     req.Currency,
-	})
+		"gateway":      gateway.Name(),
+	}
+	// Session-only Cashfree API versions have no payment link unless a checkout URL
+	// template builds one; the session opens Cashfree's checkout SDK either way
+	if cashfreeResp.PaymentSessionID != "" {
+		response["payment_session_id"] = cashfreeResp.PaymentSessionID
+	}
+	if !theme.IsZero() {
+		response["checkout"] = theme
+	}
+	if reused {
+		response["reused"] = true
+		releaseQuota(c)
+	}
+	if len(splits) > 0 {
+		response["order_splits"] = splits
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // Verifies a payment
@@ -98,28 +456,54 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 		return
 	}
 
-	// Get order status from Cashfree
-	orderStatus, err := h.cashfree.GetOrderStatus(req.OrderID)
+	started := h.now()
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	// With the status cache, orders created at the gateway are answered from the database,
+	// as are orders verified against the gateway too recently
+	throttled := h.statusCache == nil && !h.verifyThrottle.Allow(ctx, req.OrderID, h.now())
+	if h.statusCache != nil || throttled {
+		if payment, err := h.repo.GetPaymentByOrderID(ctx, req.OrderID); err == nil && payment.CFOrderID != "" {
+			if throttled {
+				c.Header("X-Verify-Throttled", "true")
+			}
+			h.recordVerified(ctx, started)
+			h.verifyFromCache(ctx, c, payment)
+			return
+		}
+	}
+
+	// Get order status from the gateway that created the order
+	gateway, err := h.gatewayForOrder(ctx, req.OrderID)
 	if err != nil {
-		log.Printf("Failed to get order status: %v", err)
+		log.Printf("Failed to resolve payment gateway: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify payment"})
 		return
 	}
 
+	// The customer is waiting at checkout
+	gateway = forCheckout(gateway)
+
+	orderStatus, err := gateway.GetOrderStatus(req.OrderID)
+	if err != nil {
+		log.Printf("Failed to get order status: %v", err)
+		respondGatewayError(c, err, "Failed to verify payment")
+		return
+	}
+
 	// Get payment details if order is paid
 	var paymentDetails *CashfreePaymentResponse
-	if orderStatus.OrderStatus == "PAID" {
-		paymentDetails, err = h.cashfree.GetPayments(req.OrderID)
+	if orderStatus.OrderStatus == domain.PaymentPaid {
+		paymentDetails, err = h.orderPayment(ctx, gateway, orderStatus)
 		if err != nil {
 			log.Printf("Failed to get payment details: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get payment details"})
+			respondGatewayError(c, err, "Failed to get payment details")
 			return
 		}
 	}
 
 	// Update payment status in database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
 	var cfPaymentID *string
 	var paymentMethod *string
@@ -131,16 +515,29 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 		paymentTime = &paymentDetails.PaymentTime
 	}
 
-	err = h.repo.UpdatePaymentStatus(ctx, req.OrderID, orderStatus.OrderStatus, cfPaymentID, paymentMethod, paymentTime)
+	// An order paid the wrong amount is reported as AMOUNT_MISMATCH, not to be fulfilled
+	status := orderStatus.OrderStatus
+	if paymentDetails != nil {
+		status = h.paidStatus(ctx, req.OrderID, paymentDetails.CFPaymentID, paymentDetails.PaymentAmount, status)
+	}
+
+	err = h.repo.UpdatePaymentStatus(ctx, req.OrderID, status, cfPaymentID, paymentMethod, paymentTime)
 	if err != nil {
 		log.Printf("Failed to update payment status: %v", err)
 		// Don't return error here as payment verification was successful
+	} else if paymentDetails != nil {
+		h.recordPaymentCharges(ctx, req.OrderID, paymentDetails.PaymentAmount, paymentDetails.PaymentCharges)
+		h.recordPaymentEMI(ctx, req.OrderID, paymentDetails.EMI)
+		h.recordPaymentMethodDetails(ctx, req.OrderID, paymentDetails.MethodDetails)
+		if status == domain.PaymentPaid {
+			h.issueInvoice(ctx, req.OrderID)
+		}
 	}
 
 	response := gin.H{
 		"order_id":     orderStatus.OrderID,
 		"cf_order_id":  orderStatus.CFOrderID,
-		"order_status": orderStatus.OrderStatus,
+		"order_status": status,
 		"order_amount": orderStatus.OrderAmount,
 	}
 
@@ -151,6 +548,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 		response["payment_amount"] = paymentDetails.PaymentAmount
 	}
 
+	h.recordVerified(ctx, started)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -158,7 +556,7 @@ func (h *PaymentHandler) VerifyPayment(c *gin.Context) {
 func (h *PaymentHandler) GetPaymentDetails(c *gin.Context) {
 	orderID := c.Param("order_id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
 	defer cancel()
 
 	// Get payment from database
@@ -169,24 +567,84 @@ func (h *PaymentHandler) GetPaymentDetails(c *gin.Context) {
 		return
 	}
 
-	// Also get latest status from Cashfree
-	orderStatus, err := h.cashfree.GetOrderStatus(orderID)
+	// Scheduled orders and those held for review do not exist at the gateway yet
+	if payment.Status == domain.PaymentScheduled || payment.Status == domain.PaymentActivating || payment.Status == domain.PaymentPendingReview {
+		respondPayment(c, payment)
+		return
+	}
+
+	if h.statusCache != nil {
+		h.serveCachedStatus(ctx, c, payment)
+		respondPayment(c, payment)
+		return
+	}
+
+	// Also get latest status from the payment gateway
+	gateway, err := h.gatewayFor(ctx, payment)
 	if err != nil {
-		log.Printf("Failed to get order status from Cashfree: %v", err)
-		// Return database payment if Cashfree call fails
-		c.JSON(http.StatusOK, payment)
+		log.Printf("Failed to resolve payment gateway: %v", err)
+		respondPayment(c, payment)
+		return
+	}
+
+	orderStatus, err := gateway.GetOrderStatus(orderID)
+	if err != nil {
+		log.Printf("Failed to get order status from %s: %v", gateway.Name(), err)
+		// Return database payment if the gateway call fails
+		respondPayment(c, payment)
 		return
 	}
 
 	// Update status if different
-	if payment.Status != orderStatus.OrderStatus {
+	if payment.Status != orderStatus.OrderStatus && !keepsLocalStatus(payment.Status, orderStatus.OrderStatus) {
 		err = h.repo.UpdatePaymentStatus(ctx, orderID, orderStatus.OrderStatus, payment.CFPaymentID, payment.PaymentMethod, payment.PaymentTime)
 		if err != nil {
 			log.Printf("Failed to update payment status: %v", err)
+		} else {
+			payment.UpdatedAt = h.now()
 		}
 		payment.Status = orderStatus.OrderStatus
 	}
 
+	respondPayment(c, payment)
+}
+
+// GetPaymentByCFPaymentID finds a payment by Cashfree's payment ID, which bank and
+// dispute communications quote instead of our order ID. Payments whose ID was never
+// recorded, such as those whose webhook was missed, are looked up at Cashfree.
+func (h *PaymentHandler) GetPaymentByCFPaymentID(c *gin.Context) {
+	cfPaymentID := c.Param("cf_payment_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByCFPaymentID(ctx, cfPaymentID)
+	if err == nil {
+		c.JSON(http.StatusOK, payment)
+		return
+	}
+
+	client, err := h.cashfreeFor(ctx)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get payment"})
+		return
+	}
+
+	cfPayment, err := client.GetPayment(cfPaymentID)
+	if err != nil {
+		log.Printf("Failed to get payment %s from Cashfree: %v", cfPaymentID, err)
+		respondGatewayError(c, err, "Failed to get payment")
+		return
+	}
+
+	payment, err = h.repo.GetPaymentByOrderID(ctx, cfPayment.OrderID)
+	if err != nil {
+		log.Printf("Failed to get payment from database: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
 
@@ -200,32 +658,29 @@ func (h *PaymentHandler) RefundPayment(c *gin.Context) {
 		return
 	}
 
-	// Generate refund ID
-	refundID := fmt.Sprintf("refund_%s_%d", orderID, time.Now().Unix())
+	if req.Reference != "" && !cashfreeReferenceID.MatchString(req.Reference) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refund_reference must be letters, digits, underscores or hyphens"})
+		return
+	}
+
+	refundID := refundIDFor(TenantIDFromContext(requestContext(c)), orderID, req.Reference, h.now())
 
 	// Create refund request for Cashfree
 	cashfreeRefundReq := CashfreeRefundRequest{
 		OrderID:      orderID,
 		RefundAmount: req.Amount,
 		RefundID:     refundID,
+		RefundSpeed:  req.Speed,
 	}
 
 	if req.Reason != nil {
 		cashfreeRefundReq.RefundNote = *req.Reason
 	}
 
-	// Create refund in Cashfree
-	refundResp, err := h.cashfree.RefundPayment(cashfreeRefundReq)
-	if err != nil {
-		log.Printf("Failed to create refund in Cashfree: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
-		return
-	}
-
-	// Get payment details for cf_order_id
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
 	defer cancel()
 
+	// Get payment first so only the merchant that owns it can refund it
 	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
 	if err != nil {
 		log.Printf("Failed to get payment: %v", err)
@@ -233,56 +688,235 @@ func (h *PaymentHandler) RefundPayment(c *gin.Context) {
 		return
 	}
 
-	// Save refund to database
 	refund := &Refund{
-		RefundID:   refundID,
-		CFRefundID: refundResp.CFRefundID,
-		OrderID:    orderID,
-		CFOrderID:  payment.CFOrderID,
-		Amount:     req.Amount,
-		Status:     refundResp.RefundStatus,
-		Reason:     req.Reason,
+		RefundID:  refundID,
+		OrderID:   orderID,
+		CFOrderID: payment.CFOrderID,
+		Amount:    req.Amount,
+		Status:    domain.RefundPending,
+		Reason:    req.Reason,
+		Speed:     req.Speed,
+	}
+	if req.Reference != "" {
+		refund.Reference = &req.Reference
 	}
 
-	if err := h.repo.CreateRefund(ctx, refund); err != nil {
+	splits, ok := h.refundSplitsFor(ctx, c, payment, &req)
+	if !ok {
+		return
+	}
+	refund.Splits = splits
+	cashfreeRefundReq.RefundSplits = cashfreeRefundSplits(splits)
+
+	// Large refunds wait for a second user's approval before reaching the gateway
+	if h.needsRefundApproval(payment, req.Amount) {
+		h.requestRefundApproval(ctx, c, refund)
+		return
+	}
+
+	// Create refund with the gateway that took the payment
+	gateway, err := h.gatewayFor(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve payment gateway: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
+		return
+	}
+
+	// Save the refund before calling the gateway, so that concurrent refunds cannot
+	// together exceed the payment
+	if !h.reserveRefund(ctx, c, refund) {
+		return
+	}
+
+	refundResp, err := gateway.RefundPayment(cashfreeRefundReq)
+	if err != nil {
+		log.Printf("Failed to create refund in %s: %v", gateway.Name(), err)
+		if !gatewayRejected(err) {
+			// The gateway may have made the refund, so it stays held against the balance
+			// until the gateway reports it, by webhook or to the status poller
+			c.JSON(http.StatusAccepted, gin.H{
+				"refund_id":     refundID,
+				"order_id":      orderID,
+				"refund_amount": req.Amount,
+				"refund_status": domain.RefundPending,
+				"message":       "The payment gateway did not confirm the refund; its status is updated once the gateway reports it",
+			})
+			return
+		}
+		if err := h.repo.ReleaseRefund(ctx, refundID); err != nil {
+			log.Printf("Failed to release refund %s: %v", refundID, err)
+		}
+		respondGatewayError(c, err, "Failed to create refund")
+		return
+	}
+
+	refund.CFRefundID = refundResp.CFRefundID
+	refund.Status = refundResp.RefundStatus
+	if err := h.repo.SetRefundGatewayResult(ctx, refundID, refundResp); err != nil {
 		log.Printf("Failed to save refund to database: %v", err)
 		// Don't return error as refund was created successfully in Cashfree
 	}
 
+	h.publishEvent(ctx, events.RefundCreated, orderID, refundPayload(refund, payment))
+
 	c.JSON(http.StatusOK, gin.H{
 		"refund_id":     refundResp.RefundID,
 		"cf_refund_id":  refundResp.CFRefundID,
 		"order_id":      refundResp.OrderID,
 		"refund_amount": refundResp.RefundAmount,
 		"refund_status": refundResp.RefundStatus,
+		"refund_mode":   refundMode(refundResp),
 	})
 }
 
+// refundIDFor returns the ID of a new refund of an order: "refund_" and a hash of the
+// order ID with the caller's reference, so it fits Cashfree's limit however long the
+// order ID is. A reference makes the ID the same on every retry; without one the time
+// is hashed instead, so the ID is unique to the nanosecond. Refund IDs are unique across
+// merchants, whose order IDs may be the same, so the owning merchant is hashed too.
+func refundIDFor(tenantID *uuid.UUID, orderID, reference string, now time.Time) string {
+	key := orderID + "\x00ref\x00" + reference
+	if reference == "" {
+		key = orderID + "\x00" + strconv.FormatInt(now.UnixNano(), 10)
+	}
+	if tenantID != nil {
+		key = tenantID.String() + "\x00" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "refund_" + hex.EncodeToString(sum[:16])
+}
+
+// reserveRefund saves a refund against the payment's refundable balance, writing the
+// error response when it does not fit. A repeat of a refund's reference is answered
+// with the existing refund.
+func (h *PaymentHandler) reserveRefund(ctx context.Context, c *gin.Context, refund *Refund) bool {
+	err := h.repo.ReserveRefund(ctx, refund)
+	var duplicateErr *DuplicateRefundError
+	if errors.As(err, &duplicateErr) {
+		respondRepeatedRefund(c, duplicateErr.Existing, refund)
+		return false
+	}
+	var limitErr *RefundLimitError
+	if errors.As(err, &limitErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "Refund amount exceeds remaining balance",
+			"refundable": limitErr.Refundable,
+		})
+		return false
+	}
+	var splitErr *RefundSplitError
+	if errors.As(err, &splitErr) {
+		response := gin.H{"error": "Refund split exceeds vendor balance", "vendor_id": splitErr.VendorID}
+		if splitErr.Available >= 0 {
+			response["available"] = splitErr.Available
+		}
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to save refund to database: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
+		return false
+	}
+	return true
+}
+
+// respondRepeatedRefund answers a refund request whose reference the order already has
+// a refund for: with that refund when the request matches it, releasing the refund
+// quota it reserved, or 409 when the reference was used for a different amount
+func respondRepeatedRefund(c *gin.Context, existing, requested *Refund) {
+	if math.Abs(existing.Amount-requested.Amount) > 0.0005 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":            "refund_reference was already used for a different refund",
+			"refund_id":        existing.RefundID,
+			"refund_reference": stringValue(existing.Reference),
+		})
+		return
+	}
+
+	response := gin.H{
+		"refund_id":        existing.RefundID,
+		"cf_refund_id":     existing.CFRefundID,
+		"order_id":         existing.OrderID,
+		"refund_amount":    existing.Amount,
+		"refund_status":    existing.Status,
+		"refund_reference": stringValue(existing.Reference),
+	}
+	if existing.Mode != nil {
+		response["refund_mode"] = *existing.Mode
+	}
+	releaseQuota(c)
+	c.JSON(http.StatusOK, response)
+}
+
+// Gets how much of a payment is left to refund
+func (h *PaymentHandler) GetRefundableAmount(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	balance, err := h.repo.GetRefundBalance(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get refund balance: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, balance)
+}
+
 // Cancels a payment
 func (h *PaymentHandler) CancelPayment(c *gin.Context) {
 	orderID := c.Param("order_id")
 
-	// Cancel order in Cashfree
-	err := h.cashfree.CancelOrder(orderID)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	// A scheduled order is only cancelled locally, as the gateway has not seen it
+	cancelled, err := h.repo.CancelScheduledPayment(ctx, orderID)
 	if err != nil {
-		log.Printf("Failed to cancel order in Cashfree: %v", err)
+		log.Printf("Failed to cancel scheduled payment: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment"})
 		return
 	}
+	if cancelled {
+		h.publishPaymentEvent(ctx, events.PaymentCancelled, orderID)
+		c.JSON(http.StatusOK, gin.H{
+			"order_id": orderID,
+			"status":   domain.PaymentCancelled,
+			"message":  "Payment cancelled successfully",
+		})
+		return
+	}
 
-	// Update payment status in database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	// Cancel order with the gateway that created it
+	gateway, err := h.gatewayForOrder(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to resolve payment gateway: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment"})
+		return
+	}
 
-	err = h.repo.UpdatePaymentStatus(ctx, orderID, "CANCELLED", nil, nil, nil)
+	err = gateway.CancelOrder(orderID)
+	if err != nil {
+		log.Printf("Failed to cancel order in %s: %v", gateway.Name(), err)
+		respondGatewayError(c, err, "Failed to cancel payment")
+		return
+	}
+
+	// Update payment status in database
+	err = h.repo.UpdatePaymentStatus(ctx, orderID, domain.PaymentCancelled, nil, nil, nil)
 	if err != nil {
 		log.Printf("Failed to update payment status: %v", err)
 		// Don't return error as cancellation was successful in Cashfree
 	}
 
+	h.publishPaymentEvent(ctx, events.PaymentCancelled, orderID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"order_id": orderID,
-		"status":   "CANCELLED",
+		"status":   domain.PaymentCancelled,
 		"message":  "Payment cancelled successfully",
 	})
 }
@@ -298,7 +932,7 @@ func (h *PaymentHandler) CreateSplitSettlement(c *gin.Context) {
 	}
 
 	// Get payment details
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
 	defer cancel()
 
 	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
@@ -309,34 +943,20 @@ func (h *PaymentHandler) CreateSplitSettlement(c *gin.Context) {
 	}
 
 	// Convert splits for Cashfree API
-	var cashfreeSplits []CashfreeSettlementSplit
-	var dbSplits []SplitSettlement
-
-	for _, split := range req.Splits {
-		cashfreeSplit := CashfreeSettlementSplit{
-			VendorID: split.VendorID,
-		}
-
-		dbSplit := SplitSettlement{
-			OrderID:   orderID,
-			CFOrderID: payment.CFOrderID,
-			VendorID:  split.VendorID,
-			Status:    "PENDING",
-		}
-
-		if split.Amount != nil {
-			cashfreeSplit.Amount = split.Amount
-			dbSplit.Amount = *split.Amount
-			dbSplit.SplitType = "AMOUNT"
-		} else if split.Percentage != nil {
-			cashfreeSplit.Percentage = split.Percentage
-			dbSplit.Percentage = split.Percentage
-			dbSplit.Amount = (payment.Amount * *split.Percentage) / 100
-			dbSplit.SplitType = "PERCENTAGE"
-		}
-
-		cashfreeSplits = append(cashfreeSplits, cashfreeSplit)
-		dbSplits = append(dbSplits, dbSplit)
+	cashfreeSplits, dbSplits, err := h.buildSplits(payment, req.Splits)
+	var feeErr *SplitFeeError
+	if errors.As(err, &feeErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":     "Split does not cover its platform fees",
+			"vendor_id": feeErr.VendorID,
+			"fees":      feeErr.Fees,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to build the splits of %s: %v", orderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create split settlement"})
+		return
 	}
 
 	// Create settlement in Cashfree
@@ -345,25 +965,48 @@ func (h *PaymentHandler) CreateSplitSettlement(c *gin.Context) {
 		Splits:  cashfreeSplits,
 	}
 
-	settlementResp, err := h.cashfree.CreateSettlement(settlementReq)
+	cashfree, err := h.cashfreeForPayment(ctx, payment)
 	if err != nil {
-		log.Printf("Failed to create settlement in Cashfree: %v", err)
+		log.Printf("Failed to get Cashfree client: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create split settlement"})
 		return
 	}
 
+	settlementResp, err := cashfree.CreateSettlement(settlementReq)
+	if err != nil {
+		log.Printf("Failed to create settlement in Cashfree: %v", err)
+		respondGatewayError(c, err, "Failed to create split settlement")
+		return
+	}
+
 	// Save split settlement to database
 	if err := h.repo.CreateSplitSettlement(ctx, dbSplits); err != nil {
 		log.Printf("Failed to save split settlement to database: %v", err)
 		// Don't return error as settlement was created in Cashfree
 	}
 
+	var splitTotal float64
+	fees := []SplitFee{}
+	for _, split := range dbSplits {
+		splitTotal += split.Amount
+		fees = append(fees, split.Fees...)
+	}
+
+	h.publishEvent(ctx, events.SettlementSplit, orderID, events.SettlementPayload{
+		SettlementID:   settlementResp.SettlementID,
+		CFSettlementID: settlementResp.CFSettlementID,
+		OrderID:        orderID,
+		Amount:         splitTotal,
+		Status:         string(settlementResp.SettlementStatus),
+	})
+
 	c.JSON(http.StatusOK, gin.H{
 		"cf_settlement_id": settlementResp.CFSettlementID,
 		"settlement_id":    settlementResp.SettlementID,
 		"order_id":         settlementResp.OrderID,
 		"settlement_status": settlementResp.SettlementStatus,
 		"splits":           settlementResp.Splits,
+		"fees":             fees,
 	})
 }
 
@@ -371,7 +1014,7 @@ func (h *PaymentHandler) CreateSplitSettlement(c *gin.Context) {
 func (h *PaymentHandler) GetSettlementDetails(c *gin.Context) {
 	settlementID := c.Param("settlement_id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
 	defer cancel()
 
 	settlement, err := h.repo.GetSettlementByID(ctx, settlementID)
@@ -404,12 +1047,30 @@ func (h *PaymentHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
+	// Merchant webhooks are verified with the receiving merchant's secrets. Other webhooks
+	// may come from any configured environment or any active merchant. Either way a
+	// merchant's webhook is applied within that merchant's scope.
+	ctx := requestContext(c)
+	var merchant *Merchant
+	if merchantID := c.Param("merchant_id"); merchantID != "" {
+		merchant, err = h.webhookMerchant(ctx, merchantID)
+		if err != nil {
+			log.Printf("Rejected webhook for merchant %s: %v", merchantID, err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown merchant"})
+			return
+		}
+	}
+
 	// Verify webhook signature
-	if !h.cashfree.VerifyWebhookSignature(signature, timestamp, string(body)) {
+	merchant, verified := h.verifyWebhook(ctx, merchant, signature, timestamp, string(body))
+	if !verified {
 		log.Println("Invalid webhook signature")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
 		return
 	}
+	if merchant != nil {
+		ctx = WithMerchant(ctx, merchant)
+	}
 
 	// Parse webhook data
 	var webhookData WebhookData
@@ -419,120 +1080,318 @@ func (h *PaymentHandler) HandleWebhook(c *gin.Context) {
 		return
 	}
 
-	// Log webhook for debugging
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	h.acceptWebhook(ctx, body, webhookData)
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+// acceptWebhook logs a verified webhook and applies it, through the task queue when it
+// can be queued. It reports whether the webhook was queued rather than applied inline.
+func (h *PaymentHandler) acceptWebhook(ctx context.Context, body []byte, webhookData WebhookData) bool {
+	// Log webhook for debugging
 	var orderID *string
-	if oid, exists := webhookData.Data["order_id"]; exists {
-		if oidStr, ok := oid.(string); ok {
-			orderID = &oidStr
-		}
+	if oid := webhookOrderID(webhookData.Data); oid != "" {
+		orderID = &oid
 	}
 
 	webhook := &Webhook{
-		EventType: webhookData.Type,
+		EventType: domain.WebhookType(webhookData.Type),
 		OrderID:   orderID,
 		Payload:   string(body),
-		Status:    "RECEIVED",
+		Status:    domain.WebhookReceived,
 	}
 
 	if err := h.repo.CreateWebhookLog(ctx, webhook); err != nil {
 		log.Printf("Failed to log webhook: %v", err)
 	}
 
-	// Process different webhook events
-	switch webhookData.Type {
-	case "PAYMENT_SUCCESS_WEBHOOK":
-		h.handlePaymentSuccessWebhook(ctx, webhookData.Data)
-	case "PAYMENT_FAILED_WEBHOOK":
-		h.handlePaymentFailedWebhook(ctx, webhookData.Data)
-	case "REFUND_STATUS_WEBHOOK":
-		h.handleRefundStatusWebhook(ctx, webhookData.Data)
-	case "SETTLEMENT_STATUS_WEBHOOK":
-		h.handleSettlementStatusWebhook(ctx, webhookData.Data)
+	// Hand the webhook to the task queue, processing inline if it cannot be queued
+	if h.tasks != nil {
+		task, err := newTenantTask(ctx, queue.TaskProcessWebhook, json.RawMessage(body))
+		if err == nil {
+			err = h.tasks.Enqueue(ctx, task)
+		}
+		if err == nil {
+			return true
+		}
+		log.Printf("Failed to enqueue webhook, processing inline: %v", err)
+	}
+
+	if err := h.dispatchWebhook(ctx, webhookData); err != nil {
+		log.Printf("Failed to process %s webhook: %v", webhookData.Type, err)
+		if err := h.repo.UpdateWebhookStatus(ctx, webhook.ID, domain.WebhookFailed); err != nil {
+			log.Printf("Failed to mark webhook %s failed: %v", webhook.ID, err)
+		}
+	}
+	return false
+}
+
+// verifyWebhook checks a webhook signature. A webhook for a merchant is checked against
+// that merchant's secrets; any other webhook against the deployment's Cashfree clients
+// and then every active merchant. It returns the merchant whose secret matched, if any.
+func (h *PaymentHandler) verifyWebhook(ctx context.Context, merchant *Merchant, signature, timestamp, payload string) (*Merchant, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if merchant != nil {
+		secrets, err := h.merchants.WebhookSecrets(ctx, merchant)
+		if err != nil {
+			log.Printf("Failed to load webhook secrets for merchant %s: %v", merchant.ID, err)
+			return nil, false
+		}
+		for _, secret := range secrets {
+			if verifyWebhookSignature(secret, signature, timestamp, payload) {
+				return merchant, true
+			}
+		}
+		return nil, false
+	}
+
+	for _, client := range h.webhookClients() {
+		if client.VerifyWebhookSignature(signature, timestamp, payload) {
+			return nil, true
+		}
+	}
+
+	if h.merchants == nil {
+		return nil, false
+	}
+	signers, err := h.merchants.WebhookSigners(ctx)
+	if err != nil {
+		log.Printf("Failed to load merchant webhook secrets: %v", err)
+		return nil, false
+	}
+	for _, signer := range signers {
+		if verifyWebhookSignature(signer.secret, signature, timestamp, payload) {
+			return signer.merchant, true
+		}
+	}
+	return nil, false
+}
+
+// webhookClients returns the deployment's Cashfree clients, default environment first
+func (h *PaymentHandler) webhookClients() []*CashfreeClient {
+	clients := []*CashfreeClient{h.cashfree}
+	for _, env := range []string{EnvironmentTest, EnvironmentProd} {
+		if client, ok := h.environments[env]; ok && client != h.cashfree {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// webhookMerchant returns the active merchant a webhook URL was issued for
+func (h *PaymentHandler) webhookMerchant(ctx context.Context, merchantID string) (*Merchant, error) {
+	if h.merchants == nil || h.clients == nil {
+		return nil, errors.New("merchant accounts are not configured")
+	}
+
+	id, err := uuid.Parse(merchantID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merchant id: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	merchant, err := h.merchants.GetMerchantByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !merchant.Active {
+		return nil, errors.New("merchant is disabled")
+	}
+	return merchant, nil
+}
+
+// dispatchWebhook applies a verified webhook to local state
+func (h *PaymentHandler) dispatchWebhook(ctx context.Context, webhookData WebhookData) error {
+	var err error
+	switch domain.WebhookType(webhookData.Type) {
+	case domain.WebhookPaymentSuccess:
+		err = h.handlePaymentSuccessWebhook(ctx, webhookData.Data)
+	case domain.WebhookPaymentFailed:
+		err = h.handlePaymentFailedWebhook(ctx, webhookData.Data)
+	case domain.WebhookPaymentCharges:
+		err = h.handlePaymentChargesWebhook(ctx, webhookData.Data)
+	case domain.WebhookRefundStatus:
+		err = h.handleRefundStatusWebhook(ctx, webhookData.Data)
+	case domain.WebhookAutoRefundStatus:
+		err = h.handleAutoRefundStatusWebhook(ctx, webhookData.Data)
+	case domain.WebhookSettlementStatus:
+		err = h.handleSettlementStatusWebhook(ctx, webhookData.Data)
+	case domain.WebhookDisputeCreated:
+		err = h.handleDisputeCreatedWebhook(ctx, webhookData.Data)
 	default:
 		log.Printf("Unknown webhook type: %s", webhookData.Type)
+		return nil
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	if err == nil {
+		h.recordWebhookApplied(ctx, webhookData)
+	}
+	return err
 }
 
-func (h *PaymentHandler) handlePaymentSuccessWebhook(ctx context.Context, data map[string]interface{}) {
-	orderID, ok := data["order_id"].(string)
-	if !ok {
-		log.Println("Missing order_id in payment success webhook")
-		return
+func (h *PaymentHandler) handlePaymentSuccessWebhook(ctx context.Context, data map[string]interface{}) error {
+	payment, err := parsePaymentWebhook(data)
+	if err != nil {
+		log.Printf("Invalid payment success webhook: %v", err)
+		return nil
 	}
 
-	cfPaymentID, _ := data["cf_payment_id"].(string)
-	paymentMethod, _ := data["payment_method"].(string)
+	status := h.paidStatus(ctx, payment.OrderID, payment.CFPaymentID, payment.PaymentAmount, domain.PaymentSuccess)
+	err = h.repo.UpdatePaymentStatus(ctx, payment.OrderID, status, &payment.CFPaymentID, &payment.PaymentMethod, payment.PaymentTime)
+	if err != nil {
+		return fmt.Errorf("failed to update payment status for successful payment: %v", err)
+	}
+	h.recordPaymentCharges(ctx, payment.OrderID, payment.PaymentAmount, payment.Charges)
+	h.recordPaymentEMI(ctx, payment.OrderID, payment.EMI)
+	h.recordPaymentMethodDetails(ctx, payment.OrderID, payment.MethodDetails)
+	h.checkCardBlocklist(ctx, payment.OrderID, payment.CardFingerprint)
+
+	// A payment of the wrong amount is not fulfilled
+	if status == domain.PaymentAmountMismatch {
+		return nil
+	}
 
-	// Parse payment time
-	var paymentTime *time.Time
-	if pt, exists := data["payment_time"]; exists {
-		if ptStr, ok := pt.(string); ok {
-			if parsedTime, err := time.Parse(time.RFC3339, ptStr); err == nil {
-				paymentTime = &parsedTime
-			}
-		}
+	h.issueInvoice(ctx, payment.OrderID)
+
+	h.publishPaymentEvent(ctx, events.PaymentSucceeded, payment.OrderID)
+	return nil
+}
+
+func (h *PaymentHandler) handlePaymentFailedWebhook(ctx context.Context, data map[string]interface{}) error {
+	payment, err := parsePaymentWebhook(data)
+	if err != nil {
+		log.Printf("Invalid payment failed webhook: %v", err)
+		return nil
 	}
 
-	err := h.repo.UpdatePaymentStatus(ctx, orderID, "SUCCESS", &cfPaymentID, &paymentMethod, paymentTime)
+	err = h.repo.UpdatePaymentStatus(ctx, payment.OrderID, domain.PaymentFailed, nil, nil, nil)
 	if err != nil {
-		log.Printf("Failed to update payment status for successful payment: %v", err)
+		return fmt.Errorf("failed to update payment status for failed payment: %v", err)
 	}
+
+	h.publishPaymentEvent(ctx, events.PaymentFailed, payment.OrderID)
+	return nil
 }
 
-func (h *PaymentHandler) handlePaymentFailedWebhook(ctx context.Context, data map[string]interface{}) {
-	orderID, ok := data["order_id"].(string)
-	if !ok {
-		log.Println("Missing order_id in payment failed webhook")
-		return
+func (h *PaymentHandler) handlePaymentChargesWebhook(ctx context.Context, data map[string]interface{}) error {
+	payment, err := parsePaymentWebhook(data)
+	if err == nil && payment.Charges == nil {
+		err = errors.New("missing charges_details")
+	}
+	if err != nil {
+		log.Printf("Invalid payment charges webhook: %v", err)
+		return nil
 	}
 
-	err := h.repo.UpdatePaymentStatus(ctx, orderID, "FAILED", nil, nil, nil)
+	err = h.repo.SetPaymentCharges(ctx, payment.OrderID, payment.Charges.ServiceCharge, payment.Charges.ServiceTax, payment.Charges.net(payment.PaymentAmount))
 	if err != nil {
-		log.Printf("Failed to update payment status for failed payment: %v", err)
+		return fmt.Errorf("failed to record gateway charges: %v", err)
 	}
+	return nil
 }
 
-func (h *PaymentHandler) handleRefundStatusWebhook(ctx context.Context, data map[string]interface{}) {
-	refundID, ok := data["refund_id"].(string)
-	if !ok {
-		log.Println("Missing refund_id in refund status webhook")
-		return
+func (h *PaymentHandler) handleRefundStatusWebhook(ctx context.Context, data map[string]interface{}) error {
+	refund, err := parseRefundWebhook(data)
+	if err != nil {
+		log.Printf("Invalid refund status webhook: %v", err)
+		return nil
 	}
+	return h.applyRefundWebhook(ctx, refund)
+}
 
-	refundStatus, _ := data["refund_status"].(string)
+// applyRefundWebhook brings the stored refund up to date with a refund webhook,
+// recording refunds that were not made through the service first
+func (h *PaymentHandler) applyRefundWebhook(ctx context.Context, refund RefundWebhook) error {
+	if !refund.RefundStatus.Valid() {
+		return fmt.Errorf("unknown refund status %q", refund.RefundStatus)
+	}
 
-	// Parse processed time
-	var processedAt *time.Time
-	if pt, exists := data["processed_at"]; exists {
-		if ptStr, ok := pt.(string); ok {
-			if parsedTime, err := time.Parse(time.RFC3339, ptStr); err == nil {
-				processedAt = &parsedTime
-			}
+	recorded, err := h.recordGatewayRefund(ctx, refund)
+	if err != nil {
+		return fmt.Errorf("failed to record refund made at the gateway: %v", err)
+	}
+
+	err = h.repo.UpdateRefundStatus(ctx, refund.RefundID, refund.RefundStatus, refund.ProcessedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update refund status: %v", err)
+	}
+	if refund.RefundARN != "" || refund.RefundMode != "" {
+		if err := h.repo.SetRefundARN(ctx, refund.RefundID, refund.RefundMode, refund.RefundARN); err != nil {
+			return fmt.Errorf("failed to update refund ARN: %v", err)
 		}
 	}
+	if err := h.reverseRefundedSplits(ctx, refund.RefundID); err != nil {
+		return fmt.Errorf("failed to reverse vendor splits: %v", err)
+	}
+
+	if recorded {
+		h.publishRefundEvent(ctx, events.RefundCreated, refund.RefundID)
+	}
+	h.publishRefundEvent(ctx, events.RefundUpdated, refund.RefundID)
+	return nil
+}
 
-	err := h.repo.UpdateRefundStatus(ctx, refundID, refundStatus, processedAt)
+func (h *PaymentHandler) handleSettlementStatusWebhook(ctx context.Context, data map[string]interface{}) error {
+	payload := parseSettlementWebhook(data)
+	settlementID := payload.SettlementID
+	if settlementID == "" {
+		settlementID = payload.CFSettlementID
+	}
+	if settlementID == "" || payload.OrderID == "" {
+		log.Printf("Invalid settlement webhook: missing settlement_id or order_id")
+		return nil
+	}
+
+	status, err := domain.ParseSettlementStatus(payload.Status)
 	if err != nil {
-		log.Printf("Failed to update refund status: %v", err)
+		return err
+	}
+
+	settlement := &Settlement{
+		SettlementID: settlementID,
+		OrderID:      payload.OrderID,
+		Amount:       payload.Amount,
+		Status:       status,
 	}
+	if payload.UTR != "" {
+		settlement.UTR = &payload.UTR
+	}
+	if status == domain.SettlementSuccess {
+		now := h.now()
+		settlement.SettledAt = &now
+	}
+
+	if err := h.repo.RecordSettlement(ctx, settlement); err != nil {
+		return fmt.Errorf("failed to record settlement: %v", err)
+	}
+
+	h.publishEvent(ctx, events.SettlementUpdated, payload.OrderID, payload)
+	return nil
 }
 
-func (h *PaymentHandler) handleSettlementStatusWebhook(ctx context.Context, data map[string]interface{}) {
-	// Handle settlement status updates
-	// This would involve updating settlement records in the database
-	log.Printf("Settlement webhook received: %+v", data)
+func (h *PaymentHandler) handleDisputeCreatedWebhook(ctx context.Context, data map[string]interface{}) error {
+	payload, err := parseDisputeWebhook(data)
+	if err != nil {
+		log.Printf("Invalid dispute webhook: %v", err)
+		return nil
+	}
+
+	h.publishEvent(ctx, events.DisputeOpened, payload.OrderID, payload)
+	return nil
 }
 
 // Gets refund details
 func (h *PaymentHandler) GetRefundDetails(c *gin.Context) {
 	refundID := c.Param("refund_id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
 	defer cancel()
 
 	refund, err := h.repo.GetRefundByID(ctx, refundID)
@@ -542,6 +1401,13 @@ func (h *PaymentHandler) GetRefundDetails(c *gin.Context) {
 		return
 	}
 
+	refund.Splits, err = h.repo.ListRefundSplits(ctx, refund.RefundID)
+	if err != nil {
+		log.Printf("Failed to get refund splits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve refund"})
+		return
+	}
+
 	c.JSON(http.StatusOK, refund)
 }
 
@@ -566,16 +1432,32 @@ func (h *PaymentHandler) GetAllPayments(c *gin.Context) {
 		limit = 100
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	tags, err := parseTagFilter(c.QueryArray("tag"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
 	defer cancel()
 
-	payments, err := h.repo.GetAllPayments(ctx, limit, offset)
+	payments, err := h.repo.GetAllPayments(ctx, limit, offset, tags)
 	if err != nil {
 		log.Printf("Failed to get payments: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payments"})
 		return
 	}
 
+	validator := newListValidator()
+	for _, payment := range payments {
+		validator.add(payment, payment.UpdatedAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	setEnvelope(c, payments, &EnvelopeMeta{Pagination: &Pagination{Limit: limit, Offset: offset, Count: len(payments)}})
+
 	c.JSON(http.StatusOK, gin.H{
 		"payments": payments,
 		"limit":    limit,
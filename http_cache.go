@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheValidator builds the ETag and Last-Modified time of a read response from the
+// records it shows, so polling clients can revalidate their copy instead of fetching
+// an identical one. The ETag hashes each record as it is serialized, so it changes
+// with any field shown, whether or not the field's writer touched updated_at.
+type cacheValidator struct {
+	hash         hash.Hash
+	lastModified time.Time
+
+	// list is set for lists, whose records can leave them, as when a refund is
+	// approved, without changing the latest update time of those that remain
+	list bool
+}
+
+func newCacheValidator() *cacheValidator {
+	return &cacheValidator{hash: sha256.New()}
+}
+
+// newListValidator returns the validator of a list. Its Last-Modified is the latest
+// update of the records listed, but only its ETag, which follows which records are
+// listed, answers conditional requests.
+func newListValidator() *cacheValidator {
+	return &cacheValidator{hash: sha256.New(), list: true}
+}
+
+// add records a record of the response, in the order the response shows it, and the
+// time it last changed, zero when it is not known
+func (v *cacheValidator) add(record interface{}, modifiedAt time.Time) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		// The response cannot be rendered either; make sure the ETag matches nothing
+		data = []byte(err.Error() + time.Now().String())
+	}
+	v.hash.Write(data)
+	v.hash.Write([]byte{0})
+	if modifiedAt.After(v.lastModified) {
+		v.lastModified = modifiedAt
+	}
+}
+
+// ETag returns the weak entity tag of the records added so far. It is weak since it
+// follows the records rather than the bytes of the response, which also depend on the
+// API version and format.
+func (v *cacheValidator) ETag() string {
+	return `W/"` + hex.EncodeToString(v.hash.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the ETag, Last-Modified and Cache-Control headers of a response
+// and reports whether the request's If-None-Match, or If-Modified-Since when it has
+// none and the response is not a list, shows the client's copy is current, in which
+// case 304 Not Modified is sent and the handler must not write a body
+func notModified(c *gin.Context, validator *cacheValidator) bool {
+	etag := validator.ETag()
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if !validator.lastModified.IsZero() {
+		c.Header("Last-Modified", validator.lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	fresh := false
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		fresh = etagMatches(match, etag)
+	} else if since := c.GetHeader("If-Modified-Since"); since != "" && !validator.list && !validator.lastModified.IsZero() {
+		// Last-Modified has whole seconds, so changes within the second it names are
+		// only seen through the ETag
+		if t, err := http.ParseTime(since); err == nil {
+			fresh = !validator.lastModified.Truncate(time.Second).After(t)
+		}
+	}
+
+	if fresh {
+		c.Status(http.StatusNotModified)
+	}
+	return fresh
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing weakly as
+// GET requests do
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondPayment writes a payment, or 304 Not Modified when the client has it already
+func respondPayment(c *gin.Context, payment *Payment) {
+	validator := newCacheValidator()
+	validator.add(payment, payment.UpdatedAt)
+	if notModified(c, validator) {
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestConditionalPaymentResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	updatedAt := time.Date(2026, 3, 1, 10, 30, 15, 500, time.UTC)
+	payment := testPayment("order_cache")
+	payment.UpdatedAt = updatedAt
+
+	router := gin.New()
+	router.GET("/api/v1/payments/:order_id", func(c *gin.Context) { respondPayment(c, payment) })
+	router.GET("/api/v2/payments/:order_id", EnvelopeMiddleware(), func(c *gin.Context) { respondPayment(c, payment) })
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/api/v1/payments/order_cache", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.Regexp(t, `^W/"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "Sun, 01 Mar 2026 10:30:15 GMT", w.Header().Get("Last-Modified"))
+
+	for _, path := range []string{"/api/v1/payments/order_cache", "/api/v2/payments/order_cache"} {
+		w = get(path, map[string]string{"If-None-Match": `"other", ` + etag})
+		assert.Equal(t, http.StatusNotModified, w.Code, path)
+		assert.Empty(t, w.Body.String(), path)
+		assert.Equal(t, etag, w.Header().Get("ETag"), path)
+	}
+
+	w = get("/api/v1/payments/order_cache", map[string]string{"If-Modified-Since": "Sun, 01 Mar 2026 10:30:15 GMT"})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = get("/api/v1/payments/order_cache", map[string]string{"If-Modified-Since": "Sun, 01 Mar 2026 10:30:14 GMT"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// If-None-Match decides when both are sent
+	w = get("/api/v1/payments/order_cache", map[string]string{
+		"If-None-Match":     `W/"stale"`,
+		"If-Modified-Since": "Sun, 01 Mar 2026 10:30:15 GMT",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A new status changes the ETag even if the update time read with it has not
+	payment.Status = domain.PaymentPaid
+	w = get("/api/v1/payments/order_cache", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// So does any other field shown, written without touching updated_at
+	etag = w.Header().Get("ETag")
+	method := "upi"
+	payment.PaymentMethod = &method
+	w = get("/api/v1/payments/order_cache", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestConditionalPaymentList(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	handler := &PaymentHandler{repo: NewPaymentRepository(db)}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/payments", handler.GetAllPayments)
+	router.GET("/api/v2/payments", EnvelopeMiddleware(), handler.GetAllPayments)
+
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, orderID := range []string{"order_list_cache_1", "order_list_cache_2"} {
+		require.NoError(t, handler.repo.CreatePayment(ctx, testPayment(orderID)))
+	}
+
+	w := get("/payments", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+
+	assert.Equal(t, http.StatusNotModified, get("/payments", etag).Code)
+	assert.Equal(t, http.StatusNotModified, get("/api/v2/payments", etag).Code)
+
+	// Lists only answer If-Modified-Since with a full response
+	req := httptest.NewRequest(http.MethodGet, "/payments", nil)
+	req.Header.Set("If-Modified-Since", w.Header().Get("Last-Modified"))
+	listed := httptest.NewRecorder()
+	router.ServeHTTP(listed, req)
+	assert.Equal(t, http.StatusOK, listed.Code)
+
+	// Updating a listed payment changes the list's ETag
+	time.Sleep(time.Millisecond)
+	require.NoError(t, handler.repo.UpdatePaymentStatus(ctx, "order_list_cache_1", domain.PaymentPaid, nil, nil, nil))
+	w = get("/payments", etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
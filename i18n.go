@@ -0,0 +1,160 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/language"
+)
+
+// defaultLanguage is the language of the catalog used when a request's
+// Accept-Language matches none, and of the handlers' own messages
+const defaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// ErrorCatalog holds the human message of each v2 error code, by language
+type ErrorCatalog struct {
+	languages []string                     // defaultLanguage first
+	messages  map[string]map[string]string // language -> code -> message
+	matcher   language.Matcher
+}
+
+// errorCatalog is the catalog of the locales shipped with the service
+var errorCatalog = mustLoadErrorCatalog(localeFiles)
+
+// loadErrorCatalog reads a <language>.json file of code -> message pairs per language
+// from the locales directory of files. A defaultLanguage file is required.
+func loadErrorCatalog(files fs.FS) (*ErrorCatalog, error) {
+	names, err := fs.Glob(files, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := &ErrorCatalog{messages: make(map[string]map[string]string)}
+	for _, name := range names {
+		lang := strings.TrimSuffix(path.Base(name), ".json")
+		if _, err := language.Parse(lang); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		catalog.messages[lang] = messages
+		if lang != defaultLanguage {
+			catalog.languages = append(catalog.languages, lang)
+		}
+	}
+	if _, ok := catalog.messages[defaultLanguage]; !ok {
+		return nil, fmt.Errorf("no %s catalog", defaultLanguage)
+	}
+	sort.Strings(catalog.languages)
+	catalog.languages = append([]string{defaultLanguage}, catalog.languages...)
+
+	tags := make([]language.Tag, len(catalog.languages))
+	for i, lang := range catalog.languages {
+		tags[i] = language.Make(lang)
+	}
+	catalog.matcher = language.NewMatcher(tags)
+	return catalog, nil
+}
+
+func mustLoadErrorCatalog(files fs.FS) *ErrorCatalog {
+	catalog, err := loadErrorCatalog(files)
+	if err != nil {
+		panic(fmt.Sprintf("loading error catalog: %v", err))
+	}
+	return catalog
+}
+
+// Languages returns the catalog's languages, defaultLanguage first
+func (c *ErrorCatalog) Languages() []string {
+	return c.languages
+}
+
+// Negotiate picks the catalog language best matching an Accept-Language header,
+// falling back to defaultLanguage
+func (c *ErrorCatalog) Negotiate(acceptLanguage string) string {
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return defaultLanguage
+	}
+	_, index, confidence := c.matcher.Match(tags...)
+	if confidence == language.No {
+		return defaultLanguage
+	}
+	return c.languages[index]
+}
+
+// Message returns the message of an error code in a language
+func (c *ErrorCatalog) Message(lang, code string) (string, bool) {
+	message, ok := c.messages[lang][code]
+	return message, ok
+}
+
+// Messages returns every error code's message in a language
+func (c *ErrorCatalog) Messages(lang string) map[string]string {
+	return c.messages[lang]
+}
+
+// localizeError translates a v2 error's message into the language negotiated from the
+// request's Accept-Language header. English keeps the handler's own, more specific
+// message; codes missing from a catalog keep it too.
+func localizeError(c *gin.Context, envelopeErr *EnvelopeError) {
+	accept := c.GetHeader("Accept-Language")
+	if accept == "" {
+		return
+	}
+	c.Writer.Header().Add("Vary", "Accept-Language")
+
+	lang := errorCatalog.Negotiate(accept)
+	if lang == defaultLanguage {
+		c.Writer.Header().Set("Content-Language", defaultLanguage)
+		return
+	}
+	if message, ok := errorCatalog.Message(lang, envelopeErr.Code); ok {
+		envelopeErr.Message = message
+		c.Writer.Header().Set("Content-Language", lang)
+	}
+}
+
+// ErrorCatalogResponse lists the messages of the v2 error codes in one language
+type ErrorCatalogResponse struct {
+	Language  string            `json:"language" yaml:"language"`
+	Languages []string          `json:"languages" yaml:"languages"` // every language offered
+	Messages  map[string]string `json:"messages" yaml:"messages"`   // code -> message
+}
+
+// GetErrorCatalog returns the error messages in the language negotiated from
+// Accept-Language or chosen with ?lang=, for apps that resolve error codes themselves
+func GetErrorCatalog(c *gin.Context) {
+	lang := errorCatalog.Negotiate(c.GetHeader("Accept-Language"))
+	if requested := c.Query("lang"); requested != "" {
+		if _, ok := errorCatalog.messages[requested]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lang must be one of " + strings.Join(errorCatalog.Languages(), ", ")})
+			return
+		}
+		lang = requested
+	}
+
+	c.Header("Content-Language", lang)
+	c.Header("Vary", "Accept-Language")
+	c.JSON(http.StatusOK, ErrorCatalogResponse{
+		Language:  lang,
+		Languages: errorCatalog.Languages(),
+		Messages:  errorCatalog.Messages(lang),
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorCatalogCoversEveryCode(t *testing.T) {
+	codes := map[string]bool{ErrCodeNotAcceptable: true}
+	for _, code := range errorCodesByMessage {
+		codes[code] = true
+	}
+	for status := 400; status < 600; status++ {
+		codes[errorCodeForStatus(status)] = true
+	}
+
+	english := errorCatalog.Messages(defaultLanguage)
+	for code := range codes {
+		assert.Contains(t, english, code)
+	}
+	for _, lang := range errorCatalog.Languages() {
+		assert.Len(t, errorCatalog.Messages(lang), len(english), "%s has a message for every code", lang)
+		for code := range english {
+			assert.Contains(t, errorCatalog.Messages(lang), code, lang)
+		}
+	}
+}
+
+func TestErrorCatalogNegotiate(t *testing.T) {
+	assert.Equal(t, []string{"en", "hi"}, errorCatalog.Languages())
+	assert.Equal(t, "en", errorCatalog.Negotiate(""))
+	assert.Equal(t, "hi", errorCatalog.Negotiate("hi-IN"))
+	assert.Equal(t, "hi", errorCatalog.Negotiate("fr;q=0.9, hi;q=0.8"))
+	assert.Equal(t, "en", errorCatalog.Negotiate("en-IN,hi;q=0.5"))
+	assert.Equal(t, "en", errorCatalog.Negotiate("fr"))
+	assert.Equal(t, "en", errorCatalog.Negotiate(";;;"))
+}
+
+func TestLoadErrorCatalogRequiresDefaultLanguage(t *testing.T) {
+	_, err := loadErrorCatalog(fstest.MapFS{"locales/hi.json": {Data: []byte(`{}`)}})
+	assert.Error(t, err)
+
+	_, err = loadErrorCatalog(fstest.MapFS{"locales/en.json": {Data: []byte(`{`)}})
+	assert.Error(t, err)
+}
+
+func TestEnvelopeLocalizesErrors(t *testing.T) {
+	router := newEnvelopeRouter()
+
+	serve := func(acceptLanguage string) (*httptest.ResponseRecorder, Envelope) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v2/payments/missing", nil)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var envelope Envelope
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+		require.NotNil(t, envelope.Error)
+		return w, envelope
+	}
+
+	w, envelope := serve("hi-IN,en;q=0.5")
+	assert.Equal(t, ErrCodePaymentNotFound, envelope.Error.Code)
+	assert.Equal(t, "भुगतान नहीं मिला", envelope.Error.Message)
+	assert.Equal(t, "hi", w.Header().Get("Content-Language"))
+
+	// English keeps the handler's message
+	w, envelope = serve("en")
+	assert.Equal(t, "Payment not found", envelope.Error.Message)
+	assert.Equal(t, "en", w.Header().Get("Content-Language"))
+
+	w, envelope = serve("")
+	assert.Equal(t, "Payment not found", envelope.Error.Message)
+	assert.Empty(t, w.Header().Get("Content-Language"))
+}
+
+func TestGetErrorCatalog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/v2/errors", EnvelopeMiddleware(), GetErrorCatalog)
+
+	serve := func(target, acceptLanguage string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept-Language", acceptLanguage)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/api/v2/errors", "hi")
+	require.Equal(t, http.StatusOK, w.Code)
+	var envelope struct {
+		Data ErrorCatalogResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "hi", envelope.Data.Language)
+	assert.Equal(t, "ग्राहक अवरुद्ध है", envelope.Data.Messages[ErrCodeCustomerBlocked])
+
+	w = serve("/api/v2/errors?lang=en", "hi")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope))
+	assert.Equal(t, "en", envelope.Data.Language)
+
+	assert.Equal(t, http.StatusBadRequest, serve("/api/v2/errors?lang=xx", "").Code)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// istLocation is India Standard Time, used for financial years and invoice dates
+var istLocation = time.FixedZone("IST", 5*60*60+30*60)
+
+// InvoiceConfig holds the merchant details printed on tax invoices
+type InvoiceConfig struct {
+	Prefix            string // e.g. "INV"
+	MerchantGSTIN     string
+	MerchantStateCode string // two digit GST state code, e.g. "29" for Karnataka
+}
+
+// FinancialYear returns the Indian financial year (April to March) containing t, e.g. "2024-25"
+func FinancialYear(t time.Time) string {
+	t = t.In(istLocation)
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
+
+// FormatInvoiceNumber formats a sequence number as "<prefix>/<financial year>/<number>"
+func FormatInvoiceNumber(prefix, financialYear string, number int) string {
+	if prefix == "" {
+		prefix = "INV"
+	}
+	return fmt.Sprintf("%s/%s/%06d", prefix, financialYear, number)
+}
+
+// TaxBreakdown splits a tax-inclusive amount into taxable value and GST components
+type TaxBreakdown struct {
+	TaxableValue float64 `json:"taxable_value"`
+	CGST         float64 `json:"cgst"`
+	SGST         float64 `json:"sgst"`
+	IGST         float64 `json:"igst"`
+	TotalTax     float64 `json:"total_tax"`
+}
+
+// ComputeGST computes the GST included in amount at the given rate. Supplies within
+// the merchant's state are taxed as CGST + SGST, other supplies as IGST.
+func ComputeGST(amount, rate float64, placeOfSupply, merchantStateCode string) TaxBreakdown {
+	taxable := roundAmount(amount * 100 / (100 + rate))
+	tax := roundAmount(amount - taxable)
+
+	breakdown := TaxBreakdown{TaxableValue: taxable, TotalTax: tax}
+	if placeOfSupply == "" || placeOfSupply == merchantStateCode {
+		breakdown.CGST = roundAmount(tax / 2)
+		breakdown.SGST = roundAmount(tax - breakdown.CGST)
+	} else {
+		breakdown.IGST = tax
+	}
+
+	return breakdown
+}
+
+// paymentTax returns the GST breakdown of a payment, or nil if it carries no tax rate
+func paymentTax(p *Payment, merchantStateCode string) *TaxBreakdown {
+	if p.TaxRate == nil {
+		return nil
+	}
+
+	placeOfSupply := ""
+	if p.PlaceOfSupply != nil {
+		placeOfSupply = *p.PlaceOfSupply
+	}
+
+	breakdown := ComputeGST(p.Amount, *p.TaxRate, placeOfSupply, merchantStateCode)
+	return &breakdown
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// Receipt is a GST tax invoice for a paid order
+type Receipt struct {
+	InvoiceNumber string        `json:"invoice_number"`
+	InvoiceDate   time.Time     `json:"invoice_date"`
+	SupplierGSTIN string        `json:"supplier_gstin,omitempty"`
+	OrderID       string        `json:"order_id"`
+	CFPaymentID   *string       `json:"cf_payment_id,omitempty"`
+	PaymentMethod *string       `json:"payment_method,omitempty"`
+	CustomerName  string        `json:"customer_name"`
+	CustomerEmail string        `json:"customer_email"`
+	CustomerGSTIN *string       `json:"customer_gstin,omitempty"`
+	PlaceOfSupply *string       `json:"place_of_supply,omitempty"`
+	HSNCode       *string       `json:"hsn_code,omitempty"`
+	Description   *string       `json:"description,omitempty"`
+	Currency      string        `json:"currency"`
+	Amount        float64       `json:"amount"`
+	TaxRate       *float64      `json:"tax_rate,omitempty"`
+	Tax           *TaxBreakdown `json:"tax,omitempty"`
+	Items         []OrderItem   `json:"items,omitempty"`
+}
+
+// issueInvoice assigns an invoice number to a paid order, logging failures
+func (h *PaymentHandler) issueInvoice(ctx context.Context, orderID string) {
+	if _, err := h.repo.AssignInvoiceNumber(ctx, orderID, h.invoices.Prefix, h.now().In(istLocation)); err != nil {
+		log.Printf("Failed to assign invoice number for %s: %v", orderID, err)
+	}
+}
+
+// Gets the tax invoice for a paid payment
+func (h *PaymentHandler) GetPaymentReceipt(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get payment from database: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	if !payment.Status.Paid() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Receipt is only available for paid orders"})
+		return
+	}
+
+	items, err := h.repo.ListOrderItems(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get order items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate receipt"})
+		return
+	}
+
+	// Payments recorded before invoicing was enabled get their number on first request
+	if payment.InvoiceNumber == nil {
+		h.issueInvoice(ctx, orderID)
+		payment, err = h.repo.GetPaymentByOrderID(ctx, orderID)
+		if err != nil || payment.InvoiceNumber == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate receipt"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, Receipt{
+		InvoiceNumber: *payment.InvoiceNumber,
+		InvoiceDate:   *payment.InvoiceDate,
+		SupplierGSTIN: h.invoices.MerchantGSTIN,
+		OrderID:       payment.OrderID,
+		CFPaymentID:   payment.CFPaymentID,
+		PaymentMethod: payment.PaymentMethod,
+		CustomerName:  payment.CustomerName,
+		CustomerEmail: payment.CustomerEmail,
+		CustomerGSTIN: payment.GSTIN,
+		PlaceOfSupply: payment.PlaceOfSupply,
+		HSNCode:       payment.HSNCode,
+		Description:   payment.Description,
+		Currency:      payment.Currency,
+		Amount:        payment.Amount,
+		TaxRate:       payment.TaxRate,
+		Tax:           paymentTax(payment, h.invoices.MerchantStateCode),
+		Items:         items,
+	})
+}
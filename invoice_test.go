@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinancialYear(t *testing.T) {
+	assert.Equal(t, "2024-25", FinancialYear(time.Date(2024, 4, 1, 0, 0, 0, 0, istLocation)))
+	assert.Equal(t, "2024-25", FinancialYear(time.Date(2025, 3, 31, 23, 59, 0, 0, istLocation)))
+	assert.Equal(t, "2023-24", FinancialYear(time.Date(2024, 3, 31, 12, 0, 0, 0, istLocation)))
+	assert.Equal(t, "2099-00", FinancialYear(time.Date(2099, 6, 1, 0, 0, 0, 0, istLocation)))
+
+	// 31 March 19:00 UTC is already 1 April in India
+	assert.Equal(t, "2025-26", FinancialYear(time.Date(2025, 3, 31, 19, 0, 0, 0, time.UTC)))
+}
+
+func TestFormatInvoiceNumber(t *testing.T) {
+	assert.Equal(t, "INV/2024-25/000042", FormatInvoiceNumber("", "2024-25", 42))
+	assert.Equal(t, "SHOP/2024-25/000001", FormatInvoiceNumber("SHOP", "2024-25", 1))
+}
+
+func TestComputeGST(t *testing.T) {
+	intraState := ComputeGST(1180, 18, "29", "29")
+	assert.Equal(t, 1000.0, intraState.TaxableValue)
+	assert.Equal(t, 90.0, intraState.CGST)
+	assert.Equal(t, 90.0, intraState.SGST)
+	assert.Equal(t, 0.0, intraState.IGST)
+
+	interState := ComputeGST(1180, 18, "27", "29")
+	assert.Equal(t, 180.0, interState.IGST)
+	assert.Equal(t, 0.0, interState.CGST)
+
+	odd := ComputeGST(100, 18, "29", "29")
+	assert.Equal(t, 84.75, odd.TaxableValue)
+	assert.InDelta(t, 100, odd.TaxableValue+odd.CGST+odd.SGST, 0.001)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// newSecretBox creates the SecretBox of MERCHANT_ENCRYPTION_KEY with the versioned keys
+// of MERCHANT_ENCRYPTION_KEYS, encrypting new values with MERCHANT_ENCRYPTION_KEY_ID
+func newSecretBox(cfg *Config) (*SecretBox, error) {
+	box, err := NewSecretBox(cfg.MerchantEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("MERCHANT_ENCRYPTION_KEY: %v", err)
+	}
+
+	for i, entry := range cfg.MerchantEncryptionKeys {
+		id, key, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			// The entry holds a key, so it is not echoed
+			return nil, fmt.Errorf("MERCHANT_ENCRYPTION_KEYS: entry %d is not <key id>:<key>", i+1)
+		}
+		if err := box.AddKey(id, key); err != nil {
+			return nil, fmt.Errorf("MERCHANT_ENCRYPTION_KEYS: %v", err)
+		}
+	}
+
+	if err := box.UseKey(cfg.MerchantEncryptionKeyID); err != nil {
+		return nil, fmt.Errorf("MERCHANT_ENCRYPTION_KEY_ID: %v", err)
+	}
+	return box, nil
+}
+
+// encryptedColumns are the columns holding values encrypted with the SecretBox, keyed
+// by a UUID id column. Where limits them to the rows whose value is encrypted.
+var encryptedColumns = []struct {
+	Table, Column, Where string
+}{
+	{"merchants", "cf_client_secret", ""},
+	{"merchant_webhook_secrets", "secret", ""},
+	{"event_endpoints", "secret", "secret_encrypted"},
+}
+
+// KeyRotation is the progress of re-encrypting a column with a key, saved after every
+// batch so an interrupted rotation resumes after the last row it finished
+type KeyRotation struct {
+	KeyID       string     `json:"key_id"`
+	Table       string     `json:"table"`
+	Column      string     `json:"column"`
+	LastID      *uuid.UUID `json:"last_id,omitempty"`
+	Processed   int        `json:"processed"`
+	Rotated     int        `json:"rotated"` // processed rows that were on another key
+	Total       int        `json:"total"`
+	StartedAt   time.Time  `json:"started_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Name returns the table and column the rotation re-encrypts
+func (k *KeyRotation) Name() string {
+	return k.Table + "." + k.Column
+}
+
+const keyRotationColumns = `key_id, table_name, column_name, last_id, processed, rotated,
+			   total, started_at, updated_at, completed_at`
+
+func scanKeyRotation(row pgx.Row) (*KeyRotation, error) {
+	var rotation KeyRotation
+	err := row.Scan(
+		&rotation.KeyID, &rotation.Table, &rotation.Column, &rotation.LastID, &rotation.Processed,
+		&rotation.Rotated, &rotation.Total, &rotation.StartedAt, &rotation.UpdatedAt, &rotation.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &rotation, nil
+}
+
+// ListKeyRotations returns the progress of every rotation, newest first
+func ListKeyRotations(ctx context.Context, db *pgxpool.Pool) ([]KeyRotation, error) {
+	rows, err := db.Query(ctx, `
+		SELECT `+keyRotationColumns+`
+		FROM key_rotations
+		ORDER BY started_at DESC, table_name, column_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rotations []KeyRotation
+	for rows.Next() {
+		rotation, err := scanKeyRotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, *rotation)
+	}
+	return rotations, rows.Err()
+}
+
+// rotateColumn re-encrypts the values of a column that are not on the box's current
+// key, batchSize rows per transaction, reporting progress after each batch. It resumes
+// a rotation to the same key that was interrupted, and leaves a finished one alone
+// unless restart is set.
+func rotateColumn(ctx context.Context, db *pgxpool.Pool, box *SecretBox, table, column, where string, batchSize int, restart bool, progress func(*KeyRotation)) (*KeyRotation, error) {
+	keyID := box.CurrentKeyID()
+	filter := ""
+	if where != "" {
+		filter = " AND " + where
+	}
+
+	now := time.Now()
+	_, err := db.Exec(ctx, `
+		INSERT INTO key_rotations (key_id, table_name, column_name, started_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+		ON CONFLICT (key_id, table_name, column_name) DO NOTHING
+	`, keyID, table, column, now)
+	if err != nil {
+		return nil, err
+	}
+	if restart {
+		_, err = db.Exec(ctx, `
+			UPDATE key_rotations
+			SET last_id = NULL, processed = 0, rotated = 0, started_at = $4, updated_at = $4, completed_at = NULL
+			WHERE key_id = $1 AND table_name = $2 AND column_name = $3
+		`, keyID, table, column, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rotation, err := scanKeyRotation(db.QueryRow(ctx, `
+		SELECT `+keyRotationColumns+`
+		FROM key_rotations
+		WHERE key_id = $1 AND table_name = $2 AND column_name = $3
+	`, keyID, table, column))
+	if err != nil {
+		return nil, err
+	}
+	if rotation.CompletedAt != nil {
+		return rotation, nil
+	}
+
+	if err := db.QueryRow(ctx, `SELECT COUNT(*) FROM `+table+` WHERE TRUE`+filter).Scan(&rotation.Total); err != nil {
+		return nil, err
+	}
+
+	for {
+		done, err := rotateBatch(ctx, db, box, rotation, filter, batchSize)
+		if err != nil {
+			return rotation, err
+		}
+		if progress != nil {
+			progress(rotation)
+		}
+		if done {
+			return rotation, nil
+		}
+	}
+}
+
+// rotateBatch re-encrypts the next batch of a rotation and saves its progress in the
+// same transaction, reporting whether the column is done
+func rotateBatch(ctx context.Context, db *pgxpool.Pool, box *SecretBox, rotation *KeyRotation, filter string, batchSize int) (bool, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, `+rotation.Column+`
+		FROM `+rotation.Table+`
+		WHERE ($1::uuid IS NULL OR id > $1)`+filter+`
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE
+	`, rotation.LastID, batchSize)
+	if err != nil {
+		return false, err
+	}
+	type row struct {
+		id    uuid.UUID
+		value string
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.value); err != nil {
+			rows.Close()
+			return false, err
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	for _, r := range batch {
+		rotation.LastID = &r.id
+		rotation.Processed++
+		if KeyID(r.value) == rotation.KeyID {
+			continue
+		}
+
+		plaintext, err := box.Decrypt(r.value)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt %s of %s: %v", rotation.Name(), r.id, err)
+		}
+		encrypted, err := box.Encrypt(plaintext)
+		if err != nil {
+			return false, err
+		}
+		_, err = tx.Exec(ctx, `UPDATE `+rotation.Table+` SET `+rotation.Column+` = $2 WHERE id = $1`, r.id, encrypted)
+		if err != nil {
+			return false, err
+		}
+		rotation.Rotated++
+	}
+
+	done := len(batch) < batchSize
+	rotation.UpdatedAt = time.Now()
+	if done {
+		rotation.CompletedAt = &rotation.UpdatedAt
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE key_rotations
+		SET last_id = $4, processed = $5, rotated = $6, total = $7, updated_at = $8, completed_at = $9
+		WHERE key_id = $1 AND table_name = $2 AND column_name = $3
+	`, rotation.KeyID, rotation.Table, rotation.Column, rotation.LastID, rotation.Processed,
+		rotation.Rotated, rotation.Total, rotation.UpdatedAt, rotation.CompletedAt)
+	if err != nil {
+		return false, err
+	}
+	return done, tx.Commit(ctx)
+}
+
+// runRotateKeys re-encrypts every encrypted column with the key of
+// MERCHANT_ENCRYPTION_KEY_ID, or prints the progress of the rotations with -status
+func runRotateKeys(args []string, db *pgxpool.Pool, box *SecretBox) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	batchSize := flags.Int("batch", 100, "rows re-encrypted per transaction")
+	restart := flags.Bool("restart", false, "start over columns already rotated to the key, such as after instances wrote with the old key")
+	status := flags.Bool("status", false, "print the progress of the rotations and exit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *batchSize < 1 {
+		return fmt.Errorf("-batch must be positive, got %d", *batchSize)
+	}
+
+	ctx := context.Background()
+	if *status {
+		rotations, err := ListKeyRotations(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, rotation := range rotations {
+			state := "in progress"
+			if rotation.CompletedAt != nil {
+				state = "completed " + rotation.CompletedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%s %s: %d/%d rows, %d re-encrypted, %s\n",
+				keyName(rotation.KeyID), rotation.Name(), rotation.Processed, rotation.Total, rotation.Rotated, state)
+		}
+		return nil
+	}
+
+	fmt.Printf("Re-encrypting with %s\n", keyName(box.CurrentKeyID()))
+	for _, col := range encryptedColumns {
+		rotation, err := rotateColumn(ctx, db, box, col.Table, col.Column, col.Where, *batchSize, *restart, func(rotation *KeyRotation) {
+			fmt.Printf("%s: %d/%d rows, %d re-encrypted\n", rotation.Name(), rotation.Processed, rotation.Total, rotation.Rotated)
+		})
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", col.Table, col.Column, err)
+		}
+		fmt.Printf("%s: done, %d/%d rows, %d re-encrypted\n", rotation.Name(), rotation.Processed, rotation.Total, rotation.Rotated)
+	}
+	return nil
+}
+
+// keyName describes a key ID for the command's output
+func keyName(id string) string {
+	if id == "" {
+		return "MERCHANT_ENCRYPTION_KEY"
+	}
+	return "key " + id
+}
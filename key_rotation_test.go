@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateKeys(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	merchants := NewMerchantRepository(db, box)
+	var created []*Merchant
+	for _, name := range []string{"One", "Two", "Three"} {
+		apiKey, err := NewMerchantAPIKey()
+		require.NoError(t, err)
+		merchant := &Merchant{Name: name, CFClientID: "id", CFSecret: "secret_" + name, Environment: "TEST"}
+		require.NoError(t, merchants.CreateMerchant(ctx, merchant, apiKey))
+		created = append(created, merchant)
+	}
+	_, err = merchants.AddWebhookSecret(ctx, created[0].ID, "whsec_one")
+	require.NoError(t, err)
+
+	require.NoError(t, box.AddKey("v2", testEncryptionKey('v')))
+	require.NoError(t, box.UseKey("v2"))
+
+	// A rotation interrupted after its first batch resumes after the row it finished
+	interrupted, interrupt := context.WithCancel(ctx)
+	rotation, err := rotateColumn(interrupted, db, box, "merchants", "cf_client_secret", "", 2, false, func(*KeyRotation) {
+		interrupt()
+	})
+	require.Error(t, err)
+	assert.Equal(t, 2, rotation.Processed)
+	assert.Equal(t, 3, rotation.Total)
+
+	rotation, err = rotateColumn(ctx, db, box, "merchants", "cf_client_secret", "", 2, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rotation.Processed)
+	assert.Equal(t, 3, rotation.Rotated)
+	assert.NotNil(t, rotation.CompletedAt)
+
+	// A finished rotation is left alone unless restarted, which skips rows already on the key
+	again, err := rotateColumn(ctx, db, box, "merchants", "cf_client_secret", "", 2, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, rotation.UpdatedAt.Unix(), again.UpdatedAt.Unix())
+	again, err = rotateColumn(ctx, db, box, "merchants", "cf_client_secret", "", 2, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, again.Processed)
+	assert.Equal(t, 0, again.Rotated)
+
+	require.NoError(t, runRotateKeys([]string{"-batch", "10"}, db, box))
+
+	rows, err := db.Query(ctx, `
+		SELECT cf_client_secret FROM merchants
+		UNION ALL SELECT secret FROM merchant_webhook_secrets
+	`)
+	require.NoError(t, err)
+	defer rows.Close()
+	values := 0
+	for rows.Next() {
+		var value string
+		require.NoError(t, rows.Scan(&value))
+		assert.Equal(t, "v2", KeyID(value))
+		values++
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, 4, values)
+
+	// Merchants read back through a box that holds the new key
+	stored, err := merchants.GetMerchantByID(ctx, created[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "secret_Two", stored.CFSecret)
+	secrets, err := merchants.WebhookSecrets(ctx, created[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"whsec_one"}, secrets)
+
+	rotations, err := ListKeyRotations(ctx, db)
+	require.NoError(t, err)
+	assert.Len(t, rotations, len(encryptedColumns))
+}
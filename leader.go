@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// schedulerLockKey is the Postgres advisory lock held by the instance that runs
+// background jobs
+const schedulerLockKey int64 = 0x7061796d656e7473 // "payments"
+
+// Leadership tells whether this instance should run background jobs, so that jobs run
+// once across replicas
+type Leadership interface {
+	IsLeader(ctx context.Context) bool
+}
+
+// PostgresLeader elects one instance as leader with a session-level advisory lock. The
+// leader keeps the lock on a dedicated connection for as long as it lives; when it
+// stops or loses its connection, Postgres releases the lock and the next instance to
+// ask takes over.
+type PostgresLeader struct {
+	pool *pgxpool.Pool
+	key  int64
+
+	mu   sync.Mutex
+	conn *pgxpool.Conn // holds the lock while this instance leads
+}
+
+// NewPostgresLeader returns an elector competing for the lock key
+func NewPostgresLeader(pool *pgxpool.Pool, key int64) *PostgresLeader {
+	return &PostgresLeader{pool: pool, key: key}
+}
+
+// IsLeader reports whether this instance holds the lock, trying to take it if not.
+// Errors count as not leading, so no instance runs jobs while the database is down.
+func (l *PostgresLeader) IsLeader(ctx context.Context) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if l.conn != nil {
+		if err := l.conn.Ping(ctx); err == nil {
+			return true
+		}
+		log.Printf("Lost the scheduler lock connection; giving up leadership")
+		l.conn.Conn().Close(ctx)
+		l.conn.Release()
+		l.conn = nil
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		log.Printf("Failed to acquire a connection for the scheduler lock: %v", err)
+		return false
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&locked); err != nil {
+		log.Printf("Failed to try the scheduler lock: %v", err)
+		conn.Release()
+		return false
+	}
+	if !locked {
+		conn.Release()
+		return false
+	}
+
+	log.Printf("This instance now runs background jobs")
+	l.conn = conn
+	return true
+}
+
+// Close gives up leadership, releasing the lock and its connection
+func (l *PostgresLeader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := l.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, l.key); err != nil {
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+	l.conn = nil
+}
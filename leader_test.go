@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostgresLeader(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	key := rand.Int63()
+
+	first := NewPostgresLeader(db, key)
+	second := NewPostgresLeader(db, key)
+	t.Cleanup(first.Close)
+	t.Cleanup(second.Close)
+
+	assert.True(t, first.IsLeader(ctx))
+	assert.True(t, first.IsLeader(ctx), "the leader keeps the lock")
+	assert.False(t, second.IsLeader(ctx))
+
+	// When the leader stops, the next instance to ask takes over
+	first.Close()
+	assert.True(t, second.IsLeader(ctx))
+	assert.False(t, first.IsLeader(ctx))
+}
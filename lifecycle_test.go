@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+// TestPaymentLifecycle takes one order through every handler that touches it, with the
+// fake Cashfree server as the gateway, and checks the database after each step: create
+// → payment webhook → verify → partial refund → refund webhook → split → settlement
+// webhook.
+func TestPaymentLifecycle(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	gateway.Now = clock.Now
+
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		invoices:     InvoiceConfig{Prefix: "INV"},
+		clock:        clock,
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		return serve(req)
+	}
+	decode := func(w *httptest.ResponseRecorder, v interface{}) {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+	paymentRow := func() *Payment {
+		payment, err := handler.repo.GetPaymentByOrderID(ctx, "order_lifecycle")
+		require.NoError(t, err)
+		return payment
+	}
+
+	// Create
+	w := post("/payments/create-session", `{
+		"order_id": "order_lifecycle", "amount": 250, "currency": "INR", "customer_id": "cust_1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "9999999999"
+	}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	order, ok := gateway.Order("order_lifecycle")
+	require.True(t, ok)
+	payment := paymentRow()
+	assert.Equal(t, order.CFOrderID, payment.CFOrderID)
+	assert.True(t, clock.Now().Add(24*time.Hour).Equal(order.ExpiryTime), "order expiry %s", order.ExpiryTime)
+	assert.Equal(t, 250.0, payment.Amount)
+	assert.True(t, clock.Now().Equal(payment.CreatedAt))
+	assert.Nil(t, payment.CFPaymentID)
+	assert.Nil(t, payment.InvoiceNumber)
+
+	// Payment webhook
+	webhook, err := gateway.CompletePayment("order_lifecycle", "upi")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(webhook.Request("/webhook/cashfree")).Code)
+
+	order, _ = gateway.Order("order_lifecycle")
+	require.Len(t, order.Payments, 1)
+	payment = paymentRow()
+	assert.Equal(t, domain.PaymentSuccess, payment.Status)
+	require.NotNil(t, payment.CFPaymentID)
+	assert.Equal(t, order.Payments[0].CFPaymentID, *payment.CFPaymentID)
+	require.NotNil(t, payment.PaymentMethod)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+	require.NotNil(t, payment.PaymentTime)
+	assert.True(t, clock.Now().Equal(*payment.PaymentTime))
+	require.NotNil(t, payment.InvoiceNumber)
+	invoiceNumber := *payment.InvoiceNumber
+
+	// Verify agrees with the webhook and issues no second invoice number
+	var verified struct {
+		OrderStatus string `json:"order_status"`
+		CFPaymentID string `json:"cf_payment_id"`
+	}
+	decode(post("/payments/verify", `{"order_id": "order_lifecycle"}`), &verified)
+	assert.Equal(t, "PAID", verified.OrderStatus)
+
+	payment = paymentRow()
+	assert.Equal(t, domain.PaymentPaid, payment.Status)
+	assert.Equal(t, verified.CFPaymentID, *payment.CFPaymentID)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+	assert.Equal(t, invoiceNumber, *payment.InvoiceNumber)
+
+	// Partial refund
+	var refunded struct {
+		RefundID     string  `json:"refund_id"`
+		CFRefundID   string  `json:"cf_refund_id"`
+		RefundAmount float64 `json:"refund_amount"`
+		RefundStatus string  `json:"refund_status"`
+	}
+	clock.Advance(48 * time.Hour)
+	decode(post("/payments/order_lifecycle/refund", `{"amount": 100, "reason": "damaged"}`), &refunded)
+	assert.Equal(t, refundIDFor(nil, "order_lifecycle", "", clock.Now()), refunded.RefundID)
+	assert.Equal(t, 100.0, refunded.RefundAmount)
+
+	refund, err := handler.repo.GetRefundByID(ctx, refunded.RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, refunded.CFRefundID, refund.CFRefundID)
+	assert.Equal(t, payment.CFOrderID, refund.CFOrderID)
+	assert.Equal(t, 100.0, refund.Amount)
+	assert.Equal(t, refunded.RefundStatus, string(refund.Status))
+	assert.Nil(t, refund.ProcessedAt)
+
+	// Refund webhook
+	webhook, err = gateway.ProcessRefund("order_lifecycle", refunded.RefundID, "SUCCESS")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(webhook.Request("/webhook/cashfree")).Code)
+
+	refund, err = handler.repo.GetRefundByID(ctx, refunded.RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundSuccess, refund.Status)
+	assert.NotNil(t, refund.ProcessedAt)
+
+	// Split
+	var split struct {
+		SettlementID string `json:"settlement_id"`
+	}
+	decode(post("/payments/order_lifecycle/split", `{"splits": [{"vendor_id": "vendor_1", "amount": 100}]}`), &split)
+	require.NotEmpty(t, split.SettlementID)
+
+	var splits int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM split_settlements WHERE order_id = 'order_lifecycle' AND status = 'PENDING'`).Scan(&splits))
+	assert.Equal(t, 1, splits)
+
+	// Settlement webhook
+	clock.Advance(24 * time.Hour)
+	webhook, err = gateway.Settle("order_lifecycle", split.SettlementID, "UTR0001")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, serve(webhook.Request("/webhook/cashfree")).Code)
+
+	settlement, err := handler.repo.GetSettlementByID(ctx, split.SettlementID)
+	require.NoError(t, err)
+	assert.Equal(t, "order_lifecycle", settlement.OrderID)
+	assert.Equal(t, payment.CFOrderID, settlement.CFOrderID)
+	assert.Equal(t, 250.0, settlement.Amount)
+	assert.Equal(t, domain.SettlementSuccess, settlement.Status)
+	require.NotNil(t, settlement.UTR)
+	assert.Equal(t, "UTR0001", *settlement.UTR)
+	require.NotNil(t, settlement.SettledAt)
+	assert.True(t, clock.Now().Equal(*settlement.SettledAt))
+
+	// Final state: the payment is unchanged by the refund and settlement, and every
+	// webhook was logged against the order
+	final := paymentRow()
+	assert.Equal(t, domain.PaymentPaid, final.Status)
+	assert.Equal(t, *payment.CFPaymentID, *final.CFPaymentID)
+	assert.Equal(t, invoiceNumber, *final.InvoiceNumber)
+
+	rows, err := db.Query(ctx, `SELECT event_type FROM webhooks WHERE order_id = 'order_lifecycle' ORDER BY created_at`)
+	require.NoError(t, err)
+	var logged []string
+	for rows.Next() {
+		var eventType string
+		require.NoError(t, rows.Scan(&eventType))
+		logged = append(logged, eventType)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"PAYMENT_SUCCESS_WEBHOOK", "REFUND_STATUS_WEBHOOK", "SETTLEMENT_STATUS_WEBHOOK"}, logged)
+
+	// Settlement webhooks are delivered again on retry without a second record
+	require.Equal(t, http.StatusOK, serve(webhook.Request("/webhook/cashfree")).Code)
+	var settlements int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM settlements WHERE order_id = 'order_lifecycle'`).Scan(&settlements))
+	assert.Equal(t, 1, settlements)
+}
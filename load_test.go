@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/loadtest"
+)
+
+// loadBudgets are what create-session and webhook delivery must sustain for a sale,
+// against a gateway that answers at once, whatever the recorded baselines say
+var loadBudgets = map[string]loadtest.Budget{
+	"create-session": {MaxErrorRate: 0.001, MaxP95: 250 * time.Millisecond, MaxP99: 500 * time.Millisecond},
+	"webhook":        {MaxErrorRate: 0.001, MaxP95: 100 * time.Millisecond, MaxP99: 250 * time.Millisecond},
+}
+
+// loadEnvInt reads a positive integer load test setting
+func loadEnvInt(t *testing.T, key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	require.NoError(t, err, key)
+	require.Positive(t, n, key)
+	return n
+}
+
+// TestLoad measures create-session and webhook throughput and latency through the HTTP
+// stack, with the fake Cashfree server as the gateway and PostgreSQL in
+// TEST_DATABASE_URL. It runs only when LOADTEST=1. Each scenario must meet loadBudgets
+// and stay within LOADTEST_TOLERANCE (default 0.2) of its baseline in
+// testdata/loadtest_baselines.json, kept per request rate; run with -update to record
+// new baselines.
+func TestLoad(t *testing.T) {
+	if os.Getenv("LOADTEST") != "1" {
+		t.Skip("set LOADTEST=1 to run the load test")
+	}
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+
+	duration := time.Duration(loadEnvInt(t, "LOADTEST_DURATION", 10)) * time.Second
+	tolerance := 0.2
+	if value := os.Getenv("LOADTEST_TOLERANCE"); value != "" {
+		var err error
+		tolerance, err = strconv.ParseFloat(value, 64)
+		require.NoError(t, err, "LOADTEST_TOLERANCE")
+	}
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	// The handlers log every request
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	transport := &http.Transport{MaxIdleConnsPerHost: 1000}
+	t.Cleanup(transport.CloseIdleConnections)
+	httpClient := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	results := make(map[string]loadtest.Summary)
+	ctx := context.Background()
+	run := time.Now().UnixNano()
+	orderID := func(i int) string { return fmt.Sprintf("load_%d_%d", run, i) }
+
+	createSession := &loadtest.Attacker{
+		Client:   httpClient,
+		Rate:     loadEnvInt(t, "LOADTEST_CREATE_RATE", 50),
+		Duration: duration,
+	}
+	created := createSession.Attack(ctx, func(i int) (*http.Request, error) {
+		body := fmt.Sprintf(`{
+			"order_id": %q, "amount": 499, "currency": "INR", "customer_id": "cust_%d",
+			"customer_name": "Load Test", "customer_email": "load@example.com",
+			"customer_phone": "9999999999"
+		}`, orderID(i), i%100)
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/payments/create-session", bytes.NewBufferString(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, err
+	})
+	results[fmt.Sprintf("create-session@%drps", createSession.Rate)] = created.Summary()
+
+	// Every order created is paid, and the webhook phase delivers those webhooks again
+	// once they run out, as Cashfree's retries would
+	var webhooks []*cashfreetest.Webhook
+	for i := 0; i < created.Requests; i++ {
+		if webhook, err := gateway.CompletePayment(orderID(i), "upi"); err == nil {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	require.NotEmpty(t, webhooks, "no orders were created: %s", created.FirstError)
+
+	deliver := &loadtest.Attacker{
+		Client:   httpClient,
+		Rate:     loadEnvInt(t, "LOADTEST_WEBHOOK_RATE", 100),
+		Duration: duration,
+	}
+	delivered := deliver.Attack(ctx, func(i int) (*http.Request, error) {
+		webhook := webhooks[i%len(webhooks)]
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/webhook/cashfree", bytes.NewReader(webhook.Body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("x-webhook-signature", webhook.Signature)
+			req.Header.Set("x-webhook-timestamp", webhook.Timestamp)
+		}
+		return req, err
+	})
+	results[fmt.Sprintf("webhook@%drps", deliver.Rate)] = delivered.Summary()
+
+	path := filepath.Join("testdata", "loadtest_baselines.json")
+	baselines, err := loadtest.LoadBaselines(path)
+	require.NoError(t, err)
+
+	for scenario, summary := range results {
+		t.Logf("%s: %s", scenario, summary)
+
+		for _, problem := range summary.Check(loadBudgets[strings.Split(scenario, "@")[0]]) {
+			t.Errorf("%s: %s", scenario, problem)
+		}
+		if *updateGolden {
+			continue
+		}
+		if baseline, ok := baselines[scenario]; ok {
+			for _, problem := range summary.Regressions(baseline, tolerance) {
+				t.Errorf("%s: %s", scenario, problem)
+			}
+		} else {
+			t.Logf("%s: no baseline recorded, so only the budgets were checked; run with -update to record one", scenario)
+		}
+	}
+	if created.FirstError != "" || delivered.FirstError != "" {
+		t.Logf("first errors: create-session %q, webhook %q", created.FirstError, delivered.FirstError)
+	}
+
+	if *updateGolden {
+		for scenario, summary := range results {
+			baselines[scenario] = summary
+		}
+		require.NoError(t, loadtest.SaveBaselines(path, baselines))
+	}
+}
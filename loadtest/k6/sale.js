@@ -0,0 +1,113 @@
+// Sale traffic against a running instance, with the same scenarios and budgets as
+// TestLoad: payment sessions are created at a constant rate, then the signed
+// PAYMENT_SUCCESS_WEBHOOK of every order is delivered, repeating them once they run out
+// as Cashfree's retries would.
+//
+//   BASE_URL=https://staging.example.com CASHFREE_CLIENT_SECRET=... \
+//   LOADTEST_CREATE_RATE=50 LOADTEST_WEBHOOK_RATE=100 LOADTEST_DURATION=10 \
+//   k6 run loadtest/k6/sale.js
+//
+// MERCHANT_API_KEY is sent as X-API-Key when set, for multi-merchant deployments.
+import http from 'k6/http';
+import crypto from 'k6/crypto';
+import exec from 'k6/execution';
+import { check } from 'k6';
+
+const baseURL = __ENV.BASE_URL || 'http://localhost:8080';
+const apiKey = __ENV.MERCHANT_API_KEY || '';
+const webhookSecret = __ENV.CASHFREE_CLIENT_SECRET || '';
+const createRate = parseInt(__ENV.LOADTEST_CREATE_RATE || '50', 10);
+const webhookRate = parseInt(__ENV.LOADTEST_WEBHOOK_RATE || '100', 10);
+const duration = parseInt(__ENV.LOADTEST_DURATION || '10', 10);
+
+// Arrivals are constant whether or not earlier requests have returned, so a slow server
+// shows up as latency rather than as a lower request rate
+export const options = {
+  scenarios: {
+    create_session: {
+      executor: 'constant-arrival-rate',
+      exec: 'createSession',
+      rate: createRate,
+      timeUnit: '1s',
+      duration: `${duration}s`,
+      preAllocatedVUs: createRate,
+      maxVUs: 1000,
+    },
+    webhook: {
+      executor: 'constant-arrival-rate',
+      exec: 'deliverWebhook',
+      startTime: `${duration}s`,
+      rate: webhookRate,
+      timeUnit: '1s',
+      duration: `${duration}s`,
+      preAllocatedVUs: webhookRate,
+      maxVUs: 1000,
+    },
+  },
+  // The budgets of loadBudgets in load_test.go
+  thresholds: {
+    'http_req_failed{scenario:create_session}': ['rate<=0.001'],
+    'http_req_duration{scenario:create_session}': ['p(95)<=250', 'p(99)<=500'],
+    'http_req_failed{scenario:webhook}': ['rate<=0.001'],
+    'http_req_duration{scenario:webhook}': ['p(95)<=100', 'p(99)<=250'],
+  },
+};
+
+// setup names the run, so order IDs do not collide with earlier runs
+export function setup() {
+  if (!webhookSecret) {
+    throw new Error('CASHFREE_CLIENT_SECRET is required to sign webhooks');
+  }
+  return { run: Date.now() };
+}
+
+function orderID(data, i) {
+  return `load_${data.run}_${i}`;
+}
+
+export function createSession(data) {
+  const i = exec.scenario.iterationInTest;
+  const headers = { 'Content-Type': 'application/json' };
+  if (apiKey) {
+    headers['X-API-Key'] = apiKey;
+  }
+  const res = http.post(`${baseURL}/api/v1/payments/create-session`, JSON.stringify({
+    order_id: orderID(data, i),
+    amount: 499,
+    currency: 'INR',
+    customer_id: `cust_${i % 100}`,
+    customer_name: 'Load Test',
+    customer_email: 'load@example.com',
+    customer_phone: '9999999999',
+  }), { headers });
+  check(res, { 'session created': (r) => r.status === 200 });
+}
+
+// deliverWebhook sends the PAYMENT_SUCCESS_WEBHOOK Cashfree would for one of the orders
+// created, signed as Cashfree signs it: the base64 HMAC-SHA256 of the timestamp followed
+// by the body, keyed with the client secret
+export function deliverWebhook(data) {
+  const i = exec.scenario.iterationInTest % (createRate * duration);
+  const now = new Date();
+  const body = JSON.stringify({
+    type: 'PAYMENT_SUCCESS_WEBHOOK',
+    event_time: now.toISOString(),
+    data: {
+      order_id: orderID(data, i),
+      cf_payment_id: `load_payment_${data.run}_${i}`,
+      payment_status: 'SUCCESS',
+      payment_amount: 499,
+      payment_method: 'upi',
+      payment_time: now.toISOString(),
+    },
+  });
+  const timestamp = String(now.getTime());
+  const res = http.post(`${baseURL}/api/v1/webhook/cashfree`, body, {
+    headers: {
+      'Content-Type': 'application/json',
+      'x-webhook-signature': crypto.hmac('sha256', webhookSecret, timestamp + body, 'base64'),
+      'x-webhook-timestamp': timestamp,
+    },
+  });
+  check(res, { 'webhook accepted': (r) => r.status === 200 });
+}
@@ -0,0 +1,254 @@
+// Package loadtest sends HTTP requests at a constant rate and summarises their latency,
+// in the manner of vegeta, so load tests run as ordinary go tests against an in-process
+// server with a fake Cashfree behind it.
+package loadtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target builds the i-th request of an attack
+type Target func(i int) (*http.Request, error)
+
+// Attacker sends requests at a fixed rate whether or not earlier ones have returned, as
+// customers arriving during a sale do, so a slow server shows up as latency rather than
+// as a lower request rate
+type Attacker struct {
+	Client   *http.Client
+	Rate     int // requests per second
+	Duration time.Duration
+
+	// MaxInFlight bounds the requests outstanding at once. A request due while the bound
+	// is reached is dropped and counted as an error. Defaults to 1000.
+	MaxInFlight int
+}
+
+// Attack sends Rate × Duration requests built by target, spaced evenly, and waits for
+// them all to complete
+func (a *Attacker) Attack(ctx context.Context, target Target) *Result {
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxInFlight := a.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 1000
+	}
+
+	total := int(float64(a.Rate) * a.Duration.Seconds())
+	interval := time.Second / time.Duration(max(a.Rate, 1))
+
+	result := &Result{StatusCodes: make(map[int]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	inFlight := make(chan struct{}, maxInFlight)
+
+	start := time.Now()
+	for i := 0; i < total; i++ {
+		if wait := time.Until(start.Add(time.Duration(i) * interval)); wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			mu.Lock()
+			result.record(0, 0, errors.New("dropped: too many requests in flight"))
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+
+			status, latency, err := send(ctx, client, target, i)
+			mu.Lock()
+			result.record(status, latency, err)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	sort.Slice(result.latencies, func(i, j int) bool { return result.latencies[i] < result.latencies[j] })
+	return result
+}
+
+// send makes one request, reading the whole response so the connection is reused
+func send(ctx context.Context, client *http.Client, target Target, i int) (int, time.Duration, error) {
+	req, err := target(i)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	began := time.Now()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, time.Since(began), err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(began), err
+}
+
+// Result is the outcome of an attack
+type Result struct {
+	Requests    int
+	Errors      int         // transport errors, dropped requests and non-2xx responses
+	StatusCodes map[int]int // responses by status code
+	FirstError  string
+	Elapsed     time.Duration // from the first request to the last response
+
+	latencies []time.Duration // of every response, ascending
+}
+
+// record adds a request's outcome. The caller serialises calls.
+func (r *Result) record(status int, latency time.Duration, err error) {
+	r.Requests++
+	if status != 0 {
+		r.StatusCodes[status]++
+		r.latencies = append(r.latencies, latency)
+	}
+	if err == nil && status >= 300 {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		r.Errors++
+		if r.FirstError == "" {
+			r.FirstError = err.Error()
+		}
+	}
+}
+
+// Percentile returns the latency p percent of responses were at or under
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(r.latencies))+0.5) - 1
+	return r.latencies[min(max(rank, 0), len(r.latencies)-1)]
+}
+
+// Summary reduces the result to the figures baselines and budgets are kept in
+func (r *Result) Summary() Summary {
+	s := Summary{
+		Requests: r.Requests,
+		P50:      milliseconds(r.Percentile(50)),
+		P95:      milliseconds(r.Percentile(95)),
+		P99:      milliseconds(r.Percentile(99)),
+		Max:      milliseconds(r.Percentile(100)),
+	}
+	if r.Elapsed > 0 {
+		s.Throughput = float64(r.Requests-r.Errors) / r.Elapsed.Seconds()
+	}
+	if r.Requests > 0 {
+		s.ErrorRate = float64(r.Errors) / float64(r.Requests)
+	}
+	return s
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Summary is an attack's throughput and latency. Latencies are in milliseconds.
+type Summary struct {
+	Requests   int     `json:"requests"`
+	Throughput float64 `json:"throughput_rps"` // successful responses per second
+	ErrorRate  float64 `json:"error_rate"`
+	P50        float64 `json:"p50_ms"`
+	P95        float64 `json:"p95_ms"`
+	P99        float64 `json:"p99_ms"`
+	Max        float64 `json:"max_ms"`
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d requests, %.1f/s ok, %.2f%% errors, p50 %.1fms, p95 %.1fms, p99 %.1fms, max %.1fms",
+		s.Requests, s.Throughput, s.ErrorRate*100, s.P50, s.P95, s.P99, s.Max)
+}
+
+// Budget is the worst an endpoint may do and still be fit for a sale. Zero fields are
+// not checked.
+type Budget struct {
+	MinThroughput float64 // successful responses per second
+	MaxErrorRate  float64
+	MaxP95        time.Duration
+	MaxP99        time.Duration
+}
+
+// Check returns the ways s breaks the budget
+func (s Summary) Check(b Budget) []string {
+	var problems []string
+	if b.MinThroughput > 0 && s.Throughput < b.MinThroughput {
+		problems = append(problems, fmt.Sprintf("throughput %.1f/s is below %.1f/s", s.Throughput, b.MinThroughput))
+	}
+	if s.ErrorRate > b.MaxErrorRate {
+		problems = append(problems, fmt.Sprintf("error rate %.2f%% is above %.2f%%", s.ErrorRate*100, b.MaxErrorRate*100))
+	}
+	if b.MaxP95 > 0 && s.P95 > milliseconds(b.MaxP95) {
+		problems = append(problems, fmt.Sprintf("p95 %.1fms is above %s", s.P95, b.MaxP95))
+	}
+	if b.MaxP99 > 0 && s.P99 > milliseconds(b.MaxP99) {
+		problems = append(problems, fmt.Sprintf("p99 %.1fms is above %s", s.P99, b.MaxP99))
+	}
+	return problems
+}
+
+// Regressions returns the ways s is worse than baseline by more than tolerance, a
+// fraction: with 0.2, p95 and p99 may grow and throughput shrink by up to 20%
+func (s Summary) Regressions(baseline Summary, tolerance float64) []string {
+	var problems []string
+	if limit := baseline.Throughput * (1 - tolerance); s.Throughput < limit {
+		problems = append(problems, fmt.Sprintf("throughput %.1f/s is below baseline %.1f/s", s.Throughput, baseline.Throughput))
+	}
+	if limit := baseline.P95 * (1 + tolerance); s.P95 > limit {
+		problems = append(problems, fmt.Sprintf("p95 %.1fms is above baseline %.1fms", s.P95, baseline.P95))
+	}
+	if limit := baseline.P99 * (1 + tolerance); s.P99 > limit {
+		problems = append(problems, fmt.Sprintf("p99 %.1fms is above baseline %.1fms", s.P99, baseline.P99))
+	}
+	return problems
+}
+
+// LoadBaselines reads the baselines recorded at path, keyed by scenario. A missing file
+// holds no baselines.
+func LoadBaselines(path string) (map[string]Summary, error) {
+	baselines := make(map[string]Summary)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return baselines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &baselines); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return baselines, nil
+}
+
+// SaveBaselines writes baselines to path
+func SaveBaselines(path string, baselines map[string]Summary) error {
+	data, err := json.MarshalIndent(baselines, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
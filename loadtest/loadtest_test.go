@@ -0,0 +1,104 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttack(t *testing.T) {
+	var served atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if served.Add(1)%10 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	attacker := &Attacker{Rate: 200, Duration: 250 * time.Millisecond}
+	result := attacker.Attack(context.Background(), func(i int) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, server.URL, nil)
+	})
+
+	assert.Equal(t, 50, result.Requests)
+	assert.Equal(t, 5, result.Errors)
+	assert.Equal(t, map[int]int{http.StatusOK: 45, http.StatusServiceUnavailable: 5}, result.StatusCodes)
+	assert.Equal(t, "status 503", result.FirstError)
+	assert.GreaterOrEqual(t, result.Elapsed, 245*time.Millisecond)
+
+	summary := result.Summary()
+	assert.InDelta(t, 0.1, summary.ErrorRate, 1e-9)
+	assert.Greater(t, summary.Throughput, 0.0)
+	assert.LessOrEqual(t, summary.P50, summary.P99)
+}
+
+func TestAttackDropsRequestsBeyondMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	time.AfterFunc(200*time.Millisecond, func() { close(release) })
+
+	attacker := &Attacker{Rate: 100, Duration: 100 * time.Millisecond, MaxInFlight: 2}
+	result := attacker.Attack(context.Background(), func(i int) (*http.Request, error) {
+		return http.NewRequest(http.MethodGet, server.URL, nil)
+	})
+
+	assert.Equal(t, 10, result.Requests)
+	assert.Equal(t, 8, result.Errors)
+	assert.Equal(t, map[int]int{http.StatusOK: 2}, result.StatusCodes)
+}
+
+func TestPercentile(t *testing.T) {
+	result := &Result{}
+	for i := 1; i <= 100; i++ {
+		result.latencies = append(result.latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, result.Percentile(50))
+	assert.Equal(t, 95*time.Millisecond, result.Percentile(95))
+	assert.Equal(t, 100*time.Millisecond, result.Percentile(100))
+	assert.Equal(t, time.Millisecond, result.Percentile(0))
+	assert.Zero(t, (&Result{}).Percentile(99))
+}
+
+func TestBudgets(t *testing.T) {
+	summary := Summary{Requests: 1000, Throughput: 180, ErrorRate: 0.002, P95: 120, P99: 300}
+
+	assert.Empty(t, summary.Check(Budget{MinThroughput: 150, MaxErrorRate: 0.01, MaxP95: 200 * time.Millisecond}))
+	assert.Equal(t, []string{
+		"throughput 180.0/s is below 190.0/s",
+		"error rate 0.20% is above 0.10%",
+		"p99 300.0ms is above 250ms",
+	}, summary.Check(Budget{MinThroughput: 190, MaxErrorRate: 0.001, MaxP99: 250 * time.Millisecond}))
+
+	baseline := Summary{Throughput: 200, P95: 100, P99: 240}
+	assert.Equal(t, []string{"p95 120.0ms is above baseline 100.0ms", "p99 300.0ms is above baseline 240.0ms"},
+		summary.Regressions(baseline, 0.1))
+	assert.Empty(t, summary.Regressions(baseline, 0.25))
+}
+
+func TestBaselines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baselines.json")
+
+	baselines, err := LoadBaselines(path)
+	require.NoError(t, err)
+	assert.Empty(t, baselines)
+
+	baselines["webhook"] = Summary{Requests: 500, Throughput: 99.5, P50: 3.2, P95: 8.1, P99: 12.4, Max: 20}
+	require.NoError(t, SaveBaselines(path, baselines))
+
+	loaded, err := LoadBaselines(path)
+	require.NoError(t, err)
+	assert.Equal(t, baselines, loaded)
+}
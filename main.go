@@ -1,77 +1,484 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+	"payment-getway/notify"
+	"payment-getway/queue"
+	"payment-getway/storage"
 )
 
 func main() {
+	startedAt := SystemClock.Now()
+
+	// Print the status check constraints migrations.sql is kept in sync with
+	if len(os.Args) > 1 && os.Args[1] == "constraints" {
+		os.Stdout.WriteString(domain.ConstraintsSQL())
+		return
+	}
+
 	// Load environment variables
+	reloadEnv := envFileReloader()
 	if err := godotenv.Load(); err != nil {
 		log.Println("No .env file found")
 	}
 
+	// Load and validate configuration
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Settings reloadable on SIGHUP or through the admin API
+	runtimeSettings := NewRuntimeSettings(cfg.Runtime, reloadEnv)
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: runtimeSettings.LogLevel()})))
+	runtimeSettings.WatchSignals(context.Background())
+
+	// Fault injection for development, enabled by CHAOS_MODE
+	var faults *FaultInjector
+	if cfg.ChaosMode {
+		faults = NewFaultInjector()
+		log.Println("CHAOS_MODE enabled: faults can be injected through /admin/faults")
+	}
+
 	// Connect to database
-	connectDB()
+	connectDB(cfg.DatabaseURL, faults)
 	defer closeDB()
 
+	// Merchant accounts for multi-merchant deployments
+	merchantRepo := newMerchantRepository(cfg)
+
+	if len(os.Args) > 1 && os.Args[1] == "create-merchant" {
+		if merchantRepo == nil {
+			log.Fatal("MERCHANT_ENCRYPTION_KEY must be set to create merchants")
+		}
+		if err := runCreateMerchant(os.Args[2:], merchantRepo); err != nil {
+			log.Fatalf("create-merchant: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "add-webhook-secret" {
+		if merchantRepo == nil {
+			log.Fatal("MERCHANT_ENCRYPTION_KEY must be set to add webhook secrets")
+		}
+		if err := runAddWebhookSecret(os.Args[2:], merchantRepo); err != nil {
+			log.Fatalf("add-webhook-secret: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfill(os.Args[2:], dbPool, cfg, merchantRepo); err != nil {
+			log.Fatalf("backfill: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "anonymize" {
+		if err := runAnonymize(os.Args[2:], dbPool); err != nil {
+			log.Fatalf("anonymize: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if merchantRepo == nil {
+			log.Fatal("MERCHANT_ENCRYPTION_KEY must be set to rotate encryption keys")
+		}
+		if err := runRotateKeys(os.Args[2:], dbPool, merchantRepo.box); err != nil {
+			log.Fatalf("rotate-keys: %v", err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "seed" {
+		if err := runSeed(os.Args[2:], dbPool, merchantRepo); err != nil {
+			log.Fatalf("seed: %v", err)
+		}
+		return
+	}
+
 	// Initialize Gin router
 	r := gin.Default()
 
 	// Add CORS middleware
-	r.Use(CORSMiddleware())
+	r.Use(CORSMiddleware(runtimeSettings))
+
+	// Add per-client rate limiting
+	rateLimiter := NewRateLimiter(0, 0)
+	runtimeSettings.Subscribe(func(rc RuntimeConfig) {
+		rateLimiter.SetLimit(rc.RateLimitRPS, rc.RateLimitBurst)
+	})
+	r.Use(rateLimiter.Middleware())
+
+	// Initialize Cashfree clients, one per configured environment
+	cashfreeOptions := []CashfreeOption{WithAPI(cfg.CashfreeAPI), WithTimeouts(cfg.CashfreeTimeouts)}
+	if !cfg.CashfreeTransport.IsZero() {
+		transport, err := cfg.CashfreeTransport.Build()
+		if err != nil {
+			log.Fatalf("Failed to configure the Cashfree transport: %v", err)
+		}
+		cashfreeOptions = append(cashfreeOptions, WithHTTPTransport(transport))
+	}
+	cashfreeClients := make(map[string]*CashfreeClient)
+	for env, creds := range cfg.Cashfree {
+		cashfreeClients[env] = NewCashfreeClient(creds.ClientID, creds.ClientSecret, env, cashfreeOptions...)
+		faults.WrapClient(cashfreeClients[env])
+	}
+	cashfreeClient, ok := cashfreeClients[cfg.CashfreeEnvironment]
+	if !ok {
+		cashfreeClient = NewCashfreeClient("", "", cfg.CashfreeEnvironment, cashfreeOptions...)
+	}
+
+	// Check the credentials, which also sign webhooks, before the first customer needs them
+	var startupWarnings []string
+	if cfg.CashfreeStartupCheck != StartupCheckOff {
+		for _, problem := range checkCashfreeCredentials(cfg.Cashfree, cashfreeCredentialValidator(cashfreeOptions...)) {
+			if problem.Rejected() && cfg.CashfreeStartupCheck == StartupCheckFail {
+				log.Fatalf("Cashfree credentials check failed: %s (set CASHFREE_STARTUP_CHECK=warn to start anyway)", problem.Message)
+			}
+			log.Printf("Cashfree credentials check: %s", problem.Message)
+			startupWarnings = append(startupWarnings, problem.Message)
+		}
+	}
 
-	// Initialize Cashfree client
-	cashfreeClient := NewCashfreeClient(
-		os.Getenv("CASHFREE_CLIENT_ID"),
-		os.Getenv("CASHFREE_CLIENT_SECRET"),
-		os.Getenv("CASHFREE_ENVIRONMENT"), // "TEST" or "PROD"
-	)
+	// Initialize payment gateways, with Razorpay as an optional fallback
+	var fallbackGateway PaymentGateway
+	if cfg.RazorpayKeyID != "" {
+		fallbackGateway = NewRazorpayClient(cfg.RazorpayKeyID, cfg.RazorpayKeySecret)
+	}
+	gatewayRouter := NewGatewayRouter(cashfreeClient, fallbackGateway, cfg.Runtime.GatewayAutoFailover)
+	runtimeSettings.Subscribe(func(rc RuntimeConfig) {
+		gatewayRouter.SetAutoFailover(rc.GatewayAutoFailover)
+	})
 
 	// Initialize repository
 	paymentRepo := NewPaymentRepository(dbPool)
 
+	// Initialize event bus
+	bus := events.NewMemoryBus(newExternalPublisher(cfg))
+	defer bus.Close()
+
+	// Record every event so streaming consumers can resume from an offset
+	subscribeEventLog(bus, paymentRepo)
+
+	// Deliver events to the endpoints merchants configure, such as their ERP and OMS
+	var endpointSecrets *SecretBox
+	if merchantRepo != nil {
+		endpointSecrets = merchantRepo.box
+	}
+	eventEndpoints := NewEventEndpoints(paymentRepo, endpointSecrets, cfg.EventRetrySchedule)
+	eventEndpoints.Subscribe(bus)
+
+	// Subscribe email notifications
+	if emailNotifier := newEmailNotifier(cfg); emailNotifier != nil {
+		emailNotifier.SetConsents(&ConsentStore{repo: paymentRepo, required: cfg.NotificationConsentRequired})
+		emailNotifier.Subscribe(bus)
+	}
+
+	// Subscribe Slack notifications
+	if cfg.Slack.WebhookURL != "" {
+		notify.NewSlackNotifier(cfg.Slack).Subscribe(bus)
+	}
+
+	// Initialize task queue
+	taskQueue := newTaskQueue(cfg, dbPool)
+	defer taskQueue.Close()
+
 	// Initialize payment handler
 	paymentHandler := &PaymentHandler{
-		cashfree: cashfreeClient,
-		repo:     paymentRepo,
+		cashfree:  cashfreeClient,
+		repo:      paymentRepo,
+		publisher: bus,
+		tasks:     taskQueue,
+		invoices:  cfg.Invoices,
+		gateways:  gatewayRouter,
+
+		statusTokens: newStatusTokenIssuer(cfg),
+		environments: cashfreeClients,
+		orderURLs:    cfg.OrderURLs,
+		checkout:     cfg.Checkout,
+		fxRates:      NewFXRates(cfg.Runtime.FXReferenceRates),
+		clock:        SystemClock,
+
+		refundApprovalThreshold: cfg.RefundApprovalThreshold,
+		splitRecoveryEvents:     cfg.SplitRecoveryEvents,
+		paymentMethods:          cfg.AllowedPaymentMethods,
+		duplicateSessions:       cfg.DuplicateSessions,
+		fees:                    cfg.Fees,
+		risk:                    newRiskScreen(cfg.Risk, paymentRepo),
+		blocklist:               NewBlocklist(paymentRepo),
+		phoneRegion:             cfg.PhoneRegion,
+		statusChanges:           NewStatusChanges(),
+		attempts:                NewPaymentAttemptCache(cfg.PaymentAttemptCacheSize),
+		verifyThrottle:          NewVerifyThrottle(cfg.VerifyMinInterval),
+		verifyLatency:           NewVerifyLatency(),
+	}
+	if cfg.StatusCache.TTL > 0 {
+		paymentHandler.statusCache = NewStatusRefresher(paymentHandler, cfg.StatusCache)
+	}
+	runtimeSettings.Subscribe(func(rc RuntimeConfig) {
+		paymentHandler.fxRates.Set(rc.FXReferenceRates)
+	})
+	if merchantRepo != nil {
+		paymentHandler.clients = NewMerchantClientPool(merchantRepo)
+		paymentHandler.clients.faults = faults
+		paymentHandler.clients.options = cashfreeOptions
+		paymentHandler.merchants = merchantRepo
 	}
 
-	// Payment routes
-	api := r.Group("/api/v1")
+	// The resync command re-fetches orders through the payment handler, so it runs
+	// once the handler is configured
+	if len(os.Args) > 1 && os.Args[1] == "resync" {
+		if err := runResync(os.Args[2:], paymentHandler); err != nil {
+			log.Fatalf("resync: %v", err)
+		}
+		return
+	}
+
+	// The catch-up command applies what Cashfree changed while webhooks were missed
+	if len(os.Args) > 1 && os.Args[1] == "catch-up" {
+		if err := runCatchUp(os.Args[2:], paymentHandler, cfg); err != nil {
+			log.Fatalf("catch-up: %v", err)
+		}
+		return
+	}
+
+	// Initialize export handler
+	exportHandler := &ExportHandler{
+		repo:      paymentRepo,
+		merchants: merchantRepo,
+		ledgers:   cfg.Ledgers,
+		invoices:  cfg.Invoices,
+		location:  cfg.ReportLocation,
+		clock:     SystemClock,
+
+		verifyLatency: paymentHandler.verifyLatency,
+	}
+
+	// Initialize reconciliation handler
+	reconHandler := &ReconHandler{
+		repo:      paymentRepo,
+		cashfree:  paymentHandler.cashfreeFor,
+		merchants: merchantRepo,
+		location:  cfg.ReportLocation,
+		clock:     SystemClock,
+	}
+
+	// Initialize abandoned checkout reminders
+	reminderHandler := &ReminderHandler{
+		repo:      paymentRepo,
+		publisher: bus,
+		cfg:       cfg.Reminders,
+		location:  cfg.ReportLocation,
+		clock:     SystemClock,
+	}
+	preferenceHandler := &PreferenceHandler{repo: paymentRepo}
+
+	// Stop on SIGTERM, as sent by Kubernetes after the preStop hook, or on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Schedule background jobs
+	scheduler := NewScheduler(SystemClock)
+	jobLeader := NewPostgresLeader(dbPool, schedulerLockKey)
+	defer jobLeader.Close()
+	scheduler.SetLeadership(jobLeader)
+	if uploader := newReportUploader(cfg); uploader != nil {
+		scheduler.Register(exportHandler.DailyPaymentsReportJob(uploader, cfg.ReportScheduleHour, cfg.ReportLocation))
+		if merchantRepo != nil {
+			scheduler.Register(exportHandler.MerchantPaymentsReportJob(uploader, cfg.ReportScheduleHour))
+		}
+	}
+	if cfg.ReconEnabled {
+		if merchantRepo != nil {
+			scheduler.Register(reconHandler.MerchantReconciliationJob(cfg.ReconScheduleHour))
+		} else {
+			scheduler.Register(reconHandler.DailyReconciliationJob(cfg.ReconScheduleHour, cfg.ReportLocation))
+		}
+	}
+	if cfg.Reminders.Enabled {
+		scheduler.Register(reminderHandler.CheckoutReminderJob())
+	}
+	scheduler.Register(paymentHandler.ScheduledOrdersJob())
+	scheduler.Register(eventEndpoints.EventDeliveryJob())
+	var statusPoller *StatusPoller
+	if cfg.StatusPoller.Enabled {
+		statusPoller = NewStatusPoller(paymentHandler, cfg.StatusPoller)
+		scheduler.Register(statusPoller.StatusPollJob())
+	}
+	scheduler.Start(ctx)
+
+	paymentHandler.RegisterTasks(taskQueue)
+	if err := taskQueue.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start task queue: %v", err)
+	}
+
+	// Routes authenticated by signature or status token rather than a merchant API key
+	public := r.Group("/api/v1")
 	{
-		// Create payment session
-		api.POST("/payments/create-session", paymentHandler.CreatePaymentSession)
-		
-		// Verify payment
-		api.POST("/payments/verify", paymentHandler.VerifyPayment)
-		
-		// Get payment details
-		api.GET("/payments/:order_id", paymentHandler.GetPaymentDetails)
-		
-		// Refund payment
-		api.POST("/payments/:order_id/refund", paymentHandler.RefundPayment)
-		
-		// Cancel payment
-		api.POST("/payments/:order_id/cancel", paymentHandler.CancelPayment)
-		
-		// Split settlement
-		api.POST("/payments/:order_id/split", paymentHandler.CreateSplitSettlement)
-		
-		// Get settlement details
-		api.GET("/settlements/:settlement_id", paymentHandler.GetSettlementDetails)
-		
 		// Webhook handler
-		api.POST("/webhook/cashfree", paymentHandler.HandleWebhook)
+		public.POST("/webhook/cashfree", paymentHandler.HandleWebhook)
 		
-		// Get refund details
-		api.GET("/refunds/:refund_id", paymentHandler.GetRefundDetails)
+		// Webhook handler for a merchant account, verified with that merchant's secret
+		public.POST("/webhook/cashfree/:merchant_id", paymentHandler.HandleWebhook)
 		
-		// Get all payments
-		api.GET("/payments", paymentHandler.GetAllPayments)
+		// Order status for the holder of a status token
+		public.GET("/status", paymentHandler.GetOrderStatusByToken)
+		
+		// Server-sent status updates for the holder of a status token
+		public.GET("/status/stream", paymentHandler.StreamOrderStatus)
+	}
+
+	// Per-merchant rate limits and daily quotas, so one merchant cannot exhaust the
+	// capacity shared by all of them
+	var quotas *TenantQuotas
+	tenantLimiter := NewRateLimiter(0, 0)
+	if cfg.MultiMerchant {
+		quotas = NewTenantQuotas(dbPool, cfg.ReportLocation, SystemClock)
+		runtimeSettings.Subscribe(func(rc RuntimeConfig) {
+			tenantLimiter.SetLimit(rc.TenantRateLimitRPS, rc.TenantRateLimitBurst)
+		})
+	}
+
+	// Payment routes
+	api := r.Group("/api/v1", CashfreeEnvironmentMiddleware())
+	if cfg.MultiMerchant {
+		api.Use(MerchantAuthMiddleware(merchantRepo), tenantLimiter.KeyedMiddleware(merchantRateLimitKey))
+	}
+	registerPaymentRoutes(api, paymentHandler, exportHandler, quotas)
+	registerReconRoutes(api, reconHandler)
+	registerReminderRoutes(api, reminderHandler)
+	registerPreferenceRoutes(api, preferenceHandler)
+	registerEventEndpointRoutes(api, eventEndpoints)
+
+	// Simulated payments for TEST orders, so frontends can be built without a sandbox
+	// checkout
+	if testModeEnabled(cfg) {
+		api.POST("/test/payments/:order_id/complete", paymentHandler.CompleteTestPayment)
+		api.POST("/test/payments/:order_id/fail", paymentHandler.FailTestPayment)
+	}
+
+	// v2 serves the same payment routes with enveloped JSON or YAML responses
+	v2 := r.Group("/api/v2", EnvelopeMiddleware(), CashfreeEnvironmentMiddleware())
+	if cfg.MultiMerchant {
+		v2.Use(MerchantAuthMiddleware(merchantRepo), tenantLimiter.KeyedMiddleware(merchantRateLimitKey))
+	}
+	registerPaymentRoutes(v2, paymentHandler, exportHandler, quotas)
+	registerReconRoutes(v2, reconHandler)
+	registerReminderRoutes(v2, reminderHandler)
+	registerPreferenceRoutes(v2, preferenceHandler)
+	registerEventEndpointRoutes(v2, eventEndpoints)
+
+	// Order status for the holder of a status token
+	r.GET("/api/v2/status", EnvelopeMiddleware(), paymentHandler.GetOrderStatusByToken)
+	
+	// Messages of the v2 error codes, by language
+	r.GET("/api/v2/errors", EnvelopeMiddleware(), GetErrorCatalog)
+
+	// Administration routes, enabled by ADMIN_API_KEY
+	if cfg.AdminAPIKey != "" {
+		admin := r.Group("/admin", AdminAuthMiddleware(cfg.AdminAPIKey))
+		{
+			// Reload runtime configuration
+			admin.POST("/config/reload", runtimeSettings.ReloadHandler)
+		}
+
+		// Customer and card blocklist
+		blocklistAdmin := &BlocklistHandler{repo: paymentRepo}
+		blocklist := admin.Group("/blocklist")
+		{
+			blocklist.POST("", blocklistAdmin.CreateEntry)
+			blocklist.GET("", blocklistAdmin.ListEntries)
+			blocklist.GET("/matches", blocklistAdmin.ListMatches)
+			blocklist.GET("/:id", blocklistAdmin.GetEntry)
+			blocklist.PATCH("/:id", blocklistAdmin.UpdateEntry)
+			blocklist.DELETE("/:id", blocklistAdmin.DeleteEntry)
+		}
+
+		// Fault injection, available with CHAOS_MODE
+		if faults != nil {
+			admin.GET("/faults", faults.FaultsHandler)
+			admin.PUT("/faults/:target", faults.SetFaultHandler)
+			admin.DELETE("/faults", faults.ClearFaultsHandler)
+		}
+
+		// Operational counters for dashboards and post-deploy smoke checks
+		adminStats := &AdminStatsHandler{
+			repo:    paymentRepo,
+			pool:    dbPool,
+			clients: cashfreeClients,
+			tasks:   taskQueue,
+			clock:   SystemClock,
+
+			scheduler: scheduler,
+			poller:    statusPoller,
+			startedAt: startedAt,
+		}
+		adminAPI := r.Group("/api/v1/admin", AdminAuthMiddleware(cfg.AdminAPIKey))
+		{
+			adminAPI.GET("/stats", adminStats.GetStats)
+			adminAPI.GET("/runtime", adminStats.GetRuntime)
+			adminAPI.POST("/resync", paymentHandler.ResyncOrders)
+
+			// Check rotated Cashfree credentials before activating them
+			credentials := &CredentialsHandler{validate: cashfreeCredentialValidator(cashfreeOptions...)}
+			adminAPI.POST("/credentials/validate", credentials.ValidateCredentials)
+
+			// Find which configured secret, if any, signed a webhook
+			adminAPI.POST("/webhooks/signature-check", paymentHandler.DebugWebhookSignature)
+
+			// Every order's domain events in sequence, for rebuilding projections
+			eventStore := &EventStreamHandler{repo: paymentRepo}
+			adminAPI.GET("/events/store", eventStore.ReadEventStore)
+		}
+
+		// Admin UI for searching payments, order timelines, webhook replays and refunds
+		registerAdminConsoleRoutes(admin, &AdminConsoleHandler{
+			repo:      paymentRepo,
+			payments:  paymentHandler,
+			merchants: merchantRepo,
+		})
+
+		// Merchant administration, available with MERCHANT_ENCRYPTION_KEY
+		if merchantRepo != nil {
+			merchantAdmin := NewMerchantAdminHandler(merchantRepo)
+			merchantAdmin.validateCredentials = cashfreeCredentialValidator(cashfreeOptions...)
+			merchants := admin.Group("/merchants")
+			{
+				merchants.POST("", merchantAdmin.CreateMerchant)
+				merchants.GET("", merchantAdmin.ListMerchants)
+				merchants.GET("/:merchant_id", merchantAdmin.GetMerchant)
+				merchants.PATCH("/:merchant_id", merchantAdmin.UpdateMerchant)
+				merchants.POST("/:merchant_id/deactivate", merchantAdmin.DeactivateMerchant)
+				merchants.POST("/:merchant_id/activate", merchantAdmin.ActivateMerchant)
+				merchants.GET("/:merchant_id/webhook-secrets", merchantAdmin.ListWebhookSecrets)
+				merchants.POST("/:merchant_id/webhook-secrets", merchantAdmin.AddWebhookSecret)
+				merchants.DELETE("/:merchant_id/webhook-secrets/:secret_id", merchantAdmin.RevokeWebhookSecret)
+				merchants.GET("/:merchant_id/audit-log", merchantAdmin.GetAuditLog)
+			}
+		}
 	}
 
 	// Health check
@@ -79,24 +486,183 @@ func main() {
 		c.JSON(200, gin.H{"status": "OK", "service": "Cashfree Payment Gateway"})
 	})
 
+	// Probes for orchestrators: startup waits for the database and migrations, and
+	// readiness fails while the instance drains for a shutdown
+	lifecycle := NewLifecycle(lifecycleChecks(dbPool, taskQueue, cashfreeClients)...)
+	for _, warning := range startupWarnings {
+		lifecycle.Degrade(warning)
+	}
+	r.GET("/livez", lifecycle.Livez)
+	r.GET("/startupz", lifecycle.Startupz)
+	r.GET("/readyz", lifecycle.Readyz)
+	go lifecycle.WaitForStartup(ctx, 2*time.Second)
+
 	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	go func() {
+		log.Printf("Server starting on port %s", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Event stream for internal consumers over gRPC, resumable by offset
+	var eventStream *grpc.Server
+	if cfg.AdminAPIKey != "" {
+		listener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			log.Fatalf("Failed to listen for gRPC on port %s: %v", cfg.GRPCPort, err)
+		}
+		eventStream = NewEventStreamServer(paymentRepo, cfg.AdminAPIKey)
+		go func() {
+			log.Printf("gRPC event stream starting on port %s", cfg.GRPCPort)
+			if err := eventStream.Serve(listener); err != nil {
+				log.Fatal("Failed to start gRPC server:", err)
+			}
+		}()
 	}
-	
-	log.Printf("Server starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+
+	<-ctx.Done()
+	stop()
+
+	// Keep serving while load balancers stop routing here, then finish requests in flight
+	log.Printf("Shutting down: draining for %s", cfg.ShutdownDrainDelay)
+	lifecycle.Drain()
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown did not complete: %v", err)
+	}
+	// Subscriptions never finish on their own; consumers resume from their last offset
+	if eventStream != nil {
+		eventStream.Stop()
+	}
+	scheduler.Wait()
+	log.Printf("Server stopped")
+}
+
+// newMerchantRepository creates the merchant store when MERCHANT_ENCRYPTION_KEY is set,
+// or returns nil in single-merchant deployments
+func newMerchantRepository(cfg *Config) *MerchantRepository {
+	if cfg.MerchantEncryptionKey == "" {
+		return nil
+	}
+
+	box, err := newSecretBox(cfg)
+	if err != nil {
+		log.Fatalf("Invalid merchant encryption keys: %v", err)
+	}
+
+	return NewMerchantRepository(dbPool, box)
+}
+
+// newStatusTokenIssuer creates the status token issuer. Without STATUS_TOKEN_SECRET a
+// random secret is generated, so tokens do not survive restarts or work across instances.
+func newStatusTokenIssuer(cfg *Config) *StatusTokenIssuer {
+	secret := cfg.StatusTokenSecret
+	if secret == "" {
+		log.Printf("STATUS_TOKEN_SECRET is not set; generating a per-process secret")
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("Failed to generate status token secret: %v", err)
+		}
+		secret = hex.EncodeToString(key)
+	}
+
+	return NewStatusTokenIssuer(secret, cfg.StatusTokenTTL)
+}
+
+// newExternalPublisher creates the external broker selected by EVENT_BUS ("nats"),
+// or returns nil when events are only delivered in-process
+func newExternalPublisher(cfg *Config) events.Publisher {
+	if cfg.EventBus != "nats" {
+		return nil
+	}
+
+	publisher, err := events.NewNATSPublisher(cfg.NATS)
+	if err != nil {
+		log.Fatalf("Failed to initialize NATS event publisher: %v", err)
+	}
+	log.Println("Publishing events to NATS JetStream")
+	return publisher
+}
+
+// newEmailNotifier creates the email notifier selected by EMAIL_PROVIDER ("smtp" or "ses"),
+// or returns nil when email notifications are disabled
+func newEmailNotifier(cfg *Config) *notify.EmailNotifier {
+	var mailer notify.Mailer
+	switch cfg.EmailProvider {
+	case "smtp":
+		mailer = notify.NewSMTPMailer(cfg.SMTP)
+	case "ses":
+		mailer = notify.NewSESMailer(cfg.SESRegion, cfg.SMTP)
+	default:
+		return nil
+	}
+
+	notifier, err := notify.NewEmailNotifier(mailer, cfg.Email)
+	if err != nil {
+		log.Fatalf("Failed to initialize email notifications: %v", err)
+	}
+
+	return notifier
+}
+
+// newReportUploader creates the report storage selected by REPORT_STORAGE ("s3" or "gcs"),
+// or returns nil when scheduled report delivery is disabled
+func newReportUploader(cfg *Config) storage.Uploader {
+	switch cfg.ReportStorage {
+	case "s3":
+		uploader, err := storage.NewS3Uploader(context.Background(), cfg.Reports)
+		if err != nil {
+			log.Fatalf("Failed to initialize S3 report storage: %v", err)
+		}
+		return uploader
+	case "gcs":
+		return storage.NewGCSUploader(cfg.Reports, cfg.GCSHMACAccessKey, cfg.GCSHMACSecret)
+	default:
+		return nil
+	}
+}
+
+// newTaskQueue creates the task queue selected by QUEUE_BACKEND ("memory", "postgres"
+// or "rabbitmq")
+func newTaskQueue(cfg *Config, db *pgxpool.Pool) queue.Queue {
+	switch cfg.QueueBackend {
+	case "postgres":
+		log.Println("Dispatching async tasks through Postgres")
+		return queue.NewPostgresQueue(db, cfg.PostgresQueue)
+	case "rabbitmq":
+		q, err := queue.NewRabbitMQQueue(cfg.RabbitMQ)
+		if err != nil {
+			log.Fatalf("Failed to initialize RabbitMQ queue: %v", err)
+		}
+		log.Println("Dispatching async tasks through RabbitMQ")
+		return q
+	default:
+		return queue.NewWorkerPool(cfg.QueueWorkers, 0)
 	}
 }
 
-// CORSMiddleware handles CORS headers
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware handles CORS headers for the origins allowed by CORS_ALLOWED_ORIGINS.
+// With nil settings every origin is allowed.
+func CORSMiddleware(settings *RuntimeSettings) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		origins := []string{"*"}
+		if settings != nil {
+			origins = settings.Get().CORSOrigins
+		}
+
+		if origin := allowedOrigin(origins, c.GetHeader("Origin")); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+			if origin != "*" {
+				c.Header("Vary", "Origin")
+			}
+		}
 		c.Header("Access-Control-Allow-Credentials", "true")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-API-Key")
 		c.Header("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
@@ -107,3 +673,142 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// allowedOrigin returns the Access-Control-Allow-Origin value for a request origin,
+// or "" when the origin is not allowed
+func allowedOrigin(allowed []string, origin string) string {
+	for _, a := range allowed {
+		if a == "*" {
+			return "*"
+		}
+		if origin != "" && strings.EqualFold(a, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// AdminAuthMiddleware requires the ADMIN_API_KEY in the X-Admin-Key header, or as the
+// password of HTTP basic auth so browsers can open the admin UI
+func AdminAuthMiddleware(adminKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-Admin-Key")
+		if key == "" {
+			_, key, _ = c.Request.BasicAuth()
+		}
+		if key == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			c.Header("WWW-Authenticate", `Basic realm="admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin key"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// registerPaymentRoutes registers the merchant-authenticated payment routes shared by
+// the v1 and v2 APIs
+func registerPaymentRoutes(group *gin.RouterGroup, paymentHandler *PaymentHandler, exportHandler *ExportHandler, quotas *TenantQuotas) {
+	// Create payment session
+	group.POST("/payments/create-session", quotas.OrderQuotaMiddleware(), paymentHandler.CreatePaymentSession)
+	
+	// Verify payment
+	group.POST("/payments/verify", paymentHandler.VerifyPayment)
+	
+	// Queue verification for a batch of orders
+	group.POST("/payments/bulk-verify", paymentHandler.BulkVerifyPayments)
+	
+	// Get payment details
+	group.GET("/payments/:order_id", paymentHandler.GetPaymentDetails)
+	
+	// Get payment details by Cashfree's payment ID
+	group.GET("/payments/by-cf-payment/:cf_payment_id", paymentHandler.GetPaymentByCFPaymentID)
+	
+	// Update payment tags and notes
+	group.PATCH("/payments/:order_id/meta", paymentHandler.UpdatePaymentMeta)
+	
+	// Get the order's domain events
+	group.GET("/payments/:order_id/events", paymentHandler.GetOrderEvents)
+	
+	// Get the recorded attempts to pay the order
+	group.GET("/payments/:order_id/attempts", paymentHandler.GetPaymentAttempts)
+	
+	// Mint a short-lived status token for the browser
+	group.POST("/payments/:order_id/status-token", paymentHandler.CreateStatusToken)
+	
+	// Get GST receipt for a paid payment
+	group.GET("/payments/:order_id/receipt", paymentHandler.GetPaymentReceipt)
+	
+	// Refund payment
+	group.POST("/payments/:order_id/refund", quotas.RefundQuotaMiddleware(), paymentHandler.RefundPayment)
+	
+	// Get the amount left to refund
+	group.GET("/payments/:order_id/refundable", paymentHandler.GetRefundableAmount)
+	
+	// Check whether a payment can be refunded instantly
+	group.GET("/payments/:order_id/instant-refund-eligibility", paymentHandler.GetInstantRefundEligibility)
+	
+	// Cancel payment
+	group.POST("/payments/:order_id/cancel", paymentHandler.CancelPayment)
+	
+	// Terminate an unpaid Cashfree order
+	group.POST("/payments/:order_id/terminate", paymentHandler.TerminatePayment)
+	
+	// Get what the Cashfree SDK needs to open the drop-in checkout
+	group.GET("/checkout/:order_id/config", paymentHandler.GetCheckoutConfig)
+	
+	// Get UPI intent links for paying from a mobile app
+	group.POST("/payments/:order_id/upi-intent", paymentHandler.CreateUPIIntent)
+	
+	// Split settlement
+	group.POST("/payments/:order_id/split", paymentHandler.CreateSplitSettlement)
+	
+	// Get a vendor's net position across split settlements and refunds
+	group.GET("/vendors/:vendor_id/balance", paymentHandler.GetVendorBalance)
+	
+	// Get a vendor's settlement statement
+	group.GET("/vendors/:vendor_id/settlements", exportHandler.GetVendorSettlements)
+	
+	// Get the settlements of a bank credit with their orders and splits
+	group.GET("/settlements/by-utr/:utr", paymentHandler.GetSettlementsByUTR)
+	
+	// Get settlement details
+	group.GET("/settlements/:settlement_id", paymentHandler.GetSettlementDetails)
+	
+	// List refunds waiting for approval
+	group.GET("/refunds/pending-approval", paymentHandler.ListPendingRefunds)
+	
+	// Get refund details
+	group.GET("/refunds/:refund_id", paymentHandler.GetRefundDetails)
+	
+	// Approve or reject a refund waiting for approval
+	group.POST("/refunds/:refund_id/approve", paymentHandler.ApproveRefund)
+	group.POST("/refunds/:refund_id/reject", paymentHandler.RejectRefund)
+	
+	// Get the approval audit log of a refund
+	group.GET("/refunds/:refund_id/audit", paymentHandler.GetRefundAuditLog)
+	
+	// List payments held for manual risk review
+	group.GET("/risk/reviews", paymentHandler.ListRiskReviews)
+	
+	// Approve or reject a payment held for review
+	group.POST("/risk/reviews/:order_id/approve", paymentHandler.ApproveRiskReview)
+	group.POST("/risk/reviews/:order_id/reject", paymentHandler.RejectRiskReview)
+	
+	// Get all payments
+	group.GET("/payments", paymentHandler.GetAllPayments)
+	
+	// Accounting export (Tally XML / Zoho Books CSV)
+	group.GET("/exports/accounting", exportHandler.ExportAccounting)
+	
+	// Payments CSV export
+	group.GET("/exports/payments", exportHandler.ExportPayments)
+	
+	// Settlements CSV export, with each settlement's net breakdown
+	group.GET("/exports/settlements", exportHandler.ExportSettlements)
+	
+	// Gateway charges by currency and payment method
+	group.GET("/analytics/gateway-charges", exportHandler.GetGatewayCharges)
+	
+	// Webhook, verify and refund latencies of the merchant's orders
+	group.GET("/analytics/sla", exportHandler.GetSLAStats)
+}
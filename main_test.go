@@ -36,7 +36,7 @@ func TestCORSMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
 	router := gin.Default()
-	router.Use(CORSMiddleware())
+	router.Use(CORSMiddleware(nil))
 	router.GET("/test", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "test"})
 	})
@@ -54,7 +54,7 @@ func TestCORSOptionsRequest(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	
 	router := gin.Default()
-	router.Use(CORSMiddleware())
+	router.Use(CORSMiddleware(nil))
 	router.OPTIONS("/test", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "options"})
 	})
@@ -189,3 +189,60 @@ func TestWebhookSignatureVerification(t *testing.T) {
 	// Since we're using a mock signature, it should be false
 	assert.False(t, isValid)
 }
+
+func TestCORSAllowedOrigins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	settings := NewRuntimeSettings(RuntimeConfig{CORSOrigins: []string{"https://shop.example.com"}}, nil)
+	router := gin.New()
+	router.Use(CORSMiddleware(settings))
+	router.GET("/test", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "test"})
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "https://shop.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/test", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	router.ServeHTTP(w, req)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(AdminAuthMiddleware("admin-key"))
+	router.POST("/admin/config/reload", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/config/reload", nil)
+	req.Header.Set("X-Admin-Key", "wrong")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/config/reload", nil)
+	req.Header.Set("X-Admin-Key", "admin-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Browsers authenticate with the key as the basic auth password
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/config/reload", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="admin"`, w.Header().Get("WWW-Authenticate"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/config/reload", nil)
+	req.SetBasicAuth("ops", "admin-key")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
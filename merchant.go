@@ -0,0 +1,692 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// merchantGinKey is the gin context key the authenticated merchant is stored under
+const merchantGinKey = "merchant"
+
+// Merchant is a Cashfree account served by this deployment
+type Merchant struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	CFClientID  string    `json:"cf_client_id"`
+	CFSecret    string    `json:"-"`
+	Environment string    `json:"environment"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// URL templates for the notify_url and return_url of the merchant's orders
+	NotifyURLTemplate *string `json:"notify_url_template,omitempty"`
+	ReturnURLTemplate *string `json:"return_url_template,omitempty"`
+
+	// Hosts caller-supplied order URLs may point at; empty uses the deployment's allowlist
+	AllowedURLHosts []string `json:"allowed_url_hosts,omitempty"`
+
+	// Hosted checkout customization
+	Checkout CheckoutTheme `json:"checkout"`
+
+	// Daily quotas; nil means unlimited
+	DailyOrderLimit  *int     `json:"daily_order_limit,omitempty"`
+	DailyRefundLimit *float64 `json:"daily_refund_limit,omitempty"`
+
+	// IANA time zone of the merchant's business day for reports and quotas; empty uses
+	// the deployment's REPORT_TIMEZONE
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// SecretBox encrypts merchant credentials at rest with AES-256-GCM. It can hold several
+// keys so they can be rotated: new values are encrypted with the current key, and
+// values of any key it holds can be decrypted.
+type SecretBox struct {
+	keys    map[string]cipher.AEAD // by key ID; "" is the original key
+	current string
+}
+
+// keyIDPattern is what a versioned key's ID may be made of
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// newAEAD creates the cipher of a base64-encoded 32-byte key
+func newAEAD(encodedKey string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewSecretBox creates a SecretBox from a base64-encoded 32-byte key
+func NewSecretBox(encodedKey string) (*SecretBox, error) {
+	aead, err := newAEAD(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{keys: map[string]cipher.AEAD{"": aead}}, nil
+}
+
+// AddKey adds a versioned key, so values encrypted with it can be decrypted
+func (b *SecretBox) AddKey(id, encodedKey string) error {
+	if !keyIDPattern.MatchString(id) {
+		return fmt.Errorf("key ID %q may only contain letters, digits, _ and -", id)
+	}
+	if _, ok := b.keys[id]; ok {
+		return fmt.Errorf("key %s is given twice", id)
+	}
+	aead, err := newAEAD(encodedKey)
+	if err != nil {
+		return fmt.Errorf("key %s: %v", id, err)
+	}
+	b.keys[id] = aead
+	return nil
+}
+
+// UseKey makes new values be encrypted with the key of id; "" is the original key
+func (b *SecretBox) UseKey(id string) error {
+	if _, ok := b.keys[id]; !ok {
+		return fmt.Errorf("no key %s was added", id)
+	}
+	b.current = id
+	return nil
+}
+
+// CurrentKeyID returns the ID of the key new values are encrypted with
+func (b *SecretBox) CurrentKeyID() string {
+	return b.current
+}
+
+// KeyID returns the ID of the key a value was encrypted with. Values of a versioned key
+// carry its ID as a "<key id>:" prefix; values of the original key carry none.
+func KeyID(encoded string) string {
+	if id, _, ok := strings.Cut(encoded, ":"); ok {
+		return id
+	}
+	return ""
+}
+
+// Encrypt returns base64(nonce || ciphertext), prefixed with the ID of a versioned key
+func (b *SecretBox) Encrypt(plaintext string) (string, error) {
+	aead := b.keys[b.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil))
+	if b.current == "" {
+		return sealed, nil
+	}
+	return b.current + ":" + sealed, nil
+}
+
+// Decrypt reverses Encrypt with the key the value was encrypted with
+func (b *SecretBox) Decrypt(encoded string) (string, error) {
+	id := KeyID(encoded)
+	aead, ok := b.keys[id]
+	if !ok {
+		return "", fmt.Errorf("value is encrypted with unknown key %s", id)
+	}
+	if id != "" {
+		encoded = encoded[len(id)+1:]
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NewMerchantAPIKey generates a random API key for a merchant
+func NewMerchantAPIKey() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "mk_" + hex.EncodeToString(key), nil
+}
+
+// HashAPIKey returns the SHA-256 hex digest stored in place of the API key
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// MerchantRepository stores merchants with their Cashfree secrets encrypted
+type MerchantRepository struct {
+	db    *pgxpool.Pool
+	box   *SecretBox
+	clock Clock
+}
+
+func NewMerchantRepository(db *pgxpool.Pool, box *SecretBox) *MerchantRepository {
+	return &MerchantRepository{db: db, box: box, clock: SystemClock}
+}
+
+// now returns the time records are stamped with
+func (r *MerchantRepository) now() time.Time {
+	return clockOrSystem(r.clock).Now()
+}
+
+const merchantColumns = `id, name, cf_client_id, cf_client_secret, environment, active,
+			   created_at, updated_at, notify_url_template, return_url_template,
+			   daily_order_limit, daily_refund_limit, allowed_url_hosts,
+			   checkout_display_name, checkout_logo_url, checkout_theme_color,
+			   timezone`
+
+// scanMerchant scans a row selected with merchantColumns and decrypts the secret
+func (r *MerchantRepository) scanMerchant(row pgx.Row) (*Merchant, error) {
+	var merchant Merchant
+	var encryptedSecret string
+	err := row.Scan(
+		&merchant.ID, &merchant.Name, &merchant.CFClientID, &encryptedSecret,
+		&merchant.Environment, &merchant.Active, &merchant.CreatedAt,
+		&merchant.UpdatedAt, &merchant.NotifyURLTemplate, &merchant.ReturnURLTemplate,
+		&merchant.DailyOrderLimit, &merchant.DailyRefundLimit, &merchant.AllowedURLHosts,
+		&merchant.Checkout.DisplayName, &merchant.Checkout.LogoURL, &merchant.Checkout.ThemeColor,
+		&merchant.Timezone,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.CFSecret, err = r.box.Decrypt(encryptedSecret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials for merchant %s: %v", merchant.ID, err)
+	}
+	return &merchant, nil
+}
+
+// CreateMerchant creates a merchant authenticated by apiKey
+func (r *MerchantRepository) CreateMerchant(ctx context.Context, merchant *Merchant, apiKey string) error {
+	query := `
+		INSERT INTO merchants (
+			id, name, api_key_hash, cf_client_id, cf_client_secret,
+			environment, active, created_at, updated_at,
+			notify_url_template, return_url_template, daily_order_limit,
+			daily_refund_limit, allowed_url_hosts, checkout_display_name,
+			checkout_logo_url, checkout_theme_color, timezone
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+	`
+
+	encryptedSecret, err := r.box.Encrypt(merchant.CFSecret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %v", err)
+	}
+
+	now := r.now()
+	merchant.ID = uuid.New()
+	merchant.Active = true
+	merchant.CreatedAt = now
+	merchant.UpdatedAt = now
+
+	_, err = r.db.Exec(ctx, query,
+		merchant.ID, merchant.Name, HashAPIKey(apiKey), merchant.CFClientID,
+		encryptedSecret, merchant.Environment, merchant.Active,
+		merchant.CreatedAt, merchant.UpdatedAt,
+		merchant.NotifyURLTemplate, merchant.ReturnURLTemplate,
+		merchant.DailyOrderLimit, merchant.DailyRefundLimit, merchant.AllowedURLHosts,
+		merchant.Checkout.DisplayName, merchant.Checkout.LogoURL, merchant.Checkout.ThemeColor,
+		merchant.Timezone,
+	)
+
+	return err
+}
+
+// UpdateMerchant saves every editable field of a merchant
+func (r *MerchantRepository) UpdateMerchant(ctx context.Context, merchant *Merchant) error {
+	query := `
+		UPDATE merchants
+		SET name = $2, cf_client_id = $3, cf_client_secret = $4, environment = $5,
+			active = $6, notify_url_template = $7, return_url_template = $8,
+			updated_at = $9, daily_order_limit = $10, daily_refund_limit = $11,
+			allowed_url_hosts = $12, checkout_display_name = $13,
+			checkout_logo_url = $14, checkout_theme_color = $15, timezone = $16
+		WHERE id = $1
+	`
+
+	encryptedSecret, err := r.box.Encrypt(merchant.CFSecret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %v", err)
+	}
+
+	merchant.UpdatedAt = r.now()
+	tag, err := r.db.Exec(ctx, query,
+		merchant.ID, merchant.Name, merchant.CFClientID, encryptedSecret,
+		merchant.Environment, merchant.Active, merchant.NotifyURLTemplate,
+		merchant.ReturnURLTemplate, merchant.UpdatedAt, merchant.DailyOrderLimit,
+		merchant.DailyRefundLimit, merchant.AllowedURLHosts, merchant.Checkout.DisplayName,
+		merchant.Checkout.LogoURL, merchant.Checkout.ThemeColor, merchant.Timezone,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("merchant not found for id: %s", merchant.ID)
+	}
+	return nil
+}
+
+// ListMerchants returns every merchant, oldest first
+func (r *MerchantRepository) ListMerchants(ctx context.Context) ([]*Merchant, error) {
+	query := `
+		SELECT ` + merchantColumns + `
+		FROM merchants
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var merchants []*Merchant
+	for rows.Next() {
+		merchant, err := r.scanMerchant(rows)
+		if err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, merchant)
+	}
+
+	return merchants, rows.Err()
+}
+
+// GetMerchantByAPIKey retrieves the merchant an API key belongs to
+func (r *MerchantRepository) GetMerchantByAPIKey(ctx context.Context, apiKey string) (*Merchant, error) {
+	query := `
+		SELECT ` + merchantColumns + `
+		FROM merchants
+		WHERE api_key_hash = $1
+	`
+
+	merchant, err := r.scanMerchant(r.db.QueryRow(ctx, query, HashAPIKey(apiKey)))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.New("merchant not found for API key")
+		}
+		return nil, err
+	}
+
+	return merchant, nil
+}
+
+// GetMerchantByID retrieves a merchant by ID
+func (r *MerchantRepository) GetMerchantByID(ctx context.Context, id uuid.UUID) (*Merchant, error) {
+	query := `
+		SELECT ` + merchantColumns + `
+		FROM merchants
+		WHERE id = $1
+	`
+
+	merchant, err := r.scanMerchant(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("merchant not found for id: %s", id)
+		}
+		return nil, err
+	}
+
+	return merchant, nil
+}
+
+// AddWebhookSecret stores an additional secret the merchant's webhooks may be signed
+// with, so a secret can be rotated without rejecting webhooks in flight
+func (r *MerchantRepository) AddWebhookSecret(ctx context.Context, merchantID uuid.UUID, secret string) (uuid.UUID, error) {
+	query := `
+		INSERT INTO merchant_webhook_secrets (id, merchant_id, secret, active, created_at)
+		VALUES ($1, $2, $3, TRUE, $4)
+	`
+
+	encryptedSecret, err := r.box.Encrypt(secret)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to encrypt webhook secret: %v", err)
+	}
+
+	id := uuid.New()
+	_, err = r.db.Exec(ctx, query, id, merchantID, encryptedSecret, r.now())
+	return id, err
+}
+
+// RevokeWebhookSecret stops accepting webhooks signed with a secret
+func (r *MerchantRepository) RevokeWebhookSecret(ctx context.Context, merchantID, secretID uuid.UUID) error {
+	query := `
+		UPDATE merchant_webhook_secrets
+		SET active = FALSE
+		WHERE id = $1 AND merchant_id = $2
+	`
+
+	tag, err := r.db.Exec(ctx, query, secretID, merchantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("webhook secret not found for id: %s", secretID)
+	}
+	return nil
+}
+
+// activeWebhookSecrets returns the decrypted active webhook secrets of active merchants
+// by merchant ID
+func (r *MerchantRepository) activeWebhookSecrets(ctx context.Context, merchantID *uuid.UUID) (map[uuid.UUID][]string, error) {
+	query := `
+		SELECT s.merchant_id, s.secret
+		FROM merchant_webhook_secrets s
+		JOIN merchants m ON m.id = s.merchant_id
+		WHERE s.active AND m.active
+	`
+	var args []interface{}
+	if merchantID != nil {
+		query += " AND s.merchant_id = $1"
+		args = append(args, *merchantID)
+	}
+	query += " ORDER BY s.created_at DESC"
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	secrets := make(map[uuid.UUID][]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var encryptedSecret string
+		if err := rows.Scan(&id, &encryptedSecret); err != nil {
+			return nil, err
+		}
+		secret, err := r.box.Decrypt(encryptedSecret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt webhook secret for merchant %s: %v", id, err)
+		}
+		secrets[id] = append(secrets[id], secret)
+	}
+
+	return secrets, rows.Err()
+}
+
+// WebhookSecrets returns the secrets a merchant's webhooks may be signed with. Merchants
+// without their own webhook secrets are signed with their Cashfree client secret.
+func (r *MerchantRepository) WebhookSecrets(ctx context.Context, merchant *Merchant) ([]string, error) {
+	secrets, err := r.activeWebhookSecrets(ctx, &merchant.ID)
+	if err != nil {
+		return nil, err
+	}
+	return webhookSecretsFor(merchant, secrets), nil
+}
+
+// webhookSigner is a merchant and one secret its webhooks may be signed with
+type webhookSigner struct {
+	merchant *Merchant
+	secret   string
+}
+
+// WebhookSigners returns every active merchant with each secret its webhooks may be
+// signed with, for webhooks that do not name their merchant
+func (r *MerchantRepository) WebhookSigners(ctx context.Context) ([]webhookSigner, error) {
+	merchants, err := r.ListMerchants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := r.activeWebhookSecrets(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []webhookSigner
+	for _, merchant := range merchants {
+		if !merchant.Active {
+			continue
+		}
+		for _, secret := range webhookSecretsFor(merchant, secrets) {
+			signers = append(signers, webhookSigner{merchant: merchant, secret: secret})
+		}
+	}
+	return signers, nil
+}
+
+// webhookSecretsFor returns a merchant's configured webhook secrets, or its Cashfree
+// client secret when it has none
+func webhookSecretsFor(merchant *Merchant, secrets map[uuid.UUID][]string) []string {
+	if configured := secrets[merchant.ID]; len(configured) > 0 {
+		return configured
+	}
+	return []string{merchant.CFSecret}
+}
+
+// MerchantClientPool keeps one Cashfree client per merchant, rebuilding it when the
+// merchant's record changes
+type MerchantClientPool struct {
+	mu        sync.Mutex
+	merchants *MerchantRepository
+	clients   map[uuid.UUID]*pooledClient
+	faults    *FaultInjector // nil unless CHAOS_MODE is enabled; never applied to PROD merchants
+	options   []CashfreeOption
+}
+
+type pooledClient struct {
+	client    *CashfreeClient
+	updatedAt time.Time
+}
+
+func NewMerchantClientPool(merchants *MerchantRepository) *MerchantClientPool {
+	return &MerchantClientPool{
+		merchants: merchants,
+		clients:   make(map[uuid.UUID]*pooledClient),
+	}
+}
+
+// Get returns the Cashfree client for a merchant
+func (p *MerchantClientPool) Get(merchant *Merchant) *CashfreeClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pooled, ok := p.clients[merchant.ID]; ok && pooled.updatedAt.Equal(merchant.UpdatedAt) {
+		return pooled.client
+	}
+
+	client := NewCashfreeClient(merchant.CFClientID, merchant.CFSecret, merchant.Environment, p.options...)
+	if strings.ToUpper(merchant.Environment) != EnvironmentProd {
+		p.faults.WrapClient(client)
+	}
+	p.clients[merchant.ID] = &pooledClient{client: client, updatedAt: merchant.UpdatedAt}
+	return client
+}
+
+// ForTenant loads the merchant and returns its Cashfree client
+func (p *MerchantClientPool) ForTenant(ctx context.Context, tenantID uuid.UUID) (*CashfreeClient, error) {
+	merchant, err := p.merchants.GetMerchantByID(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return p.Get(merchant), nil
+}
+
+type merchantContextKey struct{}
+
+// WithMerchant returns a context carrying the merchant a request is made for
+func WithMerchant(ctx context.Context, merchant *Merchant) context.Context {
+	return context.WithValue(ctx, merchantContextKey{}, merchant)
+}
+
+// MerchantFromContext returns the request's merchant, or nil in single-merchant mode
+func MerchantFromContext(ctx context.Context) *Merchant {
+	merchant, _ := ctx.Value(merchantContextKey{}).(*Merchant)
+	return merchant
+}
+
+type tenantContextKey struct{}
+
+// WithTenantID returns a context scoped to the records of a merchant, for background
+// work on a record found across merchants, such as a polled order. A nil ID, a record of
+// a single-merchant deployment, leaves ctx as it is.
+func WithTenantID(ctx context.Context, tenantID *uuid.UUID) context.Context {
+	if tenantID == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantContextKey{}, *tenantID)
+}
+
+// TenantIDFromContext returns the request's merchant ID, or nil in single-merchant mode
+func TenantIDFromContext(ctx context.Context) *uuid.UUID {
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		return &merchant.ID
+	}
+	if tenantID, ok := ctx.Value(tenantContextKey{}).(uuid.UUID); ok {
+		return &tenantID
+	}
+	return nil
+}
+
+// requestContext returns a background context carrying the request's merchant and
+// Cashfree environment. It is not cancelled with the request, so writes after a gateway
+// call still complete.
+func requestContext(c *gin.Context) context.Context {
+	ctx := context.Background()
+	if value, ok := c.Get(merchantGinKey); ok {
+		ctx = WithMerchant(ctx, value.(*Merchant))
+	}
+	if env := c.GetString(environmentGinKey); env != "" {
+		ctx = WithCashfreeEnvironment(ctx, env)
+	}
+	return ctx
+}
+
+// MerchantAuthMiddleware resolves the merchant from the X-API-Key header
+func MerchantAuthMiddleware(merchants *MerchantRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing API key"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		merchant, err := merchants.GetMerchantByAPIKey(ctx, apiKey)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			return
+		}
+
+		if !merchant.Active {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Merchant is disabled"})
+			return
+		}
+
+		c.Set(merchantGinKey, merchant)
+		c.Next()
+	}
+}
+
+// runCreateMerchant implements the create-merchant command, printing the new API key once
+func runCreateMerchant(args []string, merchants *MerchantRepository) error {
+	flags := flag.NewFlagSet("create-merchant", flag.ContinueOnError)
+	name := flags.String("name", "", "merchant name")
+	clientID := flags.String("client-id", "", "Cashfree client ID")
+	clientSecret := flags.String("client-secret", "", "Cashfree client secret")
+	environment := flags.String("environment", "TEST", "Cashfree environment (TEST or PROD)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" || *clientID == "" || *clientSecret == "" {
+		return errors.New("-name, -client-id and -client-secret are required")
+	}
+
+	apiKey, err := NewMerchantAPIKey()
+	if err != nil {
+		return err
+	}
+
+	merchant := &Merchant{
+		Name:        *name,
+		CFClientID:  *clientID,
+		CFSecret:    *clientSecret,
+		Environment: strings.ToUpper(*environment),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := merchants.CreateMerchant(ctx, merchant, apiKey); err != nil {
+		return fmt.Errorf("failed to create merchant: %v", err)
+	}
+
+	fmt.Printf("Merchant ID: %s\nAPI key:     %s\n", merchant.ID, apiKey)
+	return nil
+}
+
+// runAddWebhookSecret implements the add-webhook-secret command
+func runAddWebhookSecret(args []string, merchants *MerchantRepository) error {
+	flags := flag.NewFlagSet("add-webhook-secret", flag.ContinueOnError)
+	merchantID := flags.String("merchant-id", "", "merchant ID")
+	secret := flags.String("secret", "", "Cashfree webhook secret")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *merchantID == "" || *secret == "" {
+		return errors.New("-merchant-id and -secret are required")
+	}
+
+	id, err := uuid.Parse(*merchantID)
+	if err != nil {
+		return fmt.Errorf("invalid merchant id: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := merchants.GetMerchantByID(ctx, id); err != nil {
+		return err
+	}
+
+	secretID, err := merchants.AddWebhookSecret(ctx, id, *secret)
+	if err != nil {
+		return fmt.Errorf("failed to add webhook secret: %v", err)
+	}
+
+	fmt.Printf("Webhook secret ID: %s\n", secretID)
+	return nil
+}
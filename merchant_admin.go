@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Merchant audit log actions
+const (
+	AuditMerchantCreated      = "merchant.created"
+	AuditMerchantUpdated      = "merchant.updated"
+	AuditMerchantActivated    = "merchant.activated"
+	AuditMerchantDeactivated  = "merchant.deactivated"
+	AuditWebhookSecretAdded   = "webhook_secret.added"
+	AuditWebhookSecretRevoked = "webhook_secret.revoked"
+)
+
+// MerchantAuditEntry records a change made to a merchant through the admin API. Secrets
+// are never recorded, only which fields changed.
+type MerchantAuditEntry struct {
+	ID         uuid.UUID              `json:"id"`
+	MerchantID uuid.UUID              `json:"merchant_id"`
+	Action     string                 `json:"action"`
+	Actor      string                 `json:"actor"`
+	RemoteIP   string                 `json:"remote_ip"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// WebhookSecret describes a merchant's webhook secret without revealing it
+type WebhookSecret struct {
+	ID        uuid.UUID `json:"id"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordAudit appends an entry to the merchant audit log
+func (r *MerchantRepository) RecordAudit(ctx context.Context, entry *MerchantAuditEntry) error {
+	query := `
+		INSERT INTO merchant_audit_log (id, merchant_id, action, actor, remote_ip, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	entry.ID = uuid.New()
+	entry.CreatedAt = r.now()
+
+	_, err := r.db.Exec(ctx, query,
+		entry.ID, entry.MerchantID, entry.Action, entry.Actor,
+		entry.RemoteIP, entry.Details, entry.CreatedAt,
+	)
+
+	return err
+}
+
+// ListAuditLog returns a merchant's audit log, newest first
+func (r *MerchantRepository) ListAuditLog(ctx context.Context, merchantID uuid.UUID, limit, offset int) ([]MerchantAuditEntry, error) {
+	query := `
+		SELECT id, merchant_id, action, actor, remote_ip, details, created_at
+		FROM merchant_audit_log
+		WHERE merchant_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, merchantID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []MerchantAuditEntry
+	for rows.Next() {
+		var entry MerchantAuditEntry
+		err := rows.Scan(
+			&entry.ID, &entry.MerchantID, &entry.Action, &entry.Actor,
+			&entry.RemoteIP, &entry.Details, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ListWebhookSecrets returns a merchant's webhook secrets, newest first
+func (r *MerchantRepository) ListWebhookSecrets(ctx context.Context, merchantID uuid.UUID) ([]WebhookSecret, error) {
+	query := `
+		SELECT id, active, created_at
+		FROM merchant_webhook_secrets
+		WHERE merchant_id = $1
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var secrets []WebhookSecret
+	for rows.Next() {
+		var secret WebhookSecret
+		if err := rows.Scan(&secret.ID, &secret.Active, &secret.CreatedAt); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, rows.Err()
+}
+
+// CreateMerchantRequest registers a merchant through the admin API
+type CreateMerchantRequest struct {
+	Name              string  `json:"name" binding:"required"`
+	ClientID          string  `json:"client_id" binding:"required"`
+	ClientSecret      string  `json:"client_secret" binding:"required"`
+	Environment       string  `json:"environment"`
+	NotifyURLTemplate *string `json:"notify_url_template,omitempty"`
+	ReturnURLTemplate *string `json:"return_url_template,omitempty"`
+
+	AllowedURLHosts []string `json:"allowed_url_hosts,omitempty"`
+
+	Checkout CheckoutTheme `json:"checkout"`
+
+	DailyOrderLimit  *int     `json:"daily_order_limit,omitempty" binding:"omitempty,gt=0"`
+	DailyRefundLimit *float64 `json:"daily_refund_limit,omitempty" binding:"omitempty,gt=0"`
+
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// UpdateMerchantRequest changes the given fields of a merchant. An empty URL template,
+// host list or a zero quota removes it. The client ID and secret are replaced together.
+type UpdateMerchantRequest struct {
+	Name              *string   `json:"name,omitempty"`
+	ClientID          *string   `json:"client_id,omitempty"`
+	ClientSecret      *string   `json:"client_secret,omitempty"`
+	Environment       *string   `json:"environment,omitempty"`
+	NotifyURLTemplate *string   `json:"notify_url_template,omitempty"`
+	ReturnURLTemplate *string   `json:"return_url_template,omitempty"`
+	AllowedURLHosts   *[]string `json:"allowed_url_hosts,omitempty"`
+
+	// Checkout replaces the whole checkout theme
+	Checkout *CheckoutTheme `json:"checkout,omitempty"`
+
+	DailyOrderLimit  *int     `json:"daily_order_limit,omitempty" binding:"omitempty,gte=0"`
+	DailyRefundLimit *float64 `json:"daily_refund_limit,omitempty" binding:"omitempty,gte=0"`
+
+	// Timezone changes the merchant's business day; empty uses the deployment's
+	Timezone *string `json:"timezone,omitempty"`
+}
+
+// AddWebhookSecretRequest adds a webhook secret to a merchant
+type AddWebhookSecretRequest struct {
+	Secret string `json:"secret" binding:"required"`
+}
+
+// normalizeEnvironment upper-cases a Cashfree environment, defaulting to TEST
+func normalizeEnvironment(env string) (string, error) {
+	switch env = strings.ToUpper(env); env {
+	case "":
+		return EnvironmentTest, nil
+	case EnvironmentTest, EnvironmentProd:
+		return env, nil
+	default:
+		return "", errors.New("environment must be TEST or PROD")
+	}
+}
+
+// validAllowedHosts checks a URL host allowlist, writing the error response when an
+// entry is invalid
+func validAllowedHosts(c *gin.Context, hosts []string) bool {
+	for _, host := range hosts {
+		if !validAllowedHost(host) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "allowed_url_hosts entries must be hosts such as shop.example.com or *.example.com"})
+			return false
+		}
+	}
+	return true
+}
+
+// MerchantAdminHandler serves the merchant administration API
+type MerchantAdminHandler struct {
+	merchants *MerchantRepository
+
+	// validateCredentials checks Cashfree credentials with a live call
+	validateCredentials func(clientID, clientSecret, environment string) error
+}
+
+func NewMerchantAdminHandler(merchants *MerchantRepository) *MerchantAdminHandler {
+	return &MerchantAdminHandler{
+		merchants:           merchants,
+		validateCredentials: cashfreeCredentialValidator(),
+	}
+}
+
+// cashfreeCredentialValidator checks credentials against the Cashfree environment they
+// belong to, with clients configured by opts
+func cashfreeCredentialValidator(opts ...CashfreeOption) func(clientID, clientSecret, environment string) error {
+	return func(clientID, clientSecret, environment string) error {
+		return NewCashfreeClient(clientID, clientSecret, environment, opts...).ValidateCredentials()
+	}
+}
+
+// checkCredentials validates credentials, writing the error response on failure
+func (h *MerchantAdminHandler) checkCredentials(c *gin.Context, clientID, clientSecret, environment string) bool {
+	err := h.validateCredentials(clientID, clientSecret, environment)
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrInvalidCredentials) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Cashfree rejected the credentials"})
+		return false
+	}
+	log.Printf("Failed to validate Cashfree credentials: %v", err)
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to validate credentials with Cashfree"})
+	return false
+}
+
+// audit records an admin action, logging rather than failing the request when the
+// entry cannot be written
+func (h *MerchantAdminHandler) audit(ctx context.Context, c *gin.Context, merchantID uuid.UUID, action string, details map[string]interface{}) {
+	actor := c.GetHeader("X-Admin-Actor")
+	if actor == "" {
+		actor = "admin"
+	}
+
+	entry := &MerchantAuditEntry{
+		MerchantID: merchantID,
+		Action:     action,
+		Actor:      actor,
+		RemoteIP:   c.ClientIP(),
+		Details:    details,
+	}
+	if err := h.merchants.RecordAudit(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s for merchant %s: %v", action, merchantID, err)
+	}
+}
+
+// loadMerchant loads the merchant named by the merchant_id parameter, writing the error
+// response when it does not exist
+func (h *MerchantAdminHandler) loadMerchant(ctx context.Context, c *gin.Context) (*Merchant, bool) {
+	id, err := uuid.Parse(c.Param("merchant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return nil, false
+	}
+
+	merchant, err := h.merchants.GetMerchantByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+		return nil, false
+	}
+	return merchant, true
+}
+
+// CreateMerchant registers a merchant and returns its API key, which is shown only once
+func (h *MerchantAdminHandler) CreateMerchant(c *gin.Context) {
+	var req CreateMerchantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	environment, err := normalizeEnvironment(req.Environment)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for field, template := range map[string]*string{"notify_url_template": req.NotifyURLTemplate, "return_url_template": req.ReturnURLTemplate} {
+		if template == nil {
+			continue
+		}
+		if err := validateURLTemplate(field, *template); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if !validAllowedHosts(c, req.AllowedURLHosts) {
+		return
+	}
+	if err := req.Checkout.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Timezone != "" {
+		if _, err := loadTimezone(req.Timezone); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if !h.checkCredentials(c, req.ClientID, req.ClientSecret, environment) {
+		return
+	}
+
+	apiKey, err := NewMerchantAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	merchant := &Merchant{
+		Name:              req.Name,
+		CFClientID:        req.ClientID,
+		CFSecret:          req.ClientSecret,
+		Environment:       environment,
+		NotifyURLTemplate: req.NotifyURLTemplate,
+		ReturnURLTemplate: req.ReturnURLTemplate,
+		AllowedURLHosts:   req.AllowedURLHosts,
+		Checkout:          req.Checkout,
+		DailyOrderLimit:   req.DailyOrderLimit,
+		DailyRefundLimit:  req.DailyRefundLimit,
+		Timezone:          req.Timezone,
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := h.merchants.CreateMerchant(ctx, merchant, apiKey); err != nil {
+		log.Printf("Failed to create merchant: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create merchant"})
+		return
+	}
+
+	h.audit(ctx, c, merchant.ID, AuditMerchantCreated, map[string]interface{}{
+		"name":        merchant.Name,
+		"environment": merchant.Environment,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"merchant": merchant, "api_key": apiKey})
+}
+
+// ListMerchants returns every merchant
+func (h *MerchantAdminHandler) ListMerchants(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchants, err := h.merchants.ListMerchants(ctx)
+	if err != nil {
+		log.Printf("Failed to list merchants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list merchants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"merchants": merchants})
+}
+
+// GetMerchant returns a merchant
+func (h *MerchantAdminHandler) GetMerchant(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, merchant)
+}
+
+// UpdateMerchant changes a merchant's name, credentials, URL settings, checkout theme,
+// quotas or timezone. New credentials are validated with Cashfree before they are saved.
+func (h *MerchantAdminHandler) UpdateMerchant(c *gin.Context) {
+	var req UpdateMerchantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.ClientID == nil) != (req.ClientSecret == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "client_id and client_secret must be updated together"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	var changed []string
+	if req.Name != nil && *req.Name != merchant.Name {
+		if *req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name must not be empty"})
+			return
+		}
+		merchant.Name = *req.Name
+		changed = append(changed, "name")
+	}
+
+	credentialsChanged := false
+	if req.Environment != nil {
+		environment, err := normalizeEnvironment(*req.Environment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if environment != merchant.Environment {
+			merchant.Environment = environment
+			credentialsChanged = true
+			changed = append(changed, "environment")
+		}
+	}
+	if req.ClientID != nil {
+		merchant.CFClientID = *req.ClientID
+		merchant.CFSecret = *req.ClientSecret
+		credentialsChanged = true
+		changed = append(changed, "credentials")
+	}
+
+	for _, update := range []struct {
+		field    string
+		value    *string
+		template **string
+	}{
+		{"notify_url_template", req.NotifyURLTemplate, &merchant.NotifyURLTemplate},
+		{"return_url_template", req.ReturnURLTemplate, &merchant.ReturnURLTemplate},
+	} {
+		if update.value == nil {
+			continue
+		}
+		if *update.value == "" {
+			*update.template = nil
+		} else {
+			if err := validateURLTemplate(update.field, *update.value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			*update.template = update.value
+		}
+		changed = append(changed, update.field)
+	}
+
+	if req.AllowedURLHosts != nil {
+		if !validAllowedHosts(c, *req.AllowedURLHosts) {
+			return
+		}
+		merchant.AllowedURLHosts = *req.AllowedURLHosts
+		changed = append(changed, "allowed_url_hosts")
+	}
+	if req.Checkout != nil && *req.Checkout != merchant.Checkout {
+		if err := req.Checkout.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		merchant.Checkout = *req.Checkout
+		changed = append(changed, "checkout")
+	}
+	if req.DailyOrderLimit != nil {
+		merchant.DailyOrderLimit = req.DailyOrderLimit
+		if *req.DailyOrderLimit == 0 {
+			merchant.DailyOrderLimit = nil
+		}
+		changed = append(changed, "daily_order_limit")
+	}
+	if req.DailyRefundLimit != nil {
+		merchant.DailyRefundLimit = req.DailyRefundLimit
+		if *req.DailyRefundLimit == 0 {
+			merchant.DailyRefundLimit = nil
+		}
+		changed = append(changed, "daily_refund_limit")
+	}
+	if req.Timezone != nil && *req.Timezone != merchant.Timezone {
+		if *req.Timezone != "" {
+			if _, err := loadTimezone(*req.Timezone); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		merchant.Timezone = *req.Timezone
+		changed = append(changed, "timezone")
+	}
+
+	if len(changed) == 0 {
+		c.JSON(http.StatusOK, merchant)
+		return
+	}
+
+	if credentialsChanged && !h.checkCredentials(c, merchant.CFClientID, merchant.CFSecret, merchant.Environment) {
+		return
+	}
+
+	if err := h.merchants.UpdateMerchant(ctx, merchant); err != nil {
+		log.Printf("Failed to update merchant %s: %v", merchant.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update merchant"})
+		return
+	}
+
+	h.audit(ctx, c, merchant.ID, AuditMerchantUpdated, map[string]interface{}{"fields": changed})
+
+	c.JSON(http.StatusOK, merchant)
+}
+
+// DeactivateMerchant disables a merchant: its API key and webhooks are rejected until
+// it is activated again
+func (h *MerchantAdminHandler) DeactivateMerchant(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+// ActivateMerchant re-enables a deactivated merchant
+func (h *MerchantAdminHandler) ActivateMerchant(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+func (h *MerchantAdminHandler) setActive(c *gin.Context, active bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	if merchant.Active == active {
+		c.JSON(http.StatusOK, merchant)
+		return
+	}
+
+	merchant.Active = active
+	if err := h.merchants.UpdateMerchant(ctx, merchant); err != nil {
+		log.Printf("Failed to update merchant %s: %v", merchant.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update merchant"})
+		return
+	}
+
+	action := AuditMerchantDeactivated
+	if active {
+		action = AuditMerchantActivated
+	}
+	h.audit(ctx, c, merchant.ID, action, nil)
+
+	c.JSON(http.StatusOK, merchant)
+}
+
+// ListWebhookSecrets lists a merchant's webhook secrets without revealing them
+func (h *MerchantAdminHandler) ListWebhookSecrets(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	secrets, err := h.merchants.ListWebhookSecrets(ctx, merchant.ID)
+	if err != nil {
+		log.Printf("Failed to list webhook secrets for merchant %s: %v", merchant.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhook secrets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_secrets": secrets})
+}
+
+// AddWebhookSecret adds a secret the merchant's webhooks may be signed with
+func (h *MerchantAdminHandler) AddWebhookSecret(c *gin.Context) {
+	var req AddWebhookSecretRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	id, err := h.merchants.AddWebhookSecret(ctx, merchant.ID, req.Secret)
+	if err != nil {
+		log.Printf("Failed to add webhook secret for merchant %s: %v", merchant.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add webhook secret"})
+		return
+	}
+
+	h.audit(ctx, c, merchant.ID, AuditWebhookSecretAdded, map[string]interface{}{"webhook_secret_id": id})
+
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// RevokeWebhookSecret stops accepting webhooks signed with a secret
+func (h *MerchantAdminHandler) RevokeWebhookSecret(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	secretID, err := uuid.Parse(c.Param("secret_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook secret ID"})
+		return
+	}
+
+	if err := h.merchants.RevokeWebhookSecret(ctx, merchant.ID, secretID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook secret not found"})
+		return
+	}
+
+	h.audit(ctx, c, merchant.ID, AuditWebhookSecretRevoked, map[string]interface{}{"webhook_secret_id": secretID})
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// GetAuditLog returns a merchant's audit log, newest first
+func (h *MerchantAdminHandler) GetAuditLog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	if limit > 500 {
+		limit = 500
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	merchant, ok := h.loadMerchant(ctx, c)
+	if !ok {
+		return
+	}
+
+	entries, err := h.merchants.ListAuditLog(ctx, merchant.ID, limit, offset)
+	if err != nil {
+		log.Printf("Failed to list audit log for merchant %s: %v", merchant.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list audit log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries, "limit": limit, "offset": offset, "count": len(entries)})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMerchantAdminRouter(handler *MerchantAdminHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	merchants := r.Group("/admin/merchants")
+	merchants.POST("", handler.CreateMerchant)
+	merchants.GET("/:merchant_id", handler.GetMerchant)
+	merchants.PATCH("/:merchant_id", handler.UpdateMerchant)
+	merchants.POST("/:merchant_id/deactivate", handler.DeactivateMerchant)
+	merchants.GET("/:merchant_id/audit-log", handler.GetAuditLog)
+	return r
+}
+
+func adminRequest(r *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Admin-Actor", "ops@example.com")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestValidateURLTemplate(t *testing.T) {
+	assert.NoError(t, validateURLTemplate("return_url_template", "https://shop.example.com/return?order_id={order_id}"))
+	assert.NoError(t, validateURLTemplate("return_url_template", "https://shop.example.com/orders/{order_id}/done"))
+	assert.Error(t, validateURLTemplate("return_url_template", "/return?order_id={order_id}"))
+	assert.Error(t, validateURLTemplate("return_url_template", "ftp://shop.example.com/{order_id}"))
+}
+
+func TestCreateMerchantValidatesCredentials(t *testing.T) {
+	handler := &MerchantAdminHandler{
+		validateCredentials: func(clientID, clientSecret, environment string) error {
+			assert.Equal(t, EnvironmentTest, environment)
+			return ErrInvalidCredentials
+		},
+	}
+	r := newMerchantAdminRouter(handler)
+
+	w := adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"wrong"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	handler.validateCredentials = func(string, string, string) error { return errors.New("connection refused") }
+	w = adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"secret"}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	w = adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"secret","environment":"staging"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"secret","return_url_template":"/return"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"secret","timezone":"Mars/Olympus"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown timezone")
+}
+
+func TestUpdateMerchantRequiresCredentialPair(t *testing.T) {
+	r := newMerchantAdminRouter(&MerchantAdminHandler{})
+
+	w := adminRequest(r, http.MethodPatch, "/admin/merchants/not-a-uuid", `{"name":"Acme"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminRequest(r, http.MethodPatch, "/admin/merchants/not-a-uuid", `{"client_id":"id"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "client_id and client_secret must be updated together")
+}
+
+func TestMerchantAdminLifecycle(t *testing.T) {
+	db := testDB(t)
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	validated := 0
+	handler := &MerchantAdminHandler{
+		merchants: NewMerchantRepository(db, box),
+		validateCredentials: func(string, string, string) error {
+			validated++
+			return nil
+		},
+	}
+	r := newMerchantAdminRouter(handler)
+
+	w := adminRequest(r, http.MethodPost, "/admin/merchants", `{"name":"Acme","client_id":"id","client_secret":"secret","environment":"test"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		Merchant Merchant `json:"merchant"`
+		APIKey   string   `json:"api_key"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotContains(t, w.Body.String(), `"secret"`)
+	assert.Equal(t, 1, validated)
+
+	path := "/admin/merchants/" + created.Merchant.ID.String()
+	w = adminRequest(r, http.MethodPatch, path, `{"return_url_template":"https://shop.example.com/return?order_id={order_id}"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, validated)
+
+	w = adminRequest(r, http.MethodPatch, path, `{"client_id":"new_id","client_secret":"new_secret"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, validated)
+
+	w = adminRequest(r, http.MethodPost, path+"/deactivate", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	merchant, err := handler.merchants.GetMerchantByAPIKey(context.Background(), created.APIKey)
+	require.NoError(t, err)
+	assert.False(t, merchant.Active)
+	assert.Equal(t, "new_secret", merchant.CFSecret)
+	assert.Equal(t, "https://shop.example.com/return?order_id={order_id}", *merchant.ReturnURLTemplate)
+
+	w = adminRequest(r, http.MethodGet, path+"/audit-log", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var auditLog struct {
+		Entries []MerchantAuditEntry `json:"entries"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &auditLog))
+	require.Len(t, auditLog.Entries, 4)
+	assert.Equal(t, AuditMerchantDeactivated, auditLog.Entries[0].Action)
+	assert.Equal(t, AuditMerchantCreated, auditLog.Entries[3].Action)
+	assert.Equal(t, "ops@example.com", auditLog.Entries[0].Actor)
+	assert.NotContains(t, w.Body.String(), "new_secret")
+}
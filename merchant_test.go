@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/queue"
+)
+
+func testEncryptionKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+
+	encrypted, err := box.Encrypt("cfsk_secret")
+	require.NoError(t, err)
+	assert.NotContains(t, encrypted, "cfsk_secret")
+
+	decrypted, err := box.Decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "cfsk_secret", decrypted)
+
+	// The same plaintext encrypts differently every time
+	again, _ := box.Encrypt("cfsk_secret")
+	assert.NotEqual(t, encrypted, again)
+
+	otherBox, err := NewSecretBox(testEncryptionKey('x'))
+	require.NoError(t, err)
+	_, err = otherBox.Decrypt(encrypted)
+	assert.Error(t, err)
+}
+
+func TestSecretBoxKeyVersions(t *testing.T) {
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	original, err := box.Encrypt("cfsk_secret")
+	require.NoError(t, err)
+	assert.Equal(t, "", KeyID(original))
+
+	require.NoError(t, box.AddKey("v2", testEncryptionKey('v')))
+	require.NoError(t, box.UseKey("v2"))
+	rotated, err := box.Encrypt("cfsk_secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rotated, "v2:"))
+	assert.Equal(t, "v2", KeyID(rotated))
+
+	// Values of every key the box holds can be decrypted
+	for _, encrypted := range []string{original, rotated} {
+		decrypted, err := box.Decrypt(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, "cfsk_secret", decrypted)
+	}
+
+	otherBox, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	_, err = otherBox.Decrypt(rotated)
+	assert.EqualError(t, err, "value is encrypted with unknown key v2")
+
+	assert.Error(t, box.AddKey("v2", testEncryptionKey('w')))
+	assert.Error(t, box.AddKey("v:3", testEncryptionKey('w')))
+	assert.Error(t, box.UseKey("v3"))
+}
+
+func TestNewSecretBoxRejectsShortKey(t *testing.T) {
+	_, err := NewSecretBox(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
+
+func TestMerchantAPIKey(t *testing.T) {
+	key, err := NewMerchantAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "mk_"))
+	assert.Len(t, HashAPIKey(key), 64)
+	assert.NotEqual(t, HashAPIKey(key), HashAPIKey(key+"x"))
+}
+
+func TestMerchantAuthMiddlewareRequiresKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(MerchantAuthMiddleware(nil))
+	r.GET("/payments", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestMerchantClientPool(t *testing.T) {
+	pool := NewMerchantClientPool(nil)
+	merchant := &Merchant{ID: uuid.New(), CFClientID: "id", CFSecret: "secret", Environment: "PROD"}
+
+	client := pool.Get(merchant)
+	assert.Equal(t, CashfreeProdURL, client.BaseURL)
+	assert.Same(t, client, pool.Get(merchant))
+
+	// Updated credentials replace the pooled client
+	updated := *merchant
+	updated.CFSecret = "rotated"
+	updated.UpdatedAt = merchant.UpdatedAt.Add(1)
+	assert.Equal(t, "rotated", pool.Get(&updated).ClientSecret)
+}
+
+func TestRequestContextCarriesMerchant(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, TenantIDFromContext(requestContext(c)))
+
+	merchant := &Merchant{ID: uuid.New()}
+	c.Set(merchantGinKey, merchant)
+	assert.Equal(t, merchant.ID, *TenantIDFromContext(requestContext(c)))
+}
+
+func TestMerchantWebhookRejectsUnknownMerchant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := &PaymentHandler{cashfree: NewCashfreeClient("id", "secret", "TEST")}
+	r := gin.New()
+	r.POST("/webhook/cashfree/:merchant_id", handler.HandleWebhook)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook/cashfree/"+uuid.NewString(), strings.NewReader(`{}`))
+	req.Header.Set("x-webhook-signature", "signature")
+	req.Header.Set("x-webhook-timestamp", "1640995200")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTenantTaskPayload(t *testing.T) {
+	handler := &PaymentHandler{}
+
+	// Tasks queued without a merchant are processed unscoped
+	task, err := newTenantTask(context.Background(), queue.TaskVerifyPayment, "order_1")
+	require.NoError(t, err)
+	ctx, payload, err := handler.tenantTaskContext(context.Background(), task)
+	require.NoError(t, err)
+	assert.Nil(t, TenantIDFromContext(ctx))
+	assert.JSONEq(t, `"order_1"`, string(payload))
+
+	// Payloads queued before tasks carried a merchant are passed through
+	legacy, err := queue.NewTask(queue.TaskVerifyPayment, "order_2")
+	require.NoError(t, err)
+	_, payload, err = handler.tenantTaskContext(context.Background(), legacy)
+	require.NoError(t, err)
+	assert.JSONEq(t, `"order_2"`, string(payload))
+
+	// A merchant's task cannot be processed without the merchant store
+	merchantCtx := WithMerchant(context.Background(), &Merchant{ID: uuid.New()})
+	task, err = newTenantTask(merchantCtx, queue.TaskVerifyPayment, "order_3")
+	require.NoError(t, err)
+	_, _, err = handler.tenantTaskContext(context.Background(), task)
+	assert.Error(t, err)
+}
+
+func signWebhook(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	payload := `{"type":"PAYMENT_SUCCESS_WEBHOOK"}`
+	signature := signWebhook("whsec_1", "1640995200", payload)
+
+	assert.True(t, verifyWebhookSignature("whsec_1", signature, "1640995200", payload))
+	assert.False(t, verifyWebhookSignature("whsec_2", signature, "1640995200", payload))
+	assert.False(t, verifyWebhookSignature("whsec_1", signature, "1640995201", payload))
+
+	// A deployment without credentials must not accept webhooks signed with an empty key
+	assert.False(t, verifyWebhookSignature("", signWebhook("", "1640995200", payload), "1640995200", payload))
+}
+
+func TestWebhookSecretsFor(t *testing.T) {
+	merchant := &Merchant{ID: uuid.New(), CFSecret: "client_secret"}
+
+	// Merchants without webhook secrets are verified with their client secret
+	assert.Equal(t, []string{"client_secret"}, webhookSecretsFor(merchant, nil))
+
+	secrets := map[uuid.UUID][]string{merchant.ID: {"whsec_new", "whsec_old"}}
+	assert.Equal(t, []string{"whsec_new", "whsec_old"}, webhookSecretsFor(merchant, secrets))
+}
@@ -7,7 +7,7 @@ CREATE TABLE IF NOT EXISTS payments (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     order_id VARCHAR(255) UNIQUE NOT NULL,
     cf_order_id VARCHAR(255) UNIQUE NOT NULL,
-    amount DECIMAL(15,2) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
     currency VARCHAR(3) NOT NULL DEFAULT 'INR',
     status VARCHAR(50) NOT NULL DEFAULT 'CREATED',
     payment_method VARCHAR(100),
@@ -37,7 +37,7 @@ CREATE TABLE IF NOT EXISTS refunds (
     cf_refund_id VARCHAR(255) UNIQUE NOT NULL,
     order_id VARCHAR(255) NOT NULL,
     cf_order_id VARCHAR(255) NOT NULL,
-    amount DECIMAL(15,2) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
     status VARCHAR(50) NOT NULL DEFAULT 'PENDING',
     reason TEXT,
     processed_at TIMESTAMP WITH TIME ZONE,
@@ -59,7 +59,7 @@ CREATE TABLE IF NOT EXISTS settlements (
     settlement_id VARCHAR(255) UNIQUE NOT NULL,
     order_id VARCHAR(255) NOT NULL,
     cf_order_id VARCHAR(255) NOT NULL,
-    amount DECIMAL(15,2) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
     status VARCHAR(50) NOT NULL DEFAULT 'PENDING',
     utr VARCHAR(255),
     settled_at TIMESTAMP WITH TIME ZONE,
@@ -81,7 +81,7 @@ CREATE TABLE IF NOT EXISTS split_settlements (
     order_id VARCHAR(255) NOT NULL,
     cf_order_id VARCHAR(255) NOT NULL,
     vendor_id VARCHAR(255) NOT NULL,
-    amount DECIMAL(15,2) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
     percentage DECIMAL(5,2),
     split_type VARCHAR(20) NOT NULL CHECK (split_type IN ('AMOUNT', 'PERCENTAGE')),
     status VARCHAR(50) NOT NULL DEFAULT 'PENDING',
@@ -134,3 +134,712 @@ CREATE TRIGGER update_settlements_updated_at BEFORE UPDATE ON settlements
 
 CREATE TRIGGER update_split_settlements_updated_at BEFORE UPDATE ON split_settlements
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- GST details and invoice numbering
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gstin VARCHAR(15);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS place_of_supply VARCHAR(2);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(5,2);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS hsn_code VARCHAR(8);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS invoice_number VARCHAR(50) UNIQUE;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS invoice_date TIMESTAMP WITH TIME ZONE;
+
+-- Invoice sequences, one per financial year (e.g. 2024-25)
+CREATE TABLE IF NOT EXISTS invoice_sequences (
+    financial_year VARCHAR(7) PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+
+-- Payment gateway that created the order
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gateway VARCHAR(20) NOT NULL DEFAULT 'cashfree';
+
+-- Merchants (multi-tenant Cashfree accounts)
+CREATE TABLE IF NOT EXISTS merchants (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    api_key_hash VARCHAR(64) UNIQUE NOT NULL,
+    cf_client_id VARCHAR(255) NOT NULL,
+    cf_client_secret TEXT NOT NULL, -- AES-256-GCM encrypted with MERCHANT_ENCRYPTION_KEY
+    environment VARCHAR(10) NOT NULL DEFAULT 'TEST',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER update_merchants_updated_at BEFORE UPDATE ON merchants
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Owning merchant of every record; NULL in single-merchant mode
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES merchants(id);
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES merchants(id);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES merchants(id);
+ALTER TABLE split_settlements ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES merchants(id);
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES merchants(id);
+
+CREATE INDEX IF NOT EXISTS idx_payments_tenant_id ON payments(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_tenant_id ON refunds(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_settlements_tenant_id ON settlements(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_split_settlements_tenant_id ON split_settlements(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_webhooks_tenant_id ON webhooks(tenant_id);
+
+-- Cashfree environment ("TEST" or "PROD") a payment was created in; NULL means the
+-- deployment's default environment
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS environment VARCHAR(10);
+
+-- Webhook secrets per merchant; more than one may be active while a secret is rotated.
+-- Merchants without any are verified with their Cashfree client secret.
+CREATE TABLE IF NOT EXISTS merchant_webhook_secrets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    merchant_id UUID NOT NULL REFERENCES merchants(id),
+    secret TEXT NOT NULL, -- AES-256-GCM encrypted with MERCHANT_ENCRYPTION_KEY
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_merchant_webhook_secrets_merchant_id ON merchant_webhook_secrets(merchant_id);
+
+-- URL templates for the notify_url and return_url of a merchant's orders
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS notify_url_template TEXT;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS return_url_template TEXT;
+
+-- Changes made to merchants through the admin API
+CREATE TABLE IF NOT EXISTS merchant_audit_log (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    merchant_id UUID NOT NULL REFERENCES merchants(id),
+    action VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    remote_ip VARCHAR(45) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_merchant_audit_log_merchant_id ON merchant_audit_log(merchant_id, created_at);
+
+-- Daily quotas per merchant; NULL means unlimited
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS daily_order_limit INTEGER;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS daily_refund_limit DECIMAL(18,3);
+
+-- Usage counted against the daily quotas, per merchant and business day in the
+-- merchant's time zone (REPORT_TIMEZONE for merchants without one)
+CREATE TABLE IF NOT EXISTS tenant_usage (
+    tenant_id UUID NOT NULL REFERENCES merchants(id),
+    day DATE NOT NULL,
+    orders INTEGER NOT NULL DEFAULT 0,
+    refund_amount DECIMAL(18,3) NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant_id, day)
+);
+
+-- Hosts a merchant's callers may send as return_url and notify_url; NULL uses the
+-- deployment's ALLOWED_URL_HOSTS
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS allowed_url_hosts TEXT[];
+
+-- Hosted checkout theme per merchant; empty values use the deployment's CHECKOUT_* settings
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS checkout_display_name VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS checkout_logo_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS checkout_theme_color VARCHAR(7) NOT NULL DEFAULT '';
+
+-- Currency metadata: the number of decimal digits in the order currency, and the
+-- reference rate to INR when the order was created (NULL when none was configured).
+-- Amounts widen to three decimals for currencies such as KWD and BHD.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS currency_exponent SMALLINT NOT NULL DEFAULT 2;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS fx_rate_inr DECIMAL(18,8);
+ALTER TABLE payments ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE refunds ALTER COLUMN amount TYPE DECIMAL(18,3);
+
+-- Time zone of a merchant's business day for reports and quotas; empty uses the
+-- deployment's REPORT_TIMEZONE
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Reconciliation of local payments against Cashfree, one run per period and
+-- environment, with an item for every order the two disagree on
+CREATE TABLE IF NOT EXISTS recon_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID REFERENCES merchants(id),
+    environment VARCHAR(10) NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    orders_checked INTEGER NOT NULL DEFAULT 0,
+    mismatches INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recon_runs_tenant_id ON recon_runs(tenant_id, started_at);
+
+CREATE TABLE IF NOT EXISTS recon_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    run_id UUID NOT NULL REFERENCES recon_runs(id) ON DELETE CASCADE,
+    order_id VARCHAR(255) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    local_status VARCHAR(50),
+    remote_status VARCHAR(50),
+    local_amount DECIMAL(18,3),
+    remote_amount DECIMAL(18,3),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_recon_items_run_id ON recon_items(run_id);
+
+-- Settlement reconciliation matches settlement UTRs against credits imported from
+-- bank statements. A UTR is imported once per merchant.
+ALTER TABLE recon_runs ADD COLUMN IF NOT EXISTS type VARCHAR(20) NOT NULL DEFAULT 'PAYMENTS';
+ALTER TABLE recon_runs ADD COLUMN IF NOT EXISTS settlements_checked INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE recon_items ADD COLUMN IF NOT EXISTS utr VARCHAR(255);
+
+CREATE TABLE IF NOT EXISTS bank_statement_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID REFERENCES merchants(id),
+    utr VARCHAR(255) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
+    value_date DATE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    source VARCHAR(10) NOT NULL,
+    imported_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_bank_statement_entries_utr
+    ON bank_statement_entries(COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'), utr);
+
+-- Maker-checker approval of large refunds. A refund awaiting approval has no Cashfree
+-- refund ID until it is approved and sent to Cashfree.
+ALTER TABLE refunds ALTER COLUMN cf_refund_id DROP NOT NULL;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS requested_by VARCHAR(255);
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS approved_by VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_status ON refunds(status);
+
+CREATE TABLE IF NOT EXISTS refund_audit_log (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    refund_id VARCHAR(255) NOT NULL REFERENCES refunds(refund_id) ON DELETE CASCADE,
+    action VARCHAR(50) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    remote_ip VARCHAR(45) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_refund_audit_log_refund_id ON refund_audit_log(refund_id, created_at);
+
+-- Reminders of abandoned checkouts. Each reminder of an order is recorded once, so two
+-- instances of the job cannot both send it.
+CREATE TABLE IF NOT EXISTS checkout_reminders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID REFERENCES merchants(id),
+    order_id VARCHAR(255) NOT NULL REFERENCES payments(order_id) ON DELETE CASCADE,
+    customer_id VARCHAR(255) NOT NULL,
+    attempt INTEGER NOT NULL,
+    sent_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (order_id, attempt)
+);
+
+CREATE INDEX IF NOT EXISTS idx_checkout_reminders_customer ON checkout_reminders(customer_id, sent_at);
+CREATE INDEX IF NOT EXISTS idx_checkout_reminders_sent_at ON checkout_reminders(sent_at);
+
+CREATE TABLE IF NOT EXISTS customer_reminder_preferences (
+    tenant_id UUID REFERENCES merchants(id),
+    customer_id VARCHAR(255) NOT NULL,
+    opted_out BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customer_reminder_preferences_customer
+    ON customer_reminder_preferences(COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'), customer_id);
+
+-- Refund speed: the speed a refund was requested at, the mode the gateway processes it
+-- at, and the bank's ARN once it is processed
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS refund_speed VARCHAR(20) NOT NULL DEFAULT 'STANDARD';
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS refund_mode VARCHAR(20);
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS refund_arn VARCHAR(255);
+
+-- Refund splits: the part of a refund clawed back from each vendor of a split settlement
+CREATE TABLE IF NOT EXISTS refund_splits (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID REFERENCES merchants(id),
+    refund_id VARCHAR(255) NOT NULL REFERENCES refunds(refund_id) ON DELETE CASCADE,
+    order_id VARCHAR(255) NOT NULL REFERENCES payments(order_id) ON DELETE CASCADE,
+    vendor_id VARCHAR(255) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_refund_splits_refund_id ON refund_splits(refund_id);
+CREATE INDEX IF NOT EXISTS idx_refund_splits_order_vendor ON refund_splits(order_id, vendor_id);
+
+-- Reversals recorded automatically when a split order is fully refunded
+ALTER TABLE refund_splits ADD COLUMN IF NOT EXISTS automatic BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS idx_refund_splits_vendor_id ON refund_splits(vendor_id);
+
+-- Platform fees deducted from vendor splits when they are created
+CREATE TABLE IF NOT EXISTS split_fees (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID REFERENCES merchants(id),
+    split_id UUID NOT NULL REFERENCES split_settlements(id) ON DELETE CASCADE,
+    order_id VARCHAR(255) NOT NULL REFERENCES payments(order_id) ON DELETE CASCADE,
+    vendor_id VARCHAR(255) NOT NULL,
+    fee_type VARCHAR(20) NOT NULL CHECK (fee_type IN ('COMMISSION', 'FIXED')),
+    rate DECIMAL(5,2),
+    amount DECIMAL(18,3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_split_fees_split_id ON split_fees(split_id);
+CREATE INDEX IF NOT EXISTS idx_split_fees_vendor_id ON split_fees(vendor_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_split_fees_created_at ON split_fees(created_at);
+
+-- Gateway charges Cashfree reports on each payment
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gateway_fee DECIMAL(18,3);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gateway_tax DECIMAL(18,3);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS net_amount DECIMAL(18,3);
+ALTER TABLE recon_items ADD COLUMN IF NOT EXISTS gateway_charges DECIMAL(18,3);
+
+-- Tags and internal notes on payments, editable after creation
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS tags JSONB;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS notes TEXT;
+CREATE INDEX IF NOT EXISTS idx_payments_tags ON payments USING GIN (tags);
+
+-- Scheduled payment sessions are stored before their gateway order exists, and keep the
+-- URLs to create it with
+ALTER TABLE payments ALTER COLUMN cf_order_id DROP NOT NULL;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS return_url TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS notify_url TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS activate_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_payments_scheduled ON payments(activate_at) WHERE status IN ('SCHEDULED', 'ACTIVATING');
+
+-- Installment plan of payments made on EMI
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS emi_details JSONB;
+
+-- Risk screening of new payment sessions and the manual review queue
+CREATE TABLE IF NOT EXISTS risk_assessments (
+    id UUID PRIMARY KEY,
+    tenant_id UUID REFERENCES merchants(id),
+    order_id VARCHAR(255) NOT NULL,
+    customer_id VARCHAR(255) NOT NULL,
+    device_id VARCHAR(255),
+    remote_ip VARCHAR(64) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    decision VARCHAR(10) NOT NULL CHECK (decision IN ('ALLOW', 'REVIEW', 'BLOCK')),
+    verdicts JSONB,
+    review_status VARCHAR(10) CHECK (review_status IN ('PENDING', 'APPROVED', 'REJECTED')),
+    reviewed_by VARCHAR(255),
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_risk_assessments_customer ON risk_assessments(customer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_risk_assessments_device ON risk_assessments(device_id, created_at) WHERE device_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_risk_assessments_pending ON risk_assessments(created_at) WHERE review_status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_risk_assessments_order_id ON risk_assessments(order_id);
+
+-- Blocked customers and cards, and the orders they matched
+CREATE TABLE IF NOT EXISTS blocklist (
+    id UUID PRIMARY KEY,
+    tenant_id UUID REFERENCES merchants(id),
+    type VARCHAR(20) NOT NULL CHECK (type IN ('EMAIL', 'PHONE', 'CUSTOMER_ID', 'CARD_FINGERPRINT')),
+    value VARCHAR(255) NOT NULL,
+    reason TEXT,
+    created_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_blocklist_value ON blocklist(type, value, COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'));
+
+CREATE TABLE IF NOT EXISTS blocklist_matches (
+    id UUID PRIMARY KEY,
+    entry_id UUID REFERENCES blocklist(id) ON DELETE SET NULL,
+    tenant_id UUID REFERENCES merchants(id),
+    type VARCHAR(20) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    order_id VARCHAR(255) NOT NULL,
+    stage VARCHAR(20) NOT NULL,
+    remote_ip VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_blocklist_matches_created_at ON blocklist_matches(created_at);
+CREATE INDEX IF NOT EXISTS idx_blocklist_matches_order_id ON blocklist_matches(order_id);
+
+-- Bank and dispute communications reference Cashfree's payment ID
+CREATE INDEX IF NOT EXISTS idx_payments_cf_payment_id ON payments(cf_payment_id);
+
+-- Line items of an order's cart, shown on the hosted checkout and on receipts
+CREATE TABLE IF NOT EXISTS order_items (
+    id UUID PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL REFERENCES payments(order_id) ON DELETE CASCADE,
+    tenant_id UUID REFERENCES merchants(id),
+    position INTEGER NOT NULL,
+    item_id VARCHAR(100),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    quantity INTEGER NOT NULL,
+    unit_price DECIMAL(18,3) NOT NULL,
+    image_url TEXT,
+    details_url TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items(order_id, position);
+
+-- Payment methods an order's checkout is restricted to, kept for scheduled orders
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payment_methods VARCHAR(255);
+
+-- Callers' idempotency references for refunds, unique per order
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS refund_reference VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refunds_order_reference ON refunds(order_id, refund_reference) WHERE refund_reference IS NOT NULL;
+
+-- Every published event in publication order, for consumers resuming from an offset
+CREATE TABLE IF NOT EXISTS event_log (
+    "offset" BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,
+    type VARCHAR(64) NOT NULL,
+    order_id VARCHAR(255),
+    data JSONB,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Merchant endpoints our events are delivered to, each with its own signing secret
+-- and retry schedule, and a log of every delivery and attempt
+CREATE TABLE IF NOT EXISTS event_endpoints (
+    id UUID PRIMARY KEY,
+    tenant_id UUID REFERENCES merchants(id),
+    url TEXT NOT NULL,
+    description TEXT,
+    event_types TEXT[] NOT NULL DEFAULT '{}', -- empty for every type
+    retry_schedule INTEGER[] NOT NULL, -- seconds before each retry
+    secret TEXT NOT NULL,
+    secret_encrypted BOOLEAN NOT NULL DEFAULT FALSE, -- with MERCHANT_ENCRYPTION_KEY
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_endpoints_tenant_id ON event_endpoints(tenant_id);
+
+CREATE TABLE IF NOT EXISTS event_deliveries (
+    id UUID PRIMARY KEY,
+    endpoint_id UUID NOT NULL REFERENCES event_endpoints(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    order_id VARCHAR(255),
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    last_status_code INTEGER,
+    last_error TEXT,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (endpoint_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_deliveries_due ON event_deliveries(next_attempt_at) WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS idx_event_deliveries_endpoint ON event_deliveries(endpoint_id, created_at);
+
+CREATE TABLE IF NOT EXISTS event_delivery_attempts (
+    id UUID PRIMARY KEY,
+    delivery_id UUID NOT NULL REFERENCES event_deliveries(id) ON DELETE CASCADE,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms INTEGER NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_delivery_attempts_delivery ON event_delivery_attempts(delivery_id, attempt);
+
+-- When the status poller last asked the gateway about an order still awaiting its webhook
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS status_polled_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_payments_pollable ON payments(COALESCE(activate_at, created_at)) WHERE status = 'ACTIVE';
+
+-- Net settlement breakdown: the order's gross amount less gateway fees, the tax on them
+-- and refunds is what the settlement should pay. Left NULL for settlements recorded
+-- before it, and the charges and net while the gateway has not reported the charges.
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS gross_amount DECIMAL(18,3);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS gateway_fee DECIMAL(18,3);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS gateway_tax DECIMAL(18,3);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS refund_adjustment DECIMAL(18,3);
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS net_payable DECIMAL(18,3);
+
+-- Append-only store of domain events: each order's creation and status changes, its
+-- refunds and the webhooks received for it, recorded by triggers in the transaction that
+-- made the change. sequence orders every event and order_sequence counts an order's
+-- events from 1 without gaps.
+CREATE TABLE IF NOT EXISTS events (
+    sequence BIGSERIAL PRIMARY KEY,
+    tenant_id UUID REFERENCES merchants(id),
+    order_id VARCHAR(255) NOT NULL,
+    order_sequence INTEGER NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}',
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (order_id, order_sequence)
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_tenant_id ON events(tenant_id, sequence);
+
+-- append_event records an event of an order, numbering it after the order's last one.
+-- Appends to the same order are serialized until their transaction ends, so they number
+-- its events in turn; appends to other orders go on in parallel. Each holds the
+-- "eventsto" lock shared, which readers of the whole store take exclusively (see
+-- ReadDomainEvents), so a reader resuming after a sequence never skips an event committed
+-- late.
+CREATE OR REPLACE FUNCTION append_event(event_tenant UUID, event_order VARCHAR, event_type VARCHAR, event_data JSONB)
+RETURNS VOID AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock_shared(x'6576656e7473746f'::bigint); -- "eventsto"
+    PERFORM pg_advisory_xact_lock(x'65766e74'::int, hashtext(COALESCE(event_tenant::text, '') || '/' || event_order)); -- "evnt"
+    INSERT INTO events (tenant_id, order_id, order_sequence, type, data)
+    SELECT event_tenant, event_order, COALESCE(MAX(order_sequence), 0) + 1, event_type, event_data
+    FROM events WHERE tenant_id IS NOT DISTINCT FROM event_tenant AND order_id = event_order;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION record_payment_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        PERFORM append_event(NEW.tenant_id, NEW.order_id, 'order.created', jsonb_build_object(
+            'status', NEW.status, 'amount', NEW.amount, 'currency', NEW.currency, 'gateway', NEW.gateway));
+    ELSIF OLD.status IS DISTINCT FROM NEW.status THEN
+        PERFORM append_event(NEW.tenant_id, NEW.order_id, 'order.status_changed', jsonb_strip_nulls(jsonb_build_object(
+            'from', OLD.status, 'to', NEW.status, 'cf_payment_id', NEW.cf_payment_id, 'payment_method', NEW.payment_method)));
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION record_refund_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        PERFORM append_event(NEW.tenant_id, NEW.order_id, 'refund.requested', jsonb_build_object(
+            'refund_id', NEW.refund_id, 'amount', NEW.amount, 'status', NEW.status,
+            'refund_type', NEW.refund_type));
+    ELSIF OLD.status IS DISTINCT FROM NEW.status THEN
+        PERFORM append_event(NEW.tenant_id, NEW.order_id,
+            CASE WHEN NEW.status IN ('SUCCESS', 'FAILED', 'CANCELLED') THEN 'refund.processed' ELSE 'refund.status_changed' END,
+            jsonb_build_object('refund_id', NEW.refund_id, 'from', OLD.status, 'to', NEW.status));
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION record_webhook_event()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.order_id IS NOT NULL THEN
+        PERFORM append_event(NEW.tenant_id, NEW.order_id, 'webhook.received', jsonb_build_object(
+            'webhook_id', NEW.id, 'webhook_type', NEW.event_type));
+    END IF;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION reject_event_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'events are append-only';
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE TRIGGER record_payment_events AFTER INSERT OR UPDATE OF status ON payments
+    FOR EACH ROW EXECUTE FUNCTION record_payment_event();
+
+CREATE OR REPLACE TRIGGER record_refund_events AFTER INSERT OR UPDATE OF status ON refunds
+    FOR EACH ROW EXECUTE FUNCTION record_refund_event();
+
+CREATE OR REPLACE TRIGGER record_webhook_events AFTER INSERT ON webhooks
+    FOR EACH ROW EXECUTE FUNCTION record_webhook_event();
+
+CREATE OR REPLACE TRIGGER events_append_only BEFORE UPDATE OR DELETE ON events
+    FOR EACH ROW EXECUTE FUNCTION reject_event_change();
+
+-- Refund types as Cashfree names them: MERCHANT_INITIATED for refunds the merchant asked
+-- for, through the service or the Cashfree dashboard, and PAYMENT_AUTO_REFUND for those
+-- Cashfree made on its own
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS refund_type VARCHAR(32) NOT NULL DEFAULT 'MERCHANT_INITIATED';
+
+-- What a payment was made with: the masked card, UPI VPA or bank, for support and risk
+-- review
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS payment_method_details JSONB;
+
+-- Every attempt to pay an order, reconciled from the gateway's list of them, so a
+-- successful attempt is kept however many attempts were made after it
+CREATE TABLE IF NOT EXISTS payment_attempts (
+    id UUID PRIMARY KEY,
+    order_id VARCHAR(255) NOT NULL REFERENCES payments(order_id) ON DELETE CASCADE,
+    tenant_id UUID REFERENCES merchants(id),
+    cf_payment_id VARCHAR(255) NOT NULL UNIQUE,
+    status VARCHAR(50) NOT NULL,
+    amount DECIMAL(18,3) NOT NULL,
+    payment_method VARCHAR(50),
+    payment_time TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_order_id ON payment_attempts(order_id);
+
+-- Async tasks of the Postgres queue backend (QUEUE_BACKEND=postgres). Workers claim due
+-- tasks with FOR UPDATE SKIP LOCKED, hiding them until visible_at; tasks that keep
+-- failing are kept with dead_at set.
+CREATE TABLE IF NOT EXISTS task_queue (
+    id VARCHAR(64) PRIMARY KEY,
+    task_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    enqueued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    visible_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_error TEXT,
+    dead_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_queue_due ON task_queue(visible_at) WHERE dead_at IS NULL;
+
+-- Status check constraints, generated by `payment-getway constraints` from the domain package
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check CHECK (status IN ('CREATED', 'ACTIVE', 'PAID', 'SUCCESS', 'FAILED', 'EXPIRED', 'CANCELLED', 'TERMINATED', 'TERMINATION_REQUESTED', 'SCHEDULED', 'ACTIVATING', 'PENDING_REVIEW', 'AMOUNT_MISMATCH')) NOT VALID;
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_status_check;
+ALTER TABLE refunds ADD CONSTRAINT refunds_status_check CHECK (status IN ('PENDING', 'SUCCESS', 'CANCELLED', 'FAILED', 'ONHOLD', 'PENDING_APPROVAL', 'APPROVED', 'REJECTED')) NOT VALID;
+ALTER TABLE settlements DROP CONSTRAINT IF EXISTS settlements_status_check;
+ALTER TABLE settlements ADD CONSTRAINT settlements_status_check CHECK (status IN ('PENDING', 'SUCCESS', 'FAILED')) NOT VALID;
+ALTER TABLE split_settlements DROP CONSTRAINT IF EXISTS split_settlements_status_check;
+ALTER TABLE split_settlements ADD CONSTRAINT split_settlements_status_check CHECK (status IN ('PENDING', 'SUCCESS', 'FAILED')) NOT VALID;
+ALTER TABLE webhooks DROP CONSTRAINT IF EXISTS webhooks_status_check;
+ALTER TABLE webhooks ADD CONSTRAINT webhooks_status_check CHECK (status IN ('RECEIVED', 'FAILED', 'REPLAYED')) NOT VALID;
+ALTER TABLE event_deliveries DROP CONSTRAINT IF EXISTS event_deliveries_status_check;
+ALTER TABLE event_deliveries ADD CONSTRAINT event_deliveries_status_check CHECK (status IN ('PENDING', 'DELIVERED', 'FAILED')) NOT VALID;
+
+-- Notification preferences: the channels each customer agreed to be sent payment links
+-- and reminders on. NULL means never stated. Every change is kept in the consent audit.
+CREATE TABLE IF NOT EXISTS customer_preferences (
+    tenant_id UUID REFERENCES merchants(id),
+    customer_id VARCHAR(255) NOT NULL,
+    email_opt_in BOOLEAN,
+    sms_opt_in BOOLEAN,
+    whatsapp_opt_in BOOLEAN,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_customer_preferences_customer
+    ON customer_preferences(COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'), customer_id);
+
+CREATE TABLE IF NOT EXISTS customer_consent_audit (
+    id UUID PRIMARY KEY,
+    tenant_id UUID REFERENCES merchants(id),
+    customer_id VARCHAR(255) NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    opted_in BOOLEAN NOT NULL,
+    previous BOOLEAN,
+    actor VARCHAR(255) NOT NULL,
+    remote_ip VARCHAR(45) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_customer_consent_audit_customer ON customer_consent_audit(customer_id, changed_at);
+
+-- Look up the settlements of a bank credit by its UTR
+CREATE INDEX IF NOT EXISTS idx_settlements_utr ON settlements(utr);
+
+-- Progress of re-encrypting the encrypted columns with a new key, by the rotate-keys command
+CREATE TABLE IF NOT EXISTS key_rotations (
+    key_id VARCHAR(64) NOT NULL, -- '' is MERCHANT_ENCRYPTION_KEY
+    table_name VARCHAR(64) NOT NULL,
+    column_name VARCHAR(64) NOT NULL,
+    last_id UUID, -- the last row re-encrypted, where an interrupted rotation resumes
+    processed INTEGER NOT NULL DEFAULT 0,
+    rotated INTEGER NOT NULL DEFAULT 0,
+    total INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (key_id, table_name, column_name)
+);
+
+-- Refunds whose creation call had no definite answer, settled by the status poller
+CREATE INDEX IF NOT EXISTS idx_refunds_unconfirmed ON refunds(created_at) WHERE status = 'PENDING' AND cf_refund_id IS NULL;
+
+-- Order IDs are unique per merchant. tenant_key is tenant_id with single-merchant
+-- records under the nil UUID, so the records of an order reference it by
+-- (tenant_key, order_id) whether or not it has a merchant.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE refunds ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE settlements ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE split_settlements ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE checkout_reminders ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE refund_splits ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE split_fees ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE payment_attempts ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+ALTER TABLE events ADD COLUMN IF NOT EXISTS tenant_key UUID GENERATED ALWAYS AS (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000')) STORED;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_tenant_order ON payments(tenant_key, order_id);
+
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_order_id_fkey;
+ALTER TABLE refunds DROP CONSTRAINT IF EXISTS refunds_tenant_order_fkey;
+ALTER TABLE refunds ADD CONSTRAINT refunds_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE settlements DROP CONSTRAINT IF EXISTS settlements_order_id_fkey;
+ALTER TABLE settlements DROP CONSTRAINT IF EXISTS settlements_tenant_order_fkey;
+ALTER TABLE settlements ADD CONSTRAINT settlements_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE split_settlements DROP CONSTRAINT IF EXISTS split_settlements_order_id_fkey;
+ALTER TABLE split_settlements DROP CONSTRAINT IF EXISTS split_settlements_tenant_order_fkey;
+ALTER TABLE split_settlements ADD CONSTRAINT split_settlements_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE checkout_reminders DROP CONSTRAINT IF EXISTS checkout_reminders_order_id_fkey;
+ALTER TABLE checkout_reminders DROP CONSTRAINT IF EXISTS checkout_reminders_tenant_order_fkey;
+ALTER TABLE checkout_reminders ADD CONSTRAINT checkout_reminders_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE refund_splits DROP CONSTRAINT IF EXISTS refund_splits_order_id_fkey;
+ALTER TABLE refund_splits DROP CONSTRAINT IF EXISTS refund_splits_tenant_order_fkey;
+ALTER TABLE refund_splits ADD CONSTRAINT refund_splits_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE split_fees DROP CONSTRAINT IF EXISTS split_fees_order_id_fkey;
+ALTER TABLE split_fees DROP CONSTRAINT IF EXISTS split_fees_tenant_order_fkey;
+ALTER TABLE split_fees ADD CONSTRAINT split_fees_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_order_id_fkey;
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_tenant_order_fkey;
+ALTER TABLE order_items ADD CONSTRAINT order_items_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+ALTER TABLE payment_attempts DROP CONSTRAINT IF EXISTS payment_attempts_order_id_fkey;
+ALTER TABLE payment_attempts DROP CONSTRAINT IF EXISTS payment_attempts_tenant_order_fkey;
+ALTER TABLE payment_attempts ADD CONSTRAINT payment_attempts_tenant_order_fkey
+    FOREIGN KEY (tenant_key, order_id) REFERENCES payments(tenant_key, order_id) ON DELETE CASCADE;
+
+ALTER TABLE checkout_reminders DROP CONSTRAINT IF EXISTS checkout_reminders_order_id_attempt_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_checkout_reminders_tenant_order_attempt ON checkout_reminders(tenant_key, order_id, attempt);
+DROP INDEX IF EXISTS idx_refunds_order_reference;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refunds_tenant_order_reference
+    ON refunds(tenant_key, order_id, refund_reference) WHERE refund_reference IS NOT NULL;
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_order_id_order_sequence_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_tenant_order_sequence ON events(tenant_key, order_id, order_sequence);
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_order_id_key;
+
+-- Every money column holds three decimals, as payments.amount and refunds.amount do, so
+-- amounts in currencies such as KWD and BHD are not rounded. Databases created before
+-- the columns above were widened are altered here.
+ALTER TABLE settlements ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE settlements ALTER COLUMN gross_amount TYPE DECIMAL(18,3);
+ALTER TABLE settlements ALTER COLUMN gateway_fee TYPE DECIMAL(18,3);
+ALTER TABLE settlements ALTER COLUMN gateway_tax TYPE DECIMAL(18,3);
+ALTER TABLE settlements ALTER COLUMN refund_adjustment TYPE DECIMAL(18,3);
+ALTER TABLE settlements ALTER COLUMN net_payable TYPE DECIMAL(18,3);
+ALTER TABLE split_settlements ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE refund_splits ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE split_fees ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE payments ALTER COLUMN gateway_fee TYPE DECIMAL(18,3);
+ALTER TABLE payments ALTER COLUMN gateway_tax TYPE DECIMAL(18,3);
+ALTER TABLE payments ALTER COLUMN net_amount TYPE DECIMAL(18,3);
+ALTER TABLE payment_attempts ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE order_items ALTER COLUMN unit_price TYPE DECIMAL(18,3);
+ALTER TABLE risk_assessments ALTER COLUMN amount TYPE DECIMAL(18,3);
+ALTER TABLE tenant_usage ALTER COLUMN refund_amount TYPE DECIMAL(18,3);
+ALTER TABLE merchants ALTER COLUMN daily_refund_limit TYPE DECIMAL(18,3);
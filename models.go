@@ -3,27 +3,55 @@ package main
 import (
 	"time"
 	"github.com/google/uuid"
+
+	"payment-getway/domain"
 )
 
 // Payment represents a payment transaction
 type Payment struct {
-	ID             uuid.UUID  `json:"id" db:"id"`
-	OrderID        string     `json:"order_id" db:"order_id"`
-	CFOrderID      string     `json:"cf_order_id" db:"cf_order_id"`
-	Amount         float64    `json:"amount" db:"amount"`
-	Currency       string     `json:"currency" db:"currency"`
-	Status         string     `json:"status" db:"status"`
-	PaymentMethod  *string    `json:"payment_method,omitempty" db:"payment_method"`
-	CustomerID     string     `json:"customer_id" db:"customer_id"`
-	CustomerName   string     `json:"customer_name" db:"customer_name"`
-	CustomerEmail  string     `json:"customer_email" db:"customer_email"`
-	CustomerPhone  string     `json:"customer_phone" db:"customer_phone"`
-	Description    *string    `json:"description,omitempty" db:"description"`
-	PaymentURL     *string    `json:"payment_url,omitempty" db:"payment_url"`
-	CFPaymentID    *string    `json:"cf_payment_id,omitempty" db:"cf_payment_id"`
-	PaymentTime    *time.Time `json:"payment_time,omitempty" db:"payment_time"`
-	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+	ID               uuid.UUID  `json:"id" db:"id"`
+	TenantID         *uuid.UUID `json:"tenant_id,omitempty" db:"tenant_id"`
+	OrderID          string     `json:"order_id" db:"order_id"`
+	CFOrderID        string     `json:"cf_order_id" db:"cf_order_id"`
+	Amount           float64    `json:"amount" db:"amount"`
+	Currency         string     `json:"currency" db:"currency"`
+	CurrencyExponent int        `json:"currency_exponent" db:"currency_exponent"` // decimal digits in the currency's amounts
+	FXRateINR        *float64   `json:"fx_rate_inr,omitempty" db:"fx_rate_inr"`   // reference rate to INR when the order was created
+	GatewayFee       *float64   `json:"gateway_fee,omitempty" db:"gateway_fee"`   // the gateway's service charge on the payment
+	GatewayTax       *float64   `json:"gateway_tax,omitempty" db:"gateway_tax"`   // tax on the service charge
+	NetAmount        *float64   `json:"net_amount,omitempty" db:"net_amount"`     // amount the gateway settles after its charges
+	Status           domain.PaymentStatus     `json:"status" db:"status"`
+	Gateway          string     `json:"gateway" db:"gateway"`
+	Environment      *string    `json:"environment,omitempty" db:"environment"`
+	PaymentMethod    *string    `json:"payment_method,omitempty" db:"payment_method"`
+	CustomerID       string     `json:"customer_id" db:"customer_id"`
+	CustomerName     string     `json:"customer_name" db:"customer_name"`
+	CustomerEmail    string     `json:"customer_email" db:"customer_email"`
+	CustomerPhone    string     `json:"customer_phone" db:"customer_phone"`
+	Description      *string    `json:"description,omitempty" db:"description"`
+	Notes            *string    `json:"notes,omitempty" db:"notes"` // internal, never sent to the gateway
+	PaymentURL       *string    `json:"payment_url,omitempty" db:"payment_url"`
+	ReturnURL        *string    `json:"return_url,omitempty" db:"return_url"` // kept for scheduled orders
+	NotifyURL        *string    `json:"notify_url,omitempty" db:"notify_url"` // kept for scheduled orders
+	PaymentMethods   *string    `json:"payment_methods,omitempty" db:"payment_methods"` // methods the order is restricted to, such as "upi,cc"
+	ActivateAt       *time.Time `json:"activate_at,omitempty" db:"activate_at"` // when a scheduled order is created with the gateway
+	CFPaymentID      *string    `json:"cf_payment_id,omitempty" db:"cf_payment_id"`
+	PaymentTime      *time.Time `json:"payment_time,omitempty" db:"payment_time"`
+	GSTIN            *string    `json:"gstin,omitempty" db:"gstin"`
+	PlaceOfSupply    *string    `json:"place_of_supply,omitempty" db:"place_of_supply"`
+	TaxRate          *float64   `json:"tax_rate,omitempty" db:"tax_rate"`
+	HSNCode          *string    `json:"hsn_code,omitempty" db:"hsn_code"`
+	InvoiceNumber    *string    `json:"invoice_number,omitempty" db:"invoice_number"`
+	InvoiceDate      *time.Time `json:"invoice_date,omitempty" db:"invoice_date"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+
+	Tags map[string]string `json:"tags,omitempty" db:"tags"`
+	EMI  *EMIDetails       `json:"emi_details,omitempty" db:"emi_details"` // installment plan of a payment made on EMI
+
+	MethodDetails *PaymentMethodDetails `json:"payment_method_details,omitempty" db:"payment_method_details"` // masked card, VPA or bank of the payment
+
+	Items []OrderItem `json:"items,omitempty" db:"-"` // cart line items, stored in order_items
 }
 
 // Refund represents a refund transaction
@@ -34,11 +62,21 @@ type Refund struct {
 	OrderID     string     `json:"order_id" db:"order_id"`
 	CFOrderID   string     `json:"cf_order_id" db:"cf_order_id"`
 	Amount      float64    `json:"amount" db:"amount"`
-	Status      string     `json:"status" db:"status"`
+	Status      domain.RefundStatus     `json:"status" db:"status"`
 	Reason      *string    `json:"reason,omitempty" db:"reason"`
+	Speed       string     `json:"refund_speed" db:"refund_speed"`           // speed requested: STANDARD or INSTANT
+	Mode        *string    `json:"refund_mode,omitempty" db:"refund_mode"`   // speed the gateway processes it at
+	ARN         *string    `json:"refund_arn,omitempty" db:"refund_arn"`     // bank reference once processed
+	Type        string     `json:"refund_type,omitempty" db:"refund_type"`   // MERCHANT_INITIATED, or PAYMENT_AUTO_REFUND when Cashfree made it
+	RequestedBy *string    `json:"requested_by,omitempty" db:"requested_by"` // maker of a refund that needs approval
+	ApprovedBy  *string    `json:"approved_by,omitempty" db:"approved_by"`   // checker who approved or rejected it
+	Reference   *string    `json:"refund_reference,omitempty" db:"refund_reference"` // the caller's idempotency reference
 	ProcessedAt *time.Time `json:"processed_at,omitempty" db:"processed_at"`
+	TenantID    *uuid.UUID `json:"tenant_id,omitempty" db:"tenant_id"`
 	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+
+	Splits []RefundSplit `json:"refund_splits,omitempty" db:"-"` // vendor reversals, stored in refund_splits
 }
 
 // Settlement represents settlement information
@@ -48,11 +86,15 @@ type Settlement struct {
 	OrderID      string    `json:"order_id" db:"order_id"`
 	CFOrderID    string    `json:"cf_order_id" db:"cf_order_id"`
 	Amount       float64   `json:"amount" db:"amount"`
-	Status       string    `json:"status" db:"status"`
+	Status       domain.SettlementStatus    `json:"status" db:"status"`
 	UTR          *string   `json:"utr,omitempty" db:"utr"`
 	SettledAt    *time.Time `json:"settled_at,omitempty" db:"settled_at"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+
+	// Breakdown is what the settlement should pay for its order; nil for settlements
+	// recorded before breakdowns were kept
+	Breakdown *SettlementBreakdown `json:"breakdown,omitempty" db:"-"`
 }
 
 // SplitSettlement represents split settlement configuration
@@ -61,42 +103,131 @@ type SplitSettlement struct {
 	OrderID         string    `json:"order_id" db:"order_id"`
 	CFOrderID       string    `json:"cf_order_id" db:"cf_order_id"`
 	VendorID        string    `json:"vendor_id" db:"vendor_id"`
-	Amount          float64   `json:"amount" db:"amount"`
+	Amount          float64   `json:"amount" db:"amount"` // the vendor's share, less platform fees
 	Percentage      *float64  `json:"percentage,omitempty" db:"percentage"`
 	SplitType       string    `json:"split_type" db:"split_type"` // "PERCENTAGE" or "AMOUNT"
-	Status          string    `json:"status" db:"status"`
+	Status          domain.SettlementStatus    `json:"status" db:"status"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt       time.Time `json:"updated_at" db:"updated_at"`
+
+	Fees []SplitFee `json:"fees,omitempty" db:"-"` // platform fees deducted from the share, stored in split_fees
 }
 
 // Webhook represents webhook logs
 type Webhook struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	EventType string    `json:"event_type" db:"event_type"`
-	OrderID   *string   `json:"order_id,omitempty" db:"order_id"`
-	Payload   string    `json:"payload" db:"payload"`
-	Status    string    `json:"status" db:"status"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID        uuid.UUID  `json:"id" db:"id"`
+	EventType domain.WebhookType     `json:"event_type" db:"event_type"`
+	OrderID   *string    `json:"order_id,omitempty" db:"order_id"`
+	Payload   string     `json:"payload" db:"payload"`
+	Status    domain.WebhookStatus     `json:"status" db:"status"`
+	TenantID  *uuid.UUID `json:"tenant_id,omitempty" db:"tenant_id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ReconRun represents a reconciliation of local payments against Cashfree for a period
+type ReconRun struct {
+	ID                 uuid.UUID   `json:"id" db:"id"`
+	Type               string      `json:"type" db:"type"` // PAYMENTS or SETTLEMENTS
+	Environment        string      `json:"environment" db:"environment"`
+	PeriodStart        time.Time   `json:"period_start" db:"period_start"`
+	PeriodEnd          time.Time   `json:"period_end" db:"period_end"`
+	Status             string      `json:"status" db:"status"` // RUNNING, COMPLETED or FAILED
+	OrdersChecked      int         `json:"orders_checked" db:"orders_checked"`
+	SettlementsChecked int         `json:"settlements_checked" db:"settlements_checked"`
+	Mismatches         int         `json:"mismatches" db:"mismatches"`
+	Error              *string     `json:"error,omitempty" db:"error"`
+	StartedAt          time.Time   `json:"started_at" db:"started_at"`
+	FinishedAt         *time.Time  `json:"finished_at,omitempty" db:"finished_at"`
+	Items              []ReconItem `json:"items,omitempty"`
+}
+
+// ReconItem represents an order or settlement whose local record and Cashfree or the
+// bank disagree
+type ReconItem struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	RunID        uuid.UUID `json:"run_id" db:"run_id"`
+	OrderID      string    `json:"order_id,omitempty" db:"order_id"`
+	UTR          *string   `json:"utr,omitempty" db:"utr"`
+	Kind         string    `json:"kind" db:"kind"` // MISSING_LOCAL, MISSING_REMOTE, STATUS_MISMATCH, AMOUNT_MISMATCH, UNSETTLED, SHORT_SETTLED or NET_MISMATCH
+	LocalStatus  *string   `json:"local_status,omitempty" db:"local_status"`
+	RemoteStatus *string   `json:"remote_status,omitempty" db:"remote_status"`
+	LocalAmount  *float64  `json:"local_amount,omitempty" db:"local_amount"`
+	RemoteAmount *float64  `json:"remote_amount,omitempty" db:"remote_amount"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+
+	GatewayCharges *float64 `json:"gateway_charges,omitempty" db:"gateway_charges"` // fee and tax the gateway deducted from the order or the UTR's orders
+}
+
+// BankStatementEntry represents a credit imported from a bank statement
+type BankStatementEntry struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	UTR         string    `json:"utr" db:"utr"`
+	Amount      float64   `json:"amount" db:"amount"`
+	ValueDate   time.Time `json:"value_date" db:"value_date"`
+	Description string    `json:"description,omitempty" db:"description"`
+	Source      string    `json:"source" db:"source"` // CSV or MT940
+	ImportedAt  time.Time `json:"imported_at" db:"imported_at"`
 }
 
 // CreatePaymentSessionRequest represents the request to create a payment session
 type CreatePaymentSessionRequest struct {
 	OrderID       string  `json:"order_id" binding:"required"`
 	Amount        float64 `json:"amount" binding:"required,gt=0"`
-	Currency      string  `json:"currency" binding:"required"`
+	Currency      string  `json:"currency" binding:"required,len=3"` // ISO 4217 code
 	CustomerID    string  `json:"customer_id" binding:"required"`
 	CustomerName  string  `json:"customer_name" binding:"required"`
 	CustomerEmail string  `json:"customer_email" binding:"required,email"`
 	CustomerPhone string  `json:"customer_phone" binding:"required"`
 	Description   *string `json:"description,omitempty"`
-	ReturnURL     string  `json:"return_url" binding:"required,url"`
-	NotifyURL     string  `json:"notify_url" binding:"required,url"`
+	Notes         *string `json:"notes,omitempty" binding:"omitempty,max=2000"` // internal, never sent to the gateway
+	ReturnURL     string  `json:"return_url,omitempty" binding:"omitempty,url"` // defaults to the return URL template
+	NotifyURL     string  `json:"notify_url,omitempty" binding:"omitempty,url"` // defaults to the notify URL template
+
+	// ActivateAt schedules the order: it is created with the gateway, and its payment
+	// link sent to the customer, at that time rather than now
+	ActivateAt *time.Time `json:"activate_at,omitempty"`
+
+	// GST details for tax invoices
+	GSTIN         *string  `json:"gstin,omitempty" binding:"omitempty,len=15,alphanum"`
+	PlaceOfSupply *string  `json:"place_of_supply,omitempty" binding:"omitempty,len=2,numeric"`
+	TaxRate       *float64 `json:"tax_rate,omitempty" binding:"omitempty,gte=0,lte=28"`
+	HSNCode       *string  `json:"hsn_code,omitempty" binding:"omitempty,min=4,max=8,numeric"`
+
+	// Gateway forces a payment gateway; by default Cashfree is used with automatic failover
+	Gateway string `json:"gateway,omitempty" binding:"omitempty,oneof=cashfree razorpay"`
+
+	// Tags are stored with the payment and sent to the gateway as order tags
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// DeviceID is the customer's device fingerprint, for risk screening
+	DeviceID string `json:"device_id,omitempty" binding:"omitempty,max=255"`
+
+	// PaymentMethods restricts the checkout to a comma-separated list of Cashfree
+	// payment methods, such as "upi,cc,dc"
+	PaymentMethods string `json:"payment_methods,omitempty" binding:"omitempty,max=255"`
+
+	// Items itemize the cart on the hosted checkout and on receipts
+	Items []OrderItem `json:"items,omitempty" binding:"omitempty,dive"`
+
+	// Splits divide the order between vendors when it is created with Cashfree, so no
+	// split settlement call is needed once it is paid
+	Splits []SplitConfig `json:"order_splits,omitempty" binding:"omitempty,dive"`
 }
 
 // RefundRequest represents a refund request
 type RefundRequest struct {
 	Amount float64 `json:"amount" binding:"required,gt=0"`
 	Reason *string `json:"reason,omitempty"`
+	Speed  string  `json:"refund_speed,omitempty" binding:"omitempty,oneof=STANDARD INSTANT"`
+
+	// Reference is the caller's ID for the refund. A repeated request with the same
+	// reference returns the order's existing refund instead of creating another.
+	Reference string `json:"refund_reference,omitempty" binding:"omitempty,max=64"`
+
+	// Splits claws the refund back from vendors of the order's split settlement;
+	// ProportionalSplits does so in proportion to their splits instead
+	Splits             []RefundSplit `json:"refund_splits,omitempty" binding:"omitempty,dive"`
+	ProportionalSplits bool          `json:"proportional_splits,omitempty"`
 }
 
 // SplitSettlementRequest represents a split settlement request
@@ -115,12 +246,18 @@ type VerifyPaymentRequest struct {
 	OrderID string `json:"order_id" binding:"required"`
 }
 
+// BulkVerifyRequest represents a request to re-verify a batch of orders
+type BulkVerifyRequest struct {
+	OrderIDs []string `json:"order_ids" binding:"required,min=1,max=500"`
+}
+
 // CashfreeOrderResponse represents Cashfree order creation response
 type CashfreeOrderResponse struct {
 	CFOrderID      string `json:"cf_order_id"`
 	OrderID        string `json:"order_id"`
 	PaymentLink    string `json:"payment_link"`
-	OrderStatus    string `json:"order_status"`
+	PaymentSessionID string `json:"payment_session_id,omitempty"`
+	OrderStatus    domain.PaymentStatus `json:"order_status"`
 	OrderExpiryTime string `json:"order_expiry_time"`
 }
 
@@ -133,4 +270,8 @@ type CashfreePaymentResponse struct {
 	PaymentAmount float64   `json:"payment_amount"`
 	PaymentTime   time.Time `json:"payment_time"`
 	PaymentMethod string    `json:"payment_method"`
+
+	PaymentCharges *CashfreePaymentCharges `json:"payment_charges,omitempty"`
+	EMI            *EMIDetails             `json:"-"` // read from payment_method by UnmarshalJSON
+	MethodDetails  *PaymentMethodDetails   `json:"-"` // read from payment_method by UnmarshalJSON
 }
@@ -0,0 +1,19 @@
+package notify
+
+import "context"
+
+// Channels a customer can be notified on, each with its own consent
+const (
+	ChannelEmail    = "email"
+	ChannelSMS      = "sms"
+	ChannelWhatsApp = "whatsapp"
+)
+
+// Channels lists every notification channel
+var Channels = []string{ChannelEmail, ChannelSMS, ChannelWhatsApp}
+
+// Consents tells whether the customer of an order may be sent payment links and
+// reminders on a channel
+type Consents interface {
+	Allowed(ctx context.Context, orderID, channel string) (bool, error)
+}
@@ -0,0 +1,236 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"payment-getway/events"
+)
+
+// Email notification types, each backed by a template of the same name
+const (
+	EmailPaymentLink      = "payment_link"
+	EmailPaymentReceived  = "payment_received"
+	EmailPaymentReminder  = "payment_reminder"
+	EmailRefundInitiated  = "refund_initiated"
+	EmailRefundProcessed  = "refund_processed"
+	EmailSettlementFailed = "settlement_failed"
+)
+
+// AllEmails lists every email notification type
+var AllEmails = []string{
+	EmailPaymentLink,
+	EmailPaymentReceived,
+	EmailPaymentReminder,
+	EmailRefundInitiated,
+	EmailRefundProcessed,
+	EmailSettlementFailed,
+}
+
+//go:embed templates/*.html
+var defaultTemplates embed.FS
+
+// EmailConfig holds the settings for email notifications
+type EmailConfig struct {
+	// MerchantEmail receives merchant-facing notifications such as failed settlements
+	MerchantEmail string
+	// Enabled lists the notification types to send; empty enables all of them
+	Enabled []string
+	// TemplateDir optionally holds <type>.html files overriding the built-in templates
+	TemplateDir string
+}
+
+// EmailNotifier sends templated emails in response to payment lifecycle events
+type EmailNotifier struct {
+	mailer    Mailer
+	cfg       EmailConfig
+	enabled   map[string]bool
+	templates map[string]*template.Template
+
+	// consents is consulted before payment links and reminders; nil sends them to
+	// every customer
+	consents Consents
+}
+
+// NewEmailNotifier loads the templates for every enabled notification type
+func NewEmailNotifier(mailer Mailer, cfg EmailConfig) (*EmailNotifier, error) {
+	enabledTypes := cfg.Enabled
+	if len(enabledTypes) == 0 {
+		enabledTypes = AllEmails
+	}
+
+	n := &EmailNotifier{
+		mailer:    mailer,
+		cfg:       cfg,
+		enabled:   make(map[string]bool),
+		templates: make(map[string]*template.Template),
+	}
+
+	for _, name := range enabledTypes {
+		name = strings.TrimSpace(name)
+		tmpl, err := loadTemplate(name, cfg.TemplateDir)
+		if err != nil {
+			return nil, err
+		}
+		n.enabled[name] = true
+		n.templates[name] = tmpl
+	}
+
+	return n, nil
+}
+
+// loadTemplate parses the override from dir if present, falling back to the embedded default
+func loadTemplate(name, dir string) (*template.Template, error) {
+	if dir != "" {
+		path := filepath.Join(dir, name+".html")
+		if _, err := os.Stat(path); err == nil {
+			tmpl, err := template.ParseFiles(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse email template %s: %v", path, err)
+			}
+			return tmpl, nil
+		}
+	}
+
+	tmpl, err := template.ParseFS(defaultTemplates, "templates/"+name+".html")
+	if err != nil {
+		return nil, fmt.Errorf("unknown email notification %q: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// SetConsents has payment links and reminders sent only to customers consents allows
+// to be emailed
+func (n *EmailNotifier) SetConsents(consents Consents) {
+	n.consents = consents
+}
+
+// Subscribe registers the notifier on the event bus
+func (n *EmailNotifier) Subscribe(bus events.Subscriber) {
+	bus.Subscribe(events.PaymentActivated, n.onPaymentActivated)
+	bus.Subscribe(events.PaymentSucceeded, n.onPaymentSucceeded)
+	bus.Subscribe(events.PaymentReminder, n.onPaymentReminder)
+	bus.Subscribe(events.RefundCreated, n.onRefundCreated)
+	bus.Subscribe(events.RefundUpdated, n.onRefundUpdated)
+	bus.Subscribe(events.SettlementUpdated, n.onSettlementUpdated)
+}
+
+func (n *EmailNotifier) onPaymentActivated(ctx context.Context, event events.Event) error {
+	var payment events.PaymentPayload
+	if err := event.Decode(&payment); err != nil {
+		return err
+	}
+	if ok, err := n.consented(ctx, EmailPaymentLink, payment.OrderID); !ok {
+		return err
+	}
+	return n.send(ctx, EmailPaymentLink, payment.CustomerEmail, payment)
+}
+
+func (n *EmailNotifier) onPaymentSucceeded(ctx context.Context, event events.Event) error {
+	var payment events.PaymentPayload
+	if err := event.Decode(&payment); err != nil {
+		return err
+	}
+	return n.send(ctx, EmailPaymentReceived, payment.CustomerEmail, payment)
+}
+
+func (n *EmailNotifier) onPaymentReminder(ctx context.Context, event events.Event) error {
+	var reminder events.PaymentReminderPayload
+	if err := event.Decode(&reminder); err != nil {
+		return err
+	}
+	if ok, err := n.consented(ctx, EmailPaymentReminder, reminder.OrderID); !ok {
+		return err
+	}
+	return n.send(ctx, EmailPaymentReminder, reminder.CustomerEmail, reminder)
+}
+
+func (n *EmailNotifier) onRefundCreated(ctx context.Context, event events.Event) error {
+	var refund events.RefundPayload
+	if err := event.Decode(&refund); err != nil {
+		return err
+	}
+	return n.send(ctx, EmailRefundInitiated, refund.CustomerEmail, refund)
+}
+
+func (n *EmailNotifier) onRefundUpdated(ctx context.Context, event events.Event) error {
+	var refund events.RefundPayload
+	if err := event.Decode(&refund); err != nil {
+		return err
+	}
+	if refund.Status != "SUCCESS" {
+		return nil
+	}
+	return n.send(ctx, EmailRefundProcessed, refund.CustomerEmail, refund)
+}
+
+func (n *EmailNotifier) onSettlementUpdated(ctx context.Context, event events.Event) error {
+	var settlement events.SettlementPayload
+	if err := event.Decode(&settlement); err != nil {
+		return err
+	}
+	if settlement.Status != "FAILED" {
+		return nil
+	}
+	return n.send(ctx, EmailSettlementFailed, n.cfg.MerchantEmail, settlement)
+}
+
+// consented reports whether the customer of an order may be sent the named email.
+// Without an answer from the consents the email is not sent.
+func (n *EmailNotifier) consented(ctx context.Context, name, orderID string) (bool, error) {
+	if n.consents == nil || !n.enabled[name] {
+		return true, nil
+	}
+
+	allowed, err := n.consents.Allowed(ctx, orderID, ChannelEmail)
+	if err != nil {
+		return false, fmt.Errorf("failed to check email consent for order %s: %v", orderID, err)
+	}
+	if !allowed {
+		log.Printf("Skipping %s email for order %s: customer has not consented to email", name, orderID)
+	}
+	return allowed, nil
+}
+
+// send renders the named template and emails it to the recipient
+func (n *EmailNotifier) send(ctx context.Context, name, to string, data interface{}) error {
+	if !n.enabled[name] {
+		return nil
+	}
+	if to == "" {
+		log.Printf("Skipping %s email: no recipient", name)
+		return nil
+	}
+
+	subject, body, err := n.Render(name, data)
+	if err != nil {
+		return err
+	}
+
+	return n.mailer.Send(ctx, []string{to}, subject, body)
+}
+
+// Render renders the subject and body of the named template
+func (n *EmailNotifier) Render(name string, data interface{}) (string, string, error) {
+	tmpl, ok := n.templates[name]
+	if !ok {
+		return "", "", fmt.Errorf("email template %s is not loaded", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s subject: %v", name, err)
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s body: %v", name, err)
+	}
+
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-getway/events"
+)
+
+type sentEmail struct {
+	to      []string
+	subject string
+	body    string
+}
+
+type fakeMailer struct {
+	sent []sentEmail
+}
+
+func (m *fakeMailer) Send(ctx context.Context, to []string, subject, htmlBody string) error {
+	m.sent = append(m.sent, sentEmail{to: to, subject: subject, body: htmlBody})
+	return nil
+}
+
+func TestEmailNotifierPaymentReceived(t *testing.T) {
+	mailer := &fakeMailer{}
+	notifier, err := NewEmailNotifier(mailer, EmailConfig{})
+	assert.NoError(t, err)
+
+	bus := events.NewMemoryBus(nil)
+	notifier.Subscribe(bus)
+
+	event, _ := events.NewEvent(events.PaymentSucceeded, "order_1", events.PaymentPayload{
+		OrderID:       "order_1",
+		Amount:        250,
+		Currency:      "INR",
+		CustomerName:  "John Doe",
+		CustomerEmail: "john.doe@example.com",
+	})
+	assert.NoError(t, bus.Publish(context.Background(), event))
+	bus.Wait()
+
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, []string{"john.doe@example.com"}, mailer.sent[0].to)
+	assert.Equal(t, "Payment received for order order_1", mailer.sent[0].subject)
+	assert.Contains(t, mailer.sent[0].body, "INR 250.00")
+}
+
+func TestEmailNotifierPaymentReminder(t *testing.T) {
+	mailer := &fakeMailer{}
+	notifier, err := NewEmailNotifier(mailer, EmailConfig{})
+	assert.NoError(t, err)
+
+	event, _ := events.NewEvent(events.PaymentReminder, "order_1", events.PaymentReminderPayload{
+		PaymentPayload: events.PaymentPayload{
+			OrderID:       "order_1",
+			Amount:        499.5,
+			Currency:      "INR",
+			CustomerName:  "John Doe",
+			CustomerEmail: "john.doe@example.com",
+			PaymentURL:    "https://payments.example.com/pay/session_1",
+		},
+		Attempt: 1,
+	})
+	assert.NoError(t, notifier.onPaymentReminder(context.Background(), event))
+
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, []string{"john.doe@example.com"}, mailer.sent[0].to)
+	assert.Equal(t, "Complete your payment for order order_1", mailer.sent[0].subject)
+	assert.Contains(t, mailer.sent[0].body, "INR 499.50")
+	assert.Contains(t, mailer.sent[0].body, `href="https://payments.example.com/pay/session_1"`)
+}
+
+func TestEmailNotifierPaymentLink(t *testing.T) {
+	mailer := &fakeMailer{}
+	notifier, err := NewEmailNotifier(mailer, EmailConfig{})
+	assert.NoError(t, err)
+
+	event, _ := events.NewEvent(events.PaymentActivated, "order_1", events.PaymentPayload{
+		OrderID:       "order_1",
+		Amount:        1200,
+		Currency:      "INR",
+		CustomerName:  "John Doe",
+		CustomerEmail: "john.doe@example.com",
+		PaymentURL:    "https://payments.example.com/pay/session_1",
+	})
+	assert.NoError(t, notifier.onPaymentActivated(context.Background(), event))
+
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "Payment request for order order_1", mailer.sent[0].subject)
+	assert.Contains(t, mailer.sent[0].body, "INR 1200.00")
+	assert.Contains(t, mailer.sent[0].body, `href="https://payments.example.com/pay/session_1"`)
+}
+
+type fakeConsents map[string]bool
+
+func (c fakeConsents) Allowed(ctx context.Context, orderID, channel string) (bool, error) {
+	return c[orderID+"/"+channel], nil
+}
+
+func TestEmailNotifierConsents(t *testing.T) {
+	mailer := &fakeMailer{}
+	notifier, err := NewEmailNotifier(mailer, EmailConfig{})
+	assert.NoError(t, err)
+	notifier.SetConsents(fakeConsents{"order_1/" + ChannelEmail: true, "order_2/" + ChannelSMS: true})
+
+	for _, orderID := range []string{"order_1", "order_2"} {
+		payment := events.PaymentPayload{OrderID: orderID, CustomerEmail: "john.doe@example.com", PaymentURL: "https://payments.example.com/pay/session_1"}
+		link, _ := events.NewEvent(events.PaymentActivated, orderID, payment)
+		assert.NoError(t, notifier.onPaymentActivated(context.Background(), link))
+		reminder, _ := events.NewEvent(events.PaymentReminder, orderID, events.PaymentReminderPayload{PaymentPayload: payment, Attempt: 1})
+		assert.NoError(t, notifier.onPaymentReminder(context.Background(), reminder))
+		received, _ := events.NewEvent(events.PaymentSucceeded, orderID, payment)
+		assert.NoError(t, notifier.onPaymentSucceeded(context.Background(), received))
+	}
+
+	// Receipts are sent whatever the customer's consent
+	var subjects []string
+	for _, sent := range mailer.sent {
+		subjects = append(subjects, sent.subject)
+	}
+	assert.Equal(t, []string{
+		"Payment request for order order_1",
+		"Complete your payment for order order_1",
+		"Payment received for order order_1",
+		"Payment received for order order_2",
+	}, subjects)
+}
+
+func TestEmailNotifierToggles(t *testing.T) {
+	mailer := &fakeMailer{}
+	notifier, err := NewEmailNotifier(mailer, EmailConfig{Enabled: []string{EmailRefundProcessed}})
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	refund := events.RefundPayload{OrderID: "order_1", RefundID: "refund_1", CustomerEmail: "john.doe@example.com", Status: "PENDING"}
+
+	created, _ := events.NewEvent(events.RefundCreated, "order_1", refund)
+	assert.NoError(t, notifier.onRefundCreated(ctx, created))
+
+	pending, _ := events.NewEvent(events.RefundUpdated, "order_1", refund)
+	assert.NoError(t, notifier.onRefundUpdated(ctx, pending))
+
+	refund.Status = "SUCCESS"
+	processed, _ := events.NewEvent(events.RefundUpdated, "order_1", refund)
+	assert.NoError(t, notifier.onRefundUpdated(ctx, processed))
+
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "Refund processed for order order_1", mailer.sent[0].subject)
+}
+
+func TestEmailTemplateOverride(t *testing.T) {
+	dir := t.TempDir()
+	override := `{{define "subject"}}Custom {{.OrderID}}{{end}}{{define "body"}}Custom body{{end}}`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, EmailPaymentReceived+".html"), []byte(override), 0o644))
+
+	notifier, err := NewEmailNotifier(&fakeMailer{}, EmailConfig{TemplateDir: dir})
+	assert.NoError(t, err)
+
+	subject, body, err := notifier.Render(EmailPaymentReceived, events.PaymentPayload{OrderID: "order_9"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Custom order_9", subject)
+	assert.Equal(t, "Custom body", body)
+}
+
+func TestEmailNotifierRejectsUnknownType(t *testing.T) {
+	_, err := NewEmailNotifier(&fakeMailer{}, EmailConfig{Enabled: []string{"nope"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(ctx context.Context, to []string, subject, htmlBody string) error
+}
+
+// SMTPConfig holds the settings for an SMTP mailer
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends email through an SMTP server. Amazon SES is supported
+// through its SMTP interface (see NewSESMailer).
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPMailer creates an SMTP mailer
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+	return &SMTPMailer{cfg: cfg}
+}
+
+// NewSESMailer creates a mailer for the Amazon SES SMTP endpoint of the given region
+func NewSESMailer(region string, cfg SMTPConfig) *SMTPMailer {
+	cfg.Host = fmt.Sprintf("email-smtp.%s.amazonaws.com", region)
+	return NewSMTPMailer(cfg)
+}
+
+// Send sends an HTML email
+func (m *SMTPMailer) Send(ctx context.Context, to []string, subject, htmlBody string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + m.cfg.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	msg.WriteString("Subject: " + subject + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=\"UTF-8\"\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(htmlBody)
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	addr := m.cfg.Host + ":" + m.cfg.Port
+	if err := smtp.SendMail(addr, auth, m.cfg.From, to, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send email via %s: %v", addr, err)
+	}
+
+	return nil
+}
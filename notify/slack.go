@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"payment-getway/events"
+)
+
+// SlackConfig holds the settings for Slack notifications
+type SlackConfig struct {
+	WebhookURL string
+	// RefundThreshold is the minimum refund amount that is posted to Slack
+	RefundThreshold float64
+	// Enabled lists the event types to post; empty enables all supported events
+	Enabled []string
+}
+
+// SlackNotifier posts operational alerts to a Slack incoming webhook
+type SlackNotifier struct {
+	cfg     SlackConfig
+	enabled map[string]bool
+	client  *resty.Client
+}
+
+// NewSlackNotifier creates a Slack notifier
+func NewSlackNotifier(cfg SlackConfig) *SlackNotifier {
+	client := resty.New()
+	client.SetTimeout(10 * time.Second)
+
+	enabled := make(map[string]bool)
+	for _, eventType := range cfg.Enabled {
+		enabled[strings.TrimSpace(eventType)] = true
+	}
+
+	return &SlackNotifier{
+		cfg:     cfg,
+		enabled: enabled,
+		client:  client,
+	}
+}
+
+// Subscribe registers the notifier on the event bus
+func (n *SlackNotifier) Subscribe(bus events.Subscriber) {
+	bus.Subscribe(events.RefundCreated, n.onRefundCreated)
+	bus.Subscribe(events.DisputeOpened, n.onDisputeOpened)
+	bus.Subscribe(events.SettlementUpdated, n.onSettlementUpdated)
+	bus.Subscribe(events.ReconMismatch, n.onReconMismatch)
+	bus.Subscribe(events.AmountMismatch, n.onAmountMismatch)
+}
+
+func (n *SlackNotifier) onRefundCreated(ctx context.Context, event events.Event) error {
+	var refund events.RefundPayload
+	if err := event.Decode(&refund); err != nil {
+		return err
+	}
+	if refund.Amount < n.cfg.RefundThreshold {
+		return nil
+	}
+
+	return n.post(ctx, event.Type, fmt.Sprintf(
+		":money_with_wings: Refund of %s %.2f created for order `%s` (refund `%s`)",
+		refund.Currency, refund.Amount, refund.OrderID, refund.RefundID,
+	))
+}
+
+func (n *SlackNotifier) onDisputeOpened(ctx context.Context, event events.Event) error {
+	var dispute events.DisputePayload
+	if err := event.Decode(&dispute); err != nil {
+		return err
+	}
+
+	return n.post(ctx, event.Type, fmt.Sprintf(
+		":warning: Dispute `%s` opened on order `%s` for %.2f (%s, reason %s)",
+		dispute.DisputeID, dispute.OrderID, dispute.Amount, dispute.DisputeType, dispute.ReasonCode,
+	))
+}
+
+func (n *SlackNotifier) onSettlementUpdated(ctx context.Context, event events.Event) error {
+	var settlement events.SettlementPayload
+	if err := event.Decode(&settlement); err != nil {
+		return err
+	}
+	if settlement.Status != "FAILED" {
+		return nil
+	}
+
+	return n.post(ctx, event.Type, fmt.Sprintf(
+		":x: Settlement `%s` for order `%s` failed (amount %.2f)",
+		settlement.SettlementID, settlement.OrderID, settlement.Amount,
+	))
+}
+
+func (n *SlackNotifier) onReconMismatch(ctx context.Context, event events.Event) error {
+	var mismatch events.ReconMismatchPayload
+	if err := event.Decode(&mismatch); err != nil {
+		return err
+	}
+
+	return n.post(ctx, event.Type, fmt.Sprintf(
+		":mag: Reconciliation mismatch (%s) on order `%s` in run `%s`: local %s/%.2f, Cashfree %s/%.2f",
+		mismatch.Kind, mismatch.OrderID, mismatch.RunID,
+		mismatch.LocalStatus, mismatch.LocalAmount, mismatch.RemoteStatus, mismatch.RemoteAmount,
+	))
+}
+
+func (n *SlackNotifier) onAmountMismatch(ctx context.Context, event events.Event) error {
+	var mismatch events.AmountMismatchPayload
+	if err := event.Decode(&mismatch); err != nil {
+		return err
+	}
+
+	return n.post(ctx, event.Type, fmt.Sprintf(
+		":rotating_light: Order `%s` was paid %s %.2f but quoted %.2f; held as AMOUNT_MISMATCH (payment `%s`)",
+		mismatch.OrderID, mismatch.Currency, mismatch.PaidAmount, mismatch.QuotedAmount, mismatch.CFPaymentID,
+	))
+}
+
+// post sends a message to the Slack webhook if the event type is enabled
+func (n *SlackNotifier) post(ctx context.Context, eventType, text string) error {
+	if len(n.enabled) > 0 && !n.enabled[eventType] {
+		return nil
+	}
+
+	resp, err := n.client.R().
+		SetContext(ctx).
+		SetBody(map[string]string{"text": text}).
+		Post(n.cfg.WebhookURL)
+
+	if err != nil {
+		return fmt.Errorf("failed to post to Slack: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("slack webhook returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"payment-getway/events"
+)
+
+func TestSlackNotifierRefundThreshold(t *testing.T) {
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		messages = append(messages, body["text"])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(SlackConfig{WebhookURL: server.URL, RefundThreshold: 1000})
+	ctx := context.Background()
+
+	small, _ := events.NewEvent(events.RefundCreated, "order_1", events.RefundPayload{OrderID: "order_1", Amount: 500, Currency: "INR"})
+	assert.NoError(t, notifier.onRefundCreated(ctx, small))
+
+	large, _ := events.NewEvent(events.RefundCreated, "order_2", events.RefundPayload{OrderID: "order_2", Amount: 5000, Currency: "INR"})
+	assert.NoError(t, notifier.onRefundCreated(ctx, large))
+
+	assert.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "INR 5000.00")
+	assert.Contains(t, messages[0], "order_2")
+}
+
+func TestSlackNotifierEventToggles(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(SlackConfig{WebhookURL: server.URL, Enabled: []string{events.DisputeOpened}})
+	ctx := context.Background()
+
+	failed, _ := events.NewEvent(events.SettlementUpdated, "order_1", events.SettlementPayload{OrderID: "order_1", Status: "FAILED"})
+	assert.NoError(t, notifier.onSettlementUpdated(ctx, failed))
+
+	dispute, _ := events.NewEvent(events.DisputeOpened, "order_1", events.DisputePayload{DisputeID: "d_1", OrderID: "order_1"})
+	assert.NoError(t, notifier.onDisputeOpened(ctx, dispute))
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestSlackNotifierAmountMismatch(t *testing.T) {
+	var messages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		messages = append(messages, body["text"])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	notifier := NewSlackNotifier(SlackConfig{WebhookURL: server.URL})
+	mismatch, _ := events.NewEvent(events.AmountMismatch, "order_1", events.AmountMismatchPayload{
+		OrderID: "order_1", CFPaymentID: "cf_payment_1", Currency: "INR", QuotedAmount: 499.5, PaidAmount: 1,
+	})
+	assert.NoError(t, notifier.onAmountMismatch(context.Background(), mismatch))
+
+	assert.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "paid INR 1.00 but quoted 499.50")
+	assert.Contains(t, messages[0], "order_1")
+}
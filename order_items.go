@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxOrderItems bounds the line items of one order
+const maxOrderItems = 100
+
+// OrderItem is a line item of an order's cart, shown on the hosted checkout and on
+// receipts
+type OrderItem struct {
+	ItemID      string  `json:"item_id,omitempty" binding:"omitempty,max=100"` // the merchant's SKU or product ID
+	Name        string  `json:"name" binding:"required,max=255"`
+	Description string  `json:"description,omitempty" binding:"omitempty,max=500"`
+	Quantity    int     `json:"quantity" binding:"required,gt=0"`
+	UnitPrice   float64 `json:"unit_price" binding:"gte=0"`
+	ImageURL    string  `json:"image_url,omitempty" binding:"omitempty,url"`
+	DetailsURL  string  `json:"details_url,omitempty" binding:"omitempty,url"` // the product page
+}
+
+// CartDetails is the cart Cashfree shows on its hosted checkout
+type CartDetails struct {
+	CartItems []CartItem `json:"cart_items"`
+}
+
+// CartItem is a line item of a Cashfree cart
+type CartItem struct {
+	ItemID                  string  `json:"item_id,omitempty"`
+	ItemName                string  `json:"item_name"`
+	ItemDescription         string  `json:"item_description,omitempty"`
+	ItemDetailsURL          string  `json:"item_details_url,omitempty"`
+	ItemImageURL            string  `json:"item_image_url,omitempty"`
+	ItemOriginalUnitPrice   float64 `json:"item_original_unit_price"`
+	ItemDiscountedUnitPrice float64 `json:"item_discounted_unit_price"`
+	ItemQuantity            int     `json:"item_quantity"`
+	ItemCurrency            string  `json:"item_currency"`
+}
+
+// cartDetails converts an order's items to Cashfree's cart, or nil when it has none
+func cartDetails(items []OrderItem, currency string) *CartDetails {
+	if len(items) == 0 {
+		return nil
+	}
+
+	cart := &CartDetails{CartItems: make([]CartItem, len(items))}
+	for i, item := range items {
+		cart.CartItems[i] = CartItem{
+			ItemID:                  item.ItemID,
+			ItemName:                item.Name,
+			ItemDescription:         item.Description,
+			ItemDetailsURL:          item.DetailsURL,
+			ItemImageURL:            item.ImageURL,
+			ItemOriginalUnitPrice:   item.UnitPrice,
+			ItemDiscountedUnitPrice: item.UnitPrice,
+			ItemQuantity:            item.Quantity,
+			ItemCurrency:            currency,
+		}
+	}
+	return cart
+}
+
+// validateOrderItems checks that the items' prices fit the order's currency and add up
+// to no more than the order amount, which may include shipping and taxes on top
+func validateOrderItems(items []OrderItem, currency string, amount float64) error {
+	if len(items) > maxOrderItems {
+		return fmt.Errorf("an order may have at most %d items", maxOrderItems)
+	}
+
+	var total int64
+	for i, item := range items {
+		if _, _, err := validateCurrency(currency, item.UnitPrice); err != nil {
+			return fmt.Errorf("items[%d].unit_price: %v", i, err)
+		}
+		total += toMinorUnits(item.UnitPrice, currency) * int64(item.Quantity)
+	}
+	if total > toMinorUnits(amount, currency) {
+		return fmt.Errorf("items total %s exceeds the order amount", formatCurrencyAmount(fromMinorUnits(total, currency), currency))
+	}
+	return nil
+}
+
+// insertOrderItems records a payment's items in tx, in their order
+func (r *PaymentRepository) insertOrderItems(ctx context.Context, tx pgx.Tx, payment *Payment) error {
+	query := `
+		INSERT INTO order_items (
+			id, order_id, tenant_id, position, item_id, name, description,
+			quantity, unit_price, image_url, details_url, created_at
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NULLIF($7, ''), $8, $9, NULLIF($10, ''), NULLIF($11, ''), $12)
+	`
+
+	for i, item := range payment.Items {
+		_, err := tx.Exec(ctx, query,
+			uuid.New(), payment.OrderID, payment.TenantID, i, item.ItemID, item.Name,
+			item.Description, item.Quantity, item.UnitPrice, item.ImageURL, item.DetailsURL,
+			payment.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListOrderItems returns the items of an order's cart, in their order
+func (r *PaymentRepository) ListOrderItems(ctx context.Context, orderID string) ([]OrderItem, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT COALESCE(item_id, ''), name, COALESCE(description, ''), quantity,
+			   unit_price, COALESCE(image_url, ''), COALESCE(details_url, '')
+		FROM order_items
+		WHERE order_id = $1` + tenant + `
+		ORDER BY position
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []OrderItem
+	for rows.Next() {
+		var item OrderItem
+		err := rows.Scan(
+			&item.ItemID, &item.Name, &item.Description, &item.Quantity,
+			&item.UnitPrice, &item.ImageURL, &item.DetailsURL,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+)
+
+func testOrderItems() []OrderItem {
+	return []OrderItem{
+		{ItemID: "sku_mug", Name: "Coffee Mug", Quantity: 2, UnitPrice: 199.5, ImageURL: "https://shop.example.com/mug.png"},
+		{Name: "Gift Wrap", Quantity: 1, UnitPrice: 50},
+	}
+}
+
+func TestValidateOrderItems(t *testing.T) {
+	assert.NoError(t, validateOrderItems(nil, "INR", 100))
+	assert.NoError(t, validateOrderItems(testOrderItems(), "INR", 449))
+	// Shipping and taxes may come on top of the items
+	assert.NoError(t, validateOrderItems(testOrderItems(), "INR", 499))
+
+	assert.EqualError(t, validateOrderItems(testOrderItems(), "INR", 400), "items total 449.00 exceeds the order amount")
+	assert.EqualError(t, validateOrderItems(testOrderItems(), "JPY", 1000), "items[0].unit_price: JPY amounts must have at most 0 decimal places")
+	assert.EqualError(t, validateOrderItems(make([]OrderItem, 101), "INR", 100), "an order may have at most 100 items")
+}
+
+func TestCreateOrderSendsCartDetails(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	req := testOrderRequest("order_1")
+	req.CartDetails = cartDetails(testOrderItems(), "INR")
+	_, err := client.CreateOrder(req)
+	require.NoError(t, err)
+
+	order, ok := server.Order("order_1")
+	require.True(t, ok)
+	assert.Equal(t, []cashfreetest.CartItem{
+		{ItemID: "sku_mug", Name: "Coffee Mug", ImageURL: "https://shop.example.com/mug.png", UnitPrice: 199.5, Quantity: 2},
+		{Name: "Gift Wrap", UnitPrice: 50, Quantity: 1},
+	}, order.CartItems)
+
+	assert.Nil(t, cartDetails(nil, "INR"))
+}
+
+func TestCreatePaymentSessionWithItems(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		orderURLs:    OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	session := func(orderID string, amount float64) string {
+		return fmt.Sprintf(`{
+			"order_id": %q, "amount": %v, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9999999999", "notify_url": "https://shop.example.com/notify",
+			"items": [
+				{"item_id": "sku_mug", "name": "Coffee Mug", "quantity": 2, "unit_price": 199.5},
+				{"name": "Gift Wrap", "quantity": 1, "unit_price": 50}
+			]
+		}`, orderID, amount)
+	}
+
+	orderID := fmt.Sprintf("order_items_%d", time.Now().UnixNano())
+	w := post(session(orderID, 499))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	order, ok := server.Order(orderID)
+	require.True(t, ok)
+	require.Len(t, order.CartItems, 2)
+	assert.Equal(t, "Coffee Mug", order.CartItems[0].Name)
+
+	items, err := handler.repo.ListOrderItems(context.Background(), orderID)
+	require.NoError(t, err)
+	assert.Equal(t, []OrderItem{
+		{ItemID: "sku_mug", Name: "Coffee Mug", Quantity: 2, UnitPrice: 199.5},
+		{Name: "Gift Wrap", Quantity: 1, UnitPrice: 50},
+	}, items)
+
+	// Items worth more than the order are refused before the gateway is called
+	w = post(session(orderID+"_short", 100))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, ok = server.Order(orderID + "_short")
+	assert.False(t, ok)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Limits on the tags of a payment, which are also sent to the gateway as order tags
+const (
+	maxOrderTags           = 10
+	maxOrderTagKeyLength   = 64
+	maxOrderTagValueLength = 255
+)
+
+// PaymentMetaRequest changes a payment's tags and internal notes. Fields left out are
+// unchanged. Tags replace the payment's tags as a whole, so an empty object removes
+// them, and an empty note removes the note.
+type PaymentMetaRequest struct {
+	Tags  map[string]string `json:"tags"`
+	Notes *string           `json:"notes" binding:"omitempty,max=2000"`
+}
+
+// validateOrderTags checks tags against the order tag limits
+func validateOrderTags(tags map[string]string) error {
+	if len(tags) > maxOrderTags {
+		return fmt.Errorf("at most %d tags are allowed", maxOrderTags)
+	}
+	for key, value := range tags {
+		if strings.TrimSpace(key) == "" || len(key) > maxOrderTagKeyLength {
+			return fmt.Errorf("tag keys must be 1 to %d characters, got %q", maxOrderTagKeyLength, key)
+		}
+		if len(value) > maxOrderTagValueLength {
+			return fmt.Errorf("tag %q is longer than %d characters", key, maxOrderTagValueLength)
+		}
+	}
+	return nil
+}
+
+// gatewayOrderTags returns the order tags sent to the gateway: the payment's tags and
+// the checkout theme's, which take precedence
+func gatewayOrderTags(tags, theme map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return theme
+	}
+	merged := make(map[string]string, len(tags)+len(theme))
+	for key, value := range tags {
+		merged[key] = value
+	}
+	for key, value := range theme {
+		merged[key] = value
+	}
+	return merged
+}
+
+// parseTagFilter reads tag filters written as key:value
+func parseTagFilter(filters []string) (map[string]string, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	tags := make(map[string]string, len(filters))
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("tag filters must be key:value, got %q", filter)
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// UpdatePaymentMeta sets a payment's tags when tags is not nil and its notes when notes
+// is not nil, and returns the updated payment
+func (r *PaymentRepository) UpdatePaymentMeta(ctx context.Context, orderID string, tags map[string]string, notes *string) (*Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 7)
+	query := `
+		UPDATE payments
+		SET tags = CASE WHEN $2 THEN $3::jsonb ELSE tags END,
+			notes = CASE WHEN $4 THEN NULLIF($5, '') ELSE notes END,
+			updated_at = $6
+		WHERE order_id = $1` + tenant + `
+		RETURNING ` + paymentColumns
+
+	var note string
+	if notes != nil {
+		note = *notes
+	}
+	args := append([]interface{}{orderID, tags != nil, tags, notes != nil, note, r.now()}, tenantArgs...)
+	payment, err := scanPayment(r.db.QueryRow(ctx, query, args...))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("payment not found for order_id: %s", orderID)
+	}
+	return payment, err
+}
+
+// Updates a payment's tags and internal notes. New tags are sent to the order's
+// gateway when it can change an order's tags after creation.
+func (h *PaymentHandler) UpdatePaymentMeta(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	var req PaymentMetaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Tags == nil && req.Notes == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tags or notes is required"})
+		return
+	}
+	if err := validateOrderTags(req.Tags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get payment from database: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	if req.Tags != nil {
+		gateway, err := h.gatewayFor(ctx, payment)
+		if err != nil {
+			log.Printf("Failed to resolve payment gateway: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+			return
+		}
+		if updater, ok := gateway.(OrderTagUpdater); ok {
+			tags := gatewayOrderTags(req.Tags, h.checkoutThemeFor(ctx).orderTags())
+			if err := updater.UpdateOrderTags(orderID, tags); err != nil {
+				log.Printf("Failed to update %s order tags: %v", gateway.Name(), err)
+				respondGatewayError(c, err, "Failed to update order tags")
+				return
+			}
+		}
+	}
+
+	payment, err = h.repo.UpdatePaymentMeta(ctx, orderID, req.Tags, req.Notes)
+	if err != nil {
+		log.Printf("Failed to update payment meta: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, payment)
+}
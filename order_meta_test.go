@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOrderTags(t *testing.T) {
+	assert.NoError(t, validateOrderTags(nil))
+	assert.NoError(t, validateOrderTags(map[string]string{"channel": "web", "campaign": ""}))
+
+	tooMany := make(map[string]string)
+	for _, key := range strings.Split("a b c d e f g h i j k", " ") {
+		tooMany[key] = "x"
+	}
+	assert.EqualError(t, validateOrderTags(tooMany), "at most 10 tags are allowed")
+	assert.Error(t, validateOrderTags(map[string]string{" ": "x"}))
+	assert.Error(t, validateOrderTags(map[string]string{strings.Repeat("k", 65): "x"}))
+	assert.Error(t, validateOrderTags(map[string]string{"channel": strings.Repeat("v", 256)}))
+}
+
+func TestGatewayOrderTags(t *testing.T) {
+	theme := map[string]string{"theme_color": "#112233"}
+	assert.Equal(t, theme, gatewayOrderTags(nil, theme))
+	assert.Nil(t, gatewayOrderTags(nil, nil))
+
+	// The theme's tags win over the payment's
+	assert.Equal(t, map[string]string{"channel": "web", "theme_color": "#112233"},
+		gatewayOrderTags(map[string]string{"channel": "web", "theme_color": "red"}, theme))
+}
+
+func TestParseTagFilter(t *testing.T) {
+	tags, err := parseTagFilter([]string{"channel:web", "campaign:diwali:2024", "empty:"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"channel": "web", "campaign": "diwali:2024", "empty": ""}, tags)
+
+	tags, err = parseTagFilter(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tags)
+
+	_, err = parseTagFilter([]string{"channel"})
+	assert.Error(t, err)
+	_, err = parseTagFilter([]string{":web"})
+	assert.Error(t, err)
+}
+
+func TestPaymentMetaAPI(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	listed := func(query string) []string {
+		w := serve(http.MethodGet, "/payments?"+query, "")
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Payments []Payment `json:"payments"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var orderIDs []string
+		for _, payment := range resp.Payments {
+			orderIDs = append(orderIDs, payment.OrderID)
+		}
+		return orderIDs
+	}
+
+	for _, orderID := range []string{"order_1", "order_2"} {
+		w := serve(http.MethodPost, "/payments/create-session", `{
+			"order_id": "`+orderID+`", "amount": 250, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9999999999", "tags": {"channel": "web"}, "notes": "VIP customer"
+		}`)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// Tags go to Cashfree with the order; notes stay local
+	order, ok := gateway.Order("order_1")
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"channel": "web"}, order.Tags)
+	assert.Empty(t, order.Note)
+
+	w := serve(http.MethodPatch, "/payments/order_2/meta", `{"tags": {"channel": "app", "campaign": "diwali"}}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated Payment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, map[string]string{"channel": "app", "campaign": "diwali"}, updated.Tags)
+	require.NotNil(t, updated.Notes)
+	assert.Equal(t, "VIP customer", *updated.Notes)
+
+	// Cashfree cannot change an order's tags after creation
+	order, _ = gateway.Order("order_2")
+	assert.Equal(t, map[string]string{"channel": "web"}, order.Tags)
+
+	w = serve(http.MethodPatch, "/payments/order_1/meta", `{"notes": ""}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	stored, err := handler.repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Nil(t, stored.Notes)
+	assert.Equal(t, map[string]string{"channel": "web"}, stored.Tags)
+
+	assert.ElementsMatch(t, []string{"order_1", "order_2"}, listed(""))
+	assert.Equal(t, []string{"order_1"}, listed("tag=channel:web"))
+	assert.Equal(t, []string{"order_2"}, listed("tag=channel:app&tag=campaign:diwali"))
+	assert.Empty(t, listed("tag=channel:app&tag=campaign:holi"))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/payments?tag=channel", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPatch, "/payments/order_1/meta", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPatch, "/payments/order_1/meta", `{"tags": {"": "x"}}`).Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPatch, "/payments/order_9/meta", `{"notes": "x"}`).Code)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"payment-getway/domain"
+)
+
+// validateOrderSplits checks the vendor splits requested with a new order: each gives a
+// vendor once either an amount or a percentage of the order, and together they split no
+// more than the order amount
+func validateOrderSplits(splits []SplitConfig, amount float64) error {
+	vendors := make(map[string]bool, len(splits))
+	total := 0.0
+	for _, split := range splits {
+		if vendors[split.VendorID] {
+			return fmt.Errorf("order_splits lists vendor %s more than once", split.VendorID)
+		}
+		vendors[split.VendorID] = true
+
+		switch {
+		case (split.Amount == nil) == (split.Percentage == nil):
+			return fmt.Errorf("order split for vendor %s must set either amount or percentage", split.VendorID)
+		case split.Amount != nil && *split.Amount <= 0:
+			return fmt.Errorf("order split for vendor %s must have a positive amount", split.VendorID)
+		case split.Percentage != nil && (*split.Percentage <= 0 || *split.Percentage > 100):
+			return fmt.Errorf("order split for vendor %s must have a percentage above 0 and at most 100", split.VendorID)
+		}
+
+		if split.Amount != nil {
+			total += *split.Amount
+		} else {
+			total += amount * *split.Percentage / 100
+		}
+	}
+
+	if total > amount+1e-9 {
+		return fmt.Errorf("order splits total %.2f, more than the order amount of %.2f", total, amount)
+	}
+	return nil
+}
+
+// buildSplits converts the requested vendor splits of a payment into the splits sent to
+// Cashfree and those recorded. Platform fees come out of each vendor's share, so Cashfree
+// is sent what is left; a share that does not cover its fees returns a *SplitFeeError.
+func (h *PaymentHandler) buildSplits(payment *Payment, configs []SplitConfig) ([]CashfreeSettlementSplit, []SplitSettlement, error) {
+	var cashfreeSplits []CashfreeSettlementSplit
+	var dbSplits []SplitSettlement
+
+	for _, split := range configs {
+		cashfreeSplit := CashfreeSettlementSplit{
+			VendorID: split.VendorID,
+		}
+
+		dbSplit := SplitSettlement{
+			OrderID:   payment.OrderID,
+			CFOrderID: payment.CFOrderID,
+			VendorID:  split.VendorID,
+			Status:    domain.SettlementPending,
+		}
+
+		if split.Amount != nil {
+			cashfreeSplit.Amount = split.Amount
+			dbSplit.Amount = *split.Amount
+			dbSplit.SplitType = "AMOUNT"
+		} else if split.Percentage != nil {
+			cashfreeSplit.Percentage = split.Percentage
+			dbSplit.Percentage = split.Percentage
+			dbSplit.Amount = (payment.Amount * *split.Percentage) / 100
+			dbSplit.SplitType = "PERCENTAGE"
+		}
+
+		if h.fees.Enabled() {
+			splitFees, net, err := h.fees.Fees(payment, split.VendorID, dbSplit.Amount)
+			if err != nil {
+				return nil, nil, err
+			}
+			dbSplit.Amount = net
+			dbSplit.Fees = splitFees
+			cashfreeSplit.Amount = &net
+			cashfreeSplit.Percentage = nil
+		}
+
+		cashfreeSplits = append(cashfreeSplits, cashfreeSplit)
+		dbSplits = append(dbSplits, dbSplit)
+	}
+
+	return cashfreeSplits, dbSplits, nil
+}
+
+// recordOrderSplits records the vendor splits requested with a stored payment and returns
+// them. Failures are logged rather than returned, as the payment is already stored.
+func (h *PaymentHandler) recordOrderSplits(ctx context.Context, payment *Payment, configs []SplitConfig) []SplitSettlement {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	_, splits, err := h.buildSplits(payment, configs)
+	if err == nil {
+		err = h.repo.CreateSplitSettlement(ctx, splits)
+	}
+	if err != nil {
+		log.Printf("Failed to record the order splits of %s: %v", payment.OrderID, err)
+		return nil
+	}
+	return splits
+}
+
+// recordedOrderSplits returns the splits recorded for a payment whose gateway order is
+// created later, as they are sent to Cashfree. Splits with platform fees are sent as
+// the amount left after them, like when they were built.
+func (h *PaymentHandler) recordedOrderSplits(ctx context.Context, orderID string) ([]CashfreeSettlementSplit, error) {
+	splits, err := h.repo.ListSplitSettlements(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orderSplits []CashfreeSettlementSplit
+	for _, split := range splits {
+		orderSplit := CashfreeSettlementSplit{VendorID: split.VendorID}
+		if split.SplitType == "PERCENTAGE" && !h.fees.Enabled() {
+			orderSplit.Percentage = split.Percentage
+		} else {
+			amount := split.Amount
+			orderSplit.Amount = &amount
+		}
+		orderSplits = append(orderSplits, orderSplit)
+	}
+	return orderSplits, nil
+}
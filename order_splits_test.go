@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+)
+
+func TestValidateOrderSplits(t *testing.T) {
+	amount := func(v float64) *float64 { return &v }
+
+	assert.NoError(t, validateOrderSplits([]SplitConfig{
+		{VendorID: "vendor_1", Amount: amount(60)},
+		{VendorID: "vendor_2", Percentage: amount(40)},
+	}, 100))
+
+	assert.EqualError(t, validateOrderSplits([]SplitConfig{
+		{VendorID: "vendor_1", Amount: amount(60)},
+		{VendorID: "vendor_2", Percentage: amount(50)},
+	}, 100), "order splits total 110.00, more than the order amount of 100.00")
+	assert.EqualError(t, validateOrderSplits([]SplitConfig{
+		{VendorID: "vendor_1", Amount: amount(10), Percentage: amount(10)},
+	}, 100), "order split for vendor vendor_1 must set either amount or percentage")
+	assert.EqualError(t, validateOrderSplits([]SplitConfig{
+		{VendorID: "vendor_1", Amount: amount(10)},
+		{VendorID: "vendor_1", Amount: amount(10)},
+	}, 100), "order_splits lists vendor vendor_1 more than once")
+	assert.Error(t, validateOrderSplits([]SplitConfig{{VendorID: "vendor_1", Percentage: amount(120)}}, 100))
+	assert.Error(t, validateOrderSplits([]SplitConfig{{VendorID: "vendor_1", Amount: amount(0)}}, 100))
+}
+
+func TestCreateOrderSendsOrderSplits(t *testing.T) {
+	server, client := newFakeCashfree(t)
+	amount, percentage := 60.0, 40.0
+
+	req := testOrderRequest("order_1")
+	req.OrderSplits = []CashfreeSettlementSplit{
+		{VendorID: "vendor_1", Amount: &amount},
+		{VendorID: "vendor_2", Percentage: &percentage},
+	}
+	_, err := client.CreateOrder(req)
+	require.NoError(t, err)
+
+	order, ok := server.Order("order_1")
+	require.True(t, ok)
+	assert.Equal(t, []cashfreetest.Split{
+		{VendorID: "vendor_1", Amount: &amount},
+		{VendorID: "vendor_2", Percentage: &percentage},
+	}, order.Splits)
+}
+
+func TestCreatePaymentSessionWithOrderSplits(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		orderURLs:    OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+		fees:         FeeModel{FixedFee: 2},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	session := func(orderID, splits string) string {
+		return fmt.Sprintf(`{
+			"order_id": %q, "amount": 100, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9999999999", "notify_url": "https://shop.example.com/notify",
+			"order_splits": %s
+		}`, orderID, splits)
+	}
+
+	orderID := fmt.Sprintf("order_splits_%d", time.Now().UnixNano())
+	w := post(session(orderID, `[{"vendor_id": "vendor_1", "amount": 60}, {"vendor_id": "vendor_2", "percentage": 40}]`))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Cashfree is sent each share less its platform fees
+	order, ok := server.Order(orderID)
+	require.True(t, ok)
+	require.Len(t, order.Splits, 2)
+	assert.Equal(t, 58.0, *order.Splits[0].Amount)
+	assert.Equal(t, 38.0, *order.Splits[1].Amount)
+	assert.Nil(t, order.Splits[1].Percentage)
+
+	splits, err := handler.repo.ListSplitSettlements(context.Background(), orderID)
+	require.NoError(t, err)
+	require.Len(t, splits, 2)
+	assert.Equal(t, order.CFOrderID, splits[0].CFOrderID)
+	assert.Equal(t, "PERCENTAGE", splits[1].SplitType)
+	assert.Equal(t, 38.0, splits[1].Amount)
+
+	// Splits beyond the order, or that do not cover their fees, are refused before the
+	// gateway is called
+	w = post(session(orderID+"_over", `[{"vendor_id": "vendor_1", "amount": 101}]`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = post(session(orderID+"_fees", `[{"vendor_id": "vendor_1", "amount": 1}]`))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	_, ok = server.Order(orderID + "_fees")
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// keepsLocalStatus reports whether a payment's local status should survive a gateway
+// status sync: an order we cancelled shows as terminated at Cashfree, and one paid the
+// wrong amount shows as paid.
+func keepsLocalStatus(local, remote domain.PaymentStatus) bool {
+	switch local {
+	case domain.PaymentCancelled:
+		return remote == domain.PaymentTerminated || remote == domain.PaymentTerminationRequested
+	case domain.PaymentAmountMismatch:
+		return remote.Paid()
+	default:
+		return false
+	}
+}
+
+// TerminatePayment terminates an unpaid Cashfree order and records the status Cashfree
+// reports, TERMINATED or TERMINATION_REQUESTED
+func (h *PaymentHandler) TerminatePayment(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	switch {
+	case payment.Gateway != "" && payment.Gateway != GatewayCashfree:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Only Cashfree orders can be terminated; cancel the payment instead"})
+		return
+	case payment.Status == domain.PaymentScheduled || payment.Status == domain.PaymentActivating || payment.Status == domain.PaymentPendingReview:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has not been created at the gateway; cancel the payment instead"})
+		return
+	}
+
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate payment"})
+		return
+	}
+
+	orderStatus, err := client.TerminateOrder(orderID)
+	if err != nil {
+		log.Printf("Failed to terminate order %s: %v", orderID, err)
+		respondGatewayError(c, err, "Failed to terminate payment")
+		return
+	}
+
+	err = h.repo.UpdatePaymentStatus(ctx, orderID, orderStatus.OrderStatus, payment.CFPaymentID, payment.PaymentMethod, payment.PaymentTime)
+	if err != nil {
+		log.Printf("Failed to update payment status: %v", err)
+		// Don't return error as the termination was accepted by Cashfree
+	}
+
+	h.publishPaymentEvent(ctx, events.PaymentTerminated, orderID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id": orderID,
+		"status":   orderStatus.OrderStatus,
+		"message":  "Payment terminated successfully",
+	})
+}
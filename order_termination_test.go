@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/domain"
+)
+
+func TestCashfreeClientTerminateOrder(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	status, err := client.TerminateOrder("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentTerminated, status.OrderStatus)
+
+	// Terminating twice is rejected, as the order is no longer active
+	_, err = client.TerminateOrder("order_1")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	server.DeferTermination = true
+	_, err = client.CreateOrder(testOrderRequest("order_2"))
+	require.NoError(t, err)
+	status, err = client.TerminateOrder("order_2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentTerminationRequested, status.OrderStatus)
+
+	_, err = client.TerminateOrder("order_missing")
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestKeepsLocalStatus(t *testing.T) {
+	assert.True(t, keepsLocalStatus(domain.PaymentCancelled, domain.PaymentTerminated))
+	assert.True(t, keepsLocalStatus(domain.PaymentCancelled, domain.PaymentTerminationRequested))
+	assert.False(t, keepsLocalStatus(domain.PaymentTerminationRequested, domain.PaymentTerminated))
+	assert.False(t, keepsLocalStatus("ACTIVE", domain.PaymentTerminated))
+	assert.True(t, keepsLocalStatus(domain.PaymentAmountMismatch, domain.PaymentPaid))
+	assert.False(t, keepsLocalStatus(domain.PaymentAmountMismatch, domain.PaymentTerminated))
+}
+
+func TestTerminatePayment(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	createOrder := func(orderID string) {
+		require.NoError(t, handler.repo.CreatePayment(context.Background(), testPayment(orderID)))
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+	}
+	status := func(orderID string) domain.PaymentStatus {
+		payment, err := handler.repo.GetPaymentByOrderID(context.Background(), orderID)
+		require.NoError(t, err)
+		return payment.Status
+	}
+
+	terminated := fmt.Sprintf("order_terminate_%d", time.Now().UnixNano())
+	createOrder(terminated)
+	w := serve(http.MethodPost, "/payments/"+terminated+"/terminate")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, domain.PaymentTerminated, status(terminated))
+
+	server.DeferTermination = true
+	requested := terminated + "_deferred"
+	createOrder(requested)
+	w = serve(http.MethodPost, "/payments/"+requested+"/terminate")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, domain.PaymentTerminationRequested, status(requested))
+
+	order, _ := server.Order(requested)
+	assert.Equal(t, cashfreetest.OrderTerminationRequested, order.Status)
+
+	// Cancelling keeps our own status even though Cashfree shows the order terminated
+	cancelled := terminated + "_cancelled"
+	createOrder(cancelled)
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/payments/"+cancelled+"/cancel").Code)
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "/payments/"+cancelled).Code)
+	assert.Equal(t, domain.PaymentCancelled, status(cancelled))
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodPost, "/payments/order_missing/terminate").Code)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OrderURLs configures the return_url and notify_url of new orders. Templates fill in
+// URLs the caller leaves out; caller-supplied URLs must have an allowed host.
+type OrderURLs struct {
+	ReturnURLTemplate string
+	NotifyURLTemplate string
+
+	// AllowedHosts are the hosts caller-supplied URLs may point at, either exact
+	// ("shop.example.com") or a subdomain wildcard ("*.example.com"). Empty allows any.
+	AllowedHosts []string
+}
+
+// urlTemplateVariables are the variables URL templates may use
+var urlTemplateVariables = map[string]bool{
+	"order_id":    true,
+	"customer_id": true,
+	"merchant_id": true,
+	"environment": true,
+}
+
+// urlTemplateVariable matches a {variable} in a URL template
+var urlTemplateVariable = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// validateURLTemplate checks that a URL template only uses known variables and is an
+// absolute http(s) URL once they are filled in
+func validateURLTemplate(field, template string) error {
+	return validateTemplate(field, template, urlTemplateVariables)
+}
+
+// validateTemplate checks a URL template against the variables it may use
+func validateTemplate(field, template string, variables map[string]bool) error {
+	for _, match := range urlTemplateVariable.FindAllString(template, -1) {
+		if !variables[strings.Trim(match, "{}")] {
+			return fmt.Errorf("%s uses unknown variable %s", field, match)
+		}
+	}
+
+	parsed, err := url.Parse(urlTemplateVariable.ReplaceAllString(template, "x"))
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL", field)
+	}
+	return nil
+}
+
+// expandURLTemplate fills in a URL template's variables, escaping their values
+func expandURLTemplate(template string, vars map[string]string) string {
+	return urlTemplateVariable.ReplaceAllStringFunc(template, func(match string) string {
+		return url.QueryEscape(vars[strings.Trim(match, "{}")])
+	})
+}
+
+// validAllowedHost checks an allowlist entry
+func validAllowedHost(host string) bool {
+	host = strings.TrimPrefix(host, "*.")
+	return host != "" && !strings.ContainsAny(host, "/:*?# ")
+}
+
+// hostAllowed reports whether a host is on the allowlist. An empty allowlist allows any
+// host.
+func hostAllowed(host string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, entry := range allowed {
+		entry = strings.ToLower(entry)
+		if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// orderURLsFor returns the URL configuration for an order: the merchant's templates and
+// allowlist where it has them, and the deployment's otherwise
+func (h *PaymentHandler) orderURLsFor(ctx context.Context) OrderURLs {
+	urls := h.orderURLs
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		if merchant.ReturnURLTemplate != nil {
+			urls.ReturnURLTemplate = *merchant.ReturnURLTemplate
+		}
+		if merchant.NotifyURLTemplate != nil {
+			urls.NotifyURLTemplate = *merchant.NotifyURLTemplate
+		}
+		if len(merchant.AllowedURLHosts) > 0 {
+			urls.AllowedHosts = merchant.AllowedURLHosts
+		}
+	}
+	return urls
+}
+
+// resolveOrderURLs returns the return_url and notify_url for a new order, checking
+// caller-supplied URLs against the allowlist and expanding templates for the rest
+func (h *PaymentHandler) resolveOrderURLs(ctx context.Context, req *CreatePaymentSessionRequest) (string, string, error) {
+	urls := h.orderURLsFor(ctx)
+
+	environment := CashfreeEnvironmentFromContext(ctx)
+	if environment == "" && h.cashfree != nil {
+		environment = strings.ToUpper(h.cashfree.Environment)
+	}
+	vars := map[string]string{
+		"order_id":    req.OrderID,
+		"customer_id": req.CustomerID,
+		"environment": environment,
+	}
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		vars["merchant_id"] = merchant.ID.String()
+		vars["environment"] = strings.ToUpper(merchant.Environment)
+	}
+
+	resolve := func(field, supplied, template string) (string, error) {
+		if supplied == "" {
+			if template == "" {
+				return "", fmt.Errorf("%s is required", field)
+			}
+			return expandURLTemplate(template, vars), nil
+		}
+
+		parsed, err := url.Parse(supplied)
+		if err != nil || !hostAllowed(parsed.Hostname(), urls.AllowedHosts) {
+			return "", fmt.Errorf("%s host is not allowed", field)
+		}
+		return supplied, nil
+	}
+
+	returnURL, err := resolve("return_url", req.ReturnURL, urls.ReturnURLTemplate)
+	if err != nil {
+		return "", "", err
+	}
+	notifyURL, err := resolve("notify_url", req.NotifyURL, urls.NotifyURLTemplate)
+	if err != nil {
+		return "", "", err
+	}
+	return returnURL, notifyURL, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandURLTemplate(t *testing.T) {
+	url := expandURLTemplate("https://shop.example.com/return?order_id={order_id}&c={customer_id}", map[string]string{
+		"order_id":    "order 1&x=2",
+		"customer_id": "cust_1",
+	})
+	assert.Equal(t, "https://shop.example.com/return?order_id=order+1%26x%3D2&c=cust_1", url)
+
+	assert.ErrorContains(t, validateURLTemplate("return_url_template", "https://shop.example.com/{order}"), "unknown variable {order}")
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"shop.example.com", "*.acme.in"}
+
+	assert.True(t, hostAllowed("shop.example.com", allowed))
+	assert.True(t, hostAllowed("Checkout.Acme.in", allowed))
+	assert.False(t, hostAllowed("acme.in", allowed))
+	assert.False(t, hostAllowed("evil.example.com", allowed))
+	assert.False(t, hostAllowed("shop.example.com.evil.io", allowed))
+
+	// Without an allowlist any host is accepted
+	assert.True(t, hostAllowed("evil.example.com", nil))
+}
+
+func TestResolveOrderURLs(t *testing.T) {
+	handler := &PaymentHandler{
+		cashfree: NewCashfreeClient("id", "secret", "TEST"),
+		orderURLs: OrderURLs{
+			ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}&env={environment}",
+			AllowedHosts:      []string{"shop.example.com"},
+		},
+	}
+	req := &CreatePaymentSessionRequest{OrderID: "order_1", CustomerID: "cust_1"}
+
+	// The notify URL has no template, so the caller must send one
+	_, _, err := handler.resolveOrderURLs(context.Background(), req)
+	assert.EqualError(t, err, "notify_url is required")
+
+	req.NotifyURL = "https://evil.example.com/webhook"
+	_, _, err = handler.resolveOrderURLs(context.Background(), req)
+	assert.EqualError(t, err, "notify_url host is not allowed")
+
+	req.NotifyURL = "https://shop.example.com/webhook"
+	returnURL, notifyURL, err := handler.resolveOrderURLs(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "https://shop.example.com/return?order_id=order_1&env=TEST", returnURL)
+	assert.Equal(t, "https://shop.example.com/webhook", notifyURL)
+
+	// A merchant's templates and allowlist replace the deployment's
+	merchantReturn := "https://acme.in/orders/{order_id}"
+	merchantNotify := "https://pay.example.com/api/v1/webhook/cashfree/{merchant_id}"
+	merchant := &Merchant{
+		ID:                uuid.New(),
+		Environment:       "PROD",
+		ReturnURLTemplate: &merchantReturn,
+		NotifyURLTemplate: &merchantNotify,
+		AllowedURLHosts:   []string{"acme.in"},
+	}
+	ctx := WithMerchant(context.Background(), merchant)
+
+	_, _, err = handler.resolveOrderURLs(ctx, req)
+	assert.EqualError(t, err, "notify_url host is not allowed")
+
+	req.NotifyURL = ""
+	returnURL, notifyURL, err = handler.resolveOrderURLs(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "https://acme.in/orders/order_1", returnURL)
+	assert.Equal(t, "https://pay.example.com/api/v1/webhook/cashfree/"+merchant.ID.String(), notifyURL)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+)
+
+// PaymentAttempt is one attempt to pay an order, as the gateway last listed it. An order
+// has one successful attempt at most, behind any number that failed.
+type PaymentAttempt struct {
+	ID          uuid.UUID  `json:"-" db:"id"`
+	OrderID     string     `json:"order_id" db:"order_id"`
+	CFPaymentID string     `json:"cf_payment_id" db:"cf_payment_id"`
+	Status      string     `json:"payment_status" db:"status"`
+	Amount      float64    `json:"payment_amount" db:"amount"`
+	Method      string     `json:"payment_method,omitempty" db:"payment_method"`
+	PaymentTime *time.Time `json:"payment_time,omitempty" db:"payment_time"`
+	TenantID    *uuid.UUID `json:"-" db:"tenant_id"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// successfulAttempt returns the successful attempt among an order's, wherever it is
+// listed, or nil
+func successfulAttempt(attempts []CashfreePaymentResponse) *CashfreePaymentResponse {
+	for i := range attempts {
+		if attempts[i].PaymentStatus == "SUCCESS" {
+			return &attempts[i]
+		}
+	}
+	return nil
+}
+
+// PaymentAttemptCache keeps the payment attempts last listed for orders, each under the
+// order status they were listed at. The attempts are listed again once the order's
+// status changes, such as when it is paid. The oldest orders are dropped beyond its size.
+type PaymentAttemptCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]cachedAttempts
+	keys    []string // in the order they were added
+}
+
+type cachedAttempts struct {
+	status   domain.PaymentStatus
+	attempts []CashfreePaymentResponse
+}
+
+// NewPaymentAttemptCache creates a cache of the attempts of up to size orders
+func NewPaymentAttemptCache(size int) *PaymentAttemptCache {
+	return &PaymentAttemptCache{
+		size:    max(size, 1),
+		entries: make(map[string]cachedAttempts),
+	}
+}
+
+// Get returns the attempts cached under key if they were listed at status. A nil cache
+// has none.
+func (c *PaymentAttemptCache) Get(key string, status domain.PaymentStatus) ([]CashfreePaymentResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || entry.status != status {
+		return nil, false
+	}
+	return entry.attempts, true
+}
+
+// Put caches attempts listed at status under key, replacing those listed before
+func (c *PaymentAttemptCache) Put(key string, status domain.PaymentStatus, attempts []CashfreePaymentResponse) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		if len(c.keys) >= c.size {
+			delete(c.entries, c.keys[0])
+			c.keys = c.keys[1:]
+		}
+		c.keys = append(c.keys, key)
+	}
+	c.entries[key] = cachedAttempts{status: status, attempts: attempts}
+}
+
+// RecordPaymentAttempts reconciles the attempts the gateway listed for an order into
+// payment_attempts, adding new attempts and updating the status of known ones. Attempts
+// of an order unknown to the database are not recorded.
+func (r *PaymentRepository) RecordPaymentAttempts(ctx context.Context, orderID string, attempts []CashfreePaymentResponse) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 9)
+	query := `
+		INSERT INTO payment_attempts (
+			id, order_id, cf_payment_id, status, amount, payment_method, payment_time,
+			tenant_id, created_at, updated_at
+		)
+		SELECT $1, order_id, $3, $4, $5, NULLIF($6, ''), $7, tenant_id, $8, $8
+		FROM payments
+		WHERE order_id = $2` + tenant + `
+		ON CONFLICT (cf_payment_id) DO UPDATE
+		SET status = EXCLUDED.status, amount = EXCLUDED.amount,
+			payment_method = EXCLUDED.payment_method, payment_time = EXCLUDED.payment_time,
+			updated_at = EXCLUDED.updated_at
+		WHERE payment_attempts.status <> EXCLUDED.status
+	`
+
+	now := r.now()
+	for _, attempt := range attempts {
+		if attempt.CFPaymentID == "" {
+			continue
+		}
+		var paymentTime *time.Time
+		if !attempt.PaymentTime.IsZero() {
+			t := attempt.PaymentTime
+			paymentTime = &t
+		}
+		args := append([]interface{}{
+			uuid.New(), orderID, attempt.CFPaymentID, attempt.PaymentStatus,
+			attempt.PaymentAmount, attempt.PaymentMethod, paymentTime, now,
+		}, tenantArgs...)
+		if _, err := tx.Exec(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// ListPaymentAttempts returns the recorded attempts to pay an order, newest first
+func (r *PaymentRepository) ListPaymentAttempts(ctx context.Context, orderID string) ([]PaymentAttempt, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT id, order_id, cf_payment_id, status, amount, COALESCE(payment_method, ''),
+			   payment_time, tenant_id, created_at, updated_at
+		FROM payment_attempts
+		WHERE order_id = $1` + tenant + `
+		ORDER BY payment_time DESC NULLS LAST, created_at DESC
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []PaymentAttempt{}
+	for rows.Next() {
+		var attempt PaymentAttempt
+		err := rows.Scan(
+			&attempt.ID, &attempt.OrderID, &attempt.CFPaymentID, &attempt.Status, &attempt.Amount,
+			&attempt.Method, &attempt.PaymentTime, &attempt.TenantID, &attempt.CreatedAt, &attempt.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// paymentAttempts lists an order's attempts from Cashfree, every page of them, unless
+// they were listed at the order's current status already. Freshly listed attempts are
+// recorded in payment_attempts.
+func (h *PaymentHandler) paymentAttempts(ctx context.Context, client *CashfreeClient, orderID string, status domain.PaymentStatus) ([]CashfreePaymentResponse, error) {
+	key := client.ClientID + "/" + orderID
+	if attempts, ok := h.attempts.Get(key, status); ok {
+		return attempts, nil
+	}
+
+	attempts, err := client.ListPayments(orderID)
+	if err != nil {
+		return nil, err
+	}
+	h.attempts.Put(key, status, attempts)
+
+	if err := h.repo.RecordPaymentAttempts(ctx, orderID, attempts); err != nil {
+		log.Printf("Failed to record payment attempts of %s: %v", orderID, err)
+	}
+	return attempts, nil
+}
+
+// orderPayment gets the payment of an order from its gateway: the successful attempt,
+// however many attempts were made after it, or the newest when none succeeded
+func (h *PaymentHandler) orderPayment(ctx context.Context, gateway PaymentGateway, order *CashfreeOrderStatusResponse) (*CashfreePaymentResponse, error) {
+	client, ok := gateway.(*CashfreeClient)
+	if !ok {
+		return gateway.GetPayments(order.OrderID)
+	}
+
+	attempts, err := h.paymentAttempts(ctx, client, order.OrderID, order.OrderStatus)
+	if err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return nil, fmt.Errorf("no payments found for order %s", order.OrderID)
+	}
+	if paid := successfulAttempt(attempts); paid != nil {
+		return paid, nil
+	}
+	return &attempts[0], nil
+}
+
+// GetPaymentAttempts lists the recorded attempts to pay an order, newest first
+func (h *PaymentHandler) GetPaymentAttempts(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.repo.GetPaymentByOrderID(ctx, orderID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	attempts, err := h.repo.ListPaymentAttempts(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get payment attempts of order %s: %v", orderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve payment attempts"})
+		return
+	}
+
+	validator := newListValidator()
+	for _, attempt := range attempts {
+		validator.add(attempt, attempt.UpdatedAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"order_id": orderID, "attempts": attempts})
+}
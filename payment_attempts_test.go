@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestListPaymentsPages(t *testing.T) {
+	gateway, client := newFakeCashfree(t)
+	gateway.PaymentsPageSize = 1
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	_, err = gateway.FailPayment("order_1", "card")
+	require.NoError(t, err)
+	_, err = gateway.FailPayment("order_1", "netbanking")
+	require.NoError(t, err)
+	_, err = gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+
+	attempts, err := client.ListPayments("order_1")
+	require.NoError(t, err)
+	require.Len(t, attempts, 3)
+	assert.Equal(t, []string{"upi", "netbanking", "card"}, []string{attempts[0].PaymentMethod, attempts[1].PaymentMethod, attempts[2].PaymentMethod})
+}
+
+func TestGetPaymentsFindsSuccessBehindFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"cf_payment_id": "cf_3", "order_id": "order_1", "payment_status": "FAILED", "payment_method": "card"},
+			{"cf_payment_id": "cf_2", "order_id": "order_1", "payment_status": "SUCCESS", "payment_method": "upi"},
+			{"cf_payment_id": "cf_1", "order_id": "order_1", "payment_status": "FAILED", "payment_method": "upi"}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewCashfreeClient("id", "secret", "TEST")
+	client.BaseURL = server.URL
+	client.Client.SetRetryCount(0)
+
+	payment, err := client.GetPayments("order_1")
+	require.NoError(t, err)
+	assert.Equal(t, "cf_2", payment.CFPaymentID)
+}
+
+func TestPaymentAttemptCache(t *testing.T) {
+	cache := NewPaymentAttemptCache(2)
+	attempts := []CashfreePaymentResponse{{CFPaymentID: "cf_1", PaymentStatus: "FAILED"}}
+
+	cache.Put("client/order_1", domain.PaymentActive, attempts)
+	cached, ok := cache.Get("client/order_1", domain.PaymentActive)
+	assert.True(t, ok)
+	assert.Equal(t, attempts, cached)
+
+	// A change of order status lists the attempts again
+	_, ok = cache.Get("client/order_1", domain.PaymentPaid)
+	assert.False(t, ok)
+
+	// The oldest order is dropped beyond the cache's size
+	cache.Put("client/order_2", domain.PaymentActive, nil)
+	cache.Put("client/order_3", domain.PaymentActive, nil)
+	_, ok = cache.Get("client/order_1", domain.PaymentActive)
+	assert.False(t, ok)
+	_, ok = cache.Get("client/order_3", domain.PaymentActive)
+	assert.True(t, ok)
+
+	var none *PaymentAttemptCache
+	none.Put("client/order_1", domain.PaymentActive, attempts)
+	_, ok = none.Get("client/order_1", domain.PaymentActive)
+	assert.False(t, ok)
+}
+
+func TestVerifyRecordsPaymentAttempts(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	gateway.PaymentsPageSize = 1
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		attempts:     NewPaymentAttemptCache(10),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	payment := testPayment("order_1")
+	payment.Amount = 499.5
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+	_, err = gateway.FailPayment("order_1", "card")
+	require.NoError(t, err)
+	_, err = gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/verify", bytes.NewBufferString(`{"order_id": "order_1"}`)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments/order_1/attempts", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		Attempts []PaymentAttempt `json:"attempts"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Attempts, 2)
+	statuses := map[string]string{}
+	for _, attempt := range resp.Attempts {
+		statuses[attempt.Method] = attempt.Status
+	}
+	assert.Equal(t, map[string]string{"card": "FAILED", "upi": "SUCCESS"}, statuses)
+
+	payment, err = repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, payment.Status)
+	require.NotNil(t, payment.PaymentMethod)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// PaymentMethodDetails is what a payment was made with, kept for support and risk
+// review. Cards are only ever kept masked.
+type PaymentMethodDetails struct {
+	Method      string `json:"method"`                // upi, card, netbanking, app, emi, ...
+	Channel     string `json:"channel,omitempty"`     // collect, intent, link, ...
+	UPIID       string `json:"upi_id,omitempty"`      // the customer's VPA
+	CardNumber  string `json:"card_number,omitempty"` // masked, such as 470613XXXXXX2123
+	CardLast4   string `json:"card_last4,omitempty"`
+	CardNetwork string `json:"card_network,omitempty"` // visa, mastercard, rupay, ...
+	CardType    string `json:"card_type,omitempty"`    // credit_card, debit_card, prepaid_card
+	CardCountry string `json:"card_country,omitempty"` // issuing country
+	BankName    string `json:"bank_name,omitempty"`    // the card's issuer or the netbanking bank
+	BankCode    string `json:"bank_code,omitempty"`    // Cashfree's netbanking bank code
+	Provider    string `json:"provider,omitempty"`     // wallet, pay later or cardless EMI provider
+}
+
+// webhookPaymentMethodDetails reads the details of a payment's method object, or returns
+// nil in the flat shape, where the payment method is only a name
+func webhookPaymentMethodDetails(payment map[string]interface{}) *PaymentMethodDetails {
+	methods, ok := payment["payment_method"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	name := webhookPaymentMethod(payment)
+	method, ok := methods[name].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	details := &PaymentMethodDetails{
+		Method:      name,
+		Channel:     webhookString(method["channel"]),
+		UPIID:       webhookString(method["upi_id"]),
+		CardNumber:  maskCardNumber(webhookString(method["card_number"])),
+		CardNetwork: webhookString(method["card_network"]),
+		CardType:    webhookString(method["card_type"]),
+		CardCountry: webhookString(method["card_country"]),
+		BankName:    webhookString(method["card_bank_name"]),
+		BankCode:    webhookString(method["netbanking_bank_code"]),
+		Provider:    webhookString(method["provider"]),
+	}
+	if details.CardNumber != "" {
+		details.CardLast4 = details.CardNumber[len(details.CardNumber)-4:]
+	}
+	if details.BankName == "" {
+		details.BankName = webhookString(method["netbanking_bank_name"])
+	}
+	if details.BankName == "" {
+		details.BankName = webhookString(method["emi_bank"])
+	}
+	return details
+}
+
+// maskCardNumber returns a card number as Cashfree masks it, with every digit but the
+// first six and last four hidden. A number Cashfree already masked is kept as it is, and
+// one too short to be a card number is dropped.
+func maskCardNumber(number string) string {
+	number = strings.ReplaceAll(number, " ", "")
+	if len(number) < 10 {
+		return ""
+	}
+	if strings.IndexFunc(number, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+		return number
+	}
+	return number[:6] + strings.Repeat("X", len(number)-10) + number[len(number)-4:]
+}
+
+// SetPaymentMethodDetails records what an order's payment was made with
+func (r *PaymentRepository) SetPaymentMethodDetails(ctx context.Context, orderID string, details *PaymentMethodDetails) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 4)
+	query := `
+		UPDATE payments
+		SET payment_method_details = $1, updated_at = $2
+		WHERE order_id = $3` + tenant
+
+	args := append([]interface{}{details, r.now(), orderID}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// recordPaymentMethodDetails saves what an order's payment was made with when the
+// gateway reported it. Failures are logged, as the payment itself has been recorded.
+func (h *PaymentHandler) recordPaymentMethodDetails(ctx context.Context, orderID string, details *PaymentMethodDetails) {
+	if details == nil {
+		return
+	}
+	if err := h.repo.SetPaymentMethodDetails(ctx, orderID, details); err != nil {
+		log.Printf("Failed to record payment method details for %s: %v", orderID, err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPaymentMethodDetails(t *testing.T) {
+	method := func(name string, details map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"payment_method": map[string]interface{}{name: details}}
+	}
+
+	assert.Equal(t, &PaymentMethodDetails{Method: "upi", Channel: "collect", UPIID: "customer@okicici"},
+		webhookPaymentMethodDetails(method("upi", map[string]interface{}{"channel": "collect", "upi_id": "customer@okicici"})))
+	assert.Equal(t, &PaymentMethodDetails{
+		Method: "card", CardNumber: "470613XXXXXX2123", CardLast4: "2123", CardNetwork: "visa",
+		CardType: "debit_card", CardCountry: "IN", BankName: "HDFC Bank",
+	}, webhookPaymentMethodDetails(method("card", map[string]interface{}{
+		"channel": nil, "card_number": "470613XXXXXX2123", "card_network": "visa",
+		"card_type": "debit_card", "card_country": "IN", "card_bank_name": "HDFC Bank",
+	})))
+	assert.Equal(t, &PaymentMethodDetails{Method: "netbanking", Channel: "link", BankName: "State Bank Of India", BankCode: "3044"},
+		webhookPaymentMethodDetails(method("netbanking", map[string]interface{}{
+			"channel": "link", "netbanking_bank_code": 3044.0, "netbanking_bank_name": "State Bank Of India",
+		})))
+	assert.Equal(t, &PaymentMethodDetails{Method: "app", Channel: "link", Provider: "paytm"},
+		webhookPaymentMethodDetails(method("app", map[string]interface{}{"channel": "link", "provider": "paytm", "phone": "9876543210"})))
+
+	// The flat shape names the method only
+	assert.Nil(t, webhookPaymentMethodDetails(map[string]interface{}{"payment_method": "upi"}))
+}
+
+func TestMaskCardNumber(t *testing.T) {
+	assert.Equal(t, "XXXXXXXXXXXX1111", maskCardNumber("XXXXXXXXXXXX1111"))
+	// Full numbers are never kept
+	assert.Equal(t, "411111XXXXXX1111", maskCardNumber("4111 1111 1111 1111"))
+	assert.Empty(t, maskCardNumber("1111"))
+}
+
+func TestPaymentMethodDetailsRecorded(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/webhook/cashfree", handler.HandleWebhook)
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	webhook, err := gateway.SignWebhook("PAYMENT_SUCCESS_WEBHOOK", map[string]interface{}{
+		"order": map[string]interface{}{"order_id": "order_1"},
+		"payment": map[string]interface{}{
+			"cf_payment_id":  "cf_payment_1",
+			"payment_status": "SUCCESS",
+			"payment_amount": 100,
+			"payment_time":   "2024-04-01T10:00:00+05:30",
+			"payment_method": map[string]interface{}{"upi": map[string]interface{}{
+				"channel": "intent", "upi_id": "customer@ybl",
+			}},
+		},
+	})
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, webhook.Request("/webhook/cashfree"))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments/order_1", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		MethodDetails *PaymentMethodDetails `json:"payment_method_details"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, &PaymentMethodDetails{Method: "upi", Channel: "intent", UPIID: "customer@ybl"}, resp.MethodDetails)
+}
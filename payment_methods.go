@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// cashfreePaymentMethods are the codes Cashfree accepts in order_meta.payment_methods
+var cashfreePaymentMethods = map[string]bool{
+	"cc":           true, // credit card
+	"dc":           true, // debit card
+	"ccc":          true, // corporate credit card
+	"ppc":          true, // prepaid card
+	"nb":           true, // net banking
+	"upi":          true,
+	"paypal":       true,
+	"app":          true, // wallets
+	"paylater":     true,
+	"cardlessemi":  true,
+	"dcemi":        true,
+	"ccemi":        true,
+	"banktransfer": true,
+}
+
+// CashfreePaymentMethods returns the payment method codes Cashfree accepts, sorted
+func CashfreePaymentMethods() []string {
+	methods := make([]string, 0, len(cashfreePaymentMethods))
+	for method := range cashfreePaymentMethods {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// parsePaymentMethods parses a comma-separated list of payment method codes such as
+// "upi,cc,dc". Codes are lower-cased and duplicates dropped, keeping the list's order.
+// Each must be in allowed, or be any Cashfree method when allowed is empty.
+func parsePaymentMethods(value string, allowed []string) ([]string, error) {
+	var methods []string
+	seen := make(map[string]bool)
+	for _, method := range strings.Split(value, ",") {
+		method = strings.ToLower(strings.TrimSpace(method))
+		if method == "" || seen[method] {
+			continue
+		}
+		if !cashfreePaymentMethods[method] {
+			return nil, fmt.Errorf("unknown payment method %q; Cashfree accepts %s", method, strings.Join(CashfreePaymentMethods(), ", "))
+		}
+		if len(allowed) > 0 && !slices.Contains(allowed, method) {
+			return nil, fmt.Errorf("payment method %q is not allowed", method)
+		}
+		seen[method] = true
+		methods = append(methods, method)
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no payment methods listed")
+	}
+	return methods, nil
+}
+
+// razorpayCheckoutMethods maps Cashfree payment methods to the Razorpay checkout
+// methods that offer them
+var razorpayCheckoutMethods = map[string]string{
+	"cc":          "card",
+	"dc":          "card",
+	"ccc":         "card",
+	"ppc":         "card",
+	"nb":          "netbanking",
+	"upi":         "upi",
+	"app":         "wallet",
+	"paylater":    "paylater",
+	"cardlessemi": "emi",
+	"dcemi":       "emi",
+	"ccemi":       "emi",
+}
+
+// razorpayMethodOptions restricts a Razorpay payment link's checkout to the methods
+// of a Cashfree payment_methods list, or returns nil to allow them all. Methods
+// Razorpay has no equivalent of are left out.
+func razorpayMethodOptions(paymentMethods string) *razorpayOptions {
+	if paymentMethods == "" {
+		return nil
+	}
+
+	method := make(map[string]string)
+	for _, checkout := range razorpayCheckoutMethods {
+		method[checkout] = "0"
+	}
+	for _, code := range strings.Split(paymentMethods, ",") {
+		if checkout, ok := razorpayCheckoutMethods[code]; ok {
+			method[checkout] = "1"
+		}
+	}
+	return &razorpayOptions{Checkout: razorpayCheckoutOptions{Method: method}}
+}
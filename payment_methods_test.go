@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePaymentMethods(t *testing.T) {
+	methods, err := parsePaymentMethods(" UPI,cc,dc,upi ", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"upi", "cc", "dc"}, methods)
+
+	methods, err = parsePaymentMethods("upi,cc", []string{"upi", "cc", "dc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"upi", "cc"}, methods)
+
+	_, err = parsePaymentMethods("upi,nb", []string{"upi", "cc", "dc"})
+	assert.EqualError(t, err, `payment method "nb" is not allowed`)
+	_, err = parsePaymentMethods("upi,card", nil)
+	assert.ErrorContains(t, err, `unknown payment method "card"; Cashfree accepts app, banktransfer, cardlessemi`)
+	_, err = parsePaymentMethods(" , ", nil)
+	assert.EqualError(t, err, "no payment methods listed")
+}
+
+func TestRazorpayMethodOptions(t *testing.T) {
+	assert.Nil(t, razorpayMethodOptions(""))
+
+	options := razorpayMethodOptions("upi,cc,dc")
+	require.NotNil(t, options)
+	assert.Equal(t, map[string]string{
+		"card": "1", "upi": "1", "netbanking": "0", "wallet": "0", "paylater": "0", "emi": "0",
+	}, options.Checkout.Method)
+}
+
+func TestCreatePaymentSessionRestrictsPaymentMethods(t *testing.T) {
+	server, client := newFakeCashfree(t)
+	handler := &PaymentHandler{
+		cashfree:       client,
+		gateways:       NewGatewayRouter(client, nil, false),
+		environments:   map[string]*CashfreeClient{EnvironmentTest: client},
+		orderURLs:      OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+		paymentMethods: []string{"upi", "cc", "dc"},
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(`{
+		"order_id": "order_1", "amount": 50, "currency": "INR", "customer_id": "cust_1",
+		"customer_name": "John Doe", "customer_email": "john@example.com",
+		"customer_phone": "9999999999", "notify_url": "https://shop.example.com/notify",
+		"payment_methods": "upi,nb"
+	}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error": "payment method \"nb\" is not allowed"}`, w.Body.String())
+	_, ok := server.Order("order_1")
+	assert.False(t, ok)
+
+	// Allowed methods reach Cashfree as order_meta.payment_methods
+	order := testOrderRequest("order_2")
+	order.OrderMeta = &OrderMeta{PaymentMethods: "upi,cc"}
+	_, err := client.CreateOrder(order)
+	require.NoError(t, err)
+	stored, ok := server.Order("order_2")
+	require.True(t, ok)
+	assert.Equal(t, "upi,cc", stored.PaymentMethods)
+}
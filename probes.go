@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/queue"
+)
+
+// readinessCheckTimeout bounds each dependency check, so a hung dependency fails its
+// check rather than the probe
+const readinessCheckTimeout = 2 * time.Second
+
+// ReadinessCheck is a dependency probed before and while the instance takes traffic
+type ReadinessCheck struct {
+	Name     string
+	Required bool // a failing required check makes the instance unready
+	Check    func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of a readiness check
+type CheckResult struct {
+	Name       string  `json:"name"`
+	OK         bool    `json:"ok"`
+	Required   bool    `json:"required"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Lifecycle tracks whether the instance has started and whether it is draining for a
+// shutdown, and serves the probes orchestrators such as Kubernetes use to route traffic
+type Lifecycle struct {
+	checks []ReadinessCheck
+
+	mu       sync.RWMutex
+	started  bool
+	draining bool
+	warnings []string // problems the instance serves despite, such as unchecked credentials
+}
+
+// NewLifecycle creates a lifecycle gated on checks
+func NewLifecycle(checks ...ReadinessCheck) *Lifecycle {
+	return &Lifecycle{checks: checks}
+}
+
+// check runs every check concurrently and reports whether the required ones passed
+func (l *Lifecycle) check(ctx context.Context) ([]CheckResult, bool) {
+	results := make([]CheckResult, len(l.checks))
+	var wg sync.WaitGroup
+	for i, check := range l.checks {
+		wg.Add(1)
+		go func(i int, check ReadinessCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+			defer cancel()
+
+			started := time.Now()
+			err := check.Check(ctx)
+			results[i] = CheckResult{
+				Name:       check.Name,
+				OK:         err == nil,
+				Required:   check.Required,
+				DurationMS: float64(time.Since(started)) / float64(time.Millisecond),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, check)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, result := range results {
+		if result.Required && !result.OK {
+			ready = false
+		}
+	}
+	return results, ready
+}
+
+// WaitForStartup runs the checks every interval until the required ones pass, then
+// marks the instance started. It returns early when ctx is done.
+func (l *Lifecycle) WaitForStartup(ctx context.Context, interval time.Duration) {
+	for {
+		results, ready := l.check(ctx)
+		if ready {
+			l.mu.Lock()
+			l.started = true
+			l.mu.Unlock()
+			log.Printf("Startup checks passed; ready for traffic")
+			return
+		}
+		for _, result := range results {
+			if result.Required && !result.OK {
+				log.Printf("Waiting for %s: %s", result.Name, result.Error)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Degrade records a problem the instance keeps serving despite. Readiness still passes,
+// reporting the instance degraded with its warnings.
+func (l *Lifecycle) Degrade(warning string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, warning)
+}
+
+// Drain marks the instance as shutting down, so readiness fails and traffic moves to
+// other instances while requests in flight finish
+func (l *Lifecycle) Drain() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.draining = true
+}
+
+func (l *Lifecycle) state() (started, draining bool, warnings []string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.started, l.draining, l.warnings
+}
+
+// Livez reports that the process is up
+func (l *Lifecycle) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Startupz reports whether the startup checks have passed
+func (l *Lifecycle) Startupz(c *gin.Context) {
+	if started, _, _ := l.state(); !started {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "started"})
+}
+
+// Readyz reports whether the instance should take traffic: it has started, is not
+// draining and its required dependencies are up. A ready instance with warnings is
+// reported degraded. With ?verbose=1 it lists every check.
+func (l *Lifecycle) Readyz(c *gin.Context) {
+	started, draining, warnings := l.state()
+	var verbose bool
+	if value := c.Query("verbose"); value != "" {
+		var err error
+		verbose, err = strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "verbose must be true or false"})
+			return
+		}
+	}
+
+	status := "ready"
+	switch {
+	case draining:
+		status = "draining"
+	case !started:
+		status = "starting"
+	}
+
+	var results []CheckResult
+	if status == "ready" || verbose {
+		var passed bool
+		results, passed = l.check(c.Request.Context())
+		if status == "ready" && !passed {
+			status = "unready"
+		}
+	}
+	if status == "ready" && len(warnings) > 0 {
+		status = "degraded"
+	}
+
+	code := http.StatusOK
+	if status != "ready" && status != "degraded" {
+		code = http.StatusServiceUnavailable
+	}
+	body := gin.H{"status": status}
+	if len(warnings) > 0 {
+		body["warnings"] = warnings
+	}
+	if verbose {
+		body["checks"] = results
+	}
+	c.JSON(code, body)
+}
+
+// pinger is a dependency that can tell whether it is reachable
+type pinger interface {
+	Ping() error
+}
+
+// schemaCheck verifies the database has every column the service reads, which fails
+// until migrations.sql has been applied
+func schemaCheck(pool *pgxpool.Pool) func(ctx context.Context) error {
+	tables := []struct{ table, columns string }{
+		{"payments", paymentColumns},
+		{"refunds", refundColumns},
+		{"recon_runs", reconRunColumns},
+		{"risk_assessments", riskAssessmentColumns},
+		{"blocklist", blocklistColumns},
+	}
+	return func(ctx context.Context) error {
+		for _, t := range tables {
+			if _, err := pool.Exec(ctx, `SELECT `+t.columns+` FROM `+t.table+` LIMIT 0`); err != nil {
+				return fmt.Errorf("migrations have not been applied: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+// lifecycleChecks returns the checks gating startup and readiness: the database and
+// its schema, the task queue when it can be pinged, and, for information only, the
+// Cashfree circuit breakers
+func lifecycleChecks(pool *pgxpool.Pool, tasks queue.Queue, clients map[string]*CashfreeClient) []ReadinessCheck {
+	checks := []ReadinessCheck{
+		{Name: "database", Required: true, Check: pool.Ping},
+		{Name: "schema", Required: true, Check: schemaCheck(pool)},
+	}
+	if p, ok := tasks.(pinger); ok {
+		checks = append(checks, ReadinessCheck{Name: "task_queue", Required: true, Check: func(ctx context.Context) error {
+			return p.Ping()
+		}})
+	}
+	envs := make([]string, 0, len(clients))
+	for env := range clients {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		client := clients[env]
+		checks = append(checks, ReadinessCheck{Name: "cashfree_" + env, Check: func(ctx context.Context) error {
+			if client.Breaker != nil && client.Breaker.State() == CircuitOpen {
+				return errors.New("circuit breaker is open")
+			}
+			return nil
+		}})
+	}
+	return checks
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycleProbes(t *testing.T) {
+	var dbUp atomic.Bool
+	lifecycle := NewLifecycle(
+		ReadinessCheck{Name: "database", Required: true, Check: func(ctx context.Context) error {
+			if !dbUp.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}},
+		ReadinessCheck{Name: "cashfree_TEST", Check: func(ctx context.Context) error { return errors.New("circuit breaker is open") }},
+	)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/livez", lifecycle.Livez)
+	r.GET("/startupz", lifecycle.Startupz)
+	r.GET("/readyz", lifecycle.Readyz)
+	probe := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return w.Code, body
+	}
+
+	code, _ := probe("/livez")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Startup waits for the required checks
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		lifecycle.WaitForStartup(ctx, time.Millisecond)
+		close(done)
+	}()
+	code, body := probe("/startupz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "starting", body["status"])
+	code, body = probe("/readyz?verbose=1")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "starting", body["status"])
+	assert.Len(t, body["checks"], 2)
+
+	dbUp.Store(true)
+	<-done
+	cancel()
+	code, _ = probe("/startupz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Optional checks are reported without failing readiness
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", body["status"])
+	assert.NotContains(t, body, "checks")
+	code, body = probe("/readyz?verbose=1")
+	assert.Equal(t, http.StatusOK, code)
+	checks := body["checks"].([]interface{})
+	assert.Equal(t, true, checks[0].(map[string]interface{})["ok"])
+	assert.Equal(t, false, checks[1].(map[string]interface{})["ok"])
+	assert.Equal(t, "circuit breaker is open", checks[1].(map[string]interface{})["error"])
+	code, body = probe("/readyz?verbose=false")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "checks")
+	code, _ = probe("/readyz?verbose=yes")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	lifecycle.Drain()
+	code, body = probe("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "draining", body["status"])
+}
+
+func TestLifecycleDegraded(t *testing.T) {
+	lifecycle := NewLifecycle()
+	lifecycle.Degrade("Cashfree PROD rejected client abc")
+	lifecycle.WaitForStartup(context.Background(), time.Millisecond)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/readyz", lifecycle.Readyz)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// A degraded instance still takes traffic
+	assert.Equal(t, http.StatusOK, w.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "degraded", body["status"])
+	assert.Equal(t, []interface{}{"Cashfree PROD rejected client abc"}, body["warnings"])
+}
+
+func TestSchemaCheck(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	require.NoError(t, schemaCheck(db)(ctx))
+
+	_, err := db.Exec(ctx, `ALTER TABLE refunds DROP COLUMN refund_reference`)
+	require.NoError(t, err)
+	assert.ErrorContains(t, schemaCheck(db)(ctx), "migrations have not been applied")
+}
@@ -0,0 +1,228 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresConfig holds the settings for the Postgres queue backend
+type PostgresConfig struct {
+	Workers     int
+	MaxAttempts int
+
+	// VisibilityTimeout is how long a claimed task is hidden from other workers. A task
+	// whose worker died is claimed again once it passes; handlers are cancelled at it.
+	VisibilityTimeout time.Duration
+
+	// PollInterval is how long an idle worker waits before looking for tasks again
+	PollInterval time.Duration
+}
+
+// PostgresQueue dispatches tasks through the task_queue table, for deployments without
+// a broker.
+//
+// Workers on every instance claim due tasks with FOR UPDATE SKIP LOCKED, so each task
+// is handled by one worker at a time. A claimed task is hidden until its visibility
+// timeout passes; it is deleted once its handler succeeds. Failed tasks are retried
+// after a delay growing with their attempts until MaxAttempts is reached, after which
+// they are kept as dead with their last error.
+type PostgresQueue struct {
+	cfg      PostgresConfig
+	db       *pgxpool.Pool
+	handlers map[string]HandlerFunc
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewPostgresQueue creates a queue over the task_queue table of db
+func NewPostgresQueue(db *pgxpool.Pool, cfg PostgresConfig) *PostgresQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = time.Minute
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+
+	return &PostgresQueue{
+		cfg:      cfg,
+		db:       db,
+		handlers: make(map[string]HandlerFunc),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Register sets the handler for a task type. Only registered types are claimed, so
+// instances running different versions leave each other's tasks alone.
+func (q *PostgresQueue) Register(taskType string, handler HandlerFunc) {
+	q.handlers[taskType] = handler
+}
+
+// Enqueue stores a task, due straight away
+func (q *PostgresQueue) Enqueue(ctx context.Context, task Task) error {
+	query := `
+		INSERT INTO task_queue (id, task_type, payload, attempts, enqueued_at, visible_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (id) DO NOTHING
+	`
+
+	if _, err := q.db.Exec(ctx, query, task.ID, task.Type, []byte(task.Payload), task.Attempts, task.EnqueuedAt); err != nil {
+		return fmt.Errorf("failed to enqueue task: %v", err)
+	}
+
+	// Wake an idle worker of this instance rather than leave the task to the next poll
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Backlog returns the number of tasks due and waiting for a worker
+func (q *PostgresQueue) Backlog() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var backlog int
+	query := `SELECT COUNT(*) FROM task_queue WHERE dead_at IS NULL AND visible_at <= NOW()`
+	if err := q.db.QueryRow(ctx, query).Scan(&backlog); err != nil {
+		log.Printf("Failed to count queued tasks: %v", err)
+	}
+	return backlog
+}
+
+// Start launches the workers
+func (q *PostgresQueue) Start(ctx context.Context) error {
+	for i := 0; i < q.cfg.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	return nil
+}
+
+// Close stops claiming tasks and waits for in-flight tasks to finish
+func (q *PostgresQueue) Close() error {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+	})
+	q.wg.Wait()
+	return nil
+}
+
+func (q *PostgresQueue) work(ctx context.Context) {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stop:
+			return
+		default:
+		}
+
+		task, err := q.claim(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim a task: %v", err)
+		}
+		if task != nil {
+			q.process(ctx, *task)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(q.cfg.PollInterval):
+		}
+	}
+}
+
+// claim takes the longest due task of a registered type, hiding it from other workers
+// for the visibility timeout, or returns nil when none is due
+func (q *PostgresQueue) claim(ctx context.Context) (*Task, error) {
+	types := make([]string, 0, len(q.handlers))
+	for taskType := range q.handlers {
+		types = append(types, taskType)
+	}
+
+	query := `
+		UPDATE task_queue
+		SET attempts = attempts + 1, visible_at = NOW() + make_interval(secs => $1)
+		WHERE id = (
+			SELECT id
+			FROM task_queue
+			WHERE dead_at IS NULL AND visible_at <= NOW() AND task_type = ANY($2)
+			ORDER BY visible_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, task_type, payload, attempts, enqueued_at
+	`
+
+	var task Task
+	var payload []byte
+	err := q.db.QueryRow(ctx, query, q.cfg.VisibilityTimeout.Seconds(), types).
+		Scan(&task.ID, &task.Type, &payload, &task.Attempts, &task.EnqueuedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	task.Payload = payload
+	return &task, nil
+}
+
+// process runs a claimed task's handler, which must finish within the visibility
+// timeout, and records the outcome
+func (q *PostgresQueue) process(ctx context.Context, task Task) {
+	handlerCtx, cancel := context.WithTimeout(ctx, q.cfg.VisibilityTimeout)
+	err := q.handlers[task.Type](handlerCtx, task)
+	cancel()
+
+	// The outcome is recorded even while shutting down, so the task is not run again
+	ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err == nil {
+		if _, err := q.db.Exec(ctx, `DELETE FROM task_queue WHERE id = $1`, task.ID); err != nil {
+			log.Printf("Failed to delete task %s: %v", task.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Task %s (%s) failed on attempt %d: %v", task.ID, task.Type, task.Attempts, err)
+
+	if task.Attempts >= q.cfg.MaxAttempts {
+		log.Printf("Task %s (%s) dead after %d attempts", task.ID, task.Type, task.Attempts)
+		_, err = q.db.Exec(ctx, `UPDATE task_queue SET dead_at = NOW(), last_error = $2 WHERE id = $1`, task.ID, err.Error())
+	} else {
+		retryIn := time.Duration(task.Attempts) * time.Second
+		_, err = q.db.Exec(ctx, `
+			UPDATE task_queue
+			SET visible_at = NOW() + make_interval(secs => $2), last_error = $3
+			WHERE id = $1`, task.ID, retryIn.Seconds(), err.Error())
+	}
+	if err != nil {
+		log.Printf("Failed to record the failure of task %s: %v", task.ID, err)
+	}
+}
@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDB returns a pool on a fresh schema of TEST_DATABASE_URL with the service's
+// migrations applied, skipping the test without one (or failing it in CI)
+func testDB(t *testing.T) *pgxpool.Pool {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgxpool.New(ctx, url)
+	require.NoError(t, err)
+	t.Cleanup(admin.Close)
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE")
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	require.NoError(t, err)
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	migrations, err := os.ReadFile("../migrations.sql")
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, string(migrations))
+	require.NoError(t, err)
+	return pool
+}
+
+func TestPostgresQueueRetriesAndBuriesTasks(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	q := NewPostgresQueue(db, PostgresConfig{Workers: 2, MaxAttempts: 2, PollInterval: 10 * time.Millisecond})
+
+	done := make(chan Task, 1)
+	var verifyCalls, webhookCalls atomic.Int32
+	q.Register(TaskVerifyPayment, func(ctx context.Context, task Task) error {
+		if verifyCalls.Add(1) == 1 {
+			return errors.New("temporary failure")
+		}
+		done <- task
+		return nil
+	})
+	q.Register(TaskProcessWebhook, func(ctx context.Context, task Task) error {
+		webhookCalls.Add(1)
+		return errors.New("permanent failure")
+	})
+
+	require.NoError(t, q.Start(ctx))
+	defer q.Close()
+
+	verify, err := NewTask(TaskVerifyPayment, "order_123")
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(ctx, verify))
+	webhook, err := NewTask(TaskProcessWebhook, map[string]string{"type": "PAYMENT_SUCCESS_WEBHOOK"})
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(ctx, webhook))
+
+	select {
+	case task := <-done:
+		assert.Equal(t, verify.ID, task.ID)
+		assert.Equal(t, `"order_123"`, string(task.Payload))
+		assert.Equal(t, 2, task.Attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not retried")
+	}
+
+	// Handled tasks are deleted; the failing one is kept as dead after its attempts
+	require.Eventually(t, func() bool {
+		var dead int
+		err := db.QueryRow(ctx, `SELECT COUNT(*) FROM task_queue WHERE id = $1 AND dead_at IS NOT NULL`, webhook.ID).Scan(&dead)
+		return err == nil && dead == 1
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, int32(2), webhookCalls.Load())
+
+	var remaining int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM task_queue`).Scan(&remaining))
+	assert.Equal(t, 1, remaining)
+	assert.Equal(t, 0, q.Backlog())
+}
+
+func TestPostgresQueueReclaimsAbandonedTasks(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	// A worker that died holding a task leaves it claimed until the timeout passes
+	task, err := NewTask(TaskVerifyPayment, "order_123")
+	require.NoError(t, err)
+	_, err = db.Exec(ctx, `
+		INSERT INTO task_queue (id, task_type, payload, attempts, enqueued_at, visible_at)
+		VALUES ($1, $2, $3, 1, NOW(), NOW() + INTERVAL '200 milliseconds')`,
+		task.ID, task.Type, []byte(task.Payload))
+	require.NoError(t, err)
+
+	q := NewPostgresQueue(db, PostgresConfig{Workers: 1, PollInterval: 10 * time.Millisecond})
+	done := make(chan int, 1)
+	q.Register(TaskVerifyPayment, func(ctx context.Context, task Task) error {
+		done <- task.Attempts
+		return nil
+	})
+	require.NoError(t, q.Start(ctx))
+	defer q.Close()
+
+	select {
+	case attempts := <-done:
+		assert.Equal(t, 2, attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("abandoned task was not claimed again")
+	}
+}
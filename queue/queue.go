@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Task types dispatched through the queue
+const (
+	TaskProcessWebhook = "webhook.process"
+	TaskVerifyPayment  = "payment.verify"
+)
+
+// DefaultMaxAttempts is how many times a task is tried before it is dropped
+const DefaultMaxAttempts = 5
+
+// Task represents a unit of asynchronous work
+type Task struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+}
+
+// NewTask creates a task with a fresh ID and the given payload encoded as JSON
+func NewTask(taskType string, payload interface{}) (Task, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return Task{}, err
+	}
+
+	return Task{
+		ID:         uuid.New().String(),
+		Type:       taskType,
+		Payload:    raw,
+		EnqueuedAt: time.Now().UTC(),
+	}, nil
+}
+
+// HandlerFunc processes a task. Returning an error schedules a retry.
+type HandlerFunc func(ctx context.Context, task Task) error
+
+// Queue dispatches tasks to registered handlers
+type Queue interface {
+	// Register sets the handler for a task type. It must be called before Start.
+	Register(taskType string, handler HandlerFunc)
+	// Enqueue submits a task for asynchronous processing
+	Enqueue(ctx context.Context, task Task) error
+	// Start begins consuming tasks until the context is cancelled
+	Start(ctx context.Context) error
+	// Close stops consuming and releases resources
+	Close() error
+}
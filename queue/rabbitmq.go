@@ -0,0 +1,234 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQConfig holds the settings for the RabbitMQ queue backend
+type RabbitMQConfig struct {
+	URL         string
+	Exchange    string
+	Queue       string
+	Prefetch    int
+	Workers     int
+	MaxAttempts int
+}
+
+// RabbitMQQueue dispatches tasks through a durable RabbitMQ queue.
+//
+// Tasks are published as persistent messages to a direct exchange using the
+// task type as routing key. Consumers acknowledge a message only after the
+// handler succeeds; failed tasks are republished with an incremented attempt
+// counter until MaxAttempts is reached, after which they are rejected to the
+// dead-letter exchange.
+type RabbitMQQueue struct {
+	cfg      RabbitMQConfig
+	conn     *amqp.Connection
+	pubCh    *amqp.Channel
+	pubMu    sync.Mutex
+	handlers map[string]HandlerFunc
+	wg       sync.WaitGroup
+}
+
+// NewRabbitMQQueue connects to RabbitMQ and declares the exchange and queues
+func NewRabbitMQQueue(cfg RabbitMQConfig) (*RabbitMQQueue, error) {
+	if cfg.Exchange == "" {
+		cfg.Exchange = "payments.tasks"
+	}
+	if cfg.Queue == "" {
+		cfg.Queue = "payments.tasks"
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = 10
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+
+	conn, err := amqp.Dial(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %v", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open RabbitMQ channel: %v", err)
+	}
+
+	q := &RabbitMQQueue{
+		cfg:      cfg,
+		conn:     conn,
+		pubCh:    ch,
+		handlers: make(map[string]HandlerFunc),
+	}
+
+	if err := q.declare(ch); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// declare sets up the task exchange, queue, and dead-letter queue
+func (q *RabbitMQQueue) declare(ch *amqp.Channel) error {
+	dlx := q.cfg.Exchange + ".dlx"
+	dlq := q.cfg.Queue + ".dead"
+
+	if err := ch.ExchangeDeclare(q.cfg.Exchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", q.cfg.Exchange, err)
+	}
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %v", dlx, err)
+	}
+
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", dlq, err)
+	}
+	if err := ch.QueueBind(dlq, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("failed to bind queue %s: %v", dlq, err)
+	}
+
+	args := amqp.Table{"x-dead-letter-exchange": dlx}
+	if _, err := ch.QueueDeclare(q.cfg.Queue, true, false, false, false, args); err != nil {
+		return fmt.Errorf("failed to declare queue %s: %v", q.cfg.Queue, err)
+	}
+
+	return nil
+}
+
+// Register sets the handler for a task type and binds its routing key
+func (q *RabbitMQQueue) Register(taskType string, handler HandlerFunc) {
+	q.handlers[taskType] = handler
+
+	q.pubMu.Lock()
+	defer q.pubMu.Unlock()
+	if err := q.pubCh.QueueBind(q.cfg.Queue, taskType, q.cfg.Exchange, false, nil); err != nil {
+		log.Printf("Failed to bind task type %s: %v", taskType, err)
+	}
+}
+
+// Enqueue publishes a task as a persistent message
+func (q *RabbitMQQueue) Enqueue(ctx context.Context, task Task) error {
+	body, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to encode task: %v", err)
+	}
+
+	q.pubMu.Lock()
+	defer q.pubMu.Unlock()
+
+	return q.pubCh.PublishWithContext(ctx, q.cfg.Exchange, task.Type, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    task.ID,
+		Type:         task.Type,
+		Body:         body,
+	})
+}
+
+// Start launches consumer workers, each on its own channel with the configured prefetch
+func (q *RabbitMQQueue) Start(ctx context.Context) error {
+	for i := 0; i < q.cfg.Workers; i++ {
+		ch, err := q.conn.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open consumer channel: %v", err)
+		}
+
+		if err := ch.Qos(q.cfg.Prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set prefetch: %v", err)
+		}
+
+		deliveries, err := ch.Consume(q.cfg.Queue, "", false, false, false, false, nil)
+		if err != nil {
+			return fmt.Errorf("failed to start consumer: %v", err)
+		}
+
+		q.wg.Add(1)
+		go q.consume(ctx, ch, deliveries)
+	}
+
+	return nil
+}
+
+// Ping reports whether the connection to the broker is open
+func (q *RabbitMQQueue) Ping() error {
+	if q.conn.IsClosed() {
+		return errors.New("connection to RabbitMQ is closed")
+	}
+	return nil
+}
+
+// Close closes the connection and waits for consumers to stop
+func (q *RabbitMQQueue) Close() error {
+	err := q.conn.Close()
+	q.wg.Wait()
+	return err
+}
+
+func (q *RabbitMQQueue) consume(ctx context.Context, ch *amqp.Channel, deliveries <-chan amqp.Delivery) {
+	defer q.wg.Done()
+	defer ch.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			q.handle(ctx, d)
+		}
+	}
+}
+
+func (q *RabbitMQQueue) handle(ctx context.Context, d amqp.Delivery) {
+	var task Task
+	if err := json.Unmarshal(d.Body, &task); err != nil {
+		log.Printf("Failed to decode task %s: %v", d.MessageId, err)
+		d.Reject(false)
+		return
+	}
+
+	handler, ok := q.handlers[task.Type]
+	if !ok {
+		log.Printf("No handler registered for task type: %s", task.Type)
+		d.Reject(false)
+		return
+	}
+
+	task.Attempts++
+	err := handler(ctx, task)
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+
+	log.Printf("Task %s (%s) failed on attempt %d: %v", task.ID, task.Type, task.Attempts, err)
+
+	if task.Attempts >= q.cfg.MaxAttempts {
+		log.Printf("Task %s (%s) dead-lettered after %d attempts", task.ID, task.Type, task.Attempts)
+		d.Reject(false)
+		return
+	}
+
+	// Republish with the updated attempt count, then ack the original
+	if err := q.Enqueue(ctx, task); err != nil {
+		log.Printf("Failed to requeue task %s: %v", task.ID, err)
+		d.Nack(false, true)
+		return
+	}
+	d.Ack(false)
+}
@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// WorkerPool is an in-process queue backed by a buffered channel.
+// Tasks are lost if the process exits before they are handled.
+type WorkerPool struct {
+	workers     int
+	maxAttempts int
+	tasks       chan Task
+	handlers    map[string]HandlerFunc
+	wg          sync.WaitGroup
+
+	// mu guards closed, so Enqueue never sends on the channel Close has closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewWorkerPool creates an in-process worker pool
+func NewWorkerPool(workers, bufferSize int) *WorkerPool {
+	if workers <= 0 {
+		workers = 4
+	}
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+
+	return &WorkerPool{
+		workers:     workers,
+		maxAttempts: DefaultMaxAttempts,
+		tasks:       make(chan Task, bufferSize),
+		handlers:    make(map[string]HandlerFunc),
+	}
+}
+
+// Register sets the handler for a task type
+func (p *WorkerPool) Register(taskType string, handler HandlerFunc) {
+	p.handlers[taskType] = handler
+}
+
+// Enqueue submits a task, failing if the buffer is full or the pool is closed
+func (p *WorkerPool) Enqueue(ctx context.Context, task Task) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return fmt.Errorf("worker pool is closed")
+	}
+
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return fmt.Errorf("worker pool queue is full")
+	}
+}
+
+// Backlog returns the number of tasks waiting for a worker
+func (p *WorkerPool) Backlog() int {
+	return len(p.tasks)
+}
+
+// Start launches the workers
+func (p *WorkerPool) Start(ctx context.Context) error {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+	return nil
+}
+
+// Close stops accepting tasks and waits for in-flight tasks to finish
+func (p *WorkerPool) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+func (p *WorkerPool) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task, ok := <-p.tasks:
+			if !ok {
+				return
+			}
+			p.process(ctx, task)
+		}
+	}
+}
+
+func (p *WorkerPool) process(ctx context.Context, task Task) {
+	handler, ok := p.handlers[task.Type]
+	if !ok {
+		log.Printf("No handler registered for task type: %s", task.Type)
+		return
+	}
+
+	for task.Attempts < p.maxAttempts {
+		task.Attempts++
+		err := handler(ctx, task)
+		if err == nil {
+			return
+		}
+
+		log.Printf("Task %s (%s) failed on attempt %d: %v", task.ID, task.Type, task.Attempts, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(task.Attempts) * time.Second):
+		}
+	}
+
+	log.Printf("Task %s (%s) dropped after %d attempts", task.ID, task.Type, task.Attempts)
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolProcessesTasks(t *testing.T) {
+	pool := NewWorkerPool(2, 10)
+
+	done := make(chan string, 1)
+	pool.Register(TaskVerifyPayment, func(ctx context.Context, task Task) error {
+		done <- string(task.Payload)
+		return nil
+	})
+
+	assert.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	task, err := NewTask(TaskVerifyPayment, "order_123")
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Enqueue(context.Background(), task))
+
+	select {
+	case payload := <-done:
+		assert.Equal(t, `"order_123"`, payload)
+	case <-time.After(2 * time.Second):
+		t.Fatal("task was not processed")
+	}
+}
+
+func TestWorkerPoolRetriesFailedTasks(t *testing.T) {
+	pool := NewWorkerPool(1, 10)
+
+	var calls int32
+	done := make(chan int, 1)
+	pool.Register(TaskProcessWebhook, func(ctx context.Context, task Task) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("temporary failure")
+		}
+		done <- task.Attempts
+		return nil
+	})
+
+	assert.NoError(t, pool.Start(context.Background()))
+	defer pool.Close()
+
+	task, _ := NewTask(TaskProcessWebhook, map[string]string{"type": "PAYMENT_SUCCESS_WEBHOOK"})
+	assert.NoError(t, pool.Enqueue(context.Background(), task))
+
+	select {
+	case attempts := <-done:
+		assert.Equal(t, 2, attempts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not retried")
+	}
+}
+
+func TestWorkerPoolEnqueueAfterClose(t *testing.T) {
+	pool := NewWorkerPool(1, 10)
+	assert.NoError(t, pool.Start(context.Background()))
+	assert.NoError(t, pool.Close())
+	assert.NoError(t, pool.Close())
+
+	task, _ := NewTask(TaskVerifyPayment, "order_123")
+	assert.Error(t, pool.Enqueue(context.Background(), task))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// errQuotaExceeded is returned when a reservation would take a merchant over its quota
+var errQuotaExceeded = errors.New("quota exceeded")
+
+// TenantQuotas enforces each merchant's daily order and refund quotas. Usage is counted
+// per business day in Postgres, so every instance of the service shares the same
+// counters. Days start at midnight in the merchant's time zone, or in location for
+// merchants without one.
+type TenantQuotas struct {
+	db       *pgxpool.Pool
+	location *time.Location
+	clock    Clock
+}
+
+func NewTenantQuotas(db *pgxpool.Pool, location *time.Location, clock Clock) *TenantQuotas {
+	return &TenantQuotas{db: db, location: location, clock: clock}
+}
+
+// quotaReleaseGinKey marks a request that answered with something it had already
+// created, which does not count against the quota
+const quotaReleaseGinKey = "quota_release"
+
+// releaseQuota tells the quota middleware that the request succeeded without creating
+// anything, such as a reused session or a repeated refund answered with the existing
+// one, so its reservation is given back
+func releaseQuota(c *gin.Context) {
+	c.Set(quotaReleaseGinKey, true)
+}
+
+// quotaDay returns the day in loc usage is counted against
+func quotaDay(now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// untilNextQuotaDay returns how long until the quotas reset at midnight in loc
+func untilNextQuotaDay(now time.Time, loc *time.Location) time.Duration {
+	return startOfDay(now, loc).AddDate(0, 0, 1).Sub(now)
+}
+
+// reserveOrder counts an order against the merchant's daily order quota for day
+func (q *TenantQuotas) reserveOrder(ctx context.Context, tenantID uuid.UUID, day time.Time, limit int) error {
+	query := `
+		INSERT INTO tenant_usage (tenant_id, day, orders, refund_amount)
+		VALUES ($1, $2, 1, 0)
+		ON CONFLICT (tenant_id, day) DO UPDATE
+		SET orders = tenant_usage.orders + 1
+		WHERE tenant_usage.orders < $3
+		RETURNING orders
+	`
+
+	var orders int
+	err := q.db.QueryRow(ctx, query, tenantID, day, limit).Scan(&orders)
+	if err == pgx.ErrNoRows {
+		return errQuotaExceeded
+	}
+	return err
+}
+
+// releaseOrder returns an order reservation for a request that did not create an order
+func (q *TenantQuotas) releaseOrder(ctx context.Context, tenantID uuid.UUID, day time.Time) error {
+	query := `
+		UPDATE tenant_usage
+		SET orders = GREATEST(orders - 1, 0)
+		WHERE tenant_id = $1 AND day = $2
+	`
+
+	_, err := q.db.Exec(ctx, query, tenantID, day)
+	return err
+}
+
+// reserveRefund counts a refund amount against the merchant's daily refund quota for day
+func (q *TenantQuotas) reserveRefund(ctx context.Context, tenantID uuid.UUID, day time.Time, amount, limit float64) error {
+	query := `
+		INSERT INTO tenant_usage (tenant_id, day, orders, refund_amount)
+		SELECT $1::uuid, $2::date, 0, $3::numeric
+		WHERE $3::numeric <= $4::numeric
+		ON CONFLICT (tenant_id, day) DO UPDATE
+		SET refund_amount = tenant_usage.refund_amount + EXCLUDED.refund_amount
+		WHERE tenant_usage.refund_amount + EXCLUDED.refund_amount <= $4::numeric
+		RETURNING refund_amount
+	`
+
+	var total float64
+	err := q.db.QueryRow(ctx, query, tenantID, day, amount, limit).Scan(&total)
+	if err == pgx.ErrNoRows {
+		return errQuotaExceeded
+	}
+	return err
+}
+
+// releaseRefund returns a refund reservation for a request that did not create a refund
+func (q *TenantQuotas) releaseRefund(ctx context.Context, tenantID uuid.UUID, day time.Time, amount float64) error {
+	query := `
+		UPDATE tenant_usage
+		SET refund_amount = GREATEST(refund_amount - $3, 0)
+		WHERE tenant_id = $1 AND day = $2
+	`
+
+	_, err := q.db.Exec(ctx, query, tenantID, day, amount)
+	return err
+}
+
+// Usage returns how much of its quotas a merchant has used today
+func (q *TenantQuotas) Usage(ctx context.Context, merchant *Merchant) (orders int, refundAmount float64, err error) {
+	query := `
+		SELECT orders, refund_amount
+		FROM tenant_usage
+		WHERE tenant_id = $1 AND day = $2
+	`
+
+	err = q.db.QueryRow(ctx, query, merchant.ID, quotaDay(clockOrSystem(q.clock).Now(), merchantLocation(merchant, q.location))).Scan(&orders, &refundAmount)
+	if err == pgx.ErrNoRows {
+		return 0, 0, nil
+	}
+	return orders, refundAmount, err
+}
+
+// OrderQuotaMiddleware rejects new orders with 429 once the merchant has created its
+// daily number of orders. Requests that fail or reuse a session do not count.
+func (q *TenantQuotas) OrderQuotaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchant := MerchantFromContext(requestContext(c))
+		if q == nil || merchant == nil || merchant.DailyOrderLimit == nil {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		loc := merchantLocation(merchant, q.location)
+		day := quotaDay(clockOrSystem(q.clock).Now(), loc)
+		if err := q.reserveOrder(ctx, merchant.ID, day, *merchant.DailyOrderLimit); err != nil {
+			if err == errQuotaExceeded {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(untilNextQuotaDay(clockOrSystem(q.clock).Now(), loc).Seconds()))))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Daily order quota exceeded"})
+				return
+			}
+			log.Printf("Failed to check order quota for merchant %s: %v", merchant.ID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check quota"})
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest || c.GetBool(quotaReleaseGinKey) {
+			if err := q.releaseOrder(context.Background(), merchant.ID, day); err != nil {
+				log.Printf("Failed to release order quota for merchant %s: %v", merchant.ID, err)
+			}
+		}
+	}
+}
+
+// RefundQuotaMiddleware rejects refunds with 403 once they would take the merchant over
+// its daily refund amount. Requests that fail or repeat an existing refund do not count.
+func (q *TenantQuotas) RefundQuotaMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchant := MerchantFromContext(requestContext(c))
+		if q == nil || merchant == nil || merchant.DailyRefundLimit == nil {
+			c.Next()
+			return
+		}
+
+		// Peek at the refund amount, leaving the body for the handler. Malformed
+		// requests are left to the handler to reject.
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req RefundRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Amount <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		day := quotaDay(clockOrSystem(q.clock).Now(), merchantLocation(merchant, q.location))
+		if err := q.reserveRefund(ctx, merchant.ID, day, req.Amount, *merchant.DailyRefundLimit); err != nil {
+			if err == errQuotaExceeded {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "Daily refund quota exceeded",
+					"limit": *merchant.DailyRefundLimit,
+				})
+				return
+			}
+			log.Printf("Failed to check refund quota for merchant %s: %v", merchant.ID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check quota"})
+			return
+		}
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest || c.GetBool(quotaReleaseGinKey) {
+			if err := q.releaseRefund(context.Background(), merchant.ID, day, req.Amount); err != nil {
+				log.Printf("Failed to release refund quota for merchant %s: %v", merchant.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaDay(t *testing.T) {
+	// 20:00 UTC is already the next day in IST
+	now := time.Date(2024, 4, 1, 20, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC), quotaDay(now, istLocation))
+	assert.Equal(t, 22*time.Hour+30*time.Minute, untilNextQuotaDay(now, istLocation))
+
+	// ...but still the same day in New York
+	newYork, err := loadTimezone("America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), quotaDay(now, newYork))
+	assert.Equal(t, 8*time.Hour, untilNextQuotaDay(now, newYork))
+}
+
+func TestMerchantRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(1, 1)
+	merchant := &Merchant{ID: uuid.New()}
+
+	r := gin.New()
+	r.GET("/payments", func(c *gin.Context) {
+		if c.GetHeader("X-API-Key") != "" {
+			c.Set(merchantGinKey, merchant)
+		}
+		c.Next()
+	}, limiter.KeyedMiddleware(merchantRateLimitKey), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/payments", nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("mk_1"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("mk_1"))
+
+	// Requests without a merchant are left to the per-IP limiter
+	assert.Equal(t, http.StatusOK, serve(""))
+	assert.Equal(t, http.StatusOK, serve(""))
+}
+
+func TestQuotaMiddlewareWithoutLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var quotas *TenantQuotas
+
+	r := gin.New()
+	r.POST("/payments/:order_id/refund", quotas.RefundQuotaMiddleware(), func(c *gin.Context) {
+		var req RefundRequest
+		require.NoError(t, c.ShouldBindJSON(&req))
+		c.JSON(http.StatusOK, req)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/order_1/refund", bytes.NewBufferString(`{"amount": 10}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTenantQuotas(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	merchants := NewMerchantRepository(db, box)
+
+	apiKey, err := NewMerchantAPIKey()
+	require.NoError(t, err)
+	orderLimit, refundLimit := 2, 100.0
+	merchant := &Merchant{
+		Name: "Quotas", CFClientID: "id", CFSecret: "secret", Environment: "TEST",
+		DailyOrderLimit: &orderLimit, DailyRefundLimit: &refundLimit,
+	}
+	require.NoError(t, merchants.CreateMerchant(ctx, merchant, apiKey))
+
+	// 20:00 UTC is 01:30 the next day in IST, 22h30m before the quotas reset
+	quotas := NewTenantQuotas(db, istLocation, newTestClock(time.Date(2024, 4, 1, 20, 0, 0, 0, time.UTC)))
+	status := http.StatusOK
+	existing := false
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(merchantGinKey, merchant)
+		c.Next()
+	})
+	r.POST("/payments/create-session", quotas.OrderQuotaMiddleware(), func(c *gin.Context) {
+		if existing {
+			releaseQuota(c)
+		}
+		c.Status(status)
+	})
+	r.POST("/payments/:order_id/refund", quotas.RefundQuotaMiddleware(), func(c *gin.Context) {
+		if existing {
+			releaseQuota(c)
+		}
+		c.Status(status)
+	})
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/payments/create-session", `{}`).Code)
+
+	// Failed requests do not use up the quota
+	status = http.StatusBadGateway
+	assert.Equal(t, http.StatusBadGateway, serve("/payments/create-session", `{}`).Code)
+	status = http.StatusOK
+
+	// Neither do reused sessions
+	existing = true
+	assert.Equal(t, http.StatusOK, serve("/payments/create-session", `{}`).Code)
+	existing = false
+
+	assert.Equal(t, http.StatusOK, serve("/payments/create-session", `{}`).Code)
+	w := serve("/payments/create-session", `{}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "81000", w.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serve("/payments/order_1/refund", `{"amount": 60}`).Code)
+	assert.Equal(t, http.StatusForbidden, serve("/payments/order_1/refund", `{"amount": 50}`).Code)
+
+	// Repeating a refund answers with the existing one, which was already counted
+	existing = true
+	assert.Equal(t, http.StatusOK, serve("/payments/order_1/refund", `{"amount": 40}`).Code)
+	existing = false
+	assert.Equal(t, http.StatusOK, serve("/payments/order_1/refund", `{"amount": 40}`).Code)
+
+	orders, refunded, err := quotas.Usage(ctx, merchant)
+	require.NoError(t, err)
+	assert.Equal(t, 2, orders)
+	assert.Equal(t, 100.0, refunded)
+}
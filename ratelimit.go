@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter is a per-client token bucket limiter whose limits can change at runtime
+type RateLimiter struct {
+	mu        sync.Mutex
+	rps       float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	seenAt time.Time
+}
+
+// rateLimitIdle is how long an unused bucket is kept
+const rateLimitIdle = 10 * time.Minute
+
+// NewRateLimiter creates a limiter allowing rps requests per second per client with the
+// given burst. A non-positive rps disables limiting.
+func NewRateLimiter(rps float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rps:     rps,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// SetLimit changes the limits; existing clients keep their remaining tokens
+func (l *RateLimiter) SetLimit(rps float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rps = rps
+	l.burst = burst
+}
+
+// Allow reports whether a request from key may proceed, and otherwise how long to wait
+func (l *RateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rps <= 0 {
+		return true, 0
+	}
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.seenAt) > rateLimitIdle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.burst), seenAt: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(l.burst), bucket.tokens+now.Sub(bucket.seenAt).Seconds()*l.rps)
+	bucket.seenAt = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rps * float64(time.Second))
+		return false, wait
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// Middleware rejects requests over the limit with 429, keyed by client IP
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return l.KeyedMiddleware(func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// KeyedMiddleware rejects requests over the limit with 429, keyed by the given function.
+// Requests with an empty key are not limited.
+func (l *RateLimiter) KeyedMiddleware(key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		allowed, wait := l.Allow(k, time.Now())
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// merchantRateLimitKey keys rate limiting by the authenticated merchant
+func merchantRateLimitKey(c *gin.Context) string {
+	if merchant := MerchantFromContext(requestContext(c)); merchant != nil {
+		return merchant.ID.String()
+	}
+	return ""
+}
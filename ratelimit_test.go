@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1, 2)
+	now := time.Now()
+
+	allowed, _ := limiter.Allow("client", now)
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("client", now)
+	assert.True(t, allowed)
+
+	allowed, wait := limiter.Allow("client", now)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// Other clients have their own bucket
+	allowed, _ = limiter.Allow("other", now)
+	assert.True(t, allowed)
+
+	// Tokens refill at the configured rate
+	allowed, _ = limiter.Allow("client", now.Add(time.Second))
+	assert.True(t, allowed)
+
+	// Disabling the limit lets every request through
+	limiter.SetLimit(0, 0)
+	allowed, _ = limiter.Allow("client", now.Add(time.Second))
+	assert.True(t, allowed)
+}
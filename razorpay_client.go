@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"payment-getway/domain"
+)
+
+const RazorpayURL = "https://api.razorpay.com/v1"
+
+// RazorpayClient implements PaymentGateway on top of Razorpay Payment Links,
+// which provide a hosted checkout page equivalent to Cashfree's payment link
+type RazorpayClient struct {
+	KeyID     string
+	KeySecret string
+	BaseURL   string
+	Client    *resty.Client
+}
+
+// NewRazorpayClient creates a new Razorpay client
+func NewRazorpayClient(keyID, keySecret string) *RazorpayClient {
+	client := resty.New()
+	client.SetTimeout(30 * time.Second)
+	client.SetRetryCount(3)
+	client.SetRetryWaitTime(5 * time.Second)
+	client.SetBasicAuth(keyID, keySecret)
+	client.SetHeader("Content-Type", "application/json")
+
+	return &RazorpayClient{
+		KeyID:     keyID,
+		KeySecret: keySecret,
+		BaseURL:   RazorpayURL,
+		Client:    client,
+	}
+}
+
+// Name returns the gateway name
+func (c *RazorpayClient) Name() string {
+	return GatewayRazorpay
+}
+
+// CreateOrder creates a payment link for the order
+func (c *RazorpayClient) CreateOrder(req CreateOrderRequest) (*CashfreeOrderResponse, error) {
+	url := fmt.Sprintf("%s/payment_links", c.BaseURL)
+
+	body := razorpayPaymentLinkRequest{
+		Amount:      toMinorUnits(req.OrderAmount, req.OrderCurrency),
+		Currency:    req.OrderCurrency,
+		ReferenceID: req.OrderID,
+		Description: req.OrderNote,
+		Customer: razorpayCustomer{
+			Name:    req.CustomerDetails.CustomerName,
+			Email:   req.CustomerDetails.CustomerEmail,
+			Contact: req.CustomerDetails.CustomerPhone,
+		},
+		Notes: req.OrderTags,
+	}
+
+	if req.OrderMeta != nil && req.OrderMeta.ReturnURL != "" {
+		body.CallbackURL = req.OrderMeta.ReturnURL
+		body.CallbackMethod = "get"
+	}
+	if req.OrderMeta != nil {
+		body.Options = razorpayMethodOptions(req.OrderMeta.PaymentMethods)
+	}
+
+	if req.OrderExpiryTime != "" {
+		if expiry, err := time.Parse(time.RFC3339, req.OrderExpiryTime); err == nil {
+			body.ExpireBy = expiry.Unix()
+		}
+	}
+
+	var link razorpayPaymentLink
+	resp, err := c.Client.R().
+		SetBody(body).
+		SetResult(&link).
+		Post(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create payment link: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return &CashfreeOrderResponse{
+		CFOrderID:   link.ID,
+		OrderID:     link.ReferenceID,
+		PaymentLink: link.ShortURL,
+		OrderStatus: razorpayOrderStatus(link.Status),
+	}, nil
+}
+
+// GetOrderStatus gets the status of the order's payment link
+func (c *RazorpayClient) GetOrderStatus(orderID string) (*CashfreeOrderStatusResponse, error) {
+	link, err := c.getPaymentLink(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CashfreeOrderStatusResponse{
+		CFOrderID:       link.ID,
+		OrderID:         link.ReferenceID,
+		OrderStatus:     razorpayOrderStatus(link.Status),
+		OrderAmount:     fromMinorUnits(link.Amount, link.Currency),
+		OrderCurrency:   link.Currency,
+		OrderExpiryTime: time.Unix(link.ExpireBy, 0),
+		PaymentLink:     link.ShortURL,
+	}, nil
+}
+
+// GetPayments gets the successful payment for the order
+func (c *RazorpayClient) GetPayments(orderID string) (*CashfreePaymentResponse, error) {
+	link, payment, err := c.capturedPayment(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CashfreePaymentResponse{
+		CFOrderID:     link.ID,
+		OrderID:       link.ReferenceID,
+		CFPaymentID:   payment.PaymentID,
+		PaymentStatus: "SUCCESS",
+		PaymentAmount: fromMinorUnits(payment.Amount, link.Currency),
+		PaymentTime:   time.Unix(payment.CreatedAt, 0),
+		PaymentMethod: payment.Method,
+	}, nil
+}
+
+// capturedPayment gets the order's payment link and its captured payment
+func (c *RazorpayClient) capturedPayment(orderID string) (*razorpayPaymentLink, *razorpayPaymentLinkPayment, error) {
+	link, err := c.getPaymentLink(orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range link.Payments {
+		if link.Payments[i].Status == "captured" {
+			return link, &link.Payments[i], nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("no payments found for order %s", orderID)
+}
+
+// RefundPayment refunds the order's captured payment
+func (c *RazorpayClient) RefundPayment(req CashfreeRefundRequest) (*CashfreeRefundResponse, error) {
+	if len(req.RefundSplits) > 0 {
+		return nil, fmt.Errorf("%w: razorpay refunds do not support refund splits", ErrInvalidRequest)
+	}
+
+	link, payment, err := c.capturedPayment(req.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotSent, err)
+	}
+
+	url := fmt.Sprintf("%s/payments/%s/refund", c.BaseURL, payment.PaymentID)
+
+	body := map[string]interface{}{
+		"amount":  toMinorUnits(req.RefundAmount, link.Currency),
+		"receipt": req.RefundID,
+		"notes":   map[string]string{"reason": req.RefundNote},
+	}
+	if req.RefundSpeed == RefundSpeedInstant {
+		body["speed"] = "optimum"
+	}
+
+	var refund razorpayRefund
+	resp, err := c.Client.R().
+		SetBody(body).
+		SetResult(&refund).
+		Post(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create refund: %v", err)
+	}
+
+	if resp.StatusCode() >= 400 && resp.StatusCode() < 500 {
+		return nil, fmt.Errorf("%w: razorpay API returned status %d: %s", ErrInvalidRequest, resp.StatusCode(), resp.String())
+	}
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	response := refund.response(req.OrderID, req.RefundID, link.Currency)
+	response.RefundNote = req.RefundNote
+	return response, nil
+}
+
+// GetRefundStatus looks up the refund of the order's captured payment made with refundID
+// as its receipt, returning ErrRefundNotFound when Razorpay has none
+func (c *RazorpayClient) GetRefundStatus(orderID, refundID string) (*CashfreeRefundResponse, error) {
+	link, payment, err := c.capturedPayment(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/payments/%s/refunds", c.BaseURL, payment.PaymentID)
+
+	var result struct {
+		Items []razorpayRefund `json:"items"`
+	}
+	resp, err := c.Client.R().
+		SetQueryParam("count", "100").
+		SetResult(&result).
+		Get(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunds: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	for i := range result.Items {
+		if result.Items[i].Receipt == refundID {
+			return result.Items[i].response(orderID, refundID, link.Currency), nil
+		}
+	}
+	return nil, ErrRefundNotFound
+}
+
+// CancelOrder cancels the order's payment link
+func (c *RazorpayClient) CancelOrder(orderID string) error {
+	link, err := c.getPaymentLink(orderID)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/payment_links/%s/cancel", c.BaseURL, link.ID)
+
+	resp, err := c.Client.R().Post(url)
+	if err != nil {
+		return fmt.Errorf("failed to cancel payment link: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return nil
+}
+
+// UpdateOrderTags replaces the notes of the order's payment link
+func (c *RazorpayClient) UpdateOrderTags(orderID string, tags map[string]string) error {
+	link, err := c.getPaymentLink(orderID)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/payment_links/%s", c.BaseURL, link.ID)
+
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	resp, err := c.Client.R().
+		SetBody(map[string]interface{}{"notes": tags}).
+		Patch(url)
+	if err != nil {
+		return fmt.Errorf("failed to update payment link: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return nil
+}
+
+// getPaymentLink looks up the payment link created for our order ID
+func (c *RazorpayClient) getPaymentLink(orderID string) (*razorpayPaymentLink, error) {
+	url := fmt.Sprintf("%s/payment_links", c.BaseURL)
+
+	var result struct {
+		PaymentLinks []razorpayPaymentLink `json:"payment_links"`
+	}
+	resp, err := c.Client.R().
+		SetQueryParam("reference_id", orderID).
+		SetResult(&result).
+		Get(url)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment link: %v", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("razorpay API returned status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	if len(result.PaymentLinks) == 0 {
+		return nil, fmt.Errorf("no payment link found for order %s", orderID)
+	}
+
+	return &result.PaymentLinks[0], nil
+}
+
+// razorpayOrderStatus maps payment link statuses to Cashfree order statuses
+func razorpayOrderStatus(status string) domain.PaymentStatus {
+	switch status {
+	case "paid":
+		return domain.PaymentPaid
+	case "expired":
+		return domain.PaymentExpired
+	case "cancelled":
+		return domain.PaymentCancelled
+	default:
+		return domain.PaymentActive
+	}
+}
+
+// razorpayRefundMode maps the speed Razorpay processed a refund at to a refund speed
+func razorpayRefundMode(speed string) string {
+	switch speed {
+	case "instant":
+		return RefundSpeedInstant
+	case "normal":
+		return RefundSpeedStandard
+	default:
+		return ""
+	}
+}
+
+// razorpayRefundStatus maps refund statuses to Cashfree refund statuses
+func razorpayRefundStatus(status string) domain.RefundStatus {
+	switch status {
+	case "processed":
+		return domain.RefundSuccess
+	case "failed":
+		return domain.RefundCancelled
+	default:
+		return domain.RefundPending
+	}
+}
+
+type razorpayPaymentLinkRequest struct {
+	Amount         int64             `json:"amount"`
+	Currency       string            `json:"currency"`
+	ReferenceID    string            `json:"reference_id"`
+	Description    string            `json:"description,omitempty"`
+	Customer       razorpayCustomer  `json:"customer"`
+	CallbackURL    string            `json:"callback_url,omitempty"`
+	CallbackMethod string            `json:"callback_method,omitempty"`
+	ExpireBy       int64             `json:"expire_by,omitempty"`
+	Notes          map[string]string `json:"notes,omitempty"`
+	Options        *razorpayOptions  `json:"options,omitempty"`
+}
+
+// razorpayOptions customizes a payment link's checkout
+type razorpayOptions struct {
+	Checkout razorpayCheckoutOptions `json:"checkout"`
+}
+
+type razorpayCheckoutOptions struct {
+	Method map[string]string `json:"method"` // "1" offers a method, "0" hides it
+}
+
+type razorpayCustomer struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Contact string `json:"contact"`
+}
+
+type razorpayPaymentLink struct {
+	ID          string                       `json:"id"`
+	ReferenceID string                       `json:"reference_id"`
+	Amount      int64                        `json:"amount"`
+	Currency    string                       `json:"currency"`
+	Status      string                       `json:"status"`
+	ShortURL    string                       `json:"short_url"`
+	ExpireBy    int64                        `json:"expire_by"`
+	Payments    []razorpayPaymentLinkPayment `json:"payments"`
+}
+
+type razorpayPaymentLinkPayment struct {
+	PaymentID string `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+	Status    string `json:"status"`
+	Method    string `json:"method"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type razorpayRefund struct {
+	ID             string `json:"id"`
+	Amount         int64  `json:"amount"`
+	Receipt        string `json:"receipt"`
+	Status         string `json:"status"`
+	SpeedProcessed string `json:"speed_processed"` // "instant" or "normal"
+	AcquirerData   struct {
+		ARN string `json:"arn"`
+	} `json:"acquirer_data"`
+}
+
+// response converts a Razorpay refund of an order's payment to a refund response
+func (r *razorpayRefund) response(orderID, refundID, currency string) *CashfreeRefundResponse {
+	return &CashfreeRefundResponse{
+		CFRefundID:   r.ID,
+		RefundID:     refundID,
+		OrderID:      orderID,
+		RefundAmount: fromMinorUnits(r.Amount, currency),
+		RefundStatus: razorpayRefundStatus(r.Status),
+		RefundMode:   razorpayRefundMode(r.SpeedProcessed),
+		RefundARN:    r.AcquirerData.ARN,
+	}
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+)
+
+// Reconciliation run statuses
+const (
+	ReconRunning   = "RUNNING"
+	ReconCompleted = "COMPLETED"
+	ReconFailed    = "FAILED"
+)
+
+// Reconciliation run types: payments against Cashfree orders, or Cashfree settlements
+// against bank statement credits
+const (
+	ReconTypePayments    = "PAYMENTS"
+	ReconTypeSettlements = "SETTLEMENTS"
+)
+
+// Kinds of reconciliation item
+const (
+	ReconMissingLocal   = "MISSING_LOCAL"  // Cashfree took a payment for an order with no local record
+	ReconMissingRemote  = "MISSING_REMOTE" // Cashfree does not know a local order
+	ReconStatusMismatch = "STATUS_MISMATCH"
+	ReconAmountMismatch = "AMOUNT_MISMATCH"
+	ReconUnsettled      = "UNSETTLED"     // no bank credit carries the settlement's UTR
+	ReconShortSettled   = "SHORT_SETTLED" // the bank credited less than Cashfree settled
+	ReconNetMismatch    = "NET_MISMATCH"  // Cashfree settled other than the payment less its gateway charges
+)
+
+// ReconHandler reconciles local payments against Cashfree and serves the results
+type ReconHandler struct {
+	repo      *PaymentRepository
+	cashfree  func(ctx context.Context) (*CashfreeClient, error) // client for the request's merchant and environment
+	merchants *MerchantRepository
+	location  *time.Location
+	clock     Clock
+}
+
+// now returns the time reconciliation periods are computed from
+func (h *ReconHandler) now() time.Time {
+	return clockOrSystem(h.clock).Now()
+}
+
+// locationFor returns the time zone of the business day for the request's merchant
+func (h *ReconHandler) locationFor(ctx context.Context) *time.Location {
+	return reportLocation(ctx, h.location)
+}
+
+// Reconcile compares the orders created in [from, to) and the payments Cashfree
+// reconciled in that period with local records, and saves the differences as a run
+func (h *ReconHandler) Reconcile(ctx context.Context, from, to time.Time) (*ReconRun, error) {
+	run, client, err := h.startRun(ctx, ReconTypePayments, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return run, h.finishRun(ctx, client, run)
+}
+
+// ReconcileSettlements matches the UTRs of the settlements made in [from, to) against
+// imported bank statement credits, and saves the unsettled and short-settled ones as a run
+func (h *ReconHandler) ReconcileSettlements(ctx context.Context, from, to time.Time) (*ReconRun, error) {
+	run, client, err := h.startRun(ctx, ReconTypeSettlements, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return run, h.finishRun(ctx, client, run)
+}
+
+// startRun records a new run of runType for the Cashfree client of ctx
+func (h *ReconHandler) startRun(ctx context.Context, runType string, from, to time.Time) (*ReconRun, *CashfreeClient, error) {
+	client, err := h.cashfree(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	run := &ReconRun{
+		Type:        runType,
+		Environment: strings.ToUpper(client.Environment),
+		PeriodStart: from,
+		PeriodEnd:   to,
+	}
+	if err := h.repo.CreateReconRun(ctx, run); err != nil {
+		return nil, nil, fmt.Errorf("failed to create recon run: %v", err)
+	}
+	return run, client.ForBatch(), nil
+}
+
+// finishRun diffs the run's period and saves the outcome, including a failure
+func (h *ReconHandler) finishRun(ctx context.Context, client *CashfreeClient, run *ReconRun) error {
+	var items []ReconItem
+	var err error
+	if run.Type == ReconTypeSettlements {
+		items, run.SettlementsChecked, err = h.diffSettlements(ctx, run)
+	} else {
+		items, run.OrdersChecked, err = h.diff(ctx, client, run)
+	}
+	run.Items = items
+	run.Status = ReconCompleted
+	if err != nil {
+		message := err.Error()
+		run.Error = &message
+		run.Status = ReconFailed
+	}
+
+	// The outcome is saved even when the diff ran out of time
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if saveErr := h.repo.FinishReconRun(saveCtx, run); saveErr != nil {
+		return fmt.Errorf("failed to save recon run %s: %v", run.ID, saveErr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("recon run %s failed: %w", run.ID, err)
+	}
+	log.Printf("Recon run %s: %d orders and %d settlements checked, %d mismatches",
+		run.ID, run.OrdersChecked, run.SettlementsChecked, run.Mismatches)
+	return nil
+}
+
+// diff returns the orders local records and Cashfree disagree on, and how many local
+// orders were checked
+func (h *ReconHandler) diff(ctx context.Context, client *CashfreeClient, run *ReconRun) ([]ReconItem, int, error) {
+	payments, err := h.repo.ListPaymentsCreatedBetween(ctx, run.PeriodStart, run.PeriodEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get payments: %v", err)
+	}
+
+	var items []ReconItem
+	local := make(map[string]bool)
+	for _, payment := range payments {
+		if !reconciledIn(payment, run.Environment) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return items, len(local), err
+		}
+		local[payment.OrderID] = true
+
+		remote, err := client.GetOrderStatus(payment.OrderID)
+		if errors.Is(err, ErrOrderNotFound) {
+			items = append(items, ReconItem{
+				OrderID:     payment.OrderID,
+				Kind:        ReconMissingRemote,
+				LocalStatus: statusOf(payment.Status),
+				LocalAmount: &payment.Amount,
+			})
+			continue
+		}
+		if err != nil {
+			return items, len(local), fmt.Errorf("failed to get order status for %s: %w", payment.OrderID, err)
+		}
+		items = append(items, compareOrder(payment, remote)...)
+	}
+
+	// Payments Cashfree took for orders that are not among the local ones. They may
+	// still have a local record created before the period.
+	var remoteOnly []CashfreeReconEvent
+	seen := make(map[string]bool)
+	cursor := ""
+	for {
+		page, err := client.GetReconEvents(run.PeriodStart, run.PeriodEnd, cursor)
+		if err != nil {
+			return items, len(local), err
+		}
+		for _, event := range page.Data {
+			if event.EventType != "PAYMENT" || event.EventStatus != "SUCCESS" || local[event.OrderID] || seen[event.OrderID] {
+				continue
+			}
+			seen[event.OrderID] = true
+			remoteOnly = append(remoteOnly, event)
+		}
+		if page.Cursor == "" || len(page.Data) == 0 {
+			break
+		}
+		cursor = page.Cursor
+	}
+
+	if len(remoteOnly) > 0 {
+		orderIDs := make([]string, len(remoteOnly))
+		for i, event := range remoteOnly {
+			orderIDs[i] = event.OrderID
+		}
+		existing, err := h.repo.ExistingOrderIDs(ctx, orderIDs)
+		if err != nil {
+			return items, len(local), fmt.Errorf("failed to look up orders: %v", err)
+		}
+
+		for _, event := range remoteOnly {
+			if existing[event.OrderID] {
+				continue
+			}
+			amount := event.OrderAmount
+			items = append(items, ReconItem{
+				OrderID:      event.OrderID,
+				Kind:         ReconMissingLocal,
+				RemoteStatus: statusOf(domain.PaymentPaid),
+				RemoteAmount: &amount,
+			})
+		}
+	}
+
+	return items, len(local), nil
+}
+
+// diffSettlements returns the UTRs the bank credited less than Cashfree settled, or not
+// at all, the orders Cashfree settled other than their recorded net amount, and how
+// many settlements were checked. Settlements are grouped by UTR, as Cashfree pays out
+// many orders in one transfer, and items carry the gateway charges that explain the
+// difference between what the customers paid and what was settled.
+func (h *ReconHandler) diffSettlements(ctx context.Context, run *ReconRun) ([]ReconItem, int, error) {
+	settlements, err := h.repo.ListSettledSettlementsBetween(ctx, run.PeriodStart, run.PeriodEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get settlements: %v", err)
+	}
+	if len(settlements) == 0 {
+		return nil, 0, nil
+	}
+
+	var orderIDs []string
+	settled := make(map[string]float64)
+	for _, settlement := range settlements {
+		if _, ok := settled[settlement.OrderID]; !ok {
+			orderIDs = append(orderIDs, settlement.OrderID)
+		}
+		settled[settlement.OrderID] += settlement.Amount
+	}
+	charges, err := h.repo.GatewayChargesByOrder(ctx, orderIDs)
+	if err != nil {
+		return nil, len(settlements), fmt.Errorf("failed to get gateway charges: %v", err)
+	}
+	chargesOf := func(orderID string) *float64 {
+		c, ok := charges[orderID]
+		if !ok {
+			return nil
+		}
+		total := c.Fee + c.Tax
+		return &total
+	}
+
+	var items []ReconItem
+	for _, orderID := range orderIDs {
+		c, ok := charges[orderID]
+		amount := settled[orderID]
+		if ok && math.Abs(amount-c.Net) >= 0.0005 {
+			net := c.Net
+			items = append(items, ReconItem{
+				OrderID:        orderID,
+				Kind:           ReconNetMismatch,
+				LocalAmount:    &net,
+				RemoteAmount:   &amount,
+				GatewayCharges: chargesOf(orderID),
+			})
+		}
+	}
+
+	var utrs []string
+	expected := make(map[string]float64)
+	utrCharges := make(map[string]*float64)
+	for _, settlement := range settlements {
+		if settlement.UTR == nil || *settlement.UTR == "" {
+			items = append(items, ReconItem{
+				OrderID:        settlement.OrderID,
+				Kind:           ReconUnsettled,
+				LocalStatus:    statusOf(settlement.Status),
+				LocalAmount:    &settlement.Amount,
+				GatewayCharges: chargesOf(settlement.OrderID),
+			})
+			continue
+		}
+		utr := *settlement.UTR
+		if _, ok := expected[utr]; !ok {
+			utrs = append(utrs, utr)
+		}
+		expected[utr] += settlement.Amount
+		if orderCharges := chargesOf(settlement.OrderID); orderCharges != nil {
+			if utrCharges[utr] == nil {
+				utrCharges[utr] = new(float64)
+			}
+			*utrCharges[utr] += *orderCharges
+		}
+	}
+	if len(utrs) == 0 {
+		return items, len(settlements), nil
+	}
+
+	credits, err := h.repo.BankCreditsByUTR(ctx, utrs)
+	if err != nil {
+		return items, len(settlements), fmt.Errorf("failed to get bank credits: %v", err)
+	}
+	for _, utr := range utrs {
+		amount := expected[utr]
+		credited, ok := credits[utr]
+		switch {
+		case !ok:
+			items = append(items, ReconItem{
+				UTR:            &utr,
+				Kind:           ReconUnsettled,
+				LocalAmount:    &amount,
+				GatewayCharges: utrCharges[utr],
+			})
+		case amount-credited >= 0.0005:
+			items = append(items, ReconItem{
+				UTR:            &utr,
+				Kind:           ReconShortSettled,
+				LocalAmount:    &amount,
+				RemoteAmount:   &credited,
+				GatewayCharges: utrCharges[utr],
+			})
+		}
+	}
+	return items, len(settlements), nil
+}
+
+// reconciledIn reports whether a payment belongs to the Cashfree environment env
+func reconciledIn(payment Payment, env string) bool {
+	if payment.Gateway != "" && payment.Gateway != GatewayCashfree {
+		return false
+	}
+	return payment.Environment == nil || strings.ToUpper(*payment.Environment) == env
+}
+
+// compareOrder returns the differences between a local payment and its Cashfree order
+func compareOrder(payment Payment, remote *CashfreeOrderStatusResponse) []ReconItem {
+	var items []ReconItem
+	if reconStatus(payment.Status) != reconStatus(remote.OrderStatus) {
+		items = append(items, ReconItem{
+			OrderID:      payment.OrderID,
+			Kind:         ReconStatusMismatch,
+			LocalStatus:  statusOf(payment.Status),
+			RemoteStatus: statusOf(remote.OrderStatus),
+		})
+	}
+	if math.Abs(payment.Amount-remote.OrderAmount) >= 0.0005 {
+		items = append(items, ReconItem{
+			OrderID:      payment.OrderID,
+			Kind:         ReconAmountMismatch,
+			LocalAmount:  &payment.Amount,
+			RemoteAmount: &remote.OrderAmount,
+		})
+	}
+	return items
+}
+
+// reconStatus maps local payment statuses and Cashfree order statuses to the order
+// status they mean. A failed attempt leaves the order payable.
+func reconStatus(status domain.PaymentStatus) domain.PaymentStatus {
+	switch status = domain.PaymentStatus(strings.ToUpper(string(status))); status {
+	case domain.PaymentCreated, domain.PaymentActive, domain.PaymentFailed, "PENDING":
+		return domain.PaymentActive
+	case domain.PaymentSuccess, domain.PaymentPaid:
+		return domain.PaymentPaid
+	case domain.PaymentCancelled, domain.PaymentTerminated, domain.PaymentTerminationRequested:
+		return domain.PaymentTerminated
+	default:
+		return status
+	}
+}
+
+// statusOf returns a pointer to a status as a string, for ReconItem
+func statusOf[T ~string](status T) *string {
+	s := string(status)
+	return &s
+}
+
+// DailyReconciliationJob reconciles the previous day, with days starting at midnight
+// in loc
+func (h *ReconHandler) DailyReconciliationJob(hour int, loc *time.Location) Job {
+	return Job{
+		Name:     "reconciliation",
+		Schedule: DailyAt(hour, 0, loc),
+		Run: func(ctx context.Context) error {
+			to := startOfDay(h.now(), loc)
+			_, err := h.Reconcile(ctx, to.AddDate(0, 0, -1), to)
+			return err
+		},
+	}
+}
+
+// MerchantReconciliationJob reconciles each active merchant's previous business day at
+// the given hour of the day in the merchant's time zone. It runs hourly so that every
+// time zone is covered.
+func (h *ReconHandler) MerchantReconciliationJob(hour int) Job {
+	return Job{
+		Name:     "merchant_reconciliation",
+		Schedule: Hourly(),
+		Run: func(ctx context.Context) error {
+			merchants, err := h.merchants.ListMerchants(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list merchants: %v", err)
+			}
+
+			now := h.now()
+			var failed []string
+			for _, merchant := range merchants {
+				merchantCtx := WithMerchant(ctx, merchant)
+				loc := h.locationFor(merchantCtx)
+				if !merchant.Active || now.In(loc).Hour() != hour {
+					continue
+				}
+
+				to := startOfDay(now, loc)
+				if _, err := h.Reconcile(merchantCtx, to.AddDate(0, 0, -1), to); err != nil {
+					log.Printf("Failed to reconcile merchant %s: %v", merchant.ID, err)
+					failed = append(failed, merchant.ID.String())
+				}
+			}
+
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to reconcile %d merchants", len(failed))
+			}
+			return nil
+		},
+	}
+}
+
+// Starts a reconciliation of a date range in the background
+func (h *ReconHandler) CreateRun(c *gin.Context) {
+	ctx := requestContext(c)
+	from, to, err := parseDateRange(c, h.locationFor(ctx))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	runType := strings.ToUpper(c.DefaultQuery("type", ReconTypePayments))
+	if runType != ReconTypePayments && runType != ReconTypeSettlements {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be payments or settlements"})
+		return
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	run, client, err := h.startRun(startCtx, runType, from, to)
+	if err != nil {
+		log.Printf("Failed to start reconciliation: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start reconciliation"})
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Minute)
+		defer cancel()
+		if err := h.finishRun(ctx, client, run); err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, run)
+}
+
+// Lists reconciliation runs, newest first
+func (h *ReconHandler) ListRuns(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	runs, err := h.repo.ListReconRuns(ctx, limit, offset)
+	if err != nil {
+		log.Printf("Failed to get recon runs: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve recon runs"})
+		return
+	}
+
+	setEnvelope(c, runs, &EnvelopeMeta{Pagination: &Pagination{Limit: limit, Offset: offset, Count: len(runs)}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs":   runs,
+		"limit":  limit,
+		"offset": offset,
+		"count":  len(runs),
+	})
+}
+
+// Gets a reconciliation run with the orders it found mismatched
+func (h *ReconHandler) GetRun(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("run_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recon run ID"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	run, err := h.repo.GetReconRun(ctx, id)
+	if err != nil {
+		log.Printf("Failed to get recon run: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recon run not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+// registerReconRoutes registers the reconciliation routes on a merchant-authenticated group
+func registerReconRoutes(group *gin.RouterGroup, reconHandler *ReconHandler) {
+	// Start a reconciliation of ?from=YYYY-MM-DD&to=YYYY-MM-DD, of payments or of
+	// settlements with ?type=settlements
+	group.POST("/recon/runs", reconHandler.CreateRun)
+
+	// Import the credits of a CSV or MT940 bank statement
+	group.POST("/recon/bank-statements", reconHandler.ImportBankStatement)
+
+	// List reconciliation runs
+	group.GET("/recon/runs", reconHandler.ListRuns)
+
+	// Import orders, payments and refunds from Cashfree for ?from=YYYY-MM-DD&to=YYYY-MM-DD
+	group.POST("/backfill", reconHandler.Backfill)
+
+	// Get a reconciliation run and its mismatched orders
+	group.GET("/recon/runs/:run_id", reconHandler.GetRun)
+}
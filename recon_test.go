@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/cashfreetest"
+	"payment-getway/domain"
+)
+
+func TestReconStatus(t *testing.T) {
+	tests := []struct {
+		local, remote domain.PaymentStatus
+		same          bool
+	}{
+		{"CREATED", "ACTIVE", true},
+		{"FAILED", "ACTIVE", true},
+		{"SUCCESS", "PAID", true},
+		{"PAID", "PAID", true},
+		{"CANCELLED", "TERMINATED", true},
+		{"ACTIVE", "PAID", false},
+		{"PAID", "EXPIRED", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.same, reconStatus(tt.local) == reconStatus(tt.remote), "%s vs %s", tt.local, tt.remote)
+	}
+}
+
+func TestCompareOrder(t *testing.T) {
+	payment := *testPayment("order_1")
+	payment.Status = "SUCCESS"
+
+	assert.Empty(t, compareOrder(payment, &CashfreeOrderStatusResponse{OrderStatus: "PAID", OrderAmount: 100}))
+
+	items := compareOrder(payment, &CashfreeOrderStatusResponse{OrderStatus: "ACTIVE", OrderAmount: 99.99})
+	require.Len(t, items, 2)
+	assert.Equal(t, ReconStatusMismatch, items[0].Kind)
+	assert.Equal(t, "SUCCESS", *items[0].LocalStatus)
+	assert.Equal(t, "ACTIVE", *items[0].RemoteStatus)
+	assert.Equal(t, ReconAmountMismatch, items[1].Kind)
+	assert.Equal(t, 100.0, *items[1].LocalAmount)
+	assert.Equal(t, 99.99, *items[1].RemoteAmount)
+}
+
+func TestCashfreeClientReconEvents(t *testing.T) {
+	server, client := newFakeCashfree(t)
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return now }
+
+	// One more payment than fits on a page
+	for i := 0; i < 101; i++ {
+		orderID := fmt.Sprintf("order_%03d", i)
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+		_, err = server.CompletePayment(orderID, "upi")
+		require.NoError(t, err)
+	}
+	_, err := client.CreateOrder(testOrderRequest("order_unpaid"))
+	require.NoError(t, err)
+
+	from, to := now.Add(-time.Hour), now.Add(time.Hour)
+	page, err := client.GetReconEvents(from, to, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Data, 100)
+	require.NotEmpty(t, page.Cursor)
+	assert.Equal(t, "PAYMENT", page.Data[0].EventType)
+	assert.Equal(t, "SUCCESS", page.Data[0].EventStatus)
+	assert.Equal(t, 499.5, page.Data[0].OrderAmount)
+
+	page, err = client.GetReconEvents(from, to, page.Cursor)
+	require.NoError(t, err)
+	assert.Len(t, page.Data, 1)
+	assert.Empty(t, page.Cursor)
+
+	page, err = client.GetReconEvents(to, to.Add(time.Hour), "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Data)
+}
+
+// newTestReconHandler returns a recon handler on a test database, reconciling against
+// the fake Cashfree server
+func newTestReconHandler(t *testing.T, now time.Time) (*ReconHandler, *cashfreetest.Server, *CashfreeClient) {
+	server, client := newFakeCashfree(t)
+	clock := newTestClock(now)
+	server.Now = clock.Now
+
+	repo := NewPaymentRepository(testDB(t))
+	repo.clock = clock
+	return &ReconHandler{
+		repo:     repo,
+		cashfree: func(context.Context) (*CashfreeClient, error) { return client, nil },
+		location: time.UTC,
+		clock:    clock,
+	}, server, client
+}
+
+func TestReconcile(t *testing.T) {
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	h, server, client := newTestReconHandler(t, now)
+	ctx := context.Background()
+
+	for _, orderID := range []string{"order_match", "order_status", "order_amount", "order_remote"} {
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+	}
+	for _, orderID := range []string{"order_status", "order_remote"} {
+		_, err := server.CompletePayment(orderID, "upi")
+		require.NoError(t, err)
+	}
+	for _, orderID := range []string{"order_match", "order_status", "order_amount", "order_local"} {
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		if orderID == "order_amount" {
+			payment.Amount = 500
+		}
+		require.NoError(t, h.repo.CreatePayment(ctx, payment))
+	}
+
+	run, err := h.Reconcile(ctx, now.Add(-time.Hour), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, ReconCompleted, run.Status)
+	assert.Equal(t, 4, run.OrdersChecked)
+	assert.Equal(t, 4, run.Mismatches)
+
+	stored, err := h.repo.GetReconRun(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReconCompleted, stored.Status)
+	assert.Equal(t, "TEST", stored.Environment)
+	require.NotNil(t, stored.FinishedAt)
+	kinds := make(map[string]string)
+	for _, item := range stored.Items {
+		kinds[item.OrderID] = item.Kind
+	}
+	assert.Equal(t, map[string]string{
+		"order_status": ReconStatusMismatch,
+		"order_amount": ReconAmountMismatch,
+		"order_local":  ReconMissingRemote,
+		"order_remote": ReconMissingLocal,
+	}, kinds)
+
+	// A failing gateway fails the run, which is still saved
+	server.FailNext(http.StatusInternalServerError)
+	run, err = h.Reconcile(ctx, now.Add(-time.Hour), now.Add(time.Hour))
+	require.Error(t, err)
+	stored, err = h.repo.GetReconRun(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReconFailed, stored.Status)
+	require.NotNil(t, stored.Error)
+	assert.Contains(t, *stored.Error, "status 500")
+}
+
+func TestReconRunsAPI(t *testing.T) {
+	now := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	h, _, client := newTestReconHandler(t, now)
+	_, err := client.CreateOrder(testOrderRequest("order_remote"))
+	require.NoError(t, err)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerReconRoutes(r.Group(""), h)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := serve(http.MethodPost, "/recon/runs?from=2024-04-01&to=2024-04-01")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var created ReconRun
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, ReconRunning, created.Status)
+	assert.True(t, created.PeriodStart.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, created.PeriodEnd.Equal(time.Date(2024, 4, 2, 0, 0, 0, 0, time.UTC)))
+
+	var run ReconRun
+	require.Eventually(t, func() bool {
+		w := serve(http.MethodGet, "/recon/runs/"+created.ID.String())
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &run))
+		return run.Status != ReconRunning
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, ReconCompleted, run.Status)
+	assert.Zero(t, run.Mismatches)
+
+	var list struct {
+		Runs  []ReconRun `json:"runs"`
+		Count int        `json:"count"`
+	}
+	w = serve(http.MethodGet, "/recon/runs")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, 1, list.Count)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/recon/runs?from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/recon/runs/not-a-uuid").Code)
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/recon/runs/"+uuid.NewString()).Code)
+}
+
+func TestReconcileSettlements(t *testing.T) {
+	now := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	h, _, _ := newTestReconHandler(t, now)
+	ctx := context.Background()
+
+	settledAt := now.Add(-2 * time.Hour)
+	settle := func(settlementID, orderID string, amount float64, utr string) {
+		settlement := &Settlement{SettlementID: settlementID, OrderID: orderID, Amount: amount, Status: "SUCCESS", SettledAt: &settledAt}
+		if utr != "" {
+			settlement.UTR = &utr
+		}
+		require.NoError(t, h.repo.RecordSettlement(ctx, settlement))
+	}
+	// UTR_FULL pays out two orders in one transfer
+	settle("settlement_1", "order_1", 98, "UTR_FULL")
+	settle("settlement_2", "order_2", 49, "UTR_FULL")
+	settle("settlement_3", "order_3", 200, "UTR_SHORT")
+	settle("settlement_4", "order_4", 75, "UTR_MISSING")
+	settle("settlement_5", "order_5", 10, "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerReconRoutes(r.Group(""), h)
+	upload := func(statement string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("file", "statement.csv")
+		require.NoError(t, err)
+		_, err = file.Write([]byte(statement))
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/recon/bank-statements", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	statement := "utr,amount,value_date\nUTR_FULL,147,2024-04-02\nUTR_SHORT,180,2024-04-02\nUTR_OTHER,5000,2024-04-02\n"
+	w := upload(statement)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"credits": 3, "imported": 3, "skipped": 0}`, w.Body.String())
+
+	// Importing the same statement again adds nothing
+	w = upload(statement)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"credits": 3, "imported": 0, "skipped": 3}`, w.Body.String())
+
+	assert.Equal(t, http.StatusUnprocessableEntity, upload("utr,amount\n").Code)
+
+	run, err := h.ReconcileSettlements(ctx, now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	assert.Equal(t, ReconTypeSettlements, run.Type)
+	assert.Equal(t, 5, run.SettlementsChecked)
+	assert.Equal(t, 3, run.Mismatches)
+
+	stored, err := h.repo.GetReconRun(ctx, run.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReconTypeSettlements, stored.Type)
+	require.Len(t, stored.Items, 3)
+	for _, item := range stored.Items {
+		switch {
+		case item.Kind == ReconShortSettled:
+			assert.Equal(t, "UTR_SHORT", *item.UTR)
+			assert.Equal(t, 200.0, *item.LocalAmount)
+			assert.Equal(t, 180.0, *item.RemoteAmount)
+		case item.Kind == ReconUnsettled && item.UTR != nil:
+			assert.Equal(t, "UTR_MISSING", *item.UTR)
+			assert.Equal(t, 75.0, *item.LocalAmount)
+		default:
+			assert.Equal(t, ReconUnsettled, item.Kind)
+			assert.Equal(t, "order_5", item.OrderID)
+		}
+	}
+}
+
+func TestReconcileSettlementCharges(t *testing.T) {
+	now := time.Date(2024, 4, 2, 10, 0, 0, 0, time.UTC)
+	h, _, _ := newTestReconHandler(t, now)
+	ctx := context.Background()
+
+	for _, orderID := range []string{"order_1", "order_2"} {
+		payment := testPayment(orderID)
+		payment.Amount = 500
+		require.NoError(t, h.repo.CreatePayment(ctx, payment))
+		require.NoError(t, h.repo.SetPaymentCharges(ctx, orderID, 9.5, 1.71, 488.79))
+	}
+
+	settledAt := now.Add(-2 * time.Hour)
+	utr := "UTR_CHARGES"
+	for i, amount := range []float64{488.79, 478.79} {
+		require.NoError(t, h.repo.RecordSettlement(ctx, &Settlement{
+			SettlementID: fmt.Sprintf("settlement_%d", i+1), OrderID: fmt.Sprintf("order_%d", i+1),
+			Amount: amount, Status: "SUCCESS", UTR: &utr, SettledAt: &settledAt,
+		}))
+	}
+
+	run, err := h.ReconcileSettlements(ctx, now.Add(-24*time.Hour), now)
+	require.NoError(t, err)
+	stored, err := h.repo.GetReconRun(ctx, run.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Items, 2)
+
+	// order_2 was settled 10 less than its payment less the gateway's charges
+	mismatch := stored.Items[0]
+	assert.Equal(t, ReconNetMismatch, mismatch.Kind)
+	assert.Equal(t, "order_2", mismatch.OrderID)
+	assert.Equal(t, 488.79, *mismatch.LocalAmount)
+	assert.Equal(t, 478.79, *mismatch.RemoteAmount)
+	assert.Equal(t, 11.21, *mismatch.GatewayCharges)
+
+	unsettled := stored.Items[1]
+	assert.Equal(t, ReconUnsettled, unsettled.Kind)
+	assert.Equal(t, 967.58, *unsettled.LocalAmount)
+	assert.Equal(t, 22.42, *unsettled.GatewayCharges)
+}
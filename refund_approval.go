@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// Refund audit log actions
+const (
+	AuditRefundRequested        = "refund.requested"
+	AuditRefundApproved         = "refund.approved"
+	AuditRefundRejected         = "refund.rejected"
+	AuditRefundSubmitted        = "refund.submitted"
+	AuditRefundSubmissionFailed = "refund.submission_failed"
+)
+
+// actorHeader names the user of the merchant's system making a request. The API key
+// authenticates the merchant, which vouches for its users.
+const actorHeader = "X-Actor"
+
+var errRefundNotPending = errors.New("refund is not pending approval")
+
+// RefundAuditEntry records a step of a refund's approval
+type RefundAuditEntry struct {
+	ID        uuid.UUID              `json:"id"`
+	RefundID  string                 `json:"refund_id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	RemoteIP  string                 `json:"remote_ip"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// RecordRefundAudit appends an entry to the refund audit log
+func (r *PaymentRepository) RecordRefundAudit(ctx context.Context, entry *RefundAuditEntry) error {
+	query := `
+		INSERT INTO refund_audit_log (id, refund_id, action, actor, remote_ip, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	entry.ID = uuid.New()
+	entry.CreatedAt = r.now()
+
+	_, err := r.db.Exec(ctx, query,
+		entry.ID, entry.RefundID, entry.Action, entry.Actor,
+		entry.RemoteIP, entry.Details, entry.CreatedAt,
+	)
+
+	return err
+}
+
+// ListRefundAudit returns a refund's audit log, oldest first
+func (r *PaymentRepository) ListRefundAudit(ctx context.Context, refundID string) ([]RefundAuditEntry, error) {
+	query := `
+		SELECT id, refund_id, action, actor, remote_ip, details, created_at
+		FROM refund_audit_log
+		WHERE refund_id = $1
+		ORDER BY created_at, id
+	`
+
+	rows, err := r.db.Query(ctx, query, refundID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []RefundAuditEntry{}
+	for rows.Next() {
+		var entry RefundAuditEntry
+		err := rows.Scan(
+			&entry.ID, &entry.RefundID, &entry.Action, &entry.Actor,
+			&entry.RemoteIP, &entry.Details, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// DecideRefund approves or rejects a refund pending approval, returning
+// errRefundNotPending when it is not pending
+func (r *PaymentRepository) DecideRefund(ctx context.Context, refundID string, status domain.RefundStatus, actor string) (*Refund, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 5)
+	query := `
+		UPDATE refunds
+		SET status = $2, approved_by = $3, updated_at = $4
+		WHERE refund_id = $1 AND status = '` + string(domain.RefundPendingApproval) + `'` + tenant + `
+		RETURNING ` + refundColumns
+
+	args := append([]interface{}{refundID, status, actor, r.now()}, tenantArgs...)
+	refund, err := scanRefund(r.db.QueryRow(ctx, query, args...))
+	if err == pgx.ErrNoRows {
+		return nil, errRefundNotPending
+	}
+	return refund, err
+}
+
+// ReturnRefundForApproval puts an approved refund that Cashfree did not accept back in
+// PENDING_APPROVAL
+func (r *PaymentRepository) ReturnRefundForApproval(ctx context.Context, refundID string) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		UPDATE refunds
+		SET status = '` + string(domain.RefundPendingApproval) + `', approved_by = NULL, updated_at = $2
+		WHERE refund_id = $1 AND status = '` + string(domain.RefundApproved) + `'` + tenant
+
+	_, err := r.db.Exec(ctx, query, append([]interface{}{refundID, r.now()}, tenantArgs...)...)
+	return err
+}
+
+// SetRefundGatewayResult saves the gateway's refund ID, status, mode and ARN of a
+// refund it accepted
+func (r *PaymentRepository) SetRefundGatewayResult(ctx context.Context, refundID string, resp *CashfreeRefundResponse) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 7)
+	query := `
+		UPDATE refunds
+		SET cf_refund_id = $2, status = $3, refund_mode = NULLIF($4, ''),
+			refund_arn = NULLIF($5, ''), updated_at = $6
+		WHERE refund_id = $1` + tenant
+
+	args := append([]interface{}{
+		refundID, resp.CFRefundID, resp.RefundStatus, refundMode(resp), resp.RefundARN, r.now(),
+	}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// ListRefundsByStatus retrieves refunds with a status, oldest first
+func (r *PaymentRepository) ListRefundsByStatus(ctx context.Context, status domain.RefundStatus, limit, offset int) ([]Refund, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 4)
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE status = $1` + tenant + `
+		ORDER BY created_at
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{status, limit, offset}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// needsRefundApproval reports whether a refund of amount from payment must be approved
+// before it is sent to Cashfree. The threshold is in INR, so a refund in another
+// currency without a recorded reference rate always needs approval.
+func (h *PaymentHandler) needsRefundApproval(payment *Payment, amount float64) bool {
+	if h.refundApprovalThreshold <= 0 {
+		return false
+	}
+	if strings.EqualFold(payment.Currency, "INR") {
+		return amount > h.refundApprovalThreshold
+	}
+	if payment.FXRateINR == nil {
+		return true
+	}
+	return amount**payment.FXRateINR > h.refundApprovalThreshold
+}
+
+// requestRefundApproval saves a refund that waits for a second user's approval
+// instead of sending it to Cashfree
+func (h *PaymentHandler) requestRefundApproval(ctx context.Context, c *gin.Context, refund *Refund) {
+	actor := c.GetHeader(actorHeader)
+	if actor == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Actor header is required"})
+		return
+	}
+
+	refund.Status = domain.RefundPendingApproval
+	refund.RequestedBy = &actor
+	if !h.reserveRefund(ctx, c, refund) {
+		return
+	}
+
+	details := map[string]interface{}{"amount": refund.Amount}
+	if refund.Reason != nil {
+		details["reason"] = *refund.Reason
+	}
+	h.auditRefund(ctx, c, refund.RefundID, AuditRefundRequested, actor, details)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"refund_id":     refund.RefundID,
+		"order_id":      refund.OrderID,
+		"refund_amount": refund.Amount,
+		"refund_status": refund.Status,
+		"requested_by":  actor,
+	})
+}
+
+// auditRefund records a step of a refund's approval, logging rather than failing the
+// request when the entry cannot be written
+func (h *PaymentHandler) auditRefund(ctx context.Context, c *gin.Context, refundID, action, actor string, details map[string]interface{}) {
+	entry := &RefundAuditEntry{
+		RefundID: refundID,
+		Action:   action,
+		Actor:    actor,
+		RemoteIP: c.ClientIP(),
+		Details:  details,
+	}
+	if err := h.repo.RecordRefundAudit(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s for refund %s: %v", action, refundID, err)
+	}
+}
+
+// loadPendingRefund loads the refund named by the refund_id parameter for a decision by
+// the X-Actor user, writing the error response when it cannot be decided
+func (h *PaymentHandler) loadPendingRefund(ctx context.Context, c *gin.Context) (*Refund, string, bool) {
+	actor := c.GetHeader(actorHeader)
+	if actor == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Actor header is required"})
+		return nil, "", false
+	}
+
+	refund, err := h.repo.GetRefundByID(ctx, c.Param("refund_id"))
+	if err != nil {
+		log.Printf("Failed to get refund: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return nil, "", false
+	}
+	if refund.Status != domain.RefundPendingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "Refund is not pending approval"})
+		return nil, "", false
+	}
+	return refund, actor, true
+}
+
+// Approves a refund pending approval and sends it to Cashfree. The approver must not be
+// the user who requested it.
+func (h *PaymentHandler) ApproveRefund(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	refund, actor, ok := h.loadPendingRefund(ctx, c)
+	if !ok {
+		return
+	}
+	if refund.RequestedBy != nil && *refund.RequestedBy == actor {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Approver must not be the requester"})
+		return
+	}
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, refund.OrderID)
+	if err != nil {
+		log.Printf("Failed to get payment: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	gateway, err := h.gatewayFor(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve payment gateway: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve refund"})
+		return
+	}
+	splits, err := h.repo.ListRefundSplits(ctx, refund.RefundID)
+	if err != nil {
+		log.Printf("Failed to get refund splits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve refund"})
+		return
+	}
+
+	// Only one approval gets past this, however many race for the refund
+	refund, err = h.repo.DecideRefund(ctx, refund.RefundID, domain.RefundApproved, actor)
+	if err != nil {
+		if err == errRefundNotPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "Refund is not pending approval"})
+			return
+		}
+		log.Printf("Failed to approve refund: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve refund"})
+		return
+	}
+	h.auditRefund(ctx, c, refund.RefundID, AuditRefundApproved, actor, nil)
+
+	cashfreeRefundReq := CashfreeRefundRequest{
+		OrderID:      refund.OrderID,
+		RefundAmount: refund.Amount,
+		RefundID:     refund.RefundID,
+		RefundSpeed:  refund.Speed,
+		RefundSplits: cashfreeRefundSplits(splits),
+	}
+	if refund.Reason != nil {
+		cashfreeRefundReq.RefundNote = *refund.Reason
+	}
+
+	refundResp, err := gateway.RefundPayment(cashfreeRefundReq)
+	if err != nil {
+		log.Printf("Failed to create refund in %s: %v", gateway.Name(), err)
+		if err := h.repo.ReturnRefundForApproval(ctx, refund.RefundID); err != nil {
+			log.Printf("Failed to return refund %s for approval: %v", refund.RefundID, err)
+		}
+		h.auditRefund(ctx, c, refund.RefundID, AuditRefundSubmissionFailed, actor, map[string]interface{}{"error": err.Error()})
+		respondGatewayError(c, err, "Failed to create refund")
+		return
+	}
+
+	refund.CFRefundID = refundResp.CFRefundID
+	refund.Status = refundResp.RefundStatus
+	if err := h.repo.SetRefundGatewayResult(ctx, refund.RefundID, refundResp); err != nil {
+		log.Printf("Failed to save refund to database: %v", err)
+		// Don't return error as refund was created successfully in Cashfree
+	}
+	h.auditRefund(ctx, c, refund.RefundID, AuditRefundSubmitted, actor, map[string]interface{}{"cf_refund_id": refund.CFRefundID})
+
+	h.publishEvent(ctx, events.RefundCreated, refund.OrderID, refundPayload(refund, payment))
+
+	c.JSON(http.StatusOK, gin.H{
+		"refund_id":     refundResp.RefundID,
+		"cf_refund_id":  refundResp.CFRefundID,
+		"order_id":      refundResp.OrderID,
+		"refund_amount": refundResp.RefundAmount,
+		"refund_status": refundResp.RefundStatus,
+		"refund_mode":   refundMode(refundResp),
+		"requested_by":  refund.RequestedBy,
+		"approved_by":   actor,
+	})
+}
+
+// Rejects a refund pending approval, so it is never sent to Cashfree
+func (h *PaymentHandler) RejectRefund(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	refund, actor, ok := h.loadPendingRefund(ctx, c)
+	if !ok {
+		return
+	}
+
+	refund, err := h.repo.DecideRefund(ctx, refund.RefundID, domain.RefundRejected, actor)
+	if err != nil {
+		if err == errRefundNotPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "Refund is not pending approval"})
+			return
+		}
+		log.Printf("Failed to reject refund: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject refund"})
+		return
+	}
+
+	var details map[string]interface{}
+	if req.Reason != "" {
+		details = map[string]interface{}{"reason": req.Reason}
+	}
+	h.auditRefund(ctx, c, refund.RefundID, AuditRefundRejected, actor, details)
+
+	c.JSON(http.StatusOK, refund)
+}
+
+// Lists refunds waiting for approval, oldest first
+func (h *PaymentHandler) ListPendingRefunds(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	refunds, err := h.repo.ListRefundsByStatus(ctx, domain.RefundPendingApproval, limit, offset)
+	if err != nil {
+		log.Printf("Failed to get pending refunds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve refunds"})
+		return
+	}
+
+	validator := newListValidator()
+	for _, refund := range refunds {
+		validator.add(refund, refund.UpdatedAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	setEnvelope(c, refunds, &EnvelopeMeta{Pagination: &Pagination{Limit: limit, Offset: offset, Count: len(refunds)}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"refunds": refunds,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   len(refunds),
+	})
+}
+
+// Gets the approval audit log of a refund
+func (h *PaymentHandler) GetRefundAuditLog(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	refund, err := h.repo.GetRefundByID(ctx, c.Param("refund_id"))
+	if err != nil {
+		log.Printf("Failed to get refund: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Refund not found"})
+		return
+	}
+
+	entries, err := h.repo.ListRefundAudit(ctx, refund.RefundID)
+	if err != nil {
+		log.Printf("Failed to get refund audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit log"})
+		return
+	}
+
+	validator := newListValidator()
+	for _, entry := range entries {
+		validator.add(entry, entry.CreatedAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	setEnvelope(c, entries, nil)
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestNeedsRefundApproval(t *testing.T) {
+	h := &PaymentHandler{}
+	payment := testPayment("order_1")
+	assert.False(t, h.needsRefundApproval(payment, 1000000), "approval is off without a threshold")
+
+	h.refundApprovalThreshold = 10000
+	assert.False(t, h.needsRefundApproval(payment, 10000))
+	assert.True(t, h.needsRefundApproval(payment, 10000.01))
+
+	// Other currencies are compared in INR at the order's reference rate
+	rate := 83.25
+	payment.Currency = "USD"
+	payment.FXRateINR = &rate
+	assert.False(t, h.needsRefundApproval(payment, 120))
+	assert.True(t, h.needsRefundApproval(payment, 121))
+
+	payment.FXRateINR = nil
+	assert.True(t, h.needsRefundApproval(payment, 1), "an unknown INR amount needs approval")
+}
+
+func TestRefundApprovalFlow(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	gateway.Now = clock.Now
+
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		clock:        clock,
+
+		refundApprovalThreshold: 200,
+	}
+	for _, orderID := range []string{"order_1", "order_2"} {
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+		_, err = gateway.CompletePayment(orderID, "upi")
+		require.NoError(t, err)
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		payment.Status = "PAID"
+		require.NoError(t, repo.CreatePayment(ctx, payment))
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	post := func(path, actor, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if actor != "" {
+			req.Header.Set("X-Actor", actor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	gatewayRefunds := func(orderID string) int {
+		order, ok := gateway.Order(orderID)
+		require.True(t, ok)
+		return len(order.Refunds)
+	}
+
+	// Small refunds go straight to the gateway
+	w := post("/payments/order_1/refund", "", `{"amount": 50}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 1, gatewayRefunds("order_1"))
+	clock.Advance(time.Second)
+
+	// Large ones wait for a second user
+	assert.Equal(t, http.StatusBadRequest, post("/payments/order_1/refund", "", `{"amount": 300}`).Code)
+	w = post("/payments/order_1/refund", "maker@example.com", `{"amount": 300, "reason": "damaged"}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var requested struct {
+		RefundID     string `json:"refund_id"`
+		RefundStatus string `json:"refund_status"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &requested))
+	assert.Equal(t, string(domain.RefundPendingApproval), requested.RefundStatus)
+	assert.Equal(t, 1, gatewayRefunds("order_1"))
+
+	var pending struct {
+		Refunds []Refund `json:"refunds"`
+	}
+	w = get("/refunds/pending-approval")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pending))
+	require.Len(t, pending.Refunds, 1)
+	assert.Equal(t, requested.RefundID, pending.Refunds[0].RefundID)
+	assert.Empty(t, pending.Refunds[0].CFRefundID)
+	assert.Equal(t, "maker@example.com", *pending.Refunds[0].RequestedBy)
+
+	approve := "/refunds/" + requested.RefundID + "/approve"
+	assert.Equal(t, http.StatusBadRequest, post(approve, "", "").Code)
+	assert.Equal(t, http.StatusForbidden, post(approve, "maker@example.com", "").Code)
+
+	// A gateway failure leaves the refund waiting for approval
+	gateway.FailNext(http.StatusServiceUnavailable)
+	assert.Equal(t, http.StatusBadGateway, post(approve, "checker@example.com", "").Code)
+	refund, err := repo.GetRefundByID(ctx, requested.RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundPendingApproval, refund.Status)
+	assert.Nil(t, refund.ApprovedBy)
+
+	w = post(approve, "checker@example.com", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, 2, gatewayRefunds("order_1"))
+	refund, err = repo.GetRefundByID(ctx, requested.RefundID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, refund.CFRefundID)
+	assert.NotEqual(t, domain.RefundPendingApproval, refund.Status)
+	assert.Equal(t, "checker@example.com", *refund.ApprovedBy)
+	assert.Equal(t, http.StatusConflict, post(approve, "checker@example.com", "").Code)
+
+	// The approved refund counts against the balance
+	var balance RefundBalance
+	w = get("/payments/order_1/refundable")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &balance))
+	assert.Equal(t, 149.5, balance.Refundable)
+	clock.Advance(time.Second)
+	w = post("/payments/order_1/refund", "", `{"amount": 150}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"error": "Refund amount exceeds remaining balance", "refundable": 149.5}`, w.Body.String())
+	assert.Equal(t, 2, gatewayRefunds("order_1"))
+
+	var audit struct {
+		Entries []RefundAuditEntry `json:"entries"`
+	}
+	w = get("/refunds/" + requested.RefundID + "/audit")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &audit))
+	var actions []string
+	for _, entry := range audit.Entries {
+		actions = append(actions, entry.Action+" by "+entry.Actor)
+	}
+	assert.Equal(t, []string{
+		"refund.requested by maker@example.com",
+		"refund.approved by checker@example.com",
+		"refund.submission_failed by checker@example.com",
+		"refund.approved by checker@example.com",
+		"refund.submitted by checker@example.com",
+	}, actions)
+	assert.Equal(t, "damaged", audit.Entries[0].Details["reason"])
+
+	// A rejected refund never reaches the gateway
+	w = post("/payments/order_2/refund", "maker@example.com", `{"amount": 400}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &requested))
+	w = post("/refunds/"+requested.RefundID+"/reject", "checker@example.com", `{"reason": "duplicate request"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	refund, err = repo.GetRefundByID(ctx, requested.RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundRejected, refund.Status)
+	assert.Equal(t, 0, gatewayRefunds("order_2"))
+	assert.Equal(t, http.StatusConflict, post("/refunds/"+requested.RefundID+"/approve", "checker@example.com", "").Code)
+}
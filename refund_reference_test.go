@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundIDFor(t *testing.T) {
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	referenced := refundIDFor(nil, "order_1", "rma-42", now)
+	assert.Regexp(t, `^refund_[0-9a-f]{32}$`, referenced)
+	assert.Equal(t, referenced, refundIDFor(nil, "order_1", "rma-42", now.Add(time.Hour)))
+	assert.NotEqual(t, referenced, refundIDFor(nil, "order_1", "rma-43", now))
+	assert.NotEqual(t, referenced, refundIDFor(nil, "order_2", "rma-42", now))
+	assert.NotEqual(t, referenced, refundIDFor(nil, "order_1", "", now))
+	assert.NotEqual(t, refundIDFor(nil, "order_1", "", now), refundIDFor(nil, "order_1", "", now.Add(time.Nanosecond)))
+
+	// Merchants' refunds of orders with the same ID and reference differ
+	tenantID := uuid.New()
+	assert.NotEqual(t, referenced, refundIDFor(&tenantID, "order_1", "rma-42", now))
+
+	// IDs fit Cashfree's limit with the longest order IDs and references it allows
+	longOrderID := strings.Repeat("o", 45)
+	for _, id := range []string{refundIDFor(nil, longOrderID, strings.Repeat("r", 64), now), refundIDFor(nil, longOrderID, "", now)} {
+		assert.LessOrEqual(t, len(id), maxRefundIDLength, id)
+		assert.NoError(t, validateRefund(CashfreeRefundRequest{OrderID: longOrderID, RefundID: id, RefundAmount: 1}))
+	}
+}
+
+func TestRefundReferenceIsIdempotent(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	orderID := fmt.Sprintf("order_refund_ref_%d", time.Now().UnixNano())
+	payment := testPayment(orderID)
+	payment.Amount = 499.5
+	payment.Status = "SUCCESS"
+	require.NoError(t, handler.repo.CreatePayment(context.Background(), payment))
+	_, err := client.CreateOrder(testOrderRequest(orderID))
+	require.NoError(t, err)
+	_, err = server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+
+	refund := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/"+orderID+"/refund", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := refund(`{"amount": 100, "refund_reference": "rma-42"}`)
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Contains(t, first.Body.String(), `"refund_id":"`+refundIDFor(nil, orderID, "rma-42", time.Time{})+`"`)
+
+	// A retry gets the same refund, and Cashfree sees a single one
+	retry := refund(`{"amount": 100, "refund_reference": "rma-42"}`)
+	require.Equal(t, http.StatusOK, retry.Code, retry.Body.String())
+	assert.Contains(t, retry.Body.String(), `"refund_reference":"rma-42"`)
+	order, _ := server.Order(orderID)
+	assert.Len(t, order.Refunds, 1)
+
+	conflict := refund(`{"amount": 50, "refund_reference": "rma-42"}`)
+	assert.Equal(t, http.StatusConflict, conflict.Code)
+
+	// Refunds without a reference made in the same second no longer collide
+	require.Equal(t, http.StatusOK, refund(`{"amount": 10}`).Code)
+	require.Equal(t, http.StatusOK, refund(`{"amount": 10}`).Code)
+	order, _ = server.Order(orderID)
+	assert.Len(t, order.Refunds, 3)
+
+	assert.Equal(t, http.StatusBadRequest, refund(`{"amount": 10, "refund_reference": "rma 42"}`).Code)
+}
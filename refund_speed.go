@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// Refund speeds. An INSTANT refund reaches the customer within minutes instead of the
+// bank's usual working days.
+const (
+	RefundSpeedStandard = "STANDARD"
+	RefundSpeedInstant  = "INSTANT"
+)
+
+// instantRefundMethods are the payment methods that can be refunded instantly. Other
+// methods are refunded through the bank, so an instant refund of them is made at
+// STANDARD speed.
+var instantRefundMethods = map[string]bool{
+	"upi":  true,
+	"card": true,
+}
+
+// InstantRefundEligibility reports whether a payment can be refunded instantly
+type InstantRefundEligibility struct {
+	OrderID       string  `json:"order_id"`
+	Eligible      bool    `json:"eligible"`
+	Reason        string  `json:"reason,omitempty"` // why the payment is not eligible
+	PaymentMethod string  `json:"payment_method,omitempty"`
+	Refundable    float64 `json:"refundable"`
+}
+
+// refundMode returns the speed the gateway processes a refund at: the speed it
+// processed or accepted, falling back to the refund's mode
+func refundMode(resp *CashfreeRefundResponse) string {
+	if speed := resp.RefundSpeed; speed != nil {
+		if speed.Processed != "" {
+			return speed.Processed
+		}
+		if speed.Accepted != "" {
+			return speed.Accepted
+		}
+	}
+	return resp.RefundMode
+}
+
+// instantRefundEligibility checks whether a payment with the given refundable balance
+// can be refunded instantly
+func instantRefundEligibility(payment *Payment, balance *RefundBalance) InstantRefundEligibility {
+	eligibility := InstantRefundEligibility{OrderID: payment.OrderID, Refundable: balance.Refundable}
+	if payment.PaymentMethod != nil {
+		eligibility.PaymentMethod = *payment.PaymentMethod
+	}
+
+	switch {
+	case payment.Status != domain.PaymentPaid:
+		eligibility.Reason = "payment is not paid"
+	case !instantRefundMethods[strings.ToLower(eligibility.PaymentMethod)]:
+		eligibility.Reason = "payment method does not support instant refunds"
+	case balance.Refundable <= 0:
+		eligibility.Reason = "payment has been fully refunded"
+	default:
+		eligibility.Eligible = true
+	}
+	return eligibility
+}
+
+// Gets whether a payment can be refunded instantly
+func (h *PaymentHandler) GetInstantRefundEligibility(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, c.Param("order_id"))
+	if err != nil {
+		log.Printf("Failed to get payment: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	balance, err := h.repo.GetRefundBalance(ctx, payment.OrderID)
+	if err != nil {
+		log.Printf("Failed to get refund balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve refundable amount"})
+		return
+	}
+
+	c.JSON(http.StatusOK, instantRefundEligibility(payment, balance))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundMode(t *testing.T) {
+	assert.Equal(t, "STANDARD", refundMode(&CashfreeRefundResponse{RefundMode: "STANDARD"}))
+
+	// An instant refund Cashfree could not make is accepted at STANDARD speed
+	resp := &CashfreeRefundResponse{
+		RefundMode:  "INSTANT",
+		RefundSpeed: &CashfreeRefundSpeed{Requested: "INSTANT", Accepted: "STANDARD"},
+	}
+	assert.Equal(t, RefundSpeedStandard, refundMode(resp))
+	resp.RefundSpeed.Processed = "INSTANT"
+	assert.Equal(t, RefundSpeedInstant, refundMode(resp))
+}
+
+func TestInstantRefundEligibility(t *testing.T) {
+	method := "UPI"
+	payment := testPayment("order_1")
+	payment.Status = "PAID"
+	payment.PaymentMethod = &method
+	balance := &RefundBalance{OrderID: "order_1", Amount: 100, Refundable: 60}
+
+	eligibility := instantRefundEligibility(payment, balance)
+	assert.Equal(t, InstantRefundEligibility{OrderID: "order_1", Eligible: true, PaymentMethod: "UPI", Refundable: 60}, eligibility)
+
+	balance.Refundable = 0
+	assert.Equal(t, "payment has been fully refunded", instantRefundEligibility(payment, balance).Reason)
+
+	method = "netbanking"
+	assert.Equal(t, "payment method does not support instant refunds", instantRefundEligibility(payment, balance).Reason)
+
+	payment.Status = "ACTIVE"
+	eligibility = instantRefundEligibility(payment, balance)
+	assert.False(t, eligibility.Eligible)
+	assert.Equal(t, "payment is not paid", eligibility.Reason)
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/events"
+)
+
+// RefundSplit is the part of a refund clawed back from a vendor of a split settlement
+type RefundSplit struct {
+	VendorID string  `json:"vendor_id" binding:"required"`
+	Amount   float64 `json:"amount" binding:"required,gt=0"`
+
+	// Automatic is set on the reversals recorded when the order was fully refunded,
+	// which were not sent to the gateway with the refund
+	Automatic bool `json:"automatic,omitempty"`
+}
+
+// VendorBalance is a vendor's net position in one currency: what it was settled from
+// split orders less what refunds clawed back
+type VendorBalance struct {
+	Currency        string  `json:"currency"`
+	Settled         float64 `json:"settled"`
+	Reversed        float64 `json:"reversed"`         // clawed back by processed refunds
+	PendingReversal float64 `json:"pending_reversal"` // clawed back by refunds not yet processed
+	Net             float64 `json:"net"`
+}
+
+// CashfreeRefundSplit is a vendor's share of a refund in a Cashfree refund request
+type CashfreeRefundSplit struct {
+	VendorID string  `json:"vendor_id"`
+	Amount   float64 `json:"amount"`
+}
+
+// RefundSplitError is returned when a refund would claw back more from a vendor than
+// the vendor was settled for the order, less earlier refunds. Available is -1 when
+// the vendor has no split of the order.
+type RefundSplitError struct {
+	VendorID  string
+	Available float64
+}
+
+func (e *RefundSplitError) Error() string {
+	if e.Available < 0 {
+		return fmt.Sprintf("vendor %s has no split of the order", e.VendorID)
+	}
+	return fmt.Sprintf("refund split for vendor %s exceeds its available %.2f", e.VendorID, e.Available)
+}
+
+// ListSplitSettlements returns the vendor splits recorded for an order
+func (r *PaymentRepository) ListSplitSettlements(ctx context.Context, orderID string) ([]SplitSettlement, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT id, order_id, cf_order_id, vendor_id, amount, percentage,
+			   split_type, status, created_at, updated_at
+		FROM split_settlements
+		WHERE order_id = $1` + tenant + `
+		ORDER BY created_at, vendor_id
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var splits []SplitSettlement
+	for rows.Next() {
+		var split SplitSettlement
+		err := rows.Scan(
+			&split.ID, &split.OrderID, &split.CFOrderID, &split.VendorID, &split.Amount,
+			&split.Percentage, &split.SplitType, &split.Status, &split.CreatedAt, &split.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+
+	return splits, rows.Err()
+}
+
+// ListRefundSplits returns the vendor reversals of a refund
+func (r *PaymentRepository) ListRefundSplits(ctx context.Context, refundID string) ([]RefundSplit, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT vendor_id, amount, automatic
+		FROM refund_splits
+		WHERE refund_id = $1` + tenant + `
+		ORDER BY vendor_id
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{refundID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var splits []RefundSplit
+	for rows.Next() {
+		var split RefundSplit
+		if err := rows.Scan(&split.VendorID, &split.Amount, &split.Automatic); err != nil {
+			return nil, err
+		}
+		splits = append(splits, split)
+	}
+
+	return splits, rows.Err()
+}
+
+// reserveRefundSplits records the vendor reversals of a refund being reserved in tx,
+// returning a *RefundSplitError when a vendor's share exceeds what it has left. The
+// payment must be locked by tx.
+func (r *PaymentRepository) reserveRefundSplits(ctx context.Context, tx pgx.Tx, refund *Refund) error {
+	if len(refund.Splits) == 0 {
+		return nil
+	}
+
+	available, _, err := vendorSplitBalances(ctx, tx, refund.OrderID)
+	if err != nil {
+		return err
+	}
+	for _, split := range refund.Splits {
+		left, ok := available[split.VendorID]
+		if !ok {
+			return &RefundSplitError{VendorID: split.VendorID, Available: -1}
+		}
+		if split.Amount > left+0.0005 {
+			return &RefundSplitError{VendorID: split.VendorID, Available: math.Max(0, math.Round(left*1000)/1000)}
+		}
+		available[split.VendorID] = left - split.Amount
+	}
+
+	for _, split := range refund.Splits {
+		if err := r.insertRefundSplit(ctx, tx, refund, split.VendorID, split.Amount, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReverseRefundedSplits records the automatic vendor reversals of a fully refunded
+// order against the processed refund that completed it: each vendor of the split
+// settlement gives back what it was settled for the order less its earlier reversals.
+// It returns the reversals recorded, none for an unknown or unprocessed refund, while
+// the order is not fully refunded or once its vendors have given everything back.
+func (r *PaymentRepository) ReverseRefundedSplits(ctx context.Context, refundID string) ([]RefundSplit, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT order_id, tenant_id
+		FROM refunds
+		WHERE refund_id = $1 AND status = 'SUCCESS'` + tenant
+
+	refund := &Refund{RefundID: refundID}
+	err = tx.QueryRow(ctx, query, append([]interface{}{refundID}, tenantArgs...)...).Scan(&refund.OrderID, &refund.TenantID)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx = WithTenantID(ctx, refund.TenantID)
+
+	balance, err := r.refundBalance(ctx, tx, refund.OrderID, " FOR UPDATE")
+	if err != nil {
+		return nil, err
+	}
+	if balance.Pending > 0 || balance.Refunded < balance.Amount-0.0005 {
+		return nil, nil
+	}
+
+	available, vendors, err := vendorSplitBalances(ctx, tx, refund.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	var reversals []RefundSplit
+	for _, vendorID := range vendors {
+		amount := math.Round(available[vendorID]*100) / 100
+		if amount <= 0 {
+			continue
+		}
+		if err := r.insertRefundSplit(ctx, tx, refund, vendorID, amount, true); err != nil {
+			return nil, err
+		}
+		reversals = append(reversals, RefundSplit{VendorID: vendorID, Amount: amount, Automatic: true})
+	}
+	if len(reversals) == 0 {
+		return nil, nil
+	}
+
+	return reversals, tx.Commit(ctx)
+}
+
+// GetVendorBalance returns a vendor's net position in each currency it was settled in
+func (r *PaymentRepository) GetVendorBalance(ctx context.Context, vendorID string) ([]VendorBalance, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "WHERE", 2)
+	query := `
+		SELECT currency,
+			   COALESCE(SUM(amount) FILTER (WHERE kind = 'SETTLED'), 0),
+			   COALESCE(SUM(amount) FILTER (WHERE kind = 'REVERSED'), 0),
+			   COALESCE(SUM(amount) FILTER (WHERE kind = 'PENDING'), 0)
+		FROM (
+			SELECT s.tenant_id, s.amount, 'SETTLED' AS kind, p.currency
+			FROM split_settlements s
+			JOIN payments p ON p.tenant_key = s.tenant_key AND p.order_id = s.order_id
+			WHERE s.vendor_id = $1
+			UNION ALL
+			SELECT v.tenant_id, v.amount,
+				   CASE WHEN rf.status = 'SUCCESS' THEN 'REVERSED' ELSE 'PENDING' END, p.currency
+			FROM refund_splits v
+			JOIN refunds rf ON rf.refund_id = v.refund_id
+			JOIN payments p ON p.tenant_key = v.tenant_key AND p.order_id = v.order_id
+			WHERE v.vendor_id = $1 AND rf.status NOT IN ` + refundReleasedStatuses + `
+		) entries` + tenant + `
+		GROUP BY currency
+		ORDER BY currency
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{vendorID}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []VendorBalance{}
+	for rows.Next() {
+		var balance VendorBalance
+		if err := rows.Scan(&balance.Currency, &balance.Settled, &balance.Reversed, &balance.PendingReversal); err != nil {
+			return nil, err
+		}
+		balance.Net = math.Round((balance.Settled-balance.Reversed-balance.PendingReversal)*1000) / 1000
+		balances = append(balances, balance)
+	}
+
+	return balances, rows.Err()
+}
+
+// vendorSplitBalances returns what each vendor of an order's split settlement has left
+// to give back, and the vendors in order
+func vendorSplitBalances(ctx context.Context, tx pgx.Tx, orderID string) (map[string]float64, []string, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT s.vendor_id, SUM(s.amount) - COALESCE((
+			SELECT SUM(v.amount)
+			FROM refund_splits v
+			JOIN refunds rf ON rf.refund_id = v.refund_id
+			WHERE v.tenant_key = s.tenant_key AND v.order_id = $1 AND v.vendor_id = s.vendor_id
+			  AND rf.status NOT IN ` + refundReleasedStatuses + `
+		), 0)
+		FROM (SELECT * FROM split_settlements WHERE order_id = $1` + tenant + `) s
+		GROUP BY s.tenant_key, s.vendor_id
+		ORDER BY s.vendor_id
+	`
+
+	rows, err := tx.Query(ctx, query, append([]interface{}{orderID}, tenantArgs...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	available := make(map[string]float64)
+	var vendors []string
+	for rows.Next() {
+		var vendorID string
+		var amount float64
+		if err := rows.Scan(&vendorID, &amount); err != nil {
+			return nil, nil, err
+		}
+		available[vendorID] = amount
+		vendors = append(vendors, vendorID)
+	}
+	return available, vendors, rows.Err()
+}
+
+// insertRefundSplit records a vendor's reversal of a refund in tx
+func (r *PaymentRepository) insertRefundSplit(ctx context.Context, tx pgx.Tx, refund *Refund, vendorID string, amount float64, automatic bool) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 3, 8)
+	query := `
+		INSERT INTO refund_splits (id, refund_id, order_id, vendor_id, amount, automatic, created_at, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, ` + orderTenant + `)
+	`
+	args := []interface{}{uuid.New(), refund.RefundID, refund.OrderID, vendorID, amount, automatic, r.now()}
+	_, err := tx.Exec(ctx, query, append(args, tenantArgs...)...)
+	return err
+}
+
+// proportionalRefundSplits divides a refund among an order's vendors in proportion to
+// their splits of the payment. Shares are rounded down to the currency's smallest
+// unit, so any remainder is refunded by the merchant.
+func proportionalRefundSplits(payment *Payment, splits []SplitSettlement, amount float64) []RefundSplit {
+	byVendor := make(map[string]float64)
+	var vendors []string
+	for _, split := range splits {
+		if _, ok := byVendor[split.VendorID]; !ok {
+			vendors = append(vendors, split.VendorID)
+		}
+		byVendor[split.VendorID] += split.Amount
+	}
+
+	var refundSplits []RefundSplit
+	for _, vendorID := range vendors {
+		share := byVendor[vendorID] / payment.Amount * amount
+		minor := math.Floor(share*math.Pow10(currencyExponent(payment.Currency)) + 1e-6)
+		if minor <= 0 {
+			continue
+		}
+		refundSplits = append(refundSplits, RefundSplit{
+			VendorID: vendorID,
+			Amount:   fromMinorUnits(int64(minor), payment.Currency),
+		})
+	}
+	return refundSplits
+}
+
+// cashfreeRefundSplits converts a refund's vendor reversals for the gateway request
+func cashfreeRefundSplits(splits []RefundSplit) []CashfreeRefundSplit {
+	var cashfreeSplits []CashfreeRefundSplit
+	for _, split := range splits {
+		cashfreeSplits = append(cashfreeSplits, CashfreeRefundSplit{VendorID: split.VendorID, Amount: split.Amount})
+	}
+	return cashfreeSplits
+}
+
+// refundSplitsFor works out the vendor reversals a refund request asks for, writing the
+// error response when they are invalid
+func (h *PaymentHandler) refundSplitsFor(ctx context.Context, c *gin.Context, payment *Payment, req *RefundRequest) ([]RefundSplit, bool) {
+	if req.ProportionalSplits && len(req.Splits) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use either refund_splits or proportional_splits"})
+		return nil, false
+	}
+
+	if req.ProportionalSplits {
+		splits, err := h.repo.ListSplitSettlements(ctx, payment.OrderID)
+		if err != nil {
+			log.Printf("Failed to get split settlements: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create refund"})
+			return nil, false
+		}
+		if len(splits) == 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Order has no split settlement"})
+			return nil, false
+		}
+		return proportionalRefundSplits(payment, splits, req.Amount), true
+	}
+
+	total := 0.0
+	seen := make(map[string]bool)
+	for _, split := range req.Splits {
+		if seen[split.VendorID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "refund_splits names a vendor more than once"})
+			return nil, false
+		}
+		seen[split.VendorID] = true
+		total += split.Amount
+	}
+	if total > req.Amount+0.0005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refund_splits exceed the refund amount"})
+		return nil, false
+	}
+	return req.Splits, true
+}
+
+// reverseRefundedSplits records the automatic vendor reversals once a processed refund
+// completes its order, and publishes a vendor.recovery_due event for each when the
+// deployment recovers reversals from vendor payouts
+func (h *PaymentHandler) reverseRefundedSplits(ctx context.Context, refundID string) error {
+	reversals, err := h.repo.ReverseRefundedSplits(ctx, refundID)
+	if err != nil || len(reversals) == 0 || !h.splitRecoveryEvents {
+		return err
+	}
+	refund, err := h.repo.GetRefundByID(ctx, refundID)
+	if err != nil {
+		return err
+	}
+	payment, err := h.repo.GetPaymentByOrderID(ctx, refund.OrderID)
+	if err != nil {
+		return err
+	}
+	for _, reversal := range reversals {
+		h.publishEvent(ctx, events.VendorRecoveryDue, refund.OrderID, events.VendorRecoveryPayload{
+			VendorID: reversal.VendorID,
+			OrderID:  refund.OrderID,
+			RefundID: refund.RefundID,
+			Amount:   reversal.Amount,
+			Currency: payment.Currency,
+		})
+	}
+	return nil
+}
+
+// Gets a vendor's net position from split settlements and refund reversals
+func (h *PaymentHandler) GetVendorBalance(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	vendorID := c.Param("vendor_id")
+	balances, err := h.repo.GetVendorBalance(ctx, vendorID)
+	if err != nil {
+		log.Printf("Failed to get vendor balance: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vendor balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vendor_id": vendorID, "balances": balances})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/events"
+)
+
+func TestProportionalRefundSplits(t *testing.T) {
+	payment := testPayment("order_1")
+	splits := []SplitSettlement{
+		{VendorID: "vendor_a", Amount: 60},
+		{VendorID: "vendor_b", Amount: 25},
+		{VendorID: "vendor_b", Amount: 5},
+	}
+
+	assert.Equal(t, []RefundSplit{
+		{VendorID: "vendor_a", Amount: 30},
+		{VendorID: "vendor_b", Amount: 15},
+	}, proportionalRefundSplits(payment, splits, 50))
+
+	// Shares are rounded down, leaving the remainder to the merchant
+	assert.Equal(t, []RefundSplit{
+		{VendorID: "vendor_a", Amount: 0.19},
+		{VendorID: "vendor_b", Amount: 0.09},
+	}, proportionalRefundSplits(payment, splits, 0.33))
+	assert.Empty(t, proportionalRefundSplits(payment, splits, 0.01))
+}
+
+func TestPaymentRepositoryRefundSplits(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	require.NoError(t, repo.CreateSplitSettlement(ctx, []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_a", Amount: 60, SplitType: "AMOUNT", Status: "PENDING"},
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_b", Amount: 30, SplitType: "AMOUNT", Status: "PENDING"},
+	}))
+
+	refund := &Refund{
+		RefundID: "refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 50, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_a", Amount: 40}, {VendorID: "vendor_b", Amount: 10}},
+	}
+	require.NoError(t, repo.ReserveRefund(ctx, refund))
+	splits, err := repo.ListRefundSplits(ctx, "refund_1")
+	require.NoError(t, err)
+	assert.Equal(t, refund.Splits, splits)
+
+	// A vendor cannot give back more than it was settled, less earlier refunds
+	err = repo.ReserveRefund(ctx, &Refund{
+		RefundID: "refund_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 25, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_a", Amount: 25}},
+	})
+	var splitErr *RefundSplitError
+	require.ErrorAs(t, err, &splitErr)
+	assert.Equal(t, RefundSplitError{VendorID: "vendor_a", Available: 20}, *splitErr)
+	_, err = repo.GetRefundByID(ctx, "refund_2")
+	assert.Error(t, err, "a rejected split rolls back the refund")
+
+	err = repo.ReserveRefund(ctx, &Refund{
+		RefundID: "refund_3", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 5, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_c", Amount: 5}},
+	})
+	require.ErrorAs(t, err, &splitErr)
+	assert.Equal(t, -1.0, splitErr.Available)
+
+	// Released refunds give the vendor's share back
+	require.NoError(t, repo.ReleaseRefund(ctx, "refund_1"))
+	require.NoError(t, repo.ReserveRefund(ctx, &Refund{
+		RefundID: "refund_4", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 60, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_a", Amount: 60}},
+	}))
+}
+
+func TestReverseRefundedSplits(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	bus := events.NewMemoryBus(nil)
+	var mu sync.Mutex
+	var recoveries []events.VendorRecoveryPayload
+	bus.Subscribe(events.VendorRecoveryDue, func(ctx context.Context, event events.Event) error {
+		var recovery events.VendorRecoveryPayload
+		require.NoError(t, event.Decode(&recovery))
+		mu.Lock()
+		defer mu.Unlock()
+		recoveries = append(recoveries, recovery)
+		return nil
+	})
+	handler := &PaymentHandler{repo: repo, publisher: bus, splitRecoveryEvents: true}
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	require.NoError(t, repo.CreateSplitSettlement(ctx, []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_a", Amount: 60, SplitType: "AMOUNT", Status: "PENDING"},
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_b", Amount: 30, SplitType: "AMOUNT", Status: "PENDING"},
+	}))
+	refund := func(refundID string, amount float64, splits ...RefundSplit) {
+		require.NoError(t, repo.ReserveRefund(ctx, &Refund{RefundID: refundID, OrderID: "order_1", CFOrderID: "cf_order_1", Amount: amount, Status: "PENDING", Splits: splits}))
+		require.NoError(t, repo.UpdateRefundStatus(ctx, refundID, "SUCCESS", nil))
+		require.NoError(t, handler.reverseRefundedSplits(ctx, refundID))
+	}
+
+	// Nothing is reversed while the order is partly refunded
+	refund("refund_1", 40, RefundSplit{VendorID: "vendor_a", Amount: 10})
+	reversals, err := repo.ListRefundSplits(ctx, "refund_1")
+	require.NoError(t, err)
+	assert.Equal(t, []RefundSplit{{VendorID: "vendor_a", Amount: 10}}, reversals)
+
+	refund("refund_2", 60)
+	reversals, err = repo.ListRefundSplits(ctx, "refund_2")
+	require.NoError(t, err)
+	assert.Equal(t, []RefundSplit{
+		{VendorID: "vendor_a", Amount: 50, Automatic: true},
+		{VendorID: "vendor_b", Amount: 30, Automatic: true},
+	}, reversals)
+
+	// A repeated webhook reverses nothing more
+	require.NoError(t, handler.reverseRefundedSplits(ctx, "refund_2"))
+	reversals, err = repo.ListRefundSplits(ctx, "refund_2")
+	require.NoError(t, err)
+	assert.Len(t, reversals, 2)
+
+	bus.Wait()
+	assert.Equal(t, []events.VendorRecoveryPayload{
+		{VendorID: "vendor_a", OrderID: "order_1", RefundID: "refund_2", Amount: 50, Currency: "INR"},
+		{VendorID: "vendor_b", OrderID: "order_1", RefundID: "refund_2", Amount: 30, Currency: "INR"},
+	}, recoveries)
+
+	balances, err := repo.GetVendorBalance(ctx, "vendor_a")
+	require.NoError(t, err)
+	assert.Equal(t, []VendorBalance{{Currency: "INR", Settled: 60, Reversed: 60, Net: 0}}, balances)
+	balances, err = repo.GetVendorBalance(ctx, "vendor_c")
+	require.NoError(t, err)
+	assert.Empty(t, balances)
+}
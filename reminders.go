@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/events"
+)
+
+// reminderMaxAge is how old an unpaid order may be and still be reminded of, so
+// customers are not chased for orders they have long abandoned
+const reminderMaxAge = 24 * time.Hour
+
+// reminderBatchSize bounds the orders reminded of in one run of the job
+const reminderBatchSize = 500
+
+// ReminderConfig configures reminders for abandoned checkouts
+type ReminderConfig struct {
+	Enabled bool
+	// Delay is how long an order stays unpaid before a reminder, and the least time
+	// between two reminders of the same order
+	Delay       time.Duration
+	MaxPerOrder int
+	// CustomerDailyLimit caps the reminders a customer receives in 24 hours, across
+	// all their orders
+	CustomerDailyLimit int
+}
+
+// ReminderCandidate is an unpaid order that may be reminded of
+type ReminderCandidate struct {
+	Payment      Payment
+	OrderSent    int // reminders already sent for the order
+	CustomerSent int // reminders the customer received in the last 24 hours
+}
+
+// ReminderPreference records whether a customer has opted out of reminders
+type ReminderPreference struct {
+	CustomerID string    `json:"customer_id"`
+	OptedOut   bool      `json:"opted_out"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ReminderStats summarizes the reminders sent in a period and the orders paid after one
+type ReminderStats struct {
+	From             time.Time          `json:"from"`
+	To               time.Time          `json:"to"`
+	RemindersSent    int                `json:"reminders_sent"`
+	OrdersReminded   int                `json:"orders_reminded"`
+	OrdersRecovered  int                `json:"orders_recovered"`
+	RecoveredRevenue map[string]float64 `json:"recovered_revenue"` // by currency
+}
+
+// ListReminderCandidates returns ACTIVE orders with a payment link created between
+// createdAfter and unpaidSince, oldest first. Orders already reminded maxPerOrder
+// times or since unpaidSince, and customers who opted out, are left out.
+func (r *PaymentRepository) ListReminderCandidates(ctx context.Context, unpaidSince, createdAfter time.Time, maxPerOrder int, limit int) ([]ReminderCandidate, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 6)
+	query := `
+		SELECT ` + paymentColumns + `, o.sent, c.sent
+		FROM payments p
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS sent, MAX(sent_at) AS last_sent
+			FROM checkout_reminders r
+			WHERE r.tenant_key = p.tenant_key AND r.order_id = p.order_id
+		) o
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS sent
+			FROM checkout_reminders r
+			WHERE r.customer_id = p.customer_id
+			  AND r.tenant_id IS NOT DISTINCT FROM p.tenant_id
+			  AND r.sent_at > $5
+		) c
+		WHERE p.status = 'ACTIVE' AND COALESCE(p.payment_url, '') <> ''
+		  AND p.created_at <= $1 AND p.created_at > $2
+		  AND o.sent < $3 AND (o.last_sent IS NULL OR o.last_sent <= $1)
+		  AND NOT EXISTS (
+			SELECT 1 FROM customer_reminder_preferences pref
+			WHERE pref.customer_id = p.customer_id
+			  AND pref.tenant_id IS NOT DISTINCT FROM p.tenant_id
+			  AND pref.opted_out
+		  )` + tenant + `
+		ORDER BY p.created_at
+		LIMIT $4
+	`
+
+	args := append([]interface{}{unpaidSince, createdAfter, maxPerOrder, limit, r.now().Add(-24 * time.Hour)}, tenantArgs...)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []ReminderCandidate
+	for rows.Next() {
+		var candidate ReminderCandidate
+		payment, err := scanPayment(trailingColumns{rows, []interface{}{&candidate.OrderSent, &candidate.CustomerSent}})
+		if err != nil {
+			return nil, err
+		}
+		candidate.Payment = *payment
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// trailingColumns scans a row whose leading columns are read by a scan helper such as
+// scanPayment, and whose remaining columns go to extra
+type trailingColumns struct {
+	pgx.Row
+	extra []interface{}
+}
+
+func (t trailingColumns) Scan(dest ...interface{}) error {
+	return t.Row.Scan(append(dest, t.extra...)...)
+}
+
+// RecordCheckoutReminder records the attempt'th reminder of a payment. It returns
+// false when that reminder was already recorded, by another instance of the job.
+func (r *PaymentRepository) RecordCheckoutReminder(ctx context.Context, payment *Payment, attempt int) (bool, error) {
+	query := `
+		INSERT INTO checkout_reminders (id, tenant_id, order_id, customer_id, attempt, sent_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant_key, order_id, attempt) DO NOTHING
+	`
+
+	tag, err := r.db.Exec(ctx, query,
+		uuid.New(), payment.TenantID, payment.OrderID, payment.CustomerID, attempt, r.now(),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetReminderPreference returns a customer's reminder preference. Customers who never
+// set one receive reminders.
+func (r *PaymentRepository) GetReminderPreference(ctx context.Context, customerID string) (*ReminderPreference, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	if tenant == "" {
+		tenant = " AND tenant_id IS NULL"
+	}
+	query := `
+		SELECT customer_id, opted_out, updated_at
+		FROM customer_reminder_preferences
+		WHERE customer_id = $1` + tenant
+
+	pref := &ReminderPreference{}
+	err := r.db.QueryRow(ctx, query, append([]interface{}{customerID}, tenantArgs...)...).Scan(
+		&pref.CustomerID, &pref.OptedOut, &pref.UpdatedAt,
+	)
+	if err == pgx.ErrNoRows {
+		return &ReminderPreference{CustomerID: customerID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// SetReminderPreference opts a customer out of reminders, or back in
+func (r *PaymentRepository) SetReminderPreference(ctx context.Context, customerID string, optedOut bool) (*ReminderPreference, error) {
+	query := `
+		INSERT INTO customer_reminder_preferences (tenant_id, customer_id, opted_out, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (COALESCE(tenant_id, '00000000-0000-0000-0000-000000000000'), customer_id)
+		DO UPDATE SET opted_out = EXCLUDED.opted_out, updated_at = EXCLUDED.updated_at
+	`
+
+	pref := &ReminderPreference{CustomerID: customerID, OptedOut: optedOut, UpdatedAt: r.now()}
+	_, err := r.db.Exec(ctx, query, TenantIDFromContext(ctx), customerID, optedOut, pref.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return pref, nil
+}
+
+// GetReminderStats counts the reminders sent between from and to, and the reminded
+// orders that were paid after their first reminder
+func (r *PaymentRepository) GetReminderStats(ctx context.Context, from, to time.Time) (*ReminderStats, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		WITH reminded AS (
+			SELECT tenant_key, order_id, MIN(sent_at) AS first_sent, COUNT(*) AS sent
+			FROM checkout_reminders
+			WHERE sent_at >= $1 AND sent_at < $2` + tenant + `
+			GROUP BY tenant_key, order_id
+		)
+		SELECT p.currency, COUNT(*), SUM(r.sent)::int,
+		       COUNT(*) FILTER (WHERE p.status = 'PAID' AND COALESCE(p.payment_time, p.updated_at) >= r.first_sent),
+		       COALESCE(SUM(p.amount) FILTER (WHERE p.status = 'PAID' AND COALESCE(p.payment_time, p.updated_at) >= r.first_sent), 0)
+		FROM reminded r
+		JOIN payments p ON p.tenant_key = r.tenant_key AND p.order_id = r.order_id
+		GROUP BY p.currency
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := &ReminderStats{From: from, To: to, RecoveredRevenue: make(map[string]float64)}
+	for rows.Next() {
+		var currency string
+		var orders, sent, recovered int
+		var revenue float64
+		if err := rows.Scan(&currency, &orders, &sent, &recovered, &revenue); err != nil {
+			return nil, err
+		}
+		stats.OrdersReminded += orders
+		stats.RemindersSent += sent
+		stats.OrdersRecovered += recovered
+		if recovered > 0 {
+			stats.RecoveredRevenue[currency] = revenue
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+// ReminderHandler reminds customers of orders they left unpaid
+type ReminderHandler struct {
+	repo      *PaymentRepository
+	publisher events.Publisher
+	cfg       ReminderConfig
+	location  *time.Location
+	clock     Clock
+}
+
+// now returns the time orders are measured against
+func (h *ReminderHandler) now() time.Time {
+	return clockOrSystem(h.clock).Now()
+}
+
+// SendReminders publishes a payment.reminder event for each order due a reminder,
+// returning how many were sent. Subscribers deliver the reminder, e.g. by email.
+func (h *ReminderHandler) SendReminders(ctx context.Context) (int, error) {
+	now := h.now()
+	candidates, err := h.repo.ListReminderCandidates(ctx, now.Add(-h.cfg.Delay), now.Add(-reminderMaxAge), h.cfg.MaxPerOrder, reminderBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// The customer counts are from before this run, so add the reminders it sends
+	sentTo := make(map[string]int)
+	sent := 0
+	for _, candidate := range candidates {
+		payment := candidate.Payment
+		customer := payment.CustomerID
+		if payment.TenantID != nil {
+			customer = payment.TenantID.String() + "/" + customer
+		}
+		if candidate.CustomerSent+sentTo[customer] >= h.cfg.CustomerDailyLimit {
+			continue
+		}
+
+		attempt := candidate.OrderSent + 1
+		recorded, err := h.repo.RecordCheckoutReminder(ctx, &payment, attempt)
+		if err != nil {
+			return sent, err
+		}
+		if !recorded {
+			continue
+		}
+		sentTo[customer]++
+		sent++
+
+		h.publish(WithTenantID(ctx, payment.TenantID), events.PaymentReminderPayload{PaymentPayload: paymentPayload(&payment), Attempt: attempt})
+	}
+
+	return sent, nil
+}
+
+// publish publishes a payment.reminder event, logging rather than failing when it
+// cannot be delivered to the bus
+func (h *ReminderHandler) publish(ctx context.Context, payload events.PaymentReminderPayload) {
+	if h.publisher == nil {
+		return
+	}
+
+	event, err := newTenantEvent(ctx, events.PaymentReminder, payload.OrderID, payload)
+	if err != nil {
+		log.Printf("Failed to build %s event: %v", events.PaymentReminder, err)
+		return
+	}
+	if err := h.publisher.Publish(ctx, event); err != nil {
+		log.Printf("Failed to publish %s event: %v", events.PaymentReminder, err)
+	}
+}
+
+// CheckoutReminderJob sends reminders of abandoned checkouts every five minutes
+func (h *ReminderHandler) CheckoutReminderJob() Job {
+	return Job{
+		Name:     "checkout_reminders",
+		Schedule: Every(5 * time.Minute),
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			sent, err := h.SendReminders(ctx)
+			if sent > 0 {
+				log.Printf("Sent %d checkout reminders", sent)
+			}
+			return err
+		},
+	}
+}
+
+// Gets whether a customer has opted out of reminders
+func (h *ReminderHandler) GetPreference(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	pref, err := h.repo.GetReminderPreference(ctx, c.Param("customer_id"))
+	if err != nil {
+		log.Printf("Failed to get reminder preference: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reminder preference"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pref)
+}
+
+// Opts a customer out of reminders, or back in
+func (h *ReminderHandler) SetPreference(c *gin.Context) {
+	var req struct {
+		OptedOut *bool `json:"opted_out" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	pref, err := h.repo.SetReminderPreference(ctx, c.Param("customer_id"), *req.OptedOut)
+	if err != nil {
+		log.Printf("Failed to save reminder preference: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save reminder preference"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pref)
+}
+
+// Gets the reminders sent between ?from=YYYY-MM-DD and ?to=YYYY-MM-DD and the revenue
+// of the orders paid after one
+func (h *ReminderHandler) GetStats(c *gin.Context) {
+	ctx := requestContext(c)
+	from, to, err := parseDateRange(c, reportLocation(ctx, h.location))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stats, err := h.repo.GetReminderStats(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to get reminder stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reminder stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// registerReminderRoutes registers the reminder routes on a merchant-authenticated group
+func registerReminderRoutes(group *gin.RouterGroup, reminderHandler *ReminderHandler) {
+	// Get or set whether a customer receives reminders of unpaid orders
+	group.GET("/customers/:customer_id/reminders", reminderHandler.GetPreference)
+	group.PUT("/customers/:customer_id/reminders", reminderHandler.SetPreference)
+
+	// Reminders sent and revenue recovered between ?from=YYYY-MM-DD&to=YYYY-MM-DD
+	group.GET("/reminders/stats", reminderHandler.GetStats)
+}
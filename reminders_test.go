@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/events"
+)
+
+// reminderRecorder records the reminders published by the job
+type reminderRecorder struct {
+	mu        sync.Mutex
+	reminders []events.PaymentReminderPayload
+}
+
+func (p *reminderRecorder) Publish(ctx context.Context, event events.Event) error {
+	var reminder events.PaymentReminderPayload
+	if err := event.Decode(&reminder); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reminders = append(p.reminders, reminder)
+	return nil
+}
+
+func (p *reminderRecorder) Close() error { return nil }
+
+// take returns the reminders published since it was last called, as order:attempt
+func (p *reminderRecorder) take() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sent []string
+	for _, reminder := range p.reminders {
+		sent = append(sent, fmt.Sprintf("%s:%d", reminder.OrderID, reminder.Attempt))
+	}
+	p.reminders = nil
+	return sent
+}
+
+func TestCheckoutReminders(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	start := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	clock := newTestClock(start)
+
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	published := &reminderRecorder{}
+	handler := &ReminderHandler{
+		repo:      repo,
+		publisher: published,
+		cfg:       ReminderConfig{Enabled: true, Delay: 30 * time.Minute, MaxPerOrder: 2, CustomerDailyLimit: 3},
+		location:  time.UTC,
+		clock:     clock,
+	}
+
+	create := func(orderID, customerID string, withURL bool) {
+		payment := testPayment(orderID)
+		payment.CustomerID = customerID
+		if withURL {
+			url := "https://payments.example.com/pay/" + orderID
+			payment.PaymentURL = &url
+		}
+		require.NoError(t, repo.CreatePayment(ctx, payment))
+		clock.Advance(time.Second)
+	}
+	create("order_1", "customer_001", true)
+	create("order_2", "customer_001", true)
+	create("order_3", "customer_002", true)
+	create("order_4", "customer_003", false)
+	_, err := repo.SetReminderPreference(ctx, "customer_002", true)
+	require.NoError(t, err)
+	clock.Advance(20 * time.Minute)
+	create("order_5", "customer_003", true)
+
+	send := func() []string {
+		_, err := handler.SendReminders(ctx)
+		require.NoError(t, err)
+		return published.take()
+	}
+
+	// Nothing is due before the delay, opted out customers and orders without a
+	// payment link are never reminded
+	assert.Empty(t, send())
+	clock.Advance(11 * time.Minute)
+	assert.Equal(t, []string{"order_1:1", "order_2:1"}, send())
+	clock.Advance(10 * time.Minute)
+	assert.Empty(t, send())
+
+	// customer_001 reaches the daily limit after one more reminder
+	clock.Advance(20 * time.Minute)
+	assert.Equal(t, []string{"order_1:2", "order_5:1"}, send())
+
+	paidAt := clock.Now()
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_2", "PAID", nil, nil, &paidAt))
+
+	// Paid orders and orders reminded MaxPerOrder times are done
+	clock.Advance(30 * time.Minute)
+	assert.Equal(t, []string{"order_5:2"}, send())
+	clock.Advance(time.Hour)
+	assert.Empty(t, send())
+
+	recorded, err := repo.RecordCheckoutReminder(ctx, testPayment("order_5"), 2)
+	require.NoError(t, err)
+	assert.False(t, recorded, "a reminder is recorded once")
+
+	stats, err := repo.GetReminderStats(ctx, start, start.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 5, stats.RemindersSent)
+	assert.Equal(t, 3, stats.OrdersReminded)
+	assert.Equal(t, 1, stats.OrdersRecovered)
+	assert.Equal(t, map[string]float64{"INR": 100}, stats.RecoveredRevenue)
+}
+
+func TestReminderPreferenceAPI(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	handler := &ReminderHandler{repo: repo, location: time.UTC}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerReminderRoutes(r.Group(""), handler)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	var pref ReminderPreference
+	w := serve(http.MethodGet, "/customers/customer_001/reminders", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pref))
+	assert.False(t, pref.OptedOut)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, "/customers/customer_001/reminders", `{}`).Code)
+	w = serve(http.MethodPut, "/customers/customer_001/reminders", `{"opted_out": true}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(http.MethodGet, "/customers/customer_001/reminders", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &pref))
+	assert.True(t, pref.OptedOut)
+
+	w = serve(http.MethodGet, "/reminders/stats?from=2024-04-01&to=2024-04-30", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{
+		"from": "2024-04-01T00:00:00Z",
+		"to": "2024-05-01T00:00:00Z",
+		"reminders_sent": 0,
+		"orders_reminded": 0,
+		"orders_recovered": 0,
+		"recovered_revenue": {}
+	}`, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/reminders/stats?from=April", "").Code)
+}
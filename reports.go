@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/storage"
+)
+
+// FormatPaymentsCSV renders payments as a CSV report with times in loc
+func FormatPaymentsCSV(payments []Payment, loc *time.Location) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{
+		"order_id", "cf_order_id", "cf_payment_id", "status", "amount", "currency",
+		"payment_method", "customer_id", "customer_name", "customer_email",
+		"invoice_number", "payment_time", "created_at", "fx_rate_inr", "amount_inr",
+		"gateway_fee", "gateway_tax", "net_amount",
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, p := range payments {
+		record := []string{
+			p.OrderID,
+			p.CFOrderID,
+			stringValue(p.CFPaymentID),
+			string(p.Status),
+			formatCurrencyAmount(p.Amount, p.Currency),
+			p.Currency,
+			stringValue(p.PaymentMethod),
+			p.CustomerID,
+			p.CustomerName,
+			p.CustomerEmail,
+			stringValue(p.InvoiceNumber),
+			timeValue(p.PaymentTime, loc),
+			p.CreatedAt.In(loc).Format(time.RFC3339),
+			"",
+			"",
+			currencyAmountValue(p.GatewayFee, p.Currency),
+			currencyAmountValue(p.GatewayTax, p.Currency),
+			currencyAmountValue(p.NetAmount, p.Currency),
+		}
+		if p.FXRateINR != nil {
+			record[13] = strconv.FormatFloat(*p.FXRateINR, 'f', -1, 64)
+		}
+		if amount, ok := p.AmountINR(); ok {
+			record[14] = formatAmount(amount)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func currencyAmountValue(amount *float64, currency string) string {
+	if amount == nil {
+		return ""
+	}
+	return formatCurrencyAmount(*amount, currency)
+}
+
+func timeValue(t *time.Time, loc *time.Location) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// Exports payments created in a date range as CSV
+func (h *ExportHandler) ExportPayments(c *gin.Context) {
+	loc := h.locationFor(requestContext(c))
+	from, to, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	payments, err := h.repo.ListPaymentsCreatedBetween(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to get payments for export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build payments export"})
+		return
+	}
+
+	body, err := FormatPaymentsCSV(payments, loc)
+	if err != nil {
+		log.Printf("Failed to format payments export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build payments export"})
+		return
+	}
+
+	filename := fmt.Sprintf("payments_%s_%s.csv", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/csv", body)
+}
+
+// DailyPaymentsReportJob uploads the previous day's payments CSV to object storage
+// as payments/<date>.csv, with days starting at midnight in loc
+func (h *ExportHandler) DailyPaymentsReportJob(uploader storage.Uploader, hour int, loc *time.Location) Job {
+	return Job{
+		Name:     "payments_report",
+		Schedule: DailyAt(hour, 0, loc),
+		Run: func(ctx context.Context) error {
+			return h.uploadPaymentsReport(ctx, uploader, "payments", h.now(), loc)
+		},
+	}
+}
+
+// MerchantPaymentsReportJob uploads each active merchant's payments CSV for its
+// previous business day as payments/<merchant_id>/<date>.csv, at the given hour of the
+// day in the merchant's time zone. It runs hourly so that every time zone is covered.
+func (h *ExportHandler) MerchantPaymentsReportJob(uploader storage.Uploader, hour int) Job {
+	return Job{
+		Name:     "merchant_payments_report",
+		Schedule: Hourly(),
+		Run: func(ctx context.Context) error {
+			merchants, err := h.merchants.ListMerchants(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list merchants: %v", err)
+			}
+
+			now := h.now()
+			var failed []string
+			for _, merchant := range merchants {
+				merchantCtx := WithMerchant(ctx, merchant)
+				loc := h.locationFor(merchantCtx)
+				if !merchant.Active || now.In(loc).Hour() != hour {
+					continue
+				}
+
+				prefix := "payments/" + merchant.ID.String()
+				if err := h.uploadPaymentsReport(merchantCtx, uploader, prefix, now, loc); err != nil {
+					log.Printf("Failed to upload payments report for merchant %s: %v", merchant.ID, err)
+					failed = append(failed, merchant.ID.String())
+				}
+			}
+
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to upload payments reports for %d merchants", len(failed))
+			}
+			return nil
+		},
+	}
+}
+
+// uploadPaymentsReport uploads the payments created on the day before now, with days
+// starting at midnight in loc, as <prefix>/<date>.csv
+func (h *ExportHandler) uploadPaymentsReport(ctx context.Context, uploader storage.Uploader, prefix string, now time.Time, loc *time.Location) error {
+	to := startOfDay(now, loc)
+	from := to.AddDate(0, 0, -1)
+
+	payments, err := h.repo.ListPaymentsCreatedBetween(ctx, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to get payments: %v", err)
+	}
+
+	body, err := FormatPaymentsCSV(payments, loc)
+	if err != nil {
+		return fmt.Errorf("failed to format payments report: %v", err)
+	}
+
+	key := fmt.Sprintf("%s/%s.csv", prefix, from.Format("2006-01-02"))
+	if err := uploader.Upload(ctx, key, body, "text/csv"); err != nil {
+		return err
+	}
+
+	log.Printf("Uploaded payments report %s (%d payments)", key, len(payments))
+	return nil
+}
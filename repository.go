@@ -2,20 +2,94 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/domain"
 )
 
+// paymentColumns lists the payments columns in the order scanPayment reads them
+const paymentColumns = `id, order_id, cf_order_id, amount, currency, status,
+			   payment_method, customer_id, customer_name, customer_email,
+			   customer_phone, description, payment_url, cf_payment_id,
+			   payment_time, gstin, place_of_supply, tax_rate, hsn_code,
+			   invoice_number, invoice_date, gateway, environment, tenant_id,
+			   currency_exponent, fx_rate_inr, gateway_fee, gateway_tax,
+			   net_amount, tags, notes, return_url, notify_url, activate_at,
+			   emi_details, payment_methods, payment_method_details, created_at, updated_at`
+
+// scanPayment scans a row selected with paymentColumns
+func scanPayment(row pgx.Row) (*Payment, error) {
+	var payment Payment
+	var cfOrderID *string // scheduled orders have none until they are activated
+	err := row.Scan(
+		&payment.ID, &payment.OrderID, &cfOrderID, &payment.Amount,
+		&payment.Currency, &payment.Status, &payment.PaymentMethod,
+		&payment.CustomerID, &payment.CustomerName, &payment.CustomerEmail,
+		&payment.CustomerPhone, &payment.Description, &payment.PaymentURL,
+		&payment.CFPaymentID, &payment.PaymentTime, &payment.GSTIN,
+		&payment.PlaceOfSupply, &payment.TaxRate, &payment.HSNCode,
+		&payment.InvoiceNumber, &payment.InvoiceDate, &payment.Gateway,
+		&payment.Environment, &payment.TenantID, &payment.CurrencyExponent,
+		&payment.FXRateINR, &payment.GatewayFee, &payment.GatewayTax,
+		&payment.NetAmount, &payment.Tags, &payment.Notes, &payment.ReturnURL,
+		&payment.NotifyURL, &payment.ActivateAt, &payment.EMI, &payment.PaymentMethods,
+		&payment.MethodDetails, &payment.CreatedAt, &payment.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if cfOrderID != nil {
+		payment.CFOrderID = *cfOrderID
+	}
+	return &payment, nil
+}
+
+// tenantCondition restricts a query to the context's merchant, binding the tenant ID to
+// placeholder $n. Contexts without a merchant (single-merchant mode and background work)
+// are not restricted.
+func tenantCondition(ctx context.Context, keyword string, n int) (string, []interface{}) {
+	tenantID := TenantIDFromContext(ctx)
+	if tenantID == nil {
+		return "", nil
+	}
+	return fmt.Sprintf(" %s tenant_id = $%d", keyword, n), []interface{}{*tenantID}
+}
+
+// orderTenant selects the merchant owning the order bound to placeholder $order, for a
+// record of the order to be stored under. Order IDs are unique per merchant, so the
+// order is looked up within the context's merchant, bound to placeholder $n.
+func orderTenant(ctx context.Context, order, n int) (string, []interface{}) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", n)
+	return fmt.Sprintf("(SELECT tenant_id FROM payments WHERE order_id = $%d%s)", order, tenant), tenantArgs
+}
+
+// ErrOrderExists is returned for a payment whose order ID the merchant already used
+var ErrOrderExists = errors.New("order already exists")
+
+// ErrAmbiguousOrder is returned when an order ID looked up outside any merchant's scope
+// is used by more than one merchant
+var ErrAmbiguousOrder = errors.New("order ID is used by more than one merchant")
+
 type PaymentRepository struct {
-	db *pgxpool.Pool
+	db    *pgxpool.Pool
+	clock Clock
 }
 
 func NewPaymentRepository(db *pgxpool.Pool) *PaymentRepository {
-	return &PaymentRepository{db: db}
+	return &PaymentRepository{db: db, clock: SystemClock}
+}
+
+// now returns the time records are stamped with
+func (r *PaymentRepository) now() time.Time {
+	return clockOrSystem(r.clock).Now()
 }
 
 // CreatePayment creates a new payment record
@@ -24,84 +98,144 @@ func (r *PaymentRepository) CreatePayment(ctx context.Context, payment *Payment)
 		INSERT INTO payments (
 			id, order_id, cf_order_id, amount, currency, status,
 			customer_id, customer_name, customer_email, customer_phone,
-			description, payment_url, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			description, payment_url, gstin, place_of_supply, tax_rate,
+			hsn_code, gateway, environment, tenant_id, currency_exponent,
+			fx_rate_inr, tags, notes, return_url, notify_url, activate_at,
+			payment_methods, created_at, updated_at
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+			$16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
 	`
 
-	now := time.Now()
+	now := r.now()
 	payment.ID = uuid.New()
 	payment.CreatedAt = now
 	payment.UpdatedAt = now
+	if tenantID := TenantIDFromContext(ctx); tenantID != nil {
+		payment.TenantID = tenantID
+	}
+	if payment.Gateway == "" {
+		payment.Gateway = GatewayCashfree
+	}
 
-	_, err := r.db.Exec(ctx, query,
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, query,
 		payment.ID, payment.OrderID, payment.CFOrderID, payment.Amount,
 		payment.Currency, payment.Status, payment.CustomerID, payment.CustomerName,
 		payment.CustomerEmail, payment.CustomerPhone, payment.Description,
-		payment.PaymentURL, payment.CreatedAt, payment.UpdatedAt,
+		payment.PaymentURL, payment.GSTIN, payment.PlaceOfSupply,
+		payment.TaxRate, payment.HSNCode, payment.Gateway, payment.Environment,
+		payment.TenantID, payment.CurrencyExponent, payment.FXRateINR,
+		payment.Tags, payment.Notes, payment.ReturnURL, payment.NotifyURL,
+		payment.ActivateAt, payment.PaymentMethods, payment.CreatedAt, payment.UpdatedAt,
 	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_payments_tenant_order" {
+		return fmt.Errorf("%w: %w", ErrOrderExists, err)
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.insertOrderItems(ctx, tx, payment); err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit(ctx)
 }
 
-// GetPaymentByOrderID retrieves a payment by order ID
+// GetPaymentByOrderID retrieves a payment by order ID. Outside a merchant's scope it
+// returns ErrAmbiguousOrder rather than pick one of several merchants' orders.
 func (r *PaymentRepository) GetPaymentByOrderID(ctx context.Context, orderID string) (*Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
 	query := `
-		SELECT id, order_id, cf_order_id, amount, currency, status,
-			   payment_method, customer_id, customer_name, customer_email,
-			   customer_phone, description, payment_url, cf_payment_id,
-			   payment_time, created_at, updated_at
+		SELECT ` + paymentColumns + `
 		FROM payments
-		WHERE order_id = $1
-	`
+		WHERE order_id = $1` + tenant + `
+		LIMIT 2`
 
-	var payment Payment
-	row := r.db.QueryRow(ctx, query, orderID)
+	args := append([]interface{}{orderID}, tenantArgs...)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	err := row.Scan(
-		&payment.ID, &payment.OrderID, &payment.CFOrderID, &payment.Amount,
-		&payment.Currency, &payment.Status, &payment.PaymentMethod,
-		&payment.CustomerID, &payment.CustomerName, &payment.CustomerEmail,
-		&payment.CustomerPhone, &payment.Description, &payment.PaymentURL,
-		&payment.CFPaymentID, &payment.PaymentTime, &payment.CreatedAt,
-		&payment.UpdatedAt,
-	)
+	var payment *Payment
+	for rows.Next() {
+		if payment != nil {
+			return nil, fmt.Errorf("%w: %s", ErrAmbiguousOrder, orderID)
+		}
+		if payment, err = scanPayment(rows); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return nil, fmt.Errorf("payment not found for order_id: %s", orderID)
+	}
 
+	return payment, nil
+}
+
+// GetPaymentByCFPaymentID retrieves the payment Cashfree knows by cfPaymentID
+func (r *PaymentRepository) GetPaymentByCFPaymentID(ctx context.Context, cfPaymentID string) (*Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE cf_payment_id = $1` + tenant
+
+	args := append([]interface{}{cfPaymentID}, tenantArgs...)
+	payment, err := scanPayment(r.db.QueryRow(ctx, query, args...))
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("payment not found for order_id: %s", orderID)
+			return nil, fmt.Errorf("payment not found for cf_payment_id: %s", cfPaymentID)
 		}
 		return nil, err
 	}
 
-	return &payment, nil
+	return payment, nil
 }
 
 // UpdatePaymentStatus updates payment status and related fields
-func (r *PaymentRepository) UpdatePaymentStatus(ctx context.Context, orderID, status string, cfPaymentID *string, paymentMethod *string, paymentTime *time.Time) error {
+func (r *PaymentRepository) UpdatePaymentStatus(ctx context.Context, orderID string, status domain.PaymentStatus, cfPaymentID *string, paymentMethod *string, paymentTime *time.Time) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 7)
 	query := `
 		UPDATE payments 
 		SET status = $1, cf_payment_id = $2, payment_method = $3, 
 			payment_time = $4, updated_at = $5
-		WHERE order_id = $6
-	`
+		WHERE order_id = $6` + tenant
 
-	_, err := r.db.Exec(ctx, query, status, cfPaymentID, paymentMethod, paymentTime, time.Now(), orderID)
+	args := append([]interface{}{status, cfPaymentID, paymentMethod, paymentTime, r.now(), orderID}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
 	return err
 }
 
-// GetAllPayments retrieves all payments with pagination
-func (r *PaymentRepository) GetAllPayments(ctx context.Context, limit, offset int) ([]Payment, error) {
+// GetAllPayments retrieves all payments with pagination, limited to those carrying all
+// the given tags
+func (r *PaymentRepository) GetAllPayments(ctx context.Context, limit, offset int, tags map[string]string) ([]Payment, error) {
+	args := []interface{}{limit, offset}
+	where, keyword := "", "WHERE"
+	if len(tags) > 0 {
+		args = append(args, tags)
+		where, keyword = " WHERE tags @> $3::jsonb", "AND"
+	}
+	tenant, tenantArgs := tenantCondition(ctx, keyword, len(args)+1)
 	query := `
-		SELECT id, order_id, cf_order_id, amount, currency, status,
-			   payment_method, customer_id, customer_name, customer_email,
-			   customer_phone, description, payment_url, cf_payment_id,
-			   payment_time, created_at, updated_at
-		FROM payments
+		SELECT ` + paymentColumns + `
+		FROM payments` + where + tenant + `
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
 	`
 
-	rows, err := r.db.Query(ctx, query, limit, offset)
+	args = append(args, tenantArgs...)
+	rows, err := r.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -109,19 +243,11 @@ func (r *PaymentRepository) GetAllPayments(ctx context.Context, limit, offset in
 
 	var payments []Payment
 	for rows.Next() {
-		var payment Payment
-		err := rows.Scan(
-			&payment.ID, &payment.OrderID, &payment.CFOrderID, &payment.Amount,
-			&payment.Currency, &payment.Status, &payment.PaymentMethod,
-			&payment.CustomerID, &payment.CustomerName, &payment.CustomerEmail,
-			&payment.CustomerPhone, &payment.Description, &payment.PaymentURL,
-			&payment.CFPaymentID, &payment.PaymentTime, &payment.CreatedAt,
-			&payment.UpdatedAt,
-		)
+		payment, err := scanPayment(rows)
 		if err != nil {
 			return nil, err
 		}
-		payments = append(payments, payment)
+		payments = append(payments, *payment)
 	}
 
 	return payments, rows.Err()
@@ -129,74 +255,262 @@ func (r *PaymentRepository) GetAllPayments(ctx context.Context, limit, offset in
 
 // CreateRefund creates a new refund record
 func (r *PaymentRepository) CreateRefund(ctx context.Context, refund *Refund) error {
+	now := r.now()
+	refund.ID = uuid.New()
+	refund.CreatedAt = now
+	refund.UpdatedAt = now
+
+	return insertRefund(ctx, r.db, refund)
+}
+
+// insertRefund stores a refund under the merchant of its order
+func insertRefund(ctx context.Context, db execer, refund *Refund) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 4, 14)
 	query := `
 		INSERT INTO refunds (
 			id, refund_id, cf_refund_id, order_id, cf_order_id, amount,
-			status, reason, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			status, reason, requested_by, created_at, updated_at, tenant_id, refund_speed,
+			refund_reference
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11,
+			` + orderTenant + `, COALESCE(NULLIF($12, ''), 'STANDARD'), $13)
 	`
 
-	now := time.Now()
+	args := append([]interface{}{
+		refund.ID, refund.RefundID, refund.CFRefundID, refund.OrderID,
+		refund.CFOrderID, refund.Amount, refund.Status, refund.Reason,
+		refund.RequestedBy, refund.CreatedAt, refund.UpdatedAt, refund.Speed,
+		refund.Reference,
+	}, tenantArgs...)
+	_, err := db.Exec(ctx, query, args...)
+	return err
+}
+
+// refundReleasedStatuses are the refund statuses that give the amount back to the
+// payment's refundable balance
+const refundReleasedStatuses = `('` + string(domain.RefundCancelled) + `', '` + string(domain.RefundFailed) + `', '` + string(domain.RefundRejected) + `')`
+
+// RefundBalance is how much of a payment has been refunded and how much is left
+type RefundBalance struct {
+	OrderID    string  `json:"order_id"`
+	Currency   string  `json:"currency"`
+	Amount     float64 `json:"amount"`
+	Refunded   float64 `json:"refunded"`   // refunds Cashfree completed
+	Pending    float64 `json:"pending"`    // refunds in progress or waiting for approval
+	Refundable float64 `json:"refundable"` // what is left to refund
+}
+
+// RefundLimitError is returned when a refund would take a payment's refunds over its amount
+type RefundLimitError struct {
+	Refundable float64
+}
+
+func (e *RefundLimitError) Error() string {
+	return fmt.Sprintf("refund exceeds the refundable amount of %.2f", e.Refundable)
+}
+
+// DuplicateRefundError is returned when the order already has a refund with the same
+// caller reference
+type DuplicateRefundError struct {
+	Existing *Refund
+}
+
+func (e *DuplicateRefundError) Error() string {
+	return fmt.Sprintf("order %s already has refund %s with reference %s", e.Existing.OrderID, e.Existing.RefundID, stringValue(e.Existing.Reference))
+}
+
+// GetRefundBalance returns how much of a payment is left to refund
+func (r *PaymentRepository) GetRefundBalance(ctx context.Context, orderID string) (*RefundBalance, error) {
+	return r.refundBalance(ctx, r.db, orderID, "")
+}
+
+// rowQuerier is a connection pool or a transaction
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// execer is a connection pool or a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// refundBalance computes a payment's refund balance, with lock appended to the payment
+// query
+func (r *PaymentRepository) refundBalance(ctx context.Context, db rowQuerier, orderID, lock string) (*RefundBalance, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT order_id, currency, amount
+		FROM payments
+		WHERE order_id = $1` + tenant + lock
+
+	balance := RefundBalance{}
+	err := db.QueryRow(ctx, query, append([]interface{}{orderID}, tenantArgs...)...).Scan(
+		&balance.OrderID, &balance.Currency, &balance.Amount,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("payment not found for order_id: %s", orderID)
+		}
+		return nil, err
+	}
+
+	refundQuery := `
+		SELECT COALESCE(SUM(amount) FILTER (WHERE status = 'SUCCESS'), 0),
+			   COALESCE(SUM(amount) FILTER (WHERE status <> 'SUCCESS'), 0)
+		FROM refunds
+		WHERE order_id = $1 AND status NOT IN ` + refundReleasedStatuses + tenant
+
+	err = db.QueryRow(ctx, refundQuery, append([]interface{}{orderID}, tenantArgs...)...).Scan(&balance.Refunded, &balance.Pending)
+	if err != nil {
+		return nil, err
+	}
+
+	balance.Refundable = math.Max(0, math.Round((balance.Amount-balance.Refunded-balance.Pending)*1000)/1000)
+	return &balance, nil
+}
+
+// ReserveRefund saves a refund and its vendor reversals before it is sent to the
+// gateway, returning a *RefundLimitError when it exceeds what is left of the payment
+// or a *RefundSplitError when a reversal exceeds what is left of a vendor's split. The
+// payment is locked while the balances are checked, so concurrent refunds cannot
+// together exceed them. A refund whose reference the order already has a refund for
+// is not saved; a *DuplicateRefundError returns the existing one.
+func (r *PaymentRepository) ReserveRefund(ctx context.Context, refund *Refund) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, err := r.refundBalance(ctx, tx, refund.OrderID, " FOR UPDATE")
+	if err != nil {
+		return err
+	}
+	if refund.Reference != nil {
+		existing, err := r.refundByReference(ctx, tx, refund.OrderID, *refund.Reference)
+		if err != nil && err != pgx.ErrNoRows {
+			return err
+		}
+		if existing != nil {
+			return &DuplicateRefundError{Existing: existing}
+		}
+	}
+	if refund.Amount > balance.Refundable+0.0005 {
+		return &RefundLimitError{Refundable: balance.Refundable}
+	}
+
+	now := r.now()
 	refund.ID = uuid.New()
 	refund.CreatedAt = now
 	refund.UpdatedAt = now
 
-	_, err := r.db.Exec(ctx, query,
-		refund.ID, refund.RefundID, refund.CFRefundID, refund.OrderID,
-		refund.CFOrderID, refund.Amount, refund.Status, refund.Reason,
-		refund.CreatedAt, refund.UpdatedAt,
-	)
+	if err := insertRefund(ctx, tx, refund); err != nil {
+		return err
+	}
+	if err := r.reserveRefundSplits(ctx, tx, refund); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReleaseRefund deletes a reserved refund the gateway did not accept
+func (r *PaymentRepository) ReleaseRefund(ctx context.Context, refundID string) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		DELETE FROM refunds
+		WHERE refund_id = $1 AND cf_refund_id IS NULL` + tenant
 
+	_, err := r.db.Exec(ctx, query, append([]interface{}{refundID}, tenantArgs...)...)
 	return err
 }
 
 // UpdateRefundStatus updates refund status
-func (r *PaymentRepository) UpdateRefundStatus(ctx context.Context, refundID, status string, processedAt *time.Time) error {
+func (r *PaymentRepository) UpdateRefundStatus(ctx context.Context, refundID string, status domain.RefundStatus, processedAt *time.Time) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 5)
 	query := `
 		UPDATE refunds 
 		SET status = $1, processed_at = $2, updated_at = $3
-		WHERE refund_id = $4
-	`
+		WHERE refund_id = $4` + tenant
+
+	args := append([]interface{}{status, processedAt, r.now(), refundID}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// SetRefundARN saves the mode a refund was processed at and its ARN, keeping the
+// stored values where either is empty
+func (r *PaymentRepository) SetRefundARN(ctx context.Context, refundID, mode, arn string) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 5)
+	query := `
+		UPDATE refunds
+		SET refund_mode = COALESCE(NULLIF($2, ''), refund_mode),
+			refund_arn = COALESCE(NULLIF($3, ''), refund_arn), updated_at = $4
+		WHERE refund_id = $1` + tenant
 
-	_, err := r.db.Exec(ctx, query, status, processedAt, time.Now(), refundID)
+	args := append([]interface{}{refundID, mode, arn, r.now()}, tenantArgs...)
+	_, err := r.db.Exec(ctx, query, args...)
 	return err
 }
 
 // GetRefundByID retrieves a refund by refund ID
 func (r *PaymentRepository) GetRefundByID(ctx context.Context, refundID string) (*Refund, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
 	query := `
-		SELECT id, refund_id, cf_refund_id, order_id, cf_order_id, amount,
-			   status, reason, processed_at, created_at, updated_at
+		SELECT ` + refundColumns + `
 		FROM refunds
-		WHERE refund_id = $1
-	`
+		WHERE refund_id = $1` + tenant
 
-	var refund Refund
-	row := r.db.QueryRow(ctx, query, refundID)
+	refund, err := scanRefund(r.db.QueryRow(ctx, query, append([]interface{}{refundID}, tenantArgs...)...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("refund not found for refund_id: %s", refundID)
+		}
+		return nil, err
+	}
+
+	return refund, nil
+}
 
+// refundByReference returns the order's refund with a caller reference
+func (r *PaymentRepository) refundByReference(ctx context.Context, db rowQuerier, orderID, reference string) (*Refund, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE order_id = $1 AND refund_reference = $2` + tenant
+
+	return scanRefund(db.QueryRow(ctx, query, append([]interface{}{orderID, reference}, tenantArgs...)...))
+}
+
+// refundColumns are the columns scanRefund reads. Refunds awaiting approval have no
+// Cashfree refund ID yet.
+const refundColumns = `id, refund_id, COALESCE(cf_refund_id, ''), order_id, cf_order_id, amount,
+	status, reason, refund_speed, refund_mode, refund_arn, requested_by, approved_by,
+	refund_reference, refund_type, processed_at, tenant_id, created_at, updated_at`
+
+func scanRefund(row pgx.Row) (*Refund, error) {
+	var refund Refund
 	err := row.Scan(
 		&refund.ID, &refund.RefundID, &refund.CFRefundID, &refund.OrderID,
 		&refund.CFOrderID, &refund.Amount, &refund.Status, &refund.Reason,
-		&refund.ProcessedAt, &refund.CreatedAt, &refund.UpdatedAt,
+		&refund.Speed, &refund.Mode, &refund.ARN, &refund.RequestedBy, &refund.ApprovedBy,
+		&refund.Reference, &refund.Type, &refund.ProcessedAt, &refund.TenantID,
+		&refund.CreatedAt, &refund.UpdatedAt,
 	)
-
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("refund not found for refund_id: %s", refundID)
-		}
 		return nil, err
 	}
-
 	return &refund, nil
 }
 
-// CreateSplitSettlement creates split settlement records
+// CreateSplitSettlement creates split settlement records and their fees
 func (r *PaymentRepository) CreateSplitSettlement(ctx context.Context, splits []SplitSettlement) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 2, 11)
 	query := `
 		INSERT INTO split_settlements (
 			id, order_id, cf_order_id, vendor_id, amount, percentage,
-			split_type, status, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			split_type, status, created_at, updated_at, tenant_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, ` + orderTenant + `)
 	`
 
 	tx, err := r.db.Begin(ctx)
@@ -205,21 +519,24 @@ func (r *PaymentRepository) CreateSplitSettlement(ctx context.Context, splits []
 	}
 	defer tx.Rollback(ctx)
 
-	now := time.Now()
+	now := r.now()
 	for i := range splits {
 		splits[i].ID = uuid.New()
 		splits[i].CreatedAt = now
 		splits[i].UpdatedAt = now
 
-		_, err := tx.Exec(ctx, query,
+		_, err := tx.Exec(ctx, query, append([]interface{}{
 			splits[i].ID, splits[i].OrderID, splits[i].CFOrderID,
 			splits[i].VendorID, splits[i].Amount, splits[i].Percentage,
 			splits[i].SplitType, splits[i].Status, splits[i].CreatedAt,
 			splits[i].UpdatedAt,
-		)
+		}, tenantArgs...)...)
 		if err != nil {
 			return err
 		}
+		if err := insertSplitFees(ctx, tx, &splits[i]); err != nil {
+			return err
+		}
 	}
 
 	return tx.Commit(ctx)
@@ -227,47 +544,38 @@ func (r *PaymentRepository) CreateSplitSettlement(ctx context.Context, splits []
 
 // CreateSettlement creates a settlement record
 func (r *PaymentRepository) CreateSettlement(ctx context.Context, settlement *Settlement) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 3, 11)
 	query := `
 		INSERT INTO settlements (
 			id, settlement_id, order_id, cf_order_id, amount, status,
-			utr, settled_at, created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			utr, settled_at, created_at, updated_at, tenant_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, ` + orderTenant + `)
 	`
 
-	now := time.Now()
+	now := r.now()
 	settlement.ID = uuid.New()
 	settlement.CreatedAt = now
 	settlement.UpdatedAt = now
 
-	_, err := r.db.Exec(ctx, query,
+	_, err := r.db.Exec(ctx, query, append([]interface{}{
 		settlement.ID, settlement.SettlementID, settlement.OrderID,
 		settlement.CFOrderID, settlement.Amount, settlement.Status,
 		settlement.UTR, settlement.SettledAt, settlement.CreatedAt,
 		settlement.UpdatedAt,
-	)
+	}, tenantArgs...)...)
 
 	return err
 }
 
 // GetSettlementByID retrieves a settlement by settlement ID
 func (r *PaymentRepository) GetSettlementByID(ctx context.Context, settlementID string) (*Settlement, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
 	query := `
-		SELECT id, settlement_id, order_id, cf_order_id, amount, status,
-			   utr, settled_at, created_at, updated_at
+		SELECT ` + settlementColumns + `
 		FROM settlements
-		WHERE settlement_id = $1
-	`
-
-	var settlement Settlement
-	row := r.db.QueryRow(ctx, query, settlementID)
-
-	err := row.Scan(
-		&settlement.ID, &settlement.SettlementID, &settlement.OrderID,
-		&settlement.CFOrderID, &settlement.Amount, &settlement.Status,
-		&settlement.UTR, &settlement.SettledAt, &settlement.CreatedAt,
-		&settlement.UpdatedAt,
-	)
+		WHERE settlement_id = $1` + tenant
 
+	settlement, err := scanSettlement(r.db.QueryRow(ctx, query, append([]interface{}{settlementID}, tenantArgs...)...))
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("settlement not found for settlement_id: %s", settlementID)
@@ -275,23 +583,490 @@ func (r *PaymentRepository) GetSettlementByID(ctx context.Context, settlementID
 		return nil, err
 	}
 
-	return &settlement, nil
+	return settlement, nil
+}
+
+// RecordSettlement saves the state of a settlement the gateway reported, creating the
+// record the first time the settlement is seen. The UTR and settlement time are kept
+// when a later report leaves them out. The breakdown is computed from the order's
+// charges and refunds at each report, until the settlement succeeds.
+func (r *PaymentRepository) RecordSettlement(ctx context.Context, settlement *Settlement) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 9)
+	query := `
+		INSERT INTO settlements (
+			id, settlement_id, order_id, cf_order_id, amount, status,
+			utr, settled_at, created_at, updated_at, tenant_id,
+			gross_amount, gateway_fee, gateway_tax, refund_adjustment, net_payable
+		)
+		SELECT $1, $2, order_id, cf_order_id, $4, $5, $6, $7, $8, $8, tenant_id,` + settlementBreakdownSQL + `
+		FROM payments p, LATERAL (
+			SELECT COALESCE(SUM(refunds.amount), 0) AS refunded
+			FROM refunds
+			WHERE refunds.tenant_key = p.tenant_key AND refunds.order_id = p.order_id
+			  AND refunds.status = 'SUCCESS'
+		) r
+		WHERE order_id = $3` + tenant + `
+		ON CONFLICT (settlement_id) DO UPDATE SET
+			amount = EXCLUDED.amount,
+			status = EXCLUDED.status,
+			utr = COALESCE(EXCLUDED.utr, settlements.utr),
+			settled_at = COALESCE(EXCLUDED.settled_at, settlements.settled_at),
+			updated_at = EXCLUDED.updated_at,
+			gross_amount = CASE WHEN ` + settledBreakdown + ` THEN settlements.gross_amount ELSE EXCLUDED.gross_amount END,
+			gateway_fee = CASE WHEN ` + settledBreakdown + ` THEN settlements.gateway_fee ELSE EXCLUDED.gateway_fee END,
+			gateway_tax = CASE WHEN ` + settledBreakdown + ` THEN settlements.gateway_tax ELSE EXCLUDED.gateway_tax END,
+			refund_adjustment = CASE WHEN ` + settledBreakdown + ` THEN settlements.refund_adjustment ELSE EXCLUDED.refund_adjustment END,
+			net_payable = CASE WHEN ` + settledBreakdown + ` THEN settlements.net_payable ELSE EXCLUDED.net_payable END`
+
+	args := append([]interface{}{
+		uuid.New(), settlement.SettlementID, settlement.OrderID, settlement.Amount,
+		settlement.Status, settlement.UTR, settlement.SettledAt, r.now(),
+	}, tenantArgs...)
+	tag, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("payment not found for order_id: %s", settlement.OrderID)
+	}
+	return nil
 }
 
 // CreateWebhookLog creates a webhook log entry
 func (r *PaymentRepository) CreateWebhookLog(ctx context.Context, webhook *Webhook) error {
+	orderTenant, tenantArgs := orderTenant(ctx, 3, 7)
 	query := `
-		INSERT INTO webhooks (id, event_type, order_id, payload, status, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO webhooks (id, event_type, order_id, payload, status, created_at, tenant_id)
+		VALUES ($1, $2, $3, $4, $5, $6, ` + orderTenant + `)
 	`
 
 	webhook.ID = uuid.New()
-	webhook.CreatedAt = time.Now()
+	webhook.CreatedAt = r.now()
 
-	_, err := r.db.Exec(ctx, query,
+	_, err := r.db.Exec(ctx, query, append([]interface{}{
 		webhook.ID, webhook.EventType, webhook.OrderID,
 		webhook.Payload, webhook.Status, webhook.CreatedAt,
+	}, tenantArgs...)...)
+
+	return err
+}
+
+// ListPaidPaymentsBetween retrieves successful payments paid within [from, to)
+func (r *PaymentRepository) ListPaidPaymentsBetween(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE status IN ('SUCCESS', 'PAID')
+		  AND COALESCE(payment_time, created_at) >= $1
+		  AND COALESCE(payment_time, created_at) < $2` + tenant + `
+		ORDER BY COALESCE(payment_time, created_at)
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// ListProcessedRefundsBetween retrieves successful refunds processed within [from, to)
+func (r *PaymentRepository) ListProcessedRefundsBetween(ctx context.Context, from, to time.Time) ([]Refund, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE status = 'SUCCESS'
+		  AND COALESCE(processed_at, created_at) >= $1
+		  AND COALESCE(processed_at, created_at) < $2` + tenant + `
+		ORDER BY COALESCE(processed_at, created_at)
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refunds []Refund
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// ListSettledSettlementsBetween retrieves settlements settled within [from, to)
+func (r *PaymentRepository) ListSettledSettlementsBetween(ctx context.Context, from, to time.Time) ([]Settlement, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + settlementColumns + `
+		FROM settlements
+		WHERE settled_at >= $1 AND settled_at < $2` + tenant + `
+		ORDER BY settled_at
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settlements []Settlement
+	for rows.Next() {
+		settlement, err := scanSettlement(rows)
+		if err != nil {
+			return nil, err
+		}
+		settlements = append(settlements, *settlement)
+	}
+
+	return settlements, rows.Err()
+}
+
+// AssignInvoiceNumber gives a paid payment the next invoice number in its financial
+// year. Payments that already have an invoice number keep it.
+func (r *PaymentRepository) AssignInvoiceNumber(ctx context.Context, orderID, prefix string, invoiceDate time.Time) (string, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+
+	var existing *string
+	err = tx.QueryRow(ctx, `SELECT invoice_number FROM payments WHERE order_id = $1`+tenant+` FOR UPDATE`,
+		append([]interface{}{orderID}, tenantArgs...)...).Scan(&existing)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("payment not found for order_id: %s", orderID)
+		}
+		return "", err
+	}
+	if existing != nil {
+		return *existing, nil
+	}
+
+	financialYear := FinancialYear(invoiceDate)
+
+	var number int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO invoice_sequences (financial_year, last_number)
+		VALUES ($1, 1)
+		ON CONFLICT (financial_year)
+		DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number
+	`, financialYear).Scan(&number)
+	if err != nil {
+		return "", err
+	}
+
+	invoiceNumber := FormatInvoiceNumber(prefix, financialYear, number)
+
+	tenant, tenantArgs = tenantCondition(ctx, "AND", 5)
+	_, err = tx.Exec(ctx, `
+		UPDATE payments SET invoice_number = $1, invoice_date = $2, updated_at = $3
+		WHERE order_id = $4`+tenant,
+		append([]interface{}{invoiceNumber, invoiceDate, r.now(), orderID}, tenantArgs...)...)
+	if err != nil {
+		return "", err
+	}
+
+	return invoiceNumber, tx.Commit(ctx)
+}
+
+// ListPaymentsCreatedBetween retrieves payments created within [from, to)
+func (r *PaymentRepository) ListPaymentsCreatedBetween(ctx context.Context, from, to time.Time) ([]Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE created_at >= $1 AND created_at < $2` + tenant + `
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{from, to}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// ExistingOrderIDs returns which of orderIDs have a payment record
+func (r *PaymentRepository) ExistingOrderIDs(ctx context.Context, orderIDs []string) (map[string]bool, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `SELECT order_id FROM payments WHERE order_id = ANY($1)` + tenant
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{orderIDs}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var orderID string
+		if err := rows.Scan(&orderID); err != nil {
+			return nil, err
+		}
+		existing[orderID] = true
+	}
+
+	return existing, rows.Err()
+}
+
+const reconRunColumns = `id, type, environment, period_start, period_end, status, orders_checked,
+	settlements_checked, mismatches, error, started_at, finished_at`
+
+func scanReconRun(row pgx.Row) (*ReconRun, error) {
+	var run ReconRun
+	err := row.Scan(
+		&run.ID, &run.Type, &run.Environment, &run.PeriodStart, &run.PeriodEnd, &run.Status,
+		&run.OrdersChecked, &run.SettlementsChecked, &run.Mismatches, &run.Error,
+		&run.StartedAt, &run.FinishedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
 
+// CreateReconRun records the start of a reconciliation run
+func (r *PaymentRepository) CreateReconRun(ctx context.Context, run *ReconRun) error {
+	query := `
+		INSERT INTO recon_runs (
+			id, tenant_id, type, environment, period_start, period_end, status, started_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	run.ID = uuid.New()
+	run.Status = ReconRunning
+	run.StartedAt = r.now()
+
+	_, err := r.db.Exec(ctx, query,
+		run.ID, TenantIDFromContext(ctx), run.Type, run.Environment, run.PeriodStart,
+		run.PeriodEnd, run.Status, run.StartedAt,
+	)
 	return err
 }
+
+// FinishReconRun saves the outcome of a reconciliation run and the items it found
+func (r *PaymentRepository) FinishReconRun(ctx context.Context, run *ReconRun) error {
+	itemQuery := `
+		INSERT INTO recon_items (
+			id, run_id, order_id, utr, kind, local_status, remote_status,
+			local_amount, remote_amount, gateway_charges, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	runQuery := `
+		UPDATE recon_runs
+		SET status = $2, orders_checked = $3, settlements_checked = $4, mismatches = $5,
+			error = $6, finished_at = $7
+		WHERE id = $1
+	`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	now := r.now()
+	for i := range run.Items {
+		item := &run.Items[i]
+		item.ID = uuid.New()
+		item.RunID = run.ID
+		item.CreatedAt = now
+
+		_, err := tx.Exec(ctx, itemQuery,
+			item.ID, item.RunID, item.OrderID, item.UTR, item.Kind, item.LocalStatus,
+			item.RemoteStatus, item.LocalAmount, item.RemoteAmount, item.GatewayCharges,
+			item.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	run.Mismatches = len(run.Items)
+	run.FinishedAt = &now
+	_, err = tx.Exec(ctx, runQuery,
+		run.ID, run.Status, run.OrdersChecked, run.SettlementsChecked, run.Mismatches,
+		run.Error, run.FinishedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ListReconRuns retrieves reconciliation runs, newest first
+func (r *PaymentRepository) ListReconRuns(ctx context.Context, limit, offset int) ([]ReconRun, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "WHERE", 3)
+	query := `
+		SELECT ` + reconRunColumns + `
+		FROM recon_runs` + tenant + `
+		ORDER BY started_at DESC
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{limit, offset}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ReconRun{}
+	for rows.Next() {
+		run, err := scanReconRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetReconRun retrieves a reconciliation run with its items
+func (r *PaymentRepository) GetReconRun(ctx context.Context, id uuid.UUID) (*ReconRun, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT ` + reconRunColumns + `
+		FROM recon_runs
+		WHERE id = $1` + tenant
+
+	run, err := scanReconRun(r.db.QueryRow(ctx, query, append([]interface{}{id}, tenantArgs...)...))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("recon run not found for id: %s", id)
+		}
+		return nil, err
+	}
+
+	itemQuery := `
+		SELECT id, run_id, order_id, utr, kind, local_status, remote_status,
+			   local_amount, remote_amount, gateway_charges, created_at
+		FROM recon_items
+		WHERE run_id = $1
+		ORDER BY kind, order_id, utr
+	`
+
+	rows, err := r.db.Query(ctx, itemQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item ReconItem
+		err := rows.Scan(
+			&item.ID, &item.RunID, &item.OrderID, &item.UTR, &item.Kind, &item.LocalStatus,
+			&item.RemoteStatus, &item.LocalAmount, &item.RemoteAmount, &item.GatewayCharges,
+			&item.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		run.Items = append(run.Items, item)
+	}
+
+	return run, rows.Err()
+}
+
+// ImportBankStatementEntries saves credits from a bank statement and returns how many
+// were new. Entries whose UTR was imported before are skipped.
+func (r *PaymentRepository) ImportBankStatementEntries(ctx context.Context, entries []BankStatementEntry) (int, error) {
+	query := `
+		INSERT INTO bank_statement_entries (
+			id, tenant_id, utr, amount, value_date, description, source, imported_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT DO NOTHING
+	`
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	tenantID := TenantIDFromContext(ctx)
+	now := r.now()
+	imported := 0
+	for i := range entries {
+		entry := &entries[i]
+		entry.ID = uuid.New()
+		entry.ImportedAt = now
+
+		tag, err := tx.Exec(ctx, query,
+			entry.ID, tenantID, entry.UTR, entry.Amount, entry.ValueDate,
+			entry.Description, entry.Source, entry.ImportedAt,
+		)
+		if err != nil {
+			return 0, err
+		}
+		imported += int(tag.RowsAffected())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// BankCreditsByUTR returns the total imported bank credit for each of utrs that has one
+func (r *PaymentRepository) BankCreditsByUTR(ctx context.Context, utrs []string) (map[string]float64, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT utr, SUM(amount)
+		FROM bank_statement_entries
+		WHERE utr = ANY($1)` + tenant + `
+		GROUP BY utr
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{utrs}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[string]float64)
+	for rows.Next() {
+		var utr string
+		var amount float64
+		if err := rows.Scan(&utr, &amount); err != nil {
+			return nil, err
+		}
+		credits[utr] = amount
+	}
+
+	return credits, rows.Err()
+}
@@ -0,0 +1,739 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestTenantCondition(t *testing.T) {
+	condition, args := tenantCondition(context.Background(), "AND", 2)
+	assert.Empty(t, condition)
+	assert.Empty(t, args)
+
+	merchant := &Merchant{ID: uuid.New()}
+	condition, args = tenantCondition(WithMerchant(context.Background(), merchant), "WHERE", 3)
+	assert.Equal(t, " WHERE tenant_id = $3", condition)
+	assert.Equal(t, []interface{}{merchant.ID}, args)
+}
+
+// testDB connects to TEST_DATABASE_URL and gives the test a schema of its own with
+// migrations.sql applied, dropped again when the test ends. Tests that need a database
+// are skipped without it, except in CI, where a missing database fails them instead.
+func testDB(t testing.TB) *pgxpool.Pool {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_DATABASE_URL must be set in CI")
+		}
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	ctx := context.Background()
+
+	admin, err := pgxpool.New(ctx, url)
+	require.NoError(t, err)
+	t.Cleanup(admin.Close)
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if _, err := admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Logf("dropping schema %s: %v", schema, err)
+		}
+	})
+
+	config, err := pgxpool.ParseConfig(url)
+	require.NoError(t, err)
+	// public stays on the path for extensions such as uuid-ossp
+	config.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	migrations, err := os.ReadFile("migrations.sql")
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, string(migrations))
+	require.NoError(t, err, "applying migrations.sql")
+
+	return pool
+}
+
+func TestMigrationsHaveStatusConstraints(t *testing.T) {
+	migrations, err := os.ReadFile("migrations.sql")
+	require.NoError(t, err)
+	assert.Contains(t, string(migrations), domain.ConstraintsSQL(), "regenerate with `go run . constraints` and replace the block in migrations.sql")
+	header, _, _ := strings.Cut(domain.ConstraintsSQL(), "\n")
+	assert.Equal(t, 1, strings.Count(string(migrations), header), "migrations.sql should define the status constraints once")
+}
+
+// testPayment returns an unsaved payment for a new order
+func testPayment(orderID string) *Payment {
+	return &Payment{
+		OrderID:          orderID,
+		CFOrderID:        "cf_" + orderID,
+		Amount:           100,
+		Currency:         "INR",
+		Status:           "ACTIVE",
+		CustomerID:       "customer_001",
+		CustomerName:     "John Doe",
+		CustomerEmail:    "john.doe@example.com",
+		CustomerPhone:    "+919876543210",
+		CurrencyExponent: 2,
+	}
+}
+
+func TestPaymentRepositoryPayments(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	rate := 83.25
+	payment := testPayment("order_1")
+	payment.Amount = 12.5
+	payment.Currency = "USD"
+	payment.FXRateINR = &rate
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+	assert.NotEqual(t, uuid.Nil, payment.ID)
+	assert.Equal(t, GatewayCashfree, payment.Gateway)
+	assert.Nil(t, payment.TenantID)
+
+	stored, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, payment.ID, stored.ID)
+	assert.Equal(t, 12.5, stored.Amount)
+	assert.Equal(t, "USD", stored.Currency)
+	assert.Equal(t, domain.PaymentActive, stored.Status)
+	assert.Equal(t, 2, stored.CurrencyExponent)
+	require.NotNil(t, stored.FXRateINR)
+	assert.Equal(t, 83.25, *stored.FXRateINR)
+	assert.Nil(t, stored.PaymentTime)
+
+	paidAt := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	cfPaymentID, method := "cf_payment_1", "upi"
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_1", "SUCCESS", &cfPaymentID, &method, &paidAt))
+
+	stored, err = repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentSuccess, stored.Status)
+	assert.Equal(t, &cfPaymentID, stored.CFPaymentID)
+	assert.Equal(t, &method, stored.PaymentMethod)
+	require.NotNil(t, stored.PaymentTime)
+	assert.True(t, paidAt.Equal(*stored.PaymentTime))
+
+	_, err = repo.GetPaymentByOrderID(ctx, "missing")
+	assert.EqualError(t, err, "payment not found for order_id: missing")
+
+	// Updating an unknown order is not an error
+	assert.NoError(t, repo.UpdatePaymentStatus(ctx, "missing", "SUCCESS", nil, nil, nil))
+}
+
+func TestPaymentRepositoryConflicts(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	// Order IDs and gateway order IDs are unique
+	var pgErr *pgconn.PgError
+	err := repo.CreatePayment(ctx, testPayment("order_1"))
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23505", pgErr.Code)
+	assert.ErrorIs(t, err, ErrOrderExists)
+
+	duplicate := testPayment("order_2")
+	duplicate.CFOrderID = "cf_order_1"
+	err = repo.CreatePayment(ctx, duplicate)
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23505", pgErr.Code)
+
+	// Refund IDs are unique, and refunds need an existing payment
+	refund := &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 10, Status: "PENDING"}
+	require.NoError(t, repo.CreateRefund(ctx, refund))
+	err = repo.CreateRefund(ctx, &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 10, Status: "PENDING"})
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23505", pgErr.Code)
+
+	err = repo.CreateRefund(ctx, &Refund{RefundID: "refund_3", CFRefundID: "cf_refund_3", OrderID: "missing", CFOrderID: "cf_missing", Amount: 10, Status: "PENDING"})
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23503", pgErr.Code)
+
+	// Settlement IDs are unique
+	settlement := &Settlement{SettlementID: "settlement_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 90, Status: "PENDING"}
+	require.NoError(t, repo.CreateSettlement(ctx, settlement))
+	err = repo.CreateSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 90, Status: "PENDING"})
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23505", pgErr.Code)
+}
+
+func TestPaymentRepositoryRefunds(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	reason := "Customer request"
+	refund := &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 25.5, Status: "PENDING", Reason: &reason}
+	require.NoError(t, repo.CreateRefund(ctx, refund))
+	assert.NotEqual(t, uuid.Nil, refund.ID)
+
+	stored, err := repo.GetRefundByID(ctx, "refund_1")
+	require.NoError(t, err)
+	assert.Equal(t, refund.ID, stored.ID)
+	assert.Equal(t, 25.5, stored.Amount)
+	assert.Equal(t, domain.RefundPending, stored.Status)
+	assert.Equal(t, &reason, stored.Reason)
+	assert.Equal(t, RefundSpeedStandard, stored.Speed)
+	assert.Nil(t, stored.Mode)
+	assert.Nil(t, stored.ProcessedAt)
+
+	// The gateway accepts the refund at a mode, and its ARN follows once it is processed
+	require.NoError(t, repo.SetRefundGatewayResult(ctx, "refund_1", &CashfreeRefundResponse{
+		CFRefundID:   "cf_refund_1",
+		RefundStatus: "PENDING",
+		RefundSpeed:  &CashfreeRefundSpeed{Requested: "INSTANT", Accepted: "STANDARD"},
+	}))
+	processedAt := time.Date(2024, 5, 2, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.UpdateRefundStatus(ctx, "refund_1", "SUCCESS", &processedAt))
+	require.NoError(t, repo.SetRefundARN(ctx, "refund_1", "", "205907014017"))
+	stored, err = repo.GetRefundByID(ctx, "refund_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundSuccess, stored.Status)
+	require.NotNil(t, stored.ProcessedAt)
+	assert.True(t, processedAt.Equal(*stored.ProcessedAt))
+	require.NotNil(t, stored.Mode)
+	assert.Equal(t, RefundSpeedStandard, *stored.Mode)
+	require.NotNil(t, stored.ARN)
+	assert.Equal(t, "205907014017", *stored.ARN)
+
+	_, err = repo.GetRefundByID(ctx, "missing")
+	assert.EqualError(t, err, "refund not found for refund_id: missing")
+
+	// Only successful refunds processed within the range are listed
+	require.NoError(t, repo.CreateRefund(ctx, &Refund{RefundID: "refund_2", CFRefundID: "cf_refund_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 5, Status: "PENDING"}))
+	refunds, err := repo.ListProcessedRefundsBetween(ctx, processedAt, processedAt.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, "refund_1", refunds[0].RefundID)
+
+	refunds, err = repo.ListProcessedRefundsBetween(ctx, processedAt.Add(time.Second), processedAt.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, refunds)
+}
+
+func TestPaymentRepositoryRefundBalance(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	require.NoError(t, repo.CreateRefund(ctx, &Refund{RefundID: "refund_done", CFRefundID: "cf_refund_done", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 30, Status: "SUCCESS"}))
+	require.NoError(t, repo.CreateRefund(ctx, &Refund{RefundID: "refund_cancelled", CFRefundID: "cf_refund_cancelled", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 50, Status: "CANCELLED"}))
+	require.NoError(t, repo.ReserveRefund(ctx, &Refund{RefundID: "refund_pending", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 20, Status: "PENDING"}))
+
+	balance, err := repo.GetRefundBalance(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, RefundBalance{OrderID: "order_1", Currency: "INR", Amount: 100, Refunded: 30, Pending: 20, Refundable: 50}, *balance)
+
+	err = repo.ReserveRefund(ctx, &Refund{RefundID: "refund_too_much", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 50.01, Status: "PENDING"})
+	var limitErr *RefundLimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, 50.0, limitErr.Refundable)
+
+	// A reservation the gateway did not accept gives its amount back
+	require.NoError(t, repo.ReleaseRefund(ctx, "refund_pending"))
+	balance, err = repo.GetRefundBalance(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, 70.0, balance.Refundable)
+
+	// Concurrent refunds cannot together exceed the payment
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.ReserveRefund(ctx, &Refund{RefundID: fmt.Sprintf("refund_race_%d", i), OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 20, Status: "PENDING"})
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+				return
+			}
+			var raceErr *RefundLimitError
+			assert.ErrorAs(t, err, &raceErr)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, reserved)
+
+	balance, err = repo.GetRefundBalance(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, 10.0, balance.Refundable)
+
+	_, err = repo.GetRefundBalance(ctx, "missing")
+	assert.EqualError(t, err, "payment not found for order_id: missing")
+}
+
+func TestPaymentRepositorySettlements(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	utr := "UTR0001"
+	settledAt := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	settlement := &Settlement{SettlementID: "settlement_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 98, Status: "SUCCESS", UTR: &utr, SettledAt: &settledAt}
+	require.NoError(t, repo.CreateSettlement(ctx, settlement))
+	require.NoError(t, repo.CreateSettlement(ctx, &Settlement{SettlementID: "settlement_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 2, Status: "PENDING"}))
+
+	stored, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	assert.Equal(t, settlement.ID, stored.ID)
+	assert.Equal(t, 98.0, stored.Amount)
+	assert.Equal(t, &utr, stored.UTR)
+	require.NotNil(t, stored.SettledAt)
+	assert.True(t, settledAt.Equal(*stored.SettledAt))
+
+	_, err = repo.GetSettlementByID(ctx, "missing")
+	assert.EqualError(t, err, "settlement not found for settlement_id: missing")
+
+	// Settlements without a settlement time are not listed
+	settlements, err := repo.ListSettledSettlementsBetween(ctx, settledAt.Add(-time.Hour), settledAt.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, settlements, 1)
+	assert.Equal(t, "settlement_1", settlements[0].SettlementID)
+}
+
+func TestPaymentRepositoryRecordSettlement(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	// The first report creates the settlement with the payment's gateway order ID
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 98, Status: "PENDING"}))
+	stored, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	assert.Equal(t, "cf_order_1", stored.CFOrderID)
+	assert.Equal(t, domain.SettlementPending, stored.Status)
+	assert.Nil(t, stored.UTR)
+
+	utr := "UTR0001"
+	settledAt := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 98, Status: "SUCCESS", UTR: &utr, SettledAt: &settledAt}))
+
+	// A later report without the UTR keeps it
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 98, Status: "SUCCESS"}))
+	updated, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	assert.Equal(t, stored.ID, updated.ID)
+	assert.Equal(t, domain.SettlementSuccess, updated.Status)
+	assert.Equal(t, &utr, updated.UTR)
+	require.NotNil(t, updated.SettledAt)
+	assert.True(t, settledAt.Equal(*updated.SettledAt))
+
+	err = repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_2", OrderID: "missing", Status: "PENDING"})
+	assert.EqualError(t, err, "payment not found for order_id: missing")
+}
+
+func TestPaymentRepositorySplitSettlementTransaction(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	countSplits := func() int {
+		var count int
+		require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM split_settlements WHERE order_id = 'order_1'`).Scan(&count))
+		return count
+	}
+
+	percentage := 40.0
+	splits := []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_1", Amount: 40, Percentage: &percentage, SplitType: "PERCENTAGE", Status: "PENDING"},
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_2", Amount: 60, SplitType: "AMOUNT", Status: "PENDING"},
+	}
+	require.NoError(t, repo.CreateSplitSettlement(ctx, splits))
+	assert.NotEqual(t, uuid.Nil, splits[0].ID)
+	assert.NotEqual(t, uuid.Nil, splits[1].ID)
+	assert.Equal(t, 2, countSplits())
+
+	// A split that violates the split_type check rolls back the whole batch
+	invalid := []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_3", Amount: 10, SplitType: "AMOUNT", Status: "PENDING"},
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_4", Amount: 10, SplitType: "SHARE", Status: "PENDING"},
+	}
+	var pgErr *pgconn.PgError
+	err := repo.CreateSplitSettlement(ctx, invalid)
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, "23514", pgErr.Code)
+	assert.Equal(t, 2, countSplits())
+}
+
+func TestPaymentRepositoryWebhookLog(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	orderID := "order_1"
+	webhook := &Webhook{EventType: "PAYMENT_SUCCESS_WEBHOOK", OrderID: &orderID, Payload: `{"type":"PAYMENT_SUCCESS_WEBHOOK"}`, Status: "RECEIVED"}
+	require.NoError(t, repo.CreateWebhookLog(ctx, webhook))
+	assert.NotEqual(t, uuid.Nil, webhook.ID)
+
+	// Webhooks for unknown orders are still logged
+	require.NoError(t, repo.CreateWebhookLog(ctx, &Webhook{EventType: "UNKNOWN", Payload: `{}`, Status: "RECEIVED"}))
+
+	var count int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks`).Scan(&count))
+	assert.Equal(t, 2, count)
+
+	// The payload must be JSON
+	assert.Error(t, repo.CreateWebhookLog(ctx, &Webhook{EventType: "BAD", Payload: "not json", Status: "RECEIVED"}))
+}
+
+func TestPaymentRepositoryPagination(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, repo.CreatePayment(ctx, testPayment(fmt.Sprintf("order_%d", i))))
+	}
+
+	// Newest first
+	page, err := repo.GetAllPayments(ctx, 2, 0, nil)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "order_5", page[0].OrderID)
+	assert.Equal(t, "order_4", page[1].OrderID)
+
+	page, err = repo.GetAllPayments(ctx, 2, 2, nil)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "order_3", page[0].OrderID)
+	assert.Equal(t, "order_2", page[1].OrderID)
+
+	page, err = repo.GetAllPayments(ctx, 2, 4, nil)
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "order_1", page[0].OrderID)
+
+	page, err = repo.GetAllPayments(ctx, 2, 6, nil)
+	require.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+func TestPaymentRepositoryDateRanges(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	for _, orderID := range []string{"order_paid", "order_success", "order_active"} {
+		require.NoError(t, repo.CreatePayment(ctx, testPayment(orderID)))
+	}
+	paidAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_paid", "PAID", nil, nil, &paidAt))
+	successAt := paidAt.Add(time.Hour)
+	require.NoError(t, repo.UpdatePaymentStatus(ctx, "order_success", "SUCCESS", nil, nil, &successAt))
+
+	// Paid payments are listed by payment time, in order
+	payments, err := repo.ListPaidPaymentsBetween(ctx, paidAt, paidAt.Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	assert.Equal(t, "order_paid", payments[0].OrderID)
+	assert.Equal(t, "order_success", payments[1].OrderID)
+
+	// The range excludes its end
+	payments, err = repo.ListPaidPaymentsBetween(ctx, paidAt, successAt)
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, "order_paid", payments[0].OrderID)
+
+	// Created payments are listed by creation time whatever their status
+	now := time.Now()
+	payments, err = repo.ListPaymentsCreatedBetween(ctx, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, payments, 3)
+
+	payments, err = repo.ListPaymentsCreatedBetween(ctx, now.Add(time.Minute), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, payments)
+}
+
+func TestPaymentRepositoryAssignInvoiceNumber(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	for _, orderID := range []string{"order_1", "order_2", "order_3"} {
+		require.NoError(t, repo.CreatePayment(ctx, testPayment(orderID)))
+	}
+
+	invoiceDate := time.Date(2024, 5, 1, 10, 0, 0, 0, istLocation)
+	first, err := repo.AssignInvoiceNumber(ctx, "order_1", "INV", invoiceDate)
+	require.NoError(t, err)
+	assert.Equal(t, FormatInvoiceNumber("INV", "2024-25", 1), first)
+
+	// A payment keeps its invoice number
+	again, err := repo.AssignInvoiceNumber(ctx, "order_1", "INV", invoiceDate.AddDate(0, 1, 0))
+	require.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	second, err := repo.AssignInvoiceNumber(ctx, "order_2", "INV", invoiceDate)
+	require.NoError(t, err)
+	assert.Equal(t, FormatInvoiceNumber("INV", "2024-25", 2), second)
+
+	// Numbering restarts each financial year
+	nextYear, err := repo.AssignInvoiceNumber(ctx, "order_3", "INV", time.Date(2025, 4, 1, 10, 0, 0, 0, istLocation))
+	require.NoError(t, err)
+	assert.Equal(t, FormatInvoiceNumber("INV", "2025-26", 1), nextYear)
+
+	stored, err := repo.GetPaymentByOrderID(ctx, "order_2")
+	require.NoError(t, err)
+	require.NotNil(t, stored.InvoiceNumber)
+	assert.Equal(t, second, *stored.InvoiceNumber)
+	require.NotNil(t, stored.InvoiceDate)
+	assert.True(t, invoiceDate.Equal(*stored.InvoiceDate))
+
+	_, err = repo.AssignInvoiceNumber(ctx, "missing", "INV", invoiceDate)
+	assert.EqualError(t, err, "payment not found for order_id: missing")
+}
+
+func TestPaymentRepositoryConcurrentInvoiceNumbers(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+
+	const orders = 10
+	for i := 0; i < orders; i++ {
+		require.NoError(t, repo.CreatePayment(ctx, testPayment(fmt.Sprintf("order_%d", i))))
+	}
+
+	// Concurrent assignments take distinct numbers from the same sequence
+	invoiceDate := time.Date(2024, 5, 1, 10, 0, 0, 0, istLocation)
+	numbers := make([]string, orders)
+	errs := make([]error, orders)
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			numbers[i], errs[i] = repo.AssignInvoiceNumber(ctx, fmt.Sprintf("order_%d", i), "INV", invoiceDate)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for i := 0; i < orders; i++ {
+		require.NoError(t, errs[i])
+		assert.False(t, seen[numbers[i]], "duplicate invoice number %s", numbers[i])
+		seen[numbers[i]] = true
+	}
+	for n := 1; n <= orders; n++ {
+		assert.True(t, seen[FormatInvoiceNumber("INV", "2024-25", n)])
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	merchants := NewMerchantRepository(db, box)
+	repo := NewPaymentRepository(db)
+
+	newMerchant := func(name string) *Merchant {
+		apiKey, err := NewMerchantAPIKey()
+		require.NoError(t, err)
+		merchant := &Merchant{Name: name, CFClientID: "id", CFSecret: "secret", Environment: "TEST"}
+		require.NoError(t, merchants.CreateMerchant(ctx, merchant, apiKey))
+		return merchant
+	}
+	owner := newMerchant("Owner")
+	other := newMerchant("Other")
+	ownerCtx := WithMerchant(ctx, owner)
+	otherCtx := WithMerchant(ctx, other)
+
+	orderID := "tenant_test_" + uuid.NewString()
+	payment := &Payment{
+		OrderID:       orderID,
+		CFOrderID:     "cf_" + orderID,
+		Amount:        100,
+		Currency:      "INR",
+		Status:        "PAID",
+		CustomerID:    "customer_001",
+		CustomerName:  "John Doe",
+		CustomerEmail: "john.doe@example.com",
+		CustomerPhone: "+919876543210",
+	}
+	require.NoError(t, repo.CreatePayment(ownerCtx, payment))
+	require.Equal(t, owner.ID, *payment.TenantID)
+
+	_, err = repo.GetPaymentByOrderID(ownerCtx, orderID)
+	require.NoError(t, err)
+
+	// The other merchant cannot read, list or update the payment
+	_, err = repo.GetPaymentByOrderID(otherCtx, orderID)
+	assert.Error(t, err)
+
+	payments, err := repo.GetAllPayments(otherCtx, 100, 0, nil)
+	require.NoError(t, err)
+	for _, p := range payments {
+		assert.NotEqual(t, orderID, p.OrderID)
+	}
+
+	require.NoError(t, repo.UpdatePaymentStatus(otherCtx, orderID, "CANCELLED", nil, nil, nil))
+	stored, err := repo.GetPaymentByOrderID(ownerCtx, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, stored.Status)
+
+	_, err = repo.AssignInvoiceNumber(otherCtx, orderID, "INV", time.Now())
+	assert.Error(t, err)
+
+	// Refunds are owned by the payment's merchant
+	refund := &Refund{
+		RefundID:   "refund_" + orderID,
+		CFRefundID: "cf_refund_" + orderID,
+		OrderID:    orderID,
+		CFOrderID:  payment.CFOrderID,
+		Amount:     10,
+		Status:     "PENDING",
+	}
+	require.NoError(t, repo.CreateRefund(ownerCtx, refund))
+
+	_, err = repo.GetRefundByID(otherCtx, refund.RefundID)
+	assert.Error(t, err)
+	_, err = repo.GetRefundByID(ownerCtx, refund.RefundID)
+	assert.NoError(t, err)
+
+	// Nor can the other merchant refund it through the API
+	gin.SetMode(gin.TestMode)
+	handler := &PaymentHandler{repo: repo}
+	r := gin.New()
+	r.POST("/payments/:order_id/refund", func(c *gin.Context) {
+		c.Set(merchantGinKey, other)
+		handler.RefundPayment(c)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/payments/"+orderID+"/refund", bytes.NewBufferString(`{"amount": 10}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestOrderIDsAreUniquePerMerchant(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	merchants := NewMerchantRepository(db, box)
+	repo := NewPaymentRepository(db)
+
+	newMerchant := func(name string) *Merchant {
+		apiKey, err := NewMerchantAPIKey()
+		require.NoError(t, err)
+		merchant := &Merchant{Name: name, CFClientID: "id", CFSecret: "secret", Environment: "TEST"}
+		require.NoError(t, merchants.CreateMerchant(ctx, merchant, apiKey))
+		return merchant
+	}
+	first := WithMerchant(ctx, newMerchant("First"))
+	second := WithMerchant(ctx, newMerchant("Second"))
+
+	// Both merchants may use an order ID, but each only once
+	orderID := "shared_" + uuid.NewString()
+	firstPayment := testPayment(orderID)
+	require.NoError(t, repo.CreatePayment(first, firstPayment))
+	secondPayment := testPayment(orderID)
+	secondPayment.CFOrderID = "cf_second_" + orderID
+	secondPayment.Amount = 200
+	require.NoError(t, repo.CreatePayment(second, secondPayment))
+	again := testPayment(orderID)
+	again.CFOrderID = "cf_again_" + orderID
+	assert.ErrorIs(t, repo.CreatePayment(first, again), ErrOrderExists)
+
+	// Each merchant sees its own order; unscoped lookups cannot pick one
+	stored, err := repo.GetPaymentByOrderID(second, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, 200.0, stored.Amount)
+	_, err = repo.GetPaymentByOrderID(ctx, orderID)
+	assert.ErrorIs(t, err, ErrAmbiguousOrder)
+	stored, err = repo.GetPaymentByOrderID(WithTenantID(ctx, firstPayment.TenantID), orderID)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, stored.Amount)
+
+	// Records of the order belong to the merchant that stored them
+	refund := &Refund{RefundID: "refund_" + orderID, OrderID: orderID, CFOrderID: secondPayment.CFOrderID, Amount: 50, Status: "PENDING"}
+	require.NoError(t, repo.CreateRefund(second, refund))
+	firstRefundable, err := repo.GetRefundBalance(first, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, firstRefundable.Refundable)
+	secondRefundable, err := repo.GetRefundBalance(second, orderID)
+	require.NoError(t, err)
+	assert.Equal(t, 150.0, secondRefundable.Refundable)
+
+	// Each merchant's order has its own event stream
+	require.NoError(t, repo.AppendDomainEvent(first, orderID, "order.noted", map[string]string{}))
+	events, err := repo.ListOrderEvents(first, orderID)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, []int{1, 2}, []int{events[0].OrderSequence, events[1].OrderSequence})
+	events, err = repo.ListOrderEvents(second, orderID)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "refund.requested", events[1].Type)
+}
+
+func TestMerchantWebhookSecrets(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	box, err := NewSecretBox(testEncryptionKey('k'))
+	require.NoError(t, err)
+	merchants := NewMerchantRepository(db, box)
+
+	apiKey, err := NewMerchantAPIKey()
+	require.NoError(t, err)
+	merchant := &Merchant{Name: "Webhooks", CFClientID: "id", CFSecret: "client_secret", Environment: "TEST"}
+	require.NoError(t, merchants.CreateMerchant(ctx, merchant, apiKey))
+
+	secrets, err := merchants.WebhookSecrets(ctx, merchant)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"client_secret"}, secrets)
+
+	secretID, err := merchants.AddWebhookSecret(ctx, merchant.ID, "whsec_"+merchant.ID.String())
+	require.NoError(t, err)
+	secrets, err = merchants.WebhookSecrets(ctx, merchant)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"whsec_" + merchant.ID.String()}, secrets)
+
+	// The legacy route finds the merchant by its secret
+	handler := &PaymentHandler{cashfree: NewCashfreeClient("", "", "TEST"), merchants: merchants}
+	payload := `{"type":"PAYMENT_SUCCESS_WEBHOOK"}`
+	signature := signWebhook("whsec_"+merchant.ID.String(), "1640995200", payload)
+	signer, verified := handler.verifyWebhook(ctx, nil, signature, "1640995200", payload)
+	require.True(t, verified)
+	assert.Equal(t, merchant.ID, signer.ID)
+
+	require.NoError(t, merchants.RevokeWebhookSecret(ctx, merchant.ID, secretID))
+	_, verified = handler.verifyWebhook(ctx, merchant, signature, "1640995200", payload)
+	assert.False(t, verified)
+}
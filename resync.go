@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// Bounds of a resync, which calls the gateway once per order
+const (
+	defaultResyncLimit = 100
+	maxResyncOrders    = 1000
+	defaultResyncRate  = 5.0 // orders per second
+	maxResyncRate      = 50.0
+)
+
+// ResyncRequest selects the orders to re-fetch from the gateway, either by ID or by
+// local status
+type ResyncRequest struct {
+	OrderIDs      []string             `json:"order_ids"`
+	Status        domain.PaymentStatus `json:"status"`
+	Limit         int                  `json:"limit"`           // orders with Status to resync, least recently updated first
+	RatePerSecond float64              `json:"rate_per_second"` // gateway calls per second
+}
+
+// validate checks the request and applies defaults
+func (req *ResyncRequest) validate() error {
+	if (len(req.OrderIDs) == 0) == (req.Status == "") {
+		return errors.New("either order_ids or status is required")
+	}
+	if len(req.OrderIDs) > maxResyncOrders {
+		return fmt.Errorf("at most %d orders can be resynced at once", maxResyncOrders)
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultResyncLimit
+	}
+	if req.Limit > maxResyncOrders {
+		return fmt.Errorf("limit must be at most %d", maxResyncOrders)
+	}
+	if req.RatePerSecond <= 0 {
+		req.RatePerSecond = defaultResyncRate
+	}
+	if req.RatePerSecond > maxResyncRate {
+		return fmt.Errorf("rate_per_second must be at most %g", maxResyncRate)
+	}
+	if req.Status != "" {
+		status, err := domain.ParsePaymentStatus(string(req.Status))
+		if err != nil {
+			return err
+		}
+		req.Status = status
+	}
+	return nil
+}
+
+// ResyncChange is an order whose status was updated from the gateway
+type ResyncChange struct {
+	OrderID string               `json:"order_id"`
+	From    domain.PaymentStatus `json:"from"`
+	To      domain.PaymentStatus `json:"to"`
+}
+
+// ResyncResult summarizes a resync
+type ResyncResult struct {
+	Checked int               `json:"checked"`          // orders looked up at the gateway
+	Skipped int               `json:"skipped"`          // orders not created at the gateway yet
+	Updated []ResyncChange    `json:"updated"`          // orders whose status changed
+	Failed  map[string]string `json:"failed,omitempty"` // error by order ID
+}
+
+// ListPaymentsByStatus retrieves up to limit payments with a status, least recently
+// updated first
+func (r *PaymentRepository) ListPaymentsByStatus(ctx context.Context, status domain.PaymentStatus, limit int) ([]Payment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE status = $1` + tenant + `
+		ORDER BY updated_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{status, limit}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// resync re-fetches the selected orders from their gateway at the requested rate and
+// applies status changes. It stops early, with the result so far, when ctx is done.
+func (h *PaymentHandler) resync(ctx context.Context, req ResyncRequest) (*ResyncResult, error) {
+	result := &ResyncResult{Updated: []ResyncChange{}}
+	fail := func(orderID string, err error) {
+		if result.Failed == nil {
+			result.Failed = make(map[string]string)
+		}
+		result.Failed[orderID] = err.Error()
+	}
+
+	var payments []Payment
+	if req.Status != "" {
+		var err error
+		payments, err = h.repo.ListPaymentsByStatus(ctx, req.Status, req.Limit)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for _, orderID := range req.OrderIDs {
+			payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+			if err != nil {
+				fail(orderID, errors.New("payment not found"))
+				continue
+			}
+			payments = append(payments, *payment)
+		}
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / req.RatePerSecond))
+	defer ticker.Stop()
+
+	for i := range payments {
+		payment := &payments[i]
+
+		// Scheduled orders and those held for review do not exist at the gateway yet
+		if payment.Status == domain.PaymentScheduled || payment.Status == domain.PaymentActivating || payment.Status == domain.PaymentPendingReview {
+			result.Skipped++
+			continue
+		}
+
+		if result.Checked > 0 {
+			select {
+			case <-ctx.Done():
+				return result, ctx.Err()
+			case <-ticker.C:
+			}
+		}
+		result.Checked++
+
+		status, err := h.resyncPayment(ctx, payment)
+		if err != nil {
+			fail(payment.OrderID, err)
+			continue
+		}
+		if status != payment.Status {
+			result.Updated = append(result.Updated, ResyncChange{OrderID: payment.OrderID, From: payment.Status, To: status})
+		}
+	}
+
+	return result, nil
+}
+
+// resyncPayment fetches an order's status from its gateway and applies it as
+// verification does, returning the status the payment is left with. Statuses meaning
+// the same to the gateway, such as SUCCESS and PAID, are left alone.
+func (h *PaymentHandler) resyncPayment(ctx context.Context, payment *Payment) (domain.PaymentStatus, error) {
+	// Jobs resync payments of every merchant; what changes belongs to this one's
+	ctx = WithTenantID(ctx, payment.TenantID)
+	gateway, err := h.gatewayFor(ctx, payment)
+	if err != nil {
+		return "", err
+	}
+	gateway = forBatch(gateway)
+
+	orderStatus, err := gateway.GetOrderStatus(payment.OrderID)
+	if err != nil {
+		return "", err
+	}
+	status := orderStatus.OrderStatus
+	if reconStatus(payment.Status) == reconStatus(status) || keepsLocalStatus(payment.Status, status) {
+		return payment.Status, nil
+	}
+
+	if status == domain.PaymentPaid {
+		details, err := h.orderPayment(ctx, gateway, orderStatus)
+		if err != nil {
+			return "", err
+		}
+		status = h.paidStatus(ctx, payment.OrderID, details.CFPaymentID, details.PaymentAmount, status)
+		err = h.repo.UpdatePaymentStatus(ctx, payment.OrderID, status, &details.CFPaymentID, &details.PaymentMethod, &details.PaymentTime)
+		if err != nil {
+			return "", err
+		}
+		h.recordPaymentCharges(ctx, payment.OrderID, details.PaymentAmount, details.PaymentCharges)
+		h.recordPaymentEMI(ctx, payment.OrderID, details.EMI)
+		h.recordPaymentMethodDetails(ctx, payment.OrderID, details.MethodDetails)
+		if status == domain.PaymentAmountMismatch {
+			return status, nil
+		}
+		h.issueInvoice(ctx, payment.OrderID)
+		h.publishPaymentEvent(ctx, events.PaymentSucceeded, payment.OrderID)
+		return status, nil
+	}
+
+	err = h.repo.UpdatePaymentStatus(ctx, payment.OrderID, status, payment.CFPaymentID, payment.PaymentMethod, payment.PaymentTime)
+	if err != nil {
+		return "", err
+	}
+	if status == domain.PaymentTerminated || status == domain.PaymentTerminationRequested {
+		h.publishPaymentEvent(ctx, events.PaymentTerminated, payment.OrderID)
+	}
+	return status, nil
+}
+
+// ResyncOrders re-fetches orders of every merchant from their gateway, by ID or by
+// local status, and applies what changed
+func (h *PaymentHandler) ResyncOrders(c *gin.Context) {
+	var req ResyncRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Minute)
+	defer cancel()
+
+	result, err := h.resync(ctx, req)
+	if err != nil {
+		log.Printf("Resync failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Resync failed: " + err.Error(), "result": result})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// runResync implements the resync command, which re-fetches the orders given as
+// arguments, or those with -status, from their gateway
+func runResync(args []string, handler *PaymentHandler) error {
+	flags := flag.NewFlagSet("resync", flag.ContinueOnError)
+	status := flags.String("status", "", "resync orders with this local status instead of the given order IDs")
+	limit := flags.Int("limit", defaultResyncLimit, "number of orders with -status to resync")
+	rate := flags.Float64("rate", defaultResyncRate, "gateway calls per second")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	req := ResyncRequest{OrderIDs: flags.Args(), Status: domain.PaymentStatus(*status), Limit: *limit, RatePerSecond: *rate}
+	if err := req.validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Hour)
+	defer cancel()
+
+	result, err := handler.resync(ctx, req)
+	if result != nil {
+		fmt.Printf("Checked %d orders, skipped %d, updated %d\n", result.Checked, result.Skipped, len(result.Updated))
+		for _, change := range result.Updated {
+			fmt.Printf("%s: %s -> %s\n", change.OrderID, change.From, change.To)
+		}
+		for orderID, reason := range result.Failed {
+			fmt.Printf("Failed to resync %s: %s\n", orderID, reason)
+		}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestResyncRequestValidate(t *testing.T) {
+	req := ResyncRequest{Status: "active"}
+	require.NoError(t, req.validate())
+	assert.Equal(t, domain.PaymentActive, req.Status)
+	assert.Equal(t, defaultResyncLimit, req.Limit)
+	assert.Equal(t, defaultResyncRate, req.RatePerSecond)
+
+	assert.Error(t, (&ResyncRequest{}).validate())
+	assert.Error(t, (&ResyncRequest{OrderIDs: []string{"order_1"}, Status: "ACTIVE"}).validate())
+	assert.Error(t, (&ResyncRequest{Status: "ACTIVE", Limit: maxResyncOrders + 1}).validate())
+	assert.Error(t, (&ResyncRequest{Status: "ACTIVE", RatePerSecond: maxResyncRate + 1}).validate())
+}
+
+func TestResync(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("order_resync_%d", time.Now().UnixNano())
+	paid, terminated, unchanged := prefix+"_paid", prefix+"_terminated", prefix+"_unchanged"
+	for _, orderID := range []string{paid, terminated, unchanged} {
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		require.NoError(t, handler.repo.CreatePayment(ctx, payment))
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+	}
+	_, err := server.CompletePayment(paid, "upi")
+	require.NoError(t, err)
+	_, err = client.TerminateOrder(terminated)
+	require.NoError(t, err)
+
+	result, err := handler.resync(ctx, ResyncRequest{Status: "ACTIVE", Limit: 10, RatePerSecond: maxResyncRate})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result.Checked)
+	assert.ElementsMatch(t, []ResyncChange{
+		{OrderID: paid, From: "ACTIVE", To: "PAID"},
+		{OrderID: terminated, From: "ACTIVE", To: domain.PaymentTerminated},
+	}, result.Updated)
+	assert.Empty(t, result.Failed)
+
+	payment, err := handler.repo.GetPaymentByOrderID(ctx, paid)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, payment.Status)
+	require.NotNil(t, payment.PaymentMethod)
+	assert.Equal(t, "upi", *payment.PaymentMethod)
+
+	// Resyncing again changes nothing, and unknown orders are reported
+	result, err = handler.resync(ctx, ResyncRequest{OrderIDs: []string{paid, unchanged, prefix + "_missing"}, RatePerSecond: maxResyncRate})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Checked)
+	assert.Empty(t, result.Updated)
+	assert.Contains(t, result.Failed, prefix+"_missing")
+}
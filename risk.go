@@ -0,0 +1,551 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// Risk screening decisions, from least to most severe
+const (
+	RiskAllow  = "ALLOW"
+	RiskReview = "REVIEW"
+	RiskBlock  = "BLOCK"
+)
+
+// Review statuses of assessments that sent a payment for manual review
+const (
+	RiskReviewPending  = "PENDING"
+	RiskReviewApproved = "APPROVED"
+	RiskReviewRejected = "REJECTED"
+)
+
+var errRiskReviewNotPending = errors.New("payment is not pending review")
+
+// RiskInput is what a risk check knows about a payment session being created
+type RiskInput struct {
+	OrderID       string
+	CustomerID    string
+	CustomerEmail string
+	CustomerPhone string
+	DeviceID      string // device fingerprint sent by the merchant, if any
+	RemoteIP      string
+	Amount        float64
+	Currency      string
+	AmountINR     *float64 // nil when the currency has no reference rate
+	Tags          map[string]string
+}
+
+// RiskVerdict is a check's finding against a payment session
+type RiskVerdict struct {
+	Rule     string `json:"rule"`
+	Decision string `json:"decision"` // REVIEW or BLOCK
+	Reason   string `json:"reason"`
+}
+
+// RiskCheck screens payment sessions before their gateway order is created. Check
+// returns nil when it has nothing against the session.
+type RiskCheck interface {
+	Name() string
+	Check(ctx context.Context, input RiskInput) (*RiskVerdict, error)
+}
+
+// riskRule is a RiskCheck written as a function
+type riskRule struct {
+	name  string
+	check func(ctx context.Context, input RiskInput) (*RiskVerdict, error)
+}
+
+// NewRiskRule returns a custom check that runs fn
+func NewRiskRule(name string, fn func(ctx context.Context, input RiskInput) (*RiskVerdict, error)) RiskCheck {
+	return &riskRule{name: name, check: fn}
+}
+
+func (r *riskRule) Name() string { return r.name }
+
+func (r *riskRule) Check(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+	return r.check(ctx, input)
+}
+
+// RiskScreen runs risk checks in order and decides on the most severe verdict
+type RiskScreen struct {
+	checks []RiskCheck
+}
+
+// NewRiskScreen returns a screen running checks
+func NewRiskScreen(checks ...RiskCheck) *RiskScreen {
+	return &RiskScreen{checks: checks}
+}
+
+// Add appends a check to the screen
+func (s *RiskScreen) Add(check RiskCheck) {
+	s.checks = append(s.checks, check)
+}
+
+// Screen runs every check against input and returns the decision with the verdicts
+// behind it. A check that fails sends the session for review rather than letting it
+// through unchecked.
+func (s *RiskScreen) Screen(ctx context.Context, input RiskInput) (string, []RiskVerdict) {
+	decision := RiskAllow
+	var verdicts []RiskVerdict
+	for _, check := range s.checks {
+		verdict, err := check.Check(ctx, input)
+		if err != nil {
+			log.Printf("Risk check %s failed for order %s: %v", check.Name(), input.OrderID, err)
+			verdict = &RiskVerdict{Decision: RiskReview, Reason: "check failed"}
+		}
+		if verdict == nil {
+			continue
+		}
+		if verdict.Rule == "" {
+			verdict.Rule = check.Name()
+		}
+		verdicts = append(verdicts, *verdict)
+		if riskSeverity(verdict.Decision) > riskSeverity(decision) {
+			decision = verdict.Decision
+		}
+	}
+	return decision, verdicts
+}
+
+// riskSeverity orders decisions; unknown decisions count as review
+func riskSeverity(decision string) int {
+	switch decision {
+	case RiskAllow:
+		return 0
+	case RiskBlock:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// AmountThresholdCheck flags sessions by their INR amount. A session in a currency
+// without a reference rate is sent for review.
+type AmountThresholdCheck struct {
+	Review float64 // INR amount above which a session is reviewed; 0 for none
+	Block  float64 // INR amount above which a session is blocked; 0 for none
+}
+
+func (c AmountThresholdCheck) Name() string { return "amount_threshold" }
+
+func (c AmountThresholdCheck) Check(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+	if input.AmountINR == nil {
+		return &RiskVerdict{Decision: RiskReview, Reason: "no INR reference rate for " + input.Currency}, nil
+	}
+	amount := *input.AmountINR
+	if c.Block > 0 && amount > c.Block {
+		return &RiskVerdict{Decision: RiskBlock, Reason: fmt.Sprintf("amount of INR %.2f is above %.2f", amount, c.Block)}, nil
+	}
+	if c.Review > 0 && amount > c.Review {
+		return &RiskVerdict{Decision: RiskReview, Reason: fmt.Sprintf("amount of INR %.2f is above %.2f", amount, c.Review)}, nil
+	}
+	return nil, nil
+}
+
+// VelocityCheck blocks customers and devices that start too many payment sessions in
+// a window. Every screened session counts, including those blocked.
+type VelocityCheck struct {
+	repo           *PaymentRepository
+	Window         time.Duration
+	MaxPerCustomer int // 0 for no limit
+	MaxPerDevice   int // 0 for no limit
+}
+
+func (c *VelocityCheck) Name() string { return "velocity" }
+
+func (c *VelocityCheck) Check(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+	since := c.repo.now().Add(-c.Window)
+	if c.MaxPerCustomer > 0 {
+		count, err := c.repo.CountRiskAssessments(ctx, "customer_id", input.CustomerID, since)
+		if err != nil {
+			return nil, err
+		}
+		if count >= c.MaxPerCustomer {
+			return &RiskVerdict{Decision: RiskBlock, Reason: fmt.Sprintf("customer started %d sessions in %s", count, c.Window)}, nil
+		}
+	}
+	if c.MaxPerDevice > 0 && input.DeviceID != "" {
+		count, err := c.repo.CountRiskAssessments(ctx, "device_id", input.DeviceID, since)
+		if err != nil {
+			return nil, err
+		}
+		if count >= c.MaxPerDevice {
+			return &RiskVerdict{Decision: RiskBlock, Reason: fmt.Sprintf("device started %d sessions in %s", count, c.Window)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RiskConfig configures the built-in risk checks
+type RiskConfig struct {
+	VelocityWindow         time.Duration
+	MaxSessionsPerCustomer int     // 0 disables the customer velocity check
+	MaxSessionsPerDevice   int     // 0 disables the device velocity check
+	ReviewAmount           float64 // INR; 0 disables review by amount
+	BlockAmount            float64 // INR; 0 disables blocking by amount
+}
+
+// newRiskScreen returns a screen with the configured built-in checks, or nil when none
+// is enabled
+func newRiskScreen(cfg RiskConfig, repo *PaymentRepository) *RiskScreen {
+	var checks []RiskCheck
+	if cfg.MaxSessionsPerCustomer > 0 || cfg.MaxSessionsPerDevice > 0 {
+		checks = append(checks, &VelocityCheck{
+			repo:           repo,
+			Window:         cfg.VelocityWindow,
+			MaxPerCustomer: cfg.MaxSessionsPerCustomer,
+			MaxPerDevice:   cfg.MaxSessionsPerDevice,
+		})
+	}
+	if cfg.ReviewAmount > 0 || cfg.BlockAmount > 0 {
+		checks = append(checks, AmountThresholdCheck{Review: cfg.ReviewAmount, Block: cfg.BlockAmount})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	return NewRiskScreen(checks...)
+}
+
+// RiskAssessment records the screening of a payment session and, for sessions sent for
+// review, the reviewer's decision
+type RiskAssessment struct {
+	ID           uuid.UUID     `json:"id"`
+	TenantID     *uuid.UUID    `json:"tenant_id,omitempty"`
+	OrderID      string        `json:"order_id"`
+	CustomerID   string        `json:"customer_id"`
+	DeviceID     *string       `json:"device_id,omitempty"`
+	RemoteIP     string        `json:"remote_ip"`
+	Amount       float64       `json:"amount"`
+	Currency     string        `json:"currency"`
+	Decision     string        `json:"decision"`
+	Verdicts     []RiskVerdict `json:"verdicts,omitempty"`
+	ReviewStatus *string       `json:"review_status,omitempty"`
+	ReviewedBy   *string       `json:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time    `json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+const riskAssessmentColumns = `id, tenant_id, order_id, customer_id, device_id, remote_ip,
+			   amount, currency, decision, verdicts, review_status, reviewed_by,
+			   reviewed_at, created_at`
+
+// scanRiskAssessment scans a row selected with riskAssessmentColumns
+func scanRiskAssessment(row pgx.Row) (*RiskAssessment, error) {
+	var a RiskAssessment
+	err := row.Scan(
+		&a.ID, &a.TenantID, &a.OrderID, &a.CustomerID, &a.DeviceID, &a.RemoteIP,
+		&a.Amount, &a.Currency, &a.Decision, &a.Verdicts, &a.ReviewStatus,
+		&a.ReviewedBy, &a.ReviewedAt, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// RecordRiskAssessment saves the screening of a payment session
+func (r *PaymentRepository) RecordRiskAssessment(ctx context.Context, a *RiskAssessment) error {
+	query := `
+		INSERT INTO risk_assessments (
+			id, tenant_id, order_id, customer_id, device_id, remote_ip, amount, currency,
+			decision, verdicts, review_status, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+
+	a.ID = uuid.New()
+	a.TenantID = TenantIDFromContext(ctx)
+	a.CreatedAt = r.now()
+
+	_, err := r.db.Exec(ctx, query,
+		a.ID, a.TenantID, a.OrderID, a.CustomerID, a.DeviceID, a.RemoteIP, a.Amount,
+		a.Currency, a.Decision, a.Verdicts, a.ReviewStatus, a.CreatedAt,
+	)
+	return err
+}
+
+// CountRiskAssessments counts the sessions screened since a time for a customer_id or
+// device_id
+func (r *PaymentRepository) CountRiskAssessments(ctx context.Context, column, value string, since time.Time) (int, error) {
+	if column != "customer_id" && column != "device_id" {
+		return 0, fmt.Errorf("cannot count risk assessments by %s", column)
+	}
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT COUNT(*)
+		FROM risk_assessments
+		WHERE ` + column + ` = $1 AND created_at > $2` + tenant
+
+	var count int
+	err := r.db.QueryRow(ctx, query, append([]interface{}{value, since}, tenantArgs...)...).Scan(&count)
+	return count, err
+}
+
+// ListPendingRiskReviews returns the assessments of payments held for review, oldest
+// first
+func (r *PaymentRepository) ListPendingRiskReviews(ctx context.Context, limit, offset int) ([]RiskAssessment, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		SELECT ` + riskAssessmentColumns + `
+		FROM risk_assessments
+		WHERE review_status = '` + RiskReviewPending + `'` + tenant + `
+		  AND EXISTS (
+			SELECT 1 FROM payments p
+			WHERE p.order_id = risk_assessments.order_id AND p.tenant_id IS NOT DISTINCT FROM risk_assessments.tenant_id
+			  AND p.status = '` + string(domain.PaymentPendingReview) + `'
+		  )
+		ORDER BY created_at
+		LIMIT $1 OFFSET $2
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{limit, offset}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assessments := []RiskAssessment{}
+	for rows.Next() {
+		a, err := scanRiskAssessment(rows)
+		if err != nil {
+			return nil, err
+		}
+		assessments = append(assessments, *a)
+	}
+
+	return assessments, rows.Err()
+}
+
+// DecideRiskReview approves or rejects an order held for review. An approved payment
+// is scheduled for activation at once, or at its activate_at if that is later; a
+// rejected one is cancelled. It returns errRiskReviewNotPending when the order is not
+// held for review.
+func (r *PaymentRepository) DecideRiskReview(ctx context.Context, orderID, reviewStatus, actor string) (*RiskAssessment, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	now := r.now()
+	status := domain.PaymentCancelled
+	if reviewStatus == RiskReviewApproved {
+		status = domain.PaymentScheduled
+	}
+
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 4)
+	tag, err := tx.Exec(ctx, `
+		UPDATE payments
+		SET status = $2, updated_at = $3,
+			activate_at = CASE WHEN $2 = '`+string(domain.PaymentScheduled)+`' THEN GREATEST(COALESCE(activate_at, $3), $3) ELSE activate_at END
+		WHERE order_id = $1 AND status = '`+string(domain.PaymentPendingReview)+`'`+tenant,
+		append([]interface{}{orderID, status, now}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, errRiskReviewNotPending
+	}
+
+	tenant, tenantArgs = tenantCondition(ctx, "AND", 5)
+	query := `
+		UPDATE risk_assessments
+		SET review_status = $2, reviewed_by = $3, reviewed_at = $4
+		WHERE order_id = $1 AND review_status = '` + RiskReviewPending + `'` + tenant + `
+		RETURNING ` + riskAssessmentColumns
+
+	a, err := scanRiskAssessment(tx.QueryRow(ctx, query, append([]interface{}{orderID, reviewStatus, actor, now}, tenantArgs...)...))
+	if err == pgx.ErrNoRows {
+		return nil, errRiskReviewNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return a, tx.Commit(ctx)
+}
+
+// riskInput describes a payment session to the risk checks
+func (h *PaymentHandler) riskInput(c *gin.Context, req *CreatePaymentSessionRequest) RiskInput {
+	input := RiskInput{
+		OrderID:       req.OrderID,
+		CustomerID:    req.CustomerID,
+		CustomerEmail: req.CustomerEmail,
+		CustomerPhone: req.CustomerPhone,
+		DeviceID:      req.DeviceID,
+		RemoteIP:      c.ClientIP(),
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		Tags:          req.Tags,
+	}
+	if strings.EqualFold(req.Currency, "INR") {
+		input.AmountINR = &req.Amount
+	} else if rate, ok := h.fxRates.RateToINR(req.Currency); ok {
+		amount := req.Amount * rate
+		input.AmountINR = &amount
+	}
+	return input
+}
+
+// screenPayment screens a payment session and records the assessment. It returns false
+// after writing the response when the session cannot go ahead.
+func (h *PaymentHandler) screenPayment(ctx context.Context, c *gin.Context, req *CreatePaymentSessionRequest) (*RiskAssessment, bool) {
+	input := h.riskInput(c, req)
+	decision, verdicts := h.risk.Screen(ctx, input)
+
+	assessment := &RiskAssessment{
+		OrderID:    req.OrderID,
+		CustomerID: req.CustomerID,
+		RemoteIP:   input.RemoteIP,
+		Amount:     req.Amount,
+		Currency:   req.Currency,
+		Decision:   decision,
+		Verdicts:   verdicts,
+	}
+	if req.DeviceID != "" {
+		assessment.DeviceID = &req.DeviceID
+	}
+	if decision == RiskReview {
+		pending := RiskReviewPending
+		assessment.ReviewStatus = &pending
+	}
+	if err := h.repo.RecordRiskAssessment(ctx, assessment); err != nil {
+		log.Printf("Failed to record risk assessment: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to screen payment"})
+		return nil, false
+	}
+
+	if decision == RiskBlock {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":         "Payment blocked by risk screening",
+			"order_id":      req.OrderID,
+			"risk_decision": decision,
+		})
+		return nil, false
+	}
+	return assessment, true
+}
+
+// holdPaymentForReview stores a payment session sent for review. Its gateway order is
+// created by ScheduledOrdersJob once a reviewer approves it.
+func (h *PaymentHandler) holdPaymentForReview(c *gin.Context, req *CreatePaymentSessionRequest, exponent int, returnURL, notifyURL string) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	gateway, err := h.gatewayForNewOrder(ctx, req.Gateway)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment := h.deferredPayment(req, gateway, exponent, returnURL, notifyURL)
+	payment.Status = domain.PaymentPendingReview
+	if err := h.repo.CreatePayment(ctx, payment); err != nil {
+		log.Printf("Failed to save payment held for review to database: %v", err)
+		respondPaymentSaveError(c, err)
+		return
+	}
+	h.recordOrderSplits(ctx, payment, req.Splits)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"order_id":      payment.OrderID,
+		"order_status":  payment.Status,
+		"risk_decision": RiskReview,
+		"amount":        payment.Amount,
+		"currency":      payment.Currency,
+	})
+}
+
+// Lists payments held for manual risk review, oldest first
+func (h *PaymentHandler) ListRiskReviews(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	reviews, err := h.repo.ListPendingRiskReviews(ctx, limit, offset)
+	if err != nil {
+		log.Printf("Failed to get pending risk reviews: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve reviews"})
+		return
+	}
+
+	validator := newListValidator()
+	for _, review := range reviews {
+		validator.add(review, review.CreatedAt)
+	}
+	if notModified(c, validator) {
+		return
+	}
+
+	setEnvelope(c, reviews, &EnvelopeMeta{Pagination: &Pagination{Limit: limit, Offset: offset, Count: len(reviews)}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"limit":   limit,
+		"offset":  offset,
+		"count":   len(reviews),
+	})
+}
+
+// Approves a payment held for review; its gateway order is created and the payment
+// link sent to the customer by the next run of the scheduled orders job
+func (h *PaymentHandler) ApproveRiskReview(c *gin.Context) {
+	h.decideRiskReview(c, RiskReviewApproved)
+}
+
+// Rejects a payment held for review, cancelling it
+func (h *PaymentHandler) RejectRiskReview(c *gin.Context) {
+	h.decideRiskReview(c, RiskReviewRejected)
+}
+
+func (h *PaymentHandler) decideRiskReview(c *gin.Context, reviewStatus string) {
+	actor := c.GetHeader(actorHeader)
+	if actor == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "X-Actor header is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	orderID := c.Param("order_id")
+	assessment, err := h.repo.DecideRiskReview(ctx, orderID, reviewStatus, actor)
+	if err != nil {
+		if err == errRiskReviewNotPending {
+			c.JSON(http.StatusConflict, gin.H{"error": "Payment is not pending review"})
+			return
+		}
+		log.Printf("Failed to decide risk review: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decide review"})
+		return
+	}
+
+	if reviewStatus == RiskReviewRejected {
+		h.publishPaymentEvent(ctx, events.PaymentCancelled, orderID)
+	}
+
+	c.JSON(http.StatusOK, assessment)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestRiskScreen(t *testing.T) {
+	ctx := context.Background()
+	review := NewRiskRule("review", func(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+		return &RiskVerdict{Decision: RiskReview, Reason: "new customer"}, nil
+	})
+	block := NewRiskRule("block", func(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+		if input.CustomerEmail == "fraud@example.com" {
+			return &RiskVerdict{Rule: "blocklist", Decision: RiskBlock, Reason: "email on blocklist"}, nil
+		}
+		return nil, nil
+	})
+	failing := NewRiskRule("failing", func(ctx context.Context, input RiskInput) (*RiskVerdict, error) {
+		return nil, errors.New("timeout")
+	})
+
+	decision, verdicts := NewRiskScreen(block).Screen(ctx, RiskInput{CustomerEmail: "john@example.com"})
+	assert.Equal(t, RiskAllow, decision)
+	assert.Empty(t, verdicts)
+
+	// The most severe verdict decides, and every verdict is kept
+	screen := NewRiskScreen(review, block)
+	decision, verdicts = screen.Screen(ctx, RiskInput{CustomerEmail: "fraud@example.com"})
+	assert.Equal(t, RiskBlock, decision)
+	assert.Equal(t, []RiskVerdict{
+		{Rule: "review", Decision: RiskReview, Reason: "new customer"},
+		{Rule: "blocklist", Decision: RiskBlock, Reason: "email on blocklist"},
+	}, verdicts)
+
+	// A check that fails sends the session for review
+	decision, verdicts = NewRiskScreen(failing).Screen(ctx, RiskInput{})
+	assert.Equal(t, RiskReview, decision)
+	assert.Equal(t, []RiskVerdict{{Rule: "failing", Decision: RiskReview, Reason: "check failed"}}, verdicts)
+}
+
+func TestAmountThresholdCheck(t *testing.T) {
+	ctx := context.Background()
+	check := AmountThresholdCheck{Review: 50000, Block: 200000}
+	amount := func(v float64) *float64 { return &v }
+
+	verdict, err := check.Check(ctx, RiskInput{AmountINR: amount(50000)})
+	require.NoError(t, err)
+	assert.Nil(t, verdict)
+
+	verdict, err = check.Check(ctx, RiskInput{AmountINR: amount(50000.01)})
+	require.NoError(t, err)
+	assert.Equal(t, RiskReview, verdict.Decision)
+
+	verdict, err = check.Check(ctx, RiskInput{AmountINR: amount(250000)})
+	require.NoError(t, err)
+	assert.Equal(t, RiskBlock, verdict.Decision)
+
+	verdict, err = check.Check(ctx, RiskInput{Currency: "XAF"})
+	require.NoError(t, err)
+	assert.Equal(t, &RiskVerdict{Decision: RiskReview, Reason: "no INR reference rate for XAF"}, verdict)
+}
+
+func TestNewRiskScreen(t *testing.T) {
+	assert.Nil(t, newRiskScreen(RiskConfig{VelocityWindow: time.Hour}, nil))
+
+	screen := newRiskScreen(RiskConfig{VelocityWindow: time.Hour, MaxSessionsPerDevice: 3, BlockAmount: 100000}, nil)
+	require.NotNil(t, screen)
+	require.Len(t, screen.checks, 2)
+	assert.Equal(t, "velocity", screen.checks[0].Name())
+	assert.Equal(t, "amount_threshold", screen.checks[1].Name())
+}
+
+func TestRiskScreeningAPI(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		risk: newRiskScreen(RiskConfig{
+			VelocityWindow:       time.Hour,
+			MaxSessionsPerDevice: 3,
+			ReviewAmount:         50000,
+		}, repo),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	serve := func(method, path, body, actor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if actor != "" {
+			req.Header.Set(actorHeader, actor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(orderID, amount string) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/payments/create-session", `{
+			"order_id": "`+orderID+`", "amount": `+amount+`, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9999999999", "device_id": "device_1"
+		}`, "")
+	}
+
+	require.Equal(t, http.StatusOK, create("order_1", "500").Code)
+
+	// Large payments wait for a reviewer before the gateway order is created
+	w := create("order_2", "75000")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"order_status":"PENDING_REVIEW"`)
+	w = create("order_3", "60000")
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	_, ok := gateway.Order("order_2")
+	assert.False(t, ok)
+
+	// The device has started three sessions within the hour
+	w = create("order_4", "500")
+	require.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"risk_decision":"BLOCK"`)
+	_, err := repo.GetPaymentByOrderID(ctx, "order_4")
+	assert.Error(t, err)
+
+	w = serve(http.MethodGet, "/risk/reviews", "", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var queue struct {
+		Reviews []RiskAssessment `json:"reviews"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &queue))
+	require.Len(t, queue.Reviews, 2)
+	assert.Equal(t, "order_2", queue.Reviews[0].OrderID)
+	assert.Equal(t, []RiskVerdict{{Rule: "amount_threshold", Decision: RiskReview, Reason: "amount of INR 75000.00 is above 50000.00"}}, queue.Reviews[0].Verdicts)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/risk/reviews/order_2/approve", "", "").Code)
+	w = serve(http.MethodPost, "/risk/reviews/order_2/approve", "", "reviewer")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var decided RiskAssessment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decided))
+	assert.Equal(t, RiskReviewApproved, *decided.ReviewStatus)
+	assert.Equal(t, "reviewer", *decided.ReviewedBy)
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPost, "/risk/reviews/order_2/approve", "", "reviewer").Code)
+
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/risk/reviews/order_3/reject", "", "reviewer").Code)
+	payment, err := repo.GetPaymentByOrderID(ctx, "order_3")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentCancelled, payment.Status)
+
+	// The approved order is created with the gateway by the scheduled orders job
+	activated, err := handler.ActivateScheduledPayments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, activated)
+	_, ok = gateway.Order("order_2")
+	assert.True(t, ok)
+	payment, err = repo.GetPaymentByOrderID(ctx, "order_2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentCreated, payment.Status)
+
+	w = serve(http.MethodGet, "/risk/reviews", "", "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &queue))
+	assert.Empty(t, queue.Reviews)
+}
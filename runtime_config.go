@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// RuntimeConfig is the part of the configuration that can be reloaded without a restart
+type RuntimeConfig struct {
+	RateLimitRPS        float64  `json:"rate_limit_rps"` // per client; 0 disables rate limiting
+	RateLimitBurst      int      `json:"rate_limit_burst"`
+	CORSOrigins         []string `json:"cors_origins"` // "*" allows any origin
+	LogLevel            string   `json:"log_level"`    // "debug", "info", "warn" or "error"
+	GatewayAutoFailover bool     `json:"gateway_auto_failover"`
+
+	// Per-merchant limits in multi-merchant mode; 0 disables
+	TenantRateLimitRPS   float64 `json:"tenant_rate_limit_rps"`
+	TenantRateLimitBurst int     `json:"tenant_rate_limit_burst"`
+
+	// Reference exchange rates to INR recorded on foreign currency orders for reporting
+	FXReferenceRates map[string]float64 `json:"fx_reference_rates,omitempty"`
+}
+
+// loadRuntimeConfig reads the reloadable settings
+func loadRuntimeConfig(r *envReader) RuntimeConfig {
+	cfg := RuntimeConfig{
+		RateLimitRPS:        r.float("RATE_LIMIT_RPS"),
+		RateLimitBurst:      r.integer("RATE_LIMIT_BURST", 0, 0, 100000),
+		CORSOrigins:         []string{"*"},
+		LogLevel:            r.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+		GatewayAutoFailover: r.boolean("GATEWAY_AUTO_FAILOVER"),
+
+		TenantRateLimitRPS:   r.float("TENANT_RATE_LIMIT_RPS"),
+		TenantRateLimitBurst: r.integer("TENANT_RATE_LIMIT_BURST", 0, 0, 100000),
+	}
+	if cfg.RateLimitBurst == 0 {
+		cfg.RateLimitBurst = int(cfg.RateLimitRPS) + 1
+	}
+	if cfg.TenantRateLimitBurst == 0 {
+		cfg.TenantRateLimitBurst = int(cfg.TenantRateLimitRPS) + 1
+	}
+
+	if origins := r.list("CORS_ALLOWED_ORIGINS"); origins != nil {
+		cfg.CORSOrigins = nil
+		for _, origin := range origins {
+			origin = strings.TrimSpace(origin)
+			if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+				r.problem("CORS_ALLOWED_ORIGINS entries must be * or http(s) origins, got %q", origin)
+				continue
+			}
+			cfg.CORSOrigins = append(cfg.CORSOrigins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	for _, entry := range r.list("FX_REFERENCE_RATES") {
+		currency, value, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if _, ok := currencyExponents[currency]; !ok || err != nil || rate <= 0 {
+			r.problem("FX_REFERENCE_RATES entries must be CURRENCY=rate with a supported currency and a positive rate, got %q", entry)
+			continue
+		}
+		if cfg.FXReferenceRates == nil {
+			cfg.FXReferenceRates = make(map[string]float64)
+		}
+		cfg.FXReferenceRates[currency] = rate
+	}
+
+	return cfg
+}
+
+// LoadRuntimeConfig reads and validates the reloadable settings
+func LoadRuntimeConfig() (RuntimeConfig, error) {
+	r := &envReader{}
+	cfg := loadRuntimeConfig(r)
+	if len(r.problems) > 0 {
+		return RuntimeConfig{}, &ConfigError{Problems: r.problems}
+	}
+	return cfg, nil
+}
+
+// slogLevel converts a LOG_LEVEL value to its slog level
+func slogLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// RuntimeSettings holds the current runtime configuration and notifies subscribers when
+// it is reloaded
+type RuntimeSettings struct {
+	mu          sync.RWMutex
+	current     RuntimeConfig
+	subscribers []func(RuntimeConfig)
+	logLevel    *slog.LevelVar
+	reloadEnv   func() error
+}
+
+// NewRuntimeSettings creates runtime settings starting from cfg. reloadEnv refreshes the
+// process environment before a reload and may be nil.
+func NewRuntimeSettings(cfg RuntimeConfig, reloadEnv func() error) *RuntimeSettings {
+	s := &RuntimeSettings{
+		current:   cfg,
+		logLevel:  new(slog.LevelVar),
+		reloadEnv: reloadEnv,
+	}
+	s.logLevel.Set(slogLevel(cfg.LogLevel))
+	return s
+}
+
+// Get returns the current runtime configuration
+func (s *RuntimeSettings) Get() RuntimeConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// LogLevel returns the level variable that follows LOG_LEVEL
+func (s *RuntimeSettings) LogLevel() *slog.LevelVar {
+	return s.logLevel
+}
+
+// Subscribe calls fn with the current configuration and again after every reload
+func (s *RuntimeSettings) Subscribe(fn func(RuntimeConfig)) {
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, fn)
+	cfg := s.current
+	s.mu.Unlock()
+
+	fn(cfg)
+}
+
+// Reload re-reads the runtime configuration. An invalid configuration is rejected and
+// the current one is kept.
+func (s *RuntimeSettings) Reload() (RuntimeConfig, error) {
+	if s.reloadEnv != nil {
+		if err := s.reloadEnv(); err != nil {
+			return s.Get(), err
+		}
+	}
+
+	cfg, err := LoadRuntimeConfig()
+	if err != nil {
+		return s.Get(), err
+	}
+
+	s.mu.Lock()
+	s.current = cfg
+	subscribers := append([]func(RuntimeConfig){}, s.subscribers...)
+	s.mu.Unlock()
+
+	s.logLevel.Set(slogLevel(cfg.LogLevel))
+	for _, fn := range subscribers {
+		fn(cfg)
+	}
+	return cfg, nil
+}
+
+// WatchSignals reloads the runtime configuration on SIGHUP until ctx is cancelled
+func (s *RuntimeSettings) WatchSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if _, err := s.Reload(); err != nil {
+					log.Printf("Failed to reload runtime configuration: %v", err)
+					continue
+				}
+				log.Println("Runtime configuration reloaded")
+			}
+		}
+	}()
+}
+
+// ReloadHandler reloads the runtime configuration and returns the settings now in effect
+func (s *RuntimeSettings) ReloadHandler(c *gin.Context) {
+	cfg, err := s.Reload()
+	if err != nil {
+		log.Printf("Failed to reload runtime configuration: %v", err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "config": cfg})
+		return
+	}
+
+	log.Println("Runtime configuration reloaded")
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded", "config": cfg})
+}
+
+// envFileReloader returns a function that re-reads .env without overriding variables
+// that were set in the process environment before startup, matching godotenv.Load
+func envFileReloader() func() error {
+	external := make(map[string]bool)
+	for _, kv := range os.Environ() {
+		external[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+
+	return func() error {
+		values, err := godotenv.Read()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for key, value := range values {
+			if !external[key] {
+				os.Setenv(key, value)
+			}
+		}
+		return nil
+	}
+}
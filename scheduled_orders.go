@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// scheduledBatchSize bounds the scheduled orders activated in one run of the job
+const scheduledBatchSize = 200
+
+// activationTimeout is how long an activation may hold a payment before another run
+// of the job takes it over, e.g. after the instance holding it crashed
+const activationTimeout = 10 * time.Minute
+
+// ListDueScheduledPayments returns scheduled payments due at now, oldest first, and
+// activations abandoned for longer than activationTimeout
+func (r *PaymentRepository) ListDueScheduledPayments(ctx context.Context, now time.Time, limit int) ([]Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE (status = 'SCHEDULED' AND activate_at <= $1)
+		   OR (status = 'ACTIVATING' AND updated_at <= $2)
+		ORDER BY activate_at
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(ctx, query, now, now.Add(-activationTimeout), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// claimScheduledPayment marks a due payment as being activated. It returns false when
+// another run of the job claimed it, or it was cancelled, since it was listed.
+func (r *PaymentRepository) claimScheduledPayment(ctx context.Context, payment *Payment) (bool, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 5)
+	query := `
+		UPDATE payments
+		SET status = 'ACTIVATING', updated_at = $3
+		WHERE order_id = $1 AND status = $2 AND updated_at = $4` + tenant
+
+	args := []interface{}{payment.OrderID, payment.Status, r.now(), payment.UpdatedAt}
+	tag, err := r.db.Exec(ctx, query, append(args, tenantArgs...)...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// releaseScheduledPayment returns a payment whose activation failed to the schedule, so
+// the next run of the job retries it
+func (r *PaymentRepository) releaseScheduledPayment(ctx context.Context, orderID string) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		UPDATE payments
+		SET status = 'SCHEDULED', updated_at = $2
+		WHERE order_id = $1 AND status = 'ACTIVATING'` + tenant
+
+	_, err := r.db.Exec(ctx, query, append([]interface{}{orderID, r.now()}, tenantArgs...)...)
+	return err
+}
+
+// ActivateScheduledPayment records the gateway order created for a scheduled payment
+// and returns the payment
+func (r *PaymentRepository) ActivateScheduledPayment(ctx context.Context, orderID, cfOrderID, paymentURL, gateway string, environment *string) (*Payment, error) {
+	// Splits recorded when the payment was scheduled get the gateway order's ID too
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 7)
+	query := `
+		WITH splits AS (
+			UPDATE split_settlements SET cf_order_id = $2, updated_at = $6
+			WHERE order_id = $1` + tenant + `
+		)
+		UPDATE payments
+		SET status = 'CREATED', cf_order_id = $2, payment_url = $3, gateway = $4,
+			environment = $5, updated_at = $6
+		WHERE order_id = $1 AND status = 'ACTIVATING'` + tenant + `
+		RETURNING ` + paymentColumns
+
+	args := []interface{}{orderID, cfOrderID, paymentURL, gateway, environment, r.now()}
+	payment, err := scanPayment(r.db.QueryRow(ctx, query, append(args, tenantArgs...)...))
+	if err == pgx.ErrNoRows {
+		return nil, fmt.Errorf("payment %s is not being activated", orderID)
+	}
+	return payment, err
+}
+
+// CancelScheduledPayment cancels a payment whose gateway order is not created yet, as it
+// is scheduled or held for review. It returns false for any other payment.
+func (r *PaymentRepository) CancelScheduledPayment(ctx context.Context, orderID string) (bool, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `
+		UPDATE payments
+		SET status = 'CANCELLED', updated_at = $2
+		WHERE order_id = $1 AND status IN ('SCHEDULED', 'PENDING_REVIEW')` + tenant
+
+	tag, err := r.db.Exec(ctx, query, append([]interface{}{orderID, r.now()}, tenantArgs...)...)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// validateActivateAt checks that a scheduled order is due after now
+func validateActivateAt(activateAt, now time.Time) error {
+	if !activateAt.After(now) {
+		return errors.New("activate_at must be in the future")
+	}
+	if activateAt.After(now.AddDate(1, 0, 0)) {
+		return errors.New("activate_at must be within a year")
+	}
+	return nil
+}
+
+// schedulePaymentSession stores a payment session whose gateway order is created at
+// req.ActivateAt, which has been validated. The gateway is checked now so that a
+// request it cannot serve is refused straight away.
+func (h *PaymentHandler) schedulePaymentSession(c *gin.Context, req *CreatePaymentSessionRequest, exponent int, returnURL, notifyURL string) {
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	gateway, err := h.gatewayForNewOrder(ctx, req.Gateway)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment := h.deferredPayment(req, gateway, exponent, returnURL, notifyURL)
+	if err := h.repo.CreatePayment(ctx, payment); err != nil {
+		log.Printf("Failed to save scheduled payment to database: %v", err)
+		respondPaymentSaveError(c, err)
+		return
+	}
+	h.recordOrderSplits(ctx, payment, req.Splits)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"order_id":     payment.OrderID,
+		"order_status": payment.Status,
+		"activate_at":  payment.ActivateAt,
+		"amount":       payment.Amount,
+		"currency":     payment.Currency,
+	})
+}
+
+// deferredPayment returns the scheduled payment of a session whose gateway order is
+// created later, with what is needed to create it
+func (h *PaymentHandler) deferredPayment(req *CreatePaymentSessionRequest, gateway PaymentGateway, exponent int, returnURL, notifyURL string) *Payment {
+	payment := &Payment{
+		OrderID:       req.OrderID,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		Status:        domain.PaymentScheduled,
+		Gateway:       req.Gateway,
+		CustomerID:    req.CustomerID,
+		CustomerName:  req.CustomerName,
+		CustomerEmail: req.CustomerEmail,
+		CustomerPhone: req.CustomerPhone,
+		Description:   req.Description,
+		GSTIN:         req.GSTIN,
+		PlaceOfSupply: req.PlaceOfSupply,
+		TaxRate:       req.TaxRate,
+		HSNCode:       req.HSNCode,
+		Tags:          req.Tags,
+		Notes:         req.Notes,
+		ReturnURL:     &returnURL,
+		NotifyURL:     &notifyURL,
+		ActivateAt:    req.ActivateAt,
+		Items:         req.Items,
+	}
+	if req.PaymentMethods != "" {
+		payment.PaymentMethods = &req.PaymentMethods
+	}
+	if client, ok := gateway.(*CashfreeClient); ok {
+		env := strings.ToUpper(client.Environment)
+		payment.Environment = &env
+	}
+	payment.CurrencyExponent = exponent
+	if rate, ok := h.fxRates.RateToINR(req.Currency); ok {
+		payment.FXRateINR = &rate
+	}
+	return payment
+}
+
+// ActivateScheduledPayments creates the gateway orders of the scheduled payments that
+// are due and returns how many were activated. A payment that fails is retried by the
+// next run.
+func (h *PaymentHandler) ActivateScheduledPayments(ctx context.Context) (int, error) {
+	payments, err := h.repo.ListDueScheduledPayments(ctx, h.now(), scheduledBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	activated, failed := 0, 0
+	for i := range payments {
+		payment := &payments[i]
+		ctx := WithTenantID(ctx, payment.TenantID)
+		claimed, err := h.repo.claimScheduledPayment(ctx, payment)
+		if err != nil {
+			return activated, err
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := h.activateScheduledPayment(ctx, payment); err != nil {
+			log.Printf("Failed to activate scheduled order %s: %v", payment.OrderID, err)
+			failed++
+			if err := h.repo.releaseScheduledPayment(ctx, payment.OrderID); err != nil {
+				log.Printf("Failed to reschedule order %s: %v", payment.OrderID, err)
+			}
+			continue
+		}
+		activated++
+	}
+
+	if failed > 0 {
+		return activated, fmt.Errorf("failed to activate %d scheduled orders", failed)
+	}
+	return activated, nil
+}
+
+// activateScheduledPayment creates a claimed payment's gateway order as its merchant
+// and environment, records it and publishes payment.activated with the payment link
+func (h *PaymentHandler) activateScheduledPayment(ctx context.Context, payment *Payment) error {
+	if payment.Environment != nil {
+		ctx = WithCashfreeEnvironment(ctx, *payment.Environment)
+	}
+	if payment.TenantID != nil && h.merchants != nil {
+		merchant, err := h.merchants.GetMerchantByID(ctx, *payment.TenantID)
+		if err != nil {
+			return fmt.Errorf("failed to get merchant: %v", err)
+		}
+		ctx = WithMerchant(ctx, merchant)
+	}
+
+	// Orders scheduled for the primary gateway may fail over like any new order, unless
+	// they are split between vendors
+	orderSplits, err := h.recordedOrderSplits(ctx, payment.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to get order splits: %v", err)
+	}
+	requested := payment.Gateway
+	if requested == GatewayCashfree && len(orderSplits) == 0 {
+		requested = ""
+	}
+	gateway, err := h.gatewayForNewOrder(ctx, requested)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.repo.ListOrderItems(ctx, payment.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to get order items: %v", err)
+	}
+
+	req := CreateOrderRequest{
+		OrderID:       payment.OrderID,
+		OrderAmount:   payment.Amount,
+		OrderCurrency: payment.Currency,
+		CustomerDetails: CustomerDetails{
+			CustomerID:    payment.CustomerID,
+			CustomerName:  payment.CustomerName,
+			CustomerEmail: payment.CustomerEmail,
+			CustomerPhone: payment.CustomerPhone,
+		},
+		OrderMeta: &OrderMeta{
+			ReturnURL:      stringValue(payment.ReturnURL),
+			NotifyURL:      stringValue(payment.NotifyURL),
+			PaymentMethods: stringValue(payment.PaymentMethods),
+		},
+		OrderTags:       gatewayOrderTags(payment.Tags, h.checkoutThemeFor(ctx).orderTags()),
+		OrderNote:       stringValue(payment.Description),
+		OrderExpiryTime: h.now().Add(orderExpiry).Format(time.RFC3339),
+		CartDetails:     cartDetails(items, payment.Currency),
+		OrderSplits:     orderSplits,
+	}
+
+	cfOrderID, paymentURL, err := createScheduledOrder(gateway, req)
+	if err != nil {
+		return err
+	}
+
+	var environment *string
+	if client, ok := gateway.(*CashfreeClient); ok {
+		env := strings.ToUpper(client.Environment)
+		environment = &env
+	}
+	activated, err := h.repo.ActivateScheduledPayment(ctx, payment.OrderID, cfOrderID, paymentURL, gateway.Name(), environment)
+	if err != nil {
+		return err
+	}
+
+	h.publishEvent(ctx, events.PaymentCreated, activated.OrderID, paymentPayload(activated))
+	h.publishEvent(ctx, events.PaymentActivated, activated.OrderID, paymentPayload(activated))
+	return nil
+}
+
+// createScheduledOrder creates the gateway order and returns its ID and payment link.
+// An order an earlier, interrupted activation already created is picked up as is.
+func createScheduledOrder(gateway PaymentGateway, req CreateOrderRequest) (string, string, error) {
+	resp, err := gateway.CreateOrder(req)
+	if err == nil {
+		return resp.CFOrderID, resp.PaymentLink, nil
+	}
+	if !errors.Is(err, ErrDuplicateRequest) {
+		return "", "", fmt.Errorf("failed to create %s order: %w", gateway.Name(), err)
+	}
+
+	status, statusErr := gateway.GetOrderStatus(req.OrderID)
+	if statusErr != nil {
+		return "", "", fmt.Errorf("failed to get existing %s order: %w", gateway.Name(), statusErr)
+	}
+	return status.CFOrderID, status.PaymentLink, nil
+}
+
+// ScheduledOrdersJob activates due scheduled orders every minute
+func (h *PaymentHandler) ScheduledOrdersJob() Job {
+	return Job{
+		Name:     "scheduled_orders",
+		Schedule: Every(time.Minute),
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			activated, err := h.ActivateScheduledPayments(ctx)
+			if activated > 0 {
+				log.Printf("Activated %d scheduled orders", activated)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+func TestValidateActivateAt(t *testing.T) {
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	assert.NoError(t, validateActivateAt(now.Add(time.Minute), now))
+	assert.NoError(t, validateActivateAt(now.AddDate(1, 0, 0), now))
+	assert.EqualError(t, validateActivateAt(now, now), "activate_at must be in the future")
+	assert.EqualError(t, validateActivateAt(now.AddDate(1, 0, 1), now), "activate_at must be within a year")
+}
+
+func TestScheduledPaymentSession(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+	clock := newTestClock(time.Now().UTC().Truncate(time.Second))
+	gateway.Now = clock.Now
+
+	bus := events.NewMemoryBus(nil)
+	var mu sync.Mutex
+	var activated []events.PaymentPayload
+	bus.Subscribe(events.PaymentActivated, func(ctx context.Context, event events.Event) error {
+		var payload events.PaymentPayload
+		require.NoError(t, event.Decode(&payload))
+		mu.Lock()
+		activated = append(activated, payload)
+		mu.Unlock()
+		return nil
+	})
+
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		publisher:    bus,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{EnvironmentTest: client},
+		clock:        clock,
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	schedule := func(orderID string, activateAt time.Time) *httptest.ResponseRecorder {
+		return serve(http.MethodPost, "/payments/create-session", `{
+			"order_id": "`+orderID+`", "amount": 1200, "currency": "INR", "customer_id": "cust_1",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9999999999", "return_url": "https://shop.example.com/return",
+			"tags": {"invoice": "INV-7"}, "activate_at": "`+activateAt.Format(time.RFC3339)+`"
+		}`)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, schedule("order_past", clock.Now().Add(-time.Minute)).Code)
+
+	w := schedule("order_1", clock.Now().Add(time.Hour))
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"order_status":"SCHEDULED"`)
+	require.Equal(t, http.StatusAccepted, schedule("order_2", clock.Now().Add(time.Hour)).Code)
+
+	// Nothing exists at Cashfree until the order is due
+	_, ok := gateway.Order("order_1")
+	assert.False(t, ok)
+	w = serve(http.MethodGet, "/payments/order_1", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var details Payment
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	assert.Equal(t, domain.PaymentScheduled, details.Status)
+	assert.Empty(t, details.CFOrderID)
+	assert.Nil(t, details.PaymentURL)
+
+	// A scheduled order is cancelled without calling the gateway
+	require.Equal(t, http.StatusOK, serve(http.MethodPost, "/payments/order_2/cancel", "").Code)
+
+	count, err := handler.ActivateScheduledPayments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	clock.Advance(time.Hour)
+	count, err = handler.ActivateScheduledPayments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	bus.Wait()
+
+	order, ok := gateway.Order("order_1")
+	require.True(t, ok)
+	assert.Equal(t, "https://shop.example.com/return", order.ReturnURL)
+	assert.Equal(t, map[string]string{"invoice": "INV-7"}, order.Tags)
+	assert.True(t, clock.Now().Add(24*time.Hour).Equal(order.ExpiryTime))
+
+	payment, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentCreated, payment.Status)
+	assert.Equal(t, order.CFOrderID, payment.CFOrderID)
+	require.NotNil(t, payment.PaymentURL)
+	assert.Equal(t, order.PaymentLink, *payment.PaymentURL)
+
+	require.Len(t, activated, 1)
+	assert.Equal(t, "order_1", activated[0].OrderID)
+	assert.Equal(t, order.PaymentLink, activated[0].PaymentURL)
+
+	cancelled, err := repo.GetPaymentByOrderID(ctx, "order_2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentCancelled, cancelled.Status)
+	_, ok = gateway.Order("order_2")
+	assert.False(t, ok)
+
+	// Activated orders are not picked up again
+	count, err = handler.ActivateScheduledPayments(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
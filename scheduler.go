@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Schedule returns the next time a job should run after now
+type Schedule func(now time.Time) time.Time
+
+// Every runs a job at a fixed interval
+func Every(interval time.Duration) Schedule {
+	return func(now time.Time) time.Time {
+		return now.Add(interval)
+	}
+}
+
+// Hourly runs a job at the start of every hour
+func Hourly() Schedule {
+	return func(now time.Time) time.Time {
+		return now.Truncate(time.Hour).Add(time.Hour)
+	}
+}
+
+// DailyAt runs a job once a day at the given time of day in loc
+func DailyAt(hour, minute int, loc *time.Location) Schedule {
+	return func(now time.Time) time.Time {
+		local := now.In(loc)
+		next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, loc)
+		if !next.After(local) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+}
+
+// Job is a background task run on a schedule
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// JobRun records the outcome of a job's latest run
+type JobRun struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+	NextRunAt time.Time     `json:"next_run_at"`
+}
+
+// Scheduler runs registered jobs in the background
+type Scheduler struct {
+	clock   Clock
+	mu      sync.RWMutex
+	jobs    []Job
+	lastRun map[string]JobRun
+	wg      sync.WaitGroup
+
+	leader  Leadership // nil runs every job on this instance
+	leading bool       // whether this instance led at its latest job
+}
+
+// NewScheduler creates an empty scheduler that schedules jobs by clock
+func NewScheduler(clock Clock) *Scheduler {
+	return &Scheduler{clock: clockOrSystem(clock), lastRun: make(map[string]JobRun)}
+}
+
+// Register adds a job. It must be called before Start.
+func (s *Scheduler) Register(job Job) {
+	if job.Timeout == 0 {
+		job.Timeout = 30 * time.Minute
+	}
+	s.jobs = append(s.jobs, job)
+}
+
+// SetLeadership makes jobs run only while this instance is the leader, for deployments
+// with several replicas. It must be called before Start.
+func (s *Scheduler) SetLeadership(leader Leadership) {
+	s.leader = leader
+}
+
+// Leading reports whether this instance runs jobs: always without leader election,
+// and otherwise whether it led when a job was last due
+func (s *Scheduler) Leading() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.leader == nil || s.leading
+}
+
+// Start runs every job on its schedule until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Wait blocks until all job loops have stopped
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Jobs returns the names of the registered jobs
+func (s *Scheduler) Jobs() []string {
+	names := make([]string, len(s.jobs))
+	for i, job := range s.jobs {
+		names[i] = job.Name
+	}
+	return names
+}
+
+// LastRuns returns the latest run of every job that has run
+func (s *Scheduler) LastRuns() map[string]JobRun {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	runs := make(map[string]JobRun, len(s.lastRun))
+	for name, run := range s.lastRun {
+		runs[name] = run
+	}
+	return runs
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+
+	for {
+		now := s.clock.Now()
+		timer := time.NewTimer(job.Schedule(now).Sub(now))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.run(ctx, job)
+		}
+	}
+}
+
+// run executes a job once and records the outcome. With leader election, followers
+// skip the job.
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	if s.leader != nil {
+		leading := s.leader.IsLeader(ctx)
+		s.mu.Lock()
+		s.leading = leading
+		s.mu.Unlock()
+		if !leading {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	started := s.clock.Now()
+	err := job.Run(ctx)
+	finished := s.clock.Now()
+
+	run := JobRun{
+		StartedAt: started,
+		Duration:  finished.Sub(started),
+		NextRunAt: job.Schedule(finished),
+	}
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Job %s failed: %v", job.Name, err)
+	}
+
+	s.mu.Lock()
+	s.lastRun[job.Name] = run
+	s.mu.Unlock()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDailyAt(t *testing.T) {
+	schedule := DailyAt(1, 30, istLocation)
+
+	before := time.Date(2024, 4, 1, 0, 15, 0, 0, istLocation)
+	assert.Equal(t, time.Date(2024, 4, 1, 1, 30, 0, 0, istLocation), schedule(before))
+
+	after := time.Date(2024, 4, 1, 1, 30, 0, 0, istLocation)
+	assert.Equal(t, time.Date(2024, 4, 2, 1, 30, 0, 0, istLocation), schedule(after))
+}
+
+func TestHourly(t *testing.T) {
+	now := time.Date(2024, 4, 1, 10, 15, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 4, 1, 11, 0, 0, 0, time.UTC), Hourly()(now))
+}
+
+func TestSchedulerRecordsRuns(t *testing.T) {
+	started := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	clock := newTestClock(started)
+	scheduler := NewScheduler(clock)
+	scheduler.Register(Job{
+		Name:     "failing",
+		Schedule: Every(time.Hour),
+		Run: func(ctx context.Context) error {
+			clock.Advance(90 * time.Second)
+			return errors.New("boom")
+		},
+	})
+
+	scheduler.run(context.Background(), scheduler.jobs[0])
+
+	runs := scheduler.LastRuns()
+	assert.Equal(t, JobRun{
+		StartedAt: started,
+		Duration:  90 * time.Second,
+		Error:     "boom",
+		NextRunAt: started.Add(time.Hour + 90*time.Second),
+	}, runs["failing"])
+}
+
+type testLeadership bool
+
+func (l *testLeadership) IsLeader(ctx context.Context) bool {
+	return bool(*l)
+}
+
+func TestSchedulerRunsJobsOnlyWhenLeading(t *testing.T) {
+	leader := testLeadership(false)
+	scheduler := NewScheduler(newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)))
+	scheduler.SetLeadership(&leader)
+	runs := 0
+	scheduler.Register(Job{Name: "counted", Schedule: Every(time.Hour), Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}})
+
+	scheduler.run(context.Background(), scheduler.jobs[0])
+	assert.Equal(t, 0, runs)
+	assert.False(t, scheduler.Leading())
+	assert.Empty(t, scheduler.LastRuns())
+
+	leader = true
+	scheduler.run(context.Background(), scheduler.jobs[0])
+	assert.Equal(t, 1, runs)
+	assert.True(t, scheduler.Leading())
+	assert.Contains(t, scheduler.LastRuns(), "counted")
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"payment-getway/domain"
+)
+
+// seedNamespace derives the IDs of seeded records from their order IDs, so the same
+// options always produce the same IDs
+var seedNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("payment-getway/seed"))
+
+// seedPrefixPattern restricts the prefix of seeded order IDs, which is also used to find
+// and replace a previous seed
+var seedPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,20}$`)
+
+// seedFXRateUSD is the reference rate recorded on seeded USD orders
+const seedFXRateUSD = 83.25
+
+var (
+	seedCustomers = []struct{ Name, Email, Phone string }{
+		{"Aarav Sharma", "aarav.sharma@example.com", "+919812345601"},
+		{"Priya Iyer", "priya.iyer@example.com", "+919812345602"},
+		{"Rohan Mehta", "rohan.mehta@example.com", "+919812345603"},
+		{"Ananya Reddy", "ananya.reddy@example.com", "+919812345604"},
+		{"Vikram Singh", "vikram.singh@example.com", "+919812345605"},
+		{"Neha Gupta", "neha.gupta@example.com", "+919812345606"},
+		{"Arjun Nair", "arjun.nair@example.com", "+919812345607"},
+		{"Kavya Joshi", "kavya.joshi@example.com", "+919812345608"},
+		{"Emily Carter", "emily.carter@example.com", "+14155550109"},
+		{"James Wilson", "james.wilson@example.com", "+14155550110"},
+	}
+	seedDescriptions = []string{"Annual subscription", "Monthly subscription", "Order checkout", "Gift card", "Course enrolment", "Event ticket"}
+	seedMethods      = []string{"upi", "upi", "upi", "card", "card", "netbanking", "wallet"}
+	seedReasons      = []string{"Customer request", "Duplicate payment", "Item out of stock", "Order cancelled"}
+)
+
+// seedOptions controls the data the seed command generates
+type seedOptions struct {
+	Prefix   string     // prefix of every seeded order ID
+	Payments int        // number of payments
+	Days     int        // number of days the payments are spread over
+	End      time.Time  // last day of the range, in IST
+	Seed     int64      // random seed; the same seed gives the same data
+	TenantID *uuid.UUID // owning merchant, or nil in single-merchant mode
+}
+
+// seedData is the generated fixture data
+type seedData struct {
+	Payments    []Payment
+	Refunds     []Refund
+	Settlements []Settlement
+	Webhooks    []Webhook
+}
+
+// generateSeedData generates realistic payments, with the refunds, settlements and
+// webhook logs that follow from them. It is deterministic in its options.
+func generateSeedData(opts seedOptions) *seedData {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	data := &seedData{}
+
+	// The range ends at midnight after the end day; records later than that are left
+	// pending, as they would be on the end day
+	end := startOfDay(opts.End, istLocation).AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -opts.Days)
+
+	createdTimes := make([]time.Time, opts.Payments)
+	for i := range createdTimes {
+		createdTimes[i] = start.Add(time.Duration(rng.Int63n(int64(end.Sub(start))))).Truncate(time.Second)
+	}
+	sort.Slice(createdTimes, func(i, j int) bool { return createdTimes[i].Before(createdTimes[j]) })
+
+	for i, createdAt := range createdTimes {
+		n := i + 1
+		orderID := fmt.Sprintf("%s_order_%05d", opts.Prefix, n)
+		customerIndex := rng.Intn(len(seedCustomers))
+		customer := seedCustomers[customerIndex]
+		description := seedDescriptions[rng.Intn(len(seedDescriptions))]
+
+		payment := Payment{
+			ID:               uuid.NewSHA1(seedNamespace, []byte(orderID)),
+			OrderID:          orderID,
+			CFOrderID:        fmt.Sprintf("%s_cf_order_%05d", opts.Prefix, n),
+			Amount:           float64(99+rng.Intn(20000)) + []float64{0, 0, 0.5, 0.99}[rng.Intn(4)],
+			Currency:         "INR",
+			CustomerID:       fmt.Sprintf("%s_customer_%02d", opts.Prefix, customerIndex+1),
+			CustomerName:     customer.Name,
+			CustomerEmail:    customer.Email,
+			CustomerPhone:    customer.Phone,
+			Description:      &description,
+			Gateway:          GatewayCashfree,
+			TenantID:         opts.TenantID,
+			CurrencyExponent: 2,
+			CreatedAt:        createdAt,
+			UpdatedAt:        createdAt,
+		}
+		if rng.Intn(10) == 0 {
+			rate := seedFXRateUSD
+			payment.Currency = "USD"
+			payment.Amount = float64(5+rng.Intn(300)) + 0.99
+			payment.FXRateINR = &rate
+		}
+
+		switch roll := rng.Intn(100); {
+		case roll < 72:
+			payment.Status = domain.PaymentSuccess
+		case roll < 84:
+			payment.Status = domain.PaymentFailed
+		case roll < 92:
+			payment.Status = domain.PaymentCancelled
+		default:
+			// Unpaid orders expire after a day
+			payment.Status = domain.PaymentCreated
+			if end.Sub(createdAt) > 24*time.Hour {
+				payment.Status = domain.PaymentExpired
+			}
+		}
+
+		if payment.Status == domain.PaymentSuccess || payment.Status == domain.PaymentFailed {
+			cfPaymentID := fmt.Sprintf("%s_cf_payment_%05d", opts.Prefix, n)
+			method := seedMethods[rng.Intn(len(seedMethods))]
+			paymentTime := createdAt.Add(time.Duration(30+rng.Intn(570)) * time.Second)
+			payment.CFPaymentID = &cfPaymentID
+			payment.PaymentMethod = &method
+			payment.UpdatedAt = paymentTime
+
+			webhookType := domain.WebhookPaymentFailed
+			if payment.Status == domain.PaymentSuccess {
+				payment.PaymentTime = &paymentTime
+				webhookType = domain.WebhookPaymentSuccess
+			}
+			data.addWebhook(webhookType, orderID, paymentTime, map[string]interface{}{
+				"order_id":       orderID,
+				"cf_payment_id":  cfPaymentID,
+				"payment_status": payment.Status,
+				"payment_amount": payment.Amount,
+				"payment_method": method,
+				"payment_time":   paymentTime.Format(time.RFC3339),
+			})
+		}
+		data.Payments = append(data.Payments, payment)
+
+		if payment.Status != domain.PaymentSuccess {
+			continue
+		}
+		paidAt := *payment.PaymentTime
+
+		// About one in seven successful payments is refunded, a third of them in full
+		if rng.Intn(7) == 0 {
+			amount := payment.Amount
+			if rng.Intn(3) != 0 {
+				amount = math.Round(payment.Amount*float64(10+rng.Intn(80))) / 100
+			}
+			reason := seedReasons[rng.Intn(len(seedReasons))]
+			refundID := fmt.Sprintf("%s_refund_%05d", opts.Prefix, n)
+			refund := Refund{
+				ID:         uuid.NewSHA1(seedNamespace, []byte(refundID)),
+				RefundID:   refundID,
+				CFRefundID: fmt.Sprintf("%s_cf_refund_%05d", opts.Prefix, n),
+				OrderID:    orderID,
+				CFOrderID:  payment.CFOrderID,
+				Amount:     amount,
+				Status:     domain.RefundPending,
+				Reason:     &reason,
+				CreatedAt:  paidAt.Add(time.Duration(1+rng.Intn(72)) * time.Hour),
+			}
+			refund.UpdatedAt = refund.CreatedAt
+
+			// Refunds take a day to process
+			if processedAt := refund.CreatedAt.Add(24 * time.Hour); processedAt.Before(end) {
+				refund.Status = domain.RefundSuccess
+				refund.ProcessedAt = &processedAt
+				refund.UpdatedAt = processedAt
+				data.addWebhook(domain.WebhookRefundStatus, orderID, processedAt, map[string]interface{}{
+					"order_id":      orderID,
+					"refund_id":     refundID,
+					"cf_refund_id":  refund.CFRefundID,
+					"refund_amount": refund.Amount,
+					"refund_status": refund.Status,
+					"processed_at":  processedAt.Format(time.RFC3339),
+				})
+			}
+			if refund.CreatedAt.Before(end) {
+				data.Refunds = append(data.Refunds, refund)
+			}
+		}
+
+		// Payments settle at 10:00 IST the next day, less a 2% gateway fee
+		settlementID := fmt.Sprintf("%s_settlement_%05d", opts.Prefix, n)
+		settlement := Settlement{
+			ID:           uuid.NewSHA1(seedNamespace, []byte(settlementID)),
+			SettlementID: settlementID,
+			OrderID:      orderID,
+			CFOrderID:    payment.CFOrderID,
+			Amount:       math.Round(payment.Amount*98) / 100,
+			Status:       domain.SettlementPending,
+			CreatedAt:    paidAt,
+			UpdatedAt:    paidAt,
+		}
+		if settledAt := startOfDay(paidAt, istLocation).AddDate(0, 0, 1).Add(10 * time.Hour); settledAt.Before(end) {
+			utr := fmt.Sprintf("UTR%s%08d", opts.Prefix, n)
+			settlement.Status = domain.SettlementSuccess
+			settlement.UTR = &utr
+			settlement.SettledAt = &settledAt
+			settlement.UpdatedAt = settledAt
+			data.addWebhook(domain.WebhookSettlementStatus, orderID, settledAt, map[string]interface{}{
+				"order_id":          orderID,
+				"settlement_id":     settlementID,
+				"settlement_status": settlement.Status,
+				"settlement_amount": settlement.Amount,
+				"utr":               utr,
+			})
+		}
+		data.Settlements = append(data.Settlements, settlement)
+	}
+
+	return data
+}
+
+// addWebhook logs a webhook as the handler would have received it
+func (d *seedData) addWebhook(eventType domain.WebhookType, orderID string, receivedAt time.Time, payload map[string]interface{}) {
+	body, _ := json.Marshal(WebhookData{Type: string(eventType), Data: payload})
+	name := fmt.Sprintf("%s/%s/%d", eventType, orderID, len(d.Webhooks))
+	d.Webhooks = append(d.Webhooks, Webhook{
+		ID:        uuid.NewSHA1(seedNamespace, []byte(name)),
+		EventType: eventType,
+		OrderID:   &orderID,
+		Payload:   string(body),
+		Status:    domain.WebhookReceived,
+		CreatedAt: receivedAt,
+	})
+}
+
+// insertSeedData replaces any records seeded earlier for the merchant with the same
+// prefix with data, in one transaction
+func insertSeedData(ctx context.Context, db *pgxpool.Pool, prefix string, tenantID *uuid.UUID, data *seedData) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Refunds, settlements and split settlements are deleted with their payments
+	pattern := prefix + `\_order\_%`
+	if _, err := tx.Exec(ctx, `DELETE FROM webhooks WHERE order_id LIKE $1 AND tenant_id IS NOT DISTINCT FROM $2`, pattern, tenantID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM payments WHERE order_id LIKE $1 AND tenant_id IS NOT DISTINCT FROM $2`, pattern, tenantID); err != nil {
+		return err
+	}
+
+	batch := &pgx.Batch{}
+	for _, p := range data.Payments {
+		batch.Queue(`
+			INSERT INTO payments (
+				id, order_id, cf_order_id, amount, currency, status, payment_method,
+				customer_id, customer_name, customer_email, customer_phone, description,
+				cf_payment_id, payment_time, gateway, tenant_id, currency_exponent,
+				fx_rate_inr, created_at, updated_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)`,
+			p.ID, p.OrderID, p.CFOrderID, p.Amount, p.Currency, p.Status, p.PaymentMethod,
+			p.CustomerID, p.CustomerName, p.CustomerEmail, p.CustomerPhone, p.Description,
+			p.CFPaymentID, p.PaymentTime, p.Gateway, p.TenantID, p.CurrencyExponent,
+			p.FXRateINR, p.CreatedAt, p.UpdatedAt,
+		)
+	}
+	for _, r := range data.Refunds {
+		batch.Queue(`
+			INSERT INTO refunds (
+				id, refund_id, cf_refund_id, order_id, cf_order_id, amount, status,
+				reason, processed_at, created_at, updated_at, tenant_id
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			r.ID, r.RefundID, r.CFRefundID, r.OrderID, r.CFOrderID, r.Amount, r.Status,
+			r.Reason, r.ProcessedAt, r.CreatedAt, r.UpdatedAt, tenantID,
+		)
+	}
+	for _, s := range data.Settlements {
+		batch.Queue(`
+			INSERT INTO settlements (
+				id, settlement_id, order_id, cf_order_id, amount, status, utr,
+				settled_at, created_at, updated_at, tenant_id
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			s.ID, s.SettlementID, s.OrderID, s.CFOrderID, s.Amount, s.Status, s.UTR,
+			s.SettledAt, s.CreatedAt, s.UpdatedAt, tenantID,
+		)
+	}
+	for _, w := range data.Webhooks {
+		batch.Queue(`
+			INSERT INTO webhooks (id, event_type, order_id, payload, status, created_at, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+			w.ID, w.EventType, w.OrderID, w.Payload, w.Status, w.CreatedAt, tenantID,
+		)
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// runSeed implements the seed command, which fills the database with fixture data for
+// local development and demos. Running it again with the same prefix replaces the
+// previous seed.
+func runSeed(args []string, db *pgxpool.Pool, merchants *MerchantRepository) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	prefix := flags.String("prefix", "seed", "prefix of seeded order IDs (letters and digits)")
+	payments := flags.Int("payments", 200, "number of payments")
+	days := flags.Int("days", 30, "number of days to spread the payments over")
+	endDate := flags.String("end", "", "last day of the range as YYYY-MM-DD (default yesterday, IST)")
+	seed := flags.Int64("seed", 1, "random seed; the same flags give the same data")
+	merchantID := flags.String("merchant-id", "", "merchant owning the data in multi-merchant mode")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !seedPrefixPattern.MatchString(*prefix) {
+		return errors.New("-prefix must be 1 to 20 letters and digits")
+	}
+	if *payments < 1 || *payments > 100000 {
+		return errors.New("-payments must be between 1 and 100000")
+	}
+	if *days < 1 || *days > 3660 {
+		return errors.New("-days must be between 1 and 3660")
+	}
+
+	opts := seedOptions{
+		Prefix:   *prefix,
+		Payments: *payments,
+		Days:     *days,
+		End:      startOfDay(time.Now(), istLocation).AddDate(0, 0, -1),
+		Seed:     *seed,
+	}
+	if *endDate != "" {
+		end, err := time.ParseInLocation("2006-01-02", *endDate, istLocation)
+		if err != nil {
+			return fmt.Errorf("invalid -end: %v", err)
+		}
+		opts.End = end
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if *merchantID != "" {
+		if merchants == nil {
+			return errors.New("MERCHANT_ENCRYPTION_KEY must be set to seed a merchant's data")
+		}
+		id, err := uuid.Parse(*merchantID)
+		if err != nil {
+			return fmt.Errorf("invalid merchant id: %v", err)
+		}
+		if _, err := merchants.GetMerchantByID(ctx, id); err != nil {
+			return err
+		}
+		opts.TenantID = &id
+	}
+
+	data := generateSeedData(opts)
+	if err := insertSeedData(ctx, db, opts.Prefix, opts.TenantID, data); err != nil {
+		return fmt.Errorf("failed to seed database: %v", err)
+	}
+
+	fmt.Printf("Seeded %d payments, %d refunds, %d settlements and %d webhook logs (order IDs %s_order_*)\n",
+		len(data.Payments), len(data.Refunds), len(data.Settlements), len(data.Webhooks), opts.Prefix)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func testSeedOptions() seedOptions {
+	return seedOptions{
+		Prefix:   "seed",
+		Payments: 300,
+		Days:     30,
+		End:      time.Date(2024, 5, 31, 0, 0, 0, 0, istLocation),
+		Seed:     1,
+	}
+}
+
+func TestGenerateSeedDataIsDeterministic(t *testing.T) {
+	first := generateSeedData(testSeedOptions())
+	second := generateSeedData(testSeedOptions())
+	assert.Equal(t, first, second)
+
+	opts := testSeedOptions()
+	opts.Seed = 2
+	other := generateSeedData(opts)
+	assert.Equal(t, first.Payments[0].OrderID, other.Payments[0].OrderID)
+	assert.Equal(t, first.Payments[0].ID, other.Payments[0].ID)
+	assert.NotEqual(t, first.Payments, other.Payments)
+}
+
+func TestGenerateSeedDataIsConsistent(t *testing.T) {
+	opts := testSeedOptions()
+	data := generateSeedData(opts)
+	start := time.Date(2024, 5, 2, 0, 0, 0, 0, istLocation)
+	end := time.Date(2024, 6, 1, 0, 0, 0, 0, istLocation)
+
+	require.Len(t, data.Payments, 300)
+	assert.Equal(t, "seed_order_00001", data.Payments[0].OrderID)
+	assert.Equal(t, "seed_order_00300", data.Payments[299].OrderID)
+
+	payments := make(map[string]Payment)
+	statuses := make(map[domain.PaymentStatus]int)
+	for i, p := range data.Payments {
+		payments[p.OrderID] = p
+		statuses[p.Status]++
+
+		assert.False(t, p.CreatedAt.Before(start), p.OrderID)
+		assert.True(t, p.CreatedAt.Before(end), p.OrderID)
+		if i > 0 {
+			assert.False(t, p.CreatedAt.Before(data.Payments[i-1].CreatedAt), "payments are in creation order")
+		}
+		_, _, err := validateCurrency(p.Currency, p.Amount)
+		assert.NoError(t, err, p.OrderID)
+		assert.Equal(t, p.Status == "SUCCESS", p.PaymentTime != nil, p.OrderID)
+	}
+	for _, status := range []domain.PaymentStatus{domain.PaymentSuccess, domain.PaymentFailed, domain.PaymentCancelled, domain.PaymentExpired} {
+		assert.NotZero(t, statuses[status], status)
+	}
+
+	require.NotEmpty(t, data.Refunds)
+	for _, r := range data.Refunds {
+		payment := payments[r.OrderID]
+		assert.Equal(t, domain.PaymentSuccess, payment.Status, r.RefundID)
+		assert.LessOrEqual(t, r.Amount, payment.Amount, r.RefundID)
+		assert.True(t, r.CreatedAt.After(*payment.PaymentTime), r.RefundID)
+		assert.True(t, r.CreatedAt.Before(end), r.RefundID)
+		assert.Equal(t, r.Status == "SUCCESS", r.ProcessedAt != nil, r.RefundID)
+	}
+
+	require.NotEmpty(t, data.Settlements)
+	for _, s := range data.Settlements {
+		payment := payments[s.OrderID]
+		assert.Equal(t, domain.PaymentSuccess, payment.Status, s.SettlementID)
+		assert.Less(t, s.Amount, payment.Amount, s.SettlementID)
+		if s.Status == "SUCCESS" {
+			require.NotNil(t, s.SettledAt)
+			assert.True(t, s.SettledAt.Before(end), s.SettlementID)
+		} else {
+			assert.Nil(t, s.SettledAt)
+		}
+	}
+
+	// Webhook logs are what the handler would have received
+	require.NotEmpty(t, data.Webhooks)
+	for _, w := range data.Webhooks {
+		var webhook WebhookData
+		require.NoError(t, json.Unmarshal([]byte(w.Payload), &webhook))
+		assert.Equal(t, string(w.EventType), webhook.Type)
+		assert.Equal(t, *w.OrderID, webhook.Data["order_id"])
+		assert.Contains(t, payments, *w.OrderID)
+	}
+}
+
+func TestRunSeedValidatesFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-prefix", "seed_data"}, "-prefix must be 1 to 20 letters and digits"},
+		{[]string{"-payments", "0"}, "-payments must be between 1 and 100000"},
+		{[]string{"-days", "0"}, "-days must be between 1 and 3660"},
+		{[]string{"-end", "31/05/2024"}, "invalid -end"},
+		{[]string{"-merchant-id", "not-a-uuid"}, "MERCHANT_ENCRYPTION_KEY must be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err, func(t *testing.T) {
+			assert.ErrorContains(t, runSeed(tt.args, nil, nil), tt.err)
+		})
+	}
+}
+
+func TestInsertSeedData(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	repo := NewPaymentRepository(db)
+
+	opts := testSeedOptions()
+	opts.Payments = 50
+	data := generateSeedData(opts)
+	require.NoError(t, insertSeedData(ctx, db, opts.Prefix, opts.TenantID, data))
+
+	stored, err := repo.GetPaymentByOrderID(ctx, data.Payments[0].OrderID)
+	require.NoError(t, err)
+	assert.Equal(t, data.Payments[0].ID, stored.ID)
+	assert.True(t, data.Payments[0].CreatedAt.Equal(stored.CreatedAt))
+
+	count := func(table string) int {
+		var n int
+		require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM `+table).Scan(&n))
+		return n
+	}
+	assert.Equal(t, 50, count("payments"))
+	assert.Equal(t, len(data.Refunds), count("refunds"))
+	assert.Equal(t, len(data.Settlements), count("settlements"))
+	assert.Equal(t, len(data.Webhooks), count("webhooks"))
+
+	// Seeding again replaces the previous seed, leaving other records alone
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	opts.Payments = 20
+	data = generateSeedData(opts)
+	require.NoError(t, insertSeedData(ctx, db, opts.Prefix, opts.TenantID, data))
+	assert.Equal(t, 21, count("payments"))
+	assert.Equal(t, len(data.Refunds), count("refunds"))
+	assert.Equal(t, len(data.Webhooks), count("webhooks"))
+
+	_, err = repo.GetPaymentByOrderID(ctx, "order_1")
+	assert.NoError(t, err)
+}
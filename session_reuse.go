@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// How create-session answers an order that already has a session
+const (
+	DuplicateSessionsReuse  = "reuse"  // return the order's ACTIVE, unexpired gateway order
+	DuplicateSessionsReject = "reject" // answer 409
+)
+
+// reusesSessions reports whether repeated create-session requests get the order's
+// existing session
+func (h *PaymentHandler) reusesSessions() bool {
+	return h.duplicateSessions != DuplicateSessionsReject
+}
+
+// sameOrder reports whether a gateway order was created for the same amount and
+// currency as a create-session request
+func sameOrder(req *CreatePaymentSessionRequest, amount float64, currency string) bool {
+	return math.Abs(amount-req.Amount) < 0.005 && strings.EqualFold(currency, req.Currency)
+}
+
+// reusableOrder checks that an existing gateway order can still be paid: it is ACTIVE and
+// has not expired. It returns why it cannot otherwise.
+func (h *PaymentHandler) reusableOrder(order *CashfreeOrderStatusResponse) (string, bool) {
+	if order.OrderStatus != domain.PaymentActive {
+		return "Order is " + string(order.OrderStatus) + " and can no longer be paid", false
+	}
+	if !order.OrderExpiryTime.IsZero() && !h.now().Before(order.OrderExpiryTime) {
+		return "Order has expired and can no longer be paid", false
+	}
+	return "", true
+}
+
+// answerDuplicateSession answers a create-session request the gateway refused as a
+// duplicate of a stored order, and reports whether it did. A Cashfree order created for
+// the same amount, currency and customer that is still ACTIVE and unexpired is returned
+// as it is; anything else gets 409. Orders that are not stored are left to
+// adoptExistingOrder, and with session reuse off the gateway's refusal stands.
+func (h *PaymentHandler) answerDuplicateSession(ctx context.Context, c *gin.Context, req *CreatePaymentSessionRequest) bool {
+	if !h.reusesSessions() {
+		return false
+	}
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, req.OrderID)
+	if err != nil {
+		return false
+	}
+
+	conflict := func(message string) bool {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":        message,
+			"order_id":     payment.OrderID,
+			"order_status": payment.Status,
+		})
+		return true
+	}
+
+	switch {
+	case !sameOrder(req, payment.Amount, payment.Currency) || payment.CustomerID != req.CustomerID:
+		return conflict("Order already exists with a different amount, currency or customer")
+	case payment.Gateway != "" && payment.Gateway != GatewayCashfree:
+		return conflict("Only Cashfree sessions can be reused")
+	case payment.Status != domain.PaymentCreated && payment.Status != domain.PaymentActive:
+		return conflict("Order is " + string(payment.Status) + " and its session cannot be reused")
+	}
+
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
+		return true
+	}
+
+	order, err := client.GetOrderStatus(payment.OrderID)
+	if err != nil {
+		log.Printf("Failed to get existing order %s: %v", payment.OrderID, err)
+		respondGatewayError(c, err, "Failed to create payment session")
+		return true
+	}
+	if message, ok := h.reusableOrder(order); !ok {
+		return conflict(message)
+	}
+
+	response := gin.H{
+		"order_id":     order.OrderID,
+		"cf_order_id":  order.CFOrderID,
+		"payment_link": order.PaymentLink,
+		"order_status": order.OrderStatus,
+		"amount":       payment.Amount,
+		"currency":     payment.Currency,
+		"gateway":      GatewayCashfree,
+		"reused":       true,
+	}
+	if order.PaymentSessionID != "" {
+		response["payment_session_id"] = order.PaymentSessionID
+	}
+	if theme := checkoutThemeFromTags(order.OrderTags); !theme.IsZero() {
+		response["checkout"] = theme
+	}
+
+	releaseQuota(c)
+	c.JSON(http.StatusOK, response)
+	return true
+}
+
+// answerExistingOrder answers a create-session request for an order ID the merchant
+// already stored, and reports whether it did: with the order's session when sessions are
+// reused and it can be, and 409 otherwise
+func (h *PaymentHandler) answerExistingOrder(ctx context.Context, c *gin.Context, req *CreatePaymentSessionRequest) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, req.OrderID)
+	if err != nil {
+		return false
+	}
+	if h.answerDuplicateSession(ctx, c, req) {
+		return true
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":        "Order already exists",
+		"order_id":     payment.OrderID,
+		"order_status": payment.Status,
+	})
+	return true
+}
+
+// respondPaymentSaveError answers a create-session request whose payment could not be
+// saved. An order ID the merchant used since the request was checked is a conflict.
+func respondPaymentSaveError(c *gin.Context, err error) {
+	if errors.Is(err, ErrOrderExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order already exists"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payment"})
+}
+
+// adoptExistingOrder picks up the gateway order a create-session request found already
+// created upstream but not stored, as when saving it failed after it was created. With
+// session reuse, the order is adopted when it was created for the same amount, currency
+// and customer and can still be paid.
+func (h *PaymentHandler) adoptExistingOrder(gateway PaymentGateway, req *CreatePaymentSessionRequest) (*CashfreeOrderResponse, bool) {
+	if !h.reusesSessions() {
+		return nil, false
+	}
+
+	order, err := gateway.GetOrderStatus(req.OrderID)
+	if err != nil {
+		log.Printf("Failed to get existing %s order %s: %v", gateway.Name(), req.OrderID, err)
+		return nil, false
+	}
+	if !sameOrder(req, order.OrderAmount, order.OrderCurrency) {
+		return nil, false
+	}
+	if order.CustomerDetails != nil && order.CustomerDetails.CustomerID != req.CustomerID {
+		return nil, false
+	}
+	if _, ok := h.reusableOrder(order); !ok {
+		return nil, false
+	}
+
+	resp := &CashfreeOrderResponse{
+		CFOrderID:        order.CFOrderID,
+		OrderID:          order.OrderID,
+		PaymentLink:      order.PaymentLink,
+		PaymentSessionID: order.PaymentSessionID,
+		OrderStatus:      order.OrderStatus,
+	}
+	if !order.OrderExpiryTime.IsZero() {
+		resp.OrderExpiryTime = order.OrderExpiryTime.Format(time.RFC3339)
+	}
+	return resp, true
+}
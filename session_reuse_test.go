@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePaymentSessionReusesActiveSession(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		orderURLs:    OrderURLs{ReturnURLTemplate: "https://shop.example.com/return?order_id={order_id}"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	post := func(orderID string, amount float64) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/payments/create-session", bytes.NewBufferString(fmt.Sprintf(`{
+			"order_id": %q, "amount": %v, "currency": "INR", "customer_id": "customer_001",
+			"customer_name": "John Doe", "customer_email": "john@example.com",
+			"customer_phone": "9876543210", "notify_url": "https://shop.example.com/notify"
+		}`, orderID, amount)))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	orderID := fmt.Sprintf("order_reuse_%d", time.Now().UnixNano())
+	w, created := post(orderID, 100)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, created["reused"])
+
+	// A repeated request gets the same session
+	w, repeated := post(orderID, 100)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, repeated["reused"])
+	assert.Equal(t, created["payment_session_id"], repeated["payment_session_id"])
+	assert.Equal(t, created["cf_order_id"], repeated["cf_order_id"])
+
+	// A different amount is not the same order
+	w, _ = post(orderID, 120)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// Paid orders have no session to reuse
+	_, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	w, _ = post(orderID, 100)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// An order created upstream but never stored is adopted
+	orphan := orderID + "_orphan"
+	upstream := testOrderRequest(orphan)
+	upstream.OrderAmount = 100
+	upstream.CustomerDetails.CustomerID = "customer_001"
+	_, err = client.CreateOrder(upstream)
+	require.NoError(t, err)
+	w, adopted := post(orphan, 100)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, true, adopted["reused"])
+	payment, err := handler.repo.GetPaymentByOrderID(context.Background(), orphan)
+	require.NoError(t, err)
+	assert.Equal(t, adopted["cf_order_id"], payment.CFOrderID)
+
+	// With reuse off, repeats are refused
+	handler.duplicateSessions = DuplicateSessionsReject
+	repeat := orderID + "_reject"
+	w, _ = post(repeat, 100)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w, _ = post(repeat, 100)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// SettlementBreakdown ties a settlement to its order: the order's gross amount, less the
+// gateway fee, the tax on it and the refunds taken out of the order, is the net payable.
+// The fee, tax and net payable are nil while the gateway has not reported the payment's
+// charges.
+type SettlementBreakdown struct {
+	Currency         string   `json:"currency"`
+	GrossAmount      float64  `json:"gross_amount"`
+	GatewayFee       *float64 `json:"gateway_fee"`
+	GatewayTax       *float64 `json:"gateway_tax"`
+	RefundAdjustment float64  `json:"refund_adjustment"`
+	NetPayable       *float64 `json:"net_payable"`
+
+	// Variance is the settled amount less the net payable; anything but zero needs a look
+	Variance *float64 `json:"variance,omitempty"`
+}
+
+// settlementColumns are the settlement columns scanSettlement reads, with the currency
+// of the settlement's order
+const settlementColumns = `
+	id, settlement_id, order_id, cf_order_id, amount, status,
+	utr, settled_at, created_at, updated_at,
+	gross_amount, gateway_fee, gateway_tax, refund_adjustment, net_payable,
+	(SELECT currency FROM payments WHERE payments.tenant_key = settlements.tenant_key AND payments.order_id = settlements.order_id)`
+
+// scanSettlement scans a row of settlementColumns
+func scanSettlement(row pgx.Row) (*Settlement, error) {
+	var settlement Settlement
+	var gross, refunds *float64
+	var currency *string
+	breakdown := &SettlementBreakdown{}
+
+	err := row.Scan(
+		&settlement.ID, &settlement.SettlementID, &settlement.OrderID,
+		&settlement.CFOrderID, &settlement.Amount, &settlement.Status,
+		&settlement.UTR, &settlement.SettledAt, &settlement.CreatedAt,
+		&settlement.UpdatedAt,
+		&gross, &breakdown.GatewayFee, &breakdown.GatewayTax, &refunds, &breakdown.NetPayable,
+		&currency,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if gross != nil {
+		breakdown.GrossAmount = *gross
+		breakdown.Currency = stringValue(currency)
+		if refunds != nil {
+			breakdown.RefundAdjustment = *refunds
+		}
+		if breakdown.NetPayable != nil {
+			variance := math.Round((settlement.Amount-*breakdown.NetPayable)*100) / 100
+			breakdown.Variance = &variance
+		}
+		settlement.Breakdown = breakdown
+	}
+	return &settlement, nil
+}
+
+// settlementBreakdownSQL computes the breakdown columns of a settlement from its payment
+// p and the order's successful refunds r.refunded. The net is what Cashfree reported it
+// settles for the payment, or the gross less the charges, less the refunds.
+const settlementBreakdownSQL = `
+	p.amount, p.gateway_fee,
+	CASE WHEN p.gateway_fee IS NOT NULL THEN COALESCE(p.gateway_tax, 0) END,
+	r.refunded,
+	COALESCE(p.net_amount, p.amount - p.gateway_fee - COALESCE(p.gateway_tax, 0)) - r.refunded`
+
+// settledBreakdown holds when a recorded settlement's breakdown is kept: once it has
+// succeeded, so refunds made after the payout are not taken out of it
+const settledBreakdown = `settlements.status = 'SUCCESS' AND settlements.gross_amount IS NOT NULL`
+
+// FormatSettlementsCSV renders settlements and their breakdowns as a CSV report with
+// times in loc
+func FormatSettlementsCSV(settlements []Settlement, loc *time.Location) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{
+		"settlement_id", "order_id", "cf_order_id", "status", "utr", "settled_at",
+		"currency", "gross_amount", "gateway_fee", "gateway_tax", "refund_adjustment",
+		"net_payable", "settled_amount", "variance",
+	}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, s := range settlements {
+		record := []string{
+			s.SettlementID,
+			s.OrderID,
+			s.CFOrderID,
+			string(s.Status),
+			stringValue(s.UTR),
+			timeValue(s.SettledAt, loc),
+			"", "", "", "", "", "",
+			formatAmount(s.Amount),
+			"",
+		}
+		if b := s.Breakdown; b != nil {
+			record[6] = b.Currency
+			record[7] = formatCurrencyAmount(b.GrossAmount, b.Currency)
+			record[8] = currencyAmountValue(b.GatewayFee, b.Currency)
+			record[9] = currencyAmountValue(b.GatewayTax, b.Currency)
+			record[10] = formatCurrencyAmount(b.RefundAdjustment, b.Currency)
+			record[11] = currencyAmountValue(b.NetPayable, b.Currency)
+			record[12] = formatCurrencyAmount(s.Amount, b.Currency)
+			record[13] = currencyAmountValue(b.Variance, b.Currency)
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Exports the settlements settled in a date range, with their breakdowns, as CSV
+func (h *ExportHandler) ExportSettlements(c *gin.Context) {
+	loc := h.locationFor(requestContext(c))
+	from, to, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	settlements, err := h.repo.ListSettledSettlementsBetween(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to get settlements for export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build settlements export"})
+		return
+	}
+
+	body, err := FormatSettlementsCSV(settlements, loc)
+	if err != nil {
+		log.Printf("Failed to format settlements export: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build settlements export"})
+		return
+	}
+
+	filename := fmt.Sprintf("settlements_%s_%s.csv", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/csv", body)
+}
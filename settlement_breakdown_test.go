@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSettlementsCSV(t *testing.T) {
+	settledAt := time.Date(2024, 4, 4, 10, 0, 0, 0, time.UTC)
+	utr := "UTR123"
+	fee, tax, net, variance := 2.0, 0.36, 77.64, 0.0
+
+	body, err := FormatSettlementsCSV([]Settlement{
+		{
+			SettlementID: "settle_1", OrderID: "order_1", CFOrderID: "cf_order_1", Status: "SUCCESS",
+			Amount: 77.64, UTR: &utr, SettledAt: &settledAt,
+			Breakdown: &SettlementBreakdown{
+				Currency: "INR", GrossAmount: 100, GatewayFee: &fee, GatewayTax: &tax,
+				RefundAdjustment: 20, NetPayable: &net, Variance: &variance,
+			},
+		},
+		{SettlementID: "settle_0", OrderID: "order_0", CFOrderID: "cf_order_0", Status: "SUCCESS", Amount: 50, SettledAt: &settledAt},
+	}, time.UTC)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "settlement_id,order_id,cf_order_id,status,utr,settled_at,currency,gross_amount,gateway_fee,gateway_tax,refund_adjustment,net_payable,settled_amount,variance", lines[0])
+	assert.Equal(t, "settle_1,order_1,cf_order_1,SUCCESS,UTR123,2024-04-04T10:00:00Z,INR,100.00,2.00,0.36,20.00,77.64,77.64,0.00", lines[1])
+	assert.Equal(t, "settle_0,order_0,cf_order_0,SUCCESS,,2024-04-04T10:00:00Z,,,,,,,50.00,", lines[2], "settlements without a breakdown")
+}
+
+func TestRecordSettlementBreakdown(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	amount := func(v float64) *float64 { return &v }
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+
+	// Until the gateway reports the payment's charges, the net is unknown
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 97.64, Status: "PENDING"}))
+	stored, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	require.NotNil(t, stored.Breakdown)
+	assert.Equal(t, "INR", stored.Breakdown.Currency)
+	assert.Equal(t, 100.0, stored.Breakdown.GrossAmount)
+	assert.Nil(t, stored.Breakdown.GatewayFee)
+	assert.Nil(t, stored.Breakdown.NetPayable)
+	assert.Nil(t, stored.Breakdown.Variance)
+
+	require.NoError(t, repo.SetPaymentCharges(ctx, "order_1", 2, 0.36, 97.64))
+	refund := &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 20, Status: "SUCCESS"}
+	require.NoError(t, repo.CreateRefund(ctx, refund))
+
+	settledAt := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 77.64, Status: "SUCCESS", SettledAt: &settledAt}))
+	settled, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	assert.Equal(t, &SettlementBreakdown{
+		Currency:         "INR",
+		GrossAmount:      100,
+		GatewayFee:       amount(2),
+		GatewayTax:       amount(0.36),
+		RefundAdjustment: 20,
+		NetPayable:       amount(77.64),
+		Variance:         amount(0),
+	}, settled.Breakdown)
+
+	// Refunds after the payout are not taken out of a settled breakdown
+	later := &Refund{RefundID: "refund_2", CFRefundID: "cf_refund_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 10, Status: "SUCCESS"}
+	require.NoError(t, repo.CreateRefund(ctx, later))
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 77.64, Status: "SUCCESS"}))
+	again, err := repo.GetSettlementByID(ctx, "settlement_1")
+	require.NoError(t, err)
+	assert.Equal(t, settled.Breakdown, again.Breakdown)
+
+	listed, err := repo.ListSettledSettlementsBetween(ctx, settledAt.Add(-time.Hour), settledAt.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, settled.Breakdown, listed[0].Breakdown)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// UTROrder is the order a settlement of a bank credit paid out
+type UTROrder struct {
+	OrderID     string               `json:"order_id"`
+	Amount      float64              `json:"amount"`
+	Currency    string               `json:"currency"`
+	Status      domain.PaymentStatus `json:"status"`
+	CFPaymentID *string              `json:"cf_payment_id,omitempty"`
+	PaymentTime *time.Time           `json:"payment_time,omitempty"`
+}
+
+// UTRSettlement is a settlement of a bank credit with its order and the order's vendor
+// splits
+type UTRSettlement struct {
+	Settlement
+	Order  *UTROrder         `json:"order,omitempty"`
+	Splits []SplitSettlement `json:"splits"`
+}
+
+// ListSettlementsByUTR returns the settlements paid out in the bank credit of a UTR
+func (r *PaymentRepository) ListSettlementsByUTR(ctx context.Context, utr string) ([]Settlement, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 2)
+	query := `
+		SELECT ` + settlementColumns + `
+		FROM settlements
+		WHERE utr = $1` + tenant + `
+		ORDER BY order_id, settlement_id
+	`
+
+	rows, err := r.db.Query(ctx, query, append([]interface{}{utr}, tenantArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settlements []Settlement
+	for rows.Next() {
+		settlement, err := scanSettlement(rows)
+		if err != nil {
+			return nil, err
+		}
+		settlements = append(settlements, *settlement)
+	}
+
+	return settlements, rows.Err()
+}
+
+// Gets the settlements of a bank credit by its UTR, with the orders they paid out and
+// the orders' vendor splits
+func (h *PaymentHandler) GetSettlementsByUTR(c *gin.Context) {
+	utr := strings.TrimSpace(c.Param("utr"))
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	settlements, err := h.repo.ListSettlementsByUTR(ctx, utr)
+	if err != nil {
+		log.Printf("Failed to get settlements by UTR: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settlements"})
+		return
+	}
+	if len(settlements) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No settlements found for the UTR"})
+		return
+	}
+
+	var total float64
+	result := make([]UTRSettlement, 0, len(settlements))
+	for _, settlement := range settlements {
+		total += settlement.Amount
+		item := UTRSettlement{Settlement: settlement, Splits: []SplitSettlement{}}
+
+		payment, err := h.repo.GetPaymentByOrderID(ctx, settlement.OrderID)
+		if err == nil {
+			item.Order = &UTROrder{
+				OrderID:     payment.OrderID,
+				Amount:      payment.Amount,
+				Currency:    payment.Currency,
+				Status:      payment.Status,
+				CFPaymentID: payment.CFPaymentID,
+				PaymentTime: payment.PaymentTime,
+			}
+		} else {
+			log.Printf("Failed to get order %s of settlement %s: %v", settlement.OrderID, settlement.SettlementID, err)
+		}
+
+		splits, err := h.repo.ListSplitSettlements(ctx, settlement.OrderID)
+		if err != nil {
+			log.Printf("Failed to get splits of order %s: %v", settlement.OrderID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve settlements"})
+			return
+		}
+		if splits != nil {
+			item.Splits = splits
+		}
+
+		result = append(result, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"utr":          utr,
+		"total_amount": math.Round(total*100) / 100,
+		"settlements":  result,
+	})
+}
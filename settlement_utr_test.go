@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSettlementsByUTR(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	utr := func(v string) *string { return &v }
+
+	for _, orderID := range []string{"order_1", "order_2", "order_3"} {
+		require.NoError(t, repo.CreatePayment(ctx, testPayment(orderID)))
+	}
+	require.NoError(t, repo.CreateSplitSettlement(ctx, []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_a", Amount: 60, SplitType: "AMOUNT", Status: "PENDING"},
+	}))
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", Amount: 97.64, Status: "SUCCESS", UTR: utr("UTR123")}))
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_2", OrderID: "order_2", Amount: 48.5, Status: "SUCCESS", UTR: utr("UTR123")}))
+	require.NoError(t, repo.RecordSettlement(ctx, &Settlement{SettlementID: "settlement_3", OrderID: "order_3", Amount: 10, Status: "SUCCESS", UTR: utr("UTR999")}))
+
+	handler := &PaymentHandler{repo: repo}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/settlements/by-utr/UTR123", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp struct {
+		UTR         string          `json:"utr"`
+		TotalAmount float64         `json:"total_amount"`
+		Settlements []UTRSettlement `json:"settlements"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "UTR123", resp.UTR)
+	assert.Equal(t, 146.14, resp.TotalAmount)
+	require.Len(t, resp.Settlements, 2)
+
+	first := resp.Settlements[0]
+	assert.Equal(t, "settlement_1", first.SettlementID)
+	require.NotNil(t, first.Order)
+	assert.Equal(t, "order_1", first.Order.OrderID)
+	assert.Equal(t, 100.0, first.Order.Amount)
+	require.Len(t, first.Splits, 1)
+	assert.Equal(t, "vendor_a", first.Splits[0].VendorID)
+
+	second := resp.Settlements[1]
+	assert.Equal(t, "settlement_2", second.SettlementID)
+	assert.Empty(t, second.Splits)
+
+	// Settlement IDs are still looked up next to the UTR route
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/settlements/settlement_3", nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/settlements/by-utr/UTR000", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+)
+
+// testModeEnabled reports whether the deployment can create TEST orders, and so serves
+// the payment simulation routes
+func testModeEnabled(cfg *Config) bool {
+	_, hasTest := cfg.Cashfree[EnvironmentTest]
+	return hasTest || cfg.CashfreeEnvironment == EnvironmentTest || cfg.MultiMerchant
+}
+
+// SimulatePaymentRequest describes a simulated payment attempt
+type SimulatePaymentRequest struct {
+	PaymentMethod string `json:"payment_method"` // defaults to "upi"
+}
+
+// CompleteTestPayment pays a TEST order as if Cashfree had sent PAYMENT_SUCCESS_WEBHOOK
+func (h *PaymentHandler) CompleteTestPayment(c *gin.Context) {
+	h.simulatePayment(c, domain.WebhookPaymentSuccess, domain.PaymentSuccess)
+}
+
+// FailTestPayment fails a payment attempt on a TEST order as if Cashfree had sent
+// PAYMENT_FAILED_WEBHOOK
+func (h *PaymentHandler) FailTestPayment(c *gin.Context) {
+	h.simulatePayment(c, domain.WebhookPaymentFailed, domain.PaymentFailed)
+}
+
+// simulatePayment builds the webhook Cashfree would send for a payment attempt and
+// applies it like a verified webhook, so frontends can be built against the local order
+// without a sandbox checkout. Only the local order changes; the order at Cashfree stays
+// unpaid.
+func (h *PaymentHandler) simulatePayment(c *gin.Context, eventType domain.WebhookType, paymentStatus domain.PaymentStatus) {
+	orderID := c.Param("order_id")
+
+	var req SimulatePaymentRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = "upi"
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	if payment.Gateway != "" && payment.Gateway != GatewayCashfree {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only Cashfree payments can be simulated"})
+		return
+	}
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client for %s: %v", orderID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to simulate payment"})
+		return
+	}
+	if strings.ToUpper(client.Environment) != EnvironmentTest {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Payments can only be simulated for TEST orders"})
+		return
+	}
+
+	// An order stays payable after a failed attempt
+	switch payment.Status {
+	case domain.PaymentCreated, domain.PaymentActive, domain.PaymentFailed:
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is already " + string(payment.Status)})
+		return
+	}
+
+	cfPaymentID := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	webhookData := WebhookData{
+		Type: string(eventType),
+		Data: map[string]interface{}{
+			"order_id":         orderID,
+			"cf_payment_id":    cfPaymentID,
+			"payment_status":   paymentStatus,
+			"payment_amount":   payment.Amount,
+			"payment_currency": payment.Currency,
+			"payment_method":   req.PaymentMethod,
+			"payment_time":     h.now().UTC().Format(time.RFC3339),
+		},
+	}
+	body, err := json.Marshal(webhookData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to simulate payment"})
+		return
+	}
+
+	queued := h.acceptWebhook(ctx, body, webhookData)
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id":       orderID,
+		"cf_payment_id":  cfPaymentID,
+		"payment_status": paymentStatus,
+		"webhook":        eventType,
+		"queued":         queued,
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestTestModeEnabled(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want bool
+	}{
+		{"test deployment", Config{CashfreeEnvironment: EnvironmentTest, Cashfree: map[string]CashfreeCredentials{EnvironmentTest: {}}}, true},
+		{"prod deployment", Config{CashfreeEnvironment: EnvironmentProd, Cashfree: map[string]CashfreeCredentials{EnvironmentProd: {}}}, false},
+		{"prod with test credentials", Config{CashfreeEnvironment: EnvironmentProd, Cashfree: map[string]CashfreeCredentials{EnvironmentProd: {}, EnvironmentTest: {}}}, true},
+		{"multi-merchant", Config{CashfreeEnvironment: EnvironmentProd, MultiMerchant: true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, testModeEnabled(&tt.cfg))
+		})
+	}
+}
+
+func TestSimulatePayment(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	sandbox := NewCashfreeClient("test_id", "test_secret", EnvironmentTest)
+	production := NewCashfreeClient("prod_id", "prod_secret", EnvironmentProd)
+	handler := &PaymentHandler{
+		cashfree:     sandbox,
+		repo:         NewPaymentRepository(db),
+		environments: map[string]*CashfreeClient{EnvironmentTest: sandbox, EnvironmentProd: production},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/test/payments/:order_id/complete", handler.CompleteTestPayment)
+	r.POST("/test/payments/:order_id/fail", handler.FailTestPayment)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	createPayment := func(orderID, env, gateway string) {
+		payment := testPayment(orderID)
+		payment.Status = "CREATED"
+		payment.Environment = &env
+		payment.Gateway = gateway
+		require.NoError(t, handler.repo.CreatePayment(ctx, payment))
+	}
+	createPayment("order_test", EnvironmentTest, GatewayCashfree)
+	createPayment("order_prod", EnvironmentProd, GatewayCashfree)
+	createPayment("order_razorpay", EnvironmentTest, GatewayRazorpay)
+
+	// A failed attempt leaves the order payable
+	w := post("/test/payments/order_test/fail", "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	payment, err := handler.repo.GetPaymentByOrderID(ctx, "order_test")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentFailed, payment.Status)
+
+	w = post("/test/payments/order_test/complete", `{"payment_method": "card"}`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response struct {
+		CFPaymentID   string `json:"cf_payment_id"`
+		PaymentStatus string `json:"payment_status"`
+		Webhook       string `json:"webhook"`
+		Queued        bool   `json:"queued"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "SUCCESS", response.PaymentStatus)
+	assert.Equal(t, "PAYMENT_SUCCESS_WEBHOOK", response.Webhook)
+	assert.False(t, response.Queued)
+
+	payment, err = handler.repo.GetPaymentByOrderID(ctx, "order_test")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentSuccess, payment.Status)
+	require.NotNil(t, payment.CFPaymentID)
+	assert.Equal(t, response.CFPaymentID, *payment.CFPaymentID)
+	require.NotNil(t, payment.PaymentMethod)
+	assert.Equal(t, "card", *payment.PaymentMethod)
+	assert.NotNil(t, payment.PaymentTime)
+
+	// The simulated webhooks are logged like real ones
+	var logged int
+	require.NoError(t, db.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE order_id = 'order_test'`).Scan(&logged))
+	assert.Equal(t, 2, logged)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/test/payments/order_test/complete", http.StatusConflict},
+		{"/test/payments/order_prod/complete", http.StatusForbidden},
+		{"/test/payments/order_razorpay/complete", http.StatusBadRequest},
+		{"/test/payments/missing/complete", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := post(tt.path, "")
+		assert.Equal(t, tt.code, w.Code, "%s: %s", tt.path, w.Body.String())
+	}
+
+	payment, err = handler.repo.GetPaymentByOrderID(ctx, "order_prod")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentCreated, payment.Status)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SLAMetric summarizes how long one kind of work took, in seconds
+type SLAMetric struct {
+	Count int     `json:"count"`
+	Avg   float64 `json:"avg_seconds"`
+	P50   float64 `json:"p50_seconds"`
+	P95   float64 `json:"p95_seconds"`
+	Max   float64 `json:"max_seconds"`
+}
+
+// SLAStats is the service a merchant got over a period, read from the event store
+// except for Verify
+type SLAStats struct {
+	// WebhookToUpdate is from a webhook being received to it being applied to the order
+	WebhookToUpdate SLAMetric `json:"webhook_to_update"`
+	// Verify is how long verify calls took to answer since the instance started, from
+	// the in-process histogram
+	Verify SLAMetric `json:"verify"`
+	// RefundTurnaround is from a refund being requested to Cashfree processing it
+	RefundTurnaround SLAMetric `json:"refund_turnaround"`
+}
+
+// slaSummary aggregates the seconds column s of a samples subquery
+const slaSummary = `
+	SELECT COUNT(*), COALESCE(AVG(s), 0),
+		   COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY s), 0),
+		   COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY s), 0),
+		   COALESCE(MAX(s), 0)
+	FROM (%s) samples
+`
+
+// slaSamples select the seconds each piece of work finished in [$1, $2) took, for the
+// tenant condition appended to the innermost events read
+var slaSamples = map[string]string{
+	// Each applied webhook against the latest receipt of its type for the order
+	"webhook_to_update": `
+		SELECT EXTRACT(EPOCH FROM applied.occurred_at - received.occurred_at) AS s
+		FROM (
+			SELECT tenant_key, order_id, order_sequence, data, occurred_at
+			FROM events
+			WHERE type = 'webhook.applied' AND occurred_at >= $1 AND occurred_at < $2%s
+		) applied
+		CROSS JOIN LATERAL (
+			SELECT occurred_at
+			FROM events
+			WHERE tenant_key = applied.tenant_key AND order_id = applied.order_id AND type = 'webhook.received'
+			  AND data->>'webhook_type' = applied.data->>'webhook_type'
+			  AND order_sequence < applied.order_sequence
+			ORDER BY order_sequence DESC
+			LIMIT 1
+		) received`,
+	// Each successful refund against its request
+	"refund_turnaround": `
+		SELECT EXTRACT(EPOCH FROM processed.occurred_at - requested.occurred_at) AS s
+		FROM (
+			SELECT tenant_key, order_id, data, occurred_at
+			FROM events
+			WHERE type = 'refund.processed' AND data->>'to' = 'SUCCESS'
+			  AND occurred_at >= $1 AND occurred_at < $2%s
+		) processed
+		JOIN events requested
+		  ON requested.tenant_key = processed.tenant_key AND requested.order_id = processed.order_id
+		 AND requested.type = 'refund.requested'
+		 AND requested.data->>'refund_id' = processed.data->>'refund_id'`,
+}
+
+// GetSLAStats summarizes the latencies of work finished in [from, to)
+func (r *PaymentRepository) GetSLAStats(ctx context.Context, from, to time.Time) (*SLAStats, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	args := append([]interface{}{from, to}, tenantArgs...)
+
+	stats := &SLAStats{}
+	metrics := map[string]*SLAMetric{
+		"webhook_to_update": &stats.WebhookToUpdate,
+		"refund_turnaround": &stats.RefundTurnaround,
+	}
+	for name, metric := range metrics {
+		query := fmt.Sprintf(slaSummary, fmt.Sprintf(slaSamples[name], tenant))
+		err := r.db.QueryRow(ctx, query, args...).Scan(&metric.Count, &metric.Avg, &metric.P50, &metric.P95, &metric.Max)
+		if err != nil {
+			return nil, err
+		}
+		metric.Avg = roundMillis(metric.Avg)
+		metric.P50 = roundMillis(metric.P50)
+		metric.P95 = roundMillis(metric.P95)
+		metric.Max = roundMillis(metric.Max)
+	}
+	return stats, nil
+}
+
+// roundMillis rounds seconds to the millisecond
+func roundMillis(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
+
+// recordVerified counts how long a verify call took in the verify latency histogram
+func (h *PaymentHandler) recordVerified(ctx context.Context, started time.Time) {
+	h.verifyLatency.Observe(ctx, h.now().Sub(started))
+}
+
+// Gets the latencies of the service the merchant got in a date range
+func (h *ExportHandler) GetSLAStats(c *gin.Context) {
+	from, to, err := parseDateRange(c, h.locationFor(requestContext(c)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	stats, err := h.repo.GetSLAStats(ctx, from, to)
+	if err != nil {
+		log.Printf("Failed to get SLA stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get SLA stats"})
+		return
+	}
+	stats.Verify = h.verifyLatency.Summary(ctx)
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":              from,
+		"to":                to,
+		"webhook_to_update": stats.WebhookToUpdate,
+		"verify":            stats.Verify,
+		"refund_turnaround": stats.RefundTurnaround,
+	})
+}
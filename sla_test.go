@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestSLAStats(t *testing.T) {
+	db := testDB(t)
+	repo := NewPaymentRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	orderID := "order_1"
+	webhook := &Webhook{EventType: domain.WebhookPaymentSuccess, OrderID: &orderID, Payload: `{}`, Status: domain.WebhookReceived}
+	require.NoError(t, repo.CreateWebhookLog(ctx, webhook))
+	require.NoError(t, repo.AppendDomainEvent(ctx, "order_1", DomainWebhookApplied, map[string]string{"webhook_type": "PAYMENT_SUCCESS_WEBHOOK"}))
+	verifyLatency := NewVerifyLatency()
+	verifyLatency.Observe(ctx, 250*time.Millisecond)
+	verifyLatency.Observe(ctx, 1250*time.Millisecond)
+	refund := &Refund{RefundID: "refund_1", CFRefundID: "cf_refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 40, Status: "PENDING"}
+	require.NoError(t, repo.CreateRefund(ctx, refund))
+	processedAt := time.Now()
+	require.NoError(t, repo.UpdateRefundStatus(ctx, "refund_1", domain.RefundSuccess, &processedAt))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/analytics/sla", (&ExportHandler{repo: repo, location: time.UTC, verifyLatency: verifyLatency}).GetSLAStats)
+
+	today := time.Now().UTC().Format("2006-01-02")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/analytics/sla?from="+today+"&to="+today, nil))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var stats SLAStats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 1, stats.WebhookToUpdate.Count)
+	assert.GreaterOrEqual(t, stats.WebhookToUpdate.Avg, 0.0)
+	assert.Equal(t, SLAMetric{Count: 2, Avg: 0.75, P50: 0.25, P95: 1.225, Max: 1.25}, stats.Verify)
+	assert.Equal(t, 1, stats.RefundTurnaround.Count)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/analytics/sla?from="+today, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
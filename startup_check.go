@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// What the service does at startup when Cashfree rejects its credentials
+const (
+	StartupCheckFail = "fail" // exit before serving traffic
+	StartupCheckWarn = "warn" // serve, with readiness reporting the instance degraded
+	StartupCheckOff  = "off"  // skip the check
+)
+
+// CredentialProblem is what the startup check found wrong with the Cashfree credentials
+// of one environment
+type CredentialProblem struct {
+	Environment string
+	Err         error  // ErrInvalidCredentials, or why Cashfree could not be reached
+	Message     string // what is wrong and how to fix it
+}
+
+// Rejected reports whether Cashfree rejected the credentials, rather than not answering
+func (p CredentialProblem) Rejected() bool {
+	return errors.Is(p.Err, ErrInvalidCredentials)
+}
+
+// otherEnvironment returns the Cashfree environment that is not env
+func otherEnvironment(env string) string {
+	if env == EnvironmentProd {
+		return EnvironmentTest
+	}
+	return EnvironmentProd
+}
+
+// checkCashfreeCredentials makes an authenticated Cashfree call with each environment's
+// credentials and returns the problems found, by environment. Credentials Cashfree
+// rejects are tried against the other environment too, to tell keys configured for the
+// wrong environment from keys that are wrong.
+func checkCashfreeCredentials(creds map[string]CashfreeCredentials, validate func(clientID, clientSecret, environment string) error) []CredentialProblem {
+	envs := make([]string, 0, len(creds))
+	for env := range creds {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+
+	var problems []CredentialProblem
+	for _, env := range envs {
+		c := creds[env]
+		err := validate(c.ClientID, c.ClientSecret, env)
+		if err == nil {
+			continue
+		}
+
+		problem := CredentialProblem{Environment: env, Err: err}
+		switch {
+		case !errors.Is(err, ErrInvalidCredentials):
+			problem.Message = fmt.Sprintf("could not reach Cashfree %s to check client %s, which is used unchecked: %v", env, c.ClientID, err)
+		case validate(c.ClientID, c.ClientSecret, otherEnvironment(env)) == nil:
+			problem.Message = fmt.Sprintf("client %s belongs to Cashfree's %s environment but is configured for %s: set CASHFREE_ENVIRONMENT=%s, or use the %s app's keys",
+				c.ClientID, otherEnvironment(env), env, strings.ToLower(otherEnvironment(env)), env)
+		default:
+			problem.Message = fmt.Sprintf("Cashfree %s rejected client %s: check the client ID and secret against the %s app's API keys in the Cashfree dashboard",
+				env, c.ClientID, env)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCashfreeCredentials(t *testing.T) {
+	// Each client's keys are valid in the environment it belongs to
+	belongs := map[string]string{"test_ok": EnvironmentTest, "sandbox_keys": EnvironmentTest}
+	validate := func(clientID, clientSecret, environment string) error {
+		switch {
+		case clientID == "unreachable":
+			return errors.New("dial tcp: i/o timeout")
+		case belongs[clientID] == environment:
+			return nil
+		default:
+			return ErrInvalidCredentials
+		}
+	}
+
+	problems := checkCashfreeCredentials(map[string]CashfreeCredentials{
+		EnvironmentTest: {ClientID: "test_ok", ClientSecret: "secret"},
+	}, validate)
+	assert.Empty(t, problems)
+
+	problems = checkCashfreeCredentials(map[string]CashfreeCredentials{
+		EnvironmentProd: {ClientID: "sandbox_keys", ClientSecret: "secret"},
+	}, validate)
+	require.Len(t, problems, 1)
+	assert.True(t, problems[0].Rejected())
+	assert.Equal(t, "client sandbox_keys belongs to Cashfree's TEST environment but is configured for PROD: set CASHFREE_ENVIRONMENT=test, or use the PROD app's keys", problems[0].Message)
+
+	problems = checkCashfreeCredentials(map[string]CashfreeCredentials{
+		EnvironmentTest: {ClientID: "revoked", ClientSecret: "secret"},
+		EnvironmentProd: {ClientID: "unreachable", ClientSecret: "secret"},
+	}, validate)
+	require.Len(t, problems, 2)
+	assert.Equal(t, EnvironmentProd, problems[0].Environment)
+	assert.False(t, problems[0].Rejected(), "an unreachable Cashfree is not a rejection")
+	assert.Contains(t, problems[0].Message, "i/o timeout")
+	assert.Equal(t, EnvironmentTest, problems[1].Environment)
+	assert.True(t, problems[1].Rejected())
+	assert.Contains(t, problems[1].Message, "Cashfree TEST rejected client revoked")
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// statusRefreshTimeout bounds a background refresh of an order's status
+const statusRefreshTimeout = 10 * time.Second
+
+// StatusCacheConfig configures serving order statuses from the database while they are
+// refreshed from the gateway in the background
+type StatusCacheConfig struct {
+	// TTL is how long a status is served without asking the gateway again; 0 asks the
+	// gateway on every read, while the client waits
+	TTL time.Duration
+	// MaxRefreshes caps the refreshes running at once, so a slow gateway cannot pile
+	// them up; reads beyond it serve the local status and leave it to a later read
+	MaxRefreshes int
+}
+
+// refreshableStatuses are the order statuses the gateway may still change
+var refreshableStatuses = map[domain.PaymentStatus]bool{
+	domain.PaymentCreated:              true,
+	domain.PaymentActive:               true,
+	domain.PaymentFailed:               true,
+	domain.PaymentTerminationRequested: true,
+}
+
+// StatusChanges wakes the status streams of an order when its status changes in this
+// process. Streams on other instances see the change on their next database poll.
+type StatusChanges struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan struct{}]struct{}
+}
+
+// NewStatusChanges creates a broadcaster with no watchers
+func NewStatusChanges() *StatusChanges {
+	return &StatusChanges{watchers: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Watch returns a channel that receives when orderID's status changes, and a function
+// that stops watching. A nil StatusChanges returns a channel that never receives.
+func (s *StatusChanges) Watch(orderID string) (<-chan struct{}, func()) {
+	if s == nil {
+		return nil, func() {}
+	}
+
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	if s.watchers[orderID] == nil {
+		s.watchers[orderID] = make(map[chan struct{}]struct{})
+	}
+	s.watchers[orderID][ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.watchers[orderID], ch)
+		if len(s.watchers[orderID]) == 0 {
+			delete(s.watchers, orderID)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Notify wakes orderID's watchers. Watchers not yet woken by an earlier change are
+// woken once.
+func (s *StatusChanges) Notify(orderID string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers[orderID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StatusRefresher decides when a locally stored status is stale and refreshes it from
+// the gateway in the background, so reads answer from the database while the gateway is
+// slow. Refreshed statuses are applied as resync applies them and pushed to the order's
+// status streams.
+type StatusRefresher struct {
+	payments *PaymentHandler
+	cfg      StatusCacheConfig
+	clock    Clock
+	slots    chan struct{}
+
+	mu        sync.Mutex
+	refreshed map[string]time.Time // when each order was last read from the gateway
+	inflight  map[string]bool
+	wg        sync.WaitGroup
+}
+
+// NewStatusRefresher creates a refresher that applies statuses through the payment handler
+func NewStatusRefresher(payments *PaymentHandler, cfg StatusCacheConfig) *StatusRefresher {
+	return &StatusRefresher{
+		payments:  payments,
+		cfg:       cfg,
+		clock:     SystemClock,
+		slots:     make(chan struct{}, max(cfg.MaxRefreshes, 1)),
+		refreshed: make(map[string]time.Time),
+		inflight:  make(map[string]bool),
+	}
+}
+
+// now returns the time statuses are aged against
+func (s *StatusRefresher) now() time.Time {
+	return clockOrSystem(s.clock).Now()
+}
+
+// Stale reports whether payment's status may have changed at the gateway and was not
+// written or read from the gateway within the TTL
+func (s *StatusRefresher) Stale(payment *Payment) bool {
+	if !refreshableStatuses[payment.Status] || payment.CFOrderID == "" {
+		return false
+	}
+
+	checked := payment.UpdatedAt
+	s.mu.Lock()
+	if at, ok := s.refreshed[payment.OrderID]; ok && at.After(checked) {
+		checked = at
+	}
+	s.mu.Unlock()
+
+	return s.now().Sub(checked) >= s.cfg.TTL
+}
+
+// Refresh starts refreshing payment's status from the gateway unless a refresh of the
+// order is already running, and reports whether one is. It does not start one when
+// MaxRefreshes are running. The refresh keeps ctx's values but not its deadline, so it
+// outlives the request that started it.
+func (s *StatusRefresher) Refresh(ctx context.Context, payment *Payment) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inflight[payment.OrderID] {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return false
+	}
+	s.inflight[payment.OrderID] = true
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), statusRefreshTimeout)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		defer func() { <-s.slots }()
+		s.refresh(ctx, *payment)
+	}()
+	return true
+}
+
+// refresh reads payment's status from the gateway and applies it
+func (s *StatusRefresher) refresh(ctx context.Context, payment Payment) {
+	status, err := s.payments.resyncPayment(ctx, &payment)
+
+	s.mu.Lock()
+	delete(s.inflight, payment.OrderID)
+	if err == nil {
+		now := s.now()
+		s.refreshed[payment.OrderID] = now
+		// Entries older than the TTL no longer keep an order fresh
+		for orderID, at := range s.refreshed {
+			if now.Sub(at) >= s.cfg.TTL {
+				delete(s.refreshed, orderID)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to refresh the status of order %s: %v", payment.OrderID, err)
+		return
+	}
+	if err := s.payments.repo.MarkStatusPolled(ctx, payment.OrderID, s.now()); err != nil {
+		log.Printf("Failed to record the status refresh of order %s: %v", payment.OrderID, err)
+	}
+	if status != payment.Status {
+		s.payments.statusChanges.Notify(payment.OrderID)
+	}
+}
+
+// Wait blocks until the running refreshes finish
+func (s *StatusRefresher) Wait() {
+	s.wg.Wait()
+}
+
+// serveCachedStatus refreshes payment in the background when it is stale, marking the
+// response as served stale
+func (h *PaymentHandler) serveCachedStatus(ctx context.Context, c *gin.Context, payment *Payment) {
+	if h.statusCache == nil {
+		return
+	}
+	if h.statusCache.Stale(payment) && h.statusCache.Refresh(ctx, payment) {
+		c.Header("X-Status-Stale", "true")
+	}
+}
+
+// verifyFromCache answers a verification from the stored payment, in the shape of a
+// verification answered by the gateway
+func (h *PaymentHandler) verifyFromCache(ctx context.Context, c *gin.Context, payment *Payment) {
+	h.serveCachedStatus(ctx, c, payment)
+
+	// The order of a successful payment is PAID
+	status := payment.Status
+	if status == domain.PaymentSuccess {
+		status = domain.PaymentPaid
+	}
+
+	response := gin.H{
+		"order_id":     payment.OrderID,
+		"cf_order_id":  payment.CFOrderID,
+		"order_status": status,
+		"order_amount": payment.Amount,
+	}
+
+	if status == domain.PaymentPaid && payment.CFPaymentID != nil {
+		response["cf_payment_id"] = *payment.CFPaymentID
+		response["payment_method"] = stringValue(payment.PaymentMethod)
+		response["payment_time"] = payment.PaymentTime
+		response["payment_amount"] = payment.Amount
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestStatusChanges(t *testing.T) {
+	changes := NewStatusChanges()
+	first, stopFirst := changes.Watch("order_1")
+	second, stopSecond := changes.Watch("order_1")
+	other, stopOther := changes.Watch("order_2")
+	defer stopOther()
+
+	// Repeated changes wake a watcher once
+	changes.Notify("order_1")
+	changes.Notify("order_1")
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 1)
+	assert.Empty(t, other)
+
+	stopFirst()
+	stopSecond()
+	assert.NotContains(t, changes.watchers, "order_1")
+
+	// Without a broadcaster nothing is ever woken
+	var none *StatusChanges
+	ch, stop := none.Watch("order_1")
+	none.Notify("order_1")
+	stop()
+	assert.Nil(t, ch)
+}
+
+func TestStatusRefresherStale(t *testing.T) {
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	refresher := NewStatusRefresher(&PaymentHandler{}, StatusCacheConfig{TTL: 30 * time.Second, MaxRefreshes: 1})
+	refresher.clock = clock
+
+	payment := testPayment("order_1")
+	payment.UpdatedAt = clock.Now()
+	assert.False(t, refresher.Stale(payment))
+
+	clock.Advance(30 * time.Second)
+	assert.True(t, refresher.Stale(payment))
+
+	// Statuses the gateway no longer changes are never stale
+	payment.Status = domain.PaymentPaid
+	assert.False(t, refresher.Stale(payment))
+
+	// Nor are orders not yet created at the gateway
+	payment.Status = domain.PaymentScheduled
+	payment.CFOrderID = ""
+	assert.False(t, refresher.Stale(payment))
+}
+
+func TestGetPaymentDetailsServesCachedStatus(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+	clock := newTestClock(time.Now())
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+
+	handler := &PaymentHandler{
+		cashfree:      client,
+		repo:          repo,
+		gateways:      NewGatewayRouter(client, nil, false),
+		environments:  map[string]*CashfreeClient{"TEST": client},
+		statusChanges: NewStatusChanges(),
+	}
+	handler.statusCache = NewStatusRefresher(handler, StatusCacheConfig{TTL: 30 * time.Second, MaxRefreshes: 4})
+	handler.statusCache.clock = clock
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+
+	get := func() (*httptest.ResponseRecorder, Payment) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/payments/order_cached", nil))
+		var payment Payment
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &payment))
+		return w, payment
+	}
+
+	ctx := context.Background()
+	payment := testPayment("order_cached")
+	payment.Amount = 499.5
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+	_, err := client.CreateOrder(testOrderRequest("order_cached"))
+	require.NoError(t, err)
+	changed, stop := handler.statusChanges.Watch("order_cached")
+	defer stop()
+
+	_, err = server.CompletePayment("order_cached", "upi")
+	require.NoError(t, err)
+
+	// A fresh status is served as stored
+	w, stored := get()
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, domain.PaymentActive, stored.Status)
+	assert.Empty(t, w.Header().Get("X-Status-Stale"))
+
+	// A stale one is served too, while it is refreshed in the background
+	clock.Advance(time.Minute)
+	w, stored = get()
+	assert.Equal(t, domain.PaymentActive, stored.Status)
+	assert.Equal(t, "true", w.Header().Get("X-Status-Stale"))
+	handler.statusCache.Wait()
+	assert.Len(t, changed, 1, "status streams are woken")
+
+	w, stored = get()
+	assert.Equal(t, domain.PaymentPaid, stored.Status)
+	assert.Empty(t, w.Header().Get("X-Status-Stale"))
+
+	// Verification answers from the refreshed status
+	req := httptest.NewRequest(http.MethodPost, "/payments/verify", bytes.NewBufferString(`{"order_id": "order_cached"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var verified map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &verified))
+	assert.Equal(t, "PAID", verified["order_status"])
+	assert.NotEmpty(t, verified["cf_payment_id"])
+}
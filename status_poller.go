@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"payment-getway/events"
+)
+
+// orderExpiry is how long an order stays payable after it is created at the gateway
+const orderExpiry = 24 * time.Hour
+
+// StatusPollerConfig configures polling the gateway for orders whose webhook has not
+// arrived
+type StatusPollerConfig struct {
+	Enabled bool
+	// After is how long an order waits for a webhook before it is polled, and Interval
+	// the least time between two polls of the same order
+	After    time.Duration
+	Interval time.Duration
+	// RatePerSecond caps the status calls to each Cashfree account, below its API rate
+	// limit so checkout traffic keeps headroom
+	RatePerSecond float64
+	BatchSize     int // orders polled in one run of the job
+}
+
+// StatusPollRun summarizes one run of the poller
+type StatusPollRun struct {
+	StartedAt   time.Time     `json:"started_at"`
+	Duration    time.Duration `json:"duration"`
+	Checked     int           `json:"checked"`
+	Updated     int           `json:"updated"`
+	Failed      int           `json:"failed"`
+	RateLimited bool          `json:"rate_limited"` // the gateway refused a call, so the run stopped early
+
+	// Refunds whose gateway call had no definite answer, checked and settled
+	RefundsChecked int `json:"refunds_checked"`
+	RefundsSettled int `json:"refunds_settled"`
+}
+
+// StatusPollerStats are the poller's metrics: the orders due a poll, how long the
+// longest waiting one has been due, and the latest run
+type StatusPollerStats struct {
+	Backlog    int            `json:"backlog"`
+	LagSeconds float64        `json:"lag_seconds"`
+	LastRun    *StatusPollRun `json:"last_run,omitempty"`
+}
+
+// pollableCondition selects ACTIVE orders created at the gateway at least $1 ago and not
+// polled since $2
+const pollableCondition = `
+		status = 'ACTIVE' AND cf_order_id IS NOT NULL
+		  AND COALESCE(activate_at, created_at) <= $1
+		  AND (status_polled_at IS NULL OR status_polled_at <= $2)`
+
+// ListPollablePayments returns up to limit ACTIVE orders that have waited for a webhook
+// since createdBefore and were not polled since polledBefore, closest to expiry first
+func (r *PaymentRepository) ListPollablePayments(ctx context.Context, createdBefore, polledBefore time.Time, limit int) ([]Payment, error) {
+	query := `
+		SELECT ` + paymentColumns + `
+		FROM payments
+		WHERE` + pollableCondition + `
+		ORDER BY COALESCE(activate_at, created_at)
+		LIMIT $3
+	`
+
+	rows, err := r.db.Query(ctx, query, createdBefore, polledBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+
+	return payments, rows.Err()
+}
+
+// MarkStatusPolled records that an order's status was polled at
+func (r *PaymentRepository) MarkStatusPolled(ctx context.Context, orderID string, at time.Time) error {
+	tenant, tenantArgs := tenantCondition(ctx, "AND", 3)
+	query := `UPDATE payments SET status_polled_at = $2 WHERE order_id = $1` + tenant
+	_, err := r.db.Exec(ctx, query, append([]interface{}{orderID, at}, tenantArgs...)...)
+	return err
+}
+
+// CountPollablePayments counts the orders ListPollablePayments would return without a
+// limit at now, and returns the time the longest waiting of them fell due
+func (r *PaymentRepository) CountPollablePayments(ctx context.Context, now, createdBefore, polledBefore time.Time) (int, *time.Time, error) {
+	// Shifting when an order was created or last polled by how far the bounds are from
+	// now gives when it fell due
+	query := `
+		SELECT COUNT(*), MIN(GREATEST(
+			COALESCE(activate_at, created_at) + ($3::timestamptz - $1::timestamptz),
+			status_polled_at + ($3::timestamptz - $2::timestamptz)
+		))
+		FROM payments
+		WHERE` + pollableCondition
+
+	var count int
+	var dueSince *time.Time
+	err := r.db.QueryRow(ctx, query, createdBefore, polledBefore, now).Scan(&count, &dueSince)
+	if err != nil {
+		return 0, nil, err
+	}
+	return count, dueSince, nil
+}
+
+// ListUnconfirmedRefunds returns up to limit refunds created before createdBefore that
+// are still PENDING without a gateway refund ID, oldest first: those whose creation call
+// failed without a definite answer and that no webhook has settled
+func (r *PaymentRepository) ListUnconfirmedRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]Refund, error) {
+	query := `
+		SELECT ` + refundColumns + `
+		FROM refunds
+		WHERE status = 'PENDING' AND cf_refund_id IS NULL AND created_at <= $1
+		ORDER BY created_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.Query(ctx, query, createdBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refunds := []Refund{}
+	for rows.Next() {
+		refund, err := scanRefund(rows)
+		if err != nil {
+			return nil, err
+		}
+		refunds = append(refunds, *refund)
+	}
+
+	return refunds, rows.Err()
+}
+
+// confirmRefund settles a refund of payment whose creation call failed without a
+// definite answer. It is released from the order's balance when the gateway has no such
+// refund, and saved as the gateway reports it otherwise.
+func (h *PaymentHandler) confirmRefund(ctx context.Context, payment *Payment, refund *Refund) error {
+	remote, err := h.lookUpRefund(ctx, payment, refund)
+	if errors.Is(err, ErrRefundNotFound) {
+		log.Printf("Refund %s was not made at the gateway; releasing it", refund.RefundID)
+		return h.repo.ReleaseRefund(ctx, refund.RefundID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := h.repo.SetRefundGatewayResult(ctx, refund.RefundID, remote); err != nil {
+		return err
+	}
+	refund.CFRefundID = remote.CFRefundID
+	refund.Status = remote.RefundStatus
+	h.publishEvent(ctx, events.RefundCreated, refund.OrderID, refundPayload(refund, payment))
+	return nil
+}
+
+// refundStatusGetter is implemented by gateways that can look up a refund by our refund ID
+type refundStatusGetter interface {
+	GetRefundStatus(orderID, refundID string) (*CashfreeRefundResponse, error)
+}
+
+// lookUpRefund asks payment's gateway for a refund, through Cashfree's batch quota for
+// Cashfree orders
+func (h *PaymentHandler) lookUpRefund(ctx context.Context, payment *Payment, refund *Refund) (*CashfreeRefundResponse, error) {
+	if payment.Gateway != "" && payment.Gateway != GatewayCashfree {
+		gateway, err := h.gatewayFor(ctx, payment)
+		if err != nil {
+			return nil, err
+		}
+		lookup, ok := gateway.(refundStatusGetter)
+		if !ok {
+			return nil, fmt.Errorf("gateway %s cannot look up refunds", gateway.Name())
+		}
+		return lookup.GetRefundStatus(refund.OrderID, refund.RefundID)
+	}
+
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	return client.ForBatch().GetRefundStatus(refund.OrderID, refund.RefundID)
+}
+
+// StatusPoller polls the gateway for orders whose webhook has not arrived, so the local
+// status catches up without frontends calling verify. Orders closest to expiry are
+// polled first, within a per-account rate limit.
+type StatusPoller struct {
+	payments *PaymentHandler
+	cfg      StatusPollerConfig
+	limiter  *RateLimiter
+	clock    Clock
+
+	mu      sync.Mutex
+	lastRun *StatusPollRun
+}
+
+// NewStatusPoller creates a poller that applies statuses through the payment handler
+func NewStatusPoller(payments *PaymentHandler, cfg StatusPollerConfig) *StatusPoller {
+	return &StatusPoller{
+		payments: payments,
+		cfg:      cfg,
+		limiter:  NewRateLimiter(cfg.RatePerSecond, 1),
+		clock:    SystemClock,
+	}
+}
+
+// now returns the time orders are measured against
+func (p *StatusPoller) now() time.Time {
+	return clockOrSystem(p.clock).Now()
+}
+
+// due returns the bounds of the orders due a poll at now
+func (p *StatusPoller) due(now time.Time) (time.Time, time.Time) {
+	return now.Add(-p.cfg.After), now.Add(-p.cfg.Interval)
+}
+
+// wait blocks until key may make another gateway call
+func (p *StatusPoller) wait(ctx context.Context, key string) error {
+	for {
+		ok, wait := p.limiter.Allow(key, time.Now())
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Poll checks a batch of due orders with the gateway and applies what changed. A rate
+// limited call ends the run, leaving the remaining orders for the next one.
+func (p *StatusPoller) Poll(ctx context.Context) (*StatusPollRun, error) {
+	run := &StatusPollRun{StartedAt: p.now()}
+	defer func() {
+		run.Duration = p.now().Sub(run.StartedAt)
+		p.mu.Lock()
+		p.lastRun = run
+		p.mu.Unlock()
+	}()
+
+	createdBefore, polledBefore := p.due(run.StartedAt)
+	payments, err := p.payments.repo.ListPollablePayments(ctx, createdBefore, polledBefore, p.cfg.BatchSize)
+	if err != nil {
+		return run, err
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		ctx := WithTenantID(ctx, payment.TenantID)
+		if err := p.wait(ctx, pollKey(payment)); err != nil {
+			return run, err
+		}
+
+		run.Checked++
+		status, err := p.payments.resyncPayment(ctx, payment)
+		if errors.Is(err, ErrRateLimited) {
+			run.RateLimited = true
+			log.Printf("Status poller rate limited by the gateway; %d orders left for the next run", len(payments)-i)
+			return run, nil
+		}
+		if err != nil {
+			run.Failed++
+			log.Printf("Failed to poll the status of order %s: %v", payment.OrderID, err)
+		} else if status != payment.Status {
+			run.Updated++
+			p.payments.statusChanges.Notify(payment.OrderID)
+		}
+
+		// Failed orders wait an interval too, so one bad order cannot hold up the rest
+		if err := p.payments.repo.MarkStatusPolled(ctx, payment.OrderID, p.now()); err != nil {
+			return run, err
+		}
+	}
+
+	return run, p.confirmRefunds(ctx, run, createdBefore)
+}
+
+// confirmRefunds settles a batch of refunds created before createdBefore whose gateway
+// call had no definite answer, so they stop holding the order's balance
+func (p *StatusPoller) confirmRefunds(ctx context.Context, run *StatusPollRun, createdBefore time.Time) error {
+	refunds, err := p.payments.repo.ListUnconfirmedRefunds(ctx, createdBefore, p.cfg.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for i := range refunds {
+		refund := &refunds[i]
+		ctx := WithTenantID(ctx, refund.TenantID)
+		payment, err := p.payments.repo.GetPaymentByOrderID(ctx, refund.OrderID)
+		if err != nil {
+			return err
+		}
+		if err := p.wait(ctx, pollKey(payment)); err != nil {
+			return err
+		}
+
+		run.RefundsChecked++
+		err = p.payments.confirmRefund(ctx, payment, refund)
+		if errors.Is(err, ErrRateLimited) {
+			run.RateLimited = true
+			return nil
+		}
+		if err != nil {
+			log.Printf("Failed to confirm refund %s: %v", refund.RefundID, err)
+			continue
+		}
+		run.RefundsSettled++
+	}
+	return nil
+}
+
+// pollKey returns the gateway account of a payment, as each has its own rate limit
+func pollKey(payment *Payment) string {
+	if payment.Gateway == GatewayRazorpay {
+		return GatewayRazorpay
+	}
+	if payment.TenantID != nil {
+		return payment.TenantID.String()
+	}
+	if payment.Environment != nil {
+		return *payment.Environment
+	}
+	return "deployment"
+}
+
+// Stats returns the poller's backlog, lag and latest run
+func (p *StatusPoller) Stats(ctx context.Context) (*StatusPollerStats, error) {
+	now := p.now()
+	createdBefore, polledBefore := p.due(now)
+	backlog, dueSince, err := p.payments.repo.CountPollablePayments(ctx, now, createdBefore, polledBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &StatusPollerStats{Backlog: backlog}
+	if dueSince != nil {
+		stats.LagSeconds = max(0, now.Sub(*dueSince).Seconds())
+	}
+	p.mu.Lock()
+	if p.lastRun != nil {
+		run := *p.lastRun
+		stats.LastRun = &run
+	}
+	p.mu.Unlock()
+	return stats, nil
+}
+
+// StatusPollJob polls due orders every 30 seconds
+func (p *StatusPoller) StatusPollJob() Job {
+	return Job{
+		Name:     "status_poller",
+		Schedule: Every(30 * time.Second),
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			run, err := p.Poll(ctx)
+			if run.Updated > 0 {
+				log.Printf("Status poller updated %d of %d orders", run.Updated, run.Checked)
+			}
+			if run.RefundsSettled > 0 {
+				log.Printf("Status poller settled %d unconfirmed refunds", run.RefundsSettled)
+			}
+			return err
+		},
+	}
+}
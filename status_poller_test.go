@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestStatusPoller(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+	start := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+	clock := newTestClock(start)
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+	poller := NewStatusPoller(handler, StatusPollerConfig{
+		Enabled:       true,
+		After:         2 * time.Minute,
+		Interval:      5 * time.Minute,
+		RatePerSecond: 100,
+		BatchSize:     10,
+	})
+	poller.clock = clock
+	ctx := context.Background()
+
+	create := func(orderID string) {
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		require.NoError(t, repo.CreatePayment(ctx, payment))
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+	}
+	create("order_paid")
+	create("order_waiting")
+	clock.Advance(100 * time.Second)
+	create("order_late")
+	_, err := server.CompletePayment("order_paid", "upi")
+	require.NoError(t, err)
+
+	// Orders are polled once they have waited After for their webhook
+	clock.Advance(30 * time.Second)
+	stats, err := poller.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Backlog)
+	assert.Equal(t, 10.0, stats.LagSeconds)
+	assert.Nil(t, stats.LastRun)
+
+	run, err := poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, run.Checked)
+	assert.Equal(t, 1, run.Updated)
+	payment, err := repo.GetPaymentByOrderID(ctx, "order_paid")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentPaid, payment.Status)
+
+	stats, err = poller.Stats(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Backlog)
+	require.NotNil(t, stats.LastRun)
+	assert.Equal(t, 2, stats.LastRun.Checked)
+
+	// Polled orders wait Interval before the next poll
+	clock.Advance(2 * time.Minute)
+	run, err = poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, run.Checked, "only order_late is due")
+	clock.Advance(3 * time.Minute)
+	run, err = poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, run.Checked, "order_waiting is due again")
+
+	// A rate limited call ends the run and leaves the order due
+	clock.Advance(5 * time.Minute)
+	server.FailNext(http.StatusTooManyRequests)
+	run, err = poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.True(t, run.RateLimited)
+	assert.Equal(t, 1, run.Checked)
+	stats, err = poller.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Backlog)
+}
+
+func TestStatusPollerSettlesUnconfirmedRefunds(t *testing.T) {
+	db := testDB(t)
+	server, _ := newFakeCashfree(t)
+
+	// Cashfree is reached through a proxy that can make refund calls time out, either
+	// after Cashfree made the refund or before it saw the request
+	const (
+		passThrough = iota
+		lostAnswer
+		lostRequest
+	)
+	var mode atomic.Int32
+	upstream, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/refunds") {
+			switch mode.Load() {
+			case lostAnswer:
+				proxy.ServeHTTP(httptest.NewRecorder(), r)
+				<-r.Context().Done()
+				return
+			case lostRequest:
+				<-r.Context().Done()
+				return
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	t.Cleanup(front.Close)
+
+	client := NewCashfreeClient(server.ClientID, server.ClientSecret, "TEST", WithTimeouts(CashfreeTimeouts{
+		Default: 200 * time.Millisecond, Checkout: 200 * time.Millisecond, Batch: time.Second,
+	}))
+	client.BaseURL = front.URL
+	client.Client.SetRetryCount(0)
+
+	clock := newTestClock(time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC))
+	repo := NewPaymentRepository(db)
+	repo.clock = clock
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         repo,
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+		clock:        clock,
+	}
+	poller := NewStatusPoller(handler, StatusPollerConfig{
+		Enabled:       true,
+		After:         2 * time.Minute,
+		Interval:      5 * time.Minute,
+		RatePerSecond: 100,
+		BatchSize:     10,
+	})
+	poller.clock = clock
+	ctx := context.Background()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	refund := func(orderID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/payments/"+orderID+"/refund", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, orderID := range []string{"order_answer_lost", "order_request_lost"} {
+		payment := testPayment(orderID)
+		payment.Amount = 499.5
+		payment.Status = domain.PaymentSuccess
+		require.NoError(t, repo.CreatePayment(ctx, payment))
+		_, err := client.CreateOrder(testOrderRequest(orderID))
+		require.NoError(t, err)
+		_, err = server.CompletePayment(orderID, "upi")
+		require.NoError(t, err)
+	}
+
+	// A refund whose answer times out stays held against the balance, so a retry
+	// under a new refund ID cannot refund the order twice
+	mode.Store(lostAnswer)
+	w := refund("order_answer_lost", `{"amount": 300}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var accepted struct {
+		RefundID     string `json:"refund_id"`
+		RefundStatus string `json:"refund_status"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	assert.Equal(t, "PENDING", accepted.RefundStatus)
+	made, err := repo.GetRefundByID(ctx, accepted.RefundID)
+	require.NoError(t, err)
+	assert.Empty(t, made.CFRefundID)
+
+	mode.Store(passThrough)
+	clock.Advance(time.Second)
+	w = refund("order_answer_lost", `{"amount": 300}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	order, _ := server.Order("order_answer_lost")
+	assert.Len(t, order.Refunds, 1)
+
+	mode.Store(lostRequest)
+	w = refund("order_request_lost", `{"amount": 100}`)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &accepted))
+	lost := accepted.RefundID
+
+	// A refund Cashfree turns down is released at once
+	mode.Store(passThrough)
+	server.FailNext(http.StatusBadRequest)
+	w = refund("order_request_lost", `{"amount": 50, "refund_reference": "rejected-1"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	_, err = repo.GetRefundByID(ctx, refundIDFor(nil, "order_request_lost", "rejected-1", clock.Now()))
+	assert.Error(t, err)
+
+	// Once they have waited After, the poller saves the refund Cashfree made and
+	// releases the one it never received
+	clock.Advance(time.Minute)
+	run, err := poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, run.RefundsChecked, "refunds are checked once they have waited After")
+
+	clock.Advance(2 * time.Minute)
+	run, err = poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, run.RefundsChecked)
+	assert.Equal(t, 2, run.RefundsSettled)
+
+	made, err = repo.GetRefundByID(ctx, made.RefundID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.RefundPending, made.Status)
+	assert.NotEmpty(t, made.CFRefundID)
+	_, err = repo.GetRefundByID(ctx, lost)
+	assert.Error(t, err)
+
+	run, err = poller.Poll(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, run.RefundsChecked)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+)
+
+var (
+	ErrInvalidStatusToken = errors.New("invalid status token")
+	ErrStatusTokenExpired = errors.New("status token expired")
+)
+
+// statusPollInterval is how often the status stream checks the database for changes
+const statusPollInterval = 3 * time.Second
+
+// terminalStatuses are order statuses after which the status stream is closed. An
+// amount mismatch is final as keepsLocalStatus keeps it over the gateway's paid status.
+var terminalStatuses = map[domain.PaymentStatus]bool{
+	domain.PaymentPaid:           true,
+	domain.PaymentSuccess:        true,
+	domain.PaymentFailed:         true,
+	domain.PaymentCancelled:      true,
+	domain.PaymentExpired:        true,
+	domain.PaymentTerminated:     true,
+	domain.PaymentAmountMismatch: true,
+}
+
+// StatusTokenIssuer mints and verifies short-lived tokens that let a browser read the
+// status of a single order without our API credentials
+type StatusTokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// statusTokenClaims is the signed part of a status token
+type statusTokenClaims struct {
+	OrderID   string     `json:"oid"`
+	TenantID  *uuid.UUID `json:"tid,omitempty"` // merchant owning the order, as order IDs are unique per merchant
+	ExpiresAt int64      `json:"exp"`
+}
+
+// NewStatusTokenIssuer creates an issuer that signs tokens with secret
+func NewStatusTokenIssuer(secret string, ttl time.Duration) *StatusTokenIssuer {
+	return &StatusTokenIssuer{secret: []byte(secret), ttl: ttl}
+}
+
+// Issue creates a token for an order of the merchant tenantID, or of no merchant for
+// nil, that expires after the issuer's TTL
+func (i *StatusTokenIssuer) Issue(orderID string, tenantID *uuid.UUID, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(i.ttl).Truncate(time.Second)
+	claims, _ := json.Marshal(statusTokenClaims{OrderID: orderID, TenantID: tenantID, ExpiresAt: expiresAt.Unix()})
+
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + i.sign(payload), expiresAt
+}
+
+// Verify checks the token's signature and expiry and returns the order it is scoped to
+// and the merchant owning it
+func (i *StatusTokenIssuer) Verify(token string, now time.Time) (string, *uuid.UUID, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(i.sign(payload))) {
+		return "", nil, ErrInvalidStatusToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, ErrInvalidStatusToken
+	}
+
+	var claims statusTokenClaims
+	if err := json.Unmarshal(raw, &claims); err != nil || claims.OrderID == "" {
+		return "", nil, ErrInvalidStatusToken
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return "", nil, ErrStatusTokenExpired
+	}
+
+	return claims.OrderID, claims.TenantID, nil
+}
+
+func (i *StatusTokenIssuer) sign(payload string) string {
+	h := hmac.New(sha256.New, i.secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// orderStatusView is the subset of a payment that is safe to show to the browser
+func orderStatusView(p *Payment) gin.H {
+	return gin.H{
+		"order_id":   p.OrderID,
+		"status":     p.Status,
+		"amount":     p.Amount,
+		"currency":   p.Currency,
+		"updated_at": p.UpdatedAt,
+	}
+}
+
+// statusTokenFromRequest reads the token from the Authorization header or the token query parameter
+func statusTokenFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return c.Query("token")
+}
+
+// orderFromStatusToken verifies the request's status token, writing a 401 response on
+// failure. It returns the order and the request's context scoped to its merchant.
+func (h *PaymentHandler) orderFromStatusToken(c *gin.Context) (context.Context, string, bool) {
+	orderID, tenantID, err := h.statusTokens.Verify(statusTokenFromRequest(c), h.now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return WithTenantID(requestContext(c), tenantID), orderID, true
+}
+
+// Creates a short-lived status token for an order
+func (h *PaymentHandler) CreateStatusToken(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get payment: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	token, expiresAt := h.statusTokens.Issue(orderID, payment.TenantID, h.now())
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id":   orderID,
+		"token":      token,
+		"expires_at": expiresAt,
+	})
+}
+
+// Gets the status of the order a status token is scoped to
+func (h *PaymentHandler) GetOrderStatusByToken(c *gin.Context) {
+	ctx, orderID, ok := h.orderFromStatusToken(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		log.Printf("Failed to get payment: %v", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orderStatusView(payment))
+}
+
+// Streams status changes of the order a status token is scoped to as server-sent events
+func (h *PaymentHandler) StreamOrderStatus(c *gin.Context) {
+	scope, orderID, ok := h.orderFromStatusToken(c)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(statusPollInterval)
+	defer ticker.Stop()
+	changed, stop := h.statusChanges.Watch(orderID)
+	defer stop()
+
+	var lastStatus domain.PaymentStatus
+	first := true
+
+	c.Stream(func(w io.Writer) bool {
+		if !first {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-ticker.C:
+			case <-changed:
+			}
+		}
+		first = false
+
+		// Stop once the token expires; the browser can request a new one
+		if _, _, err := h.statusTokens.Verify(statusTokenFromRequest(c), h.now()); err != nil {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+			return false
+		}
+
+		ctx, cancel := context.WithTimeout(scope, 5*time.Second)
+		payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to get payment for status stream: %v", err)
+			c.SSEvent("error", gin.H{"error": "Payment not found"})
+			return false
+		}
+
+		// A stale status is refreshed in the background and pushed when it changes
+		if h.statusCache != nil && h.statusCache.Stale(payment) {
+			h.statusCache.Refresh(scope, payment)
+		}
+
+		if payment.Status != lastStatus {
+			lastStatus = payment.Status
+			c.SSEvent("status", orderStatusView(payment))
+		}
+
+		return !terminalStatuses[payment.Status]
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestStatusToken(t *testing.T) {
+	issuer := NewStatusTokenIssuer("secret", 15*time.Minute)
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	token, expiresAt := issuer.Issue("order_123", nil, now)
+	assert.Equal(t, now.Add(15*time.Minute), expiresAt)
+
+	orderID, tenantID, err := issuer.Verify(token, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "order_123", orderID)
+	assert.Nil(t, tenantID)
+
+	_, _, err = issuer.Verify(token, now.Add(15*time.Minute))
+	assert.ErrorIs(t, err, ErrStatusTokenExpired)
+
+	// A merchant's token is scoped to its order
+	merchantID := uuid.New()
+	token, _ = issuer.Issue("order_123", &merchantID, now)
+	_, tenantID, err = issuer.Verify(token, now)
+	require.NoError(t, err)
+	assert.Equal(t, &merchantID, tenantID)
+}
+
+func TestStatusTokenRejectsTampering(t *testing.T) {
+	issuer := NewStatusTokenIssuer("secret", 15*time.Minute)
+	now := time.Now()
+
+	token, _ := issuer.Issue("order_123", nil, now)
+	other, _ := issuer.Issue("order_456", nil, now)
+
+	// Swapping the payload onto another token's signature must fail
+	payload, _, _ := strings.Cut(other, ".")
+	_, signature, _ := strings.Cut(token, ".")
+	_, _, err := issuer.Verify(payload+"."+signature, now)
+	assert.ErrorIs(t, err, ErrInvalidStatusToken)
+
+	_, _, err = NewStatusTokenIssuer("other-secret", 15*time.Minute).Verify(token, now)
+	assert.ErrorIs(t, err, ErrInvalidStatusToken)
+
+	_, _, err = issuer.Verify("", now)
+	assert.ErrorIs(t, err, ErrInvalidStatusToken)
+}
+
+func TestStatusStreamClosesOnFinalStatuses(t *testing.T) {
+	// An amount mismatch is kept over the gateway's paid status, so it is final
+	require.True(t, keepsLocalStatus(domain.PaymentAmountMismatch, domain.PaymentPaid))
+	assert.True(t, terminalStatuses[domain.PaymentAmountMismatch])
+	assert.True(t, terminalStatuses[domain.PaymentCancelled])
+	assert.False(t, terminalStatuses[domain.PaymentActive])
+}
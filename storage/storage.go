@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// GCSEndpoint is the Google Cloud Storage XML API endpoint, which is S3 compatible
+const GCSEndpoint = "https://storage.googleapis.com"
+
+// Uploader writes report files to object storage
+type Uploader interface {
+	Upload(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// Config holds the settings for an object storage bucket
+type Config struct {
+	Bucket string
+	Prefix string
+	Region string
+
+	// Endpoint overrides the S3 endpoint, e.g. for MinIO or other S3-compatible stores
+	Endpoint string
+
+	// SSE is the S3 server-side encryption mode: "AES256" or "aws:kms"
+	SSE string
+	// KMSKeyID is the KMS key for SSE "aws:kms", or the Cloud KMS key name for GCS
+	KMSKeyID string
+}
+
+// BucketUploader uploads objects through the S3 API. It is used for both Amazon S3
+// and Google Cloud Storage (through GCS's S3-compatible XML API).
+type BucketUploader struct {
+	client *s3.Client
+	cfg    Config
+	gcs    bool
+}
+
+// NewS3Uploader creates an uploader for Amazon S3 using the default AWS credential chain
+func NewS3Uploader(ctx context.Context, cfg Config) (*BucketUploader, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &BucketUploader{client: client, cfg: cfg}, nil
+}
+
+// NewGCSUploader creates an uploader for Google Cloud Storage using HMAC interoperability keys
+func NewGCSUploader(cfg Config, accessKey, secret string) *BucketUploader {
+	if cfg.Region == "" {
+		cfg.Region = "auto"
+	}
+
+	client := s3.New(s3.Options{
+		Region:       cfg.Region,
+		BaseEndpoint: aws.String(GCSEndpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider(accessKey, secret, ""),
+		UsePathStyle: true,
+	})
+
+	return &BucketUploader{client: client, cfg: cfg, gcs: true}
+}
+
+// ObjectKey joins the configured prefix and key
+func (u *BucketUploader) ObjectKey(key string) string {
+	if u.cfg.Prefix == "" {
+		return key
+	}
+	return path.Join(strings.Trim(u.cfg.Prefix, "/"), key)
+}
+
+// Upload writes the object with the configured server-side encryption
+func (u *BucketUploader) Upload(ctx context.Context, key string, body []byte, contentType string) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(u.cfg.Bucket),
+		Key:         aws.String(u.ObjectKey(key)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+
+	var optFns []func(*s3.Options)
+	if u.gcs {
+		// GCS encrypts all objects at rest; a customer-managed key is selected with its own header
+		if u.cfg.KMSKeyID != "" {
+			optFns = append(optFns, s3.WithAPIOptions(
+				smithyhttp.AddHeaderValue("x-goog-encryption-kms-key-name", u.cfg.KMSKeyID),
+			))
+		}
+	} else if u.cfg.SSE != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(u.cfg.SSE)
+		if u.cfg.KMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(u.cfg.KMSKeyID)
+		}
+	}
+
+	if _, err := u.client.PutObject(ctx, input, optFns...); err != nil {
+		return fmt.Errorf("failed to upload s3://%s/%s: %v", u.cfg.Bucket, u.ObjectKey(key), err)
+	}
+
+	return nil
+}
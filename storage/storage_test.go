@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectKey(t *testing.T) {
+	assert.Equal(t, "payments/2024-04-01.csv", (&BucketUploader{}).ObjectKey("payments/2024-04-01.csv"))
+	assert.Equal(t, "reports/prod/payments/2024-04-01.csv", (&BucketUploader{cfg: Config{Prefix: "/reports/prod/"}}).ObjectKey("payments/2024-04-01.csv"))
+}
+
+func TestS3UploadWithServerSideEncryption(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	var gotPath, gotSSE, gotKMSKey, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotPath = r.URL.Path
+		gotSSE = r.Header.Get("x-amz-server-side-encryption")
+		gotKMSKey = r.Header.Get("x-amz-server-side-encryption-aws-kms-key-id")
+		gotBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	uploader, err := NewS3Uploader(context.Background(), Config{
+		Bucket:   "reports",
+		Prefix:   "daily",
+		Region:   "ap-south-1",
+		Endpoint: server.URL,
+		SSE:      "aws:kms",
+		KMSKeyID: "key-123",
+	})
+	assert.NoError(t, err)
+
+	err = uploader.Upload(context.Background(), "payments/2024-04-01.csv", []byte("order_id\n"), "text/csv")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/reports/daily/payments/2024-04-01.csv", gotPath)
+	assert.Equal(t, "aws:kms", gotSSE)
+	assert.Equal(t, "key-123", gotKMSKey)
+	assert.Equal(t, "order_id\n", gotBody)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"payment-getway/domain"
+	"payment-getway/queue"
+)
+
+// RegisterTasks registers the handler's asynchronous task processors on the queue
+func (h *PaymentHandler) RegisterTasks(q queue.Queue) {
+	q.Register(queue.TaskProcessWebhook, h.processWebhookTask)
+	q.Register(queue.TaskVerifyPayment, h.verifyPaymentTask)
+}
+
+// tenantTaskPayload wraps a task payload with the merchant it was queued for
+type tenantTaskPayload struct {
+	TenantID *uuid.UUID      `json:"tenant_id,omitempty"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+// newTenantTask creates a task that is processed within the context's merchant scope
+func newTenantTask(ctx context.Context, taskType string, payload interface{}) (queue.Task, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return queue.Task{}, err
+	}
+	return queue.NewTask(taskType, tenantTaskPayload{TenantID: TenantIDFromContext(ctx), Payload: raw})
+}
+
+// tenantTaskContext unwraps a task created by newTenantTask, returning a context carrying
+// its merchant. Payloads queued without a merchant wrapper are returned unchanged.
+func (h *PaymentHandler) tenantTaskContext(ctx context.Context, task queue.Task) (context.Context, json.RawMessage, error) {
+	var wrapped tenantTaskPayload
+	if err := json.Unmarshal(task.Payload, &wrapped); err != nil || len(wrapped.Payload) == 0 {
+		return ctx, task.Payload, nil
+	}
+	if wrapped.TenantID == nil {
+		return ctx, wrapped.Payload, nil
+	}
+
+	if h.merchants == nil {
+		return nil, nil, fmt.Errorf("task %s belongs to merchant %s but merchant accounts are not configured", task.ID, *wrapped.TenantID)
+	}
+	merchant, err := h.merchants.GetMerchantByID(ctx, *wrapped.TenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return WithMerchant(ctx, merchant), wrapped.Payload, nil
+}
+
+// processWebhookTask applies a queued webhook payload
+func (h *PaymentHandler) processWebhookTask(ctx context.Context, task queue.Task) error {
+	ctx, payload, err := h.tenantTaskContext(ctx, task)
+	if err != nil {
+		return err
+	}
+
+	var webhookData WebhookData
+	if err := json.Unmarshal(payload, &webhookData); err != nil {
+		// A payload that cannot be parsed will never succeed, so don't retry it
+		log.Printf("Dropping unparseable webhook task %s: %v", task.ID, err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return h.dispatchWebhook(ctx, webhookData)
+}
+
+// verifyPaymentTask refreshes a single order's status from its payment gateway
+func (h *PaymentHandler) verifyPaymentTask(ctx context.Context, task queue.Task) error {
+	ctx, payload, err := h.tenantTaskContext(ctx, task)
+	if err != nil {
+		return err
+	}
+
+	var orderID string
+	if err := json.Unmarshal(payload, &orderID); err != nil {
+		log.Printf("Dropping invalid verify task %s: %v", task.ID, err)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	gateway, err := h.gatewayForOrder(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve payment gateway for %s: %v", orderID, err)
+	}
+	gateway = forBatch(gateway)
+
+	orderStatus, err := gateway.GetOrderStatus(orderID)
+	if err != nil {
+		return fmt.Errorf("failed to get order status for %s: %v", orderID, err)
+	}
+
+	var cfPaymentID, paymentMethod *string
+	var paymentTime *time.Time
+	var paymentDetails *CashfreePaymentResponse
+	status := orderStatus.OrderStatus
+
+	if status == domain.PaymentPaid {
+		paymentDetails, err = h.orderPayment(ctx, gateway, orderStatus)
+		if err != nil {
+			return fmt.Errorf("failed to get payment details for %s: %v", orderID, err)
+		}
+		cfPaymentID = &paymentDetails.CFPaymentID
+		paymentMethod = &paymentDetails.PaymentMethod
+		paymentTime = &paymentDetails.PaymentTime
+		status = h.paidStatus(ctx, orderID, paymentDetails.CFPaymentID, paymentDetails.PaymentAmount, status)
+	}
+
+	err = h.repo.UpdatePaymentStatus(ctx, orderID, status, cfPaymentID, paymentMethod, paymentTime)
+	if err != nil {
+		return err
+	}
+
+	if paymentDetails != nil {
+		h.recordPaymentCharges(ctx, orderID, paymentDetails.PaymentAmount, paymentDetails.PaymentCharges)
+		h.recordPaymentEMI(ctx, orderID, paymentDetails.EMI)
+		h.recordPaymentMethodDetails(ctx, orderID, paymentDetails.MethodDetails)
+		if status == domain.PaymentPaid {
+			h.issueInvoice(ctx, orderID)
+		}
+	}
+	return nil
+}
+
+// Queues status verification for a batch of orders
+func (h *PaymentHandler) BulkVerifyPayments(c *gin.Context) {
+	var req BulkVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	queued := 0
+	var failed []string
+
+	for _, orderID := range req.OrderIDs {
+		task, err := newTenantTask(ctx, queue.TaskVerifyPayment, orderID)
+		if err == nil {
+			err = h.tasks.Enqueue(ctx, task)
+		}
+		if err != nil {
+			log.Printf("Failed to enqueue verification for %s: %v", orderID, err)
+			failed = append(failed, orderID)
+			continue
+		}
+		queued++
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"queued": queued,
+		"failed": failed,
+	})
+}
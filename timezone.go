@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	// Embedded zone database, so time zones load in images without one
+	_ "time/tzdata"
+)
+
+// defaultTimezone is the business day reports and quotas follow unless configured
+// otherwise
+const defaultTimezone = "Asia/Kolkata"
+
+// timezones caches loaded locations by name
+var timezones sync.Map
+
+// loadTimezone loads an IANA time zone such as "Asia/Kolkata" or "Europe/London"
+func loadTimezone(name string) (*time.Location, error) {
+	if cached, ok := timezones.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+
+	// "" and "Local" would silently follow the server's time zone
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("timezone must be an IANA time zone such as %s", defaultTimezone)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+
+	timezones.Store(name, loc)
+	return loc, nil
+}
+
+// Location returns the time zone of the merchant's business day, or nil when the
+// merchant uses the deployment's
+func (m *Merchant) Location() *time.Location {
+	if m == nil || m.Timezone == "" {
+		return nil
+	}
+	loc, err := loadTimezone(m.Timezone)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// merchantLocation returns the time zone of a merchant's business day, falling back to
+// the deployment's and then to IST
+func merchantLocation(merchant *Merchant, fallback *time.Location) *time.Location {
+	if loc := merchant.Location(); loc != nil {
+		return loc
+	}
+	if fallback != nil {
+		return fallback
+	}
+	return istLocation
+}
+
+// reportLocation returns the time zone reporting windows follow for the context's
+// merchant
+func reportLocation(ctx context.Context, fallback *time.Location) *time.Location {
+	return merchantLocation(MerchantFromContext(ctx), fallback)
+}
+
+// startOfDay returns midnight at the start of t's day in loc
+func startOfDay(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTimezone(t *testing.T) {
+	loc, err := loadTimezone("America/New_York")
+	require.NoError(t, err)
+	assert.Equal(t, "America/New_York", loc.String())
+
+	for _, name := range []string{"", "Local", "Mars/Olympus"} {
+		_, err := loadTimezone(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestReportLocation(t *testing.T) {
+	london, err := loadTimezone("Europe/London")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	assert.Equal(t, istLocation, reportLocation(ctx, nil))
+	assert.Equal(t, london, reportLocation(ctx, london))
+
+	merchant := &Merchant{Timezone: "America/New_York"}
+	assert.Equal(t, "America/New_York", reportLocation(WithMerchant(ctx, merchant), london).String())
+
+	merchant.Timezone = ""
+	assert.Equal(t, london, reportLocation(WithMerchant(ctx, merchant), london))
+}
+
+func TestParseDateRangeInLocation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newYork, err := loadTimezone("America/New_York")
+	require.NoError(t, err)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/exports/payments?from=2024-03-31&to=2024-03-31", nil)
+
+	from, to, err := parseDateRange(c, newYork)
+	require.NoError(t, err)
+	assert.True(t, from.Equal(time.Date(2024, 3, 31, 4, 0, 0, 0, time.UTC)))
+	assert.True(t, to.Equal(time.Date(2024, 4, 1, 4, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"payment-getway/domain"
+)
+
+// UPIIntentResponse carries the links that open a UPI app with an order's payment
+// filled in, for mobile apps that skip the hosted checkout
+type UPIIntentResponse struct {
+	OrderID     string            `json:"order_id"`
+	CFPaymentID string            `json:"cf_payment_id"`
+	IntentURI   string            `json:"intent_uri"` // upi:// URI any UPI app handles
+	AppLinks    map[string]string `json:"app_links"`  // deep links by app: gpay, phonepe, paytm, ...
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+}
+
+// CreateUPIIntent starts a UPI intent payment of an unpaid Cashfree order and returns
+// the upi:// URI and app-specific deep links to launch. The payment completes in the
+// UPI app, and its outcome arrives by webhook like any other.
+func (h *PaymentHandler) CreateUPIIntent(c *gin.Context) {
+	orderID := c.Param("order_id")
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 10*time.Second)
+	defer cancel()
+
+	payment, err := h.repo.GetPaymentByOrderID(ctx, orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	switch {
+	case payment.Gateway != "" && payment.Gateway != GatewayCashfree:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "UPI intent links are only available for Cashfree orders"})
+		return
+	case payment.Status == domain.PaymentScheduled || payment.Status == domain.PaymentActivating || payment.Status == domain.PaymentPendingReview:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order has not been created at the gateway yet"})
+		return
+	case payment.PaymentMethods != nil && !slices.Contains(strings.Split(*payment.PaymentMethods, ","), "upi"):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "UPI is not enabled for this order"})
+		return
+	}
+
+	client, err := h.cashfreeForPayment(ctx, payment)
+	if err != nil {
+		log.Printf("Failed to resolve Cashfree client: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create UPI intent"})
+		return
+	}
+
+	client = client.ForCheckout()
+	order, err := client.GetOrderStatus(orderID)
+	if err != nil {
+		log.Printf("Failed to get order %s: %v", orderID, err)
+		respondGatewayError(c, err, "Failed to create UPI intent")
+		return
+	}
+	if order.OrderStatus != domain.PaymentActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Order is " + string(order.OrderStatus) + " and can no longer be paid"})
+		return
+	}
+	if order.PaymentSessionID == "" {
+		log.Printf("Cashfree returned no payment session for order %s", orderID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create UPI intent"})
+		return
+	}
+
+	intent, err := client.CreateUPIIntent(order.PaymentSessionID)
+	if err != nil {
+		log.Printf("Failed to create UPI intent for order %s: %v", orderID, err)
+		respondGatewayError(c, err, "Failed to create UPI intent")
+		return
+	}
+
+	response := UPIIntentResponse{
+		OrderID:     orderID,
+		CFPaymentID: intent.CFPaymentID,
+		IntentURI:   intent.Data.Payload["default"],
+		AppLinks:    make(map[string]string),
+	}
+	for app, link := range intent.Data.Payload {
+		if app != "default" && link != "" {
+			response.AppLinks[app] = link
+		}
+	}
+	if !order.OrderExpiryTime.IsZero() {
+		response.ExpiresAt = &order.OrderExpiryTime
+	}
+	if response.IntentURI == "" {
+		log.Printf("Cashfree returned no UPI intent URI for order %s", orderID)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to create UPI intent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
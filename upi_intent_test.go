@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCashfreeClientCreateUPIIntent(t *testing.T) {
+	server, client := newFakeCashfree(t)
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	order, err := client.GetOrderStatus("order_1")
+	require.NoError(t, err)
+
+	intent, err := client.CreateUPIIntent(order.PaymentSessionID)
+	require.NoError(t, err)
+	assert.NotEmpty(t, intent.CFPaymentID)
+	assert.True(t, strings.HasPrefix(intent.Data.Payload["default"], "upi://pay?"))
+	assert.True(t, strings.HasPrefix(intent.Data.Payload["gpay"], "tez://"))
+	assert.True(t, strings.HasPrefix(intent.Data.Payload["phonepe"], "phonepe://"))
+	assert.True(t, strings.HasPrefix(intent.Data.Payload["paytm"], "paytmmp://"))
+
+	// A paid order's session can no longer start payments
+	_, err = server.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+	_, err = client.CreateUPIIntent(order.PaymentSessionID)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	_, err = client.CreateUPIIntent("")
+	var validation *ValidationError
+	assert.ErrorAs(t, err, &validation)
+}
+
+func TestCreateUPIIntent(t *testing.T) {
+	db := testDB(t)
+	server, client := newFakeCashfree(t)
+
+	handler := &PaymentHandler{
+		cashfree:     client,
+		repo:         NewPaymentRepository(db),
+		gateways:     NewGatewayRouter(client, nil, false),
+		environments: map[string]*CashfreeClient{"TEST": client},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: handler.repo}, nil)
+
+	serve := func(orderID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/"+orderID+"/upi-intent", nil))
+		return w
+	}
+	createOrder := func(orderID, methods string) {
+		payment := testPayment(orderID)
+		req := testOrderRequest(orderID)
+		if methods != "" {
+			payment.PaymentMethods = &methods
+			req.OrderMeta.PaymentMethods = methods
+		}
+		require.NoError(t, handler.repo.CreatePayment(context.Background(), payment))
+		_, err := client.CreateOrder(req)
+		require.NoError(t, err)
+	}
+
+	orderID := fmt.Sprintf("order_upi_%d", time.Now().UnixNano())
+	createOrder(orderID, "")
+	w := serve(orderID)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var intent UPIIntentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &intent))
+	assert.Equal(t, orderID, intent.OrderID)
+	assert.True(t, strings.HasPrefix(intent.IntentURI, "upi://pay?"))
+	assert.Contains(t, intent.AppLinks, "gpay")
+	assert.Contains(t, intent.AppLinks, "phonepe")
+	assert.Contains(t, intent.AppLinks, "paytm")
+	assert.NotNil(t, intent.ExpiresAt)
+
+	// Orders restricted to other methods have no UPI intent
+	cards := orderID + "_cards"
+	createOrder(cards, "cc,dc")
+	assert.Equal(t, http.StatusUnprocessableEntity, serve(cards).Code)
+
+	// Paid orders can no longer be paid
+	_, err := server.CompletePayment(orderID, "upi")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, serve(orderID).Code)
+
+	assert.Equal(t, http.StatusNotFound, serve("order_missing").Code)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Vendor statement line types
+const (
+	VendorLineSettlement = "SETTLEMENT"
+	VendorLineFee        = "FEE"
+	VendorLineReversal   = "REVERSAL"
+)
+
+// VendorStatementLine is a vendor's split of an order before platform fees, a fee
+// deducted from it, or its share of a refund of one. Fees and reversals have a
+// negative amount.
+type VendorStatementLine struct {
+	Date     time.Time `json:"date"`
+	Type     string    `json:"type"`
+	OrderID  string    `json:"order_id"`
+	RefundID string    `json:"refund_id,omitempty"`
+	FeeType  string    `json:"fee_type,omitempty"`
+	Amount   float64   `json:"amount"`
+	Currency string    `json:"currency"`
+	Status   string    `json:"status"`
+	UTRs     []string  `json:"utrs,omitempty"` // UTRs of the order's settlements
+}
+
+// VendorStatementTotal sums a vendor statement's lines in one currency
+type VendorStatementTotal struct {
+	Currency string  `json:"currency"`
+	Settled  float64 `json:"settled"`
+	Fees     float64 `json:"fees"`
+	Reversed float64 `json:"reversed"`
+	Net      float64 `json:"net"`
+}
+
+// VendorStatement is a vendor's settlements and reversals over a date range
+type VendorStatement struct {
+	VendorID string                 `json:"vendor_id"`
+	From     time.Time              `json:"from"`
+	To       time.Time              `json:"to"`
+	Lines    []VendorStatementLine  `json:"lines"`
+	Totals   []VendorStatementTotal `json:"totals"`
+}
+
+// ListVendorStatementLines returns a vendor's splits, their fees and its reversals
+// recorded in [from, to), oldest first. Released refunds are left out.
+func (r *PaymentRepository) ListVendorStatementLines(ctx context.Context, vendorID string, from, to time.Time) ([]VendorStatementLine, error) {
+	tenant, tenantArgs := tenantCondition(ctx, "WHERE", 4)
+	query := `
+		SELECT created_at, kind, order_id, refund_id, fee_type, amount, currency, status, utrs
+		FROM (
+			SELECT s.tenant_id, s.created_at, 'SETTLEMENT' AS kind, 0 AS position, s.order_id,
+				   '' AS refund_id, '' AS fee_type,
+				   s.amount + COALESCE((SELECT SUM(f.amount) FROM split_fees f WHERE f.split_id = s.id), 0) AS amount,
+				   p.currency, s.status,
+				   ARRAY(
+					   SELECT DISTINCT st.utr FROM settlements st
+					   WHERE st.tenant_key = s.tenant_key AND st.order_id = s.order_id AND st.utr IS NOT NULL
+					   ORDER BY st.utr
+				   ) AS utrs
+			FROM split_settlements s
+			JOIN payments p ON p.tenant_key = s.tenant_key AND p.order_id = s.order_id
+			WHERE s.vendor_id = $1 AND s.created_at >= $2 AND s.created_at < $3
+			UNION ALL
+			SELECT f.tenant_id, f.created_at, 'FEE', 1, f.order_id, '', f.fee_type,
+				   -f.amount, f.currency, s.status, ARRAY[]::VARCHAR[]
+			FROM split_fees f
+			JOIN split_settlements s ON s.id = f.split_id
+			WHERE f.vendor_id = $1 AND f.created_at >= $2 AND f.created_at < $3
+			UNION ALL
+			SELECT v.tenant_id, v.created_at, 'REVERSAL', 2, v.order_id, v.refund_id, '',
+				   -v.amount, p.currency, rf.status, ARRAY[]::VARCHAR[]
+			FROM refund_splits v
+			JOIN refunds rf ON rf.refund_id = v.refund_id
+			JOIN payments p ON p.tenant_key = v.tenant_key AND p.order_id = v.order_id
+			WHERE v.vendor_id = $1 AND v.created_at >= $2 AND v.created_at < $3
+			  AND rf.status NOT IN ` + refundReleasedStatuses + `
+		) lines` + tenant + `
+		ORDER BY created_at, order_id, position, fee_type
+	`
+
+	args := append([]interface{}{vendorID, from, to}, tenantArgs...)
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []VendorStatementLine{}
+	for rows.Next() {
+		var line VendorStatementLine
+		err := rows.Scan(&line.Date, &line.Type, &line.OrderID, &line.RefundID, &line.FeeType, &line.Amount, &line.Currency, &line.Status, &line.UTRs)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// vendorStatementTotals sums statement lines by currency, in the order the currencies
+// first appear
+func vendorStatementTotals(lines []VendorStatementLine) []VendorStatementTotal {
+	totals := []VendorStatementTotal{}
+	index := make(map[string]int)
+	for _, line := range lines {
+		i, ok := index[line.Currency]
+		if !ok {
+			i = len(totals)
+			index[line.Currency] = i
+			totals = append(totals, VendorStatementTotal{Currency: line.Currency})
+		}
+		switch line.Type {
+		case VendorLineFee:
+			totals[i].Fees -= line.Amount
+		case VendorLineReversal:
+			totals[i].Reversed -= line.Amount
+		default:
+			totals[i].Settled += line.Amount
+		}
+	}
+	for i := range totals {
+		totals[i].Settled = math.Round(totals[i].Settled*1000) / 1000
+		totals[i].Fees = math.Round(totals[i].Fees*1000) / 1000
+		totals[i].Reversed = math.Round(totals[i].Reversed*1000) / 1000
+		totals[i].Net = math.Round((totals[i].Settled-totals[i].Fees-totals[i].Reversed)*1000) / 1000
+	}
+	return totals
+}
+
+// FormatVendorStatementCSV renders a vendor statement's lines as CSV with dates in loc
+func FormatVendorStatementCSV(statement *VendorStatement, loc *time.Location) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	header := []string{"date", "type", "order_id", "refund_id", "fee_type", "amount", "currency", "status", "utr"}
+	if err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	for _, line := range statement.Lines {
+		record := []string{
+			line.Date.In(loc).Format(time.RFC3339),
+			line.Type,
+			line.OrderID,
+			line.RefundID,
+			line.FeeType,
+			formatCurrencyAmount(line.Amount, line.Currency),
+			line.Currency,
+			line.Status,
+			strings.Join(line.UTRs, " "),
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Gets a vendor's settlement statement for a date range, as JSON or CSV
+func (h *ExportHandler) GetVendorSettlements(c *gin.Context) {
+	loc := h.locationFor(requestContext(c))
+	from, to, err := parseDateRange(c, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestContext(c), 30*time.Second)
+	defer cancel()
+
+	statement := &VendorStatement{VendorID: c.Param("vendor_id"), From: from, To: to}
+	statement.Lines, err = h.repo.ListVendorStatementLines(ctx, statement.VendorID, from, to)
+	if err != nil {
+		log.Printf("Failed to get vendor statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build vendor statement"})
+		return
+	}
+	statement.Totals = vendorStatementTotals(statement.Lines)
+
+	// Statement lines carry when they happened, not when they last changed, so the
+	// statement has no Last-Modified
+	validator := newListValidator()
+	validator.add(format, time.Time{})
+	validator.add(statement, time.Time{})
+	if notModified(c, validator) {
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, statement)
+		return
+	}
+
+	body, err := FormatVendorStatementCSV(statement, loc)
+	if err != nil {
+		log.Printf("Failed to format vendor statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build vendor statement"})
+		return
+	}
+
+	filename := fmt.Sprintf("vendor_settlements_%s_%s.csv", from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/csv", body)
+}
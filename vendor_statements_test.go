@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sampleVendorStatement() *VendorStatement {
+	day := time.Date(2024, 4, 3, 6, 30, 0, 0, time.UTC)
+	return &VendorStatement{
+		VendorID: "vendor_a",
+		Lines: []VendorStatementLine{
+			{Date: day, Type: VendorLineSettlement, OrderID: "order_1", Amount: 60, Currency: "INR", Status: "SUCCESS", UTRs: []string{"UTR0001", "UTR0002"}},
+			{Date: day, Type: VendorLineFee, OrderID: "order_1", FeeType: FeeTypeCommission, Amount: -3, Currency: "INR", Status: "SUCCESS"},
+			{Date: day.Add(time.Hour), Type: VendorLineSettlement, OrderID: "order_2", Amount: 12.5, Currency: "USD", Status: "PENDING"},
+			{Date: day.AddDate(0, 0, 1), Type: VendorLineReversal, OrderID: "order_1", RefundID: "refund_1", Amount: -20.1, Currency: "INR", Status: "SUCCESS"},
+		},
+	}
+}
+
+func TestVendorStatementTotals(t *testing.T) {
+	assert.Equal(t, []VendorStatementTotal{
+		{Currency: "INR", Settled: 60, Fees: 3, Reversed: 20.1, Net: 36.9},
+		{Currency: "USD", Settled: 12.5, Fees: 0, Reversed: 0, Net: 12.5},
+	}, vendorStatementTotals(sampleVendorStatement().Lines))
+	assert.Empty(t, vendorStatementTotals(nil))
+}
+
+func TestFormatVendorStatementCSV(t *testing.T) {
+	out, err := FormatVendorStatementCSV(sampleVendorStatement(), istLocation)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	assert.Equal(t, []string{
+		"date,type,order_id,refund_id,fee_type,amount,currency,status,utr",
+		"2024-04-03T12:00:00+05:30,SETTLEMENT,order_1,,,60.00,INR,SUCCESS,UTR0001 UTR0002",
+		"2024-04-03T12:00:00+05:30,FEE,order_1,,COMMISSION,-3.00,INR,SUCCESS,",
+		"2024-04-03T13:00:00+05:30,SETTLEMENT,order_2,,,12.50,USD,PENDING,",
+		"2024-04-04T12:00:00+05:30,REVERSAL,order_1,refund_1,,-20.10,INR,SUCCESS,",
+	}, lines)
+}
+
+func TestVendorSettlementsAPI(t *testing.T) {
+	repo := NewPaymentRepository(testDB(t))
+	ctx := context.Background()
+	clock := newTestClock(time.Date(2024, 4, 3, 6, 30, 0, 0, time.UTC))
+	repo.clock = clock
+
+	require.NoError(t, repo.CreatePayment(ctx, testPayment("order_1")))
+	require.NoError(t, repo.CreateSplitSettlement(ctx, []SplitSettlement{
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_a", Amount: 60, SplitType: "AMOUNT", Status: "SUCCESS"},
+		{OrderID: "order_1", CFOrderID: "cf_order_1", VendorID: "vendor_b", Amount: 30, SplitType: "AMOUNT", Status: "SUCCESS"},
+	}))
+	utr := "UTR0001"
+	require.NoError(t, repo.CreateSettlement(ctx, &Settlement{SettlementID: "settlement_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 98, Status: "SUCCESS", UTR: &utr}))
+	clock.Advance(24 * time.Hour)
+	require.NoError(t, repo.ReserveRefund(ctx, &Refund{
+		RefundID: "refund_1", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 20, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_a", Amount: 20}},
+	}))
+	clock.Advance(30 * 24 * time.Hour)
+	require.NoError(t, repo.ReserveRefund(ctx, &Refund{
+		RefundID: "refund_2", OrderID: "order_1", CFOrderID: "cf_order_1", Amount: 10, Status: "PENDING",
+		Splits: []RefundSplit{{VendorID: "vendor_a", Amount: 10}},
+	}))
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), &PaymentHandler{repo: repo}, &ExportHandler{repo: repo, location: istLocation}, nil)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	// The second refund falls in May
+	w := get("/vendors/vendor_a/settlements?from=2024-04-01&to=2024-04-30")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var statement VendorStatement
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statement))
+	require.Len(t, statement.Lines, 2)
+	assert.Equal(t, VendorLineSettlement, statement.Lines[0].Type)
+	assert.Equal(t, []string{"UTR0001"}, statement.Lines[0].UTRs)
+	assert.Equal(t, "refund_1", statement.Lines[1].RefundID)
+	assert.Equal(t, -20.0, statement.Lines[1].Amount)
+	assert.Equal(t, []VendorStatementTotal{{Currency: "INR", Settled: 60, Reversed: 20, Net: 40}}, statement.Totals)
+
+	w = get("/vendors/vendor_a/settlements?from=2024-04-01&to=2024-04-30&format=csv")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "attachment; filename=vendor_settlements_20240401_20240430.csv", w.Header().Get("Content-Disposition"))
+	assert.Len(t, strings.Split(strings.TrimSpace(w.Body.String()), "\n"), 3)
+
+	assert.Equal(t, http.StatusBadRequest, get("/vendors/vendor_a/settlements?from=2024-04-01&to=2024-04-30&format=xml").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/vendors/vendor_a/settlements?from=April").Code)
+}
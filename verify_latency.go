@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// verifyLatencyBuckets are the upper bounds, in seconds, verify latencies are counted in
+var verifyLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// VerifyLatency is an in-process histogram of how long verify calls took, per merchant,
+// since the instance started. Verify is called far too often to record every call in
+// the event store.
+type VerifyLatency struct {
+	mu      sync.Mutex
+	tenants map[string]*latencyHistogram
+}
+
+// latencyHistogram counts samples in verifyLatencyBuckets, the last count being above
+// every bound
+type latencyHistogram struct {
+	counts []int
+	count  int
+	sum    float64
+	max    float64
+}
+
+// NewVerifyLatency creates an empty verify latency histogram
+func NewVerifyLatency() *VerifyLatency {
+	return &VerifyLatency{tenants: make(map[string]*latencyHistogram)}
+}
+
+// Observe counts a verify call of the merchant in ctx that took duration. A nil
+// histogram observes nothing.
+func (v *VerifyLatency) Observe(ctx context.Context, duration time.Duration) {
+	if v == nil {
+		return
+	}
+	seconds := duration.Seconds()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	key := latencyTenantKey(ctx)
+	histogram, ok := v.tenants[key]
+	if !ok {
+		histogram = &latencyHistogram{counts: make([]int, len(verifyLatencyBuckets)+1)}
+		v.tenants[key] = histogram
+	}
+	histogram.counts[sort.SearchFloat64s(verifyLatencyBuckets, seconds)]++
+	histogram.count++
+	histogram.sum += seconds
+	histogram.max = math.Max(histogram.max, seconds)
+}
+
+// Summary summarizes the verify calls of the merchant in ctx. The percentiles are
+// interpolated within buckets, so they are estimates.
+func (v *VerifyLatency) Summary(ctx context.Context) SLAMetric {
+	if v == nil {
+		return SLAMetric{}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	histogram, ok := v.tenants[latencyTenantKey(ctx)]
+	if !ok || histogram.count == 0 {
+		return SLAMetric{}
+	}
+	return SLAMetric{
+		Count: histogram.count,
+		Avg:   roundMillis(histogram.sum / float64(histogram.count)),
+		P50:   roundMillis(histogram.quantile(0.5)),
+		P95:   roundMillis(histogram.quantile(0.95)),
+		Max:   roundMillis(histogram.max),
+	}
+}
+
+// quantile estimates the q quantile by interpolating linearly within the bucket it falls
+// in. The open bucket above every bound ends at the largest sample.
+func (h *latencyHistogram) quantile(q float64) float64 {
+	rank := q * float64(h.count)
+	cumulative := 0
+	for i, n := range h.counts {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = verifyLatencyBuckets[i-1]
+		}
+		upper := h.max
+		if i < len(verifyLatencyBuckets) {
+			upper = math.Min(verifyLatencyBuckets[i], h.max)
+		}
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(n)
+	}
+	return h.max
+}
+
+// latencyTenantKey tells the merchants of ctx apart, the empty key being single-merchant mode
+func latencyTenantKey(ctx context.Context) string {
+	if tenantID := TenantIDFromContext(ctx); tenantID != nil {
+		return tenantID.String()
+	}
+	return ""
+}
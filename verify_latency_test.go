@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyLatencySummarizesEachMerchant(t *testing.T) {
+	latency := NewVerifyLatency()
+	merchantID, otherID := uuid.New(), uuid.New()
+	merchant := WithTenantID(context.Background(), &merchantID)
+	other := WithTenantID(context.Background(), &otherID)
+
+	for _, duration := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond, 12 * time.Second} {
+		latency.Observe(merchant, duration)
+	}
+	latency.Observe(other, time.Second)
+
+	assert.Equal(t, SLAMetric{Count: 4, Avg: 3.035, P50: 0.05, P95: 11.6, Max: 12}, latency.Summary(merchant))
+	assert.Equal(t, SLAMetric{Count: 1, Avg: 1, P50: 0.75, P95: 0.975, Max: 1}, latency.Summary(other))
+	assert.Equal(t, SLAMetric{}, latency.Summary(context.Background()))
+
+	var none *VerifyLatency
+	none.Observe(merchant, time.Second)
+	assert.Equal(t, SLAMetric{}, none.Summary(merchant))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// VerifyThrottle limits how often verifying one order asks the gateway, so a checkout
+// tab polling a stuck order cannot use up the Cashfree quota. Verifications in between
+// are answered from the database.
+type VerifyThrottle struct {
+	limiter *RateLimiter
+}
+
+// NewVerifyThrottle creates a throttle letting each order ask the gateway once per
+// interval, or nil, which throttles nothing, for a non-positive interval
+func NewVerifyThrottle(interval time.Duration) *VerifyThrottle {
+	if interval <= 0 {
+		return nil
+	}
+	return &VerifyThrottle{limiter: NewRateLimiter(1/interval.Seconds(), 1)}
+}
+
+// Allow reports whether verifying orderID may ask the gateway at now. Orders are told
+// apart per merchant.
+func (t *VerifyThrottle) Allow(ctx context.Context, orderID string, now time.Time) bool {
+	if t == nil {
+		return true
+	}
+
+	key := orderID
+	if merchant := MerchantFromContext(ctx); merchant != nil {
+		key = merchant.ID.String() + "/" + orderID
+	}
+	allowed, _ := t.limiter.Allow(key, now)
+	return allowed
+}
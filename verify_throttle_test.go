@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"payment-getway/domain"
+)
+
+func TestVerifyThrottle(t *testing.T) {
+	throttle := NewVerifyThrottle(3 * time.Second)
+	ctx := context.Background()
+	now := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.True(t, throttle.Allow(ctx, "order_1", now))
+	assert.False(t, throttle.Allow(ctx, "order_1", now.Add(time.Second)))
+	assert.True(t, throttle.Allow(ctx, "order_2", now.Add(time.Second)))
+	assert.True(t, throttle.Allow(ctx, "order_1", now.Add(3*time.Second)))
+
+	// Merchants' orders of the same ID are throttled apart
+	merchant := WithMerchant(ctx, &Merchant{ID: uuid.New()})
+	assert.True(t, throttle.Allow(merchant, "order_1", now.Add(3*time.Second)))
+
+	assert.Nil(t, NewVerifyThrottle(0))
+	var none *VerifyThrottle
+	assert.True(t, none.Allow(ctx, "order_1", now))
+}
+
+func TestVerifyPaymentThrottled(t *testing.T) {
+	db := testDB(t)
+	gateway, client := newFakeCashfree(t)
+	ctx := context.Background()
+
+	repo := NewPaymentRepository(db)
+	handler := &PaymentHandler{
+		cashfree:       client,
+		repo:           repo,
+		gateways:       NewGatewayRouter(client, nil, false),
+		environments:   map[string]*CashfreeClient{EnvironmentTest: client},
+		verifyThrottle: NewVerifyThrottle(time.Minute),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	registerPaymentRoutes(r.Group(""), handler, &ExportHandler{repo: repo}, nil)
+	verify := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/verify", bytes.NewBufferString(`{"order_id": "order_1"}`)))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return w
+	}
+
+	_, err := client.CreateOrder(testOrderRequest("order_1"))
+	require.NoError(t, err)
+	payment := testPayment("order_1")
+	payment.Amount = 499.5
+	require.NoError(t, repo.CreatePayment(ctx, payment))
+
+	w := verify()
+	assert.Empty(t, w.Header().Get("X-Verify-Throttled"))
+	stored, err := repo.GetPaymentByOrderID(ctx, "order_1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.PaymentActive, stored.Status)
+
+	// Paid at Cashfree, but the order was just verified, so the stored status is served
+	_, err = gateway.CompletePayment("order_1", "upi")
+	require.NoError(t, err)
+	w = verify()
+	assert.Equal(t, "true", w.Header().Get("X-Verify-Throttled"))
+	assert.Contains(t, w.Body.String(), `"order_status":"ACTIVE"`)
+}
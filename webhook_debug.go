@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// signaturePrefixLength is how much of an expected signature is shown, enough to tell
+// signatures apart by eye without handing out a usable one
+const signaturePrefixLength = 8
+
+// SignatureCandidate is a configured secret a webhook may be signed with and whether it
+// produces the webhook's signature. The secret itself is never returned.
+type SignatureCandidate struct {
+	Source       string     `json:"source"` // "deployment" or "merchant"
+	Environment  string     `json:"environment"`
+	ClientID     string     `json:"client_id"`
+	MerchantID   *uuid.UUID `json:"merchant_id,omitempty"`
+	MerchantName string     `json:"merchant_name,omitempty"`
+
+	// Secret is "client_secret", or "webhook_secret_<n>" for a merchant's nth active
+	// webhook secret, newest first
+	Secret         string `json:"secret"`
+	ExpectedPrefix string `json:"expected_prefix"`
+	Matches        bool   `json:"matches"`
+}
+
+// signatureCandidates returns every secret a webhook may be checked against, with the
+// signature each produces and whether it is the webhook's: the deployment's Cashfree
+// clients, then each active merchant's webhook secrets, or its client secret when it
+// has none. With a merchant, only that merchant's secrets are returned, as for its
+// webhook URL.
+func (h *PaymentHandler) signatureCandidates(ctx context.Context, merchantID *uuid.UUID, signature, timestamp, payload string) ([]SignatureCandidate, error) {
+	candidates := []SignatureCandidate{}
+	add := func(candidate SignatureCandidate, secret string) {
+		if secret == "" {
+			return
+		}
+		candidate.ExpectedPrefix = webhookSignature(secret, timestamp, payload)[:signaturePrefixLength]
+		candidate.Matches = verifyWebhookSignature(secret, signature, timestamp, payload)
+		candidates = append(candidates, candidate)
+	}
+
+	if merchantID == nil {
+		for _, client := range h.webhookClients() {
+			if client == nil {
+				continue
+			}
+			add(SignatureCandidate{Source: "deployment", Environment: client.Environment, ClientID: client.ClientID, Secret: "client_secret"}, client.ClientSecret)
+		}
+	}
+
+	if h.merchants == nil {
+		return candidates, nil
+	}
+	merchants, err := h.merchants.ListMerchants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := h.merchants.activeWebhookSecrets(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+	for _, merchant := range merchants {
+		if !merchant.Active || (merchantID != nil && merchant.ID != *merchantID) {
+			continue
+		}
+		candidate := SignatureCandidate{
+			Source:       "merchant",
+			Environment:  merchant.Environment,
+			ClientID:     merchant.CFClientID,
+			MerchantID:   &merchant.ID,
+			MerchantName: merchant.Name,
+			Secret:       "client_secret",
+		}
+		if configured := secrets[merchant.ID]; len(configured) > 0 {
+			for i, secret := range configured {
+				candidate.Secret = fmt.Sprintf("webhook_secret_%d", i+1)
+				add(candidate, secret)
+			}
+			continue
+		}
+		add(candidate, merchant.CFSecret)
+	}
+	return candidates, nil
+}
+
+// Checks a webhook's signature against every configured secret and reports which one,
+// if any, produces it. The raw payload is sent as the body with the
+// x-webhook-signature and x-webhook-timestamp headers Cashfree sent, so a captured
+// webhook can be replayed as is. Nothing is applied or stored.
+func (h *PaymentHandler) DebugWebhookSignature(c *gin.Context) {
+	signature := c.GetHeader("x-webhook-signature")
+	timestamp := c.GetHeader("x-webhook-timestamp")
+	if signature == "" || timestamp == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing webhook headers"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	var merchantID *uuid.UUID
+	if value := c.Query("merchant_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "merchant_id must be a UUID"})
+			return
+		}
+		merchantID = &id
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	candidates, err := h.signatureCandidates(ctx, merchantID, signature, timestamp, string(body))
+	if err != nil {
+		log.Printf("Failed to load webhook secrets: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load webhook secrets"})
+		return
+	}
+
+	var matched *SignatureCandidate
+	for i := range candidates {
+		if candidates[i].Matches && matched == nil {
+			matched = &candidates[i]
+		}
+	}
+
+	resp := gin.H{
+		"matched":    matched != nil,
+		"match":      matched,
+		"candidates": candidates,
+	}
+	if matched == nil {
+		resp["hint"] = "No configured secret produces this signature. Check that the body is the raw payload byte for byte, the timestamp is the one sent with it, and the webhook came from the environment it was sent to."
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDebugWebhookSignature(t *testing.T) {
+	test := NewCashfreeClient("test_id", "test_secret", EnvironmentTest)
+	prod := NewCashfreeClient("prod_id", "prod_secret", EnvironmentProd)
+	handler := &PaymentHandler{
+		cashfree:     test,
+		environments: map[string]*CashfreeClient{EnvironmentTest: test, EnvironmentProd: prod},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/admin/webhooks/signature-check", handler.DebugWebhookSignature)
+
+	payload := `{"type":"PAYMENT_SUCCESS_WEBHOOK","data":{"order":{"order_id":"order_1"}}}`
+	var body string
+	check := func(signature string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/webhooks/signature-check", bytes.NewBufferString(payload))
+		req.Header.Set("x-webhook-timestamp", "1712000000")
+		if signature != "" {
+			req.Header.Set("x-webhook-signature", signature)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		body = w.Body.String()
+
+		var resp map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	// A webhook signed with the PROD secret is found even though TEST is the default
+	code, resp := check(webhookSignature("prod_secret", "1712000000", payload))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, resp["matched"])
+	match := resp["match"].(map[string]interface{})
+	assert.Equal(t, EnvironmentProd, match["environment"])
+	assert.Equal(t, "prod_id", match["client_id"])
+	assert.Equal(t, "client_secret", match["secret"])
+
+	candidates := resp["candidates"].([]interface{})
+	require.Len(t, candidates, 2)
+	first := candidates[0].(map[string]interface{})
+	assert.Equal(t, EnvironmentTest, first["environment"])
+	assert.Equal(t, false, first["matches"])
+	assert.Equal(t, webhookSignature("test_secret", "1712000000", payload)[:signaturePrefixLength], first["expected_prefix"])
+	assert.NotContains(t, body, "prod_secret")
+
+	code, resp = check(webhookSignature("other_secret", "1712000000", payload))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, false, resp["matched"])
+	assert.Nil(t, resp["match"])
+	assert.NotEmpty(t, resp["hint"])
+
+	code, _ = check("")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
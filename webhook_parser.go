@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"payment-getway/domain"
+	"payment-getway/events"
+)
+
+// Cashfree webhook data comes in two shapes. Older API versions put the fields at the top
+// of "data"; since 2023-08-01 they are nested under "order", "payment", "refund" and so
+// on, IDs may be numbers and the payment method is an object keyed by the method. The
+// parsers below accept both, so a field is never dropped for having moved.
+
+// PaymentWebhook is the payment attempt a PAYMENT_SUCCESS_WEBHOOK or
+// PAYMENT_FAILED_WEBHOOK reports
+type PaymentWebhook struct {
+	OrderID       string     `json:"order_id"`
+	CFPaymentID   string     `json:"cf_payment_id,omitempty"`
+	PaymentStatus string     `json:"payment_status,omitempty"`
+	PaymentAmount float64    `json:"payment_amount,omitempty"`
+	PaymentMethod string     `json:"payment_method,omitempty"`
+	PaymentTime   *time.Time `json:"payment_time,omitempty"`
+
+	Charges         *CashfreePaymentCharges `json:"payment_charges,omitempty"`
+	EMI             *EMIDetails             `json:"emi_details,omitempty"`
+	MethodDetails   *PaymentMethodDetails   `json:"payment_method_details,omitempty"`
+	CardFingerprint string                  `json:"card_fingerprint,omitempty"`
+}
+
+// RefundWebhook is the refund a REFUND_STATUS_WEBHOOK or AUTO_REFUND_STATUS_WEBHOOK
+// reports
+type RefundWebhook struct {
+	RefundID     string              `json:"refund_id"`
+	CFRefundID   string              `json:"cf_refund_id,omitempty"`
+	OrderID      string              `json:"order_id,omitempty"`
+	RefundStatus domain.RefundStatus `json:"refund_status,omitempty"`
+	RefundAmount float64             `json:"refund_amount,omitempty"`
+	RefundMode   string              `json:"refund_mode,omitempty"`
+	RefundARN    string              `json:"refund_arn,omitempty"`
+	RefundType   string              `json:"refund_type,omitempty"`
+	RefundNote   string              `json:"refund_note,omitempty"`
+	ProcessedAt  *time.Time          `json:"processed_at,omitempty"`
+}
+
+// parsePaymentWebhook reads the payment attempt from a payment webhook's data
+func parsePaymentWebhook(data map[string]interface{}) (PaymentWebhook, error) {
+	order := webhookObject(data, "order")
+	payment := webhookObject(data, "payment")
+
+	parsed := PaymentWebhook{
+		OrderID:       webhookString(order["order_id"]),
+		CFPaymentID:   webhookString(payment["cf_payment_id"]),
+		PaymentStatus: webhookString(payment["payment_status"]),
+		PaymentAmount: webhookFloat(payment["payment_amount"]),
+		PaymentMethod: webhookPaymentMethod(payment),
+		PaymentTime:   webhookTime(payment["payment_time"]),
+		Charges:       webhookPaymentCharges(data, payment),
+		EMI:           webhookPaymentEMI(payment),
+		MethodDetails: webhookPaymentMethodDetails(payment),
+
+		CardFingerprint: webhookCardFingerprint(payment),
+	}
+	if parsed.OrderID == "" {
+		return parsed, errors.New("missing order_id")
+	}
+	return parsed, nil
+}
+
+// parseRefundWebhook reads the refund from a refund webhook's data. Auto-refunds are
+// nested under "auto_refund" and may have no refund_id of their own, in which case the
+// Cashfree refund ID stands in for it.
+func parseRefundWebhook(data map[string]interface{}) (RefundWebhook, error) {
+	refund := webhookObject(data, "refund")
+	if autoRefund, ok := data["auto_refund"].(map[string]interface{}); ok {
+		refund = autoRefund
+	}
+
+	parsed := RefundWebhook{
+		RefundID:     webhookString(refund["refund_id"]),
+		CFRefundID:   webhookString(refund["cf_refund_id"]),
+		OrderID:      webhookString(refund["order_id"]),
+		RefundStatus: domain.RefundStatus(webhookString(refund["refund_status"])),
+		RefundAmount: webhookFloat(refund["refund_amount"]),
+		RefundMode:   webhookString(refund["refund_mode"]),
+		RefundARN:    webhookString(refund["refund_arn"]),
+		RefundType:   webhookString(refund["refund_type"]),
+		RefundNote:   webhookString(refund["refund_note"]),
+		ProcessedAt:  webhookTime(refund["processed_at"]),
+	}
+	if parsed.RefundID == "" {
+		parsed.RefundID = parsed.CFRefundID
+	}
+	if parsed.RefundID == "" {
+		return parsed, errors.New("missing refund_id")
+	}
+	return parsed, nil
+}
+
+// parseSettlementWebhook reads the settlement from a settlement webhook's data
+func parseSettlementWebhook(data map[string]interface{}) events.SettlementPayload {
+	settlement := webhookObject(data, "settlement")
+
+	status := webhookString(settlement["settlement_status"])
+	if status == "" {
+		status = webhookString(settlement["status"])
+	}
+	return events.SettlementPayload{
+		SettlementID:   webhookString(settlement["settlement_id"]),
+		CFSettlementID: webhookString(settlement["cf_settlement_id"]),
+		OrderID:        webhookString(settlement["order_id"]),
+		Amount:         webhookFloat(settlement["settlement_amount"]),
+		Status:         status,
+		UTR:            webhookString(settlement["utr"]),
+	}
+}
+
+// parseDisputeWebhook reads the dispute from a dispute webhook's data, where it has
+// always been nested under "dispute" and "order_details"
+func parseDisputeWebhook(data map[string]interface{}) (events.DisputePayload, error) {
+	dispute, _ := data["dispute"].(map[string]interface{})
+	orderDetails, _ := data["order_details"].(map[string]interface{})
+
+	parsed := events.DisputePayload{
+		DisputeID:   webhookString(dispute["dispute_id"]),
+		OrderID:     webhookString(orderDetails["order_id"]),
+		Amount:      webhookFloat(dispute["dispute_amount"]),
+		Status:      webhookString(dispute["dispute_status"]),
+		DisputeType: webhookString(dispute["dispute_type"]),
+		ReasonCode:  webhookString(dispute["reason_code"]),
+	}
+	if parsed.DisputeID == "" {
+		return parsed, errors.New("missing dispute_id")
+	}
+	return parsed, nil
+}
+
+// webhookOrderID returns the order a webhook is about, wherever its shape puts it
+func webhookOrderID(data map[string]interface{}) string {
+	for _, key := range []string{"order", "refund", "auto_refund", "settlement", "order_details"} {
+		if nested, ok := data[key].(map[string]interface{}); ok {
+			if orderID := webhookString(nested["order_id"]); orderID != "" {
+				return orderID
+			}
+		}
+	}
+	return webhookString(data["order_id"])
+}
+
+// webhookObject returns the object nested under key, or data itself in the flat shape
+func webhookObject(data map[string]interface{}, key string) map[string]interface{} {
+	if nested, ok := data[key].(map[string]interface{}); ok {
+		return nested
+	}
+	return data
+}
+
+// webhookString reads a string or numeric ID
+func webhookString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// webhookFloat reads an amount sent as a number or a numeric string
+func webhookFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// webhookTime reads an RFC 3339 time, or returns nil
+func webhookTime(value interface{}) *time.Time {
+	s, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// webhookPaymentMethod reads the payment method, a name such as "upi" in the flat shape
+// and an object keyed by the method ({"upi": {...}}) in the nested one
+func webhookPaymentMethod(payment map[string]interface{}) string {
+	switch method := payment["payment_method"].(type) {
+	case string:
+		return method
+	case map[string]interface{}:
+		names := make([]string, 0, len(method))
+		for name := range method {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			return names[0]
+		}
+	}
+	return webhookString(payment["payment_group"])
+}
+
+// webhookCardFingerprint reads the fingerprint of the card a payment was made with,
+// under the card or card EMI method, or returns ""
+func webhookCardFingerprint(payment map[string]interface{}) string {
+	method, ok := payment["payment_method"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	for _, name := range []string{"card", "emi"} {
+		if card, ok := method[name].(map[string]interface{}); ok {
+			if fingerprint := webhookString(card["card_fingerprint"]); fingerprint != "" {
+				return fingerprint
+			}
+		}
+	}
+	return ""
+}
+
+// webhookPaymentCharges reads the charges on a payment, sent under the payment as
+// "payment_charges" or alongside it as "charges_details", or returns nil
+func webhookPaymentCharges(data, payment map[string]interface{}) *CashfreePaymentCharges {
+	charges, ok := payment["payment_charges"].(map[string]interface{})
+	if !ok {
+		if charges, ok = data["charges_details"].(map[string]interface{}); !ok {
+			return nil
+		}
+	}
+	return &CashfreePaymentCharges{
+		ServiceCharge:    webhookFloat(charges["service_charge"]),
+		ServiceTax:       webhookFloat(charges["service_tax"]),
+		SettlementAmount: webhookFloat(charges["settlement_amount"]),
+	}
+}
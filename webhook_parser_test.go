@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// parsedWebhook is what the dispatcher reads from a webhook, as recorded in the golden
+// files
+type parsedWebhook struct {
+	Type    string      `json:"type"`
+	OrderID string      `json:"order_id"` // order the webhook is logged against
+	Handled bool        `json:"handled"`
+	Parsed  interface{} `json:"parsed,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// parseWebhookForGolden parses a webhook with the parser dispatchWebhook uses for its type
+func parseWebhookForGolden(webhookData WebhookData) parsedWebhook {
+	result := parsedWebhook{Type: webhookData.Type, OrderID: webhookOrderID(webhookData.Data), Handled: true}
+
+	var err error
+	switch webhookData.Type {
+	case "PAYMENT_SUCCESS_WEBHOOK", "PAYMENT_FAILED_WEBHOOK", "PAYMENT_CHARGES_WEBHOOK":
+		result.Parsed, err = parsePaymentWebhook(webhookData.Data)
+	case "REFUND_STATUS_WEBHOOK", "AUTO_REFUND_STATUS_WEBHOOK":
+		result.Parsed, err = parseRefundWebhook(webhookData.Data)
+	case "SETTLEMENT_STATUS_WEBHOOK":
+		result.Parsed = parseSettlementWebhook(webhookData.Data)
+	case "DISPUTE_CREATED":
+		result.Parsed, err = parseDisputeWebhook(webhookData.Data)
+	default:
+		result.Handled = false
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// TestWebhookGolden parses every webhook payload in testdata/webhooks and compares the
+// result with its .golden file. Run with -update after an intended change.
+func TestWebhookGolden(t *testing.T) {
+	payloads, err := filepath.Glob(filepath.Join("testdata", "webhooks", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, payloads)
+
+	for _, path := range payloads {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			// Decoded as HandleWebhook decodes it
+			var webhookData WebhookData
+			require.NoError(t, json.Unmarshal(body, &webhookData))
+
+			got, err := json.MarshalIndent(parseWebhookForGolden(webhookData), "", "  ")
+			require.NoError(t, err)
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			if *updateGolden {
+				require.NoError(t, os.WriteFile(golden, got, 0o644))
+				return
+			}
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err, "missing golden file; run go test -run TestWebhookGolden -update")
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+func TestParsePaymentWebhookErrors(t *testing.T) {
+	_, err := parsePaymentWebhook(map[string]interface{}{"payment": map[string]interface{}{"cf_payment_id": 1}})
+	assert.EqualError(t, err, "missing order_id")
+
+	_, err = parseRefundWebhook(map[string]interface{}{"refund": map[string]interface{}{"order_id": "order_1"}})
+	assert.EqualError(t, err, "missing refund_id")
+
+	_, err = parseDisputeWebhook(map[string]interface{}{})
+	assert.EqualError(t, err, "missing dispute_id")
+}
+
+func TestWebhookValues(t *testing.T) {
+	assert.Equal(t, "1453002795", webhookString(1453002795.0))
+	assert.Equal(t, "1453002795", webhookString(json.Number("1453002795")))
+	assert.Equal(t, "", webhookString(nil))
+
+	assert.Equal(t, 2.5, webhookFloat("2.50"))
+	assert.Equal(t, 2.5, webhookFloat(json.Number("2.5")))
+	assert.Nil(t, webhookTime("15/09/2023"))
+
+	assert.Equal(t, "upi", webhookPaymentMethod(map[string]interface{}{"payment_method": "upi"}))
+	assert.Equal(t, "card", webhookPaymentMethod(map[string]interface{}{"payment_method": map[string]interface{}{"card": map[string]interface{}{}}}))
+	assert.Equal(t, "credit_card", webhookPaymentMethod(map[string]interface{}{"payment_group": "credit_card"}))
+	assert.Empty(t, webhookPaymentMethod(map[string]interface{}{}))
+}